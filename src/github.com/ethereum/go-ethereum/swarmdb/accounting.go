@@ -0,0 +1,450 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Storage is paid for with bids: an owner bids a price per chunk replica, and every row chunk written for the owner
+// debits bid × MinReplication, of the table (see replication.go) or else of the writer, from the owner's balance
+// into escrow for that chunk.  A farmer earns one bid from the escrow of a chunk once it proves custody of the chunk
+// (see Audit); the escrow is released when every replica is paid.  Owners fund their balance with deposits, e.g. cashed checks, recorded by an admin with ADMIN_DEPOSIT.
+// The ledger of accounts and escrow is saved to LEDGER_FILE in the chunk store directory every LEDGER_AUDIT_INTERVAL,
+// on Close, and at once after bids and deposits.
+const (
+	LEDGER_FILE           = "ledger.json"
+	LEDGER_AUDIT_INTERVAL = 60 * time.Second
+
+	ACCOUNTING_OFF     = 0 // nothing is debited
+	ACCOUNTING_METER   = 1 // owners are debited, balances may go negative
+	ACCOUNTING_ENFORCE = 2 // writes the owner cannot pay for are refused
+
+	RT_BALANCE = "Balance" // answered with the Account row of Owner
+	RT_SET_BID = "SetBid"  // Rows[0] {"bid"} sets the price Owner pays per chunk replica, 0 pays config.DefaultBid
+
+	ADMIN_DEPOSIT  = "Deposit"  // Rows[0] {"address", "amount"} credits a payment received
+	ADMIN_BALANCES = "Balances" // optional Rows[0] {"address"}; answered with an Account row per address
+	ADMIN_AUDIT    = "Audit"    // optional Rows[0] {"limit"}; proves custody of the escrowed chunks stored here
+)
+
+type Account struct {
+	Address   string `json:"address"`
+	Bid       int64  `json:"bid,omitempty"` // price per chunk replica, 0 pays the default bid
+	Balance   int64  `json:"balance"`
+	Deposited int64  `json:"deposited"`
+	Debited   int64  `json:"debited"` // paid into escrow for chunks written
+	Earned    int64  `json:"earned"`  // paid out of escrow for chunks in custody
+	UpdatedMs int64  `json:"updatedMs"`
+}
+
+// escrow holds the payment of one chunk until its replicas are in custody
+type escrow struct {
+	Owner    string   `json:"owner"`
+	Bid      int64    `json:"bid"`
+	Replicas int      `json:"replicas"`
+	Farmers  []string `json:"farmers,omitempty"` // paid so far, one bid each
+}
+
+type ledgerFile struct {
+	Accounts []*Account         `json:"accounts"`
+	Escrow   map[string]*escrow `json:"escrow"` // by chunk key (hex)
+}
+
+type Ledger struct {
+	mu         sync.Mutex
+	path       string
+	mode       int
+	defaultBid int64
+	accounts   map[string]*Account
+	escrow     map[string]*escrow
+	dirty      bool // changed since the last save
+}
+
+// NewLedger returns a ledger saving to path, starting from the ledger saved there if any; mode is one of the
+// ACCOUNTING_* modes and defaultBid the price per chunk replica of owners without a bid, SWARMDBCONF_DEFAULT_BID if 0
+func NewLedger(path string, mode int, defaultBid int64) (l *Ledger, err error) {
+	if defaultBid <= 0 {
+		defaultBid = SWARMDBCONF_DEFAULT_BID
+	}
+	l = &Ledger{path: path, mode: mode, defaultBid: defaultBid, accounts: make(map[string]*Account), escrow: make(map[string]*escrow)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[accounting:NewLedger] ReadFile %s", err.Error()), ErrorCode: 461, ErrorMessage: "Unable to Load Ledger"}
+	}
+	var f ledgerFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[accounting:NewLedger] Unmarshal %s", err.Error()), ErrorCode: 461, ErrorMessage: "Unable to Load Ledger"}
+	}
+	for _, a := range f.Accounts {
+		l.accounts[a.Address] = a
+	}
+	if f.Escrow != nil {
+		l.escrow = f.Escrow
+	}
+	return l, nil
+}
+
+// account returns the account of address, opening it if needed; l.mu must be held
+func (l *Ledger) account(address string) *Account {
+	a, ok := l.accounts[address]
+	if !ok {
+		a = &Account{Address: address}
+		l.accounts[address] = a
+	}
+	a.UpdatedMs = nowMs()
+	l.dirty = true
+	return a
+}
+
+// bid returns the price per chunk replica a pays
+func (l *Ledger) bid(a *Account) int64 {
+	if a.Bid > 0 {
+		return a.Bid
+	}
+	return l.defaultBid
+}
+
+// row returns a as a row, with the chunk replicas its balance still pays for as "credits"
+func (l *Ledger) row(a *Account) (row sdbc.Row) {
+	row = sdbc.NewRow()
+	row["address"] = a.Address
+	row["bid"] = l.bid(a)
+	row["balance"] = a.Balance
+	row["deposited"] = a.Deposited
+	row["debited"] = a.Debited
+	row["earned"] = a.Earned
+	row["updatedMs"] = a.UpdatedMs
+	credits := int64(0)
+	if bid := l.bid(a); bid > 0 && a.Balance > 0 {
+		credits = a.Balance / bid
+	}
+	row["credits"] = credits
+	return row
+}
+
+// SetBid sets the price per chunk replica owner pays for the chunks it writes from now on
+func (l *Ledger) SetBid(owner string, bid int64) (err error) {
+	if len(owner) == 0 || bid < 0 {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[accounting:SetBid] owner [%s] bid %d", owner, bid), ErrorCode: 418, ErrorMessage: "Request Invalid: a bid needs an owner and may not be negative"}
+	}
+	l.mu.Lock()
+	l.account(owner).Bid = bid
+	l.mu.Unlock()
+	return l.Save()
+}
+
+// Deposit credits amount to the balance of address
+func (l *Ledger) Deposit(address string, amount int64) (row sdbc.Row, err error) {
+	if len(address) == 0 || amount <= 0 {
+		return row, &sdbc.SWARMDBError{Message: fmt.Sprintf("[accounting:Deposit] address [%s] amount %d", address, amount), ErrorCode: 418, ErrorMessage: "Request Invalid: a deposit needs an address and a positive amount"}
+	}
+	l.mu.Lock()
+	a := l.account(address)
+	a.Balance += amount
+	a.Deposited += amount
+	row = l.row(a)
+	l.mu.Unlock()
+	return row, l.Save()
+}
+
+// charge debits owner for storing replicas replicas of the chunk key, holding the payment in escrow; a nil ledger or
+// one with accounting off charges nothing, and a chunk already in escrow is not charged again
+func (l *Ledger) charge(owner string, key []byte, replicas int) (err error) {
+	if l == nil || l.mode == ACCOUNTING_OFF || len(owner) == 0 {
+		return nil
+	}
+	if replicas < 1 {
+		replicas = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	chunkKey := hex.EncodeToString(key)
+	if _, ok := l.escrow[chunkKey]; ok {
+		return nil
+	}
+	a := l.account(owner)
+	bid := l.bid(a)
+	cost := bid * int64(replicas)
+	if l.mode == ACCOUNTING_ENFORCE && a.Balance < cost {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[accounting:charge] %s has %d, chunk %s costs %d", owner, a.Balance, chunkKey, cost), ErrorCode: 507, ErrorMessage: fmt.Sprintf("Payment Required: balance of %s is below the cost of the write", owner)}
+	}
+	a.Balance -= cost
+	a.Debited += cost
+	l.escrow[chunkKey] = &escrow{Owner: owner, Bid: bid, Replicas: replicas}
+	return nil
+}
+
+// refund returns the escrow of the chunk key to its owner, when storing the chunk failed after charge
+func (l *Ledger) refund(key []byte) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	chunkKey := hex.EncodeToString(key)
+	e, ok := l.escrow[chunkKey]
+	if !ok {
+		return
+	}
+	cost := e.Bid * int64(e.Replicas-len(e.Farmers))
+	a := l.account(e.Owner)
+	a.Balance += cost
+	a.Debited -= cost
+	delete(l.escrow, chunkKey)
+}
+
+// Credit pays farmer one bid from the escrow of the chunk key, once farmer proved custody of it; a farmer is paid
+// once per chunk, so a repeated credit pays 0
+func (l *Ledger) Credit(farmer string, key []byte) (amount int64, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	chunkKey := hex.EncodeToString(key)
+	e, ok := l.escrow[chunkKey]
+	if !ok {
+		return 0, &sdbc.SWARMDBError{Message: fmt.Sprintf("[accounting:Credit] chunk %s not in escrow", chunkKey), ErrorCode: 498, ErrorMessage: "Chunk Not In Escrow"}
+	}
+	for _, paid := range e.Farmers {
+		if paid == farmer {
+			return 0, nil
+		}
+	}
+	a := l.account(farmer)
+	a.Balance += e.Bid
+	a.Earned += e.Bid
+	e.Farmers = append(e.Farmers, farmer)
+	if len(e.Farmers) >= e.Replicas {
+		delete(l.escrow, chunkKey)
+	}
+	return e.Bid, nil
+}
+
+// pending returns up to limit (all if 0) keys of chunks in escrow that farmer was not paid for, in key order
+func (l *Ledger) pending(farmer string, limit int) (keys [][]byte) {
+	l.mu.Lock()
+	chunkKeys := make([]string, 0, len(l.escrow))
+	for chunkKey, e := range l.escrow {
+		paid := false
+		for _, f := range e.Farmers {
+			paid = paid || f == farmer
+		}
+		if !paid {
+			chunkKeys = append(chunkKeys, chunkKey)
+		}
+	}
+	l.mu.Unlock()
+	sort.Strings(chunkKeys)
+	for _, chunkKey := range chunkKeys {
+		if limit > 0 && len(keys) == limit {
+			break
+		}
+		key, _ := hex.DecodeString(chunkKey)
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Balances returns the account of address, or of every address sorted by address when address is empty, as rows
+func (l *Ledger) Balances(address string) (rows []sdbc.Row) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	addresses := make([]string, 0, len(l.accounts))
+	for a := range l.accounts {
+		if len(address) == 0 || a == address {
+			addresses = append(addresses, a)
+		}
+	}
+	sort.Strings(addresses)
+	for _, a := range addresses {
+		rows = append(rows, l.row(l.accounts[a]))
+	}
+	if len(address) > 0 && len(rows) == 0 {
+		rows = append(rows, l.row(&Account{Address: address}))
+	}
+	return rows
+}
+
+// Save writes the ledger to its file, unless nothing changed since the last save
+func (l *Ledger) Save() (err error) {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.dirty {
+		return nil
+	}
+	f := ledgerFile{Accounts: make([]*Account, 0, len(l.accounts)), Escrow: l.escrow}
+	for _, a := range l.accounts {
+		f.Accounts = append(f.Accounts, a)
+	}
+	sort.Slice(f.Accounts, func(i, j int) bool { return f.Accounts[i].Address < f.Accounts[j].Address })
+	data, err := json.MarshalIndent(f, "", " ")
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[accounting:Save] MarshalIndent %s", err.Error()), ErrorCode: 461, ErrorMessage: "Unable to Save Ledger"}
+	}
+	// replace the file whole, so a crash never leaves half of it
+	tmp := l.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0600); err == nil {
+		err = os.Rename(tmp, l.path)
+	}
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[accounting:Save] %s", err.Error()), ErrorCode: 461, ErrorMessage: "Unable to Save Ledger"}
+	}
+	l.dirty = false
+	return nil
+}
+
+// Ledger returns the storage accounts and escrow of self
+func (self *SwarmDB) Ledger() *Ledger {
+	return self.ledger
+}
+
+// Audit challenges the chunk store for custody of up to limit (all if 0) escrowed chunks this node was not paid for
+// yet, crediting the farmer Address of the node for each proof.  Chunks that fail the challenge stay in escrow.
+func (self *SwarmDB) Audit(limit int) (audited int, failed int, earned int64, err error) {
+	farmer := self.dbchunkstore.farmer.Hex()
+	for _, key := range self.ledger.pending(farmer, limit) {
+		secret := make([]byte, 32)
+		if _, err = rand.Read(secret); err != nil {
+			return audited, failed, earned, &sdbc.SWARMDBError{Message: fmt.Sprintf("[accounting:Audit] rand %s", err.Error()), ErrorCode: 471, ErrorMessage: "RetrieveAsh Error"}
+		}
+		audited++
+		// challenge one of the 128 segments of the chunk
+		if _, err = self.dbchunkstore.RetrieveAsh(key, secret, true, int8(secret[0]%128)); err != nil {
+			log.Debug(fmt.Sprintf("[accounting:Audit] chunk %x %s", key, err.Error()))
+			failed++
+			continue
+		}
+		amount, cerr := self.ledger.Credit(farmer, key)
+		if cerr != nil {
+			return audited, failed, earned, sdbc.GenerateSWARMDBError(cerr, fmt.Sprintf("[accounting:Audit] Credit %s", cerr.Error()))
+		}
+		earned += amount
+	}
+	return audited, failed, earned, nil
+}
+
+// StartAuditor audits custody (see Audit) and saves the ledger every interval (LEDGER_AUDIT_INTERVAL if 0) until the
+// returned stop func is called
+func (self *SwarmDB) StartAuditor(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = LEDGER_AUDIT_INTERVAL
+	}
+	quit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				if self.ledger.mode != ACCOUNTING_OFF {
+					if _, _, _, err := self.Audit(0); err != nil {
+						log.Debug(fmt.Sprintf("[accounting:StartAuditor] %s", err.Error()))
+					}
+				}
+				if err := self.ledger.Save(); err != nil {
+					log.Debug(fmt.Sprintf("[accounting:StartAuditor] %s", err.Error()))
+				}
+			}
+		}
+	}()
+	return func() { close(quit) }
+}
+
+// balance answers RT_BALANCE and RT_SET_BID for the account of d.Owner
+func (self *SwarmDB) balance(d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	if d.RequestType == RT_SET_BID {
+		var bid float64
+		ok := len(d.Rows) == 1
+		if ok {
+			bid, ok = toFloat(d.Rows[0]["bid"])
+		}
+		if !ok {
+			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[accounting:balance] bid %v", d.Rows), ErrorCode: 418, ErrorMessage: "Request Invalid: SetBid needs Rows[0] {\"bid\": number}"}
+		}
+		if err = self.ledger.SetBid(d.Owner, int64(bid)); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[accounting:balance] SetBid %s", err.Error()))
+		}
+		resp.AffectedRowCount = 1
+	}
+	resp.Data = self.ledger.Balances(d.Owner)
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
+
+// deposit answers ADMIN_DEPOSIT
+func (self *SwarmDB) deposit(d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	var address string
+	var amount float64
+	if len(d.Rows) == 1 {
+		address, _ = d.Rows[0]["address"].(string)
+		amount, _ = toFloat(d.Rows[0]["amount"])
+	}
+	row, err := self.ledger.Deposit(address, int64(amount))
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[accounting:deposit] %s", err.Error()))
+	}
+	resp.Data = []sdbc.Row{row}
+	resp.AffectedRowCount = 1
+	return resp, nil
+}
+
+// balances answers ADMIN_BALANCES
+func (self *SwarmDB) balances(d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	address := ""
+	if len(d.Rows) > 0 {
+		address, _ = d.Rows[0]["address"].(string)
+	}
+	resp.Data = self.ledger.Balances(address)
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
+
+// audit answers ADMIN_AUDIT with a row of the chunks audited and failed and the amount earned
+func (self *SwarmDB) audit(d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	limit := 0.0
+	if len(d.Rows) > 0 {
+		limit, _ = toFloat(d.Rows[0]["limit"])
+	}
+	audited, failed, earned, err := self.Audit(int(limit))
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[accounting:audit] %s", err.Error()))
+	}
+	row := sdbc.NewRow()
+	row["farmer"] = self.dbchunkstore.farmer.Hex()
+	row["audited"] = audited
+	row["failed"] = failed
+	row["earned"] = earned
+	resp.Data = []sdbc.Row{row}
+	resp.AffectedRowCount = audited - failed
+	return resp, nil
+}
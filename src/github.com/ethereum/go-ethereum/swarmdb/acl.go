@@ -0,0 +1,356 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"sort"
+	"strings"
+)
+
+// Table ACLs live in the table descriptor chunk, so every grant produces a new descriptor version:
+//
+//	[448:452]    ACL_MAGIC, [452] entry count
+//	[1024:2048]  up to 32 entries of 32 bytes: 20 byte address, 1 byte permission bits
+//
+// Both lie in the hashed front of the chunk (see hashChunkSize), so that every grant changes the descriptor key.
+// Descriptors written before kept the magic and count at 4024; they are still read from there.
+//
+// A table without entries is open for reading and writing rows, as tables were before ACLs existed; granting,
+// revoking, dropping it and changing its settings take the owner still.  Once an entry exists, only the owner and
+// granted addresses may access the table.  Grants on the database of the
+// table (see database.go) count as grants on the table.
+//
+// Columns may further be restricted, with the flag at COLUMN_RESTRICTED_OFFSET of the column entry: only the owner
+// and addresses holding ACL_RESTRICTED or ACL_GRANT read them, on open tables too.  Every read (checkRead) leaves
+// the restricted columns out of the rows, and out of the table description, for other callers.
+const (
+	ACL_READ       = 1
+	ACL_WRITE      = 2
+	ACL_GRANT      = 4 // may grant and revoke permissions of others
+	ACL_ALL        = ACL_READ | ACL_WRITE | ACL_GRANT
+	ACL_RESTRICTED = 8 // may read restricted columns
+
+	COLUMN_RESTRICTED_OFFSET = 27
+
+	ACL_START       = 1024
+	ACL_END         = 2048
+	ACL_ENTRY_SIZE  = 32
+	ACL_MAGIC_START = 448
+	ACL_COUNT_BYTE  = 452
+
+	LEGACY_ACL_MAGIC_START = 4024
+	LEGACY_ACL_COUNT_BYTE  = 4028
+
+	RT_GRANT       = "Grant"
+	RT_REVOKE      = "Revoke"
+	RT_LIST_GRANTS = "ListGrants"
+)
+
+var ACL_MAGIC = []byte("acl\x01")
+
+var aclPermissionNames = map[string]uint8{"read": ACL_READ, "write": ACL_WRITE, "grant": ACL_GRANT, "all": ACL_ALL, "restricted": ACL_RESTRICTED}
+
+func readACL(descriptor []byte) (acl map[common.Address]uint8) {
+	acl = make(map[common.Address]uint8)
+	var n int
+	if bytes.Equal(descriptor[ACL_MAGIC_START:ACL_COUNT_BYTE], ACL_MAGIC) {
+		n = int(descriptor[ACL_COUNT_BYTE])
+	} else if bytes.Equal(descriptor[LEGACY_ACL_MAGIC_START:LEGACY_ACL_COUNT_BYTE], ACL_MAGIC) {
+		n = int(descriptor[LEGACY_ACL_COUNT_BYTE])
+	} else {
+		return acl
+	}
+	for i := 0; i < n && ACL_START+(i+1)*ACL_ENTRY_SIZE <= ACL_END; i++ {
+		entry := descriptor[ACL_START+i*ACL_ENTRY_SIZE : ACL_START+(i+1)*ACL_ENTRY_SIZE]
+		acl[common.BytesToAddress(entry[0:20])] = entry[20]
+	}
+	return acl
+}
+
+func writeACL(descriptor []byte, acl map[common.Address]uint8) {
+	i := 0
+	for addr, perm := range acl {
+		copy(descriptor[ACL_START+i*ACL_ENTRY_SIZE:], addr.Bytes())
+		descriptor[ACL_START+i*ACL_ENTRY_SIZE+20] = perm
+		i++
+	}
+	copy(descriptor[ACL_MAGIC_START:], ACL_MAGIC)
+	descriptor[ACL_COUNT_BYTE] = byte(i)
+}
+
+func (t *Table) isOwner(u *SWARMDBUser) bool {
+	if t.swarmdb == nil {
+		return ownedBy(u, t.Owner)
+	}
+	return t.swarmdb.isOwner(u, t.Owner)
+}
+
+// grants returns the permissions u holds on the table, by the table ACL or the ACL of its database (see
+// database.go), and whether both are empty, leaving the table open
+func (t *Table) grants(u *SWARMDBUser) (perm uint8, open bool, err error) {
+	dbacl := make(map[common.Address]uint8)
+	if t.swarmdb != nil {
+		if dbacl, err = t.swarmdb.databaseACL(u, t.Owner, t.Database); err != nil {
+			return 0, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[acl:grants] databaseACL %s", err.Error()))
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if u != nil {
+		addr := common.HexToAddress(u.Address)
+		perm = t.acl[addr] | dbacl[addr]
+	}
+	return perm, len(t.acl) == 0 && len(dbacl) == 0, nil
+}
+
+// checkAccess returns an error unless u holds perm on the table; an open table needs no permission but ACL_GRANT
+func (t *Table) checkAccess(u *SWARMDBUser, perm uint8) (err error) {
+	if t.isOwner(u) {
+		return nil
+	}
+	held, open, err := t.grants(u)
+	if err != nil {
+		return err
+	}
+	if (open && perm&ACL_GRANT == 0) || held&perm == perm {
+		return nil
+	}
+	return &sdbc.SWARMDBError{Message: fmt.Sprintf("[acl:checkAccess] user [%+v] lacks permission %d on table [%s]", u, perm, t.tableName), ErrorCode: 490, ErrorMessage: fmt.Sprintf("Access Denied to Table [%s]", t.tableName)}
+}
+
+// checkRead checks that u may read the table and returns the restricted columns u may not read, which the caller
+// leaves out of what it returns (see maskRow)
+func (t *Table) checkRead(u *SWARMDBUser) (hidden map[string]bool, err error) {
+	if err = t.checkAccess(u, ACL_READ); err != nil {
+		return nil, err
+	}
+	if t.isOwner(u) {
+		return nil, nil
+	}
+	if held, _, err := t.grants(u); err != nil {
+		return nil, err
+	} else if held&(ACL_RESTRICTED|ACL_GRANT) != 0 {
+		return nil, nil
+	}
+	for name, c := range t.columns {
+		if c.restricted {
+			if hidden == nil {
+				hidden = make(map[string]bool)
+			}
+			hidden[name] = true
+		}
+	}
+	return hidden, nil
+}
+
+// maskRow returns row without the hidden columns; row itself is left as is
+func maskRow(row sdbc.Row, hidden map[string]bool) sdbc.Row {
+	if len(hidden) == 0 {
+		return row
+	}
+	masked := sdbc.NewRow()
+	for name, value := range row {
+		if !hidden[name] {
+			masked[name] = value
+		}
+	}
+	return masked
+}
+
+func maskRows(rows []sdbc.Row, hidden map[string]bool) []sdbc.Row {
+	if len(hidden) == 0 {
+		return rows
+	}
+	masked := make([]sdbc.Row, len(rows))
+	for i, row := range rows {
+		masked[i] = maskRow(row, hidden)
+	}
+	return masked
+}
+
+// RestrictedColumns returns the names of the restricted columns of the table, sorted
+func (t *Table) RestrictedColumns() (names []string) {
+	for name, c := range t.columns {
+		if c.restricted {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetRestrictedColumns restricts reading the named columns, and no others, to the owner and addresses holding
+// ACL_RESTRICTED or ACL_GRANT.  The primary key cannot be restricted.
+func (t *Table) SetRestrictedColumns(u *SWARMDBUser, names []string) (err error) {
+	if err = t.checkGrant(u); err != nil {
+		return err
+	}
+	restricted := make(map[string]bool)
+	for _, name := range names {
+		c, ok := t.columns[name]
+		if !ok {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[acl:SetRestrictedColumns] unknown column %s", name), ErrorCode: 404, ErrorMessage: fmt.Sprintf("Column Does Not Exist in table definition: [%s]", name)}
+		}
+		if c.primary > 0 {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[acl:SetRestrictedColumns] primary column %s", name), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: the primary key [%s] cannot be restricted", name)}
+		}
+		restricted[name] = true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name, c := range t.columns {
+		c.restricted = restricted[name]
+	}
+	return t.updateTableInfo(u)
+}
+
+// setRestrictedColumns runs an RT_RESTRICT_COLUMNS request: d.Rows[0] {"columns": [...]} names the columns to
+// restrict; without Rows the restricted columns are only listed
+func (self *SwarmDB) setRestrictedColumns(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[acl:setRestrictedColumns] GetTable %s", err.Error()))
+	}
+	if len(d.Rows) == 1 {
+		list, ok := d.Rows[0]["columns"].([]interface{})
+		if !ok && d.Rows[0]["columns"] != nil {
+			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[acl:setRestrictedColumns] columns %v", d.Rows[0]["columns"]), ErrorCode: 418, ErrorMessage: "Request Invalid: columns must be a list of column names"}
+		}
+		names := make([]string, 0, len(list))
+		for _, v := range list {
+			name, ok := v.(string)
+			if !ok {
+				return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[acl:setRestrictedColumns] column %v", v), ErrorCode: 418, ErrorMessage: "Request Invalid: columns must be a list of column names"}
+			}
+			names = append(names, name)
+		}
+		if err = tbl.SetRestrictedColumns(u, names); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[acl:setRestrictedColumns] SetRestrictedColumns %s", err.Error()))
+		}
+		resp.AffectedRowCount = len(names)
+	} else if _, err = tbl.checkRead(u); err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[acl:setRestrictedColumns] checkRead %s", err.Error()))
+	}
+	for _, name := range tbl.RestrictedColumns() {
+		row := sdbc.NewRow()
+		row["column"] = name
+		resp.Data = append(resp.Data, row)
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
+
+// Grant adds perm for grantee; only the owner grants on an open table
+func (t *Table) Grant(u *SWARMDBUser, grantee string, perm uint8) (err error) {
+	if err = t.checkGrant(u); err != nil {
+		return err
+	}
+	addr := common.HexToAddress(grantee)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.acl[addr]; !ok && len(t.acl) >= (ACL_END-ACL_START)/ACL_ENTRY_SIZE {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[acl:Grant] ACL of table [%s] is full", t.tableName), ErrorCode: 491, ErrorMessage: "Table ACL is full"}
+	}
+	t.acl[addr] |= perm
+	return t.updateTableInfo(u)
+}
+
+// Revoke removes perm from grantee
+func (t *Table) Revoke(u *SWARMDBUser, grantee string, perm uint8) (err error) {
+	if err = t.checkGrant(u); err != nil {
+		return err
+	}
+	addr := common.HexToAddress(grantee)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.acl[addr]; !ok {
+		return nil
+	}
+	t.acl[addr] &^= perm
+	if t.acl[addr] == 0 {
+		delete(t.acl, addr)
+	}
+	return t.updateTableInfo(u)
+}
+
+// copyACL returns a copy of the table ACL, which Grant and Revoke change under t.mu
+func (t *Table) copyACL() (acl map[common.Address]uint8) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	acl = make(map[common.Address]uint8, len(t.acl))
+	for addr, perm := range t.acl {
+		acl[addr] = perm
+	}
+	return acl
+}
+
+func (t *Table) checkGrant(u *SWARMDBUser) (err error) {
+	if err = t.checkAccess(u, ACL_GRANT); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[acl:checkGrant] checkAccess %s", err.Error()))
+	}
+	return nil
+}
+
+// ListGrants returns one row per address: {"address": "0x...", "permission": "read,write"}
+func (t *Table) ListGrants() (rows []sdbc.Row) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return grantRows(t.acl)
+}
+
+func grantRows(acl map[common.Address]uint8) (rows []sdbc.Row) {
+	for addr, perm := range acl {
+		var names []string
+		for _, name := range []string{"read", "write", "grant", "restricted"} {
+			if perm&aclPermissionNames[name] != 0 {
+				names = append(names, name)
+			}
+		}
+		r := sdbc.NewRow()
+		r["address"] = addr.Hex()
+		r["permission"] = strings.Join(names, ",")
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+// parseGrantRow reads {"address": "0x...", "permission": "read"|"write"|"grant"|"restricted"|"all"} from a Grant/Revoke request
+func parseGrantRow(row sdbc.Row) (grantee string, perm uint8, err error) {
+	grantee, _ = row["address"].(string)
+	if !common.IsHexAddress(grantee) {
+		return grantee, perm, &sdbc.SWARMDBError{Message: fmt.Sprintf("[acl:parseGrantRow] invalid address [%v]", row["address"]), ErrorCode: 492, ErrorMessage: "Invalid Grant Request: address must be an Ethereum address"}
+	}
+	for _, name := range strings.Split(fmt.Sprintf("%v", row["permission"]), ",") {
+		p, ok := aclPermissionNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return grantee, perm, &sdbc.SWARMDBError{Message: fmt.Sprintf("[acl:parseGrantRow] invalid permission [%v]", row["permission"]), ErrorCode: 492, ErrorMessage: "Invalid Grant Request: permission must be read, write, grant, restricted or all"}
+		}
+		perm |= p
+	}
+	return grantee, perm, nil
+}
+
+// queryPermission is the permission a parsed query needs
+func queryPermission(query *QueryOption) uint8 {
+	if query.Type == "Select" || query.Explain {
+		return ACL_READ
+	}
+	return ACL_WRITE
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb_test
+
+import (
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	sdb "swarmdb"
+	"testing"
+)
+
+func TestTableACL(t *testing.T) {
+	owner, database, tableName := make_table(t, "acl")
+	reader := &sdb.SWARMDBUser{Address: "0x1111111111111111111111111111111111111111"}
+	stranger := &sdb.SWARMDBUser{Address: "0x2222222222222222222222222222222222222222"}
+	writer := &sdb.SWARMDBUser{Address: "0x3333333333333333333333333333333333333333"}
+
+	row := sdbc.NewRow()
+	row["email"] = "acl@wolk.com"
+	row["name"] = "Alan"
+	row["age"] = 41
+	put := &sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{row}}
+	get := &sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: "acl@wolk.com"}
+
+	// tables without grants are open for rows, but only the owner grants or drops them
+	if _, err := swarmdb.HandleRequest(stranger, put); err != nil {
+		t.Fatalf("[acl_test:TestTableACL] PUT on open table %s", err)
+	}
+	grant := sdbc.NewRow()
+	grant["address"] = stranger.Address
+	grant["permission"] = "all"
+	if _, err := swarmdb.HandleRequest(stranger, &sdbc.RequestOption{RequestType: sdb.RT_GRANT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{grant}}); err == nil {
+		t.Fatalf("[acl_test:TestTableACL] GRANT as stranger on open table succeeded")
+	}
+	if _, err := swarmdb.HandleRequest(stranger, &sdbc.RequestOption{RequestType: sdbc.RT_DROP_TABLE, Owner: owner, Database: database, Table: tableName}); err == nil {
+		t.Fatalf("[acl_test:TestTableACL] DROP TABLE as stranger on open table succeeded")
+	}
+
+	writerGrant := sdbc.NewRow()
+	writerGrant["address"] = writer.Address
+	writerGrant["permission"] = "write"
+	grant = sdbc.NewRow()
+	grant["address"] = reader.Address
+	grant["permission"] = "read"
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdb.RT_GRANT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{grant, writerGrant}}); err != nil {
+		t.Fatalf("[acl_test:TestTableACL] GRANT %s", err)
+	}
+
+	if res, err := swarmdb.HandleRequest(reader, get); err != nil || res.MatchedRowCount != 1 {
+		t.Fatalf("[acl_test:TestTableACL] GET as reader %v %s", res, err)
+	}
+	if _, err := swarmdb.HandleRequest(reader, put); err == nil {
+		t.Fatalf("[acl_test:TestTableACL] PUT as reader succeeded")
+	}
+	if _, err := swarmdb.HandleRequest(stranger, get); err == nil {
+		t.Fatalf("[acl_test:TestTableACL] GET as stranger succeeded")
+	}
+	if _, err := swarmdb.HandleRequest(writer, &sdbc.RequestOption{RequestType: sdb.RT_GRANT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{grant}}); err == nil {
+		t.Fatalf("[acl_test:TestTableACL] GRANT as writer succeeded")
+	}
+	// the owner keeps full control
+	if _, err := swarmdb.HandleRequest(u, put); err != nil {
+		t.Fatalf("[acl_test:TestTableACL] PUT as owner %s", err)
+	}
+
+	res, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdb.RT_LIST_GRANTS, Owner: owner, Database: database, Table: tableName})
+	if err != nil || res.MatchedRowCount != 2 {
+		t.Fatalf("[acl_test:TestTableACL] LIST GRANTS %s %s", res.Stringify(), err)
+	}
+
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdb.RT_REVOKE, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{grant}}); err != nil {
+		t.Fatalf("[acl_test:TestTableACL] REVOKE %s", err)
+	}
+	// the grant of writer keeps the table closed
+	if _, err := swarmdb.HandleRequest(reader, get); err == nil {
+		t.Fatalf("[acl_test:TestTableACL] GET after REVOKE succeeded")
+	}
+}
+
+func TestRestrictedColumns(t *testing.T) {
+	owner, database, tableName := make_table(t, "restricted")
+	reader := &sdb.SWARMDBUser{Address: "0x3333333333333333333333333333333333333333"}
+
+	row := sdbc.NewRow()
+	row["email"] = "restricted@wolk.com"
+	row["name"] = "Rita"
+	row["age"] = 37
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{row}}); err != nil {
+		t.Fatalf("[acl_test:TestRestrictedColumns] PUT %s", err)
+	}
+	restrict := &sdbc.RequestOption{RequestType: wire.RT_RESTRICT_COLUMNS, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{{"columns": []string{"age"}}}}
+	if res, err := swarmdb.HandleRequest(u, restrict); err != nil || len(res.Data) != 1 || res.Data[0]["column"] != "age" {
+		t.Fatalf("[acl_test:TestRestrictedColumns] RESTRICT %v %v", res, err)
+	}
+	grant := sdbc.NewRow()
+	grant["address"] = reader.Address
+	grant["permission"] = "read"
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdb.RT_GRANT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{grant}}); err != nil {
+		t.Fatalf("[acl_test:TestRestrictedColumns] GRANT %s", err)
+	}
+
+	get := &sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: "restricted@wolk.com"}
+	scan := &sdbc.RequestOption{RequestType: sdbc.RT_SCAN, Owner: owner, Database: database, Table: tableName}
+	describe := &sdbc.RequestOption{RequestType: sdbc.RT_DESCRIBE_TABLE, Owner: owner, Database: database, Table: tableName}
+	query := &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, Table: tableName, RawQuery: fmt.Sprintf("select email, name, age from %s where email = 'restricted@wolk.com'", tableName)}
+	whereAge := &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, Table: tableName, RawQuery: fmt.Sprintf("select email, name from %s where age = 37", tableName)}
+
+	// a reader without "restricted" gets the rows, and the table description, without the age
+	for _, req := range []*sdbc.RequestOption{get, scan, query} {
+		res, err := swarmdb.HandleRequest(reader, req)
+		if err != nil || len(res.Data) != 1 || res.Data[0]["name"] != "Rita" {
+			t.Fatalf("[acl_test:TestRestrictedColumns] %s as reader %v %v", req.RequestType, res, err)
+		}
+		if _, ok := res.Data[0]["age"]; ok {
+			t.Fatalf("[acl_test:TestRestrictedColumns] %s as reader returned the restricted column %v", req.RequestType, res.Data[0])
+		}
+	}
+	res, err := swarmdb.HandleRequest(reader, describe)
+	if err != nil {
+		t.Fatalf("[acl_test:TestRestrictedColumns] DESCRIBE as reader %s", err)
+	}
+	for _, c := range res.Data {
+		if c["ColumnName"] == "age" {
+			t.Fatalf("[acl_test:TestRestrictedColumns] DESCRIBE as reader listed the restricted column")
+		}
+	}
+	_, err = swarmdb.HandleRequest(reader, whereAge)
+	if sErr, ok := err.(*sdbc.SWARMDBError); !ok || sErr.ErrorCode != 490 {
+		t.Fatalf("[acl_test:TestRestrictedColumns] WHERE on restricted column as reader returned %v", err)
+	}
+
+	// granting "restricted" reveals it
+	grant["permission"] = "read,restricted"
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdb.RT_GRANT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{grant}}); err != nil {
+		t.Fatalf("[acl_test:TestRestrictedColumns] GRANT restricted %s", err)
+	}
+	if res, err := swarmdb.HandleRequest(reader, get); err != nil || len(res.Data) != 1 || res.Data[0]["age"] == nil {
+		t.Fatalf("[acl_test:TestRestrictedColumns] GET with restricted %v %v", res, err)
+	}
+	if _, err := swarmdb.HandleRequest(reader, whereAge); err != nil {
+		t.Fatalf("[acl_test:TestRestrictedColumns] WHERE with restricted %s", err)
+	}
+}
+
+func TestDatabaseACL(t *testing.T) {
+	owner, database, tableName := make_table(t, "dbacl")
+	reader := &sdb.SWARMDBUser{Address: "0x4444444444444444444444444444444444444444"}
+	stranger := &sdb.SWARMDBUser{Address: "0x5555555555555555555555555555555555555555"}
+	writer := &sdb.SWARMDBUser{Address: "0x6666666666666666666666666666666666666666"}
+
+	row := sdbc.NewRow()
+	row["email"] = "dbacl@wolk.com"
+	row["name"] = "Dana"
+	row["age"] = 29
+	put := &sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{row}}
+	get := &sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: "dbacl@wolk.com"}
+	if _, err := swarmdb.HandleRequest(u, put); err != nil {
+		t.Fatalf("[acl_test:TestDatabaseACL] PUT %s", err)
+	}
+
+	// a grant without a table is on the database, and so on every table in it; only the owner grants on an open one
+	grant := sdbc.NewRow()
+	grant["address"] = reader.Address
+	grant["permission"] = "read"
+	if _, err := swarmdb.HandleRequest(stranger, &sdbc.RequestOption{RequestType: sdb.RT_GRANT, Owner: owner, Database: database, Rows: []sdbc.Row{grant}}); err == nil {
+		t.Fatalf("[acl_test:TestDatabaseACL] GRANT as stranger on open database succeeded")
+	}
+	writerGrant := sdbc.NewRow()
+	writerGrant["address"] = writer.Address
+	writerGrant["permission"] = "write"
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdb.RT_GRANT, Owner: owner, Database: database, Rows: []sdbc.Row{grant, writerGrant}}); err != nil {
+		t.Fatalf("[acl_test:TestDatabaseACL] GRANT %s", err)
+	}
+	if res, err := swarmdb.HandleRequest(reader, get); err != nil || res.MatchedRowCount != 1 {
+		t.Fatalf("[acl_test:TestDatabaseACL] GET as reader %v %s", res, err)
+	}
+	if _, err := swarmdb.HandleRequest(reader, put); err == nil {
+		t.Fatalf("[acl_test:TestDatabaseACL] PUT as reader succeeded")
+	}
+	if _, err := swarmdb.HandleRequest(stranger, get); err == nil {
+		t.Fatalf("[acl_test:TestDatabaseACL] GET as stranger succeeded")
+	}
+	create := &sdbc.RequestOption{RequestType: sdbc.RT_CREATE_TABLE, Owner: owner, Database: database, Table: make_name("dbacl2tbl"), Columns: []sdbc.Column{{ColumnName: "email", Primary: 1, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_STRING}}}
+	if _, err := swarmdb.HandleRequest(stranger, create); err == nil {
+		t.Fatalf("[acl_test:TestDatabaseACL] CREATE TABLE as stranger succeeded")
+	}
+	if _, err := swarmdb.HandleRequest(u, create); err != nil {
+		t.Fatalf("[acl_test:TestDatabaseACL] CREATE TABLE as owner %s", err)
+	}
+	res, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdb.RT_LIST_GRANTS, Owner: owner, Database: database})
+	if err != nil || res.MatchedRowCount != 2 {
+		t.Fatalf("[acl_test:TestDatabaseACL] LIST GRANTS %s %s", res.Stringify(), err)
+	}
+
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdb.RT_REVOKE, Owner: owner, Database: database, Rows: []sdbc.Row{grant}}); err != nil {
+		t.Fatalf("[acl_test:TestDatabaseACL] REVOKE %s", err)
+	}
+	if _, err := swarmdb.HandleRequest(reader, get); err == nil {
+		t.Fatalf("[acl_test:TestDatabaseACL] GET after REVOKE succeeded")
+	}
+
+	// dropping the database, open again, is left to the owner and drops its tables
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdb.RT_REVOKE, Owner: owner, Database: database, Rows: []sdbc.Row{writerGrant}}); err != nil {
+		t.Fatalf("[acl_test:TestDatabaseACL] REVOKE writer %s", err)
+	}
+	if _, err := swarmdb.HandleRequest(stranger, &sdbc.RequestOption{RequestType: sdbc.RT_DROP_DATABASE, Owner: owner, Database: database}); err == nil {
+		t.Fatalf("[acl_test:TestDatabaseACL] DROP DATABASE as stranger succeeded")
+	}
+	if res, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_DROP_DATABASE, Owner: owner, Database: database}); err != nil || res.AffectedRowCount != 1 {
+		t.Fatalf("[acl_test:TestDatabaseACL] DROP DATABASE %v %v", res, err)
+	}
+	if _, err := swarmdb.GetTable(u, owner, database, tableName); err == nil {
+		t.Fatalf("[acl_test:TestDatabaseACL] GetTable after DROP DATABASE succeeded")
+	}
+}
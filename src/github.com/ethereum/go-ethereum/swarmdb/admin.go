@@ -0,0 +1,207 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Admin commands are sent as swarmdbwire.RT_ADMIN requests naming one of the commands below; Owner/Database/Table
+// select the table for ADMIN_FLUSH and ADMIN_CLOSE_TABLE (ADMIN_FLUSH without a table flushes every open table).
+// ADMIN_ROOT_HASH and ADMIN_GET_CHUNKS serve the anti-entropy sync of other nodes (see Syncer), ADMIN_GOSSIP their
+// gossip layer (see Gossip).  ADMIN_BACKUP and ADMIN_RESTORE move tables between nodes as archives (see Backup),
+// ADMIN_INSPECT_CHUNK decodes a chunk for debugging (see InspectChunk) and ADMIN_VERIFY checks tables (see Verify).
+// ADMIN_LOG_LEVEL changes log levels while the server runs (see swarmdblog) and ADMIN_USAGE exports the usage
+// metered per owner for billing (see ExportUsage).  ADMIN_DEPOSIT, ADMIN_BALANCES and ADMIN_AUDIT keep the storage
+// accounts of owners and farmers (see Ledger).  ADMIN_WARM loads the top levels of table indexes after a restart
+// (see Table.Warm).
+// The TCP server only accepts them on sessions authenticated as the node Address or one of config.Admins.
+const (
+	ADMIN_LIST_TABLES = "ListOpenTables"
+	ADMIN_CACHE_STATS = "CacheStats"
+	ADMIN_FLUSH       = "Flush"
+	ADMIN_GC          = "GC"
+	ADMIN_CLOSE_TABLE = "CloseTable"
+	ADMIN_CONFIG      = "Config"
+	ADMIN_ROOT_HASH   = "RootHash"
+	ADMIN_GET_CHUNKS  = "GetChunks"
+)
+
+// IsAdmin reports whether address may run admin commands
+func (self *SWARMDBConfig) IsAdmin(address string) bool {
+	address = strings.ToLower(address)
+	if len(self.Address) > 0 && strings.ToLower(self.Address) == address {
+		return true
+	}
+	for _, admin := range self.Admins {
+		if strings.ToLower(admin) == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Admin runs an admin command; authorization is the caller's responsibility
+func (self *SwarmDB) Admin(u *SWARMDBUser, config *SWARMDBConfig, command string, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	log.Debug(fmt.Sprintf("[admin:Admin] %s", command), "trace", u.TraceID())
+	switch command {
+	case ADMIN_LIST_TABLES:
+		tables := self.openTables()
+		sort.Slice(tables, func(i, j int) bool {
+			return self.GetTableKey(tables[i].Owner, tables[i].Database, tables[i].tableName) < self.GetTableKey(tables[j].Owner, tables[j].Database, tables[j].tableName)
+		})
+		for _, tbl := range tables {
+			row := sdbc.NewRow()
+			row["owner"] = tbl.Owner
+			row["database"] = tbl.Database
+			row["table"] = tbl.tableName
+			row["buffered"] = tbl.buffered
+			row["refs"] = int(atomic.LoadInt32(&tbl.refs))
+			row["idleSeconds"] = int(time.Since(tbl.idleSince()).Seconds())
+			resp.Data = append(resp.Data, row)
+		}
+		resp.MatchedRowCount = len(resp.Data)
+		return resp, nil
+
+	case ADMIN_CACHE_STATS:
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		row := self.dbchunkstore.Stats()
+		row["openTables"] = len(self.openTables())
+		row["chunkCacheMB"] = config.ChunkCacheMB
+		row["openFilesCache"] = config.OpenFilesCache
+		self.queryCache.stats(row)
+		self.statements.stats(row)
+		row["heapAlloc"] = mem.HeapAlloc
+		row["heapSys"] = mem.HeapSys
+		row["numGC"] = mem.NumGC
+		return sdbc.SWARMDBResponse{Data: []sdbc.Row{row}, MatchedRowCount: 1}, nil
+
+	case ADMIN_FLUSH:
+		if len(d.Table) > 0 {
+			tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+			if err != nil {
+				return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[admin:Admin] GetTable %s", err.Error()))
+			}
+			if err = tbl.FlushBuffer(u); err != nil {
+				return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[admin:Admin] FlushBuffer %s", err.Error()))
+			}
+			return sdbc.SWARMDBResponse{AffectedRowCount: 1}, nil
+		}
+		for _, tbl := range self.openTables() {
+			if err = tbl.FlushBuffer(u); err != nil {
+				return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[admin:Admin] FlushBuffer [%s] %s", self.GetTableKey(tbl.Owner, tbl.Database, tbl.tableName), err.Error()))
+			}
+			resp.AffectedRowCount++
+		}
+		return resp, nil
+
+	case ADMIN_GC:
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		debug.FreeOSMemory()
+		runtime.ReadMemStats(&after)
+		row := sdbc.NewRow()
+		row["heapAllocBefore"] = before.HeapAlloc
+		row["heapAllocAfter"] = after.HeapAlloc
+		return sdbc.SWARMDBResponse{Data: []sdbc.Row{row}, MatchedRowCount: 1}, nil
+
+	case ADMIN_CLOSE_TABLE:
+		closed, err := self.CloseTable(u, d.Owner, d.Database, d.Table)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[admin:Admin] CloseTable %s", err.Error()))
+		}
+		if closed {
+			resp.AffectedRowCount = 1
+		}
+		return resp, nil
+
+	case ADMIN_CONFIG:
+		row, err := config.redacted()
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[admin:Admin] redacted %s", err.Error()))
+		}
+		return sdbc.SWARMDBResponse{Data: []sdbc.Row{row}, MatchedRowCount: 1}, nil
+
+	case ADMIN_ROOT_HASH:
+		return self.syncRootHash(u, d)
+
+	case ADMIN_GET_CHUNKS:
+		return self.syncGetChunks(d)
+
+	case ADMIN_GOSSIP:
+		return self.exchange(d)
+
+	case ADMIN_INSPECT_CHUNK:
+		return self.inspectChunk(u, d)
+
+	case ADMIN_BACKUP:
+		return self.backup(u, d)
+
+	case ADMIN_RESTORE:
+		return self.restore(u, d)
+
+	case ADMIN_VERIFY:
+		return self.verify(u, d)
+
+	case ADMIN_LOG_LEVEL:
+		return logLevel(d)
+
+	case ADMIN_USAGE:
+		return self.exportUsage(d)
+
+	case ADMIN_DEPOSIT:
+		return self.deposit(d)
+
+	case ADMIN_BALANCES:
+		return self.balances(d)
+
+	case ADMIN_AUDIT:
+		return self.audit(d)
+
+	case ADMIN_WARM:
+		return self.warm(u, d)
+	}
+	return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[admin:Admin] unknown command [%s]", command), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: unknown admin command [%s]", command)}
+}
+
+// redacted returns the config as a row without the private key and user passphrases
+func (self *SWARMDBConfig) redacted() (row sdbc.Row, err error) {
+	c := *self
+	c.PrivateKey = ""
+	c.Users = make([]SWARMDBUser, len(self.Users))
+	for i, user := range self.Users {
+		c.Users[i] = SWARMDBUser{Address: user.Address, MinReplication: user.MinReplication, MaxReplication: user.MaxReplication, AutoRenew: user.AutoRenew}
+	}
+	out, err := json.Marshal(c)
+	if err != nil {
+		return row, err
+	}
+	row = sdbc.NewRow()
+	if err = json.Unmarshal(out, &row); err != nil {
+		return row, err
+	}
+	return row, nil
+}
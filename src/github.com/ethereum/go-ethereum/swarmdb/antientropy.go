@@ -0,0 +1,513 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"github.com/ethereum/go-ethereum/swarmdb/swarmdblib"
+	"sort"
+	"time"
+)
+
+// A Syncer brings the tables of a node up to date with its peers (config.Peers) by copying only the chunks it is
+// missing.  For each table it compares the root hash the peer publishes with its own and walks the peer's index
+// trees top down: index nodes are keyed by the hash of their content and stored only after their whole subtree,
+// so a node already held locally roots a complete subtree and is skipped, and a replica that was offline fetches
+// just the nodes written since.  Rows are updated in place under a fixed key, so the leaves of the primary index
+// are always visited and rows are compared by version (see ChunkHeader), fetching those the peer has newer
+// together with any archived versions missing locally.  Once a table is complete its root hash is published to
+// the local ENS, on replicas only, and Follow reopens the open tables that moved.
+const (
+	SYNC_BATCH    = 64 // chunks requested per ADMIN_GET_CHUNKS round trip
+	SYNC_INTERVAL = 30 * time.Second
+)
+
+type Syncer struct {
+	swarmdb *SwarmDB
+	peers   map[string]*swarmdblib.Pool
+}
+
+// NewSyncer returns a Syncer of swarmdb with the peers of config; peer connections authenticate with
+// config.PrivateKey, which the peers must accept as an admin address
+func NewSyncer(swarmdb *SwarmDB, config *SWARMDBConfig) *Syncer {
+	s := &Syncer{swarmdb: swarmdb, peers: make(map[string]*swarmdblib.Pool)}
+	for _, peer := range config.Peers {
+		name := fmt.Sprintf("%s:%d", peer.IP, peer.Port)
+		s.peers[name] = swarmdblib.NewPool(swarmdblib.PoolConfig{IP: peer.IP, Port: peer.Port, PrivateKey: config.PrivateKey})
+	}
+	return s
+}
+
+// Close closes the peer connections
+func (s *Syncer) Close() {
+	for _, p := range s.peers {
+		p.Close()
+	}
+}
+
+func (s *Syncer) peerNames() (names []string) {
+	for name := range s.peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sync syncs the tables open here or on any peer and returns the number of chunks fetched
+func (s *Syncer) Sync(ctx context.Context, u *SWARMDBUser) (fetched int, err error) {
+	tables := make(map[string]sdbc.RequestOption)
+	for _, t := range s.swarmdb.openTables() {
+		tables[s.swarmdb.GetTableKey(t.Owner, t.Database, t.tableName)] = sdbc.RequestOption{Owner: t.Owner, Database: t.Database, Table: t.tableName}
+	}
+	for _, name := range s.peerNames() {
+		resp, err := s.admin(ctx, s.peers[name], ADMIN_LIST_TABLES, sdbc.RequestOption{})
+		if err != nil {
+			log.Debug(fmt.Sprintf("[antientropy:Sync] %s ListOpenTables %s", name, err.Error()))
+			continue
+		}
+		for _, row := range resp.Data {
+			owner, _ := row["owner"].(string)
+			database, _ := row["database"].(string)
+			table, _ := row["table"].(string)
+			tables[s.swarmdb.GetTableKey(owner, database, table)] = sdbc.RequestOption{Owner: owner, Database: database, Table: table}
+		}
+	}
+	tblKeys := make([]string, 0, len(tables))
+	for tblKey := range tables {
+		tblKeys = append(tblKeys, tblKey)
+	}
+	sort.Strings(tblKeys)
+	for _, tblKey := range tblKeys {
+		t := tables[tblKey]
+		n, err := s.SyncTable(ctx, u, t.Owner, t.Database, t.Table)
+		fetched += n
+		if err != nil {
+			return fetched, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:Sync] SyncTable %s", err.Error()))
+		}
+	}
+	if s.swarmdb.IsReplica() {
+		if _, err = s.swarmdb.Follow(u); err != nil {
+			return fetched, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:Sync] Follow %s", err.Error()))
+		}
+	}
+	return fetched, nil
+}
+
+// SyncTable syncs one table from the first peer that answers and returns the number of chunks fetched
+func (s *Syncer) SyncTable(ctx context.Context, u *SWARMDBUser, owner string, database string, table string) (fetched int, err error) {
+	err = &sdbc.SWARMDBError{Message: "[antientropy:SyncTable] no peers", ErrorCode: 418, ErrorMessage: "Request Invalid: no peers to sync from"}
+	for _, name := range s.peerNames() {
+		p := s.peers[name]
+		var dbc *swarmdblib.SWARMDBConnection
+		if dbc, err = p.Get(ctx); err == nil {
+			ts := &tableSync{swarmdb: s.swarmdb, u: u, dbc: dbc}
+			err = ts.syncTable(owner, database, table)
+			p.Put(dbc)
+			fetched += ts.fetched
+			if err == nil {
+				return fetched, nil
+			}
+		}
+		log.Debug(fmt.Sprintf("[antientropy:SyncTable] %s %s", name, err.Error()))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return fetched, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:SyncTable] %s", err.Error()))
+}
+
+// Start calls Sync every interval until stop is called
+func (s *Syncer) Start(u *SWARMDBUser, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = SYNC_INTERVAL
+	}
+	quit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				if _, err := s.Sync(context.Background(), u); err != nil {
+					log.Debug(fmt.Sprintf("[antientropy:Start] %s", err.Error()))
+				}
+			}
+		}
+	}()
+	return func() { close(quit) }
+}
+
+func (s *Syncer) admin(ctx context.Context, p *swarmdblib.Pool, command string, req sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	dbc, err := p.Get(ctx)
+	if err != nil {
+		return resp, err
+	}
+	defer p.Put(dbc)
+	return dbc.Admin(command, req)
+}
+
+// tableSync is the sync of one table from one peer
+type tableSync struct {
+	swarmdb *SwarmDB
+	u       *SWARMDBUser
+	dbc     *swarmdblib.SWARMDBConnection
+	fetched int
+}
+
+func (ts *tableSync) syncTable(owner string, database string, table string) (err error) {
+	tableRow := sdbc.NewRow()
+	tableRow["owner"] = owner
+	tableRow["database"] = database
+	tableRow["table"] = table
+	resp, err := ts.dbc.Admin(ADMIN_ROOT_HASH, sdbc.RequestOption{Rows: []sdbc.Row{tableRow}})
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:syncTable] RootHash %s", err.Error()))
+	}
+	var roothash []byte
+	if len(resp.Data) > 0 {
+		if h, ok := resp.Data[0]["roothash"].(string); ok {
+			roothash, _ = hex.DecodeString(h)
+		}
+	}
+	if len(bytes.Trim(roothash, "\x00")) == 0 {
+		return nil
+	}
+	chunks, err := ts.fetch([][]byte{roothash})
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:syncTable] fetch %s", err.Error()))
+	}
+	descriptor, err := ts.swarmdb.RetrieveDBChunk(ts.u, roothash)
+	if data, ok := chunks[string(roothash)]; ok {
+		descriptor, err = ts.swarmdb.dbchunkstore.openStoredChunk(ts.u, data)
+	}
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:syncTable] descriptor %s", err.Error()))
+	}
+	for i := 2048; i < 4000 && descriptor[i] != 0; i = i + 64 {
+		primary := descriptor[i+26] == 1
+		indexType := ByteToIndexType(descriptor[i+30])
+		root := make([]byte, 32)
+		copy(root, descriptor[i+32:i+64])
+		if !valid_hashid(root) {
+			continue
+		}
+		if err = ts.syncTree([][]byte{root}, indexType, primary); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:syncTable] syncTree %s", err.Error()))
+		}
+	}
+	if splits := readShardSplits(descriptor); len(splits) > 0 {
+		for i := 0; i <= len(splits); i++ {
+			if err = ts.syncTable(owner, database, shardTableName(table, i)); err != nil {
+				return err
+			}
+		}
+	}
+	if data, ok := chunks[string(roothash)]; ok {
+		if err = ts.store(roothash, data); err != nil {
+			return err
+		}
+	}
+	if !ts.swarmdb.IsReplica() {
+		return nil
+	}
+	tblKey := []byte(ts.swarmdb.GetTableKey(owner, database, table))
+	local, err := ts.swarmdb.GetRootHash(ts.u, tblKey)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:syncTable] GetRootHash %s", err.Error()))
+	}
+	if bytes.Equal(local, roothash) {
+		return nil
+	}
+	if err = ts.swarmdb.StoreRootHash(ts.u, tblKey, nil, roothash); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:syncTable] StoreRootHash %s", err.Error()))
+	}
+	return nil
+}
+
+// syncTree makes the index subtrees under keys complete locally, storing each fetched node after its subtree.
+// Nodes held locally are skipped, except in the primary index, whose leaves point to the rows.
+func (ts *tableSync) syncTree(keys [][]byte, indexType sdbc.IndexType, primary bool) (err error) {
+	chunks, err := ts.fetch(keys)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		data, fetched := chunks[string(key)]
+		var buf []byte
+		if fetched {
+			buf, err = ts.swarmdb.dbchunkstore.openStoredChunk(ts.u, data)
+		} else if primary {
+			buf, err = ts.swarmdb.RetrieveDBChunk(ts.u, key)
+		} else {
+			continue
+		}
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:syncTree] %x %s", key, err.Error()))
+		}
+		children, rows := indexNodeRefs(buf, indexType)
+		if err = ts.syncTree(children, indexType, primary); err != nil {
+			return err
+		}
+		if primary {
+			if err = ts.syncRows(rows); err != nil {
+				return err
+			}
+		}
+		if fetched {
+			if err = ts.store(key, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexNodeRefs returns the hashes of the child nodes and the values held by the index node buf
+func indexNodeRefs(buf []byte, indexType sdbc.IndexType) (children [][]byte, values [][]byte) {
+	switch indexType {
+	case sdbc.IT_BPLUSTREE:
+		isX := get_chunk_nodetype(buf) == "X"
+		for _, e := range get_chunk_entries(buf) {
+			if !valid_hashid(e.v) {
+				continue
+			}
+			if isX {
+				children = append(children, e.v)
+			} else {
+				values = append(values, e.v)
+			}
+		}
+	case sdbc.IT_HASHTREE:
+		if binary.LittleEndian.Uint64(buf[0:8]) == 1 {
+			for i := 0; i < 64; i++ {
+				if hashid := buf[64+32*i : 64+32*(i+1)]; valid_hashid(hashid) {
+					children = append(children, hashid)
+				}
+			}
+		} else if hashid := buf[64:96]; valid_hashid(hashid) {
+			values = append(values, hashid)
+		}
+	}
+	return children, values
+}
+
+// syncRows fetches the rows of keys the peer holds a newer version of, and their archived versions
+func (ts *tableSync) syncRows(keys [][]byte) (err error) {
+	for start := 0; start < len(keys); start += SYNC_BATCH {
+		end := start + SYNC_BATCH
+		if end > len(keys) {
+			end = len(keys)
+		}
+		req := sdbc.RequestOption{}
+		for _, key := range keys[start:end] {
+			row := sdbc.NewRow()
+			row["key"] = hex.EncodeToString(key)
+			row["version"] = -1
+			data, ok, err := ts.swarmdb.dbchunkstore.RetrieveStoredChunk(key)
+			if err != nil {
+				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:syncRows] RetrieveStoredChunk %s", err.Error()))
+			}
+			if ok {
+				if header, isRow, err := storedRowHeader(data); err == nil && isRow {
+					row["version"] = header.Version
+					row["updated"] = header.UpdateMs
+				}
+			}
+			req.Rows = append(req.Rows, row)
+		}
+		chunks, err := ts.getChunks(req)
+		if err != nil {
+			return err
+		}
+		var archived [][]byte
+		for key, data := range chunks {
+			if err = ts.store([]byte(key), data); err != nil {
+				return err
+			}
+			archived = appendPrevVersion(archived, data)
+		}
+		for len(archived) > 0 {
+			chunks, err := ts.fetch(archived)
+			if err != nil {
+				return err
+			}
+			archived = nil
+			for key, data := range chunks {
+				if err = ts.store([]byte(key), data); err != nil {
+					return err
+				}
+				archived = appendPrevVersion(archived, data)
+			}
+		}
+	}
+	return nil
+}
+
+func appendPrevVersion(keys [][]byte, data []byte) [][]byte {
+	header, isRow, err := storedRowHeader(data)
+	if err != nil || !isRow || !valid_hashid(header.PrevVersion) {
+		return keys
+	}
+	return append(keys, header.PrevVersion)
+}
+
+// storedRowHeader parses the header of a chunk in its stored form, reporting whether it is a row
+func storedRowHeader(data []byte) (header ChunkHeader, isRow bool, err error) {
+	c, err := decodeStoredChunk(data)
+	if err != nil {
+		return header, false, err
+	}
+	if len(c.Val) < CHUNK_START_CHUNKVAL || string(c.Val[CHUNK_START_CHUNKTYPE:CHUNK_END_CHUNKTYPE]) != "k" {
+		return header, false, nil
+	}
+	header, err = ParseChunkHeader(c.Val)
+	return header, err == nil, err
+}
+
+// fetch returns the chunks of keys missing locally, in their stored form, as held by the peer
+func (ts *tableSync) fetch(keys [][]byte) (chunks map[string][]byte, err error) {
+	chunks = make(map[string][]byte)
+	req := sdbc.RequestOption{}
+	flush := func() error {
+		if len(req.Rows) == 0 {
+			return nil
+		}
+		got, err := ts.getChunks(req)
+		if err != nil {
+			return err
+		}
+		for key, data := range got {
+			chunks[key] = data
+		}
+		req.Rows = nil
+		return nil
+	}
+	for _, key := range keys {
+		ok, err := ts.swarmdb.dbchunkstore.HasChunk(key)
+		if err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:fetch] HasChunk %s", err.Error()))
+		}
+		if ok {
+			continue
+		}
+		row := sdbc.NewRow()
+		row["key"] = hex.EncodeToString(key)
+		req.Rows = append(req.Rows, row)
+		if len(req.Rows) == SYNC_BATCH {
+			if err = flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err = flush(); err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+func (ts *tableSync) getChunks(req sdbc.RequestOption) (chunks map[string][]byte, err error) {
+	resp, err := ts.dbc.Admin(ADMIN_GET_CHUNKS, req)
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:getChunks] GetChunks %s", err.Error()))
+	}
+	chunks = make(map[string][]byte)
+	for _, row := range resp.Data {
+		k, _ := row["key"].(string)
+		c, _ := row["chunk"].(string)
+		key, errK := hex.DecodeString(k)
+		data, errC := base64.StdEncoding.DecodeString(c)
+		if errK != nil || errC != nil || len(key) == 0 {
+			return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[antientropy:getChunks] bad chunk [%s]", k), ErrorCode: 440, ErrorMessage: "Unable to Retrieve Chunk"}
+		}
+		chunks[string(key)] = data
+	}
+	return chunks, nil
+}
+
+func (ts *tableSync) store(key []byte, data []byte) (err error) {
+	if err = ts.swarmdb.dbchunkstore.StoreStoredChunk(key, data); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:store] StoreStoredChunk %s", err.Error()))
+	}
+	ts.fetched++
+	return nil
+}
+
+// syncRootHash answers ADMIN_ROOT_HASH with the root hash published for the table named by the "owner", "database"
+// and "table" of d.Rows[0]; d.Owner is the owner of the session, not of the table synced
+func (self *SwarmDB) syncRootHash(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	if len(d.Rows) == 0 {
+		return resp, &sdbc.SWARMDBError{Message: "[antientropy:syncRootHash] missing table", ErrorCode: 418, ErrorMessage: "Request Invalid: RootHash requires a table"}
+	}
+	owner, _ := d.Rows[0]["owner"].(string)
+	database, _ := d.Rows[0]["database"].(string)
+	table, _ := d.Rows[0]["table"].(string)
+	roothash, err := self.GetRootHash(u, []byte(self.GetTableKey(owner, database, table)))
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:syncRootHash] GetRootHash %s", err.Error()))
+	}
+	row := sdbc.NewRow()
+	row["roothash"] = hex.EncodeToString(roothash)
+	return sdbc.SWARMDBResponse{Data: []sdbc.Row{row}, MatchedRowCount: 1}, nil
+}
+
+// syncGetChunks answers ADMIN_GET_CHUNKS: each of d.Rows names a chunk by its hex "key" and the chunks held here
+// are returned in their stored form as base64 "chunk".  A row with a "version" (-1 for a row missing on the
+// caller) and "updated" only gets the row chunk if the version held here is newer.
+func (self *SwarmDB) syncGetChunks(d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	if len(d.Rows) > SYNC_BATCH {
+		return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[antientropy:syncGetChunks] %d chunks", len(d.Rows)), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: at most %d chunks per request", SYNC_BATCH)}
+	}
+	for _, row := range d.Rows {
+		k, _ := row["key"].(string)
+		key, err := hex.DecodeString(k)
+		if err != nil || len(key) == 0 {
+			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[antientropy:syncGetChunks] key [%s]", k), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: bad chunk key [%s]", k)}
+		}
+		data, ok, err := self.dbchunkstore.RetrieveStoredChunk(key)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:syncGetChunks] RetrieveStoredChunk %s", err.Error()))
+		}
+		if !ok {
+			continue
+		}
+		if row["version"] != nil {
+			version, _ := toFloat(row["version"])
+			updated, _ := toFloat(row["updated"])
+			header, isRow, err := storedRowHeader(data)
+			if err != nil {
+				return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[antientropy:syncGetChunks] storedRowHeader %s", err.Error()))
+			}
+			if !isRow || float64(header.Version) < version || (float64(header.Version) == version && float64(header.UpdateMs) <= updated) {
+				continue
+			}
+		}
+		out := sdbc.NewRow()
+		out["key"] = k
+		out["chunk"] = base64.StdEncoding.EncodeToString(data)
+		resp.Data = append(resp.Data, out)
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The HTTP, JSON-RPC and gRPC servers share no session with their clients, so, unlike the TCP server, they
+// authenticate every call by its Credentials: either a challenge returned by Authenticator.Challenge with the hex
+// signature of SignHash(challenge), the call running as the signer, or a capability token, the call running as its
+// owner within its scope.  A signed challenge authenticates a single call, made within AUTH_CHALLENGE_TTL after the
+// challenge was issued; a call replaying it is refused.
+// With Authentication 0 a call without credentials runs as the default user.
+const AUTH_CHALLENGE_TTL = 5 * time.Minute
+
+// Credentials are what a call to a sessionless server authenticates with
+type Credentials struct {
+	Challenge  string `json:"challenge,omitempty"`
+	Signature  string `json:"signature,omitempty"`
+	Capability string `json:"capability,omitempty"`
+}
+
+type Authenticator struct {
+	swarmdb *SwarmDB
+	config  *SWARMDBConfig
+
+	mu         sync.Mutex
+	challenges map[string]time.Time // challenges issued, until they expire
+}
+
+func NewAuthenticator(swarmdb *SwarmDB, config *SWARMDBConfig) *Authenticator {
+	return &Authenticator{swarmdb: swarmdb, config: config, challenges: make(map[string]time.Time)}
+}
+
+// Challenge returns a new challenge, which one call may carry with its signature until it expires
+func (self *Authenticator) Challenge() (challenge string, expires time.Time, err error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return challenge, expires, &sdbc.SWARMDBError{Message: fmt.Sprintf("[auth:Challenge] rand.Read %s", err.Error()), ErrorCode: 489, ErrorMessage: "Authentication Required: unable to issue a challenge"}
+	}
+	challenge = fmt.Sprintf("%x", nonce)
+	now := time.Now()
+	expires = now.Add(AUTH_CHALLENGE_TTL)
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for c, t := range self.challenges {
+		if now.After(t) {
+			delete(self.challenges, c)
+		}
+	}
+	self.challenges[challenge] = expires
+	return challenge, expires, nil
+}
+
+// Authenticate returns the user the call of d runs as, from its credentials.  A capability fills in the owner and
+// database of d.
+func (self *Authenticator) Authenticate(creds Credentials, d *sdbc.RequestOption) (u *SWARMDBUser, err error) {
+	switch {
+	case len(creds.Capability) > 0:
+		req := &wire.Request{RequestOption: *d, Capability: creds.Capability}
+		if u, err = self.swarmdb.CheckCapability(req); err != nil {
+			return nil, err
+		}
+		*d = req.RequestOption
+	case len(creds.Challenge) > 0 || len(creds.Signature) > 0:
+		if !self.issued(creds.Challenge) {
+			return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[auth:Authenticate] challenge [%s] unknown or expired", creds.Challenge), ErrorCode: 489, ErrorMessage: "Authentication Required: the challenge is unknown or expired"}
+		}
+		sig, err := hex.DecodeString(strings.TrimPrefix(creds.Signature, "0x"))
+		if err != nil {
+			return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[auth:Authenticate] DecodeString %s", err.Error()), ErrorCode: 419, ErrorMessage: "Invalid Signature Length: Must be 65 characters"}
+		}
+		if u, err = self.swarmdb.dbchunkstore.GetKeyManager().VerifyMessage(SignHash([]byte(creds.Challenge)), sig); err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[auth:Authenticate] VerifyMessage %s", err.Error()))
+		}
+		if !self.consume(creds.Challenge) {
+			return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[auth:Authenticate] challenge [%s] used already", creds.Challenge), ErrorCode: 489, ErrorMessage: "Authentication Required: the challenge is unknown or expired"}
+		}
+	case self.config.Authentication == 1:
+		return nil, &sdbc.SWARMDBError{Message: "[auth:Authenticate] call without credentials", ErrorCode: 489, ErrorMessage: "Authentication Required: sign a challenge or present a capability"}
+	default:
+		u = self.config.GetSWARMDBUser()
+	}
+	return u, nil
+}
+
+// issued reports whether challenge was issued by Challenge and has not expired
+func (self *Authenticator) issued(challenge string) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	expires, ok := self.challenges[challenge]
+	return ok && time.Now().Before(expires)
+}
+
+// consume removes challenge, once its signature is verified, so that no other call authenticates with it; it
+// reports whether challenge was still issued
+func (self *Authenticator) consume(challenge string) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	expires, ok := self.challenges[challenge]
+	delete(self.challenges, challenge)
+	return ok && time.Now().Before(expires)
+}
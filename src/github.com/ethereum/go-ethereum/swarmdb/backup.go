@@ -0,0 +1,365 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"io"
+)
+
+// A backup archive holds everything needed to bring a table back at one root hash on any node: the descriptor,
+// every node of every column index and every row chunk, with the archived versions of each row (see mvcc.go),
+// copied in their stored form.  A sharded table is archived with its shards.  The layout is
+//
+//	BACKUP_MAGIC, uint32 length + JSON BackupHeader, then per chunk uint16 key length, key, uint32 length, chunk
+//
+// ending with a zero key length.  Encrypted chunks stay encrypted, so an archive of an encrypted table is only
+// readable by its owner's key.
+const (
+	ADMIN_BACKUP  = "Backup"  // Rows[0] {"owner", "database", "table", optional "roothash"}; answered with {"archive": base64}
+	ADMIN_RESTORE = "Restore" // Rows[0] {"archive": base64}; answered with a {"table", "roothash"} row per restored table
+
+	BACKUP_HEADER_MAX = 1 << 20
+)
+
+var BACKUP_MAGIC = []byte("swdbbak\x01")
+
+type BackupHeader struct {
+	Owner     string        `json:"owner"`
+	Database  string        `json:"database"`
+	Table     string        `json:"table"`
+	Encrypted int           `json:"encrypted"`
+	Columns   []sdbc.Column `json:"columns"`
+	Tables    []BackupTable `json:"tables"` // shards first, then the table itself
+	CreatedMs int64         `json:"createdMs"`
+}
+
+type BackupTable struct {
+	Table    string `json:"table"`
+	RootHash string `json:"roothash"` // hex
+}
+
+func backupError(message string, errorMessage string) error {
+	return &sdbc.SWARMDBError{Message: message, ErrorCode: 506, ErrorMessage: "Invalid Backup: " + errorMessage}
+}
+
+// Backup writes an archive of the table at roothash, or at its published root hash when roothash is nil, to w and
+// returns the number of chunks archived.  Shards are archived at their published root hashes.
+func (self *SwarmDB) Backup(u *SWARMDBUser, owner string, database string, tableName string, roothash []byte, w io.Writer) (chunks int, err error) {
+	tbl, err := self.GetTable(u, owner, database, tableName)
+	if err != nil {
+		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[backup:Backup] GetTable %s", err.Error()))
+	}
+	if roothash == nil {
+		if roothash, err = self.GetRootHash(u, []byte(self.GetTableKey(owner, database, tableName))); err != nil {
+			return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[backup:Backup] GetRootHash %s", err.Error()))
+		}
+	}
+	if !valid_hashid(roothash) {
+		return 0, backupError(fmt.Sprintf("[backup:Backup] %s has no root hash", tableName), "the table was never flushed")
+	}
+	descriptor, err := self.RetrieveDBChunk(u, roothash)
+	if err != nil {
+		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[backup:Backup] RetrieveDBChunk %s", err.Error()))
+	}
+	header := BackupHeader{Owner: owner, Database: database, Table: tableName, Encrypted: tbl.encrypted, CreatedMs: nowMs()}
+	columns, err := tbl.DescribeTable()
+	if err != nil {
+		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[backup:Backup] DescribeTable %s", err.Error()))
+	}
+	for _, name := range tbl.columnOrder() {
+		header.Columns = append(header.Columns, columns[name])
+	}
+	if splits := readShardSplits(descriptor); len(splits) > 0 {
+		for i := 0; i <= len(splits); i++ {
+			shardhash, err := self.GetRootHash(u, []byte(self.GetTableKey(owner, database, shardTableName(tableName, i))))
+			if err != nil {
+				return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[backup:Backup] GetRootHash shard %d %s", i, err.Error()))
+			}
+			header.Tables = append(header.Tables, BackupTable{Table: shardTableName(tableName, i), RootHash: hex.EncodeToString(shardhash)})
+		}
+	}
+	header.Tables = append(header.Tables, BackupTable{Table: tableName, RootHash: hex.EncodeToString(roothash)})
+
+	out, err := json.Marshal(header)
+	if err != nil {
+		return 0, backupError(fmt.Sprintf("[backup:Backup] Marshal %s", err.Error()), "unable to encode the header")
+	}
+	bw := &backupWriter{swarmdb: self, u: u, w: bufio.NewWriter(w), seen: make(map[string]bool)}
+	bw.w.Write(BACKUP_MAGIC)
+	binary.Write(bw.w, binary.BigEndian, uint32(len(out)))
+	bw.w.Write(out)
+	for _, t := range header.Tables {
+		root, _ := hex.DecodeString(t.RootHash)
+		if err = bw.table(root); err != nil {
+			return bw.chunks, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[backup:Backup] %s %s", t.Table, err.Error()))
+		}
+	}
+	binary.Write(bw.w, binary.BigEndian, uint16(0))
+	if err = bw.w.Flush(); err != nil {
+		return bw.chunks, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[backup:Backup] Flush %s", err.Error()))
+	}
+	log.Debug(fmt.Sprintf("[backup:Backup] %d chunks of %s at %x", bw.chunks, tableName, roothash), "trace", u.TraceID())
+	return bw.chunks, nil
+}
+
+// backupWriter writes the chunks reachable from table descriptors, each once
+type backupWriter struct {
+	swarmdb *SwarmDB
+	u       *SWARMDBUser
+	w       *bufio.Writer
+	seen    map[string]bool
+	chunks  int
+}
+
+// add writes the chunk under key unless it was written before; fresh reports whether it was
+func (bw *backupWriter) add(key []byte) (data []byte, fresh bool, err error) {
+	if bw.seen[string(key)] {
+		return nil, false, nil
+	}
+	data, ok, err := bw.swarmdb.dbchunkstore.RetrieveStoredChunk(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		return nil, false, backupError(fmt.Sprintf("[backup:add] chunk %x missing", key), "a chunk of the table is missing from the chunk store")
+	}
+	bw.seen[string(key)] = true
+	binary.Write(bw.w, binary.BigEndian, uint16(len(key)))
+	bw.w.Write(key)
+	binary.Write(bw.w, binary.BigEndian, uint32(len(data)))
+	if _, err = bw.w.Write(data); err != nil {
+		return nil, false, err
+	}
+	bw.chunks++
+	return data, true, nil
+}
+
+func (bw *backupWriter) table(roothash []byte) (err error) {
+	data, fresh, err := bw.add(roothash)
+	if err != nil || !fresh {
+		return err
+	}
+	descriptor, err := bw.swarmdb.dbchunkstore.openStoredChunk(bw.u, data)
+	if err != nil {
+		return err
+	}
+	for i := 2048; i < 4000 && descriptor[i] != 0; i = i + 64 {
+		root := make([]byte, 32)
+		copy(root, descriptor[i+32:i+64])
+		if !valid_hashid(root) {
+			continue
+		}
+		if err = bw.tree(root, ByteToIndexType(descriptor[i+30]), descriptor[i+26] == 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tree writes the index nodes under key and, in the primary index, the rows its leaves point to
+func (bw *backupWriter) tree(key []byte, indexType sdbc.IndexType, primary bool) (err error) {
+	data, fresh, err := bw.add(key)
+	if err != nil || !fresh {
+		return err
+	}
+	buf, err := bw.swarmdb.dbchunkstore.openStoredChunk(bw.u, data)
+	if err != nil {
+		return err
+	}
+	children, rows := indexNodeRefs(buf, indexType)
+	for _, child := range children {
+		if err = bw.tree(child, indexType, primary); err != nil {
+			return err
+		}
+	}
+	if !primary {
+		return nil
+	}
+	for _, row := range rows {
+		// the row and the chain of its archived versions
+		for valid_hashid(row) {
+			data, fresh, err := bw.add(row)
+			if err != nil {
+				return err
+			}
+			header, isRow, _ := storedRowHeader(data)
+			if !fresh || !isRow {
+				break
+			}
+			row = header.PrevVersion
+		}
+	}
+	return nil
+}
+
+// Restore reads an archive written by Backup, stores its chunks and publishes the root hash of the table and its
+// shards, creating the database and the tables where missing.  Open handles of the tables are dropped, so the
+// next request reads the restored rows.
+func (self *SwarmDB) Restore(u *SWARMDBUser, r io.Reader) (header BackupHeader, chunks int, err error) {
+	if err = self.checkWritable(); err != nil {
+		return header, 0, err
+	}
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(BACKUP_MAGIC))
+	if _, err = io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, BACKUP_MAGIC) {
+		return header, 0, backupError("[backup:Restore] bad magic", "not a backup archive")
+	}
+	var n uint32
+	if err = binary.Read(br, binary.BigEndian, &n); err != nil || n > BACKUP_HEADER_MAX {
+		return header, 0, backupError(fmt.Sprintf("[backup:Restore] header length %d", n), "bad header")
+	}
+	out := make([]byte, n)
+	if _, err = io.ReadFull(br, out); err != nil {
+		return header, 0, backupError(fmt.Sprintf("[backup:Restore] header %s", err.Error()), "truncated header")
+	}
+	if err = json.Unmarshal(out, &header); err != nil || len(header.Tables) == 0 {
+		return header, 0, backupError(fmt.Sprintf("[backup:Restore] header %v", err), "bad header")
+	}
+	for {
+		var keyLen uint16
+		if err = binary.Read(br, binary.BigEndian, &keyLen); err != nil {
+			return header, chunks, backupError(fmt.Sprintf("[backup:Restore] chunk %d %s", chunks, err.Error()), "truncated archive")
+		}
+		if keyLen == 0 {
+			break
+		}
+		key := make([]byte, keyLen)
+		var dataLen uint32
+		if _, err = io.ReadFull(br, key); err == nil {
+			err = binary.Read(br, binary.BigEndian, &dataLen)
+		}
+		if err != nil || dataLen > 4*CHUNK_SIZE {
+			return header, chunks, backupError(fmt.Sprintf("[backup:Restore] chunk %d %v", chunks, err), "truncated archive")
+		}
+		data := make([]byte, dataLen)
+		if _, err = io.ReadFull(br, data); err != nil {
+			return header, chunks, backupError(fmt.Sprintf("[backup:Restore] chunk %x %s", key, err.Error()), "truncated archive")
+		}
+		if err = self.dbchunkstore.StoreStoredChunk(key, data); err != nil {
+			return header, chunks, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[backup:Restore] StoreStoredChunk %s", err.Error()))
+		}
+		chunks++
+	}
+
+	if err = self.restoreTables(u, &header); err != nil {
+		return header, chunks, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[backup:Restore] %s", err.Error()))
+	}
+	log.Debug(fmt.Sprintf("[backup:Restore] %d chunks of %s", chunks, header.Table), "trace", u.TraceID())
+	return header, chunks, nil
+}
+
+// restoreTables creates the tables of header missing here and points every one of them at its archived root hash
+func (self *SwarmDB) restoreTables(u *SWARMDBUser, header *BackupHeader) (err error) {
+	databases, err := self.ListDatabases(u, header.Owner)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, row := range databases {
+		found = found || row["database"] == header.Database
+	}
+	if !found {
+		if err = self.CreateDatabase(u, header.Owner, header.Database, header.Encrypted); err != nil {
+			return err
+		}
+	}
+	tables, err := self.ListTables(u, header.Owner, header.Database)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for _, row := range tables {
+		if name, ok := row["table"].(string); ok {
+			existing[name] = true
+		}
+	}
+	for _, t := range header.Tables {
+		roothash, err := hex.DecodeString(t.RootHash)
+		if err != nil || !valid_hashid(roothash) {
+			return backupError(fmt.Sprintf("[backup:restoreTables] %s roothash [%s]", t.Table, t.RootHash), "bad root hash")
+		}
+		if !existing[t.Table] {
+			if _, err = self.CreateTable(u, header.Owner, header.Database, t.Table, header.Columns); err != nil {
+				return err
+			}
+		}
+		if err = self.StoreRootHash(u, []byte(self.GetTableKey(header.Owner, header.Database, t.Table)), nil, roothash); err != nil {
+			return err
+		}
+		self.UnregisterTable(header.Owner, header.Database, t.Table)
+	}
+	return nil
+}
+
+// backup answers ADMIN_BACKUP
+func (self *SwarmDB) backup(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	if len(d.Rows) == 0 {
+		return resp, &sdbc.SWARMDBError{Message: "[backup:backup] missing table", ErrorCode: 418, ErrorMessage: "Request Invalid: Backup requires a table"}
+	}
+	owner, _ := d.Rows[0]["owner"].(string)
+	database, _ := d.Rows[0]["database"].(string)
+	table, _ := d.Rows[0]["table"].(string)
+	var roothash []byte
+	if h, ok := d.Rows[0]["roothash"].(string); ok && len(h) > 0 {
+		if roothash, err = hex.DecodeString(h); err != nil {
+			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[backup:backup] roothash [%s]", h), ErrorCode: 418, ErrorMessage: "Request Invalid: bad root hash"}
+		}
+	}
+	var archive bytes.Buffer
+	chunks, err := self.Backup(u, owner, database, table, roothash, &archive)
+	if err != nil {
+		return resp, err
+	}
+	row := sdbc.NewRow()
+	row["archive"] = base64.StdEncoding.EncodeToString(archive.Bytes())
+	row["chunks"] = chunks
+	return sdbc.SWARMDBResponse{Data: []sdbc.Row{row}, MatchedRowCount: 1}, nil
+}
+
+// restore answers ADMIN_RESTORE
+func (self *SwarmDB) restore(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	var archive string
+	if len(d.Rows) > 0 {
+		archive, _ = d.Rows[0]["archive"].(string)
+	}
+	data, err := base64.StdEncoding.DecodeString(archive)
+	if err != nil || len(data) == 0 {
+		return resp, &sdbc.SWARMDBError{Message: "[backup:restore] missing archive", ErrorCode: 418, ErrorMessage: "Request Invalid: Restore requires a base64 archive"}
+	}
+	header, _, err := self.Restore(u, bytes.NewReader(data))
+	if err != nil {
+		return resp, err
+	}
+	for _, t := range header.Tables {
+		row := sdbc.NewRow()
+		row["owner"] = header.Owner
+		row["database"] = header.Database
+		row["table"] = t.Table
+		row["roothash"] = t.RootHash
+		resp.Data = append(resp.Data, row)
+	}
+	resp.AffectedRowCount = len(resp.Data)
+	return resp, nil
+}
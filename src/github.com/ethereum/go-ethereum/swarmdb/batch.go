@@ -0,0 +1,123 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+)
+
+func isWriteRequest(requestType string) bool {
+	return requestType == sdbc.RT_PUT || requestType == sdbc.RT_DELETE || requestType == wire.RT_INCREMENT || requestType == wire.RT_IMPORT_CSV
+}
+
+// HandleBatch runs reqs in order and returns one response and one error per request.
+// With atomic set, the writes to each table are buffered and flushed once at the end; if any write to a table
+// fails, that table's buffer is discarded (the table is reopened from its last stored root hash) and every
+// write to it in the batch reports ErrorCode 495.  Reads are never rolled back.  A table that is already buffered,
+// by a Transaction, another batch or RT_START_BUFFER, is not written: its buffer belongs to that owner, and every
+// write to it in the batch reports ErrorCode 499, as Begin does.
+func (self *SwarmDB) HandleBatch(u *SWARMDBUser, reqs []*sdbc.RequestOption, atomic bool) (resps []sdbc.SWARMDBResponse, errs []error) {
+	resps = make([]sdbc.SWARMDBResponse, len(reqs))
+	errs = make([]error, len(reqs))
+	if !atomic {
+		for i, req := range reqs {
+			resps[i], errs[i] = self.HandleRequest(u, req)
+		}
+		return resps, errs
+	}
+
+	// buffer every table written in the batch; tables buffered already are left to whoever buffers them
+	buffered := make(map[string]*Table)
+	failed := make(map[string]error)
+	for i, req := range reqs {
+		if !isWriteRequest(req.RequestType) {
+			continue
+		}
+		tblKey := self.GetTableKey(req.Owner, req.Database, req.Table)
+		if _, ok := buffered[tblKey]; ok {
+			continue
+		}
+		if _, ok := failed[tblKey]; ok {
+			continue
+		}
+		tbl, err := self.GetTable(u, req.Owner, req.Database, req.Table)
+		if err == nil && tbl.buffered {
+			failed[tblKey] = &sdbc.SWARMDBError{Message: fmt.Sprintf("[batch:HandleBatch] op %d table %s is already buffered", i, tblKey), ErrorCode: 499, ErrorMessage: "Transaction Conflict: the table is already in a transaction"}
+			continue
+		}
+		if err == nil {
+			err = tbl.StartBuffer(u)
+		}
+		if err != nil {
+			failed[tblKey] = sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[batch:HandleBatch] op %d StartBuffer %s", i, err.Error()))
+			continue
+		}
+		tbl.holdFlush = true
+		buffered[tblKey] = tbl
+	}
+
+	for i, req := range reqs {
+		tblKey := self.GetTableKey(req.Owner, req.Database, req.Table)
+		if isWriteRequest(req.RequestType) {
+			if ferr, ok := failed[tblKey]; ok {
+				errs[i] = abortedError(i, ferr)
+				continue
+			}
+		}
+		resps[i], errs[i] = self.HandleRequest(u, req)
+		if errs[i] != nil && isWriteRequest(req.RequestType) {
+			failed[tblKey] = errs[i]
+		}
+	}
+
+	for tblKey, tbl := range buffered {
+		if ferr, ok := failed[tblKey]; ok {
+			log.Debug(fmt.Sprintf("[batch:HandleBatch] rolling back [%s]: %s", tblKey, ferr.Error()))
+			self.UnregisterTable(tbl.Owner, tbl.Database, tbl.tableName)
+			continue
+		}
+		if err := tbl.FlushBuffer(u); err != nil {
+			failed[tblKey] = sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[batch:HandleBatch] FlushBuffer %s", err.Error()))
+			self.UnregisterTable(tbl.Owner, tbl.Database, tbl.tableName)
+			continue
+		}
+		tbl.buffered, tbl.holdFlush = false, false
+	}
+
+	// writes that succeeded on a table that was rolled back did not happen
+	for i, req := range reqs {
+		if !isWriteRequest(req.RequestType) || errs[i] != nil {
+			continue
+		}
+		if ferr, ok := failed[self.GetTableKey(req.Owner, req.Database, req.Table)]; ok {
+			resps[i] = sdbc.SWARMDBResponse{}
+			errs[i] = abortedError(i, ferr)
+		}
+	}
+	return resps, errs
+}
+
+// abortedError is the error of write i of an atomic batch that did not happen because of cause; conflicts with
+// another buffer are reported as they are
+func abortedError(i int, cause error) error {
+	if isConflict(cause) {
+		return cause
+	}
+	return &sdbc.SWARMDBError{Message: fmt.Sprintf("[batch:HandleBatch] op %d aborted: %s", i, cause.Error()), ErrorCode: 495, ErrorMessage: "Batch Aborted: another write to this table failed"}
+}
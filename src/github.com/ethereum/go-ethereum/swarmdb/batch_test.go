@@ -0,0 +1,109 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb_test
+
+import (
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"testing"
+)
+
+func batch_put(owner string, database string, tableName string, email string, extra string) *sdbc.RequestOption {
+	row := sdbc.NewRow()
+	row["email"] = email
+	row["name"] = "Batch"
+	row["age"] = 1
+	if len(extra) > 0 {
+		row[extra] = "not a column"
+	}
+	return &sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{row}}
+}
+
+func batch_get(owner string, database string, tableName string, email string) *sdbc.RequestOption {
+	return &sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: email}
+}
+
+func TestBatch(t *testing.T) {
+	owner, database, tbl1 := make_table(t, "batch1")
+	_, _, tbl2 := make_owner_table(t, owner, "batch2")
+
+	resps, errs := swarmdb.HandleBatch(u, []*sdbc.RequestOption{
+		batch_put(owner, database, tbl1, "a@wolk.com", ""),
+		batch_put(owner, database, tbl2, "b@wolk.com", ""),
+		batch_get(owner, database, tbl1, "a@wolk.com"),
+	}, false)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("[batch_test:TestBatch] op %d %s", i, err)
+		}
+	}
+	if resps[2].MatchedRowCount != 1 {
+		t.Fatalf("[batch_test:TestBatch] GET in batch returned %s", resps[2].Stringify())
+	}
+
+	// atomic: the bad write to tbl1 rolls back the good one, tbl2 commits
+	_, errs = swarmdb.HandleBatch(u, []*sdbc.RequestOption{
+		batch_put(owner, database, tbl1, "c@wolk.com", ""),
+		batch_put(owner, database, tbl1, "d@wolk.com", "bogus"),
+		batch_put(owner, database, tbl2, "e@wolk.com", ""),
+	}, true)
+	if errs[0] == nil || errs[1] == nil || errs[2] != nil {
+		t.Fatalf("[batch_test:TestBatch] atomic batch errors %v", errs)
+	}
+	if resp, err := swarmdb.HandleRequest(u, batch_get(owner, database, tbl1, "c@wolk.com")); err != nil || resp.MatchedRowCount != 0 {
+		t.Fatalf("[batch_test:TestBatch] rolled back write is visible %v %s", resp, err)
+	}
+	if resp, err := swarmdb.HandleRequest(u, batch_get(owner, database, tbl1, "a@wolk.com")); err != nil || resp.MatchedRowCount != 1 {
+		t.Fatalf("[batch_test:TestBatch] earlier write lost by rollback %v %s", resp, err)
+	}
+	if resp, err := swarmdb.HandleRequest(u, batch_get(owner, database, tbl2, "e@wolk.com")); err != nil || resp.MatchedRowCount != 1 {
+		t.Fatalf("[batch_test:TestBatch] committed write missing %v %s", resp, err)
+	}
+}
+
+func TestBatchOpenTransaction(t *testing.T) {
+	owner, database, tbl1 := make_table(t, "batchtxn1")
+	_, _, tbl2 := make_owner_table(t, owner, "batchtxn2")
+	tx, err := swarmdb.Begin(u, owner, database, tbl1)
+	if err != nil {
+		t.Fatalf("[batch_test:TestBatchOpenTransaction] Begin %s", err)
+	}
+	if _, err = swarmdb.HandleRequest(u, batch_put(owner, database, tbl1, "txn@wolk.com", "")); err != nil {
+		t.Fatalf("[batch_test:TestBatchOpenTransaction] PUT in transaction %s", err)
+	}
+
+	// the batch may neither commit nor discard the writes of the open transaction
+	_, errs := swarmdb.HandleBatch(u, []*sdbc.RequestOption{
+		batch_put(owner, database, tbl1, "batch@wolk.com", ""),
+		batch_put(owner, database, tbl2, "other@wolk.com", ""),
+	}, true)
+	if sErr, ok := errs[0].(*sdbc.SWARMDBError); !ok || sErr.ErrorCode != 499 {
+		t.Fatalf("[batch_test:TestBatchOpenTransaction] write to table in transaction returned %v", errs[0])
+	}
+	if errs[1] != nil {
+		t.Fatalf("[batch_test:TestBatchOpenTransaction] write to other table %s", errs[1])
+	}
+	if err = tx.Rollback(u); err != nil {
+		t.Fatalf("[batch_test:TestBatchOpenTransaction] Rollback %s", err)
+	}
+	for _, email := range []string{"txn@wolk.com", "batch@wolk.com"} {
+		if resp, err := swarmdb.HandleRequest(u, batch_get(owner, database, tbl1, email)); err != nil || resp.MatchedRowCount != 0 {
+			t.Fatalf("[batch_test:TestBatchOpenTransaction] %s visible after rollback %v %s", email, resp, err)
+		}
+	}
+	if resp, err := swarmdb.HandleRequest(u, batch_get(owner, database, tbl2, "other@wolk.com")); err != nil || resp.MatchedRowCount != 1 {
+		t.Fatalf("[batch_test:TestBatchOpenTransaction] committed write missing %v %s", resp, err)
+	}
+}
@@ -0,0 +1,486 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"io"
+)
+
+// A blob is a binary object of any size, cut the way the swarm chunker cuts files into a tree of chunks: leaves hold
+// up to BLOB_LEAF_SIZE bytes of the blob, the nodes above them the hashes of up to BLOB_BRANCHES children, every
+// child but the last of a node covering a full subtree.  Every chunk of the tree starts with a header:
+//
+//	[0:8] span, the bytes of the blob under the chunk, [8] level, 0 for a leaf, [16:] the data or the child hashes
+//
+// and the blob is named by the hash of its manifest chunk:
+//
+//	[0:4] BLOB_MAGIC, [4:12] size of the blob, [12:44] root hash of the tree
+//
+// A table keeps a blob in a CT_STRING column holding the manifest hash in hex, see Table.PutBlob.  Clients upload
+// a blob in parts of BLOB_PART_SIZE bytes, each of which is a full subtree of level 1 (RT_PUT_BLOB_PART), and then
+// name the parts to join them into one tree (RT_PUT_BLOB), so that no request carries more than one part.
+const (
+	BLOB_MAGIC     = "blb\x01"
+	BLOB_HEADER    = 16
+	BLOB_LEAF_SIZE = CHUNK_SIZE - BLOB_HEADER
+	BLOB_BRANCHES  = BLOB_LEAF_SIZE / 32
+	BLOB_PART_SIZE = wire.BLOB_PART_SIZE // BLOB_LEAF_SIZE * BLOB_BRANCHES
+)
+
+// blobNode is a chunk of the tree of a blob
+type blobNode struct {
+	start   int64 // offset in the blob of the first byte under the node
+	span    int64
+	level   int
+	payload []byte
+}
+
+func (n *blobNode) covers(off int64) bool {
+	return off >= n.start && off < n.start+n.span
+}
+
+// blobChildSpan is the span of a full child of a node of the given level
+func blobChildSpan(level int) (span int64) {
+	span = BLOB_LEAF_SIZE
+	for i := 1; i < level; i++ {
+		span *= BLOB_BRANCHES
+	}
+	return span
+}
+
+func (self *SwarmDB) storeBlobNode(u *SWARMDBUser, level int, span int64, payload []byte, encrypted int) (hash []byte, err error) {
+	buf := getChunkBuffer()
+	defer releaseChunkBuffer(buf)
+	binary.BigEndian.PutUint64(buf[0:8], uint64(span))
+	buf[8] = byte(level)
+	copy(buf[BLOB_HEADER:], payload)
+	if hash, err = self.StoreDBChunk(u, buf, encrypted); err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:storeBlobNode] StoreDBChunk %s", err.Error()))
+	}
+	return hash, nil
+}
+
+func (self *SwarmDB) retrieveBlobNode(u *SWARMDBUser, hash []byte) (n *blobNode, err error) {
+	buf, err := self.RetrieveDBChunk(u, hash)
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:retrieveBlobNode] RetrieveDBChunk %s", err.Error()))
+	}
+	if len(buf) < CHUNK_SIZE {
+		return nil, blobInvalid(hash)
+	}
+	n = &blobNode{span: int64(binary.BigEndian.Uint64(buf[0:8])), level: int(buf[8]), payload: buf[BLOB_HEADER:CHUNK_SIZE]}
+	if n.span < 0 || (n.level == 0 && n.span > BLOB_LEAF_SIZE) || (n.level > 0 && (n.span+blobChildSpan(n.level)-1)/blobChildSpan(n.level) > BLOB_BRANCHES) {
+		return nil, blobInvalid(hash)
+	}
+	return n, nil
+}
+
+func blobInvalid(hash []byte) error {
+	return &sdbc.SWARMDBError{Message: fmt.Sprintf("[blob:retrieveBlobNode] blob chunk %x", hash), ErrorCode: 439, ErrorMessage: "Unable to Parse Chunk: not a blob"}
+}
+
+// storeBlobPart stores data, of up to BLOB_PART_SIZE bytes, as a subtree of level 1 and returns its hash
+func (self *SwarmDB) storeBlobPart(u *SWARMDBUser, data []byte, encrypted int) (part []byte, err error) {
+	if len(data) > BLOB_PART_SIZE {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[blob:storeBlobPart] %d bytes", len(data)), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: a blob part holds at most %d bytes", BLOB_PART_SIZE)}
+	}
+	var children []byte
+	for start := 0; start == 0 || start < len(data); start += BLOB_LEAF_SIZE {
+		end := start + BLOB_LEAF_SIZE
+		if end > len(data) {
+			end = len(data)
+		}
+		leaf, err := self.storeBlobNode(u, 0, int64(end-start), data[start:end], encrypted)
+		if err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:storeBlobPart] storeBlobNode %s", err.Error()))
+		}
+		children = append(children, leaf...)
+	}
+	return self.storeBlobNode(u, 1, int64(len(data)), children, encrypted)
+}
+
+// commitBlob joins the parts storeBlobPart stored, in order, into one tree and stores its manifest; every part but
+// the last must be full
+func (self *SwarmDB) commitBlob(u *SWARMDBUser, parts [][]byte, encrypted int) (manifest []byte, size int64, err error) {
+	if len(parts) == 0 {
+		return nil, 0, &sdbc.SWARMDBError{Message: "[blob:commitBlob] no parts", ErrorCode: 418, ErrorMessage: "Request Invalid: a blob needs a part"}
+	}
+	spans := make([]int64, len(parts))
+	for i, part := range parts {
+		n, err := self.retrieveBlobNode(u, part)
+		if err != nil {
+			return nil, 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:commitBlob] retrieveBlobNode %s", err.Error()))
+		}
+		if n.level != 1 || (i < len(parts)-1 && n.span != BLOB_PART_SIZE) {
+			return nil, 0, &sdbc.SWARMDBError{Message: fmt.Sprintf("[blob:commitBlob] part %d of %d: level %d span %d", i, len(parts), n.level, n.span), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: every blob part but the last must hold %d bytes", BLOB_PART_SIZE)}
+		}
+		spans[i] = n.span
+	}
+	for level := 2; len(parts) > 1; level++ {
+		var upper [][]byte
+		var upperSpans []int64
+		for start := 0; start < len(parts); start += BLOB_BRANCHES {
+			end := start + BLOB_BRANCHES
+			if end > len(parts) {
+				end = len(parts)
+			}
+			var span int64
+			for _, s := range spans[start:end] {
+				span += s
+			}
+			hash, err := self.storeBlobNode(u, level, span, bytes.Join(parts[start:end], nil), encrypted)
+			if err != nil {
+				return nil, 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:commitBlob] storeBlobNode %s", err.Error()))
+			}
+			upper = append(upper, hash)
+			upperSpans = append(upperSpans, span)
+		}
+		parts, spans = upper, upperSpans
+	}
+
+	buf := getChunkBuffer()
+	defer releaseChunkBuffer(buf)
+	copy(buf[0:4], BLOB_MAGIC)
+	binary.BigEndian.PutUint64(buf[4:12], uint64(spans[0]))
+	copy(buf[12:44], parts[0])
+	if manifest, err = self.StoreDBChunk(u, buf, encrypted); err != nil {
+		return nil, 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:commitBlob] StoreDBChunk %s", err.Error()))
+	}
+	return manifest, spans[0], nil
+}
+
+// StoreBlob stores everything read from r as a blob and returns the hash of its manifest and its size
+func (self *SwarmDB) StoreBlob(u *SWARMDBUser, r io.Reader, encrypted int) (manifest []byte, size int64, err error) {
+	var parts [][]byte
+	data := make([]byte, BLOB_PART_SIZE)
+	for {
+		n, rerr := io.ReadFull(r, data)
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			return nil, 0, &sdbc.SWARMDBError{Message: fmt.Sprintf("[blob:StoreBlob] Read %s", rerr.Error()), ErrorCode: 418, ErrorMessage: "Request Invalid: unable to read the blob"}
+		}
+		if n > 0 || len(parts) == 0 {
+			part, err := self.storeBlobPart(u, data[:n], encrypted)
+			if err != nil {
+				return nil, 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:StoreBlob] storeBlobPart %s", err.Error()))
+			}
+			parts = append(parts, part)
+		}
+		if n < len(data) {
+			break
+		}
+	}
+	return self.commitBlob(u, parts, encrypted)
+}
+
+// BlobReader reads a blob, retrieving its chunks as they are read
+type BlobReader struct {
+	swarmdb  *SwarmDB
+	u        *SWARMDBUser
+	Manifest []byte
+	size     int64
+	off      int64
+	path     []*blobNode // from the root to the leaf read last
+}
+
+// OpenBlob opens the blob with the given manifest hash
+func (self *SwarmDB) OpenBlob(u *SWARMDBUser, manifest []byte) (br *BlobReader, err error) {
+	buf, err := self.RetrieveDBChunk(u, manifest)
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:OpenBlob] RetrieveDBChunk %s", err.Error()))
+	}
+	if len(buf) < 44 || string(buf[0:4]) != BLOB_MAGIC {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[blob:OpenBlob] manifest %x", manifest), ErrorCode: 439, ErrorMessage: "Unable to Parse Chunk: not a blob manifest"}
+	}
+	root, err := self.retrieveBlobNode(u, buf[12:44])
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:OpenBlob] retrieveBlobNode %s", err.Error()))
+	}
+	return &BlobReader{swarmdb: self, u: u, Manifest: manifest, size: root.span, path: []*blobNode{root}}, nil
+}
+
+// Size returns the size of the blob in bytes
+func (br *BlobReader) Size() int64 {
+	return br.size
+}
+
+// leaf returns the leaf holding the byte at off, retrieving only the chunks below the last one read that covers it
+func (br *BlobReader) leaf(off int64) (n *blobNode, err error) {
+	i := len(br.path)
+	for i > 1 && !br.path[i-1].covers(off) {
+		i--
+	}
+	br.path = br.path[:i]
+	n = br.path[i-1]
+	for n.level > 0 {
+		span := blobChildSpan(n.level)
+		c := (off - n.start) / span
+		child, err := br.swarmdb.retrieveBlobNode(br.u, n.payload[c*32:c*32+32])
+		if err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:leaf] retrieveBlobNode %s", err.Error()))
+		}
+		if child.level != n.level-1 {
+			return nil, blobInvalid(n.payload[c*32 : c*32+32])
+		}
+		child.start = n.start + c*span
+		br.path = append(br.path, child)
+		n = child
+	}
+	return n, nil
+}
+
+// ReadAt implements io.ReaderAt
+func (br *BlobReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, &sdbc.SWARMDBError{Message: fmt.Sprintf("[blob:ReadAt] offset %d", off), ErrorCode: 418, ErrorMessage: "Request Invalid: negative blob offset"}
+	}
+	for n < len(p) && off < br.size {
+		leaf, err := br.leaf(off)
+		if err != nil {
+			return n, err
+		}
+		m := copy(p[n:], leaf.payload[off-leaf.start:leaf.span])
+		n += m
+		off += int64(m)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read implements io.Reader
+func (br *BlobReader) Read(p []byte) (n int, err error) {
+	if br.off >= br.size {
+		return 0, io.EOF
+	}
+	n, err = br.ReadAt(p, br.off)
+	br.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker
+func (br *BlobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += br.off
+	case io.SeekEnd:
+		offset += br.size
+	}
+	if offset < 0 {
+		return br.off, &sdbc.SWARMDBError{Message: fmt.Sprintf("[blob:Seek] offset %d", offset), ErrorCode: 418, ErrorMessage: "Request Invalid: negative blob offset"}
+	}
+	br.off = offset
+	return offset, nil
+}
+
+// blobColumn checks that the named column can hold the manifest hash of a blob
+func (t *Table) blobColumn(columnName string) (err error) {
+	c, ok := t.columns[columnName]
+	if !ok {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[blob:blobColumn] unknown column %s", columnName), ErrorCode: 404, ErrorMessage: fmt.Sprintf("Column Does Not Exist in table definition: [%s]", columnName)}
+	}
+	if c.primary > 0 || c.columnType != sdbc.CT_STRING {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[blob:blobColumn] column %s cannot hold a blob", columnName), ErrorCode: 501, ErrorMessage: fmt.Sprintf("Column [%s] is not a string column to hold a blob", columnName)}
+	}
+	return nil
+}
+
+// getRow returns the row with the given primary key, an empty row when there is none
+func (t *Table) getRow(u *SWARMDBUser, key interface{}) (row sdbc.Row, ok bool, err error) {
+	primary, err := t.getPrimaryColumn()
+	if err != nil {
+		return row, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:getRow] getPrimaryColumn %s", err.Error()))
+	}
+	k, err := convertJSONValueToKey(primary.columnType, key)
+	if err != nil {
+		return row, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:getRow] convertJSONValueToKey %s", err.Error()))
+	}
+	byteRow, ok, err := t.Get(u, k)
+	if err != nil {
+		return row, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:getRow] Get %s", err.Error()))
+	}
+	if !ok {
+		return sdbc.NewRow(), false, nil
+	}
+	if row, err = t.byteArrayToRow(byteRow); err != nil {
+		return row, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:getRow] byteArrayToRow %s", err.Error()))
+	}
+	return row, true, nil
+}
+
+// setBlob stores manifest in the blob column of the row with the given primary key, creating the row if needed
+func (t *Table) setBlob(u *SWARMDBUser, key interface{}, columnName string, manifest []byte) (err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	row, _, err := t.getRow(u, key)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:setBlob] getRow %s", err.Error()))
+	}
+	row[t.primaryColumnName] = key
+	row[columnName] = fmt.Sprintf("%x", manifest)
+	if err = t.put(u, row); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:setBlob] put %s", err.Error()))
+	}
+	return nil
+}
+
+// PutBlob stores everything read from r as a blob and keeps its manifest hash in the named CT_STRING column of the
+// row with the given primary key, creating the row if needed; other columns of the row are left as they are
+func (t *Table) PutBlob(u *SWARMDBUser, key interface{}, columnName string, r io.Reader) (manifest []byte, err error) {
+	if err = t.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err = t.blobColumn(columnName); err != nil {
+		return nil, err
+	}
+	manifest, _, err = t.swarmdb.StoreBlob(u, r, t.encrypted)
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:PutBlob] StoreBlob %s", err.Error()))
+	}
+	if err = t.setBlob(u, key, columnName, manifest); err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:PutBlob] setBlob %s", err.Error()))
+	}
+	return manifest, nil
+}
+
+// GetBlob opens the blob kept in the named column of the row with the given primary key, if there is one
+func (t *Table) GetBlob(u *SWARMDBUser, key interface{}, columnName string) (br *BlobReader, ok bool, err error) {
+	if err = t.blobColumn(columnName); err != nil {
+		return nil, false, err
+	}
+	row, ok, err := t.getRow(u, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	value, _ := row[columnName].(string)
+	manifest, err := hex.DecodeString(value)
+	if err != nil || len(manifest) != 32 {
+		return nil, false, nil
+	}
+	if br, err = t.swarmdb.OpenBlob(u, manifest); err != nil {
+		return nil, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:GetBlob] OpenBlob %s", err.Error()))
+	}
+	return br, true, nil
+}
+
+// blobRequest runs RT_PUT_BLOB_PART, RT_PUT_BLOB and RT_GET_BLOB requests, see swarmdbwire
+func (self *SwarmDB) blobRequest(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:blobRequest] GetTable %s", err.Error()))
+	}
+	arg := sdbc.NewRow()
+	if len(d.Rows) > 0 {
+		arg = d.Rows[0]
+	}
+	if d.RequestType == wire.RT_GET_BLOB {
+		hidden, err := tbl.checkRead(u)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:blobRequest] checkRead %s", err.Error()))
+		}
+		columnName, _ := arg["column"].(string)
+		if hidden[columnName] {
+			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[blob:blobRequest] column %s is restricted", columnName), ErrorCode: 490, ErrorMessage: fmt.Sprintf("Access Denied to Column [%s]", columnName)}
+		}
+	} else {
+		if err = tbl.checkAccess(u, ACL_WRITE); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:blobRequest] checkAccess %s", err.Error()))
+		}
+		if err = tbl.checkWritable(); err != nil {
+			return resp, err
+		}
+	}
+
+	switch d.RequestType {
+	case wire.RT_PUT_BLOB_PART:
+		encoded, _ := arg["data"].(string)
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[blob:blobRequest] data %s", err.Error()), ErrorCode: 418, ErrorMessage: "Request Invalid: PutBlobPart needs Rows[0] {\"data\": base64}"}
+		}
+		part, err := self.storeBlobPart(u, data, tbl.encrypted)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:blobRequest] storeBlobPart %s", err.Error()))
+		}
+		resp.Data = []sdbc.Row{{"part": fmt.Sprintf("%x", part)}}
+
+	case wire.RT_PUT_BLOB:
+		if isNil(d.Key) {
+			return resp, &sdbc.SWARMDBError{Message: "[blob:blobRequest] missing key", ErrorCode: 433, ErrorMessage: "PutBlob Request Missing Key"}
+		}
+		columnName, _ := arg["column"].(string)
+		if err = tbl.blobColumn(columnName); err != nil {
+			return resp, err
+		}
+		list, _ := arg["parts"].([]interface{})
+		parts := make([][]byte, 0, len(list))
+		for _, p := range list {
+			s, _ := p.(string)
+			part, err := hex.DecodeString(s)
+			if err != nil || len(part) != 32 {
+				return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[blob:blobRequest] part %v", p), ErrorCode: 418, ErrorMessage: "Request Invalid: PutBlob needs Rows[0] {\"column\", \"parts\": [hex hashes]}"}
+			}
+			parts = append(parts, part)
+		}
+		manifest, size, err := self.commitBlob(u, parts, tbl.encrypted)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:blobRequest] commitBlob %s", err.Error()))
+		}
+		if err = tbl.setBlob(u, d.Key, columnName, manifest); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:blobRequest] setBlob %s", err.Error()))
+		}
+		resp.AffectedRowCount = 1
+		resp.Data = []sdbc.Row{{"manifest": fmt.Sprintf("%x", manifest), "size": int(size)}}
+
+	case wire.RT_GET_BLOB:
+		columnName, _ := arg["column"].(string)
+		br, ok, err := tbl.GetBlob(u, d.Key, columnName)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:blobRequest] GetBlob %s", err.Error()))
+		}
+		if !ok {
+			return resp, nil
+		}
+		offset, _ := toFloat(arg["offset"])
+		length := BLOB_PART_SIZE
+		if n, ok := toFloat(arg["length"]); ok && n > 0 && n < BLOB_PART_SIZE {
+			length = int(n)
+		}
+		if int64(offset) < br.Size() && int64(length) > br.Size()-int64(offset) {
+			length = int(br.Size() - int64(offset))
+		} else if int64(offset) >= br.Size() {
+			length = 0
+		}
+		data := make([]byte, length)
+		n, err := br.ReadAt(data, int64(offset))
+		if err != nil && err != io.EOF {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[blob:blobRequest] ReadAt %s", err.Error()))
+		}
+		resp.Data = []sdbc.Row{{"manifest": fmt.Sprintf("%x", br.Manifest), "size": int(br.Size()), "offset": int(offset), "data": base64.StdEncoding.EncodeToString(data[:n])}}
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
@@ -58,17 +58,17 @@
 // and the top level node of the B+ tree (and HashDB) is kept in a table root (itself updated with ENS)
 
 // Loads up the top level node of the B+ tree only
-// X nodes are SWARMDB chunks with 32 key-hashid combinations (64 bytes)
+// X nodes are SWARMDB chunks with key-hashid entries, as many as their keys leave room for (see NODE_ENTRIES_SIZE)
 //   0: key - hashid
 // ...
-//  31: key - hashid
+//   n: key - hashid
 // where each hashid points to another X or D node
 // at the bottom of each X node is the "parent" type and the "child type"
 
-// D nodes are SWARMDB chunks with 32 key-hashid combinations (64 bytes)
+// D nodes are SWARMDB chunks with key-hashid entries in the same way
 //   0: key - hashid
 // ...
-//  31: key - hashid
+//   n: key - hashid
 // The hashid actually point to K nodes where raw records are stored (see kademliadb.go)
 // At the bottom of each D  are prev/next chunk pointers
 
@@ -109,14 +109,32 @@ type Tree struct {
 	hashid            []byte
 }
 
+// A node is stored in one chunk.  From the start of the chunk it holds the number of its entries, then each entry as
+// the lengths of its key and value followed by both: the hash of a child node in X nodes, the hash of a K-chunk (or, in
+// a secondary index, a primary key) in D nodes.  The entries end within the NODE_ENTRIES_SIZE bytes the chunk key is
+// computed from; the last NODE_HEADER_SIZE bytes hold the layout of the chunk, its child and node types and, in D
+// nodes, the hashes of the previous and next leaf.  Keys shorter than K_SIZE are zero padded to it in memory, values
+// to V_SIZE, and stored without the padding, so the fanout follows from the sizes of the keys: some 80 integer or short
+// string keys fit a node, fewer long ones, up to K_SIZE_MAX bytes.  A leaf that no longer fits its chunk is split in
+// two of about the same size, an X node already once another entry of NODE_ENTRY_MAX bytes may not fit, and a node
+// under NODE_UNDERFLOW_SIZE bytes is merged with or refilled from a sibling.  Chunks written in the earlier layout, of
+// LEGACY_KEYS_PER_CHUNK slots of K_SIZE and V_SIZE bytes, are still read.
 const (
-	kx             = 3
-	kd             = 3
-	KEYS_PER_CHUNK = 32 // TODO - OPTIMIZE THIS based on X + D node Chunk structur
-	KV_SIZE        = 64
-	K_SIZE         = 32 // TODO - WHAT allows for bigger keys?
-	V_SIZE         = 32
-	HASH_SIZE      = 32
+	K_SIZE     = 32
+	K_SIZE_MAX = 256
+	V_SIZE     = 32
+	HASH_SIZE  = 32
+
+	NODE_ENTRIES_SIZE   = hashChunkSize
+	NODE_ENTRIES_MAX    = 128
+	NODE_ENTRY_OVERHEAD = 4
+	NODE_ENTRY_MAX      = NODE_ENTRY_OVERHEAD + K_SIZE_MAX + HASH_SIZE
+	NODE_UNDERFLOW_SIZE = NODE_ENTRIES_SIZE / 4
+	NODE_HEADER_SIZE    = 2*HASH_SIZE + 3
+	NODE_LAYOUT_SIZED   = 1
+
+	LEGACY_KV_SIZE        = K_SIZE + V_SIZE
+	LEGACY_KEYS_PER_CHUNK = (CHUNK_SIZE - 2*HASH_SIZE - 2) / LEGACY_KV_SIZE
 )
 
 type (
@@ -130,7 +148,7 @@ type (
 
 	d struct { // data page
 		c int
-		d [2*kd + 1]de
+		d [NODE_ENTRIES_MAX + 1]de
 		n *d
 		p *d
 
@@ -174,7 +192,7 @@ type (
 
 	x struct {
 		c int
-		x [2*kx + 2]xe
+		x [NODE_ENTRIES_MAX + 1]xe
 
 		// used in open, insert, delete
 		hashid    []byte
@@ -183,16 +201,6 @@ type (
 	}
 )
 
-func init() {
-	if kd < 1 {
-		panic(fmt.Errorf("kd %d: out of range", kd))
-	}
-
-	if kx < 2 {
-		panic(fmt.Errorf("kx %d: out of range", kx))
-	}
-}
-
 var (
 	btDPool = sync.Pool{New: func() interface{} { return &d{} }}
 	btEPool = btEpool{sync.Pool{New: func() interface{} { return &Enumerator{} }}}
@@ -286,6 +294,49 @@ func (q *x) siblings(i int) (l, r *d) {
 	return
 }
 
+// size is the number of bytes q takes in its chunk
+func (q *x) size() (n int) {
+	n = 2
+	for i := 0; i <= q.c; i++ {
+		n += q.entrySize(i)
+	}
+	return n
+}
+
+// entrySize is the number of bytes child i takes in the chunk of q; the last child has no key
+func (q *x) entrySize(i int) int {
+	if i == q.c {
+		return NODE_ENTRY_OVERHEAD + HASH_SIZE
+	}
+	return NODE_ENTRY_OVERHEAD + len(storedBytes(q.x[i].k, K_SIZE)) + HASH_SIZE
+}
+
+// full reports whether q may not have room for another entry
+func (q *x) full() bool {
+	return q.c+2 > NODE_ENTRIES_MAX || q.size()+NODE_ENTRY_MAX > NODE_ENTRIES_SIZE
+}
+
+func (q *x) underfull() bool {
+	return q.c+1 < NODE_ENTRIES_MAX/4 && q.size() < NODE_UNDERFLOW_SIZE
+}
+
+// fits reports whether l, the separator sep and r make one X node that is not full
+func (l *x) fits(r *x, sep []byte) bool {
+	return l.c+r.c+3 <= NODE_ENTRIES_MAX && l.size()+r.size()-2+len(storedBytes(sep, K_SIZE))+NODE_ENTRY_MAX <= NODE_ENTRIES_SIZE
+}
+
+// half is the index of the key of q that splits it in two of about the same size
+func (q *x) half() int {
+	size, n := q.size(), 2
+	for i := 0; i < q.c-1; i++ {
+		n += q.entrySize(i)
+		if 2*n >= size {
+			return i + 1
+		}
+	}
+	return q.c - 1
+}
+
 // -------------------------------------------------------------------------- d
 
 func (l *d) mvL(r *d, c int) {
@@ -302,6 +353,40 @@ func (l *d) mvR(r *d, c int) {
 	l.c -= c
 }
 
+// size is the number of bytes q takes in its chunk
+func (q *d) size() (n int) {
+	n = 2
+	for i := 0; i < q.c; i++ {
+		n += entrySize(q.d[i].k, q.d[i].v)
+	}
+	return n
+}
+
+func (q *d) overfull() bool {
+	return q.c > NODE_ENTRIES_MAX || q.size() > NODE_ENTRIES_SIZE
+}
+
+func (q *d) underfull() bool {
+	return q.c < NODE_ENTRIES_MAX/4 && q.size() < NODE_UNDERFLOW_SIZE
+}
+
+// fits reports whether the entries of l and r fit one chunk
+func (l *d) fits(r *d) bool {
+	return l.c+r.c <= NODE_ENTRIES_MAX && l.size()+r.size()-2 <= NODE_ENTRIES_SIZE
+}
+
+// half is the index of the first entry of the upper of two halves of q of about the same size
+func (q *d) half() int {
+	size, n := q.size(), 2
+	for i := 0; i < q.c-1; i++ {
+		n += entrySize(q.d[i].k, q.d[i].v)
+		if 2*n >= size {
+			return i + 1
+		}
+	}
+	return q.c - 1
+}
+
 // ----------------------------------------------------------------------- Tree
 
 // BPlusTree returns a newly created, empty Tree. The compare function is used for key collation.
@@ -324,7 +409,7 @@ func NewBPlusTreeDB(u *SWARMDBUser, swarmdb *SwarmDB, hashid []byte, columnType
 	}
 
 	switch columnType {
-	case sdbc.CT_BLOB:
+	case sdbc.CT_BLOB, CT_UUID:
 		t = btTPool.get(cmpBytes, cmpPrimary)
 	case sdbc.CT_FLOAT:
 		t = btTPool.get(cmpFloat, cmpPrimary)
@@ -422,6 +507,89 @@ func set_chunk_childtype(buf []byte, nodetype string) {
 	copy(buf[CHUNK_SIZE-66:], []byte(nodetype))
 }
 
+// --
+func get_chunk_layout(buf []byte) byte {
+	return buf[CHUNK_SIZE-NODE_HEADER_SIZE]
+}
+
+func set_chunk_layout(buf []byte, layout byte) {
+	buf[CHUNK_SIZE-NODE_HEADER_SIZE] = layout
+}
+
+// get_chunk_entries returns the entries of the node chunk buf, in either layout, their keys and values padded
+func get_chunk_entries(buf []byte) (entries []de) {
+	if get_chunk_layout(buf) != NODE_LAYOUT_SIZED {
+		for i := 0; i < LEGACY_KEYS_PER_CHUNK; i++ {
+			k := buf[i*LEGACY_KV_SIZE : i*LEGACY_KV_SIZE+K_SIZE]
+			hashid := buf[i*LEGACY_KV_SIZE+K_SIZE : (i+1)*LEGACY_KV_SIZE]
+			if valid_hashid(hashid) {
+				entries = append(entries, de{k: paddedBytes(k, K_SIZE), v: paddedBytes(hashid, V_SIZE)})
+			}
+		}
+		return entries
+	}
+	n := int(binary.BigEndian.Uint16(buf))
+	off := 2
+	for i := 0; i < n && off+NODE_ENTRY_OVERHEAD <= NODE_ENTRIES_SIZE; i++ {
+		klen := int(binary.BigEndian.Uint16(buf[off:]))
+		vlen := int(binary.BigEndian.Uint16(buf[off+2:]))
+		off += NODE_ENTRY_OVERHEAD
+		if off+klen+vlen > NODE_ENTRIES_SIZE {
+			break
+		}
+		entries = append(entries, de{k: paddedBytes(buf[off:off+klen], K_SIZE), v: paddedBytes(buf[off+klen:off+klen+vlen], V_SIZE)})
+		off += klen + vlen
+	}
+	return entries
+}
+
+// put_chunk_entry writes the entry k, v at off of the node chunk buf and returns the offset after it
+func put_chunk_entry(buf []byte, off int, k []byte, v []byte) int {
+	k, v = storedBytes(k, K_SIZE), storedBytes(v, V_SIZE)
+	binary.BigEndian.PutUint16(buf[off:], uint16(len(k)))
+	binary.BigEndian.PutUint16(buf[off+2:], uint16(len(v)))
+	off += NODE_ENTRY_OVERHEAD
+	off += copy(buf[off:], k)
+	return off + copy(buf[off:], v)
+}
+
+// storedBytes is b as a node chunk stores it, without the zero padding up to size
+func storedBytes(b []byte, size int) []byte {
+	if len(b) > size {
+		return b
+	}
+	return bytes.TrimRight(b, "\x00")
+}
+
+// paddedBytes is a copy of b zero padded to size
+func paddedBytes(b []byte, size int) []byte {
+	n := size
+	if len(b) > n {
+		n = len(b)
+	}
+	p := make([]byte, n)
+	copy(p, b)
+	return p
+}
+
+// entrySize is the number of bytes the entry k, v takes in a node chunk
+func entrySize(k []byte, v []byte) int {
+	return NODE_ENTRY_OVERHEAD + len(storedBytes(k, K_SIZE)) + len(storedBytes(v, V_SIZE))
+}
+
+// treeKey is key as the tree holds it, zero padded to K_SIZE
+func treeKey(key []byte) []byte {
+	return paddedBytes(key, K_SIZE)
+}
+
+// checkEntrySize refuses a key or value too large for a node entry
+func checkEntrySize(op string, key []byte, v []byte) error {
+	if len(key) <= K_SIZE_MAX && len(v) <= K_SIZE_MAX {
+		return nil
+	}
+	return &sdbc.SWARMDBError{Message: fmt.Sprintf("[bplus:%s] key of %d bytes, value of %d bytes, limit %d", op, len(key), len(v), K_SIZE_MAX), ErrorCode: KEY_TOO_LARGE, ErrorMessage: fmt.Sprintf("Key Too Large: index keys and values may be at most %d bytes", K_SIZE_MAX)}
+}
+
 func (t *Tree) GetRootHash() (hashid []byte) {
 	return t.hashid
 }
@@ -443,60 +611,18 @@ func (t *Tree) swarmGet(u *SWARMDBUser) (success bool, err error) {
 		return false, sdbc.GenerateSWARMDBError(err, `[bplus:swarmGet] RetrieveDBChunk `+err.Error())
 	}
 
-	// two bytes
-	nodetype := get_chunk_nodetype(buf)
-	childtype := get_chunk_childtype(buf)
-	if nodetype == "X" {
+	if get_chunk_nodetype(buf) == "X" {
 		// create X node
-		t.r = btXPool.Get().(*x)
-		switch z := t.r.(type) {
-		case (*x):
-			z.hashid = t.hashid
-			for i := 0; i < KEYS_PER_CHUNK; i++ {
-				k := buf[i*KV_SIZE : i*KV_SIZE+K_SIZE]
-				hashid := buf[i*KV_SIZE+K_SIZE : i*KV_SIZE+KV_SIZE]
-				if valid_hashid(hashid) && i < 2*kx+2 { //
-					z.c++
-					if childtype == "X" {
-						x := btXPool.Get().(*x)
-						z.x[i].ch = x
-						z.x[i].k = k
-						x.notloaded = true
-						x.hashid = hashid
-					} else if childtype == "D" {
-						x := btDPool.Get().(*d)
-						z.x[i].ch = x
-						z.x[i].k = k
-						x.notloaded = true
-						x.hashid = hashid
-					}
-				}
-			}
-		}
+		z := btXPool.Get().(*x)
+		z.hashid = t.hashid
+		z.load(buf)
+		t.r = z
 	} else {
 		// create D node
-		t.r = btDPool.Get().(*d)
-		switch z := t.r.(type) {
-		case (*d):
-			z.hashid = t.hashid
-			for i := 0; i < KEYS_PER_CHUNK; i++ {
-				k := buf[i*KV_SIZE : i*KV_SIZE+K_SIZE]
-				hashid := buf[i*KV_SIZE+K_SIZE : i*KV_SIZE+KV_SIZE]
-				if valid_hashid(hashid) && i < 2*kd {
-					z.c++
-					x := btDPool.Get().(*d)
-					z.d[i].k = k
-					z.d[i].v = hashid
-					x.notloaded = true
-					x.hashid = hashid
-				}
-			}
-		}
-	}
-
-	switch z := t.r.(type) {
-	case (*x):
-		z.c--
+		z := btDPool.Get().(*d)
+		z.hashid = t.hashid
+		z.load(buf)
+		t.r = z
 	}
 	return true, nil
 }
@@ -514,6 +640,7 @@ func valid_hashid(hashid []byte) (valid bool) {
 
 func (q *x) swarmGet(u *SWARMDBUser, swarmdb DBChunkstorage) (success bool, err error) {
 	if q.notloaded {
+		log.Trace("[bplus:swarmGet] load XNode", "trace", u.TraceID(), "hashid", fmt.Sprintf("%x", q.hashid))
 	} else {
 		return false, nil
 	}
@@ -523,36 +650,38 @@ func (q *x) swarmGet(u *SWARMDBUser, swarmdb DBChunkstorage) (success bool, err
 	if err != nil {
 		return false, sdbc.GenerateSWARMDBError(err, `[bplus:swarmGet] RetrieveDBChunk `+err.Error())
 	}
+	q.load(buf)
+	return true, nil
+}
 
+// load fills q with the children in its chunk buf, none of them loaded yet
+func (q *x) load(buf []byte) {
 	childtype := get_chunk_childtype(buf)
-	for i := 0; i < KEYS_PER_CHUNK; i++ {
-		if i < 2*kx+2 {
-			k := buf[i*KV_SIZE : i*KV_SIZE+K_SIZE]
-			hashid := buf[i*KV_SIZE+K_SIZE : i*KV_SIZE+KV_SIZE]
-			if valid_hashid(hashid) {
-				if childtype == "X" {
-					x := btXPool.Get().(*x)
-					q.x[i].ch = x
-					q.x[i].k = k
-					x.notloaded = true
-					x.hashid = hashid
-				} else if childtype == "D" {
-					q.c++
-					x := btDPool.Get().(*d)
-					q.x[i].ch = x
-					q.x[i].k = []byte(k)
-					x.notloaded = true
-					x.hashid = hashid
-				}
-			}
+	entries := get_chunk_entries(buf)
+	if len(entries) > len(q.x) {
+		entries = entries[:len(q.x)]
+	}
+	for i, e := range entries {
+		if childtype == "X" {
+			x := btXPool.Get().(*x)
+			x.notloaded = true
+			x.hashid = e.v
+			q.x[i].ch = x
+		} else {
+			x := btDPool.Get().(*d)
+			x.notloaded = true
+			x.hashid = e.v
+			q.x[i].ch = x
 		}
+		q.x[i].k = e.k
 	}
+	q.c = len(entries) - 1
 	q.notloaded = false
-	return true, nil
 }
 
 func (q *d) swarmGet(u *SWARMDBUser, swarmdb DBChunkstorage) (success bool, err error) {
 	if q.notloaded {
+		log.Trace("[bplus:swarmGet] load DNode", "trace", u.TraceID(), "hashid", fmt.Sprintf("%x", q.hashid))
 	} else {
 		return false, nil
 	}
@@ -562,21 +691,20 @@ func (q *d) swarmGet(u *SWARMDBUser, swarmdb DBChunkstorage) (success bool, err
 	if err != nil {
 		return false, sdbc.GenerateSWARMDBError(err, `[bplus:swarmGet] RetrieveDBChunk `+err.Error())
 	}
-	for i := 0; i < KEYS_PER_CHUNK; i++ {
-		k := buf[i*KV_SIZE : i*KV_SIZE+K_SIZE]
-		hashid := buf[i*KV_SIZE+K_SIZE : i*KV_SIZE+KV_SIZE]
-		//fmt.Printf(" LOAD-C|%d (%d)|%x|%v\n", i, 2*kd+1, hashid, valid_hashid(hashid))
-		if valid_hashid(hashid) && (i < 2*kd+1) {
-			q.c++
-			q.d[i].k = k
-			q.d[i].v = hashid
-			q.notloaded = false
-		}
+	q.load(buf)
+	return true, nil
+}
+
+// load fills q with the entries in its chunk buf and the hashes of its neighbours
+func (q *d) load(buf []byte) {
+	entries := get_chunk_entries(buf)
+	if len(entries) > len(q.d) {
+		entries = entries[:len(q.d)]
 	}
+	q.c = copy(q.d[:], entries)
 	q.prevhashid = buf[CHUNK_SIZE-HASH_SIZE*2 : CHUNK_SIZE-HASH_SIZE]
 	q.nexthashid = buf[CHUNK_SIZE-HASH_SIZE : CHUNK_SIZE]
 	q.notloaded = false
-	return true, nil
 }
 
 func (t *Tree) swarmPut(u *SWARMDBUser) (new_hashid []byte, changed bool, err error) {
@@ -584,6 +712,7 @@ func (t *Tree) swarmPut(u *SWARMDBUser) (new_hashid []byte, changed bool, err er
 	if q == nil {
 		return
 	}
+	log.Trace("[bplus:swarmPut]", "trace", u.TraceID(), "hashid", fmt.Sprintf("%x", t.hashid))
 
 	switch x := q.(type) {
 	case *x: // intermediate node -- descend on the next pass
@@ -608,6 +737,10 @@ func (t *Tree) swarmPut(u *SWARMDBUser) (new_hashid []byte, changed bool, err er
 }
 
 func (q *x) swarmPut(u *SWARMDBUser, swarmdb DBChunkstorage, columnType sdbc.ColumnType, encrypted int) (new_hashid []byte, changed bool, err error) {
+	if size := q.size(); size > NODE_ENTRIES_SIZE {
+		return q.hashid, false, &sdbc.SWARMDBError{Message: fmt.Sprintf("[bplus:swarmPut] XNode of %d bytes, limit %d", size, NODE_ENTRIES_SIZE), ErrorCode: 471, ErrorMessage: "Failure encountered attempting to Flush nodes"}
+	}
+
 	// recurse through children
 	// fmt.Printf("put XNode [c=%d] %x [dirty=%v|notloaded=%v]\n", q.c, q.hashid, q.dirty, q.notloaded)
 	for i := 0; i <= q.c; i++ {
@@ -631,20 +764,28 @@ func (q *x) swarmPut(u *SWARMDBUser, swarmdb DBChunkstorage, columnType sdbc.Col
 	}
 
 	// compute the data here
-	sdata := make([]byte, CHUNK_SIZE)
+	sdata := getChunkBuffer()
+	defer releaseChunkBuffer(sdata)
 	childtype := "X"
+	n, off := 0, 2
 	for i := 0; i <= q.c; i++ {
+		k := q.x[i].k
+		if i == q.c {
+			k = zk // the last child has no key
+		}
 		switch z := q.x[i].ch.(type) {
 		case *x:
-			copy(sdata[i*KV_SIZE:], q.x[i].k)        // max 32 bytes
-			copy(sdata[i*KV_SIZE+K_SIZE:], z.hashid) // max 32 bytes
+			off = put_chunk_entry(sdata, off, k, z.hashid)
+			n++
 		case *d:
-			copy(sdata[i*KV_SIZE:], q.x[i].k)        // max 32 bytes
-			copy(sdata[i*KV_SIZE+K_SIZE:], z.hashid) // max 32 bytes
+			off = put_chunk_entry(sdata, off, k, z.hashid)
+			n++
 			childtype = "D"
 		}
 	}
+	binary.BigEndian.PutUint16(sdata, uint16(n))
 
+	set_chunk_layout(sdata, NODE_LAYOUT_SIZED)
 	set_chunk_nodetype(sdata, "X")
 	set_chunk_childtype(sdata, childtype)
 
@@ -658,6 +799,10 @@ func (q *x) swarmPut(u *SWARMDBUser, swarmdb DBChunkstorage, columnType sdbc.Col
 }
 
 func (q *d) swarmPut(u *SWARMDBUser, swarmdb DBChunkstorage, columnType sdbc.ColumnType, encrypted int) (new_hashid []byte, changed bool, err error) {
+	if size := q.size(); size > NODE_ENTRIES_SIZE {
+		return q.hashid, false, &sdbc.SWARMDBError{Message: fmt.Sprintf("[bplus:swarmPut] DNode of %d bytes, limit %d", size, NODE_ENTRIES_SIZE), ErrorCode: 471, ErrorMessage: "Failure encountered attempting to Flush nodes"}
+	}
+
 	// fmt.Printf("put DNode [c=%d] [dirty=%v|notloaded=%v, prev=%x, next=%x]\n", q.c, q.dirty, q.notloaded, q.prevhashid, q.nexthashid)
 	if q.n != nil {
 		if q.n.dirty {
@@ -683,20 +828,20 @@ func (q *d) swarmPut(u *SWARMDBUser, swarmdb DBChunkstorage, columnType sdbc.Col
 	}
 	// fmt.Printf("N: %x P: %x\n", q.n, q.p) //  q.prevhashid, q.nexthashid
 
-	sdata := make([]byte, CHUNK_SIZE)
+	sdata := getChunkBuffer()
+	defer releaseChunkBuffer(sdata)
+	off := 2
 	for i := 0; i < q.c; i++ {
 		// fmt.Printf("STORE-C|%d|%s|%x\n", i, KeyToString(columnType, q.d[i].k), q.d[i].v)
-		copy(sdata[i*KV_SIZE:], q.d[i].k)        // max 32 bytes
-		copy(sdata[i*KV_SIZE+K_SIZE:], q.d[i].v) // max 32 bytes
+		off = put_chunk_entry(sdata, off, q.d[i].k, q.d[i].v)
 	}
+	binary.BigEndian.PutUint16(sdata, uint16(q.c))
 
-	if q.p != nil {
-		copy(sdata[CHUNK_SIZE-HASH_SIZE*2:], q.prevhashid) // 32 bytes
-	}
-	if q.n != nil {
-		copy(sdata[CHUNK_SIZE-HASH_SIZE*2+HASH_SIZE:], q.nexthashid) // 32 bytes
-	}
+	// a leaf loaded on its own keeps the hashes of the neighbours it was stored with
+	copy(sdata[CHUNK_SIZE-HASH_SIZE*2:], q.prevhashid) // 32 bytes
+	copy(sdata[CHUNK_SIZE-HASH_SIZE:], q.nexthashid)   // 32 bytes
 
+	set_chunk_layout(sdata, NODE_LAYOUT_SIZED)
 	set_chunk_nodetype(sdata, "D")
 	set_chunk_childtype(sdata, "C")
 
@@ -728,13 +873,14 @@ func (t *Tree) cat(p *x, q, r *d, pi int) {
 		r.n.p = q
 	} else {
 		t.last = q
+		q.nexthashid = r.nexthashid
 	}
 	q.n = r.n
 	r.dirty = true
 	q.dirty = true
 	*r = zd
 	btDPool.Put(r)
-	if p.c > 1 {
+	if p != t.r || p.c > 1 {
 		p.extract(pi)
 		p.x[pi].ch = q
 		return
@@ -757,9 +903,10 @@ func (t *Tree) catX(p, q, r *x, pi int) {
 	copy(q.x[q.c+1:], r.x[:r.c])
 	q.c += r.c + 1
 	q.x[q.c].ch = r.x[r.c].ch
+	q.dirty = true
 	*r = zx
 	btXPool.Put(r)
-	if p.c > 1 {
+	if p != t.r || p.c > 1 {
 		p.c--
 		pc := p.c
 		if pi < pc {
@@ -784,11 +931,16 @@ func (t *Tree) catX(p, q, r *x, pi int) {
 }
 
 // Delete removes the k's KV pair, if it exists, in which case Delete returns true.
-// TODO: the Delete => underflow situation  needs full testing
-func (t *Tree) Delete(u *SWARMDBUser, k []byte /*K*/) (ok bool, err error) {
+// On the way down, X nodes are split when full and refilled when underfull, as in Put, so that the leaf and its
+// parent can be fixed up without going back up the tree.
+func (t *Tree) Delete(u *SWARMDBUser, key []byte /*K*/) (ok bool, err error) {
+	k := treeKey(key)
 	pi := -1
 	var p *x
 	q := t.r
+	if q == nil {
+		return false, nil
+	}
 	for {
 		err = checkload(u, t.swarmdb, q)
 		if err != nil {
@@ -797,50 +949,40 @@ func (t *Tree) Delete(u *SWARMDBUser, k []byte /*K*/) (ok bool, err error) {
 		}
 		var i int
 		i, ok = t.find(q, k)
-		if ok {
-
-			switch x := q.(type) {
-			case *x:
-				if x.c < kx && q != t.r {
-					x, i = t.underflowX(p, x, pi, i)
-				}
-				pi = i + 1
-				p = x
-				q = x.x[pi].ch
-				x.dirty = true // optimization: this should really be if something is *actually* deleted
-				continue
-			case *d:
-				t.extract(x, i)
-				if x.c >= kd {
-					return true, nil
-				}
-
-				if q != t.r {
-					t.underflow(p, x, pi)
-				} else if t.c == 0 {
-
-					t.Clear()
-				}
-				x.dirty = true // we found the key and  actually deleted it!
-				_, err = t.check_flush(u)
-				if err != nil {
-					return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[bplus:Delete] check_flush - %s", err.Error()))
-				}
-				return true, nil
-			}
-		}
-
 		switch x := q.(type) {
 		case *x:
-			if x.c < kx && q != t.r {
-				x, i = t.underflowX(p, x, pi, i)
+			if ok {
+				i++
+			}
+			if x.full() {
+				x, i = t.splitX(p, x, pi, i)
+			} else if q != t.r && x.underfull() {
+				x, i, err = t.underflowX(u, p, x, pi, i)
+				if err != nil {
+					return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[bplus:Delete] underflowX - %s", err.Error()))
+				}
 			}
 			pi = i
 			p = x
 			q = x.x[i].ch
 			x.dirty = true // optimization: this should really be if something is *actually* deleted
 		case *d:
-			return false, nil // we got to the bottom and key was not found
+			if !ok {
+				return false, nil // we got to the bottom and key was not found
+			}
+			t.extract(x, i)
+			x.dirty = true // we found the key and  actually deleted it!
+			if q != t.r && x.underfull() {
+				err = t.underflow(u, p, x, pi)
+				if err != nil {
+					return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[bplus:Delete] underflow - %s", err.Error()))
+				}
+			}
+			_, err = t.check_flush(u)
+			if err != nil {
+				return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[bplus:Delete] check_flush - %s", err.Error()))
+			}
+			return true, nil
 		}
 	}
 }
@@ -925,8 +1067,7 @@ func (t *Tree) Get(u *SWARMDBUser, key []byte /*K*/) (v []byte /*V*/, ok bool, e
 	//		return
 	//	}
 
-	k := make([]byte, K_SIZE)
-	copy(k, key)
+	k := treeKey(key)
 
 	for {
 		err = checkload(u, t.swarmdb, q)
@@ -977,94 +1118,56 @@ func (t *Tree) insert(q *d, i int, k []byte /*K*/, v []byte /*V*/) *d {
 	return q
 }
 
-func print_spaces(nspaces int) {
-	for i := 0; i < nspaces; i++ {
-		fmt.Printf("  ")
-	}
-}
-
-// NOTE: this only prints the portion of the tree that is actually LOADED
-func (t *Tree) Print(u *SWARMDBUser) {
+// Print returns a line describing each node and leaf entry of the tree, indented by level, for the caller to log.
+// NOTE: this only describes the portion of the tree that is actually LOADED
+func (t *Tree) Print(u *SWARMDBUser) (lines []string) {
 	q := t.r
 	if q == nil {
-		return
+		return nil
 	}
 
 	switch x := q.(type) {
 	case *x: // intermediate node -- descend on the next pass
-		fmt.Printf("ROOT Node (X) [%x] [dirty=%v|notloaded=%v]\n", x.hashid, x.dirty, x.notloaded)
-		x.print(t.columnType, 0)
+		lines = append(lines, fmt.Sprintf("ROOT Node (X) hashid=%x dirty=%v notloaded=%v", x.hashid, x.dirty, x.notloaded))
+		lines = x.print(t.columnType, 0, lines)
 	case *d: // data node -- EXACT match
-		fmt.Printf("ROOT Node (D) [%x] [dirty=%v|notloaded=%v]\n", x.hashid, x.dirty, x.notloaded)
-		x.print(t.columnType, 0)
+		lines = append(lines, fmt.Sprintf("ROOT Node (D) hashid=%x dirty=%v notloaded=%v", x.hashid, x.dirty, x.notloaded))
+		lines = x.print(t.columnType, 0, lines)
 	}
-	return
+	return lines
 }
 
-func (q *x) print(columnType sdbc.ColumnType, level int) {
-	print_spaces(level)
-	fmt.Printf("XNode (%x) [c=%d] (LEVEL %d) [dirty=%v|notloaded=%v]\n", q.hashid, q.c, level, q.dirty, q.notloaded)
+func (q *x) print(columnType sdbc.ColumnType, level int, lines []string) []string {
+	indent := strings.Repeat("  ", level)
+	lines = append(lines, fmt.Sprintf("%sXNode hashid=%x c=%d level=%d dirty=%v notloaded=%v", indent, q.hashid, q.c, level, q.dirty, q.notloaded))
 	if q.notloaded == false {
 		for i := 0; i <= q.c; i++ {
-			print_spaces(level + 1)
-			fmt.Printf("Child %d|%v\n", i, level+1) // , KeyToString(columnType, q.x[i].k))
+			lines = append(lines, fmt.Sprintf("%s Child i=%d level=%d", indent, i, level+1))
 			switch z := q.x[i].ch.(type) {
 			case *x:
-				z.print(columnType, level+1)
+				lines = z.print(columnType, level+1, lines)
 			case *d:
-				z.print(columnType, level+1)
+				lines = z.print(columnType, level+1, lines)
 			}
 		}
 	}
-
-	return
+	return lines
 }
 
-func (q *d) print(columnType sdbc.ColumnType, level int) {
-	print_spaces(level)
-	fmt.Printf("DNode %x [c=%d] (LEVEL %d) [dirty=%v|notloaded=%v|prev=%x|next=%x]\n", q.hashid, q.c, level, q.dirty, q.notloaded, q.prevhashid, q.nexthashid)
+func (q *d) print(columnType sdbc.ColumnType, level int, lines []string) []string {
+	indent := strings.Repeat("  ", level)
+	lines = append(lines, fmt.Sprintf("%sDNode hashid=%x c=%d level=%d dirty=%v notloaded=%v prev=%x next=%x", indent, q.hashid, q.c, level, q.dirty, q.notloaded, q.prevhashid, q.nexthashid))
 	for i := 0; i < q.c; i++ {
-		print_spaces(level + 1)
-		fmt.Printf("DATA %d (L%d)|%s|%v\n", i, level+1, KeyToString(columnType, q.d[i].k), ValueToString(q.d[i].v))
-	}
-	return
-}
-
-func (t *Tree) overflow(p *x, q *d, pi, i int, k []byte /*K*/, v []byte /*V*/) {
-	t.ver++
-	l, r := p.siblings(pi)
-
-	if l != nil && l.c < 2*kd && i != 0 {
-		l.dirty = true
-		l.mvL(q, 1)
-		t.insert(q, i-1, k, v)
-		p.x[pi-1].k = q.d[0].k
-		return
-	}
-
-	if r != nil && r.c < 2*kd {
-		r.dirty = true
-		if i < 2*kd {
-			q.mvR(r, 1)
-			t.insert(q, i, k, v)
-			p.x[pi].k = r.d[0].k
-			return
-		}
-
-		t.insert(r, 0, k, v)
-		p.x[pi].k = k
-		return
+		lines = append(lines, fmt.Sprintf("%s DATA i=%d level=%d key=%s value=%s", indent, i, level+1, KeyToString(columnType, q.d[i].k), ValueToString(q.d[i].v)))
 	}
-
-	t.split(p, q, pi, i, k, v)
+	return lines
 }
 
 // Seek returns an Enumerator positioned on an item such that k >= item's key.
 // ok reports if k == item.key The Enumerator's position is possibly after the
 // last item in the tree.
 func (t *Tree) Seek(u *SWARMDBUser, key []byte /*K*/) (e OrderedDatabaseCursor, ok bool, err error) {
-	k := make([]byte, K_SIZE)
-	copy(k, key)
+	k := treeKey(key)
 
 	q := t.r
 	if q == nil {
@@ -1152,11 +1255,12 @@ func (t *Tree) SeekLast(u *SWARMDBUser) (e OrderedDatabaseCursor, err error) {
 }
 
 // Put(k,v) -- actually puts the key
-// TODO: add checks for byte length input on key/value
 func (t *Tree) Put(u *SWARMDBUser, key []byte /*K*/, v []byte /*V*/) (okresult bool, err error) {
 	// fmt.Printf(" -- B+ Tree Put: %s => %s\n", KeyToString(t.columnType, key), ValueToString(v))
-	k := make([]byte, K_SIZE)
-	copy(k, key)
+	if err = checkEntrySize("Put", key, v); err != nil {
+		return false, err
+	}
+	k := treeKey(key)
 
 	pi := -1
 	var p *x
@@ -1176,33 +1280,13 @@ func (t *Tree) Put(u *SWARMDBUser, key []byte /*K*/, v []byte /*V*/) (okresult b
 		}
 
 		i, ok := t.find(q, k)
-		if ok {
-			// the key is found
-			switch x := q.(type) {
-			case *x:
-				// for the intermediate level
-				i++
-				if x.c > 2*kx {
-					x, i = t.splitX(p, x, pi, i)
-				}
-				pi = i
-				p = x
-				q = x.x[i].ch
-				continue
-			case *d:
-				x.d[i].v = v
-				x.dirty = true // we updated the value but did not insert anything
-				_, err = t.check_flush(u)
-				if err != nil {
-					return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[bplus:Put] check_flush - %s", err.Error()))
-				}
-				return true, nil
-			}
-		}
-
 		switch x := q.(type) {
 		case *x:
-			if x.c > 2*kx {
+			if ok {
+				// the key is found at the intermediate level
+				i++
+			}
+			if x.full() {
 				x, i = t.splitX(p, x, pi, i)
 			}
 			pi = i
@@ -1210,11 +1294,13 @@ func (t *Tree) Put(u *SWARMDBUser, key []byte /*K*/, v []byte /*V*/) (okresult b
 			q = x.x[i].ch
 			x.dirty = true // we updated the value at the intermediate node
 		case *d:
-			switch {
-			case x.c < 2*kd: // insert
+			if ok {
+				x.d[i].v = v // we updated the value but did not insert anything
+			} else {
 				t.insert(x, i, k, v)
-			default:
-				t.overflow(p, x, pi, i, k, v)
+			}
+			if x.overfull() {
+				t.split(p, x, pi)
 			}
 			x.dirty = true // we inserted the value at the intermediate node or leaf node
 			_, err = t.check_flush(u)
@@ -1226,7 +1312,12 @@ func (t *Tree) Put(u *SWARMDBUser, key []byte /*K*/, v []byte /*V*/) (okresult b
 	}
 }
 
-func (t *Tree) Insert(u *SWARMDBUser, k []byte /*K*/, v []byte /*V*/) (okres bool, err error) {
+func (t *Tree) Insert(u *SWARMDBUser, key []byte /*K*/, v []byte /*V*/) (okres bool, err error) {
+	if err = checkEntrySize("Insert", key, v); err != nil {
+		return false, err
+	}
+	k := treeKey(key)
+
 	pi := -1
 	var p *x
 	q := t.r
@@ -1245,14 +1336,12 @@ func (t *Tree) Insert(u *SWARMDBUser, k []byte /*K*/, v []byte /*V*/) (okres boo
 		}
 
 		i, ok := t.find(q, k)
-		if ok {
-			var dkerr *sdbc.DuplicateKeyError
-			return false, dkerr
-		}
-
 		switch x := q.(type) {
 		case *x:
-			if x.c > 2*kx {
+			if ok {
+				i++
+			}
+			if x.full() {
 				x, i = t.splitX(p, x, pi, i)
 			}
 			pi = i
@@ -1260,11 +1349,12 @@ func (t *Tree) Insert(u *SWARMDBUser, k []byte /*K*/, v []byte /*V*/) (okres boo
 			q = x.x[i].ch
 			x.dirty = true // we updated the value at the intermediate node
 		case *d:
-			switch {
-			case x.c < 2*kd: // insert
-				t.insert(x, i, k, v)
-			default:
-				t.overflow(p, x, pi, i, k, v)
+			if ok {
+				return false, new(sdbc.DuplicateKeyError)
+			}
+			t.insert(x, i, k, v)
+			if x.overfull() {
+				t.split(p, x, pi)
 			}
 			x.dirty = true // we inserted the value at the intermediate node or leaf node
 			_, err = t.check_flush(u)
@@ -1277,7 +1367,8 @@ func (t *Tree) Insert(u *SWARMDBUser, k []byte /*K*/, v []byte /*V*/) (okres boo
 	}
 }
 
-func (t *Tree) split(p *x, q *d, pi, i int, k []byte /*K*/, v []byte /*V*/) {
+// split moves the upper half of the entries of the overfull leaf q, child pi of p, to a new leaf after it
+func (t *Tree) split(p *x, q *d, pi int) {
 	t.ver++
 	r := btDPool.Get().(*d)
 	if q.n != nil {
@@ -1287,86 +1378,106 @@ func (t *Tree) split(p *x, q *d, pi, i int, k []byte /*K*/, v []byte /*V*/) {
 	} else {
 		// its the last node of the linked list!
 		t.last = r
+		r.nexthashid = q.nexthashid
 	}
 	q.n = r // old node "next" points to new node
 	r.p = q // new node "prev" points to prev node
 	r.dirty = true
 	q.dirty = true
 
-	copy(r.d[:], q.d[kd:2*kd])
-	for i := range q.d[kd:] {
-		q.d[kd+i] = zde
-	}
-	q.c = kd
-	r.c = kd
-	var done bool
-	if i > kd {
-		done = true
-		t.insert(r, i-kd, k, v)
+	m := q.half()
+	copy(r.d[:], q.d[m:q.c])
+	r.c = q.c - m
+	for i := m; i < q.c; i++ {
+		q.d[i] = zde
 	}
+	q.c = m
 	if pi >= 0 {
 		p.insert(pi, r.d[0].k, r)
 	} else {
 		t.r = newX(q).insert(0, r.d[0].k, r)
 	}
-	if done {
-		return
-	}
-
-	t.insert(q, i, k, v)
 }
 
+// splitX moves the children of the full X node q, child pi of p, after its middle key to a new X node after it, and
+// the middle key to p; i is the child of q being descended to, and is returned along with the node holding it
 func (t *Tree) splitX(p *x, q *x, pi int, i int) (*x, int) {
 	t.ver++
+	m := q.half()
 	r := btXPool.Get().(*x)
-	copy(r.x[:], q.x[kx+1:])
-	q.c = kx
-	r.c = kx
+	copy(r.x[:], q.x[m+1:q.c+1])
+	r.c = q.c - m - 1
 	r.dirty = true
+	q.dirty = true
 	if pi >= 0 {
-		p.insert(pi, q.x[kx].k, r)
+		p.insert(pi, q.x[m].k, r)
 	} else {
-		t.r = newX(q).insert(0, q.x[kx].k, r)
+		t.r = newX(q).insert(0, q.x[m].k, r)
 	}
 
-	q.x[kx].k = zk
-	for i := range q.x[kx+1:] {
-		q.x[kx+i+1] = zxe
+	q.x[m].k = zk
+	for j := m + 1; j <= q.c; j++ {
+		q.x[j] = zxe
 	}
-	if i > kx {
+	q.c = m
+	if i > m {
 		q = r
-		i -= kx + 1
+		i -= m + 1
 	}
 
 	return q, i
 }
 
-func (t *Tree) underflow(p *x, q *d, pi int) {
+// underflow merges the underfull leaf q, child pi of p, with a sibling when their entries fit one chunk, and otherwise
+// moves entries to q from a sibling until q is no longer underfull
+func (t *Tree) underflow(u *SWARMDBUser, p *x, q *d, pi int) (err error) {
 	t.ver++
 	l, r := p.siblings(pi)
 
-	if l != nil && l.c+q.c >= 2*kd {
-		l.mvR(q, 1)
-		p.x[pi-1].k = q.d[0].k
-		return
+	if l != nil {
+		if err = checkload(u, t.swarmdb, l); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[bplus:underflow] checkload - %s", err.Error()))
+		}
+		if l.fits(q) {
+			t.cat(p, l, q, pi-1)
+			return nil
+		}
 	}
 
-	if r != nil && q.c+r.c >= 2*kd {
-		q.mvL(r, 1)
-		p.x[pi].k = r.d[0].k
-		r.d[r.c] = zde // GC
-		return
+	if r != nil {
+		if err = checkload(u, t.swarmdb, r); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[bplus:underflow] checkload - %s", err.Error()))
+		}
+		if q.fits(r) {
+			t.cat(p, q, r, pi)
+			return nil
+		}
 	}
 
 	if l != nil {
-		t.cat(p, l, q, pi-1)
-		return
+		for q.underfull() && l.c > 1 {
+			l.mvR(q, 1)
+		}
+		l.dirty = true
+		p.x[pi-1].k = q.d[0].k
+		return nil
 	}
 
-	t.cat(p, q, r, pi)
+	if r != nil {
+		for q.underfull() && r.c > 1 {
+			q.mvL(r, 1)
+			r.d[r.c] = zde // GC
+		}
+		r.dirty = true
+		p.x[pi].k = r.d[0].k
+	}
+	return nil
 }
 
-func (t *Tree) underflowX(p *x, q *x, pi int, i int) (*x, int) {
+// underflowX merges the underfull X node q, child pi of p, with a sibling when that leaves room for another entry, and
+// otherwise moves children to q from a sibling through p until q is no longer underfull; i is the child of q being
+// descended to, and is returned along with the node holding it
+func (t *Tree) underflowX(u *SWARMDBUser, p *x, q *x, pi int, i int) (*x, int, error) {
 	t.ver++
 	var l, r *x
 
@@ -1379,41 +1490,60 @@ func (t *Tree) underflowX(p *x, q *x, pi int, i int) (*x, int) {
 		}
 	}
 
-	if l != nil && l.c > kx {
-		q.x[q.c+1].ch = q.x[q.c].ch
-		copy(q.x[1:], q.x[:q.c])
-		q.x[0].ch = l.x[l.c].ch
-		q.x[0].k = p.x[pi-1].k
-		q.c++
-		i++
-		l.c--
-		p.x[pi-1].k = l.x[l.c].k
-		return q, i
+	if l != nil {
+		if err := checkload(u, t.swarmdb, l); err != nil {
+			return q, i, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[bplus:underflowX] checkload - %s", err.Error()))
+		}
+		if l.fits(q, p.x[pi-1].k) {
+			i += l.c + 1
+			t.catX(p, l, q, pi-1)
+			return l, i, nil
+		}
 	}
 
-	if r != nil && r.c > kx {
-		q.x[q.c].k = p.x[pi].k
-		q.c++
-		q.x[q.c].ch = r.x[0].ch
-		p.x[pi].k = r.x[0].k
-		copy(r.x[:], r.x[1:r.c])
-		r.c--
-		rc := r.c
-		r.x[rc].ch = r.x[rc+1].ch
-		r.x[rc].k = zk
-		r.x[rc+1].ch = nil
-		return q, i
+	if r != nil {
+		if err := checkload(u, t.swarmdb, r); err != nil {
+			return q, i, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[bplus:underflowX] checkload - %s", err.Error()))
+		}
+		if q.fits(r, p.x[pi].k) {
+			t.catX(p, q, r, pi)
+			return q, i, nil
+		}
 	}
 
 	if l != nil {
-		i += l.c + 1
-		t.catX(p, l, q, pi-1)
-		q = l
-		return q, i
+		for q.underfull() && l.c > 1 {
+			q.x[q.c+1].ch = q.x[q.c].ch
+			copy(q.x[1:], q.x[:q.c])
+			q.x[0].ch = l.x[l.c].ch
+			q.x[0].k = p.x[pi-1].k
+			q.c++
+			i++
+			l.c--
+			p.x[pi-1].k = l.x[l.c].k
+			l.x[l.c].k = zk  // GC
+			l.x[l.c+1] = zxe // GC
+		}
+		l.dirty = true
+		return q, i, nil
 	}
 
-	t.catX(p, q, r, pi)
-	return q, i
+	if r != nil {
+		for q.underfull() && r.c > 1 {
+			q.x[q.c].k = p.x[pi].k
+			q.c++
+			q.x[q.c].ch = r.x[0].ch
+			p.x[pi].k = r.x[0].k
+			copy(r.x[:], r.x[1:r.c])
+			r.c--
+			rc := r.c
+			r.x[rc].ch = r.x[rc+1].ch
+			r.x[rc].k = zk
+			r.x[rc+1].ch = nil
+		}
+		r.dirty = true
+	}
+	return q, i, nil
 }
 
 // ----------------------------------------------------------------- Enumerator
@@ -1462,17 +1592,16 @@ func (e *Enumerator) next(u *SWARMDBUser) (err error) {
 	case e.i < e.q.c-1:
 		e.i++
 	default:
-		if valid_hashid(e.q.nexthashid) && e.q.n == nil {
-			r := btDPool.Get().(*d)
-			r.p = e.q
-			r.hashid = e.q.nexthashid
-			r.notloaded = true
-			_, err = r.swarmGet(u, e.t.swarmdb)
+		if e.q.n == nil && e.q.c > 0 {
+			// a leaf loaded from its chunk is not linked to the next one, and the hash it was stored with may be
+			// of an older version of it, so the next key is found from the root
+			r, i, err := e.t.seekNext(u, e.q.d[e.q.c-1].k)
 			if err != nil {
-				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[bplus:next] swarmGet - %s", err.Error()))
+				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[bplus:next] seekNext - %s", err.Error()))
+			}
+			if e.q, e.i = r, i; e.q == nil {
+				e.err = io.EOF
 			}
-			e.q = r
-			e.i = 0
 		} else {
 			if e.q, e.i = e.q.n, 0; e.q == nil {
 				e.err = io.EOF
@@ -1525,19 +1654,14 @@ func (e *Enumerator) prev(u *SWARMDBUser) (err error) {
 	case e.i > 0:
 		e.i--
 	default:
-		if valid_hashid(e.q.prevhashid) && e.q.p == nil {
-			r := btDPool.Get().(*d)
-			r.hashid = e.q.prevhashid
-			r.notloaded = true
-			_, err = r.swarmGet(u, e.t.swarmdb)
+		if e.q.p == nil && e.q.c > 0 {
+			// as in next, the previous key of a leaf loaded from its chunk is found from the root
+			r, i, err := e.t.seekPrev(u, e.q.d[0].k)
 			if err != nil {
-				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[bplus:prev] swarmGet - %s", err.Error()))
+				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[bplus:prev] seekPrev - %s", err.Error()))
 			}
-			e.q = r
-			if r.c >= 0 {
-				e.i = r.c - 1
-			} else {
-				e.i = 0
+			if e.q, e.i = r, i; e.q == nil {
+				e.err = io.EOF
 			}
 		} else {
 			if e.q = e.q.p; e.q == nil {
@@ -1550,6 +1674,90 @@ func (e *Enumerator) prev(u *SWARMDBUser) (err error) {
 	return e.err
 }
 
+// seekNext returns the leaf holding the smallest key above k and its index, or nil if there is none
+func (t *Tree) seekNext(u *SWARMDBUser, k []byte /*K*/) (r *d, i int, err error) {
+	var next interface{} // the subtree right of the path to k
+	q := t.r
+	for {
+		if err = checkload(u, t.swarmdb, q); err != nil {
+			return nil, 0, err
+		}
+		i, ok := t.find(q, k)
+		if ok {
+			i++
+		}
+		switch x := q.(type) {
+		case *x:
+			if i < x.c {
+				next = x.x[i+1].ch
+			}
+			q = x.x[i].ch
+		case *d:
+			if i < x.c {
+				return x, i, nil
+			}
+			r, err = t.edgeLeaf(u, next, false)
+			return r, 0, err
+		default:
+			return nil, 0, nil
+		}
+	}
+}
+
+// seekPrev returns the leaf holding the largest key below k and its index, or nil if there is none
+func (t *Tree) seekPrev(u *SWARMDBUser, k []byte /*K*/) (r *d, i int, err error) {
+	var prev interface{} // the subtree left of the path to k
+	q := t.r
+	for {
+		if err = checkload(u, t.swarmdb, q); err != nil {
+			return nil, 0, err
+		}
+		i, ok := t.find(q, k)
+		switch x := q.(type) {
+		case *x:
+			if ok {
+				i++
+			}
+			if i > 0 {
+				prev = x.x[i-1].ch
+			}
+			q = x.x[i].ch
+		case *d:
+			if i > 0 {
+				return x, i - 1, nil
+			}
+			if r, err = t.edgeLeaf(u, prev, true); r != nil {
+				i = r.c - 1
+			}
+			return r, i, err
+		default:
+			return nil, 0, nil
+		}
+	}
+}
+
+// edgeLeaf returns the first leaf under q, or the last one, loading the nodes on the way
+func (t *Tree) edgeLeaf(u *SWARMDBUser, q interface{}, last bool) (r *d, err error) {
+	for q != nil {
+		if err = checkload(u, t.swarmdb, q); err != nil {
+			return nil, err
+		}
+		switch x := q.(type) {
+		case *x:
+			if last {
+				q = x.x[x.c].ch
+			} else {
+				q = x.x[0].ch
+			}
+		case *d:
+			return x, nil
+		default:
+			return nil, nil
+		}
+	}
+	return nil, nil
+}
+
 // ----- COMPARATORS -- depending on the tree column Type, one of these will be used
 func cmpBytes(a, b []byte) int {
 	// Compare returns an integer comparing two byte slices lexicographically.
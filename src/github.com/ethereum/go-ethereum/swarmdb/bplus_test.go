@@ -21,6 +21,7 @@ import (
 	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
 	"math/rand"
 	"os"
+	"strings"
 	wolkdb "swarmdb"
 	"testing"
 )
@@ -68,7 +69,9 @@ func TestPutInteger(t *testing.T) {
 		}
 	}
 
-	r.Print(u)
+	for _, line := range r.Print(u) {
+		t.Log(line)
+	}
 	// flush B+tree in memory to SWARM
 	_, errF := r.FlushBuffer(u)
 	if errF != nil {
@@ -114,7 +117,9 @@ func TestPutInteger(t *testing.T) {
 	} else {
 		t.Fatal("Get(16) failure")
 	}
-	s.Print(u)
+	for _, line := range s.Print(u) {
+		t.Log(line)
+	}
 
 	// ENUMERATOR
 	if false {
@@ -451,3 +456,113 @@ func TestDelete2(t *testing.T) {
 		}
 	}
 }
+
+func TestSplitMerge(t *testing.T) {
+	u := config.GetSWARMDBUser()
+
+	// enough integer keys for leaves and X nodes to split at the fanout of a chunk
+	const N = 80 * wolkdb.NODE_ENTRIES_MAX
+	hashid := make([]byte, 32)
+	r, _ := wolkdb.NewBPlusTreeDB(u, swarmdb, hashid, sdbc.CT_INTEGER, false, sdbc.CT_STRING, TEST_ENCRYPTED)
+	r.StartBuffer(u)
+	for _, i := range rand.Perm(N) {
+		if _, err := r.Put(u, wolkdb.IntToByte(i), wolkdb.SHA256(fmt.Sprintf("%d", i))); err != nil {
+			t.Fatal("Put", i, err)
+		}
+	}
+	if _, err := r.FlushBuffer(u); err != nil {
+		t.Fatal("FlushBuffer", err)
+	}
+
+	s, _ := wolkdb.NewBPlusTreeDB(u, swarmdb, r.GetRootHash(), sdbc.CT_INTEGER, false, sdbc.CT_STRING, TEST_ENCRYPTED)
+	for i := 0; i < N; i++ {
+		v, ok, err := s.Get(u, wolkdb.IntToByte(i))
+		if !ok || err != nil || bytes.Compare(v, wolkdb.SHA256(fmt.Sprintf("%d", i))) != 0 {
+			t.Fatal("Get", i, ok, err)
+		}
+	}
+
+	// deleting all but every tenth key merges and refills the nodes loaded on the way
+	s.StartBuffer(u)
+	for _, i := range rand.Perm(N) {
+		if i%10 == 0 {
+			continue
+		}
+		if ok, err := s.Delete(u, wolkdb.IntToByte(i)); !ok || err != nil {
+			t.Fatal("Delete", i, ok, err)
+		}
+	}
+	if _, err := s.FlushBuffer(u); err != nil {
+		t.Fatal("FlushBuffer", err)
+	}
+
+	d, _ := wolkdb.NewBPlusTreeDB(u, swarmdb, s.GetRootHash(), sdbc.CT_INTEGER, false, sdbc.CT_STRING, TEST_ENCRYPTED)
+	for i := 0; i < N; i++ {
+		_, ok, err := d.Get(u, wolkdb.IntToByte(i))
+		if err != nil || ok != (i%10 == 0) {
+			t.Fatal("Get after Delete", i, ok, err)
+		}
+	}
+	res, _ := d.SeekFirst(u)
+	records := 0
+	for k, _, err := res.Next(u); err == nil; k, _, err = res.Next(u) {
+		if bytes.Compare(k[0:8], wolkdb.IntToByte(10 * records)[0:8]) != 0 {
+			t.Fatal("Next", records, k)
+		}
+		records++
+	}
+	if records != N/10 {
+		t.Fatal("records", records)
+	}
+}
+
+func TestPutLongKeys(t *testing.T) {
+	u := config.GetSWARMDBUser()
+
+	// keys longer than K_SIZE are kept whole, and fewer of them fit a node
+	const N = 600
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("%04d%s", i, strings.Repeat("k", 40+(i*37)%200)))
+	}
+	hashid := make([]byte, 32)
+	r, _ := wolkdb.NewBPlusTreeDB(u, swarmdb, hashid, sdbc.CT_STRING, false, sdbc.CT_STRING, TEST_ENCRYPTED)
+	r.StartBuffer(u)
+	for _, i := range rand.Perm(N) {
+		if _, err := r.Put(u, key(i), wolkdb.SHA256(string(key(i)))); err != nil {
+			t.Fatal("Put", i, err)
+		}
+	}
+	if _, err := r.FlushBuffer(u); err != nil {
+		t.Fatal("FlushBuffer", err)
+	}
+
+	s, _ := wolkdb.NewBPlusTreeDB(u, swarmdb, r.GetRootHash(), sdbc.CT_STRING, false, sdbc.CT_STRING, TEST_ENCRYPTED)
+	for i := 0; i < N; i++ {
+		v, ok, err := s.Get(u, key(i))
+		if !ok || err != nil || bytes.Compare(v, wolkdb.SHA256(string(key(i)))) != 0 {
+			t.Fatal("Get", i, ok, err)
+		}
+	}
+	if _, ok, _ := s.Get(u, key(N)[0:wolkdb.K_SIZE]); ok {
+		t.Fatal("Get of a key cut to K_SIZE")
+	}
+	res, _, err := s.Seek(u, key(0))
+	if err != nil {
+		t.Fatal("Seek", err)
+	}
+	records := 0
+	for k, _, err := res.Next(u); err == nil; k, _, err = res.Next(u) {
+		if bytes.Compare(k, key(records)) != 0 {
+			t.Fatal("Next", records, string(k))
+		}
+		records++
+	}
+	if records != N {
+		t.Fatal("records", records)
+	}
+
+	_, err = s.Put(u, bytes.Repeat([]byte("k"), wolkdb.K_SIZE_MAX+1), wolkdb.SHA256("k"))
+	if sErr, ok := err.(*sdbc.SWARMDBError); !ok || sErr.ErrorCode != wolkdb.KEY_TOO_LARGE {
+		t.Fatal("Put of a key over K_SIZE_MAX", err)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"sync/atomic"
+)
+
+// A read request may retrieve at most config.QueryChunkBudget chunks, of at most config.QueryByteBudget bytes in
+// total, from the chunk store.  Like the deadline the budget is carried on the per-request SWARMDBUser, and every
+// chunk DBChunkstore.RetrieveChunk reads is charged to it, so a scan or a query on an unindexed column fails with
+// ErrorCode 509 once it has read its share instead of walking a whole table on a shared node.  Writes and answers
+// from the query cache are not charged.
+
+// requestBudget is shared by the copies WithTrace, WithDeadline and WithBuffers make of the SWARMDBUser of a request
+type requestBudget struct {
+	maxChunks int64 // 0 - unlimited
+	maxBytes  int64 // 0 - unlimited
+	chunks    int64
+	bytes     int64
+}
+
+// WithBudget returns a copy of u that may retrieve chunks chunks of bytes bytes in total; 0 leaves either unlimited
+func (u *SWARMDBUser) WithBudget(chunks int, bytes int64) *SWARMDBUser {
+	if u == nil {
+		return nil
+	}
+	limited := *u
+	limited.budget = &requestBudget{maxChunks: int64(chunks), maxBytes: bytes}
+	return &limited
+}
+
+// BudgetUsed returns the chunks, and their bytes, the request u is acting for retrieved so far
+func (u *SWARMDBUser) BudgetUsed() (chunks int, bytes int64) {
+	if u == nil || u.budget == nil {
+		return 0, 0
+	}
+	return int(atomic.LoadInt64(&u.budget.chunks)), atomic.LoadInt64(&u.budget.bytes)
+}
+
+// chargeChunk charges a chunk of size bytes to the budget of the request, failing once the budget is spent
+func (u *SWARMDBUser) chargeChunk(size int) (err error) {
+	if u == nil || u.budget == nil {
+		return nil
+	}
+	b := u.budget
+	chunks := atomic.AddInt64(&b.chunks, 1)
+	bytes := atomic.AddInt64(&b.bytes, int64(size))
+	if b.maxChunks > 0 && chunks > b.maxChunks {
+		return budgetExceeded(fmt.Sprintf("more than %d chunks", b.maxChunks))
+	}
+	if b.maxBytes > 0 && bytes > b.maxBytes {
+		return budgetExceeded(fmt.Sprintf("more than %d bytes", b.maxBytes))
+	}
+	return nil
+}
+
+func budgetExceeded(read string) error {
+	return &sdbc.SWARMDBError{Message: fmt.Sprintf("[budget:chargeChunk] request read %s", read), ErrorCode: 509, ErrorMessage: fmt.Sprintf("Query Budget Exceeded: the request read %s; add an index on the columns it filters on or narrow its key range", read)}
+}
+
+// withQueryBudget gives a read request without a budget of its own the budget of the node
+func (self *SwarmDB) withQueryBudget(u *SWARMDBUser) *SWARMDBUser {
+	if u == nil || u.budget != nil || (self.chunkBudget == 0 && self.byteBudget == 0) {
+		return u
+	}
+	return u.WithBudget(self.chunkBudget, self.byteBudget)
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"sync"
+)
+
+// Encoding a node or row fills a CHUNK_SIZE buffer that is dead as soon as the chunk store has it, as storeChunkInDB
+// keeps copies only.  Bulk loads and flushes encode thousands of them, so the buffers are recycled through a
+// sync.Pool instead of being left to the GC.  A buffer may only be released once nothing refers to it any more: the
+// chunks handed out by the chunk store on reads are owned by their callers, which keep slices of them, and are not.
+var chunkBuffers = sync.Pool{
+	New: func() interface{} { return make([]byte, CHUNK_SIZE) },
+}
+
+// getChunkBuffer returns a zeroed CHUNK_SIZE buffer, to be given back with releaseChunkBuffer
+func getChunkBuffer() []byte {
+	return chunkBuffers.Get().([]byte)
+}
+
+// releaseChunkBuffer zeroes buf and returns it to the pool
+func releaseChunkBuffer(buf []byte) {
+	if cap(buf) != CHUNK_SIZE {
+		return
+	}
+	buf = buf[:CHUNK_SIZE]
+	for i := range buf {
+		buf[i] = 0
+	}
+	chunkBuffers.Put(buf)
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"strings"
+)
+
+// A capability (see swarmdbwire.Capability) lets a third party act for an owner within a scope: a database, possibly
+// one table and a primary key prefix, read and/or write access, until it expires.  Requests carrying one run as the
+// owner, whose key must be configured on the node as for an authenticated session.  Only row reads and writes are
+// allowed; a key prefix further limits them to requests naming their keys, or ranges within the prefix.
+
+// parseCapabilityPermission returns the ACL_* bits of a capability permission; a capability cannot grant ACL_GRANT
+func parseCapabilityPermission(permission string) (perm uint8, err error) {
+	for _, name := range strings.Split(permission, ",") {
+		switch strings.TrimSpace(name) {
+		case "read":
+			perm |= ACL_READ
+		case "write":
+			perm |= ACL_WRITE
+		default:
+			return 0, &sdbc.SWARMDBError{Message: fmt.Sprintf("[capability:parseCapabilityPermission] permission [%s]", permission), ErrorCode: 489, ErrorMessage: "Authentication Required: capability permission must be read, write or read,write"}
+		}
+	}
+	return perm, nil
+}
+
+// VerifyCapability checks the signature and expiry of a capability token and returns the capability with the
+// configured user of its owner, which requests made with the token run as
+func (self *SwarmDB) VerifyCapability(token string) (c wire.Capability, u *SWARMDBUser, err error) {
+	c, err = wire.DecodeCapability(token)
+	if err != nil {
+		return c, nil, err
+	}
+	if c.ExpiresMs <= nowMs() {
+		return c, nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[capability:VerifyCapability] expired at %d", c.ExpiresMs), ErrorCode: 489, ErrorMessage: "Authentication Required: capability expired"}
+	}
+	if _, err = parseCapabilityPermission(c.Permission); err != nil {
+		return c, nil, err
+	}
+	if len(c.Database) == 0 || !common.IsHexAddress(c.Owner) {
+		return c, nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[capability:VerifyCapability] owner [%s] database [%s]", c.Owner, c.Database), ErrorCode: 489, ErrorMessage: "Authentication Required: capability needs an owner address and a database"}
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(c.Signature, "0x"))
+	if err != nil {
+		return c, nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[capability:VerifyCapability] DecodeString %s", err.Error()), ErrorCode: 419, ErrorMessage: "Invalid Signature Length: Must be 65 characters"}
+	}
+	u, err = self.dbchunkstore.GetKeyManager().VerifyMessage(SignHash(c.SigningPayload()), sig)
+	if err != nil {
+		return c, nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[capability:VerifyCapability] VerifyMessage %s", err.Error()))
+	}
+	if common.HexToAddress(u.Address) != common.HexToAddress(c.Owner) {
+		return c, nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[capability:VerifyCapability] owner %s, signed by %s", c.Owner, u.Address), ErrorCode: 490, ErrorMessage: "Access Denied: capability not signed by its owner"}
+	}
+	return c, u, nil
+}
+
+// CheckCapability verifies the capability of req and that req lies within its scope, filling in the owner and
+// database of req from it; it returns the user req runs as
+func (self *SwarmDB) CheckCapability(req *wire.Request) (u *SWARMDBUser, err error) {
+	c, u, err := self.VerifyCapability(req.Capability)
+	if err != nil {
+		return nil, err
+	}
+	d := &req.RequestOption
+	owner := strings.ToLower(c.Owner)
+	if len(d.Database) == 0 {
+		d.Database = c.Database
+	}
+	if (len(d.Owner) > 0 && strings.ToLower(d.Owner) != owner) || d.Database != c.Database {
+		return nil, capabilityDenied(d, "owner or database")
+	}
+	d.Owner = owner
+
+	var need uint8
+	switch d.RequestType {
+	case sdbc.RT_GET, sdbc.RT_SCAN, sdbc.RT_DESCRIBE_TABLE, wire.RT_VERSIONS, wire.RT_SCAN_RANGE, wire.RT_GET_MULTI, wire.RT_TABLE_INFO, wire.RT_GET_BLOB:
+		need = ACL_READ
+	case sdbc.RT_PUT, sdbc.RT_DELETE, wire.RT_INCREMENT, wire.RT_PUT_BLOB_PART, wire.RT_PUT_BLOB:
+		need = ACL_WRITE
+	case sdbc.RT_QUERY:
+		query, err := ParseQuery(d.RawQuery)
+		if err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[capability:CheckCapability] ParseQuery %s", err.Error()))
+		}
+		if len(d.Table) == 0 {
+			d.Table = query.Table
+		}
+		if d.Table != query.Table {
+			return nil, capabilityDenied(d, "query table")
+		}
+		need = queryPermission(&query)
+	default:
+		return nil, capabilityDenied(d, "request type")
+	}
+	perm, _ := parseCapabilityPermission(c.Permission)
+	if perm&need != need {
+		return nil, capabilityDenied(d, "permission")
+	}
+	if len(c.Table) > 0 && d.Table != c.Table {
+		return nil, capabilityDenied(d, "table")
+	}
+	if len(c.KeyPrefix) > 0 {
+		if err = self.checkKeyPrefix(u, req, c.KeyPrefix); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// checkKeyPrefix checks that req only touches rows whose primary key starts with prefix
+func (self *SwarmDB) checkKeyPrefix(u *SWARMDBUser, req *wire.Request, prefix string) (err error) {
+	d := &req.RequestOption
+	inPrefix := func(key interface{}) bool {
+		return key != nil && strings.HasPrefix(fmt.Sprintf("%v", key), prefix)
+	}
+	switch d.RequestType {
+	case sdbc.RT_GET, sdbc.RT_DELETE, wire.RT_VERSIONS, wire.RT_INCREMENT:
+		if !inPrefix(d.Key) {
+			return capabilityDenied(d, "key prefix")
+		}
+	case wire.RT_GET_MULTI:
+		keys, _ := d.Key.([]interface{})
+		for _, key := range keys {
+			if !inPrefix(key) {
+				return capabilityDenied(d, "key prefix")
+			}
+		}
+	case sdbc.RT_PUT:
+		tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[capability:checkKeyPrefix] GetTable %s", err.Error()))
+		}
+		for _, row := range d.Rows {
+			if !inPrefix(row[tbl.primaryColumnName]) {
+				return capabilityDenied(d, "key prefix")
+			}
+		}
+	case wire.RT_SCAN_RANGE:
+		// the range must lie in [prefix, the first key after every key starting with prefix), which holds only the
+		// keys starting with prefix when keys order as strings
+		tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[capability:checkKeyPrefix] GetTable %s", err.Error()))
+		}
+		if t := tbl.columns[tbl.primaryColumnName].columnType; t != sdbc.CT_STRING && t != CT_UUID {
+			return capabilityDenied(d, "scan range of a key that is not a string")
+		}
+		if req.Range == nil || !inPrefix(req.Range.Start) || !(inPrefix(req.Range.End) || fmt.Sprintf("%v", req.Range.End) == prefixEnd(prefix)) {
+			return capabilityDenied(d, "scan range outside the key prefix")
+		}
+	case sdbc.RT_DESCRIBE_TABLE:
+	default:
+		// scans and queries are not bounded by the key
+		return capabilityDenied(d, "key prefix")
+	}
+	return nil
+}
+
+// prefixEnd returns the first string after every string starting with prefix, or "" when there is none
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+func capabilityDenied(d *sdbc.RequestOption, scope string) error {
+	return &sdbc.SWARMDBError{Message: fmt.Sprintf("[capability:CheckCapability] %s request on [%s/%s/%s] outside the capability: %s", d.RequestType, d.Owner, d.Database, d.Table, scope), ErrorCode: 490, ErrorMessage: fmt.Sprintf("Access Denied: %s outside the scope of the capability", scope)}
+}
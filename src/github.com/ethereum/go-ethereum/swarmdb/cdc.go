@@ -0,0 +1,272 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"sync"
+)
+
+// With config.ChangeStream set, every Put and Delete is appended to the change stream of its table once it is
+// committed, at the same point its TableEvent is published: at once on unbuffered tables, on FlushBuffer or commit
+// on buffered ones, so rolled back writes never show up.  A record holds the operation, the key, the row before and
+// after the change as stored (sealed cells stay sealed until a reader holding the keys reads them), the root hash
+// the table was published at and the version of the row written.
+//
+// The stream is kept in the leveldb of the chunk store, under CHANGE_PREFIX, the table key, a zero byte and a big
+// endian sequence number, so it survives restarts and reads back in commit order.  The position of a record is
+// the hex of its sequence number; Changes returns the records after a position, so a consumer tails the stream by
+// passing the position of the last record it processed.  Sharded tables keep a stream per shard.
+const (
+	CHANGE_PREFIX    = "cdc/"
+	CHANGES_PAGE_MAX = 1000 // records one Changes call returns at most, and by default
+)
+
+// ChangeRecord is one committed row change of a table, see Changes
+type ChangeRecord struct {
+	Position string      `json:"position"`
+	Op       string      `json:"op"` // TE_PUT or TE_DELETE
+	Key      interface{} `json:"key"`
+	Before   sdbc.Row    `json:"before,omitempty"` // nil when the Put created the row
+	After    sdbc.Row    `json:"after,omitempty"`  // nil for Deletes
+	Roothash string      `json:"roothash"`
+	Version  int         `json:"version,omitempty"` // of the row a Put wrote
+	Time     int64       `json:"time"`              // of the commit, in unix milliseconds
+}
+
+// storedChange is the form a ChangeRecord is kept in
+type storedChange struct {
+	Op       string          `json:"op"`
+	Key      interface{}     `json:"key"`
+	Before   json.RawMessage `json:"before,omitempty"`
+	After    json.RawMessage `json:"after,omitempty"`
+	Roothash string          `json:"roothash"`
+	Version  int             `json:"version,omitempty"`
+	Time     int64           `json:"time"`
+}
+
+type changeStream struct {
+	mu   sync.Mutex
+	ldb  *leveldb.DB
+	next map[string]uint64 // sequence number of the next record per table key
+}
+
+func newChangeStream(ldb *leveldb.DB) *changeStream {
+	return &changeStream{ldb: ldb, next: make(map[string]uint64)}
+}
+
+func changePrefix(tableKey string) []byte {
+	return append([]byte(CHANGE_PREFIX+tableKey), 0)
+}
+
+func changeKey(tableKey string, seq uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], seq)
+	return append(changePrefix(tableKey), b[:]...)
+}
+
+func changePosition(seq uint64) string {
+	return fmt.Sprintf("%016x", seq)
+}
+
+func parseChangePosition(position string) (seq uint64, err error) {
+	if len(position) == 0 {
+		return 0, nil
+	}
+	b, err := hex.DecodeString(position)
+	if err != nil || len(b) != 8 {
+		return 0, &sdbc.SWARMDBError{Message: fmt.Sprintf("[cdc:parseChangePosition] position %s", position), ErrorCode: 418, ErrorMessage: "Request Invalid: position must come from a change record"}
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// append stores change as the next record of the stream of tableKey
+func (s *changeStream) append(tableKey string, change *storedChange) (err error) {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[cdc:append] Marshal %s", err.Error()), ErrorCode: 435, ErrorMessage: "Invalid Row Data"}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq, ok := s.next[tableKey]
+	if !ok {
+		seq = 1
+		iter := s.ldb.NewIterator(util.BytesPrefix(changePrefix(tableKey)), nil)
+		if iter.Last() {
+			seq = binary.BigEndian.Uint64(iter.Key()[len(iter.Key())-8:]) + 1
+		}
+		iter.Release()
+	}
+	if err = s.ldb.Put(changeKey(tableKey, seq), data, nil); err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[cdc:append] Put %s", err.Error()), ErrorCode: 462, ErrorMessage: "Unable to Store Change Record"}
+	}
+	s.next[tableKey] = seq + 1
+	return nil
+}
+
+// last returns the sequence number of the last record of the stream of tableKey, 0 when there is none
+func (s *changeStream) last(tableKey string) (seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if next, ok := s.next[tableKey]; ok {
+		return next - 1
+	}
+	iter := s.ldb.NewIterator(util.BytesPrefix(changePrefix(tableKey)), nil)
+	defer iter.Release()
+	if iter.Last() {
+		return binary.BigEndian.Uint64(iter.Key()[len(iter.Key())-8:])
+	}
+	return 0
+}
+
+// read returns up to limit records of the stream of tableKey following the record at position after
+func (s *changeStream) read(tableKey string, after uint64, limit int, fn func(seq uint64, change *storedChange) error) (err error) {
+	r := util.BytesPrefix(changePrefix(tableKey))
+	r.Start = changeKey(tableKey, after+1)
+	iter := s.ldb.NewIterator(r, nil)
+	defer iter.Release()
+	for n := 0; n < limit && iter.Next(); n++ {
+		var change storedChange
+		if err = json.Unmarshal(iter.Value(), &change); err != nil {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[cdc:read] Unmarshal %s", err.Error()), ErrorCode: 439, ErrorMessage: "Unable to Parse Change Record"}
+		}
+		if err = fn(binary.BigEndian.Uint64(iter.Key()[len(iter.Key())-8:]), &change); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// readBefore returns the row k as stored, as JSON, or nil when there is none; an expired row is returned too, so
+// that its purge records what was deleted
+func (t *Table) readBefore(u *SWARMDBUser, k []byte) (before []byte, err error) {
+	value, ok, err := t.readStoredValue(u, k)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return t.rowJSON(value), nil
+}
+
+// recordChange appends the Put or Delete of ev, which is being committed, to the change stream of t.  The change
+// is committed already, so a failure to record it is logged rather than returned.
+func (t *Table) recordChange(ev TableEvent) {
+	s := t.swarmdb.changeStream
+	if s == nil || (ev.Type != TE_PUT && ev.Type != TE_DELETE) {
+		return
+	}
+	change := &storedChange{Op: ev.Type, Key: ev.Key, Before: ev.before, Roothash: fmt.Sprintf("%x", t.roothash), Version: ev.version, Time: nowMs()}
+	if ev.Row != nil {
+		after, err := json.Marshal(ev.Row)
+		if err != nil {
+			log.Error(fmt.Sprintf("[cdc:recordChange] Marshal %s", err.Error()))
+			return
+		}
+		change.After = after
+	}
+	if err := s.append(t.swarmdb.GetTableKey(t.Owner, t.Database, t.tableName), change); err != nil {
+		log.Error(fmt.Sprintf("[cdc:recordChange] table %s %s", t.tableName, err.Error()))
+	}
+}
+
+// Changes returns up to limit records of the change stream of t following the one at position after, oldest first;
+// an empty after starts at the beginning of the stream
+func (t *Table) Changes(u *SWARMDBUser, after string, limit int) (changes []ChangeRecord, err error) {
+	s := t.swarmdb.changeStream
+	if s == nil {
+		return nil, &sdbc.SWARMDBError{Message: "[cdc:Changes] change stream disabled", ErrorCode: 418, ErrorMessage: "Request Invalid: the change stream is not enabled (config.ChangeStream)"}
+	}
+	seq, err := parseChangePosition(after)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > CHANGES_PAGE_MAX {
+		limit = CHANGES_PAGE_MAX
+	}
+	decode := func(raw json.RawMessage) (row sdbc.Row, err error) {
+		if len(raw) == 0 {
+			return nil, nil
+		}
+		return t.byteArrayToRow(t.decryptColumns(u, raw))
+	}
+	err = s.read(t.swarmdb.GetTableKey(t.Owner, t.Database, t.tableName), seq, limit, func(seq uint64, change *storedChange) (err error) {
+		record := ChangeRecord{Position: changePosition(seq), Op: change.Op, Key: change.Key, Roothash: change.Roothash, Version: change.Version, Time: change.Time}
+		if record.Before, err = decode(change.Before); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[cdc:Changes] before %s", err.Error()))
+		}
+		if record.After, err = decode(change.After); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[cdc:Changes] after %s", err.Error()))
+		}
+		changes = append(changes, record)
+		return nil
+	})
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[cdc:Changes] %s", err.Error()))
+	}
+	return changes, nil
+}
+
+// ChangesPosition returns the position of the last record of the change stream of t, from which Changes returns
+// the changes committed from now on
+func (t *Table) ChangesPosition() (position string, err error) {
+	s := t.swarmdb.changeStream
+	if s == nil {
+		return "", &sdbc.SWARMDBError{Message: "[cdc:ChangesPosition] change stream disabled", ErrorCode: 418, ErrorMessage: "Request Invalid: the change stream is not enabled (config.ChangeStream)"}
+	}
+	return changePosition(s.last(t.swarmdb.GetTableKey(t.Owner, t.Database, t.tableName))), nil
+}
+
+// readChanges answers RT_CHANGES: optional Rows[0] {"after": position, "limit": n}; answered with a row per change
+// record, oldest first, whose "position" continues the stream
+func (self *SwarmDB) readChanges(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[cdc:readChanges] GetTable %s", err.Error()))
+	}
+	hidden, err := tbl.checkRead(u)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[cdc:readChanges] checkRead %s", err.Error()))
+	}
+	after, limit := "", 0
+	if len(d.Rows) > 0 {
+		after, _ = d.Rows[0]["after"].(string)
+		if n, ok := toFloat(d.Rows[0]["limit"]); ok {
+			limit = int(n)
+		}
+	}
+	changes, err := tbl.Changes(u, after, limit)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[cdc:readChanges] Changes %s", err.Error()))
+	}
+	for _, c := range changes {
+		row := sdbc.Row{"position": c.Position, "op": c.Op, "key": c.Key, "roothash": c.Roothash, "version": c.Version, "time": c.Time}
+		if c.Before != nil {
+			row["before"] = maskRow(c.Before, hidden)
+		}
+		if c.After != nil {
+			row["after"] = maskRow(c.After, hidden)
+		}
+		resp.Data = append(resp.Data, row)
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
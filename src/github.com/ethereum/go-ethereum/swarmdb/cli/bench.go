@@ -0,0 +1,68 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/ethereum/go-ethereum/swarmdb"
+	"github.com/ethereum/go-ethereum/swarmdb/swarmdblib"
+	"os"
+)
+
+// bench runs "swarmdb-cli bench [flags]": a benchmark workload against a server, or against an embedded instance
+// opened from -config with -embedded, reporting throughput and latency percentiles
+func bench(args []string) (err error) {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	host := flags.String("host", "127.0.0.1", "address of the SWARMDB TCP server")
+	port := flags.Int("port", 2001, "port of the SWARMDB TCP server")
+	privateKey := flags.String("privateKey", "", "hex private key to authenticate with, empty skips authentication")
+	embedded := flags.Bool("embedded", false, "benchmark an in-process instance instead of a server")
+	configFile := flags.String("config", swarmdb.SWARMDBCONF_FILE, "config of the embedded instance")
+	var config swarmdblib.BenchConfig
+	flags.StringVar(&config.Workload, "workload", swarmdblib.BENCH_MIXED, "insert, read, scan or mixed")
+	flags.StringVar(&config.Owner, "owner", "", "owner of the benchmark database, the authenticated address when authenticated")
+	flags.StringVar(&config.Database, "database", swarmdblib.BENCH_DATABASE, "database of the benchmark table, created if missing")
+	flags.StringVar(&config.Table, "table", "", "benchmark table to create, a new one by default")
+	flags.IntVar(&config.Rows, "rows", 1000, "rows loaded before the timed run")
+	flags.IntVar(&config.Ops, "ops", 10000, "timed operations")
+	flags.IntVar(&config.Concurrency, "concurrency", 4, "connections running operations in parallel")
+	flags.IntVar(&config.ValueSize, "valueSize", 100, "bytes per row value")
+	flags.IntVar(&config.ScanLimit, "scanLimit", 50, "rows read by each range scan")
+	flags.Parse(args)
+
+	connection := swarmdblib.ConnectionConfig{IP: *host, Port: *port, PrivateKey: *privateKey}
+	if *embedded {
+		c, err := swarmdb.LoadSWARMDBConfig(*configFile)
+		if err != nil {
+			return err
+		}
+		if connection.Embedded, err = swarmdb.NewEmbeddedServer(c); err != nil {
+			return err
+		}
+	}
+	open := func() (*swarmdblib.SWARMDBConnection, error) {
+		return swarmdblib.Open(connection)
+	}
+
+	result, err := swarmdblib.RunBench(context.Background(), open, config)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(os.Stdout, result.String())
+	return nil
+}
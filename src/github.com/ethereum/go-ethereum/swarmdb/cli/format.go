@@ -0,0 +1,120 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"io"
+	"sort"
+	"strings"
+)
+
+// columnNames is the union of the columns of rows, in sorted order
+func columnNames(rows []sdbc.Row) (names []string) {
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		for name := range row {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func cellString(cell interface{}) string {
+	switch v := cell.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		if v == float64(int64(v)) {
+			return fmt.Sprintf("%d", int64(v))
+		}
+		return fmt.Sprintf("%g", v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// writeRows prints rows as an aligned table, a JSON array or CSV with a header line
+func writeRows(w io.Writer, format string, rows []sdbc.Row) (err error) {
+	names := columnNames(rows)
+	switch format {
+	case "json":
+		out, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", out)
+		return err
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err = cw.Write(names); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			record := make([]string, len(names))
+			for i, name := range names {
+				record[i] = cellString(row[name])
+			}
+			if err = cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		widths := make([]int, len(names))
+		cells := make([][]string, len(rows))
+		for i, name := range names {
+			widths[i] = len(name)
+		}
+		for r, row := range rows {
+			cells[r] = make([]string, len(names))
+			for i, name := range names {
+				cells[r][i] = cellString(row[name])
+				if len(cells[r][i]) > widths[i] {
+					widths[i] = len(cells[r][i])
+				}
+			}
+		}
+		line := func(values []string) {
+			padded := make([]string, len(values))
+			for i, v := range values {
+				padded[i] = fmt.Sprintf(" %-*s ", widths[i], v)
+			}
+			fmt.Fprintln(w, strings.Join(padded, "|"))
+		}
+		line(names)
+		rule := make([]string, len(names))
+		for i := range names {
+			rule[i] = strings.Repeat("-", widths[i]+2)
+		}
+		fmt.Fprintln(w, strings.Join(rule, "+"))
+		for _, values := range cells {
+			line(values)
+		}
+		_, err = fmt.Fprintf(w, "(%d rows)\n", len(rows))
+		return err
+	}
+}
@@ -0,0 +1,592 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// swarmdb-cli is an interactive shell for a SWARMDB TCP server.  Lines are SQL statements, run when a line ends in
+// ";", or backslash commands; \? lists the commands.  "swarmdb-cli bench" runs a benchmark workload instead (see bench.go).
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/ethereum/go-ethereum/swarmdb"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"github.com/ethereum/go-ethereum/swarmdb/swarmdblib"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HISTORY_FILE = ".swarmdb_history" // in the home directory
+	HISTORY_MAX  = 1000
+)
+
+const help = `  \l                      list databases
+  \c DATABASE             use DATABASE
+  \createdb DATABASE      create DATABASE
+  \dropdb DATABASE        drop DATABASE
+  \dt                     list tables
+  \d TABLE                describe TABLE
+  \create TABLE COLUMN TYPE [primary] [INDEX], ...
+                          create TABLE; TYPE is string, int, float, blob or uuid, INDEX is bplus, hash, fulltext or none
+  \drop TABLE             drop TABLE
+  \encrypt TABLE [COLUMN ...]
+                          encrypt the COLUMNs of TABLE in rows written from now on, or show its encrypted columns
+  \import TABLE FILE [HEADER=COLUMN ...]
+                          load a CSV file with a header line into TABLE, renaming headers to columns
+  \export TABLE FILE [jsonl|csv]
+                          write every row of TABLE to FILE, as JSON Lines by default
+  \backup TABLE FILE       archive TABLE to FILE (admin)
+  \restore FILE           restore the table archived in FILE (admin)
+  \chunk HASH [KIND]      decode the chunk HASH; KIND is descriptor, bplus, hashdb, row or raw (admin)
+  \verify [TABLE]         check the tables of the database, or of every database when none is selected (admin)
+  \usage [OWNER] [reset]  show the usage metered per owner; reset starts a new billing period (admin)
+  \balance                show the storage balance, bid and credits of the owner
+  \bid PRICE              set the price the owner pays per chunk replica written
+  \deposit ADDRESS AMOUNT credit a payment received to the balance of ADDRESS (admin)
+  \balances [ADDRESS]     show the storage accounts of every owner and farmer, or of ADDRESS (admin)
+  \audit [LIMIT]          prove custody of escrowed chunks, crediting the farmer of the server (admin)
+  \loglevel [SUBSYSTEM] [LEVEL]
+                          show the log levels of the server or set one; LEVEL is trace, debug, info, warn or error (admin)
+  \format table|json|csv  set the output format
+  \history                show the command history
+  \?                      show this help
+  \q                      quit
+  SQL;                    run a query, e.g. select * from contacts where age >= 30;`
+
+type shell struct {
+	dbc      *swarmdblib.SWARMDBConnection
+	owner    string
+	database string
+	format   string
+	out      io.Writer
+	history  []string
+	histFile string
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := bench(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "swarmdb-cli: %s\n", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	host := flag.String("host", "127.0.0.1", "address of the SWARMDB TCP server")
+	port := flag.Int("port", 2001, "port of the SWARMDB TCP server")
+	privateKey := flag.String("privateKey", "", "hex private key to authenticate with, empty skips authentication")
+	owner := flag.String("owner", "", "owner of the databases, defaults to the authenticated address")
+	database := flag.String("database", "", "database to use")
+	format := flag.String("format", "table", "output format: table, json or csv")
+	execute := flag.String("e", "", "run this command and exit")
+	timeout := flag.Duration("timeout", 0, "deadline of each command, e.g. 30s; 0 waits as long as the server takes")
+	flag.Parse()
+
+	dbc, err := swarmdblib.Open(swarmdblib.ConnectionConfig{IP: *host, Port: *port, PrivateKey: *privateKey, DialTimeout: 10 * time.Second, RequestTimeout: *timeout})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "swarmdb-cli: %s\n", err.Error())
+		os.Exit(1)
+	}
+	defer dbc.Close()
+	sh := &shell{dbc: dbc, owner: *owner, format: *format, out: os.Stdout}
+	if len(sh.owner) == 0 {
+		sh.owner = dbc.Owner
+	}
+	if len(*database) > 0 {
+		if err = sh.use(*database); err != nil {
+			fmt.Fprintf(os.Stderr, "swarmdb-cli: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	if len(*execute) > 0 {
+		if _, err = sh.run(*execute); err != nil {
+			fmt.Fprintf(os.Stderr, "swarmdb-cli: %s\n", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if home := os.Getenv("HOME"); len(home) > 0 {
+		sh.histFile = filepath.Join(home, HISTORY_FILE)
+		sh.loadHistory()
+	}
+	sh.repl(os.Stdin)
+}
+
+func (sh *shell) prompt(continued bool) string {
+	name := sh.database
+	if len(name) == 0 {
+		name = "swarmdb"
+	}
+	if continued {
+		return name + "-> "
+	}
+	return name + "=> "
+}
+
+// repl reads commands until \q or EOF; SQL may span lines up to the terminating ";"
+func (sh *shell) repl(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	var pending []string
+	fmt.Fprint(sh.out, sh.prompt(false))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(line) == 0:
+		case len(pending) == 0 && strings.HasPrefix(line, "\\"):
+			sh.remember(line)
+			quit, err := sh.run(line)
+			if err != nil {
+				fmt.Fprintf(sh.out, "ERROR: %s\n", err.Error())
+			}
+			if quit {
+				return
+			}
+		default:
+			pending = append(pending, line)
+			if strings.HasSuffix(line, ";") {
+				statement := strings.Join(pending, " ")
+				pending = nil
+				sh.remember(statement)
+				if _, err := sh.run(statement); err != nil {
+					fmt.Fprintf(sh.out, "ERROR: %s\n", err.Error())
+				}
+			}
+		}
+		fmt.Fprint(sh.out, sh.prompt(len(pending) > 0))
+	}
+	fmt.Fprintln(sh.out)
+}
+
+// run executes one backslash command or SQL statement; quit is set by \q
+func (sh *shell) run(line string) (quit bool, err error) {
+	if !strings.HasPrefix(line, "\\") {
+		return false, sh.query(strings.TrimSuffix(line, ";"))
+	}
+	fields := strings.Fields(line)
+	command, args := fields[0], fields[1:]
+	switch command {
+	case "\\q":
+		return true, nil
+	case "\\?":
+		fmt.Fprintln(sh.out, help)
+	case "\\history":
+		for i, h := range sh.history {
+			fmt.Fprintf(sh.out, "%5d  %s\n", i+1, h)
+		}
+	case "\\format":
+		if len(args) != 1 || (args[0] != "table" && args[0] != "json" && args[0] != "csv") {
+			return false, fmt.Errorf("usage: \\format table|json|csv")
+		}
+		sh.format = args[0]
+	case "\\l":
+		return false, sh.request(sdbc.RequestOption{RequestType: sdbc.RT_LIST_DATABASES, Owner: sh.owner})
+	case "\\c":
+		if len(args) != 1 {
+			return false, fmt.Errorf("usage: \\c DATABASE")
+		}
+		return false, sh.use(args[0])
+	case "\\createdb", "\\dropdb":
+		if len(args) != 1 {
+			return false, fmt.Errorf("usage: %s DATABASE", command)
+		}
+		requestType := sdbc.RT_CREATE_DATABASE
+		if command == "\\dropdb" {
+			requestType = sdbc.RT_DROP_DATABASE
+		}
+		return false, sh.request(sdbc.RequestOption{RequestType: requestType, Owner: sh.owner, Database: args[0]})
+	case "\\dt":
+		if err = sh.needDatabase(); err != nil {
+			return false, err
+		}
+		return false, sh.request(sdbc.RequestOption{RequestType: sdbc.RT_LIST_TABLES, Owner: sh.owner, Database: sh.database})
+	case "\\d", "\\drop":
+		if len(args) != 1 {
+			return false, fmt.Errorf("usage: %s TABLE", command)
+		}
+		if err = sh.needDatabase(); err != nil {
+			return false, err
+		}
+		requestType := sdbc.RT_DESCRIBE_TABLE
+		if command == "\\drop" {
+			requestType = sdbc.RT_DROP_TABLE
+		}
+		return false, sh.request(sdbc.RequestOption{RequestType: requestType, Owner: sh.owner, Database: sh.database, Table: args[0]})
+	case "\\create":
+		if len(args) < 3 {
+			return false, fmt.Errorf("usage: \\create TABLE COLUMN TYPE [primary] [INDEX], ...")
+		}
+		if err = sh.needDatabase(); err != nil {
+			return false, err
+		}
+		columns, err := parseColumns(strings.Join(args[1:], " "))
+		if err != nil {
+			return false, err
+		}
+		return false, sh.request(sdbc.RequestOption{RequestType: sdbc.RT_CREATE_TABLE, Owner: sh.owner, Database: sh.database, Table: args[0], Columns: columns})
+	case "\\encrypt":
+		if len(args) < 1 {
+			return false, fmt.Errorf("usage: \\encrypt TABLE [COLUMN ...]")
+		}
+		if err = sh.needDatabase(); err != nil {
+			return false, err
+		}
+		var columns []string
+		if len(args) > 1 {
+			columns = args[1:]
+		}
+		encrypted, err := sh.dbc.EncryptColumns(sh.owner, sh.database, args[0], columns)
+		if err != nil {
+			return false, err
+		}
+		rows := make([]sdbc.Row, 0, len(encrypted))
+		for _, name := range encrypted {
+			rows = append(rows, sdbc.Row{"column": name})
+		}
+		return false, writeRows(sh.out, sh.format, rows)
+	case "\\import":
+		if len(args) < 2 {
+			return false, fmt.Errorf("usage: \\import TABLE FILE [HEADER=COLUMN ...]")
+		}
+		if err = sh.needDatabase(); err != nil {
+			return false, err
+		}
+		return false, sh.importCSV(args[0], args[1], args[2:])
+	case "\\export":
+		if len(args) < 2 || len(args) > 3 {
+			return false, fmt.Errorf("usage: \\export TABLE FILE [jsonl|csv]")
+		}
+		if err = sh.needDatabase(); err != nil {
+			return false, err
+		}
+		format := "jsonl"
+		if len(args) == 3 {
+			format = args[2]
+		}
+		return false, sh.export(args[0], args[1], format)
+	case "\\backup":
+		if len(args) != 2 {
+			return false, fmt.Errorf("usage: \\backup TABLE FILE")
+		}
+		if err = sh.needDatabase(); err != nil {
+			return false, err
+		}
+		return false, sh.backup(args[0], args[1])
+	case "\\restore":
+		if len(args) != 1 {
+			return false, fmt.Errorf("usage: \\restore FILE")
+		}
+		f, err := os.Open(args[0])
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		resp, err := sh.dbc.Restore(f)
+		if err != nil {
+			return false, err
+		}
+		return false, writeRows(sh.out, sh.format, resp.Data)
+	case "\\chunk":
+		if len(args) < 1 || len(args) > 2 {
+			return false, fmt.Errorf("usage: \\chunk HASH [KIND]")
+		}
+		row := sdbc.NewRow()
+		row["key"] = args[0]
+		if len(args) == 2 {
+			row["kind"] = args[1]
+		}
+		resp, err := sh.dbc.Admin("InspectChunk", sdbc.RequestOption{Rows: []sdbc.Row{row}})
+		if err != nil {
+			return false, err
+		}
+		// nested columns and entries only read well as JSON
+		return false, writeRows(sh.out, "json", resp.Data)
+	case "\\usage":
+		owner, reset := "", false
+		if len(args) > 0 && args[len(args)-1] == "reset" {
+			reset, args = true, args[:len(args)-1]
+		}
+		if len(args) > 1 {
+			return false, fmt.Errorf("usage: \\usage [OWNER] [reset]")
+		}
+		if len(args) == 1 {
+			owner = args[0]
+		}
+		usage, err := sh.dbc.Usage(owner, reset)
+		if err != nil {
+			return false, err
+		}
+		return false, writeRows(sh.out, sh.format, usage)
+	case "\\balance", "\\bid":
+		var account sdbc.Row
+		if command == "\\balance" && len(args) == 0 {
+			account, err = sh.dbc.Balance(sh.owner)
+		} else if command == "\\bid" && len(args) == 1 {
+			bid, perr := strconv.ParseInt(args[0], 10, 64)
+			if perr != nil {
+				return false, fmt.Errorf("usage: \\bid PRICE")
+			}
+			account, err = sh.dbc.SetBid(sh.owner, bid)
+		} else {
+			return false, fmt.Errorf("usage: \\balance | \\bid PRICE")
+		}
+		if err != nil {
+			return false, err
+		}
+		return false, writeRows(sh.out, sh.format, []sdbc.Row{account})
+	case "\\deposit":
+		var amount int64
+		if len(args) == 2 {
+			amount, err = strconv.ParseInt(args[1], 10, 64)
+		}
+		if len(args) != 2 || err != nil {
+			return false, fmt.Errorf("usage: \\deposit ADDRESS AMOUNT")
+		}
+		account, err := sh.dbc.Deposit(args[0], amount)
+		if err != nil {
+			return false, err
+		}
+		return false, writeRows(sh.out, sh.format, []sdbc.Row{account})
+	case "\\balances":
+		if len(args) > 1 {
+			return false, fmt.Errorf("usage: \\balances [ADDRESS]")
+		}
+		address := ""
+		if len(args) == 1 {
+			address = args[0]
+		}
+		accounts, err := sh.dbc.Balances(address)
+		if err != nil {
+			return false, err
+		}
+		return false, writeRows(sh.out, sh.format, accounts)
+	case "\\audit":
+		limit := 0
+		if len(args) == 1 {
+			limit, err = strconv.Atoi(args[0])
+		}
+		if len(args) > 1 || err != nil {
+			return false, fmt.Errorf("usage: \\audit [LIMIT]")
+		}
+		result, err := sh.dbc.Audit(limit)
+		if err != nil {
+			return false, err
+		}
+		return false, writeRows(sh.out, sh.format, []sdbc.Row{result})
+	case "\\loglevel":
+		req := sdbc.RequestOption{}
+		switch len(args) {
+		case 0:
+		case 1:
+			req.Rows = []sdbc.Row{{"subsystem": "", "level": args[0]}}
+		case 2:
+			req.Rows = []sdbc.Row{{"subsystem": args[0], "level": args[1]}}
+		default:
+			return false, fmt.Errorf("usage: \\loglevel [SUBSYSTEM] [LEVEL]")
+		}
+		resp, err := sh.dbc.Admin("LogLevel", req)
+		if err != nil {
+			return false, err
+		}
+		return false, writeRows(sh.out, sh.format, resp.Data)
+	case "\\verify":
+		if len(args) > 1 || (len(args) == 1 && len(sh.database) == 0) {
+			return false, fmt.Errorf("usage: \\verify [TABLE], with a database selected to verify a table")
+		}
+		table := ""
+		if len(args) == 1 {
+			table = args[0]
+		}
+		reports, err := sh.dbc.Verify(sh.owner, sh.database, table)
+		if err != nil {
+			return false, err
+		}
+		return false, writeRows(sh.out, "json", reports)
+	default:
+		return false, fmt.Errorf("unknown command %s, \\? lists the commands", command)
+	}
+	return false, nil
+}
+
+func (sh *shell) needDatabase() error {
+	if len(sh.database) == 0 {
+		return fmt.Errorf("no database selected, use \\c DATABASE")
+	}
+	return nil
+}
+
+func (sh *shell) use(database string) (err error) {
+	if err = sh.dbc.Use(sh.owner, database); err != nil {
+		return err
+	}
+	sh.database = database
+	return nil
+}
+
+func (sh *shell) query(sql string) error {
+	if err := sh.needDatabase(); err != nil {
+		return err
+	}
+	return sh.request(sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: sh.owner, Database: sh.database, RawQuery: sql})
+}
+
+// request sends req and prints the rows of the response, or its row count when it has none
+func (sh *shell) request(req sdbc.RequestOption) error {
+	resp, err := sh.dbc.ProcessRequestResponseCommand(req)
+	if err != nil {
+		return err
+	}
+	if len(resp.Data) == 0 {
+		fmt.Fprintf(sh.out, "OK, %d row(s) affected\n", resp.AffectedRowCount)
+		return nil
+	}
+	return writeRows(sh.out, sh.format, resp.Data)
+}
+
+// importCSV loads a CSV file, printing the records the server rejected
+func (sh *shell) importCSV(table string, filename string, renames []string) error {
+	mapping := make(map[string]string)
+	for _, rename := range renames {
+		parts := strings.SplitN(rename, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("%s is not HEADER=COLUMN", rename)
+		}
+		mapping[parts[0]] = parts[1]
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	resp, err := sh.dbc.ImportCSV(sh.owner, sh.database, table, f, mapping)
+	if err != nil {
+		return err
+	}
+	if len(resp.Data) > 0 {
+		if err = writeRows(sh.out, sh.format, resp.Data); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(sh.out, "IMPORT %d row(s), %d rejected\n", resp.AffectedRowCount, len(resp.Data))
+	return nil
+}
+
+func (sh *shell) export(table string, filename string, format string) (err error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	n, err := sh.dbc.ExportTable(context.Background(), sh.owner, sh.database, table, format, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "EXPORT %d row(s)\n", n)
+	return nil
+}
+
+func (sh *shell) backup(table string, filename string) (err error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	chunks, err := sh.dbc.Backup(sh.owner, sh.database, table, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "BACKUP %d chunk(s)\n", chunks)
+	return nil
+}
+
+// parseColumns reads "name type [primary] [index], ..." column definitions
+func parseColumns(s string) (columns []sdbc.Column, err error) {
+	for _, def := range strings.Split(s, ",") {
+		fields := strings.Fields(def)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("column [%s] needs a name and a type", strings.TrimSpace(def))
+		}
+		c := sdbc.Column{ColumnName: fields[0], IndexType: sdbc.IT_BPLUSTREE}
+		switch strings.ToLower(fields[1]) {
+		case "string", "text":
+			c.ColumnType = sdbc.CT_STRING
+		case "int", "integer":
+			c.ColumnType = sdbc.CT_INTEGER
+		case "float":
+			c.ColumnType = sdbc.CT_FLOAT
+		case "blob":
+			c.ColumnType = sdbc.CT_BLOB
+		case "uuid":
+			c.ColumnType = swarmdb.CT_UUID
+		default:
+			return nil, fmt.Errorf("column [%s] has unknown type %s", fields[0], fields[1])
+		}
+		for _, opt := range fields[2:] {
+			switch strings.ToLower(opt) {
+			case "primary":
+				c.Primary = 1
+			case "bplus":
+				c.IndexType = sdbc.IT_BPLUSTREE
+			case "hash":
+				c.IndexType = sdbc.IT_HASHTREE
+			case "fulltext":
+				c.IndexType = sdbc.IT_FULLTEXT
+			case "none":
+				c.IndexType = sdbc.IT_NONE
+			default:
+				return nil, fmt.Errorf("column [%s] has unknown option %s", fields[0], opt)
+			}
+		}
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+func (sh *shell) loadHistory() {
+	f, err := os.Open(sh.histFile)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		sh.history = append(sh.history, scanner.Text())
+	}
+	if len(sh.history) > HISTORY_MAX {
+		sh.history = sh.history[len(sh.history)-HISTORY_MAX:]
+	}
+}
+
+// remember adds a command to the history and appends it to the history file
+func (sh *shell) remember(line string) {
+	sh.history = append(sh.history, line)
+	if len(sh.histFile) == 0 {
+		return
+	}
+	f, err := os.OpenFile(sh.histFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
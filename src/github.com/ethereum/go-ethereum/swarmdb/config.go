@@ -19,7 +19,12 @@ import (
 	"encoding/json"
 	"fmt"
 	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"github.com/ethereum/go-ethereum/swarmdb/swarmdblog"
+	"github.com/naoina/toml"
 	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 // SwarmDB Configuration Defaults
@@ -33,9 +38,14 @@ const (
 	SWARMDBCONF_PORTTCP               = 2001
 	SWARMDBCONF_PORTHTTP              = 8501
 	SWARMDBCONF_PORTENS               = 8545
+	SWARMDBCONF_PORTRPC               = 8502
+	SWARMDBCONF_PORTGRPC              = 8503
 	SWARMDBCONF_CURRENCY              = "WLK"
 	SWARMDBCONF_TARGET_COST_STORAGE   = 2.71828
 	SWARMDBCONF_TARGET_COST_BANDWIDTH = 3.14159
+	SWARMDBCONF_DEFAULT_BID           = 1
+	SWARMDBCONF_SHUTDOWN_TIMEOUT      = 30  // seconds
+	SWARMDBCONF_IDEMPOTENCY_TTL       = 600 // seconds
 )
 
 type SWARMDBUser struct {
@@ -48,6 +58,10 @@ type SWARMDBUser struct {
 	sk             []byte
 	publicK        [32]byte
 	secretK        [32]byte
+	traceID        string          // set per request by WithTrace
+	deadline       time.Time       // set per request by WithDeadline
+	buffers        map[string]bool // set per request by WithBuffers
+	budget         *requestBudget  // set per read request by WithBudget, see budget.go
 }
 
 type SWARMDBConfig struct {
@@ -57,13 +71,70 @@ type SWARMDBConfig struct {
 	ListenAddrHTTP string `json:"listenAddrHTTP,omitempty"` // IP for HTTP server
 	PortHTTP       int    `json:"portHTTP,omitempty"`       // port for HTTP server
 
+	ListenAddrRPC string   `json:"listenAddrRPC,omitempty"` // IP for JSON-RPC server (HTTP + WS)
+	PortRPC       int      `json:"portRPC,omitempty"`       // port for JSON-RPC server, 0 disables it
+	IPCPath       string   `json:"ipcPath,omitempty"`       // unix socket for JSON-RPC over IPC, empty disables it
+	RPCOrigins    []string `json:"rpcOrigins,omitempty"`    // web origins allowed to open a WS connection to the JSON-RPC server, empty allows localhost
+
+	ListenAddrGRPC string `json:"listenAddrGRPC,omitempty"` // IP for gRPC server
+	PortGRPC       int    `json:"portGRPC,omitempty"`       // port for gRPC server
+
+	TLSCertFile     string `json:"tlsCertFile,omitempty"`     // PEM server certificate, empty disables TLS on all listeners
+	TLSKeyFile      string `json:"tlsKeyFile,omitempty"`      // PEM private key for TLSCertFile
+	TLSClientCAFile string `json:"tlsClientCAFile,omitempty"` // PEM CA bundle used to verify client certificates
+	TLSClientAuth   int    `json:"tlsClientAuth,omitempty"`   // 0 - no client certificates, 1 - verify if given, 2 - required (mutual TLS)
+
 	Address    string `json:"address,omitempty"`    // the address that earns, must be in keystore directory
 	PrivateKey string `json:"privateKey,omitempty"` // to access child chain
 
 	ChunkDBPath    string        `json:"chunkDBPath,omitempty"`    // the directory of the SWARMDB local databases (SWARMDBCONF_CHUNKDB_PATH)
+	ENSDBPath      string        `json:"ensDBPath,omitempty"`      // sqlite file of the ENS simulation, defaults to ChunkDBPath/ens.db
 	KeystorePath   string        `json:"usersKeysPath,omitempty"`  // directory containing the keystore of Ethereum wallets (SWARMDBCONF_KEYSTORE_PATH)
 	Authentication int           `json:"authentication,omitempty"` // 0 - authentication is not required, 1 - required 2 - only users data stored
 	Users          []SWARMDBUser `json:"users,omitempty"`          // array of users with permissions
+	Admins         []string      `json:"admins,omitempty"`         // addresses, besides Address, allowed to run admin commands
+
+	ChunkCacheMB   int `json:"chunkCacheMB,omitempty"`   // leveldb block cache of the chunk store in MiB, 0 uses the leveldb default
+	OpenFilesCache int `json:"openFilesCache,omitempty"` // leveldb open files cache of the chunk store, 0 uses the leveldb default
+	QueryCache     int `json:"queryCache,omitempty"`     // SELECT results cached by table root hash, 0 uses QUERY_CACHE_ENTRIES, -1 disables
+	StatementCache int `json:"statementCache,omitempty"` // parsed statements and plans cached by text, 0 uses STATEMENT_CACHE_ENTRIES, -1 disables
+
+	QueryChunkBudget int   `json:"queryChunkBudget,omitempty"` // chunks one read request may retrieve, 0 disables, see budget.go
+	QueryByteBudget  int64 `json:"queryByteBudget,omitempty"`  // bytes of chunks one read request may retrieve, 0 disables
+	TableIdleTimeout int   `json:"tableIdleTimeout,omitempty"` // seconds an unused open table stays in memory, 0 keeps it, see tablecache.go
+
+	MaxRowSize int `json:"maxRowSize,omitempty"` // bytes of the largest row value written, 0 allows what fits a chunk, see limits.go
+	MaxKeySize int `json:"maxKeySize,omitempty"` // bytes of the largest string primary key written, 0 allows K_SIZE_MAX
+
+	ExpiryPurgeInterval int `json:"expiryPurgeInterval,omitempty"` // seconds between purges of expired rows, 0 uses the default, -1 disables, see expiry.go
+
+	RequestTimeout  int `json:"requestTimeout,omitempty"`  // seconds to read and answer one request, 0 disables
+	IdleTimeout     int `json:"idleTimeout,omitempty"`     // seconds an idle client connection is kept open, 0 disables
+	ShutdownTimeout int `json:"shutdownTimeout,omitempty"` // seconds in-flight requests get on shutdown (SWARMDBCONF_SHUTDOWN_TIMEOUT)
+	IdempotencyTTL  int `json:"idempotencyTTL,omitempty"`  // seconds the result of a write with an idempotency key is kept (SWARMDBCONF_IDEMPOTENCY_TTL)
+
+	Replica             int `json:"replica,omitempty"`             // 1 - serve reads only, following the root hashes another node publishes to ENS
+	Placement           int `json:"placement,omitempty"`           // 1 - store each row MinReplication times, at addresses in distinct neighborhoods
+	SignRows            int `json:"signRows,omitempty"`            // 1 - sign every row value with the node key, so readers can verify who wrote it
+	JSONRows            int `json:"jsonRows,omitempty"`            // 1 - store row values as JSON rather than binary, for readers that predate rowcodec.go
+	ChangeStream        int `json:"changeStream,omitempty"`        // 1 - keep a durable stream of the row changes committed to each table, see cdc.go
+	ReplicaPollInterval int `json:"replicaPollInterval,omitempty"` // seconds between ENS root hash checks of a replica, 0 uses the default
+
+	Peers        []PeerConfig `json:"peers,omitempty"`        // SwarmDB nodes a Fanout sends sub-queries to and a replica syncs from
+	SyncInterval int          `json:"syncInterval,omitempty"` // seconds between anti-entropy syncs of a replica with Peers, 0 uses the default
+
+	PSSPublishers []string `json:"pssPublishers,omitempty"` // public keys (hex) of writer nodes whose root notices a Notifier subscribes to
+
+	Election ElectionConfig `json:"election,omitempty"` // owners whose tables only an elected leader among the nodes writes
+	Gossip   GossipConfig   `json:"gossip,omitempty"`   // discovery of other nodes and the tables they serve
+
+	RateLimit      RateLimitConfig            `json:"rateLimit,omitempty"`      // applied to every connection and, by default, to every user
+	UserRateLimits map[string]RateLimitConfig `json:"userRateLimits,omitempty"` // per user address overrides of RateLimit
+
+	Log swarmdblog.Config `json:"log,omitempty"` // level, format and sink of the log, and levels per subsystem
+
+	Accounting int   `json:"accounting,omitempty"` // 1 - debit owners their bid per chunk replica written, 2 - also refuse writes they cannot pay for
+	DefaultBid int64 `json:"defaultBid,omitempty"` // price per chunk replica of owners without a bid, 0 uses SWARMDBCONF_DEFAULT_BID
 
 	Currency            string  `json:"currency,omitempty"`            //
 	TargetCostStorage   float64 `json:"targetCostStorage,omitempty"`   //
@@ -96,6 +167,12 @@ func GenerateSampleSWARMDBConfig(privateKey string, address string, passphrase s
 	c.ListenAddrHTTP = SWARMDBCONF_LISTENADDR
 	c.PortHTTP = SWARMDBCONF_PORTHTTP
 
+	c.ListenAddrRPC = SWARMDBCONF_LISTENADDR
+	c.PortRPC = SWARMDBCONF_PORTRPC
+
+	c.ListenAddrGRPC = SWARMDBCONF_LISTENADDR
+	c.PortGRPC = SWARMDBCONF_PORTGRPC
+
 	c.Address = u.Address
 	c.PrivateKey = privateKey
 
@@ -104,9 +181,12 @@ func GenerateSampleSWARMDBConfig(privateKey string, address string, passphrase s
 	c.KeystorePath = SWARMDBCONF_KEYSTORE_PATH
 	c.Users = append(c.Users, u)
 
+	c.ShutdownTimeout = SWARMDBCONF_SHUTDOWN_TIMEOUT
+
 	c.Currency = SWARMDBCONF_CURRENCY
 	c.TargetCostStorage = SWARMDBCONF_TARGET_COST_STORAGE
 	c.TargetCostBandwidth = SWARMDBCONF_TARGET_COST_BANDWIDTH
+	c.DefaultBid = SWARMDBCONF_DEFAULT_BID
 	return c
 }
 
@@ -124,6 +204,7 @@ func SaveSWARMDBConfig(c SWARMDBConfig, filename string) (err error) {
 	return nil
 }
 
+// LoadSWARMDBConfig reads a JSON config file, or a TOML one when filename ends in .toml
 func LoadSWARMDBConfig(filename string) (c *SWARMDBConfig, err error) {
 	// read file
 	c = new(SWARMDBConfig)
@@ -131,9 +212,35 @@ func LoadSWARMDBConfig(filename string) (c *SWARMDBConfig, err error) {
 	if err != nil {
 		return c, &sdbc.SWARMDBError{Message: fmt.Sprintf("[config:LoadSWARMDBConfig] ReadFile %s", err.Error()), ErrorCode: 458, ErrorMessage: "Unable to Load Config File"}
 	}
-	err = json.Unmarshal(dat, c)
+	if strings.HasSuffix(filename, ".toml") {
+		err = toml.Unmarshal(dat, c)
+	} else {
+		err = json.Unmarshal(dat, c)
+	}
 	if err != nil {
 		return c, &sdbc.SWARMDBError{Message: fmt.Sprintf("[config:LoadSWARMDBConfig] Unmarshal %s", err.Error()), ErrorCode: 458, ErrorMessage: "Unable to Load Config File"}
 	}
 	return c, nil
 }
+
+// GetENSDBPath returns ENSDBPath or its default inside ChunkDBPath
+func (self *SWARMDBConfig) GetENSDBPath() string {
+	if len(self.ENSDBPath) > 0 {
+		return self.ENSDBPath
+	}
+	return filepath.Join(self.ChunkDBPath, "ens.db")
+}
+
+func (self *SWARMDBConfig) GetShutdownTimeout() time.Duration {
+	if self.ShutdownTimeout > 0 {
+		return time.Duration(self.ShutdownTimeout) * time.Second
+	}
+	return SWARMDBCONF_SHUTDOWN_TIMEOUT * time.Second
+}
+
+func (self *SWARMDBConfig) GetIdempotencyTTL() time.Duration {
+	if self.IdempotencyTTL > 0 {
+		return time.Duration(self.IdempotencyTTL) * time.Second
+	}
+	return SWARMDBCONF_IDEMPOTENCY_TTL * time.Second
+}
@@ -18,6 +18,10 @@ package swarmdb_test
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/swarmdb/swarmdblog"
+	"io/ioutil"
+	"os"
 	"strings"
 	"swarmdb"
 	"testing"
@@ -42,3 +46,84 @@ func TestConfig(t *testing.T) {
 		t.Fatal("Mismatched output", string(cout), targ)
 	}
 }
+
+func TestConfigTOML(t *testing.T) {
+	f, err := ioutil.TempFile("", "swarmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+	filename := f.Name() + ".toml"
+	conf := `
+ListenAddrTCP = "127.0.0.1"
+PortTCP = 2001
+ChunkDBPath = "/usr/local/swarmdb/data"
+ChunkCacheMB = 64
+IdleTimeout = 300
+`
+	if err := ioutil.WriteFile(filename, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	config, err := swarmdb.LoadSWARMDBConfig(filename)
+	if err != nil {
+		t.Fatal("Did not load TOML config", err)
+	}
+	if config.PortTCP != 2001 || config.ChunkCacheMB != 64 || config.IdleTimeout != 300 {
+		t.Fatalf("Mismatched TOML config %+v", config)
+	}
+	if config.GetENSDBPath() != "/usr/local/swarmdb/data/ens.db" {
+		t.Fatalf("Unexpected default ENSDBPath %s", config.GetENSDBPath())
+	}
+}
+
+func TestLogConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "swarmdb-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+	defer log.Root().SetHandler(log.DiscardHandler())
+
+	conf := swarmdblog.Config{Level: "warn", Format: swarmdblog.FORMAT_JSON, File: f.Name(), Subsystems: map[string]string{"bplus": "debug"}}
+	if err = swarmdblog.Setup(conf); err != nil {
+		t.Fatal("Did not set up the log", err)
+	}
+	swarmdblog.New("bplus").Debug("bplus debug")
+	swarmdblog.New("hashdb").Debug("hashdb debug")
+	swarmdblog.New("hashdb").Warn("hashdb warn")
+	// at runtime
+	if err = swarmdblog.SetLevel("hashdb", "trace"); err != nil {
+		t.Fatal("Did not set the hashdb level", err)
+	}
+	swarmdblog.New("hashdb").Trace("hashdb trace")
+	if err = swarmdblog.SetLevel("bplus", ""); err != nil {
+		t.Fatal("Did not reset the bplus level", err)
+	}
+	swarmdblog.New("bplus").Info("bplus info")
+	if levels := swarmdblog.Levels(); levels[""] != "warn" || levels["hashdb"] != "trace" || len(levels) != 2 {
+		t.Fatalf("Mismatched levels %v", levels)
+	}
+	if swarmdblog.SetLevel("hashdb", "loud") == nil {
+		t.Fatal("Set an unknown level")
+	}
+
+	out, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		var record map[string]interface{}
+		if err = json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Log line is not JSON: %s", line)
+		}
+		msgs = append(msgs, fmt.Sprintf("%v", record["msg"]))
+	}
+	if strings.Join(msgs, ",") != "bplus debug,hashdb warn,hashdb trace" {
+		t.Fatalf("Mismatched log %s", out)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+// Writes to a buffered table reach its in-memory index trees at once but are published only by FlushBuffer, and
+// Scan and ScanRange read a Snapshot of the published roots.  To give a session read-your-writes consistency, the
+// server marks the per-request SWARMDBUser with the tables whose buffer the session itself started (RT_START_BUFFER
+// or RT_BEGIN); reads of those tables go to the live table, buffered writes included, instead of the snapshot.
+
+// WithBuffers returns a copy of u that reads through the buffers of the tables in tableKeys (see GetTableKey)
+func (u *SWARMDBUser) WithBuffers(tableKeys map[string]bool) *SWARMDBUser {
+	if u == nil || len(tableKeys) == 0 {
+		return u
+	}
+	reading := *u
+	reading.buffers = tableKeys
+	return &reading
+}
+
+// readsBuffer reports whether the request u is acting for sees the buffered writes of t
+func (u *SWARMDBUser) readsBuffer(t *Table) bool {
+	return u != nil && t.buffered && u.buffers[t.swarmdb.GetTableKey(t.Owner, t.Database, t.tableName)]
+}
+
+// readView is the table a read of u goes to: t itself when u reads through its buffer, otherwise a Snapshot
+func (t *Table) readView(u *SWARMDBUser) (view *Table, err error) {
+	if u.readsBuffer(t) {
+		return t, nil
+	}
+	return t.Snapshot(u)
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+)
+
+// Coordinator commits the buffered writes of several tables, e.g. an accounts table and its ledger, as one unit.
+// Commit runs two phases: first every table flushes its column indexes and stores its new descriptor chunk, none of
+// which is visible yet; then a commit record listing each table's old and new descriptor root hash is written to the
+// ENS, and all the root hashes are published in the sql transaction that marks the record committed.  If another
+// writer published any of the tables meanwhile, or any flush fails, no table is published and every table is
+// reopened from its last published root hash.
+type Coordinator struct {
+	swarmdb *SwarmDB
+	tables  []*Table
+	done    bool
+}
+
+// NewCoordinator starts a cross-table commit; add its tables with Stage
+func (self *SwarmDB) NewCoordinator() *Coordinator {
+	return &Coordinator{swarmdb: self}
+}
+
+// Stage buffers the writes to a table until Commit or Rollback and returns the table to write to
+func (c *Coordinator) Stage(u *SWARMDBUser, owner string, database string, tableName string) (tbl *Table, err error) {
+	if c.done {
+		return nil, &sdbc.SWARMDBError{Message: "[coordinator:Stage] commit already finished", ErrorCode: 418, ErrorMessage: "Request Invalid: no transaction open"}
+	}
+	tbl, err = c.swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[coordinator:Stage] GetTable %s", err.Error()))
+	}
+	for _, staged := range c.tables {
+		if staged == tbl {
+			return tbl, nil
+		}
+	}
+	if tbl.buffered {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[coordinator:Stage] table %s is already buffered", c.swarmdb.GetTableKey(owner, database, tableName)), ErrorCode: 499, ErrorMessage: "Transaction Conflict: the table is already in a transaction"}
+	}
+	if err = tbl.StartBuffer(u); err != nil {
+		c.swarmdb.UnregisterTable(owner, database, tableName)
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[coordinator:Stage] StartBuffer %s", err.Error()))
+	}
+	tbl.holdFlush = true
+	c.tables = append(c.tables, tbl)
+	return tbl, nil
+}
+
+// Commit publishes the buffered writes of every staged table, or of none
+func (c *Coordinator) Commit(u *SWARMDBUser) (err error) {
+	if c.done {
+		return &sdbc.SWARMDBError{Message: "[coordinator:Commit] commit already finished", ErrorCode: 418, ErrorMessage: "Request Invalid: no transaction open"}
+	}
+	c.done = true
+
+	// phase 1: flush and store every table without publishing
+	roots := make([]map[string][]byte, len(c.tables))
+	swaps := make([]RootHashSwap, len(c.tables))
+	for i, tbl := range c.tables {
+		if roots[i], err = tbl.flushColumns(u); err == nil {
+			swaps[i].RootHash, err = tbl.storeDescriptor(u, roots[i])
+		}
+		if err != nil {
+			c.discard()
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[coordinator:Commit] stage %s %s", tbl.tableName, err.Error()))
+		}
+		swaps[i].IndexName = []byte(c.swarmdb.GetTableKey(tbl.Owner, tbl.Database, tbl.tableName))
+		swaps[i].Expected = tbl.roothash
+	}
+
+	// phase 2: record the commit, then publish all of it at once
+	commitID := NewTraceID()
+	if err = c.swarmdb.ens.prepareCommit(commitID, swaps); err != nil {
+		c.discard()
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[coordinator:Commit] prepareCommit %s", err.Error()))
+	}
+	if err = c.swarmdb.ens.commitRootHashes(commitID, swaps); err != nil {
+		c.discard()
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[coordinator:Commit] commitRootHashes %s", err.Error()))
+	}
+	log.Debug(fmt.Sprintf("[coordinator:Commit] commit %s published %d tables", commitID, len(c.tables)), "trace", u.TraceID())
+	for i, tbl := range c.tables {
+		tbl.setColumnRoots(roots[i])
+		tbl.roothash = swaps[i].RootHash
+		tbl.resetDirty()
+		tbl.buffered, tbl.holdFlush = false, false
+		tbl.publishPendingEvents()
+	}
+	return nil
+}
+
+// Rollback discards the buffered writes of every staged table
+func (c *Coordinator) Rollback(u *SWARMDBUser) (err error) {
+	if c.done {
+		return &sdbc.SWARMDBError{Message: "[coordinator:Rollback] commit already finished", ErrorCode: 418, ErrorMessage: "Request Invalid: no transaction open"}
+	}
+	c.done = true
+	log.Debug(fmt.Sprintf("[coordinator:Rollback] rolling back %d tables", len(c.tables)), "trace", u.TraceID())
+	c.discard()
+	return nil
+}
+
+// discard reopens every staged table from its last published root hash
+func (c *Coordinator) discard() {
+	for _, tbl := range c.tables {
+		c.swarmdb.UnregisterTable(tbl.Owner, tbl.Database, tbl.tableName)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+)
+
+// Increment adds deltas[column] to each named counter column of the row with the given primary key and returns
+// the updated row.  The read and the write happen under the table lock, so concurrent increments are never lost
+// the way a client side Get followed by a Put can lose them.  A missing row, or a missing cell, counts from 0.
+// Counters are integer or float columns other than the primary key.
+func (t *Table) Increment(u *SWARMDBUser, key interface{}, deltas map[string]interface{}) (row sdbc.Row, err error) {
+	if err = t.checkWritable(); err != nil {
+		return row, err
+	}
+	if len(deltas) == 0 {
+		return row, &sdbc.SWARMDBError{Message: "[counter:Increment] no columns to increment", ErrorCode: 418, ErrorMessage: "Request Invalid: Increment needs a column and delta"}
+	}
+	primary, err := t.getPrimaryColumn()
+	if err != nil {
+		return row, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[counter:Increment] getPrimaryColumn %s", err.Error()))
+	}
+	for columnName, delta := range deltas {
+		c, ok := t.columns[columnName]
+		if !ok {
+			return row, &sdbc.SWARMDBError{Message: fmt.Sprintf("[counter:Increment] unknown column %s", columnName), ErrorCode: 404, ErrorMessage: fmt.Sprintf("Column Does Not Exist in table definition: [%s]", columnName)}
+		}
+		if c.primary > 0 || (c.columnType != sdbc.CT_INTEGER && c.columnType != sdbc.CT_FLOAT) {
+			return row, &sdbc.SWARMDBError{Message: fmt.Sprintf("[counter:Increment] column %s is not a counter", columnName), ErrorCode: 501, ErrorMessage: fmt.Sprintf("Column [%s] is not an integer or float column", columnName)}
+		}
+		if _, ok := toFloat(delta); !ok {
+			return row, &sdbc.SWARMDBError{Message: fmt.Sprintf("[counter:Increment] delta %v of %s", delta, columnName), ErrorCode: 501, ErrorMessage: fmt.Sprintf("Delta of [%s] must be a number", columnName)}
+		}
+	}
+	k, err := convertJSONValueToKey(primary.columnType, key)
+	if err != nil {
+		return row, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[counter:Increment] convertJSONValueToKey %s", err.Error()))
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	byteRow, ok, err := t.Get(u, k)
+	if err != nil {
+		return row, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[counter:Increment] Get %s", err.Error()))
+	}
+	row = sdbc.NewRow()
+	if ok {
+		if row, err = t.byteArrayToRow(byteRow); err != nil {
+			return row, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[counter:Increment] byteArrayToRow %s", err.Error()))
+		}
+	}
+	row[t.primaryColumnName] = key
+	for columnName, delta := range deltas {
+		current, _ := toFloat(row[columnName])
+		d, _ := toFloat(delta)
+		if t.columns[columnName].columnType == sdbc.CT_INTEGER {
+			row[columnName] = int(current) + int(d)
+		} else {
+			row[columnName] = current + d
+		}
+	}
+	if err = t.put(u, row); err != nil {
+		return row, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[counter:Increment] Put %s", err.Error()))
+	}
+	return row, nil
+}
+
+// toFloat converts a decoded JSON number, or an int from Go callers, to float64
+func toFloat(v interface{}) (f float64, ok bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case nil:
+		return 0, true
+	}
+	return 0, false
+}
+
+// increment runs an RT_INCREMENT request: d.Key names the row and d.Rows[0] maps counter columns to deltas
+func (self *SwarmDB) increment(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[counter:increment] GetTable %s", err.Error()))
+	}
+	if err = tbl.checkAccess(u, ACL_WRITE); err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[counter:increment] checkAccess %s", err.Error()))
+	}
+	if isNil(d.Key) {
+		return resp, &sdbc.SWARMDBError{Message: "[counter:increment] missing key", ErrorCode: 433, ErrorMessage: "Increment Request Missing Key"}
+	}
+	if len(d.Rows) != 1 {
+		return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[counter:increment] %d delta rows", len(d.Rows)), ErrorCode: 418, ErrorMessage: "Request Invalid: Increment takes one row of column deltas"}
+	}
+	row, err := tbl.Increment(u, d.Key, d.Rows[0])
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[counter:increment] Increment %s", err.Error()))
+	}
+	return sdbc.SWARMDBResponse{AffectedRowCount: 1, MatchedRowCount: 1, Data: []sdbc.Row{row}}, nil
+}
@@ -0,0 +1,374 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"sync"
+)
+
+// Databases group the tables of an owner.  The ENS entry of the owner (the keccak of its name) points at a chunk
+// listing its databases, 64 bytes each: the name, the encryption flag and the hash of the chunk listing the tables
+// of the database (see CreateDatabase and CreateTable).  At [32:64] that chunk holds the hash of the ACL chunk of
+// the database, which is laid out like the ACL of a table descriptor (see acl.go), or nothing for open databases.
+//
+// Permissions granted on a database hold on every table in it, on top of those of the table's own ACL, and a
+// database with grants closes its tables to other addresses as a table ACL does.  Creating a table in it takes
+// ACL_WRITE on the database, dropping it ACL_GRANT; granting on, revoking on and dropping a database without grants
+// take the owner.  RT_GRANT, RT_REVOKE and RT_LIST_GRANTS without a table act on
+// the database.
+//
+// An owner named by an ENS name rather than an address is controlled by the address that created its first
+// database, which CreateDatabase records at OWNER_CONTROLLER of the owner chunk; that address counts as the owner
+// of its databases and tables (see isOwner).  Owner chunks written before have no controller, so nobody owns them.
+//
+// A table is opened only while its database lists it, so DropDatabase drops every table of the database with the
+// one ENS update that removes the database from its owner; the ENS entries of the tables are cleared afterwards.
+const (
+	DATABASE_ACL_REF = 32              // offset of the hash of the ACL chunk in the table list of a database
+	OWNER_CONTROLLER = CHUNK_HASH_SIZE // offset of the address controlling an ENS name owner in its owner chunk
+)
+
+// databaseEntry is a database as listed by its owner
+type databaseEntry struct {
+	ownerHash  []byte
+	ownerRoot  []byte // the hash of ownerChunk, published under ownerHash
+	ownerChunk []byte // the databases of the owner
+	offset     int    // of the entry of the database in ownerChunk
+	encrypted  int
+	chunk      []byte // the tables of the database
+}
+
+// databaseACLCache holds the ACLs of the databases whose tables were checked, see databaseACL
+type databaseACLCache struct {
+	mu   sync.Mutex
+	acls map[string]map[common.Address]uint8
+}
+
+func (c *databaseACLCache) get(key string) (acl map[common.Address]uint8, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	acl, ok = c.acls[key]
+	return acl, ok
+}
+
+func (c *databaseACLCache) set(key string, acl map[common.Address]uint8) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.acls == nil {
+		c.acls = make(map[string]map[common.Address]uint8)
+	}
+	if acl == nil {
+		delete(c.acls, key)
+	} else {
+		c.acls[key] = acl
+	}
+}
+
+// ownerCache holds the controllers of the ENS name owners checked, see isOwner
+type ownerCache struct {
+	mu          sync.Mutex
+	controllers map[string]common.Address
+}
+
+func (c *ownerCache) get(owner string) (controller common.Address, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	controller, ok = c.controllers[owner]
+	return controller, ok
+}
+
+func (c *ownerCache) set(owner string, controller common.Address) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.controllers == nil {
+		c.controllers = make(map[string]common.Address)
+	}
+	c.controllers[owner] = controller
+}
+
+// getDatabaseEntry reads the entry of database from the chunk of its owner and the table list it points at; the
+// entry is nil when the owner has no such database
+func (self *SwarmDB) getDatabaseEntry(u *SWARMDBUser, owner string, database string) (e *databaseEntry, err error) {
+	if len(database) == 0 || len(database) > DATABASE_NAME_LENGTH_MAX {
+		return nil, nil
+	}
+	e = &databaseEntry{ownerHash: crypto.Keccak256([]byte(owner))}
+	if e.ownerRoot, err = self.ens.GetRootHash(u, e.ownerHash); err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[database:getDatabaseEntry] GetRootHash %s", err.Error()))
+	}
+	if EmptyBytes(e.ownerRoot) {
+		return nil, nil
+	}
+	if e.ownerChunk, err = self.RetrieveDBChunk(u, e.ownerRoot); err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[database:getDatabaseEntry] RetrieveDBChunk %s", err.Error()))
+	}
+	if !bytes.Equal(e.ownerChunk[0:CHUNK_HASH_SIZE], e.ownerHash[0:CHUNK_HASH_SIZE]) {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[database:getDatabaseEntry] Invalid owner %x != %x", e.ownerHash, e.ownerChunk[0:CHUNK_HASH_SIZE]), ErrorCode: 450, ErrorMessage: "Invalid Owner Specified"}
+	}
+	name := make([]byte, DATABASE_NAME_LENGTH_MAX)
+	copy(name, database)
+	for i := CHUNK_START_CHUNKVAL + 64; i < CHUNK_SIZE; i += 64 {
+		if !bytes.Equal(e.ownerChunk[i:i+DATABASE_NAME_LENGTH_MAX], name) {
+			continue
+		}
+		e.offset = i
+		if e.ownerChunk[i+DATABASE_NAME_LENGTH_MAX] > 0 {
+			e.encrypted = 1
+		}
+		if e.chunk, err = self.RetrieveDBChunk(u, e.ownerChunk[i+32:i+64]); err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[database:getDatabaseEntry] RetrieveDBChunk %s", err.Error()))
+		}
+		return e, nil
+	}
+	return nil, nil
+}
+
+// putDatabaseEntry stores the table list of e and the owner chunk pointing at it, and moves the ENS entry of the
+// owner from the chunk e was read from to the new one
+func (self *SwarmDB) putDatabaseEntry(u *SWARMDBUser, e *databaseEntry) (err error) {
+	dbHash, err := self.StoreDBChunk(u, e.chunk, e.encrypted)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[database:putDatabaseEntry] StoreDBChunk %s", err.Error()))
+	}
+	copy(e.ownerChunk[e.offset+32:e.offset+64], dbHash[0:CHUNK_HASH_SIZE])
+	ownerRoot, err := self.StoreDBChunk(u, e.ownerChunk, 0)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[database:putDatabaseEntry] StoreDBChunk %s", err.Error()))
+	}
+	if err = self.StoreRootHash(u, e.ownerHash, e.ownerRoot, ownerRoot); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[database:putDatabaseEntry] StoreRootHash %s", err.Error()))
+	}
+	e.ownerRoot = ownerRoot
+	return nil
+}
+
+// tables returns the names of the tables the database of e lists
+func (e *databaseEntry) tables() (names []string) {
+	for j := 64; j < CHUNK_SIZE; j += 64 {
+		if !EmptyBytes(e.chunk[j:(j + TABLE_NAME_LENGTH_MAX)]) {
+			names = append(names, string(bytes.Trim(e.chunk[j:(j+TABLE_NAME_LENGTH_MAX)], "\x00")))
+		}
+	}
+	return names
+}
+
+func (e *databaseEntry) hasTable(tableName string) bool {
+	for _, name := range e.tables() {
+		if name == tableName {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *SwarmDB) readDatabaseACL(u *SWARMDBUser, e *databaseEntry) (acl map[common.Address]uint8, err error) {
+	ref := e.chunk[DATABASE_ACL_REF : DATABASE_ACL_REF+CHUNK_HASH_SIZE]
+	if EmptyBytes(ref) {
+		return make(map[common.Address]uint8), nil
+	}
+	buf, err := self.RetrieveDBChunk(u, ref)
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[database:readDatabaseACL] RetrieveDBChunk %s", err.Error()))
+	}
+	return readACL(buf), nil
+}
+
+// databaseACL returns the ACL of database, empty when the database is open or not known here (as on a replica,
+// which follows the tables but not the owner chunks)
+func (self *SwarmDB) databaseACL(u *SWARMDBUser, owner string, database string) (acl map[common.Address]uint8, err error) {
+	key := self.GetTableKey(owner, database, "")
+	if acl, ok := self.databaseACLs.get(key); ok {
+		return acl, nil
+	}
+	e, err := self.getDatabaseEntry(u, owner, database)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		acl = make(map[common.Address]uint8)
+	} else if acl, err = self.readDatabaseACL(u, e); err != nil {
+		return nil, err
+	}
+	self.databaseACLs.set(key, acl)
+	return acl, nil
+}
+
+// ownedBy reports whether u is the owner, when the owner is an address
+func ownedBy(u *SWARMDBUser, owner string) bool {
+	if u == nil || !common.IsHexAddress(owner) {
+		return false
+	}
+	return common.HexToAddress(owner) == common.HexToAddress(u.Address)
+}
+
+// isOwner reports whether u is the owner, or the address controlling it when the owner is an ENS name
+func (self *SwarmDB) isOwner(u *SWARMDBUser, owner string) bool {
+	if u == nil || common.IsHexAddress(owner) {
+		return ownedBy(u, owner)
+	}
+	controller, ok := self.owners.get(owner)
+	if !ok {
+		ownerRoot, err := self.ens.GetRootHash(u, crypto.Keccak256([]byte(owner)))
+		if err != nil || EmptyBytes(ownerRoot) {
+			return false
+		}
+		ownerChunk, err := self.RetrieveDBChunk(u, ownerRoot)
+		if err != nil {
+			return false
+		}
+		controller = common.BytesToAddress(ownerChunk[OWNER_CONTROLLER : OWNER_CONTROLLER+common.AddressLength])
+		if controller == (common.Address{}) {
+			return false
+		}
+		self.owners.set(owner, controller)
+	}
+	return controller == common.HexToAddress(u.Address)
+}
+
+// checkDatabaseAccess returns an error unless u holds perm on database; a database without grants needs no
+// permission but ACL_GRANT
+func (self *SwarmDB) checkDatabaseAccess(u *SWARMDBUser, owner string, database string, perm uint8) (err error) {
+	if self.isOwner(u, owner) {
+		return nil
+	}
+	acl, err := self.databaseACL(u, owner, database)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[database:checkDatabaseAccess] databaseACL %s", err.Error()))
+	}
+	if (len(acl) == 0 && perm&ACL_GRANT == 0) || (u != nil && acl[common.HexToAddress(u.Address)]&perm == perm) {
+		return nil
+	}
+	return &sdbc.SWARMDBError{Message: fmt.Sprintf("[database:checkDatabaseAccess] user [%+v] lacks permission %d on database [%s]", u, perm, database), ErrorCode: 490, ErrorMessage: fmt.Sprintf("Access Denied to Database [%s]", database)}
+}
+
+// GrantDatabase adds perm for grantee on every table of database; only the owner grants on an open database
+func (self *SwarmDB) GrantDatabase(u *SWARMDBUser, owner string, database string, grantee string, perm uint8) (err error) {
+	return self.updateDatabaseACL(u, owner, database, func(acl map[common.Address]uint8) error {
+		addr := common.HexToAddress(grantee)
+		if _, ok := acl[addr]; !ok && len(acl) >= (ACL_END-ACL_START)/ACL_ENTRY_SIZE {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[database:GrantDatabase] ACL of database [%s] is full", database), ErrorCode: 491, ErrorMessage: "Database ACL is full"}
+		}
+		acl[addr] |= perm
+		return nil
+	})
+}
+
+// RevokeDatabase removes perm from grantee on database
+func (self *SwarmDB) RevokeDatabase(u *SWARMDBUser, owner string, database string, grantee string, perm uint8) (err error) {
+	return self.updateDatabaseACL(u, owner, database, func(acl map[common.Address]uint8) error {
+		addr := common.HexToAddress(grantee)
+		if _, ok := acl[addr]; ok {
+			acl[addr] &^= perm
+			if acl[addr] == 0 {
+				delete(acl, addr)
+			}
+		}
+		return nil
+	})
+}
+
+// DatabaseGrants returns one row per address holding permissions on database, as ListGrants does for a table
+func (self *SwarmDB) DatabaseGrants(u *SWARMDBUser, owner string, database string) (rows []sdbc.Row, err error) {
+	if err = self.checkDatabaseAccess(u, owner, database, ACL_READ); err != nil {
+		return rows, err
+	}
+	acl, err := self.databaseACL(u, owner, database)
+	if err != nil {
+		return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[database:DatabaseGrants] databaseACL %s", err.Error()))
+	}
+	return grantRows(acl), nil
+}
+
+// updateDatabaseACL applies update to the ACL of database, which u needs ACL_GRANT on, and publishes it
+func (self *SwarmDB) updateDatabaseACL(u *SWARMDBUser, owner string, database string, update func(acl map[common.Address]uint8) error) (err error) {
+	if err = self.checkWritable(); err != nil {
+		return err
+	}
+	if err = self.checkDatabaseAccess(u, owner, database, ACL_GRANT); err != nil {
+		return err
+	}
+	e, err := self.getDatabaseEntry(u, owner, database)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[database:updateDatabaseACL] no database [%s]", database), ErrorCode: 443, ErrorMessage: "Database Specified Not Found"}
+	}
+	acl, err := self.readDatabaseACL(u, e)
+	if err != nil {
+		return err
+	}
+	if err = update(acl); err != nil {
+		return err
+	}
+	ref := make([]byte, CHUNK_HASH_SIZE)
+	if len(acl) > 0 {
+		buf := make([]byte, CHUNK_SIZE)
+		writeACL(buf, acl)
+		if ref, err = self.StoreDBChunk(u, buf, e.encrypted); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[database:updateDatabaseACL] StoreDBChunk %s", err.Error()))
+		}
+	}
+	copy(e.chunk[DATABASE_ACL_REF:DATABASE_ACL_REF+CHUNK_HASH_SIZE], ref)
+	if err = self.putDatabaseEntry(u, e); err != nil {
+		return err
+	}
+	self.databaseACLs.set(self.GetTableKey(owner, database, ""), acl)
+	return nil
+}
+
+// DropDatabase removes database, and with it every table it lists, from its owner in one ENS update
+func (self *SwarmDB) DropDatabase(u *SWARMDBUser, owner string, database string) (ok bool, err error) {
+	if err = self.checkWritable(); err != nil {
+		return false, err
+	}
+	if len(database) > DATABASE_NAME_LENGTH_MAX {
+		return false, &sdbc.SWARMDBError{Message: "[database:DropDatabase] Database name length", ErrorCode: 500, ErrorMessage: "Database Name too long (max is 32 chars)"}
+	}
+	e, err := self.getDatabaseEntry(u, owner, database)
+	if err != nil || e == nil {
+		return false, err
+	}
+	if err = self.checkDatabaseAccess(u, owner, database, ACL_GRANT); err != nil {
+		return false, err
+	}
+	tables := e.tables()
+	copy(e.ownerChunk[e.offset:e.offset+64], make([]byte, 64))
+	ownerRoot, err := self.StoreDBChunk(u, e.ownerChunk, 0)
+	if err != nil {
+		return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[database:DropDatabase] StoreDBChunk %s", err.Error()))
+	}
+	if err = self.StoreRootHash(u, e.ownerHash, e.ownerRoot, ownerRoot); err != nil {
+		return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[database:DropDatabase] StoreRootHash %s", err.Error()))
+	}
+	self.databaseACLs.set(self.GetTableKey(owner, database, ""), nil)
+
+	// the tables are gone with the entry; clearing their ENS entries keeps a database created later under the
+	// same name from seeing them
+	for _, tableName := range tables {
+		if err := self.StoreRootHash(u, []byte(self.GetTableKey(owner, database, tableName)), nil, make([]byte, 64)); err != nil {
+			log.Debug(fmt.Sprintf("[database:DropDatabase] StoreRootHash %s %s", tableName, err.Error()))
+		}
+		self.UnregisterTable(owner, database, tableName)
+	}
+	return true, nil
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/ethereum/go-ethereum/rlp"
 	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
@@ -36,11 +37,13 @@ const (
 )
 
 type DBChunkstore struct {
-	ldb      *leveldb.DB
-	km       *KeyManager
-	netstats *Netstats
-	farmer   common.Address
-	filepath string
+	ldb       *leveldb.DB
+	km        *KeyManager
+	netstats  *Netstats
+	farmer    common.Address
+	filepath  string
+	dedup     dedupStats // see dedup.go
+	placement bool       // stores chunks at MinReplication addresses in distinct neighborhoods, see placement.go
 }
 
 type DBChunk struct {
@@ -88,7 +91,11 @@ func (u *ChunkAsh) MarshalJSON() ([]byte, error) {
 
 func NewDBChunkStore(config *SWARMDBConfig, netstats *Netstats) (self *DBChunkstore, err error) {
 	path := config.ChunkDBPath
-	ldb, err := leveldb.OpenFile(path, nil)
+	var o *opt.Options
+	if config.ChunkCacheMB > 0 || config.OpenFilesCache > 0 {
+		o = &opt.Options{BlockCacheCapacity: config.ChunkCacheMB * opt.MiB, OpenFilesCacheCapacity: config.OpenFilesCache}
+	}
+	ldb, err := leveldb.OpenFile(path, o)
 	if err != nil {
 		return self, err
 	}
@@ -116,28 +123,54 @@ func (self *DBChunkstore) GetKeyManager() (km *KeyManager) {
 	return self.km
 }
 
+// Stats reports the leveldb cache and compaction statistics of the chunk store, and its deduplication
+func (self *DBChunkstore) Stats() (row sdbc.Row) {
+	row = sdbc.NewRow()
+	for _, property := range []string{"leveldb.stats", "leveldb.cachedblock", "leveldb.openedtables", "leveldb.blockpool", "leveldb.alivesnaps", "leveldb.aliveiters"} {
+		if value, err := self.ldb.GetProperty(property); err == nil {
+			row[property] = value
+		}
+	}
+	self.dedupRow(row)
+	return row
+}
+
+func (self *DBChunkstore) Close() (err error) {
+	err = self.ldb.Close()
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[dbchunkstore:Close] Close %s", err.Error()), ErrorCode: 462, ErrorMessage: "Unable to Flush DBChunkstore"}
+	}
+	return nil
+}
+
 func (self *DBChunkstore) StoreKChunk(u *SWARMDBUser, key []byte, val []byte, encrypted int) (err error) {
 	self.netstats.StoreChunk()
-	_, err = self.storeChunkInDB(u, val, encrypted, key)
-	return err
+	if _, err = self.storeChunkInDB(u, val, encrypted, key); err != nil {
+		return err
+	}
+	return self.storeReplicas(u, key, val, encrypted, true)
 }
 
 func (self *DBChunkstore) StoreChunk(u *SWARMDBUser, val []byte, encrypted int) (key []byte, err error) {
 	//self.netstats.StoreChunk() -- TODO: Review with Michael and Sourabh
-	return self.storeChunkInDB(u, val, encrypted, key)
+	if key, err = self.storeChunkInDB(u, val, encrypted, key); err != nil {
+		return key, err
+	}
+	return key, self.storeReplicas(u, key, val, encrypted, false)
 }
 
 func (self *DBChunkstore) storeChunkInDB(u *SWARMDBUser, val []byte, encrypted int, k []byte) (key []byte, err error) {
+	log.Trace("[dbchunkstore:StoreChunk]", "trace", u.TraceID(), "key", fmt.Sprintf("%x", k))
 	if len(val) < CHUNK_SIZE {
 		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[dbchunkstore:StoreChunk] Chunk too small (< %s)| %x", CHUNK_SIZE, val), ErrorCode: 439, ErrorMessage: "Unable to Store Chunk"}
 	}
 	var chunk DBChunk
-	var finalSdata []byte
-	finalSdata = make([]byte, CHUNK_SIZE)
 	recordData := val[CHUNK_START_CHUNKVAL : CHUNK_END_CHUNKVAL-40] //MAJOR TODO: figure out how we pass in to ensure <=4096
 	if len(k) > 0 {
 		key = k
-		finalSdata = make([]byte, CHUNK_SIZE)
+		// only copied from by the encoding and the ash below
+		finalSdata := getChunkBuffer()
+		defer releaseChunkBuffer(finalSdata)
 		//log.Debug(fmt.Sprintf("Key: [%x][%v] After Loop recordData length (%d) and start pos %d", key, key, len(recordData), CHUNK_START_CHUNKVAL))
 		copy(finalSdata[0:CHUNK_START_CHUNKVAL], val[0:CHUNK_START_CHUNKVAL])
 		if encrypted > 0 {
@@ -154,6 +187,14 @@ func (self *DBChunkstore) storeChunkInDB(u *SWARMDBUser, val []byte, encrypted i
 		inp := make([]byte, hashChunkSize)
 		copy(inp, val[0:hashChunkSize])
 		key = ash.Computehash(inp)
+		stored, err := self.isStored(key, val, encrypted)
+		if err != nil {
+			return key, &sdbc.SWARMDBError{Message: fmt.Sprintf("[dbchunkstore:StoreChunk] isStored %s", err.Error()), ErrorCode: 439, ErrorMessage: "Unable to Store Chunk"}
+		}
+		self.noteStored(stored)
+		if stored {
+			return key, nil
+		}
 		if encrypted > 0 {
 			chunk.Enc = 1
 			val = self.km.EncryptData(u, val)
@@ -228,8 +269,52 @@ func (self *DBChunkstore) RetrieveRawChunk(key []byte) (val []byte, err error) {
 	return c.Val, nil
 }
 
+// HasChunk reports whether a chunk is stored under key
+func (self *DBChunkstore) HasChunk(key []byte) (ok bool, err error) {
+	ok, err = self.ldb.Has(key, nil)
+	if err != nil {
+		return false, &sdbc.SWARMDBError{Message: fmt.Sprintf("[dbchunkstore:HasChunk] Has %s", err.Error()), ErrorCode: 440, ErrorMessage: "Unable to Retrieve Chunk"}
+	}
+	return ok, nil
+}
+
+// RetrieveStoredChunk returns the chunk under key exactly as stored (an encoded DBChunk), for copying it to another node
+func (self *DBChunkstore) RetrieveStoredChunk(key []byte) (data []byte, ok bool, err error) {
+	data, err = self.ldb.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, &sdbc.SWARMDBError{Message: fmt.Sprintf("[dbchunkstore:RetrieveStoredChunk] Get %s", err.Error()), ErrorCode: 440, ErrorMessage: "Unable to Retrieve Chunk"}
+	}
+	return data, true, nil
+}
+
+// StoreStoredChunk stores a chunk copied with RetrieveStoredChunk from another node under key
+func (self *DBChunkstore) StoreStoredChunk(key []byte, data []byte) (err error) {
+	if _, err = decodeStoredChunk(data); err != nil {
+		return err
+	}
+	if err = self.ldb.Put(key, data, nil); err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[dbchunkstore:StoreStoredChunk] Put %s", err.Error()), ErrorCode: 439, ErrorMessage: "Unable to Store Chunk"}
+	}
+	self.netstats.StoreChunk()
+	return nil
+}
+
+func decodeStoredChunk(data []byte) (c *DBChunk, err error) {
+	c = new(DBChunk)
+	if err = rlp.Decode(bytes.NewReader(data), c); err != nil {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[dbchunkstore:decodeStoredChunk] Decode %s", err.Error()), ErrorCode: 440, ErrorMessage: "Unable to Retrieve Chunk"}
+	}
+	return c, nil
+}
+
 func (self *DBChunkstore) RetrieveChunk(u *SWARMDBUser, key []byte) (val []byte, err error) {
+	log.Trace("[dbchunkstore:RetrieveChunk]", "trace", u.TraceID(), "key", fmt.Sprintf("%x", key))
 	data, err := self.ldb.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		data, err = self.retrieveReplica(u, key)
+	}
 	if err == leveldb.ErrNotFound {
 		log.Debug("Chunk not found")
 		val = make([]byte, CHUNK_SIZE)
@@ -238,10 +323,18 @@ func (self *DBChunkstore) RetrieveChunk(u *SWARMDBUser, key []byte) (val []byte,
 		log.Debug(fmt.Sprintf("Error retrieving Chunk: %s", err.Error()))
 		return val, &sdbc.SWARMDBError{Message: fmt.Sprintf("[dbchunkstore:RetrieveChunk] Get - %s", err.Error()), ErrorCode: 440, ErrorMessage: "unable to Retrieve Chunk"}
 	}
+	if err = u.chargeChunk(len(data)); err != nil {
+		return val, err
+	}
+	return self.openStoredChunk(u, data)
+}
+
+// openStoredChunk decodes a chunk in its stored form, decrypting its value
+func (self *DBChunkstore) openStoredChunk(u *SWARMDBUser, data []byte) (val []byte, err error) {
 	c := new(DBChunk)
 	err = rlp.Decode(bytes.NewReader(data), c)
 	if err != nil {
-		return val, &sdbc.SWARMDBError{Message: fmt.Sprintf("[dbchunkstore:RetrieveChunk] Prepare %s", err.Error()), ErrorCode: 440, ErrorMessage: "Unable to Retrieve Chunk"}
+		return val, &sdbc.SWARMDBError{Message: fmt.Sprintf("[dbchunkstore:openStoredChunk] Prepare %s", err.Error()), ErrorCode: 440, ErrorMessage: "Unable to Retrieve Chunk"}
 	}
 	val = c.Val
 	if string(c.Val[CHUNK_START_CHUNKTYPE:CHUNK_END_CHUNKTYPE]) == "k" {
@@ -251,7 +344,7 @@ func (self *DBChunkstore) RetrieveChunk(u *SWARMDBUser, key []byte) (val []byte,
 	if c.Enc > 0 {
 		val, err = self.km.DecryptData(u, val)
 		if err != nil {
-			return val, &sdbc.SWARMDBError{Message: fmt.Sprintf("[dbchunkstore:RetrieveChunk] DecryptData %s", err.Error()), ErrorCode: 440, ErrorMessage: "Unable to Retrieve Chunk"}
+			return val, &sdbc.SWARMDBError{Message: fmt.Sprintf("[dbchunkstore:openStoredChunk] DecryptData %s", err.Error()), ErrorCode: 440, ErrorMessage: "Unable to Retrieve Chunk"}
 		}
 	}
 	var fullVal []byte
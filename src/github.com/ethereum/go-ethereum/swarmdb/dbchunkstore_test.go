@@ -90,3 +90,50 @@ func TestDBChunkStore(t *testing.T) {
 		}
 	}
 }
+
+func TestDBChunkStoreDedup(t *testing.T) {
+	config, _ := swarmdb.LoadSWARMDBConfig(swarmdb.SWARMDBCONF_FILE)
+	swarmdb.NewKeyManager(config)
+	u := config.GetSWARMDBUser()
+
+	store, err := swarmdb.NewDBChunkStore(config, swarmdb.NewNetstats(config))
+	if err != nil {
+		t.Fatal("Failure to open NewDBChunkStore")
+	}
+	v := make([]byte, 4096)
+	copy(v, fmt.Sprintf("dedup%s", time.Now()))
+	k1, err := store.StoreChunk(u, v, 0)
+	if err != nil {
+		t.Fatal("Failure to StoreChunk", err)
+	}
+	written, deduplicated := store.DedupStats()
+	k2, err := store.StoreChunk(u, v, 0)
+	if err != nil || !bytes.Equal(k1, k2) {
+		t.Fatal("Failure to StoreChunk again", k1, k2, err)
+	}
+	if w, d := store.DedupStats(); w != written || d != deduplicated+1 {
+		t.Fatalf("identical chunk written again: written %d->%d deduplicated %d->%d", written, w, deduplicated, d)
+	}
+
+	// a chunk differing past the hashed bytes has the same key, but is not the same chunk
+	v2 := make([]byte, 4096)
+	copy(v2, v)
+	v2[4095] = 1
+	if k2, err = store.StoreChunk(u, v2, 0); err != nil || !bytes.Equal(k1, k2) {
+		t.Fatal("Failure to StoreChunk", k1, k2, err)
+	}
+	if val, err := store.RetrieveChunk(u, k2); err != nil || !bytes.Equal(val, v2) {
+		t.Fatal("Failure to RetrieveChunk the chunk written last", err)
+	}
+
+	// encrypted chunks are written every time
+	written, deduplicated = store.DedupStats()
+	for i := 0; i < 2; i++ {
+		if _, err = store.StoreChunk(u, v, 1); err != nil {
+			t.Fatal("Failure to StoreChunk encrypted", err)
+		}
+	}
+	if w, d := store.DedupStats(); w != written+2 || d != deduplicated {
+		t.Fatalf("encrypted chunk deduplicated: written %d->%d deduplicated %d->%d", written, w, deduplicated, d)
+	}
+}
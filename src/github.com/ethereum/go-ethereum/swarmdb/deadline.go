@@ -0,0 +1,52 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"time"
+)
+
+// A client may send the deadline of its context with a request (swarmdbwire.Request.Deadline).  Like the trace ID it
+// is carried on the per-request SWARMDBUser, and long running work (scans, batches) checks it so that requests the
+// client has given up on stop consuming the node.
+
+// WithDeadline returns a copy of u that expires at deadline; the zero time means no deadline
+func (u *SWARMDBUser) WithDeadline(deadline time.Time) *SWARMDBUser {
+	if u == nil {
+		return nil
+	}
+	limited := *u
+	limited.deadline = deadline
+	return &limited
+}
+
+// Deadline is the deadline of the request u is acting for
+func (u *SWARMDBUser) Deadline() (deadline time.Time, ok bool) {
+	if u == nil || u.deadline.IsZero() {
+		return deadline, false
+	}
+	return u.deadline, true
+}
+
+// checkDeadline returns a timeout error once the deadline of the request has passed
+func (u *SWARMDBUser) checkDeadline(where string) (err error) {
+	if deadline, ok := u.Deadline(); ok && time.Now().After(deadline) {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[%s] deadline %s exceeded", where, deadline.Format(time.RFC3339Nano)), ErrorCode: 496, ErrorMessage: "Request Deadline Exceeded"}
+	}
+	return nil
+}
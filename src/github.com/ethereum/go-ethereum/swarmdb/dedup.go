@@ -0,0 +1,88 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ethereum/go-ethereum/rlp"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"github.com/syndtr/goleveldb/leveldb"
+	"sync/atomic"
+)
+
+// Index nodes and table descriptors are content addressed (StoreChunk keys them by the hash of their content), so a
+// node that is the same in two tables, or in two flushes of one table, is the same chunk.  StoreChunk does not write
+// a chunk that is stored already, and counts it as deduplicated.  Only unencrypted chunks are deduplicated against
+// unencrypted chunks: an encrypted chunk is sealed with its writer's key, so it is written again either way.  Row
+// chunks are keyed by their row, not their content, and are always written.  The counts run from the start of the
+// process and are reported by ADMIN_CACHE_STATS.
+type dedupStats struct {
+	written      int64 // content addressed chunks written
+	deduplicated int64 // content addressed chunks found stored already
+}
+
+// isStored reports whether the content addressed chunk val is stored under key already, unencrypted, so that writing
+// it again can be skipped.  The key hashes only the first hashChunkSize bytes, so the whole chunk is compared.
+func (self *DBChunkstore) isStored(key []byte, val []byte, encrypted int) (ok bool, err error) {
+	if encrypted > 0 {
+		return false, nil
+	}
+	if ok, err = self.ldb.Has(key, nil); err != nil || !ok {
+		return false, err
+	}
+	data, err := self.ldb.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	var c DBChunk
+	if err = rlp.Decode(bytes.NewReader(data), &c); err != nil {
+		// overwritten by the write
+		return false, nil
+	}
+	return c.Enc == 0 && bytes.Equal(c.Val, val), nil
+}
+
+// noteStored counts a content addressed chunk written, or found stored already when deduplicated
+func (self *DBChunkstore) noteStored(deduplicated bool) {
+	if deduplicated {
+		atomic.AddInt64(&self.dedup.deduplicated, 1)
+		return
+	}
+	atomic.AddInt64(&self.dedup.written, 1)
+}
+
+// DedupStats returns the content addressed chunks written and those not written because they were stored already
+func (self *DBChunkstore) DedupStats() (written int64, deduplicated int64) {
+	return atomic.LoadInt64(&self.dedup.written), atomic.LoadInt64(&self.dedup.deduplicated)
+}
+
+// dedupRow adds the dedup counts and ratio, chunks stored per chunk written, to the stats row
+func (self *DBChunkstore) dedupRow(row sdbc.Row) {
+	written, deduplicated := self.DedupStats()
+	row["chunksWritten"] = written
+	row["chunksDeduplicated"] = deduplicated
+	if written > 0 {
+		row["dedupRatio"] = fmt.Sprintf("%.2f", float64(written+deduplicated)/float64(written))
+	}
+}
+
+// DedupStats returns the content addressed chunks written and those not written because they were stored already
+func (self *SwarmDB) DedupStats() (written int64, deduplicated int64) {
+	return self.dbchunkstore.DedupStats()
+}
@@ -0,0 +1,711 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A collection is a named set of JSON documents of an owner, for users who want MongoDB rather than tables: no
+// schema, nested objects and arrays, and filters on dotted paths such as "address.city".  Documents are identified
+// by their "_id", a string of up to K_SIZE bytes, made up on insert when they have none, and kept in value chunks
+// (see storeValueChunk) indexed by a B+tree of their ids.  A collection is created by the first document inserted
+// into it and published through ENS under the table key of the owner, an empty database and COLLECTION_PREFIX and
+// its name, which no table or keyspace key can be.  The published chunk is a descriptor:
+//
+//	[0:4] COLLECTION_MAGIC, [32:64] root hash of the id B+tree
+//	[64+i*64:96+i*64] path of index i, [96+i*64:128+i*64] root hash of index i
+//
+// A path index is a B+tree of the documents whose value at the path equals a given value, or holds it when the
+// value is an array: its keys are the first 24 bytes of the Keccak256 of the JSON of the value followed by the first
+// 8 bytes of the Keccak256 of the document id, and its values the ids.  FindDocs answers the equality terms of a
+// filter on an indexed path from the index and checks the whole filter on the documents found; without one it reads
+// every document.  A path is indexed by CreateDocIndex, or automatically once DOC_AUTO_INDEX_FILTERS FindDocs on
+// the open collection filtered on it.
+const (
+	COLLECTION_MAGIC       = "doc\x01"
+	COLLECTION_PREFIX      = "doc:"
+	DOC_SIZE_MAX           = CHUNK_SIZE - 4
+	DOC_INDEXES_MAX        = 16
+	DOC_INDEX_PATH_MAX     = 32
+	DOC_AUTO_INDEX_FILTERS = 3    // filters on a path after which FindDocs indexes it
+	DOC_FIND_MAX           = 1000 // documents one FindDocs returns at most
+)
+
+// Collection is an open collection, see doc.go
+type Collection struct {
+	mu       sync.Mutex
+	swarmdb  *SwarmDB
+	Owner    string
+	Name     string
+	ids      OrderedDatabase
+	indexes  map[string]OrderedDatabase // by path
+	filtered map[string]int             // FindDocs filtering on each path not indexed, since the collection was opened
+	roothash []byte                     // of the published descriptor
+}
+
+type collectionRegistry struct {
+	mu   sync.Mutex
+	open map[string]*Collection // by collectionKey
+}
+
+func (self *SwarmDB) collectionKey(owner string, name string) string {
+	return self.GetTableKey(owner, "", COLLECTION_PREFIX+name)
+}
+
+// GetCollection returns the named collection of owner, opening it from its published descriptor when it is not
+// open; with create set, a collection that does not exist is created empty
+func (self *SwarmDB) GetCollection(u *SWARMDBUser, owner string, name string, create bool) (c *Collection, err error) {
+	if !validName(name, TABLE_NAME_LENGTH_MAX) {
+		return nil, invalidRequest("collection", name, fmt.Sprintf("must be up to %d letters, digits, '_', '-' or '.'", TABLE_NAME_LENGTH_MAX))
+	}
+	key := self.collectionKey(owner, name)
+	self.collections.mu.Lock()
+	defer self.collections.mu.Unlock()
+	if c, ok := self.collections.open[key]; ok {
+		return c, nil
+	}
+	roothash, err := self.GetRootHash(u, []byte(key))
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:GetCollection] GetRootHash %s", err.Error()))
+	}
+	c = &Collection{swarmdb: self, Owner: owner, Name: name, indexes: make(map[string]OrderedDatabase), filtered: make(map[string]int)}
+	if !valid_hashid(roothash) {
+		if !create {
+			return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[doc:GetCollection] %s not found", key), ErrorCode: 403, ErrorMessage: fmt.Sprintf("Collection Does Not Exist: Collection [%s] Owner [%s]", name, owner)}
+		}
+		if err = self.checkWritable(); err != nil {
+			return nil, err
+		}
+		if c.ids, err = NewBPlusTreeDB(u, self, nil, sdbc.CT_STRING, false, sdbc.CT_STRING, 0); err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:GetCollection] NewBPlusTreeDB %s", err.Error()))
+		}
+		if err = c.publish(u); err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:GetCollection] publish %s", err.Error()))
+		}
+	} else {
+		buf, err := self.RetrieveDBChunk(u, roothash)
+		if err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:GetCollection] RetrieveDBChunk %s", err.Error()))
+		}
+		if len(buf) < 64 || string(buf[0:4]) != COLLECTION_MAGIC {
+			return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[doc:GetCollection] %s: not a collection descriptor", key), ErrorCode: 439, ErrorMessage: "Unable to Parse Chunk"}
+		}
+		c.roothash = roothash
+		if c.ids, err = NewBPlusTreeDB(u, self, buf[32:64], sdbc.CT_STRING, false, sdbc.CT_STRING, 0); err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:GetCollection] NewBPlusTreeDB %s", err.Error()))
+		}
+		for i := 64; i+64 <= len(buf) && i < 64+DOC_INDEXES_MAX*64; i += 64 {
+			path := string(bytes.TrimRight(buf[i:i+32], "\x00"))
+			if len(path) == 0 {
+				break
+			}
+			if c.indexes[path], err = newBlobIndex(u, self, buf[i+32:i+64]); err != nil {
+				return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:GetCollection] newBlobIndex %s", err.Error()))
+			}
+		}
+	}
+	if self.collections.open == nil {
+		self.collections.open = make(map[string]*Collection)
+	}
+	self.collections.open[key] = c
+	return c, nil
+}
+
+// newBlobIndex opens a B+tree of opaque keys at root, or an empty one when root is not a hash
+func newBlobIndex(u *SWARMDBUser, swarmdb *SwarmDB, root []byte) (index OrderedDatabase, err error) {
+	if !valid_hashid(root) {
+		root = nil
+	}
+	return NewBPlusTreeDB(u, swarmdb, root, sdbc.CT_BLOB, false, sdbc.CT_BLOB, 0)
+}
+
+// publish flushes the indexes of c and publishes its descriptor.  When it fails, e.g. because another writer
+// published meanwhile, the caller drops c with close so the next request reopens the collection.
+func (c *Collection) publish(u *SWARMDBUser) (err error) {
+	buf := getChunkBuffer()
+	defer releaseChunkBuffer(buf)
+	copy(buf[0:4], COLLECTION_MAGIC)
+	if _, err = c.ids.FlushBuffer(u); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:publish] FlushBuffer %s", err.Error()))
+	}
+	copy(buf[32:64], c.ids.GetRootHash())
+	for i, path := range c.indexPaths() {
+		index := c.indexes[path]
+		if _, err = index.FlushBuffer(u); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:publish] %s FlushBuffer %s", path, err.Error()))
+		}
+		copy(buf[64+i*64:96+i*64], path)
+		copy(buf[96+i*64:128+i*64], index.GetRootHash())
+	}
+	swarmhash, err := c.swarmdb.StoreDBChunk(u, buf, 0)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:publish] StoreDBChunk %s", err.Error()))
+	}
+	if err = c.swarmdb.StoreRootHash(u, []byte(c.swarmdb.collectionKey(c.Owner, c.Name)), c.roothash, swarmhash); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:publish] StoreRootHash %s", err.Error()))
+	}
+	c.roothash = swarmhash
+	return nil
+}
+
+// close drops c from the open collections
+func (c *Collection) close() {
+	r := &c.swarmdb.collections
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := c.swarmdb.collectionKey(c.Owner, c.Name)
+	if r.open[key] == c {
+		delete(r.open, key)
+	}
+}
+
+// IndexPaths returns the paths indexed, in order
+func (c *Collection) IndexPaths() (paths []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.indexPaths()
+}
+
+func (c *Collection) indexPaths() (paths []string) {
+	for path := range c.indexes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// docPath returns the value at the dotted path of doc
+func docPath(doc map[string]interface{}, path string) (v interface{}, ok bool) {
+	v = doc
+	for _, field := range strings.Split(path, ".") {
+		m, isMap := v.(map[string]interface{})
+		if !isMap {
+			return nil, false
+		}
+		if v, ok = m[field]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// docIndexKeys returns the keys of the index entries of the document id whose value at the path is v
+func docIndexKeys(v interface{}, id string) (keys [][]byte) {
+	values := []interface{}{v}
+	if a, ok := v.([]interface{}); ok {
+		values = a
+	}
+	idHash := crypto.Keccak256([]byte(id))
+	for _, value := range values {
+		if k := docIndexPrefix(value); k != nil {
+			keys = append(keys, append(k, idHash[0:8]...))
+		}
+	}
+	return keys
+}
+
+// docIndexPrefix returns the first 24 bytes of the index keys of the value, or nil for objects and arrays, which
+// are not indexed
+func docIndexPrefix(value interface{}) []byte {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	return crypto.Keccak256(data)[0:24]
+}
+
+// normalizeDoc returns doc as it reads back from JSON, so that numbers compare and hash alike however they were given
+func normalizeDoc(doc interface{}) (out map[string]interface{}, data []byte, err error) {
+	data, err = json.Marshal(doc)
+	if err != nil {
+		return nil, nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[doc:normalizeDoc] Marshal %s", err.Error()), ErrorCode: 435, ErrorMessage: "Invalid Document"}
+	}
+	if err = json.Unmarshal(data, &out); err != nil || out == nil {
+		return nil, nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[doc:normalizeDoc] %s is not an object", data), ErrorCode: 435, ErrorMessage: "Invalid Document: a document is a JSON object"}
+	}
+	return out, data, nil
+}
+
+// InsertDoc stores doc, a JSON object, as a new document and returns its id, doc["_id"] or one made up
+func (c *Collection) InsertDoc(u *SWARMDBUser, doc map[string]interface{}) (id string, err error) {
+	if err = c.swarmdb.checkWritable(); err != nil {
+		return "", err
+	}
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+	if _, ok := doc["_id"]; !ok {
+		b := make([]byte, 12)
+		if _, err = rand.Read(b); err != nil {
+			return "", &sdbc.SWARMDBError{Message: fmt.Sprintf("[doc:InsertDoc] rand.Read %s", err.Error()), ErrorCode: 462, ErrorMessage: "Unable to Make Document Id"}
+		}
+		withID := make(map[string]interface{}, len(doc)+1)
+		for k, v := range doc {
+			withID[k] = v
+		}
+		withID["_id"] = fmt.Sprintf("%x", b)
+		doc = withID
+	}
+	id, ok := doc["_id"].(string)
+	if !ok {
+		return "", invalidRequest("_id", fmt.Sprintf("%v", doc["_id"]), "must be a string")
+	}
+	k, err := keyspaceKeyOf([]byte(id))
+	if err != nil {
+		return "", err
+	}
+	doc, data, err := normalizeDoc(doc)
+	if err != nil {
+		return "", err
+	}
+	if len(data) > DOC_SIZE_MAX {
+		return "", invalidRequest("document", fmt.Sprintf("%d bytes", len(data)), fmt.Sprintf("must be at most %d bytes of JSON", DOC_SIZE_MAX))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok, err := c.ids.Get(u, k); err != nil {
+		return "", sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:InsertDoc] Get %s", err.Error()))
+	} else if ok {
+		return "", &sdbc.SWARMDBError{Message: fmt.Sprintf("[doc:InsertDoc] %s exists", id), ErrorCode: 499, ErrorMessage: fmt.Sprintf("Conflict: document [%s] exists", id)}
+	}
+	dhash, err := c.swarmdb.storeValueChunk(u, data, 0)
+	if err != nil {
+		return "", sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:InsertDoc] %s", err.Error()))
+	}
+	if _, err = c.ids.Put(u, k, dhash); err != nil {
+		c.close()
+		return "", sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:InsertDoc] Put %s", err.Error()))
+	}
+	for path, index := range c.indexes {
+		if err = indexDoc(u, index, doc, path, id); err != nil {
+			c.close()
+			return "", sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:InsertDoc] %s", err.Error()))
+		}
+	}
+	if err = c.publish(u); err != nil {
+		c.close()
+		return "", sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:InsertDoc] %s", err.Error()))
+	}
+	return id, nil
+}
+
+// indexDoc adds the entries of the document id to the index of path
+func indexDoc(u *SWARMDBUser, index OrderedDatabase, doc map[string]interface{}, path string, id string) (err error) {
+	v, ok := docPath(doc, path)
+	if !ok {
+		return nil
+	}
+	for _, k := range docIndexKeys(v, id) {
+		if _, err = index.Put(u, k, padKey([]byte(id))); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:indexDoc] %s Put %s", path, err.Error()))
+		}
+	}
+	return nil
+}
+
+// getDoc returns the document of the padded id k
+func (c *Collection) getDoc(u *SWARMDBUser, k []byte) (doc map[string]interface{}, ok bool, err error) {
+	v, ok, err := c.ids.Get(u, k)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	data, err := c.swarmdb.retrieveValueChunk(u, v)
+	if err != nil {
+		return nil, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:getDoc] %s", err.Error()))
+	}
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return nil, false, &sdbc.SWARMDBError{Message: fmt.Sprintf("[doc:getDoc] Unmarshal %s", err.Error()), ErrorCode: 439, ErrorMessage: "Unable to Parse Document"}
+	}
+	return doc, true, nil
+}
+
+// CreateDocIndex indexes the documents of c by their value at the dotted path
+func (c *Collection) CreateDocIndex(u *SWARMDBUser, path string) (err error) {
+	if err = c.swarmdb.checkWritable(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.createIndex(u, path)
+}
+
+func (c *Collection) createIndex(u *SWARMDBUser, path string) (err error) {
+	if len(path) == 0 || len(path) > DOC_INDEX_PATH_MAX || path == "_id" {
+		return invalidRequest("path", path, fmt.Sprintf("must be 1 to %d bytes, other than _id", DOC_INDEX_PATH_MAX))
+	}
+	if _, ok := c.indexes[path]; ok {
+		return nil
+	}
+	if len(c.indexes) >= DOC_INDEXES_MAX {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[doc:createIndex] %s: %d indexes", c.Name, len(c.indexes)), ErrorCode: 409, ErrorMessage: fmt.Sprintf("Max Allowed Indexes of a collection exceeded, max is [%d]", DOC_INDEXES_MAX)}
+	}
+	index, err := newBlobIndex(u, c.swarmdb, nil)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:createIndex] newBlobIndex %s", err.Error()))
+	}
+	err = c.scan(u, func(id string, doc map[string]interface{}) (bool, error) {
+		return true, indexDoc(u, index, doc, path, id)
+	})
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:createIndex] %s", err.Error()))
+	}
+	c.indexes[path] = index
+	delete(c.filtered, path)
+	if err = c.publish(u); err != nil {
+		c.close()
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:createIndex] %s", err.Error()))
+	}
+	return nil
+}
+
+// scan calls fn with every document of c in id order until it returns false or an error
+func (c *Collection) scan(u *SWARMDBUser, fn func(id string, doc map[string]interface{}) (bool, error)) (err error) {
+	cursor, err := c.ids.SeekFirst(u)
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:scan] SeekFirst %s", err.Error()))
+	}
+	for {
+		if err = u.checkDeadline("doc:scan"); err != nil {
+			return err
+		}
+		k, _, err := cursor.Next(u)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:scan] cursor %s", err.Error()))
+		}
+		doc, ok, err := c.getDoc(u, k)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if more, err := fn(string(bytes.TrimRight(k, "\x00")), doc); err != nil || !more {
+			return err
+		}
+	}
+}
+
+// lookup calls fn with the documents of c the index of path holds under value, until it returns false or an error
+func (c *Collection) lookup(u *SWARMDBUser, index OrderedDatabase, value interface{}, fn func(id string, doc map[string]interface{}) (bool, error)) (err error) {
+	prefix := docIndexPrefix(value)
+	if prefix == nil {
+		return nil
+	}
+	return seekPrefix(u, index, prefix, func(k []byte, v []byte) (bool, error) {
+		doc, ok, err := c.getDoc(u, padKey(v))
+		if err != nil || !ok {
+			return true, err
+		}
+		return fn(string(bytes.TrimRight(v, "\x00")), doc)
+	})
+}
+
+// seekPrefix calls fn with the entries of the ordered index whose keys start with prefix, in key order, until it
+// returns false or an error
+func seekPrefix(u *SWARMDBUser, index OrderedDatabase, prefix []byte, fn func(k []byte, v []byte) (bool, error)) (err error) {
+	cursor, _, err := index.Seek(u, padKey(prefix))
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:seekPrefix] Seek %s", err.Error()))
+	}
+	for {
+		if err = u.checkDeadline("doc:seekPrefix"); err != nil {
+			return err
+		}
+		k, v, err := cursor.Next(u)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:seekPrefix] cursor %s", err.Error()))
+		}
+		if !bytes.HasPrefix(k, prefix) {
+			return nil
+		}
+		if more, err := fn(k, v); err != nil || !more {
+			return err
+		}
+	}
+}
+
+// FindDocs returns up to limit documents of c, in id order unless an index is used, matching filterJSON, a JSON
+// object of conditions on dotted paths that all must hold:
+//
+//	{"path": value}                 the value at path equals value, or is an array holding it
+//	{"path": {"$op": operand, ...}} with $eq, $ne, $gt, $gte, $lt, $lte and $in (operand an array)
+//
+// An empty filter matches every document.
+func (c *Collection) FindDocs(u *SWARMDBUser, filterJSON string, limit int) (docs []map[string]interface{}, err error) {
+	filter := make(map[string]interface{})
+	if len(strings.TrimSpace(filterJSON)) > 0 {
+		if err = json.Unmarshal([]byte(filterJSON), &filter); err != nil {
+			return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[doc:FindDocs] Unmarshal %s", err.Error()), ErrorCode: 439, ErrorMessage: "Unable to Parse Filter: a filter is a JSON object"}
+		}
+	}
+	if _, err = matchDoc(nil, filter); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > DOC_FIND_MAX {
+		limit = DOC_FIND_MAX
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.noteFilter(u, filter)
+
+	collect := func(id string, doc map[string]interface{}) (bool, error) {
+		match, err := matchDoc(doc, filter)
+		if err != nil || !match {
+			return true, err
+		}
+		docs = append(docs, doc)
+		return len(docs) < limit, nil
+	}
+	paths := make([]string, 0, len(filter))
+	for path := range filter {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		index, ok := c.indexes[path]
+		if !ok {
+			continue
+		}
+		if value, ok := equalityOperand(filter[path]); ok {
+			err = c.lookup(u, index, value, collect)
+			if err != nil {
+				return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:FindDocs] %s", err.Error()))
+			}
+			return docs, nil
+		}
+	}
+	if err = c.scan(u, collect); err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:FindDocs] %s", err.Error()))
+	}
+	return docs, nil
+}
+
+// noteFilter counts the paths filter filters on that are not indexed, and indexes those filtered on often enough.
+// Failing to index only costs speed, so errors are logged rather than returned.
+func (c *Collection) noteFilter(u *SWARMDBUser, filter map[string]interface{}) {
+	for path := range filter {
+		if _, ok := c.indexes[path]; ok || path == "_id" {
+			continue
+		}
+		c.filtered[path]++
+		if c.filtered[path] < DOC_AUTO_INDEX_FILTERS || c.swarmdb.checkWritable() != nil {
+			continue
+		}
+		if err := c.createIndex(u, path); err != nil {
+			log.Error(fmt.Sprintf("[doc:noteFilter] collection %s path %s %s", c.Name, path, err.Error()))
+			// do not retry on every filter
+			c.filtered[path] = 0
+		}
+	}
+}
+
+// equalityOperand returns the value a condition of a filter requires, when it is {"path": value} or {"$eq": value}
+// with a value an index holds: null also matches documents without the path, which are not in the index
+func equalityOperand(cond interface{}) (value interface{}, ok bool) {
+	value = cond
+	if ops, isOps := cond.(map[string]interface{}); isOps {
+		if value, ok = ops["$eq"]; !ok || len(ops) > 1 {
+			return nil, false
+		}
+	}
+	return value, value != nil && docIndexPrefix(value) != nil
+}
+
+// matchDoc reports whether doc satisfies every condition of filter; with a nil doc it only checks the filter
+func matchDoc(doc map[string]interface{}, filter map[string]interface{}) (match bool, err error) {
+	match = true
+	for path, cond := range filter {
+		v, present := docPath(doc, path)
+		ops, isOps := cond.(map[string]interface{})
+		if !isOps || !hasOperators(ops) {
+			match = match && docEquals(v, present, cond)
+			continue
+		}
+		for op, operand := range ops {
+			var ok bool
+			switch op {
+			case "$eq":
+				ok = docEquals(v, present, operand)
+			case "$ne":
+				ok = !docEquals(v, present, operand)
+			case "$gt", "$gte", "$lt", "$lte":
+				cmp, comparable := docCompare(v, operand)
+				switch op {
+				case "$gt":
+					ok = comparable && cmp > 0
+				case "$gte":
+					ok = comparable && cmp >= 0
+				case "$lt":
+					ok = comparable && cmp < 0
+				default:
+					ok = comparable && cmp <= 0
+				}
+			case "$in":
+				values, isArray := operand.([]interface{})
+				if !isArray {
+					return false, invalidRequest("filter", path, "$in takes an array")
+				}
+				for _, value := range values {
+					ok = ok || docEquals(v, present, value)
+				}
+			default:
+				return false, invalidRequest("filter", path, fmt.Sprintf("unknown operator %s", op))
+			}
+			match = match && ok
+		}
+	}
+	return match, nil
+}
+
+// hasOperators reports whether the object cond of a filter is a set of operators rather than a value to match
+func hasOperators(cond map[string]interface{}) bool {
+	for op := range cond {
+		if strings.HasPrefix(op, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// docEquals reports whether the value v, present or not, equals want or is an array holding it
+func docEquals(v interface{}, present bool, want interface{}) bool {
+	if !present {
+		return want == nil
+	}
+	if reflect.DeepEqual(v, want) {
+		return true
+	}
+	if a, ok := v.([]interface{}); ok {
+		for _, e := range a {
+			if reflect.DeepEqual(e, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// docCompare orders two numbers or two strings
+func docCompare(a interface{}, b interface{}) (cmp int, ok bool) {
+	if as, isString := a.(string); isString {
+		bs, isString := b.(string)
+		return strings.Compare(as, bs), isString
+	}
+	af, aok := a.(float64)
+	bf, bok := b.(float64)
+	if !aok || !bok {
+		return 0, false
+	}
+	switch {
+	case af < bf:
+		return -1, true
+	case af > bf:
+		return 1, true
+	}
+	return 0, true
+}
+
+// InsertDoc stores doc as a new document of the named collection of owner, creating the collection when it does
+// not exist, and returns its id
+func (self *SwarmDB) InsertDoc(u *SWARMDBUser, owner string, name string, doc map[string]interface{}) (id string, err error) {
+	c, err := self.GetCollection(u, owner, name, true)
+	if err != nil {
+		return "", err
+	}
+	return c.InsertDoc(u, doc)
+}
+
+// FindDocs returns up to limit documents of the named collection of owner matching filterJSON, see
+// Collection.FindDocs
+func (self *SwarmDB) FindDocs(u *SWARMDBUser, owner string, name string, filterJSON string, limit int) (docs []map[string]interface{}, err error) {
+	c, err := self.GetCollection(u, owner, name, false)
+	if err != nil {
+		return nil, err
+	}
+	return c.FindDocs(u, filterJSON, limit)
+}
+
+// CreateDocIndex indexes the documents of the named collection of owner by their value at the dotted path
+func (self *SwarmDB) CreateDocIndex(u *SWARMDBUser, owner string, name string, path string) (err error) {
+	c, err := self.GetCollection(u, owner, name, false)
+	if err != nil {
+		return err
+	}
+	return c.CreateDocIndex(u, path)
+}
+
+// docRequest answers RT_INSERT_DOC, RT_FIND_DOCS and RT_CREATE_DOC_INDEX on the collection d.Table of d.Owner
+func (self *SwarmDB) docRequest(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	switch d.RequestType {
+	case wire.RT_INSERT_DOC:
+		for _, row := range d.Rows {
+			id, err := self.InsertDoc(u, d.Owner, d.Table, row)
+			if err != nil {
+				return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:docRequest] InsertDoc %s", err.Error()))
+			}
+			resp.Data = append(resp.Data, sdbc.Row{"_id": id})
+		}
+		resp.AffectedRowCount = len(resp.Data)
+	case wire.RT_FIND_DOCS:
+		limit := 0
+		if len(d.Rows) > 0 {
+			if n, ok := toFloat(d.Rows[0]["limit"]); ok {
+				limit = int(n)
+			}
+		}
+		docs, err := self.FindDocs(u, d.Owner, d.Table, d.RawQuery, limit)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:docRequest] FindDocs %s", err.Error()))
+		}
+		for _, doc := range docs {
+			resp.Data = append(resp.Data, sdbc.Row(doc))
+		}
+	case wire.RT_CREATE_DOC_INDEX:
+		path, _ := d.Key.(string)
+		if err = self.CreateDocIndex(u, d.Owner, d.Table, path); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[doc:docRequest] CreateDocIndex %s", err.Error()))
+		}
+		resp.AffectedRowCount = 1
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"sort"
+	"strings"
+)
+
+// Columns may be encrypted one by one, so that a sensitive field does not force encrypting the whole table.  Put
+// replaces the value of an encrypted column with ENCRYPTED_CELL_PREFIX and the hex of the JSON value sealed with the
+// keys of the writer before the row or any index is written; Get opens the cells the reader holds the keys for and
+// leaves the others sealed.  Sealed values cannot be searched, so encrypted columns are not indexed and the primary
+// key cannot be encrypted.  The flag is kept at COLUMN_ENCRYPTED_OFFSET of the column entry in the table descriptor.
+const (
+	COLUMN_ENCRYPTED_OFFSET = 31
+	ENCRYPTED_CELL_PREFIX   = "enc:"
+)
+
+// EncryptedColumns returns the names of the encrypted columns of the table, sorted
+func (t *Table) EncryptedColumns() (names []string) {
+	for name, c := range t.columns {
+		if c.encrypted {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetEncryptedColumns encrypts the named columns, and no others, in the rows written from now on.  Rows already
+// stored keep their cells as they were written.
+func (t *Table) SetEncryptedColumns(u *SWARMDBUser, names []string) (err error) {
+	if err = t.checkGrant(u); err != nil {
+		return err
+	}
+	encrypted := make(map[string]bool)
+	for _, name := range names {
+		c, ok := t.columns[name]
+		if !ok {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[encryption:SetEncryptedColumns] unknown column %s", name), ErrorCode: 404, ErrorMessage: fmt.Sprintf("Column Does Not Exist in table definition: [%s]", name)}
+		}
+		if c.primary > 0 {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[encryption:SetEncryptedColumns] primary column %s", name), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: the primary key [%s] cannot be encrypted", name)}
+		}
+		if c.expires {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[encryption:SetEncryptedColumns] expiry column %s", name), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: the expiry column [%s] cannot be encrypted", name)}
+		}
+		encrypted[name] = true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name, c := range t.columns {
+		c.encrypted = encrypted[name]
+	}
+	return t.updateTableInfo(u)
+}
+
+// encryptColumns returns row with the values of its encrypted columns sealed for u.  row itself is left as is.  Only
+// the cells merge replays as sealed (see replaySealed) are kept; any other value is sealed, including one a client
+// wrote starting with ENCRYPTED_CELL_PREFIX, so that no client value is stored in the clear in an encrypted column.
+func (t *Table) encryptColumns(u *SWARMDBUser, row map[string]interface{}) (out map[string]interface{}, err error) {
+	out = row
+	copied := false
+	for name, c := range t.columns {
+		value, ok := row[name]
+		if !c.encrypted || !ok || t.replaySealed[name] {
+			continue
+		}
+		plain, err := json.Marshal(value)
+		if err != nil {
+			return row, &sdbc.SWARMDBError{Message: fmt.Sprintf("[encryption:encryptColumns] Marshal %s %s", name, err.Error()), ErrorCode: 435, ErrorMessage: "Invalid Row Data"}
+		}
+		if !copied {
+			out = make(map[string]interface{}, len(row))
+			for k, v := range row {
+				out[k] = v
+			}
+			copied = true
+		}
+		out[name] = ENCRYPTED_CELL_PREFIX + hex.EncodeToString(t.swarmdb.dbchunkstore.km.EncryptData(u, plain))
+	}
+	return out, nil
+}
+
+// isSealed reports whether value is a cell sealed by encryptColumns
+func isSealed(value interface{}) bool {
+	s, ok := value.(string)
+	return ok && strings.HasPrefix(s, ENCRYPTED_CELL_PREFIX)
+}
+
+// decryptColumns returns the JSON row raw with the sealed cells u holds the keys for opened.  Cells are recognized
+// by their prefix rather than by the column flags, so rows written before a column stopped being encrypted open too.
+func (t *Table) decryptColumns(u *SWARMDBUser, raw []byte) (out []byte) {
+	if !bytes.Contains(raw, []byte(`"`+ENCRYPTED_CELL_PREFIX)) {
+		return raw
+	}
+	var row map[string]interface{}
+	if err := decodeJSONNumbers(raw, &row); err != nil {
+		return raw
+	}
+	opened := false
+	for name, value := range row {
+		if !isSealed(value) {
+			continue
+		}
+		sealed, err := hex.DecodeString(strings.TrimPrefix(value.(string), ENCRYPTED_CELL_PREFIX))
+		if err != nil {
+			continue
+		}
+		plain, err := t.swarmdb.dbchunkstore.km.DecryptData(u, sealed)
+		if err != nil {
+			// sealed by another writer: the cell stays sealed for u
+			continue
+		}
+		var v interface{}
+		if err = decodeJSONNumbers(plain, &v); err != nil {
+			continue
+		}
+		row[name] = v
+		opened = true
+	}
+	if !opened {
+		return raw
+	}
+	out, err := json.Marshal(row)
+	if err != nil {
+		return raw
+	}
+	return out
+}
+
+// decodeJSONNumbers unmarshals data into v keeping numbers as json.Number, so that they marshal back unchanged
+func decodeJSONNumbers(data []byte, v interface{}) (err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// setEncryptedColumns runs an RT_ENCRYPT_COLUMNS request: d.Rows[0] {"columns": [...]} names the columns to
+// encrypt; without Rows the encrypted columns are only listed
+func (self *SwarmDB) setEncryptedColumns(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[encryption:setEncryptedColumns] GetTable %s", err.Error()))
+	}
+	if len(d.Rows) == 1 {
+		list, ok := d.Rows[0]["columns"].([]interface{})
+		if !ok && d.Rows[0]["columns"] != nil {
+			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[encryption:setEncryptedColumns] columns %v", d.Rows[0]["columns"]), ErrorCode: 418, ErrorMessage: "Request Invalid: columns must be a list of column names"}
+		}
+		names := make([]string, 0, len(list))
+		for _, v := range list {
+			name, ok := v.(string)
+			if !ok {
+				return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[encryption:setEncryptedColumns] column %v", v), ErrorCode: 418, ErrorMessage: "Request Invalid: columns must be a list of column names"}
+			}
+			names = append(names, name)
+		}
+		if err = tbl.SetEncryptedColumns(u, names); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[encryption:setEncryptedColumns] SetEncryptedColumns %s", err.Error()))
+		}
+		resp.AffectedRowCount = len(names)
+	}
+	for _, name := range tbl.EncryptedColumns() {
+		row := sdbc.NewRow()
+		row["column"] = name
+		resp.Data = append(resp.Data, row)
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
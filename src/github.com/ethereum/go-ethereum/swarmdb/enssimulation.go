@@ -17,12 +17,19 @@ package swarmdb
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"github.com/ethereum/go-ethereum/log"
 	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+const (
+	COMMIT_PREPARED  = "prepared"
+	COMMIT_COMMITTED = "committed"
+	COMMIT_ABORTED   = "aborted"
+)
+
 type ENSSimulation struct {
 	filepath string
 	db       *sql.DB
@@ -51,11 +58,40 @@ func NewENSSimulation(path string) (ens ENSSimulation, err error) {
 	if err != nil {
 		return ens, err
 	}
+
+	// two-phase commit records of Coordinator.Commit
+	sql_table = `
+	CREATE TABLE IF NOT EXISTS commits (
+	commitID TEXT NOT NULL PRIMARY KEY,
+	state TEXT NOT NULL,
+	record BLOB,
+	storeDT DATETIME
+	);
+	`
+	_, err = db.Exec(sql_table)
+	if err != nil {
+		return ens, err
+	}
+	// a commit is published in the same sql transaction that marks it committed, so one still prepared was
+	// interrupted before publishing anything
+	_, err = db.Exec(`UPDATE commits SET state = ?, storeDT = CURRENT_TIMESTAMP WHERE state = ?`, COMMIT_ABORTED, COMMIT_PREPARED)
+	if err != nil {
+		return ens, err
+	}
 	return ens, nil
 }
 
-func (self *ENSSimulation) StoreRootHash(u *SWARMDBUser, indexName []byte, roothash []byte) (err error) {
+func (self *ENSSimulation) Close() (err error) {
+	return self.db.Close()
+}
+
+// StoreRootHash maps indexName to roothash.  With expected set the store is a compare-and-swap: it fails with
+// ErrorCode 499 unless indexName still maps to expected, so that concurrent writers cannot overwrite each other.
+func (self *ENSSimulation) StoreRootHash(u *SWARMDBUser, indexName []byte, expected []byte, roothash []byte) (err error) {
 	log.Debug(fmt.Sprintf("[enssimulation:StoreRootHash] indexName: (%s)[%x] => roothash[%x]", indexName, indexName, roothash))
+	if expected != nil {
+		return self.swapRootHash(indexName, expected, roothash)
+	}
 	sql_add := `INSERT OR REPLACE INTO ens ( indexName, roothash, storeDT ) values(?, ?, CURRENT_TIMESTAMP)`
 	stmt, err := self.db.Prepare(sql_add)
 	if err != nil {
@@ -72,6 +108,29 @@ func (self *ENSSimulation) StoreRootHash(u *SWARMDBUser, indexName []byte, rooth
 	return nil
 }
 
+func (self *ENSSimulation) swapRootHash(indexName []byte, expected []byte, roothash []byte) (err error) {
+	res, err := self.db.Exec(`UPDATE ens SET roothash = ?, storeDT = CURRENT_TIMESTAMP WHERE indexName = ? AND roothash = ?`, roothash, indexName, expected)
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[enssimulation:swapRootHash] db.Exec [%s]", err.Error()), ErrorCode: 441, ErrorMessage: "Error Storing RootHash"}
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[enssimulation:swapRootHash] indexName [%s] no longer at roothash [%x]", indexName, expected), ErrorCode: 499, ErrorMessage: "Conflict: the table was changed by another writer, reopen it and retry"}
+	}
+	return nil
+}
+
+// createRootHash stores roothash for indexName unless a value is already stored, failing with ErrorCode 499 then
+func (self *ENSSimulation) createRootHash(indexName []byte, roothash []byte) (err error) {
+	res, err := self.db.Exec(`INSERT OR IGNORE INTO ens ( indexName, roothash, storeDT ) values(?, ?, CURRENT_TIMESTAMP)`, indexName, roothash)
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[enssimulation:createRootHash] db.Exec [%s]", err.Error()), ErrorCode: 441, ErrorMessage: "Error Storing RootHash"}
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[enssimulation:createRootHash] indexName [%s] exists", indexName), ErrorCode: 499, ErrorMessage: "Conflict: the table was changed by another writer, reopen it and retry"}
+	}
+	return nil
+}
+
 func (self *ENSSimulation) GetRootHash(u *SWARMDBUser, indexName []byte) (val []byte, err error) {
 	//TODO: why are we passing in 'u' but not using?
 	log.Debug(fmt.Sprintf("[enssimulation:GetRootHash] indexName: (%s)[%x] => roothash[%x]", indexName, indexName)) //, roothash))
@@ -98,3 +157,77 @@ func (self *ENSSimulation) GetRootHash(u *SWARMDBUser, indexName []byte) (val []
 	}
 	return val, nil
 }
+
+// RootHashSwap is one compare-and-swap of a multi-table commit: IndexName moves from Expected to RootHash
+type RootHashSwap struct {
+	IndexName []byte `json:"indexName"`
+	Expected  []byte `json:"expected"`
+	RootHash  []byte `json:"roothash"`
+}
+
+// prepareCommit records the swaps of a commit before any of them is applied
+func (self *ENSSimulation) prepareCommit(commitID string, swaps []RootHashSwap) (err error) {
+	record, err := json.Marshal(swaps)
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[enssimulation:prepareCommit] Marshal [%s]", err.Error()), ErrorCode: 441, ErrorMessage: "Error Storing RootHash"}
+	}
+	_, err = self.db.Exec(`INSERT INTO commits ( commitID, state, record, storeDT ) values(?, ?, ?, CURRENT_TIMESTAMP)`, commitID, COMMIT_PREPARED, record)
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[enssimulation:prepareCommit] db.Exec [%s]", err.Error()), ErrorCode: 441, ErrorMessage: "Error Storing RootHash"}
+	}
+	return nil
+}
+
+// commitRootHashes applies every swap of a prepared commit and marks it committed, or, if any index no longer maps
+// to its expected root hash, applies none, marks it aborted and fails with ErrorCode 499
+func (self *ENSSimulation) commitRootHashes(commitID string, swaps []RootHashSwap) (err error) {
+	tx, err := self.db.Begin()
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[enssimulation:commitRootHashes] db.Begin [%s]", err.Error()), ErrorCode: 441, ErrorMessage: "Error Storing RootHash"}
+	}
+	for _, swap := range swaps {
+		var res sql.Result
+		if swap.Expected == nil {
+			res, err = tx.Exec(`INSERT OR REPLACE INTO ens ( indexName, roothash, storeDT ) values(?, ?, CURRENT_TIMESTAMP)`, swap.IndexName, swap.RootHash)
+		} else {
+			res, err = tx.Exec(`UPDATE ens SET roothash = ?, storeDT = CURRENT_TIMESTAMP WHERE indexName = ? AND roothash = ?`, swap.RootHash, swap.IndexName, swap.Expected)
+		}
+		if err != nil {
+			tx.Rollback()
+			self.abortCommit(commitID)
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[enssimulation:commitRootHashes] tx.Exec [%s]", err.Error()), ErrorCode: 441, ErrorMessage: "Error Storing RootHash"}
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			tx.Rollback()
+			self.abortCommit(commitID)
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[enssimulation:commitRootHashes] commit %s: indexName [%s] no longer at roothash [%x]", commitID, swap.IndexName, swap.Expected), ErrorCode: 499, ErrorMessage: "Conflict: a table of the commit was changed by another writer, reopen it and retry"}
+		}
+	}
+	if _, err = tx.Exec(`UPDATE commits SET state = ?, storeDT = CURRENT_TIMESTAMP WHERE commitID = ?`, COMMIT_COMMITTED, commitID); err != nil {
+		tx.Rollback()
+		self.abortCommit(commitID)
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[enssimulation:commitRootHashes] tx.Exec [%s]", err.Error()), ErrorCode: 441, ErrorMessage: "Error Storing RootHash"}
+	}
+	if err = tx.Commit(); err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[enssimulation:commitRootHashes] tx.Commit [%s]", err.Error()), ErrorCode: 441, ErrorMessage: "Error Storing RootHash"}
+	}
+	return nil
+}
+
+func (self *ENSSimulation) abortCommit(commitID string) {
+	if _, err := self.db.Exec(`UPDATE commits SET state = ?, storeDT = CURRENT_TIMESTAMP WHERE commitID = ?`, COMMIT_ABORTED, commitID); err != nil {
+		log.Debug(fmt.Sprintf("[enssimulation:abortCommit] %s: %s", commitID, err.Error()))
+	}
+}
+
+// CommitState returns the state of a commit record: COMMIT_PREPARED, COMMIT_COMMITTED, COMMIT_ABORTED, or "" if unknown
+func (self *ENSSimulation) CommitState(commitID string) (state string, err error) {
+	err = self.db.QueryRow(`SELECT state FROM commits WHERE commitID = ?`, commitID).Scan(&state)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", &sdbc.SWARMDBError{Message: fmt.Sprintf("[enssimulation:CommitState] QueryRow [%s]", err.Error()), ErrorCode: 442, ErrorMessage: "Error Retrieving RootHash"}
+	}
+	return state, nil
+}
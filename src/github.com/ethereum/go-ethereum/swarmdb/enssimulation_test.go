@@ -30,7 +30,7 @@ func TestENSSimulation(t *testing.T) {
 	}
 	indexName := []byte("contact")
 	roothash := []byte("contactroothash")
-	store.StoreRootHash(&u, indexName, roothash)
+	store.StoreRootHash(&u, indexName, nil, roothash)
 
 	val, err := store.GetRootHash(&u, indexName)
 	if err != nil {
@@ -0,0 +1,136 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/event"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"strings"
+	"sync"
+)
+
+const (
+	TE_PUT    = "Put"
+	TE_DELETE = "Delete"
+	TE_FLUSH  = "Flush"
+)
+
+// TableEvent is published once a change is committed: immediately for unbuffered tables, on FlushBuffer for buffered ones
+type TableEvent struct {
+	Type     string      `json:"type"`
+	Owner    string      `json:"owner"`
+	Database string      `json:"database"`
+	Table    string      `json:"table"`
+	Key      interface{} `json:"key,omitempty"`
+	Row      sdbc.Row    `json:"row,omitempty"`
+	Roothash string      `json:"roothash,omitempty"`
+	before   []byte      // Put/Delete: the row as stored before the change, for the change stream
+	version  int         // Put: the version of the row written
+}
+
+// Matches reports whether the event is for the given table and (for Put/Delete) has a key starting with keyPrefix
+func (ev *TableEvent) Matches(owner string, database string, table string, keyPrefix string) bool {
+	if ev.Owner != owner || ev.Database != database || ev.Table != table {
+		return false
+	}
+	if len(keyPrefix) == 0 || ev.Type == TE_FLUSH {
+		return true
+	}
+	return strings.HasPrefix(fmt.Sprintf("%v", ev.Key), keyPrefix)
+}
+
+// TableWatch selects the committed Puts and Deletes of a table whose keys start with KeyPrefix, every one when it is
+// empty; a Notifier pushes them to the peers watching them, see Notifier.Watch
+type TableWatch struct {
+	Owner     string `json:"owner"`
+	Database  string `json:"database"`
+	Table     string `json:"table"`
+	KeyPrefix string `json:"keyPrefix,omitempty"`
+}
+
+// Matches reports whether w selects ev
+func (w TableWatch) Matches(ev *TableEvent) bool {
+	return ev.Type != TE_FLUSH && ev.Matches(w.Owner, w.Database, w.Table, w.KeyPrefix)
+}
+
+func (self *SwarmDB) SubscribeTableEvents(ch chan<- TableEvent) event.Subscription {
+	return self.tableFeed.Subscribe(ch)
+}
+
+// eventQueue holds the TableEvents published, under the lock of their table, until sendEvents sends them
+type eventQueue struct {
+	mu      sync.Mutex
+	pending []TableEvent
+	sending bool // a sendEvents goroutine is running
+}
+
+// sendEvent queues ev for the subscribers.  The queued events are sent in order by a goroutine, outside the lock of
+// the table writing them, so that a slow subscriber delays the events but never the writes.
+func (self *SwarmDB) sendEvent(ev TableEvent) {
+	q := &self.tableEvents
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, ev)
+	if !q.sending {
+		q.sending = true
+		go self.sendEvents()
+	}
+}
+
+// sendEvents sends the queued events on the table feed until none is left
+func (self *SwarmDB) sendEvents() {
+	q := &self.tableEvents
+	for {
+		q.mu.Lock()
+		events := q.pending
+		q.pending = nil
+		if len(events) == 0 {
+			q.sending = false
+			q.mu.Unlock()
+			return
+		}
+		q.mu.Unlock()
+		for _, ev := range events {
+			self.tableFeed.Send(ev)
+		}
+	}
+}
+
+func (t *Table) newEvent(eventType string) TableEvent {
+	return TableEvent{Type: eventType, Owner: t.Owner, Database: t.Database, Table: t.tableName}
+}
+
+// publishEvent holds events back while the table is buffered so subscribers only see committed changes
+func (t *Table) publishEvent(ev TableEvent) {
+	if t.buffered {
+		t.pendingEvents = append(t.pendingEvents, ev)
+		return
+	}
+	t.recordChange(ev)
+	t.swarmdb.sendEvent(ev)
+}
+
+func (t *Table) publishPendingEvents() {
+	for _, ev := range t.pendingEvents {
+		t.recordChange(ev)
+		t.swarmdb.sendEvent(ev)
+	}
+	t.pendingEvents = nil
+	ev := t.newEvent(TE_FLUSH)
+	ev.Roothash = fmt.Sprintf("%x", t.roothash)
+	t.swarmdb.sendEvent(ev)
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"math"
+	"time"
+)
+
+// One integer or float column of a table may hold the expiry of its rows, in unix milliseconds, so that session
+// stores and caches can live in SwarmDB: a row whose expiry is at or before now is not found by Get, scans, queries
+// and snapshots (which compare with their own time) as if it had been deleted, and the purger started by
+// StartExpiryPurger deletes it for good.  Rows without a value in the column never expire.  Until they are purged,
+// expired rows keep their index entries, so a B+tree still counts them.  The flag, 1, is kept at
+// COLUMN_EXPIRES_OFFSET of the column entry in the table descriptor, the byte after the longest column name, which
+// holds the timestamp attributes of timestamp.go otherwise.
+const (
+	COLUMN_EXPIRES_OFFSET = 25
+	EXPIRY_PURGE_INTERVAL = time.Minute // how often the purger runs when config.ExpiryPurgeInterval is 0
+)
+
+// ExpiryColumn returns the name of the column holding the expiry of the rows; ok is false when rows never expire
+func (t *Table) ExpiryColumn() (name string, ok bool) {
+	for name, c := range t.columns {
+		if c.expires {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// SetExpiryColumn makes the rows expire at the time in unix milliseconds of the column name, or never when name is
+// empty.  The column must be an unencrypted integer or float column other than the primary key and the timestamp
+// columns.
+func (t *Table) SetExpiryColumn(u *SWARMDBUser, name string) (err error) {
+	if err = t.checkGrant(u); err != nil {
+		return err
+	}
+	if len(name) > 0 {
+		c, ok := t.columns[name]
+		if !ok {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[expiry:SetExpiryColumn] unknown column %s", name), ErrorCode: 404, ErrorMessage: fmt.Sprintf("Column Does Not Exist in table definition: [%s]", name)}
+		}
+		if c.primary > 0 || c.encrypted || c.timestamp > 0 || (c.columnType != sdbc.CT_INTEGER && c.columnType != sdbc.CT_FLOAT) {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[expiry:SetExpiryColumn] column %s", name), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: the expiry column [%s] must be an unencrypted integer or float column other than the primary key and the timestamp columns", name)}
+		}
+	}
+	if t.IsSharded() {
+		if err = t.eachShard(u, func(i int, shard *Table) error { return shard.SetExpiryColumn(u, name) }); err != nil {
+			return err
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for n, c := range t.columns {
+		c.expires = n == name
+	}
+	return t.updateTableInfo(u)
+}
+
+// expiredAt reports whether the row value, as stored, expires at or before atMs
+func (t *Table) expiredAt(value []byte, atMs int64) bool {
+	name, ok := t.ExpiryColumn()
+	if !ok || len(value) == 0 {
+		return false
+	}
+	expires, ok := t.numberCell(value, name)
+	return ok && expires <= float64(atMs)
+}
+
+// numberCell returns the value of the number cell name of the row value, as stored, without decoding binary rows
+func (t *Table) numberCell(value []byte, name string) (n float64, ok bool) {
+	if !isBinaryRow(value) {
+		var row map[string]interface{}
+		if err := decodeJSONNumbers(value, &row); err != nil {
+			return 0, false
+		}
+		number, isNumber := row[name].(json.Number)
+		if !isNumber {
+			return 0, false
+		}
+		n, err := number.Float64()
+		return n, err == nil
+	}
+	forEachCell(t.columnOrder(), value, func(cell string, tag byte, payload []byte) bool {
+		if cell != name {
+			return true
+		}
+		switch tag {
+		case CELL_INT:
+			i, _ := binary.Varint(payload)
+			n, ok = float64(i), true
+		case CELL_FLOAT:
+			n, ok = math.Float64frombits(binary.BigEndian.Uint64(payload)), true
+		}
+		return false
+	})
+	return n, ok
+}
+
+// PurgeExpired deletes the rows of t that expired and returns how many it deleted
+func (t *Table) PurgeExpired(u *SWARMDBUser) (purged int, err error) {
+	if _, ok := t.ExpiryColumn(); !ok {
+		return 0, nil
+	}
+	now := nowMs()
+	var keys []interface{}
+	err = t.ScanEach(u, 1, func(r *RowView) (bool, error) {
+		shard := r.t // of a sharded table, the shard holding the row
+		value, ok, err := shard.readStoredValue(u, r.Key())
+		if err != nil || !ok || !shard.expiredAt(value, now) {
+			return true, err
+		}
+		row, err := shard.byteArrayToRow(shard.rowJSON(value))
+		if err != nil {
+			return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[expiry:PurgeExpired] byteArrayToRow %s", err.Error()))
+		}
+		keys = append(keys, row[t.primaryColumnName])
+		return true, nil
+	})
+	if err != nil {
+		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[expiry:PurgeExpired] ScanEach %s", err.Error()))
+	}
+	for _, key := range keys {
+		ok, err := t.Delete(u, key)
+		if err != nil {
+			return purged, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[expiry:PurgeExpired] Delete %s", err.Error()))
+		}
+		if ok {
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// PurgeExpired purges the expired rows of every open table and returns how many were deleted.  Replicas purge
+// nothing, as they follow the root hashes of their primary.  The first error is returned after all tables were
+// attempted.
+func (self *SwarmDB) PurgeExpired(u *SWARMDBUser) (purged int, err error) {
+	if self.IsReplica() {
+		return 0, nil
+	}
+	for _, tbl := range self.openTables() {
+		n, perr := tbl.PurgeExpired(u)
+		if perr != nil && err == nil {
+			err = sdbc.GenerateSWARMDBError(perr, fmt.Sprintf("[expiry:PurgeExpired] %s %s", tbl.tableName, perr.Error()))
+		}
+		purged += n
+	}
+	return purged, err
+}
+
+// StartExpiryPurger runs PurgeExpired every interval (EXPIRY_PURGE_INTERVAL if 0) until the returned stop func is
+// called; a negative interval purges nothing
+func (self *SwarmDB) StartExpiryPurger(u *SWARMDBUser, interval time.Duration) (stop func()) {
+	if interval < 0 {
+		return func() {}
+	}
+	if interval == 0 {
+		interval = EXPIRY_PURGE_INTERVAL
+	}
+	quit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				n, err := self.PurgeExpired(u)
+				if err != nil {
+					log.Error(fmt.Sprintf("[expiry:StartExpiryPurger] %s", err.Error()))
+				}
+				if n > 0 {
+					log.Debug(fmt.Sprintf("[expiry:StartExpiryPurger] purged %d expired rows", n))
+				}
+			}
+		}
+	}()
+	return func() { close(quit) }
+}
+
+// setExpiryColumn runs an RT_EXPIRY_COLUMN request: d.Rows[0] {"column": name} sets the expiry column, "" clearing
+// it; without Rows it is only read
+func (self *SwarmDB) setExpiryColumn(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[expiry:setExpiryColumn] GetTable %s", err.Error()))
+	}
+	if len(d.Rows) == 1 {
+		name, ok := d.Rows[0]["column"].(string)
+		if !ok && d.Rows[0]["column"] != nil {
+			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[expiry:setExpiryColumn] column %v", d.Rows[0]["column"]), ErrorCode: 418, ErrorMessage: "Request Invalid: column must be a column name"}
+		}
+		if err = tbl.SetExpiryColumn(u, name); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[expiry:setExpiryColumn] SetExpiryColumn %s", err.Error()))
+		}
+		resp.AffectedRowCount = 1
+	}
+	if name, ok := tbl.ExpiryColumn(); ok {
+		row := sdbc.NewRow()
+		row["column"] = name
+		resp.Data = append(resp.Data, row)
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"io"
+	"strconv"
+)
+
+// Export formats
+const (
+	EXPORT_JSONL = "jsonl" // one JSON object per row and line
+	EXPORT_CSV   = "csv"   // a header line of the column names, primary key first, then one record per row
+)
+
+// rowWriter writes rows in one of the export formats
+type rowWriter struct {
+	format  string
+	columns []string
+	buf     *bufio.Writer
+	csv     *csv.Writer
+}
+
+func newRowWriter(w io.Writer, format string, columns []string) (rw *rowWriter, err error) {
+	rw = &rowWriter{format: format, columns: columns, buf: bufio.NewWriter(w)}
+	switch format {
+	case EXPORT_JSONL:
+	case EXPORT_CSV:
+		rw.csv = csv.NewWriter(rw.buf)
+		if err = rw.csv.Write(columns); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[export:newRowWriter] format %s", format), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: unknown export format [%s], use %s or %s", format, EXPORT_JSONL, EXPORT_CSV)}
+	}
+	return rw, nil
+}
+
+func (rw *rowWriter) write(row sdbc.Row) (err error) {
+	if rw.csv == nil {
+		line, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if _, err = rw.buf.Write(line); err != nil {
+			return err
+		}
+		return rw.buf.WriteByte('\n')
+	}
+	record := make([]string, len(rw.columns))
+	for i, name := range rw.columns {
+		record[i] = exportCell(row[name])
+	}
+	return rw.csv.Write(record)
+}
+
+func (rw *rowWriter) flush() (err error) {
+	if rw.csv != nil {
+		rw.csv.Flush()
+		if err = rw.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return rw.buf.Flush()
+}
+
+// exportCell formats a cell for CSV, writing integral numbers without a fraction and missing cells as ""
+func exportCell(cell interface{}) string {
+	switch v := cell.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", cell)
+}
+
+// ExportTable writes every row of the table to w in primary key order, as JSON Lines or CSV (EXPORT_*), and returns
+// the number of rows written.  The rows are read from a snapshot, so writes going on meanwhile do not tear the
+// export, and are streamed one at a time as ScanRange yields them: a slow w holds the scan back instead of the
+// table being loaded into memory, which keeps exports of large tables suitable for backups and ETL.
+func (self *SwarmDB) ExportTable(u *SWARMDBUser, owner string, database string, tableName string, format string, w io.Writer) (n int, err error) {
+	tbl, err := self.GetTable(u, owner, database, tableName)
+	if err != nil {
+		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[export:ExportTable] GetTable %s", err.Error()))
+	}
+	hidden, err := tbl.checkRead(u)
+	if err != nil {
+		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[export:ExportTable] checkRead %s", err.Error()))
+	}
+	snap, err := tbl.Snapshot(u)
+	if err != nil {
+		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[export:ExportTable] Snapshot %s", err.Error()))
+	}
+	var columns []string
+	for _, name := range tbl.columnOrder() {
+		if !hidden[name] {
+			columns = append(columns, name)
+		}
+	}
+	rw, err := newRowWriter(w, format, columns)
+	if err != nil {
+		return 0, err
+	}
+	var werr error
+	err = snap.ScanRange(u, nil, nil, 1, func(k []byte, row sdbc.Row) bool {
+		if werr = rw.write(row); werr != nil {
+			return false
+		}
+		n++
+		return true
+	})
+	if err == nil {
+		err = werr
+	}
+	if err == nil {
+		err = rw.flush()
+	}
+	if err != nil {
+		return n, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[export:ExportTable] %s row %d %s", tableName, n, err.Error()))
+	}
+	log.Debug(fmt.Sprintf("[export:ExportTable] %d rows of %s exported as %s", n, tableName, format), "trace", u.TraceID())
+	return n, nil
+}
@@ -0,0 +1,334 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"sort"
+)
+
+// Columns that are read apart from the rest of the row can be put in a column family of their own: Put then stores
+// the cells of each family 1..COLUMN_FAMILIES_MAX-1 in a K-chunk of its own, under familyKey, and leaves the primary
+// key and the other columns, family 0, in the K-chunk rows have always been stored in.  Get reads every family back
+// into one row, while GetColumns only fetches the families of the columns asked for.
+//
+// Every family that ever held a column stays in the family mask of the table, and a Put rewrites the chunk of each
+// of them, empty or not, so that moving a column to another family never leaves an older cell behind to be read.
+// Rows written before a column got its family keep the cell in family 0 until they are written again; GetColumns
+// falls back to the remaining families for columns not found where they belong now.
+//
+// The family of a column is kept at COLUMN_FAMILY_OFFSET of its column entry, the mask in the unused front of the
+// descriptor chunk, after the ACL magic:
+//
+//	456-460 magic, 460-462 family mask
+//
+// Descriptors written before kept the mask at 4032-4038, outside the hashed bytes; it is still read from there.
+const (
+	COLUMN_FAMILY_OFFSET = 29
+	COLUMN_FAMILIES_MAX  = 16
+
+	FAMILY_MAGIC_START = 456
+	FAMILY_MASK_START  = 460
+	FAMILY_MASK_END    = 462
+
+	LEGACY_FAMILY_MAGIC_START = 4032
+	LEGACY_FAMILY_MASK_START  = 4036
+)
+
+var FAMILY_MAGIC = []byte("cfm\x01")
+
+func readFamilyMask(descriptor []byte) (mask uint16) {
+	start := FAMILY_MASK_START
+	if !bytes.Equal(descriptor[FAMILY_MAGIC_START:FAMILY_MASK_START], FAMILY_MAGIC) {
+		if !bytes.Equal(descriptor[LEGACY_FAMILY_MAGIC_START:LEGACY_FAMILY_MASK_START], FAMILY_MAGIC) {
+			return 0
+		}
+		start = LEGACY_FAMILY_MASK_START
+	}
+	return uint16(descriptor[start])<<8 | uint16(descriptor[start+1])
+}
+
+func writeFamilyMask(descriptor []byte, mask uint16) {
+	if mask == 0 {
+		return
+	}
+	copy(descriptor[FAMILY_MAGIC_START:], FAMILY_MAGIC)
+	descriptor[FAMILY_MASK_START] = byte(mask >> 8)
+	descriptor[FAMILY_MASK_START+1] = byte(mask)
+}
+
+// maskFamilies lists the families 1.. of mask in order
+func maskFamilies(mask uint16) (families []uint8) {
+	for f := uint8(1); f < COLUMN_FAMILIES_MAX; f++ {
+		if mask&(1<<f) != 0 {
+			families = append(families, f)
+		}
+	}
+	return families
+}
+
+// familyKey is the key the cells of family f of the row k are stored under.  Primary keys are K_SIZE bytes, so it
+// never is the key of another row.
+func familyKey(k []byte, f uint8) []byte {
+	fk := make([]byte, K_SIZE+1)
+	copy(fk, k)
+	fk[K_SIZE] = f
+	return fk
+}
+
+// ColumnFamilies returns the family of every column outside family 0
+func (t *Table) ColumnFamilies() (families map[string]int) {
+	families = make(map[string]int)
+	for name, c := range t.columns {
+		if c.family > 0 {
+			families[name] = int(c.family)
+		}
+	}
+	return families
+}
+
+// SetColumnFamilies puts the named columns in the given families, 1 to COLUMN_FAMILIES_MAX-1, and all others in
+// family 0, for the rows written from now on.  The primary key stays in family 0.
+func (t *Table) SetColumnFamilies(u *SWARMDBUser, families map[string]int) (err error) {
+	if err = t.checkGrant(u); err != nil {
+		return err
+	}
+	for name, f := range families {
+		c, ok := t.columns[name]
+		if !ok {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[family:SetColumnFamilies] unknown column %s", name), ErrorCode: 404, ErrorMessage: fmt.Sprintf("Column Does Not Exist in table definition: [%s]", name)}
+		}
+		if f < 0 || f >= COLUMN_FAMILIES_MAX {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[family:SetColumnFamilies] column %s family %d", name, f), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: column families are numbered 0 to %d", COLUMN_FAMILIES_MAX-1)}
+		}
+		if c.primary > 0 && f != 0 {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[family:SetColumnFamilies] primary column %s", name), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: the primary key [%s] stays in family 0", name)}
+		}
+	}
+	if t.IsSharded() {
+		if err = t.eachShard(u, func(i int, shard *Table) error { return shard.SetColumnFamilies(u, families) }); err != nil {
+			return err
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name, c := range t.columns {
+		c.family = uint8(families[name])
+		if c.family > 0 {
+			t.familyMask |= 1 << c.family
+		}
+	}
+	return t.updateTableInfo(u)
+}
+
+// storeRow stores the cells of row k in the K-chunks of their families and returns the key of the family 0 chunk,
+// for the primary index, the whole row as stored and its version
+func (t *Table) storeRow(u *SWARMDBUser, k []byte, row map[string]interface{}) (hashVal []byte, value []byte, version int, err error) {
+	if t.familyMask == 0 {
+		if value, err = t.encodeValue(row); err != nil {
+			return nil, nil, 0, err
+		}
+		hashVal, version, err = t.storeRowChunk(u, k, value)
+		return hashVal, value, version, err
+	}
+	cells := make(map[uint8]map[string]interface{})
+	cells[0] = make(map[string]interface{})
+	for _, f := range maskFamilies(t.familyMask) {
+		cells[f] = make(map[string]interface{})
+	}
+	for name, v := range row {
+		f := uint8(0)
+		if c, ok := t.columns[name]; ok {
+			f = c.family
+		}
+		cells[f][name] = v
+	}
+	// every family is encoded and checked before any is stored, so a family too large does not leave the others written
+	values := make(map[uint8][]byte, len(cells))
+	for f, familyRow := range cells {
+		v, err := t.encodeValue(familyRow)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if err = t.swarmdb.checkRowSize(len(v)); err != nil {
+			return nil, nil, 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[family:storeRow] family %d %s", f, err.Error()))
+		}
+		values[f] = v
+	}
+	for f, v := range values {
+		if f == 0 {
+			hashVal, version, err = t.storeRowChunk(u, k, v)
+		} else {
+			_, _, err = t.storeRowChunk(u, familyKey(k, f), v)
+		}
+		if err != nil {
+			return nil, nil, 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[family:storeRow] family %d %s", f, err.Error()))
+		}
+	}
+	if value, err = t.encodeValue(row); err != nil {
+		return nil, nil, 0, err
+	}
+	return hashVal, value, version, nil
+}
+
+// readFamilies adds the cells of the families in mask of the row key to value, the value of its family 0 chunk
+func (t *Table) readFamilies(u *SWARMDBUser, key []byte, value []byte, mask uint16) (out []byte, ok bool, err error) {
+	for _, f := range maskFamilies(mask) {
+		cells, found, err := t.readRowChunk(u, familyKey(key, f))
+		if err != nil {
+			return nil, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[family:readFamilies] family %d %s", f, err.Error()))
+		}
+		if found {
+			value = t.mergeRowValues(value, cells)
+		}
+	}
+	return value, true, nil
+}
+
+// mergeRowValues returns the row value holding the cells of both a and b, the cells of b winning
+func (t *Table) mergeRowValues(a []byte, b []byte) []byte {
+	if len(b) == 0 || bytes.Equal(b, []byte("{}")) {
+		return a
+	}
+	if isBinaryRow(a) && isBinaryRow(b) {
+		out := make([]byte, 0, len(a)+len(b))
+		out = append(out, a[:len(a)-1]...)
+		return append(out, b[1:]...)
+	}
+	var row, cells map[string]interface{}
+	if decodeJSONNumbers(t.rowJSON(a), &row) != nil || decodeJSONNumbers(t.rowJSON(b), &cells) != nil {
+		return a
+	}
+	for name, v := range cells {
+		row[name] = v
+	}
+	out, err := json.Marshal(row)
+	if err != nil {
+		return a
+	}
+	return out
+}
+
+// GetColumns reads the row key as Get does, but fetches only the column families holding columns; the row may hold
+// further columns of the same families.  Snapshots at a point in time read every family.
+func (t *Table) GetColumns(u *SWARMDBUser, key []byte, columns []string) (out []byte, ok bool, err error) {
+	if t.IsSharded() {
+		shard, err := t.shardFor(u, key)
+		if err != nil {
+			return nil, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[family:GetColumns] shardFor %s", err.Error()))
+		}
+		return shard.GetColumns(u, key, columns)
+	}
+	if t.familyMask == 0 || t.asOfMs > 0 {
+		return t.Get(u, key)
+	}
+	if !t.snapshot {
+		if value, held := t.memtable.get(key); held {
+			if value == nil || t.expiredAt(value, nowMs()) {
+				return nil, false, nil
+			}
+			return t.decryptColumns(u, t.rowJSON(value)), true, nil
+		}
+	}
+	primary, err := t.getPrimaryColumn()
+	if err != nil {
+		return nil, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[family:GetColumns] getPrimaryColumn %s", err.Error()))
+	}
+	if _, ok, err = primary.dbaccess.Get(u, key); err != nil {
+		return nil, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[family:GetColumns] dbaccess.Get %s", err.Error()))
+	}
+	if !ok {
+		return nil, false, nil
+	}
+	value, ok, err := t.readRowChunk(u, key)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	wanted := uint16(0)
+	for _, name := range columns {
+		if c, found := t.columns[name]; found && c.family > 0 {
+			wanted |= 1 << c.family
+		}
+	}
+	if name, ok := t.ExpiryColumn(); ok && t.columns[name].family > 0 {
+		wanted |= 1 << t.columns[name].family
+	}
+	if value, _, err = t.readFamilies(u, key, value, wanted&t.familyMask); err != nil {
+		return nil, false, err
+	}
+	if rest := t.familyMask &^ wanted; rest != 0 {
+		var row map[string]interface{}
+		if err = decodeJSONNumbers(t.rowJSON(value), &row); err == nil {
+			for _, name := range columns {
+				if _, found := row[name]; !found {
+					// written before the column moved to its family
+					if value, _, err = t.readFamilies(u, key, value, rest); err != nil {
+						return nil, false, err
+					}
+					break
+				}
+			}
+		}
+	}
+	if t.expiredAt(value, nowMs()) {
+		return nil, false, nil
+	}
+	return t.decryptColumns(u, t.rowJSON(value)), true, nil
+}
+
+// setColumnFamilies runs an RT_COLUMN_FAMILIES request: d.Rows[0] {"families": {"column": family, ...}} sets the
+// families of the columns; without Rows the families are only listed
+func (self *SwarmDB) setColumnFamilies(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[family:setColumnFamilies] GetTable %s", err.Error()))
+	}
+	if len(d.Rows) == 1 {
+		list, ok := d.Rows[0]["families"].(map[string]interface{})
+		if !ok && d.Rows[0]["families"] != nil {
+			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[family:setColumnFamilies] families %v", d.Rows[0]["families"]), ErrorCode: 418, ErrorMessage: "Request Invalid: families must map column names to family numbers"}
+		}
+		families := make(map[string]int)
+		for name, v := range list {
+			f, ok := toFloat(v)
+			if !ok {
+				return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[family:setColumnFamilies] column %s family %v", name, v), ErrorCode: 418, ErrorMessage: "Request Invalid: families must map column names to family numbers"}
+			}
+			families[name] = int(f)
+		}
+		if err = tbl.SetColumnFamilies(u, families); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[family:setColumnFamilies] SetColumnFamilies %s", err.Error()))
+		}
+		resp.AffectedRowCount = len(families)
+	}
+	families := tbl.ColumnFamilies()
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		row := sdbc.NewRow()
+		row["column"] = name
+		row["family"] = families[name]
+		resp.Data = append(resp.Data, row)
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"github.com/ethereum/go-ethereum/swarmdb/swarmdblib"
+	"sort"
+	"sync"
+)
+
+// A Fanout runs one query on the local SwarmDB and on every peer node of config.Peers over the wire protocol, in
+// parallel, and merges the partial results: rows of tables sharded across nodes are joined, rows of tables
+// replicated on several nodes are deduplicated by key, and the merged rows are sorted, cut and aggregated locally.
+
+// PeerConfig is a SwarmDB node a Fanout sends sub-queries to
+type PeerConfig struct {
+	IP   string `json:"ip"`
+	Port int    `json:"port"`
+}
+
+// Aggregate reduces a column of the merged rows: Func is one of "count", "sum", "min", "max" and "avg"; count of
+// Column "*" counts rows.  The result is named As, or Func(Column)
+type Aggregate struct {
+	Func   string
+	Column string
+	As     string
+}
+
+// FanoutQuery is a SQL query and how to merge its partial results
+type FanoutQuery struct {
+	Owner      string
+	Database   string
+	SQL        string
+	KeyColumn  string // rows with the same value in this column are one row, as replicas all return it
+	OrderBy    string // column the merged rows are sorted by, empty keeps them in arrival order
+	Descending bool
+	Limit      int         // rows kept after sorting, 0 keeps all
+	Aggregates []Aggregate // when set, the merged rows are reduced to a single row
+}
+
+var aggregateFuncs = map[string]bool{"count": true, "sum": true, "min": true, "max": true, "avg": true}
+
+type Fanout struct {
+	local *SwarmDB // nil when only peers are queried
+	peers map[string]*swarmdblib.Pool
+}
+
+// NewFanout returns a Fanout over local, which may be nil, and the peers of config; peer connections authenticate
+// with config.PrivateKey when set
+func NewFanout(local *SwarmDB, config *SWARMDBConfig) *Fanout {
+	f := &Fanout{local: local, peers: make(map[string]*swarmdblib.Pool)}
+	for _, peer := range config.Peers {
+		name := fmt.Sprintf("%s:%d", peer.IP, peer.Port)
+		f.peers[name] = swarmdblib.NewPool(swarmdblib.PoolConfig{IP: peer.IP, Port: peer.Port, PrivateKey: config.PrivateKey})
+	}
+	return f
+}
+
+// Close closes the peer connections
+func (f *Fanout) Close() {
+	for _, p := range f.peers {
+		p.Close()
+	}
+}
+
+// Query runs q everywhere and returns the merged result; it fails if any node fails
+func (f *Fanout) Query(ctx context.Context, u *SWARMDBUser, q *FanoutQuery) (resp sdbc.SWARMDBResponse, err error) {
+	for _, a := range q.Aggregates {
+		if !aggregateFuncs[a.Func] {
+			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[fanout:Query] aggregate %s", a.Func), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: unknown aggregate [%s]", a.Func)}
+		}
+	}
+	req := sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: q.Owner, Database: q.Database, RawQuery: q.SQL}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var parts [][]sdbc.Row
+	var errs []error
+	collect := func(node string, part sdbc.SWARMDBResponse, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[fanout:Query] %s %s", node, err.Error())))
+			return
+		}
+		parts = append(parts, part.Data)
+	}
+	if f.local != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			part, err := f.local.HandleRequest(u, &req)
+			collect("local", part, err)
+		}()
+	}
+	for name, p := range f.peers {
+		wg.Add(1)
+		go func(name string, p *swarmdblib.Pool) {
+			defer wg.Done()
+			part, err := p.ProcessRequestCtx(ctx, req)
+			collect(name, part, err)
+		}(name, p)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return resp, errs[0]
+	}
+	resp.Data = mergeRows(parts, q)
+	resp.MatchedRowCount = len(resp.Data)
+	log.Debug(fmt.Sprintf("[fanout:Query] %d rows from %d nodes", len(resp.Data), len(parts)))
+	return resp, nil
+}
+
+// mergeRows joins, deduplicates, sorts, cuts and aggregates the partial results
+func mergeRows(parts [][]sdbc.Row, q *FanoutQuery) (rows []sdbc.Row) {
+	seen := make(map[string]bool)
+	for _, part := range parts {
+		for _, row := range part {
+			if len(q.KeyColumn) > 0 {
+				key := fmt.Sprint(row[q.KeyColumn])
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+			}
+			rows = append(rows, row)
+		}
+	}
+	if len(q.OrderBy) > 0 {
+		sort.SliceStable(rows, func(i, j int) bool {
+			if q.Descending {
+				return lessValue(rows[j][q.OrderBy], rows[i][q.OrderBy])
+			}
+			return lessValue(rows[i][q.OrderBy], rows[j][q.OrderBy])
+		})
+	}
+	if q.Limit > 0 && len(rows) > q.Limit {
+		rows = rows[:q.Limit]
+	}
+	if len(q.Aggregates) > 0 {
+		rows = []sdbc.Row{aggregateRows(rows, q.Aggregates)}
+	}
+	return rows
+}
+
+// lessValue orders numbers numerically (ints read locally, float64s decoded from peers) and anything else by text;
+// missing values come first
+func lessValue(a interface{}, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b != nil
+	}
+	fa, okA := toFloat(a)
+	fb, okB := toFloat(b)
+	if okA && okB {
+		return fa < fb
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+func aggregateRows(rows []sdbc.Row, aggregates []Aggregate) (out sdbc.Row) {
+	out = sdbc.NewRow()
+	for _, a := range aggregates {
+		name := a.As
+		if len(name) == 0 {
+			name = fmt.Sprintf("%s(%s)", a.Func, a.Column)
+		}
+		count, sum := 0, 0.0
+		var min, max interface{}
+		for _, row := range rows {
+			v, ok := row[a.Column]
+			if a.Column == "*" {
+				count++
+				continue
+			}
+			if !ok || v == nil {
+				continue
+			}
+			count++
+			if f, ok := toFloat(v); ok {
+				sum += f
+			}
+			if min == nil || lessValue(v, min) {
+				min = v
+			}
+			if max == nil || lessValue(max, v) {
+				max = v
+			}
+		}
+		switch a.Func {
+		case "count":
+			out[name] = count
+		case "sum":
+			out[name] = sum
+		case "min":
+			out[name] = min
+		case "max":
+			out[name] = max
+		case "avg":
+			if count > 0 {
+				out[name] = sum / float64(count)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"time"
+)
+
+// A table buffered with StartBuffer (RT_START_BUFFER) is flushed only by FlushBuffer, unless its descriptor holds a
+// FlushPolicy: then the flusher started by StartFlusher flushes it once enough writes, enough bytes of row data or
+// enough time have accumulated in the buffer, and keeps it buffered.  Buffers belonging to a Transaction, an atomic
+// batch or a Coordinator are left to their owner.
+//
+// The policy is stored in the unused front of the descriptor chunk:
+//
+//	512-516 magic, 516-524 max mutations, 524-532 max bytes, 532-540 max age in seconds
+const (
+	FLUSH_POLICY_MAGIC_START = 512
+	FLUSH_POLICY_MUTATIONS   = 516
+	FLUSH_POLICY_BYTES       = 524
+	FLUSH_POLICY_SECONDS     = 532
+	FLUSH_POLICY_END         = 540
+
+	FLUSH_POLICY_INTERVAL = time.Second // how often the flusher checks the open tables
+)
+
+var FLUSH_POLICY_MAGIC = []byte("flp\x01")
+
+// FlushPolicy flushes a buffered table when any of its limits is reached; zero limits are disabled
+type FlushPolicy struct {
+	MaxMutations int           // buffered Puts and Deletes
+	MaxBytes     int           // bytes of buffered row data
+	MaxAge       time.Duration // since the first buffered write, in whole seconds
+}
+
+func (p FlushPolicy) enabled() bool {
+	return p.MaxMutations > 0 || p.MaxBytes > 0 || p.MaxAge > 0
+}
+
+func readFlushPolicy(descriptor []byte) (p FlushPolicy) {
+	if !bytes.Equal(descriptor[FLUSH_POLICY_MAGIC_START:FLUSH_POLICY_MUTATIONS], FLUSH_POLICY_MAGIC) {
+		return p
+	}
+	p.MaxMutations = BytesToInt(descriptor[FLUSH_POLICY_MUTATIONS:FLUSH_POLICY_BYTES])
+	p.MaxBytes = BytesToInt(descriptor[FLUSH_POLICY_BYTES:FLUSH_POLICY_SECONDS])
+	p.MaxAge = time.Duration(BytesToInt(descriptor[FLUSH_POLICY_SECONDS:FLUSH_POLICY_END])) * time.Second
+	return p
+}
+
+func writeFlushPolicy(descriptor []byte, p FlushPolicy) {
+	if !p.enabled() {
+		return
+	}
+	copy(descriptor[FLUSH_POLICY_MAGIC_START:], FLUSH_POLICY_MAGIC)
+	copy(descriptor[FLUSH_POLICY_MUTATIONS:FLUSH_POLICY_BYTES], IntToByte(p.MaxMutations))
+	copy(descriptor[FLUSH_POLICY_BYTES:FLUSH_POLICY_SECONDS], IntToByte(p.MaxBytes))
+	copy(descriptor[FLUSH_POLICY_SECONDS:FLUSH_POLICY_END], IntToByte(int(p.MaxAge/time.Second)))
+}
+
+// FlushPolicy returns the flush policy of the table
+func (t *Table) FlushPolicy() FlushPolicy {
+	return t.flushPolicy
+}
+
+// SetFlushPolicy stores p in the table descriptor; the zero FlushPolicy turns automatic flushing off
+func (t *Table) SetFlushPolicy(u *SWARMDBUser, p FlushPolicy) (err error) {
+	if err = t.checkGrant(u); err != nil {
+		return err
+	}
+	if p.MaxMutations < 0 || p.MaxBytes < 0 || p.MaxAge < 0 {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[flushpolicy:SetFlushPolicy] negative limit %+v", p), ErrorCode: 418, ErrorMessage: "Request Invalid: flush policy limits cannot be negative"}
+	}
+	t.flushPolicy = p
+	return t.updateTableInfo(u)
+}
+
+// noteWrite counts a buffered write of size bytes of row data against the flush policy
+func (t *Table) noteWrite(size int) {
+	t.noteHistogramWrite()
+	if !t.buffered {
+		return
+	}
+	if t.dirtyMutations == 0 {
+		t.dirtySince = time.Now()
+	}
+	t.dirtyMutations++
+	t.dirtyBytes += size
+}
+
+// resetDirty forgets the buffered writes after a flush
+func (t *Table) resetDirty() {
+	t.dirtyMutations, t.dirtyBytes = 0, 0
+	t.ops = nil
+	t.memtable.drain()
+}
+
+// flushDue reports whether the flush policy asks for the buffer of t to be flushed at now
+func (t *Table) flushDue(now time.Time) bool {
+	p := t.flushPolicy
+	if !t.buffered || t.holdFlush || t.dirtyMutations == 0 || !p.enabled() {
+		return false
+	}
+	return (p.MaxMutations > 0 && t.dirtyMutations >= p.MaxMutations) ||
+		(p.MaxBytes > 0 && t.dirtyBytes >= p.MaxBytes) ||
+		(p.MaxAge > 0 && now.Sub(t.dirtySince) >= p.MaxAge)
+}
+
+// FlushDue flushes every open table whose flush policy is due and returns how many were flushed.  The tables stay
+// buffered.  The first error is returned after all tables were attempted.
+func (self *SwarmDB) FlushDue(u *SWARMDBUser) (flushed int, err error) {
+	now := time.Now()
+	for _, tbl := range self.openTables() {
+		ok, ferr := tbl.autoFlush(u, now)
+		if ferr != nil && err == nil {
+			err = sdbc.GenerateSWARMDBError(ferr, fmt.Sprintf("[flushpolicy:FlushDue] %s %s", tbl.tableName, ferr.Error()))
+		}
+		if ok {
+			flushed++
+		}
+	}
+	return flushed, err
+}
+
+// autoFlush flushes the buffer of t if its policy is due and starts a new one, under the table lock so that no Put
+// lands between the flush and the new buffer
+func (t *Table) autoFlush(u *SWARMDBUser, now time.Time) (flushed bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.flushDue(now) {
+		return false, nil
+	}
+	if err = t.FlushBuffer(u); err != nil {
+		return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[flushpolicy:autoFlush] FlushBuffer %s", err.Error()))
+	}
+	for _, ip := range t.columns {
+		if _, err = ip.dbaccess.StartBuffer(u); err != nil {
+			return true, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[flushpolicy:autoFlush] dbaccess.StartBuffer %s", err.Error()))
+		}
+	}
+	log.Debug(fmt.Sprintf("[flushpolicy:autoFlush] flushed [%s]", t.tableName), "trace", u.TraceID())
+	return true, nil
+}
+
+// StartFlusher runs FlushDue every interval (FLUSH_POLICY_INTERVAL if 0) until the returned stop func is called
+func (self *SwarmDB) StartFlusher(u *SWARMDBUser, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = FLUSH_POLICY_INTERVAL
+	}
+	quit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				if _, err := self.FlushDue(u); err != nil {
+					log.Debug(fmt.Sprintf("[flushpolicy:StartFlusher] %s", err.Error()))
+				}
+			}
+		}
+	}()
+	return func() { close(quit) }
+}
+
+// setFlushPolicy runs an RT_FLUSH_POLICY request: d.Rows[0] holds "mutations", "bytes" and "seconds" limits
+func (self *SwarmDB) setFlushPolicy(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[flushpolicy:setFlushPolicy] GetTable %s", err.Error()))
+	}
+	if len(d.Rows) == 1 {
+		limits := make(map[string]int)
+		for _, name := range []string{"mutations", "bytes", "seconds"} {
+			v, ok := toFloat(d.Rows[0][name])
+			if !ok {
+				return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[flushpolicy:setFlushPolicy] %s is %v", name, d.Rows[0][name]), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: flush policy %s must be a number", name)}
+			}
+			limits[name] = int(v)
+		}
+		p := FlushPolicy{MaxMutations: limits["mutations"], MaxBytes: limits["bytes"], MaxAge: time.Duration(limits["seconds"]) * time.Second}
+		if err = tbl.SetFlushPolicy(u, p); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[flushpolicy:setFlushPolicy] SetFlushPolicy %s", err.Error()))
+		}
+		resp.AffectedRowCount = 1
+	} else if err = tbl.checkAccess(u, ACL_READ); err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[flushpolicy:setFlushPolicy] checkAccess %s", err.Error()))
+	}
+	p := tbl.FlushPolicy()
+	row := sdbc.NewRow()
+	row["mutations"] = p.MaxMutations
+	row["bytes"] = p.MaxBytes
+	row["seconds"] = int(p.MaxAge / time.Second)
+	resp.Data = []sdbc.Row{row}
+	resp.MatchedRowCount = 1
+	return resp, nil
+}
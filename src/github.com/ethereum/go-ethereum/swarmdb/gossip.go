@@ -0,0 +1,329 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"github.com/ethereum/go-ethereum/swarmdb/swarmdblib"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Gossip lets SwarmDB nodes discover each other and learn which tables each serves.  Every round a node pushes its
+// view, a NodeInfo per node it knows including itself, to GOSSIP_FANOUT random peers (or to its seeds while it
+// knows none) and merges the view they answer with; only a node itself refreshes its own NodeInfo, so a merge keeps
+// the more recent of two.  Nodes not heard of for GOSSIP_EXPIRY drop out.  With config.Gossip.Route set, requests
+// for tables not published here are forwarded to a node serving them; they run there as this node's key.
+const (
+	ADMIN_GOSSIP = "Gossip"
+
+	GOSSIP_INTERVAL = 5 * time.Second
+	GOSSIP_FANOUT   = 3
+	GOSSIP_EXPIRY   = time.Minute
+)
+
+// GossipConfig enables the gossip layer when it has seeds or an advertised address
+type GossipConfig struct {
+	Seeds           []PeerConfig `json:"seeds,omitempty"`           // nodes contacted until others are known
+	Advertise       string       `json:"advertise,omitempty"`       // ip:port of the TCP server of this node, defaults to ListenAddrTCP:PortTCP
+	IntervalSeconds int          `json:"intervalSeconds,omitempty"` // seconds between rounds, 0 uses GOSSIP_INTERVAL
+	Route           int          `json:"route,omitempty"`           // 1 - forward requests for tables served elsewhere
+}
+
+// TableRef names a table
+type TableRef struct {
+	Owner    string `json:"owner"`
+	Database string `json:"database"`
+	Table    string `json:"table"`
+}
+
+// NodeInfo is what a node tells about itself
+type NodeInfo struct {
+	Address string     `json:"address"`
+	Tables  []TableRef `json:"tables,omitempty"` // tables open on the node
+	SeenMs  int64      `json:"seen"`             // unix milliseconds of the node's clock when it described itself
+}
+
+type Gossip struct {
+	swarmdb    *SwarmDB
+	advertise  string
+	seeds      []string
+	route      bool
+	privateKey string
+
+	mu    sync.Mutex
+	nodes map[string]NodeInfo         // by address, this node excluded
+	pools map[string]*swarmdblib.Pool // by address
+}
+
+// NewGossip returns the gossip layer of swarmdb for config.Gossip and has swarmdb answer ADMIN_GOSSIP with it
+func NewGossip(swarmdb *SwarmDB, config *SWARMDBConfig) *Gossip {
+	g := &Gossip{swarmdb: swarmdb, advertise: config.Gossip.Advertise, route: config.Gossip.Route > 0, privateKey: config.PrivateKey, nodes: make(map[string]NodeInfo), pools: make(map[string]*swarmdblib.Pool)}
+	if len(g.advertise) == 0 {
+		g.advertise = fmt.Sprintf("%s:%d", config.ListenAddrTCP, config.PortTCP)
+	}
+	for _, seed := range config.Gossip.Seeds {
+		g.seeds = append(g.seeds, fmt.Sprintf("%s:%d", seed.IP, seed.Port))
+	}
+	swarmdb.gossip = g
+	return g
+}
+
+// local describes this node
+func (g *Gossip) local() NodeInfo {
+	info := NodeInfo{Address: g.advertise, SeenMs: nowMs()}
+	for _, t := range g.swarmdb.openTables() {
+		info.Tables = append(info.Tables, TableRef{Owner: t.Owner, Database: t.Database, Table: t.tableName})
+	}
+	return info
+}
+
+// view returns the NodeInfo of every node known, this one first
+func (g *Gossip) view() (nodes []NodeInfo) {
+	nodes = append(nodes, g.local())
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	expired := nowMs() - int64(GOSSIP_EXPIRY/time.Millisecond)
+	for address, info := range g.nodes {
+		if info.SeenMs < expired {
+			delete(g.nodes, address)
+			continue
+		}
+		nodes = append(nodes, info)
+	}
+	return nodes
+}
+
+// merge keeps the more recent NodeInfo of every node in nodes
+func (g *Gossip) merge(nodes []NodeInfo) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, info := range nodes {
+		if len(info.Address) == 0 || info.Address == g.advertise {
+			continue
+		}
+		if known, ok := g.nodes[info.Address]; !ok || known.SeenMs < info.SeenMs {
+			g.nodes[info.Address] = info
+		}
+	}
+}
+
+// Nodes returns the NodeInfo of the other nodes known, by address
+func (g *Gossip) Nodes() (nodes []NodeInfo) {
+	nodes = g.view()[1:]
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Address < nodes[j].Address })
+	return nodes
+}
+
+// Locate returns the addresses of the nodes known to serve a table
+func (g *Gossip) Locate(owner string, database string, table string) (addresses []string) {
+	for _, info := range g.Nodes() {
+		for _, ref := range info.Tables {
+			if ref.Owner == owner && ref.Database == database && ref.Table == table {
+				addresses = append(addresses, info.Address)
+				break
+			}
+		}
+	}
+	return addresses
+}
+
+// Round exchanges views with up to GOSSIP_FANOUT random peers
+func (g *Gossip) Round(ctx context.Context) (err error) {
+	g.mu.Lock()
+	targets := make([]string, 0, len(g.nodes))
+	for address := range g.nodes {
+		targets = append(targets, address)
+	}
+	g.mu.Unlock()
+	if len(targets) == 0 {
+		targets = append(targets, g.seeds...)
+	}
+	rand.Shuffle(len(targets), func(i, j int) { targets[i], targets[j] = targets[j], targets[i] })
+	if len(targets) > GOSSIP_FANOUT {
+		targets = targets[:GOSSIP_FANOUT]
+	}
+	req := sdbc.RequestOption{}
+	for _, info := range g.view() {
+		row, err := nodeInfoRow(info)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[gossip:Round] nodeInfoRow %s", err.Error()))
+		}
+		req.Rows = append(req.Rows, row)
+	}
+	exchanged := 0
+	for _, address := range targets {
+		p := g.pool(address)
+		dbc, err := p.Get(ctx)
+		if err != nil {
+			log.Debug(fmt.Sprintf("[gossip:Round] %s %s", address, err.Error()))
+			continue
+		}
+		resp, err := dbc.Admin(ADMIN_GOSSIP, req)
+		p.Put(dbc)
+		if err != nil {
+			log.Debug(fmt.Sprintf("[gossip:Round] %s %s", address, err.Error()))
+			continue
+		}
+		nodes, err := rowsNodeInfo(resp.Data)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[gossip:Round] %s %s", address, err.Error()))
+		}
+		g.merge(nodes)
+		exchanged++
+	}
+	if exchanged == 0 && len(targets) > 0 {
+		return &sdbc.SWARMDBError{Message: "[gossip:Round] no peer answered", ErrorCode: 488, ErrorMessage: "Unable to connect to SWARMDB server: no gossip peer answered"}
+	}
+	return nil
+}
+
+// Start runs a round every interval until stop is called, which also has swarmdb stop answering ADMIN_GOSSIP
+func (g *Gossip) Start(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = GOSSIP_INTERVAL
+	}
+	quit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := g.Round(context.Background()); err != nil {
+				log.Debug(fmt.Sprintf("[gossip:Start] %s", err.Error()))
+			}
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		if g.swarmdb.gossip == g {
+			g.swarmdb.gossip = nil
+		}
+		g.mu.Lock()
+		for _, p := range g.pools {
+			p.Close()
+		}
+		g.mu.Unlock()
+	}
+}
+
+func (g *Gossip) pool(address string) *swarmdblib.Pool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p, ok := g.pools[address]
+	if !ok {
+		host, portStr, _ := net.SplitHostPort(address)
+		port, _ := strconv.Atoi(portStr)
+		p = swarmdblib.NewPool(swarmdblib.PoolConfig{IP: host, Port: port, PrivateKey: g.privateKey})
+		g.pools[address] = p
+	}
+	return p
+}
+
+// remote returns a node serving the table of d when it is not published here
+func (g *Gossip) remote(u *SWARMDBUser, d *sdbc.RequestOption) (address string, ok bool) {
+	if !g.route || len(d.Table) == 0 || d.RequestType == sdbc.RT_CREATE_TABLE {
+		return "", false
+	}
+	tblKey := g.swarmdb.GetTableKey(d.Owner, d.Database, d.Table)
+	g.swarmdb.tablesMu.RLock()
+	_, open := g.swarmdb.tables[tblKey]
+	g.swarmdb.tablesMu.RUnlock()
+	if open {
+		return "", false
+	}
+	roothash, err := g.swarmdb.GetRootHash(u, []byte(tblKey))
+	if err != nil || len(bytes.Trim(roothash, "\x00")) > 0 {
+		return "", false
+	}
+	addresses := g.Locate(d.Owner, d.Database, d.Table)
+	if len(addresses) == 0 {
+		return "", false
+	}
+	return addresses[rand.Intn(len(addresses))], true
+}
+
+// forward runs d on the node at address
+func (g *Gossip) forward(address string, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	resp, err = g.pool(address).ProcessRequestCtx(context.Background(), *d)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[gossip:forward] %s %s", address, err.Error()))
+	}
+	return resp, nil
+}
+
+// exchange answers ADMIN_GOSSIP: d.Rows is the view of the caller, merged here, and the answer is the view of this
+// node; without rows it just lists the view
+func (self *SwarmDB) exchange(d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	g := self.gossip
+	if g == nil {
+		return resp, &sdbc.SWARMDBError{Message: "[gossip:exchange] gossip disabled", ErrorCode: 418, ErrorMessage: "Request Invalid: this node does not gossip"}
+	}
+	nodes, err := rowsNodeInfo(d.Rows)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[gossip:exchange] %s", err.Error()))
+	}
+	g.merge(nodes)
+	for _, info := range g.view() {
+		row, err := nodeInfoRow(info)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[gossip:exchange] nodeInfoRow %s", err.Error()))
+		}
+		resp.Data = append(resp.Data, row)
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
+
+func nodeInfoRow(info NodeInfo) (row sdbc.Row, err error) {
+	out, err := json.Marshal(info)
+	if err != nil {
+		return row, err
+	}
+	row = sdbc.NewRow()
+	if err = json.Unmarshal(out, &row); err != nil {
+		return row, err
+	}
+	return row, nil
+}
+
+func rowsNodeInfo(rows []sdbc.Row) (nodes []NodeInfo, err error) {
+	for _, row := range rows {
+		out, err := json.Marshal(row)
+		if err != nil {
+			return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[gossip:rowsNodeInfo] Marshal %s", err.Error()), ErrorCode: 418, ErrorMessage: "Request Invalid: bad gossip row"}
+		}
+		var info NodeInfo
+		if err = json.Unmarshal(out, &info); err != nil {
+			return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[gossip:rowsNodeInfo] Unmarshal %s", err.Error()), ErrorCode: 418, ErrorMessage: "Request Invalid: bad gossip row"}
+		}
+		nodes = append(nodes, info)
+	}
+	return nodes, nil
+}
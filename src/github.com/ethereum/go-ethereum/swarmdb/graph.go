@@ -0,0 +1,425 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/crypto"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"sync"
+)
+
+// A graph is a named set of labelled, directed edges between nodes of an owner, each with JSON properties, such as
+// "alice" -follows-> "bob" {"since": 2017}.  Nodes are strings and need not be declared; an edge is identified by its
+// source, label and destination, and putting it again replaces its properties.  Edges are kept in value chunks (see
+// storeValueChunk) indexed twice, by source in the forward B+tree and by destination in the reverse one, whose keys
+// are the first 16 bytes of the Keccak256 of the node followed by the first 16 bytes of the Keccak256 of the label, a
+// zero byte and the other node, so the edges of a node in either direction are one prefix seek.  A graph is created
+// by its first edge and published through ENS under the table key of the owner, an empty database and GRAPH_PREFIX
+// and its name.  The published chunk is a descriptor:
+//
+//	[0:4] GRAPH_MAGIC, [32:64] root hash of the forward B+tree, [64:96] root hash of the reverse B+tree
+//
+// Traverse walks the graph breadth first from a node on the server, so a friends of friends query is one request.
+const (
+	GRAPH_MAGIC         = "gph\x01"
+	GRAPH_PREFIX        = "graph:"
+	GRAPH_NODE_SIZE_MAX = 256
+	GRAPH_DEPTH_MAX     = 16
+	GRAPH_TRAVERSE_MAX  = 10000 // edges one Traverse returns at most
+
+	GRAPH_OUT  = "out"  // follow edges from the node to their destination
+	GRAPH_IN   = "in"   // follow edges to the node back to their source
+	GRAPH_BOTH = "both" // follow both
+)
+
+// Edge is an edge of a graph, see graph.go
+type Edge struct {
+	Src   string                 `json:"src"`
+	Dst   string                 `json:"dst"`
+	Label string                 `json:"label"`
+	Props map[string]interface{} `json:"props,omitempty"`
+}
+
+// TraverseFilter restricts the edges Traverse follows
+type TraverseFilter struct {
+	Direction string                 // GRAPH_OUT (the default), GRAPH_IN or GRAPH_BOTH
+	Labels    []string               // edges with any of these labels, or any label when empty
+	Where     map[string]interface{} // conditions on the properties of the edges, as in the filters of FindDocs
+}
+
+// TraversalStep is an edge Traverse followed, Depth edges away from the start
+type TraversalStep struct {
+	Edge
+	Depth int `json:"depth"`
+}
+
+// Graph is an open graph, see graph.go
+type Graph struct {
+	mu       sync.Mutex
+	swarmdb  *SwarmDB
+	Owner    string
+	Name     string
+	forward  OrderedDatabase
+	reverse  OrderedDatabase
+	roothash []byte // of the published descriptor
+}
+
+type graphRegistry struct {
+	mu   sync.Mutex
+	open map[string]*Graph // by graphKey
+}
+
+func (self *SwarmDB) graphKey(owner string, name string) string {
+	return self.GetTableKey(owner, "", GRAPH_PREFIX+name)
+}
+
+// GetGraph returns the named graph of owner, opening it from its published descriptor when it is not open; with
+// create set, a graph that does not exist is created empty
+func (self *SwarmDB) GetGraph(u *SWARMDBUser, owner string, name string, create bool) (g *Graph, err error) {
+	if !validName(name, TABLE_NAME_LENGTH_MAX) {
+		return nil, invalidRequest("graph", name, fmt.Sprintf("must be up to %d letters, digits, '_', '-' or '.'", TABLE_NAME_LENGTH_MAX))
+	}
+	key := self.graphKey(owner, name)
+	self.graphs.mu.Lock()
+	defer self.graphs.mu.Unlock()
+	if g, ok := self.graphs.open[key]; ok {
+		return g, nil
+	}
+	roothash, err := self.GetRootHash(u, []byte(key))
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graph:GetGraph] GetRootHash %s", err.Error()))
+	}
+	g = &Graph{swarmdb: self, Owner: owner, Name: name}
+	forward, reverse := []byte(nil), []byte(nil)
+	if valid_hashid(roothash) {
+		buf, err := self.RetrieveDBChunk(u, roothash)
+		if err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graph:GetGraph] RetrieveDBChunk %s", err.Error()))
+		}
+		if len(buf) < 96 || string(buf[0:4]) != GRAPH_MAGIC {
+			return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[graph:GetGraph] %s: not a graph descriptor", key), ErrorCode: 439, ErrorMessage: "Unable to Parse Chunk"}
+		}
+		g.roothash = roothash
+		forward, reverse = buf[32:64], buf[64:96]
+	} else if !create {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[graph:GetGraph] %s not found", key), ErrorCode: 403, ErrorMessage: fmt.Sprintf("Graph Does Not Exist: Graph [%s] Owner [%s]", name, owner)}
+	} else if err = self.checkWritable(); err != nil {
+		return nil, err
+	}
+	if g.forward, err = newBlobIndex(u, self, forward); err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graph:GetGraph] forward %s", err.Error()))
+	}
+	if g.reverse, err = newBlobIndex(u, self, reverse); err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graph:GetGraph] reverse %s", err.Error()))
+	}
+	if g.roothash == nil {
+		if err = g.publish(u); err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graph:GetGraph] publish %s", err.Error()))
+		}
+	}
+	if self.graphs.open == nil {
+		self.graphs.open = make(map[string]*Graph)
+	}
+	self.graphs.open[key] = g
+	return g, nil
+}
+
+// publish flushes the indexes of g and publishes its descriptor.  When it fails the caller drops g with close, as
+// with collections.
+func (g *Graph) publish(u *SWARMDBUser) (err error) {
+	buf := getChunkBuffer()
+	defer releaseChunkBuffer(buf)
+	copy(buf[0:4], GRAPH_MAGIC)
+	for i, index := range []OrderedDatabase{g.forward, g.reverse} {
+		if _, err = index.FlushBuffer(u); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graph:publish] FlushBuffer %s", err.Error()))
+		}
+		copy(buf[32+i*32:64+i*32], index.GetRootHash())
+	}
+	swarmhash, err := g.swarmdb.StoreDBChunk(u, buf, 0)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graph:publish] StoreDBChunk %s", err.Error()))
+	}
+	if err = g.swarmdb.StoreRootHash(u, []byte(g.swarmdb.graphKey(g.Owner, g.Name)), g.roothash, swarmhash); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graph:publish] StoreRootHash %s", err.Error()))
+	}
+	g.roothash = swarmhash
+	return nil
+}
+
+// close drops g from the open graphs
+func (g *Graph) close() {
+	r := &g.swarmdb.graphs
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := g.swarmdb.graphKey(g.Owner, g.Name)
+	if r.open[key] == g {
+		delete(r.open, key)
+	}
+}
+
+// edgeKey returns the index key of the edge labelled label between node and other: forward from the source node
+// to the destination other, reverse the other way round
+func edgeKey(node string, label string, other string) []byte {
+	return append(crypto.Keccak256([]byte(node))[0:16], crypto.Keccak256([]byte(label + "\x00" + other))[0:16]...)
+}
+
+func checkEdge(src string, label string, dst string) (err error) {
+	fields, values := []string{"src", "label", "dst"}, []string{src, label, dst}
+	for i, v := range values {
+		if len(v) == 0 || len(v) > GRAPH_NODE_SIZE_MAX {
+			return invalidRequest(fields[i], v, fmt.Sprintf("must be 1 to %d bytes", GRAPH_NODE_SIZE_MAX))
+		}
+	}
+	return nil
+}
+
+// PutEdge stores e, replacing the properties of the edge when it exists
+func (g *Graph) PutEdge(u *SWARMDBUser, e Edge) (err error) {
+	if err = g.swarmdb.checkWritable(); err != nil {
+		return err
+	}
+	if err = checkEdge(e.Src, e.Label, e.Dst); err != nil {
+		return err
+	}
+	data, err := json.Marshal(&e)
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[graph:PutEdge] Marshal %s", err.Error()), ErrorCode: 435, ErrorMessage: "Invalid Edge Properties"}
+	}
+	if len(data) > KEYSPACE_VALUE_SIZE_MAX {
+		return invalidRequest("edge", fmt.Sprintf("%d bytes", len(data)), fmt.Sprintf("must be at most %d bytes of JSON", KEYSPACE_VALUE_SIZE_MAX))
+	}
+	ehash, err := g.swarmdb.storeValueChunk(u, data, 0)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graph:PutEdge] %s", err.Error()))
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, err = g.forward.Put(u, edgeKey(e.Src, e.Label, e.Dst), ehash); err == nil {
+		_, err = g.reverse.Put(u, edgeKey(e.Dst, e.Label, e.Src), ehash)
+	}
+	if err == nil {
+		err = g.publish(u)
+	}
+	if err != nil {
+		g.close()
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graph:PutEdge] %s", err.Error()))
+	}
+	return nil
+}
+
+// DeleteEdge removes the edge labelled label from src to dst and reports whether it was there
+func (g *Graph) DeleteEdge(u *SWARMDBUser, src string, label string, dst string) (ok bool, err error) {
+	if err = g.swarmdb.checkWritable(); err != nil {
+		return false, err
+	}
+	if err = checkEdge(src, label, dst); err != nil {
+		return false, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if ok, err = g.forward.Delete(u, edgeKey(src, label, dst)); err == nil && ok {
+		if _, err = g.reverse.Delete(u, edgeKey(dst, label, src)); err == nil {
+			err = g.publish(u)
+		}
+	}
+	if err != nil {
+		g.close()
+		return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graph:DeleteEdge] %s", err.Error()))
+	}
+	return ok, nil
+}
+
+// edges calls fn with the edges from node, in the forward index, or to it, in the reverse one, until it returns false
+// or an error
+func (g *Graph) edges(u *SWARMDBUser, index OrderedDatabase, node string, fn func(e *Edge) (bool, error)) (err error) {
+	return seekPrefix(u, index, crypto.Keccak256([]byte(node))[0:16], func(k []byte, v []byte) (bool, error) {
+		data, err := g.swarmdb.retrieveValueChunk(u, v)
+		if err != nil {
+			return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graph:edges] %s", err.Error()))
+		}
+		e := new(Edge)
+		if err = json.Unmarshal(data, e); err != nil {
+			return false, &sdbc.SWARMDBError{Message: fmt.Sprintf("[graph:edges] Unmarshal %s", err.Error()), ErrorCode: 439, ErrorMessage: "Unable to Parse Edge"}
+		}
+		return fn(e)
+	})
+}
+
+// Traverse returns the edges reached walking the graph breadth first from start, up to depth edges away, that pass
+// filter (nil follows every outgoing edge), each with the depth it was reached at.  A node is walked from once, so
+// every edge is returned once even in cyclic graphs.
+func (g *Graph) Traverse(u *SWARMDBUser, start string, depth int, filter *TraverseFilter) (steps []TraversalStep, err error) {
+	if depth < 1 || depth > GRAPH_DEPTH_MAX {
+		return nil, invalidRequest("depth", fmt.Sprintf("%d", depth), fmt.Sprintf("must be 1 to %d", GRAPH_DEPTH_MAX))
+	}
+	if filter == nil {
+		filter = new(TraverseFilter)
+	}
+	var indexes []OrderedDatabase
+	switch filter.Direction {
+	case "", GRAPH_OUT:
+		indexes = []OrderedDatabase{g.forward}
+	case GRAPH_IN:
+		indexes = []OrderedDatabase{g.reverse}
+	case GRAPH_BOTH:
+		indexes = []OrderedDatabase{g.forward, g.reverse}
+	default:
+		return nil, invalidRequest("direction", filter.Direction, fmt.Sprintf("must be %s, %s or %s", GRAPH_OUT, GRAPH_IN, GRAPH_BOTH))
+	}
+	if _, err = matchDoc(nil, filter.Where); err != nil {
+		return nil, err
+	}
+	labels := make(map[string]bool)
+	for _, label := range filter.Labels {
+		labels[label] = true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	visited := map[string]bool{start: true}
+	followed := make(map[string]bool) // edges, which both ends find when walking both directions
+	frontier := []string{start}
+	for d := 1; d <= depth && len(frontier) > 0; d++ {
+		var next []string
+		for _, node := range frontier {
+			for _, index := range indexes {
+				reverse := index == g.reverse
+				err = g.edges(u, index, node, func(e *Edge) (bool, error) {
+					if len(labels) > 0 && !labels[e.Label] {
+						return true, nil
+					}
+					if match, err := matchDoc(e.Props, filter.Where); err != nil || !match {
+						return true, err
+					}
+					id := e.Src + "\x00" + e.Label + "\x00" + e.Dst
+					if followed[id] {
+						return true, nil
+					}
+					followed[id] = true
+					other := e.Dst
+					if reverse {
+						other = e.Src
+					}
+					steps = append(steps, TraversalStep{Edge: *e, Depth: d})
+					if !visited[other] {
+						visited[other] = true
+						next = append(next, other)
+					}
+					return len(steps) < GRAPH_TRAVERSE_MAX, nil
+				})
+				if err != nil {
+					return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graph:Traverse] %s", err.Error()))
+				}
+				if len(steps) >= GRAPH_TRAVERSE_MAX {
+					return steps, nil
+				}
+			}
+		}
+		frontier = next
+	}
+	return steps, nil
+}
+
+// PutEdge stores e in the named graph of owner, creating the graph when it does not exist
+func (self *SwarmDB) PutEdge(u *SWARMDBUser, owner string, name string, e Edge) (err error) {
+	g, err := self.GetGraph(u, owner, name, true)
+	if err != nil {
+		return err
+	}
+	return g.PutEdge(u, e)
+}
+
+// DeleteEdge removes the edge labelled label from src to dst of the named graph of owner and reports whether it
+// was there
+func (self *SwarmDB) DeleteEdge(u *SWARMDBUser, owner string, name string, src string, label string, dst string) (ok bool, err error) {
+	g, err := self.GetGraph(u, owner, name, false)
+	if err != nil {
+		return false, err
+	}
+	return g.DeleteEdge(u, src, label, dst)
+}
+
+// Traverse walks the named graph of owner from start, see Graph.Traverse
+func (self *SwarmDB) Traverse(u *SWARMDBUser, owner string, name string, start string, depth int, filter *TraverseFilter) (steps []TraversalStep, err error) {
+	g, err := self.GetGraph(u, owner, name, false)
+	if err != nil {
+		return nil, err
+	}
+	return g.Traverse(u, start, depth, filter)
+}
+
+// rowEdge reads an edge from a request row {"src", "dst", "label", "props"}
+func rowEdge(row sdbc.Row) (e Edge) {
+	e.Src, _ = row["src"].(string)
+	e.Dst, _ = row["dst"].(string)
+	e.Label, _ = row["label"].(string)
+	e.Props, _ = row["props"].(map[string]interface{})
+	return e
+}
+
+// graphRequest answers RT_PUT_EDGE, RT_DELETE_EDGE and RT_TRAVERSE on the graph d.Table of d.Owner
+func (self *SwarmDB) graphRequest(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	switch d.RequestType {
+	case wire.RT_PUT_EDGE:
+		for _, row := range d.Rows {
+			if err = self.PutEdge(u, d.Owner, d.Table, rowEdge(row)); err != nil {
+				return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graph:graphRequest] PutEdge %s", err.Error()))
+			}
+			resp.AffectedRowCount++
+		}
+	case wire.RT_DELETE_EDGE:
+		for _, row := range d.Rows {
+			e := rowEdge(row)
+			ok, err := self.DeleteEdge(u, d.Owner, d.Table, e.Src, e.Label, e.Dst)
+			if err != nil {
+				return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graph:graphRequest] DeleteEdge %s", err.Error()))
+			}
+			if ok {
+				resp.AffectedRowCount++
+			}
+		}
+	case wire.RT_TRAVERSE:
+		start, _ := d.Key.(string)
+		depth, filter := 1, new(TraverseFilter)
+		if len(d.Rows) > 0 {
+			arg := d.Rows[0]
+			if n, ok := toFloat(arg["depth"]); ok && n > 0 {
+				depth = int(n)
+			}
+			filter.Direction, _ = arg["direction"].(string)
+			filter.Where, _ = arg["where"].(map[string]interface{})
+			labels, _ := arg["labels"].([]interface{})
+			for _, label := range labels {
+				if s, ok := label.(string); ok {
+					filter.Labels = append(filter.Labels, s)
+				}
+			}
+		}
+		steps, err := self.Traverse(u, d.Owner, d.Table, start, depth, filter)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graph:graphRequest] Traverse %s", err.Error()))
+		}
+		for _, step := range steps {
+			resp.Data = append(resp.Data, sdbc.Row{"src": step.Src, "dst": step.Dst, "label": step.Label, "props": step.Props, "depth": step.Depth})
+		}
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
@@ -0,0 +1,668 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The GraphQL schema of a database is generated from its table descriptors, so front-end code can read tables
+// without SQL.  Every table whose name is a GraphQL name is a field of the Query type and an object type of the
+// same name, e.g. for a table "users" keyed on "email" with an indexed "age":
+//
+//	type Query {
+//	  users(email: String, age: Int, limit: Int): [users!]!
+//	}
+//
+//	type users {
+//	  email: String!
+//	  age: Int
+//	  name: String
+//	}
+//
+// Columns are fields, with CT_INTEGER as Int, CT_FLOAT as Float and the others as String; indexed columns are
+// arguments that select the rows equal to the given value, and limit caps the number of rows returned.  An argument
+// on the primary column reads the row with Get, the others scan the table.  Restricted columns the caller may not
+// read are left out of the types, and reads go through HandleRequest with the usual ACL checks.
+//
+// Only queries are supported: one operation with variables, aliases and __typename, but no fragments, directives,
+// mutations, subscriptions or introspection.
+
+const (
+	GRAPHQL_QUERY_SIZE_MAX = 1 << 16 // bytes of query text accepted
+	GRAPHQL_LIMIT          = "limit" // argument capping the rows of a table field
+)
+
+// GraphQLRequest is the body of a POST to /graphql/{owner}/{database}
+type GraphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+// GraphQLError is an entry of the errors of a GraphQLResponse; Path holds the alias of the field it applies to
+type GraphQLError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// GraphQLResponse holds the rows of each field of a query, null for the fields that failed, and their errors
+type GraphQLResponse struct {
+	Data   map[string]interface{} `json:"data,omitempty"`
+	Errors []GraphQLError         `json:"errors,omitempty"`
+}
+
+// gqlField is a field of a selection set, with its variables substituted in its arguments
+type gqlField struct {
+	Alias     string
+	Name      string
+	Arguments map[string]interface{}
+	Fields    []*gqlField
+}
+
+// gqlTable is the object type of a table
+type gqlTable struct {
+	name    string
+	primary string
+	columns map[string]sdbc.Column // the columns the caller may read
+}
+
+// GraphQLSchema returns the schema of the tables of database u may read, in the schema definition language
+func (self *SwarmDB) GraphQLSchema(u *SWARMDBUser, owner string, database string) (sdl string, err error) {
+	tableNames, err := self.ListTables(u, owner, database)
+	if err != nil {
+		return sdl, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graphql:GraphQLSchema] ListTables %s", err.Error()))
+	}
+	var tables []*gqlTable
+	for _, r := range tableNames {
+		name, _ := r["table"].(string)
+		if !validGraphQLName(name) || name == "Query" {
+			continue
+		}
+		t, err := self.graphQLTable(u, owner, database, name)
+		if err != nil {
+			// tables u may not read are not part of its schema
+			continue
+		}
+		tables = append(tables, t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].name < tables[j].name })
+
+	var b bytes.Buffer
+	b.WriteString("type Query {\n")
+	for _, t := range tables {
+		fmt.Fprintf(&b, "  %s(%s): [%s!]!\n", t.name, strings.Join(t.arguments(), ", "), t.name)
+	}
+	b.WriteString("}\n")
+	for _, t := range tables {
+		fmt.Fprintf(&b, "\ntype %s {\n", t.name)
+		for _, name := range t.fields() {
+			typ := graphQLType(t.columns[name].ColumnType)
+			if name == t.primary {
+				typ += "!"
+			}
+			fmt.Fprintf(&b, "  %s: %s\n", name, typ)
+		}
+		b.WriteString("}\n")
+	}
+	return b.String(), nil
+}
+
+// GraphQL runs the query of req on the tables of database.  Errors of a field are reported next to the data of the
+// others; a query that cannot be parsed returns an error instead.
+func (self *SwarmDB) GraphQL(u *SWARMDBUser, owner string, database string, req *GraphQLRequest) (resp GraphQLResponse, err error) {
+	fields, err := parseGraphQL(req.Query, req.Variables)
+	if err != nil {
+		return resp, err
+	}
+	resp.Data = make(map[string]interface{})
+	for _, f := range fields {
+		if f.Name == "__typename" {
+			resp.Data[f.Alias] = "Query"
+			continue
+		}
+		rows, err := self.graphQLField(u, owner, database, f)
+		if err != nil {
+			resp.Data[f.Alias] = nil
+			resp.Errors = append(resp.Errors, GraphQLError{Message: graphQLMessage(err), Path: []interface{}{f.Alias}})
+			continue
+		}
+		resp.Data[f.Alias] = rows
+	}
+	return resp, nil
+}
+
+func (self *SwarmDB) graphQLTable(u *SWARMDBUser, owner string, database string, name string) (t *gqlTable, err error) {
+	tbl, err := self.GetTable(u, owner, database, name)
+	if err != nil {
+		return t, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graphql:graphQLTable] GetTable %s", err.Error()))
+	}
+	hidden, err := tbl.checkRead(u)
+	if err != nil {
+		return t, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graphql:graphQLTable] checkRead %s", err.Error()))
+	}
+	tblInfo, err := tbl.DescribeTable()
+	if err != nil {
+		return t, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graphql:graphQLTable] DescribeTable %s", err.Error()))
+	}
+	t = &gqlTable{name: name, primary: tbl.primaryColumnName, columns: make(map[string]sdbc.Column)}
+	for cname, c := range tblInfo {
+		if !hidden[cname] && validGraphQLName(cname) {
+			t.columns[cname] = c
+		}
+	}
+	return t, nil
+}
+
+// fields returns the names of the fields of t, the primary column first
+func (t *gqlTable) fields() []string {
+	names := make([]string, 0, len(t.columns))
+	for name := range t.columns {
+		names = append(names, name)
+	}
+	return orderColumns(names, t.primary)
+}
+
+// isArgument reports whether column name of t is an argument of its Query field
+func (t *gqlTable) isArgument(name string) bool {
+	c, ok := t.columns[name]
+	return ok && c.IndexType != sdbc.IT_NONE && name != GRAPHQL_LIMIT
+}
+
+// arguments returns the argument definitions of the Query field of t
+func (t *gqlTable) arguments() (args []string) {
+	for _, name := range t.fields() {
+		if t.isArgument(name) {
+			args = append(args, fmt.Sprintf("%s: %s", name, graphQLType(t.columns[name].ColumnType)))
+		}
+	}
+	return append(args, GRAPHQL_LIMIT+": Int")
+}
+
+// graphQLField returns the rows selected by the Query field f, projected onto its subfields
+func (self *SwarmDB) graphQLField(u *SWARMDBUser, owner string, database string, f *gqlField) (out []map[string]interface{}, err error) {
+	if !validGraphQLName(f.Name) || f.Name == "Query" {
+		return out, graphQLError("graphQLField", fmt.Sprintf("Cannot query field [%s] on type [Query]", f.Name))
+	}
+	t, err := self.graphQLTable(u, owner, database, f.Name)
+	if err != nil {
+		return out, err
+	}
+	if len(f.Fields) == 0 {
+		return out, graphQLError("graphQLField", fmt.Sprintf("Field [%s] of type [[%s!]!] must have a selection of subfields", f.Alias, t.name))
+	}
+	for _, sel := range f.Fields {
+		if sel.Name == "__typename" {
+			continue
+		}
+		if _, ok := t.columns[sel.Name]; !ok {
+			return out, graphQLError("graphQLField", fmt.Sprintf("Cannot query field [%s] on type [%s]", sel.Name, t.name))
+		}
+		if len(sel.Fields) > 0 || len(sel.Arguments) > 0 {
+			return out, graphQLError("graphQLField", fmt.Sprintf("Field [%s] of type [%s] takes no arguments or subfields", sel.Name, graphQLType(t.columns[sel.Name].ColumnType)))
+		}
+	}
+
+	limit := -1
+	where := make(map[string]interface{})
+	for name, value := range f.Arguments {
+		if name == GRAPHQL_LIMIT {
+			if limit, err = graphQLLimit(value); err != nil {
+				return out, err
+			}
+			continue
+		}
+		if !t.isArgument(name) {
+			return out, graphQLError("graphQLField", fmt.Sprintf("Unknown argument [%s] on field [Query.%s]", name, t.name))
+		}
+		// a null argument is the same as none
+		if value != nil {
+			where[name] = value
+		}
+	}
+
+	req := &sdbc.RequestOption{RequestType: sdbc.RT_SCAN, Owner: owner, Database: database, Table: t.name}
+	if key, ok := where[t.primary]; ok {
+		req.RequestType = sdbc.RT_GET
+		req.Key = key
+	}
+	resp, err := self.HandleRequest(u, req)
+	if err != nil {
+		return out, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[graphql:graphQLField] HandleRequest %s", err.Error()))
+	}
+	out = make([]map[string]interface{}, 0)
+	for _, row := range resp.Data {
+		if limit >= 0 && len(out) >= limit {
+			break
+		}
+		if !graphQLMatch(row, where) {
+			continue
+		}
+		obj := make(map[string]interface{})
+		for _, sel := range f.Fields {
+			if sel.Name == "__typename" {
+				obj[sel.Alias] = t.name
+			} else {
+				obj[sel.Alias] = row[sel.Name]
+			}
+		}
+		out = append(out, obj)
+	}
+	return out, nil
+}
+
+// graphQLMatch reports whether row holds every value of where, comparing numbers by value whatever their Go type
+func graphQLMatch(row sdbc.Row, where map[string]interface{}) bool {
+	for name, value := range where {
+		v, ok := row[name]
+		if !ok || graphQLString(v) != graphQLString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func graphQLString(v interface{}) string {
+	switch n := v.(type) {
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(n)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func graphQLLimit(value interface{}) (limit int, err error) {
+	switch n := value.(type) {
+	case nil:
+		return -1, nil
+	case int:
+		limit = n
+	case float64:
+		// from the JSON variables
+		limit = int(n)
+		if float64(limit) != n {
+			limit = -1
+		}
+	default:
+		limit = -1
+	}
+	if limit < 0 {
+		return limit, graphQLError("graphQLLimit", fmt.Sprintf("Argument [%s] must be a non-negative Int, not [%v]", GRAPHQL_LIMIT, value))
+	}
+	return limit, nil
+}
+
+func graphQLType(ct sdbc.ColumnType) string {
+	switch ct {
+	case sdbc.CT_INTEGER:
+		return "Int"
+	case sdbc.CT_FLOAT:
+		return "Float"
+	}
+	return "String"
+}
+
+// validGraphQLName reports whether name is a GraphQL name that is not reserved for introspection
+func validGraphQLName(name string) bool {
+	if len(name) == 0 || strings.HasPrefix(name, "__") || isDigit(name[0]) {
+		return false
+	}
+	for _, c := range []byte(name) {
+		if !isNameChar(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isDigit(c) || c == '_'
+}
+
+func graphQLError(fn string, msg string) error {
+	return &sdbc.SWARMDBError{Message: fmt.Sprintf("[graphql:%s] %s", fn, msg), ErrorCode: 418, ErrorMessage: msg}
+}
+
+// graphQLMessage is the message of err shown to GraphQL clients
+func graphQLMessage(err error) string {
+	var sErr *sdbc.SWARMDBError
+	if errors.As(err, &sErr) && len(sErr.ErrorMessage) > 0 {
+		return sErr.ErrorMessage
+	}
+	return err.Error()
+}
+
+const (
+	gqlEOF = iota
+	gqlPunct
+	gqlName
+	gqlInt
+	gqlFloat
+	gqlString
+)
+
+// gqlParser is a recursive descent parser of GraphQL queries; tok is the current token, of type kind
+type gqlParser struct {
+	src  string
+	pos  int
+	tok  string
+	kind int
+	vars map[string]interface{}
+}
+
+// parseGraphQL returns the top level fields of the query, with the variables substituted
+func parseGraphQL(query string, variables map[string]interface{}) (fields []*gqlField, err error) {
+	if len(query) > GRAPHQL_QUERY_SIZE_MAX {
+		return fields, &sdbc.SWARMDBError{Message: fmt.Sprintf("[graphql:parseGraphQL] Query of %d bytes exceeds %d", len(query), GRAPHQL_QUERY_SIZE_MAX), ErrorCode: 409, ErrorMessage: fmt.Sprintf("GraphQL query exceeds %d bytes", GRAPHQL_QUERY_SIZE_MAX)}
+	}
+	p := &gqlParser{src: query, vars: make(map[string]interface{})}
+	if err = p.next(); err != nil {
+		return fields, err
+	}
+	if p.kind == gqlName {
+		switch p.tok {
+		case "query":
+		case "mutation", "subscription", "fragment":
+			return fields, p.errorf("%s is not supported", p.tok)
+		default:
+			return fields, p.errorf("Unexpected [%s]", p.tok)
+		}
+		if err = p.next(); err != nil {
+			return fields, err
+		}
+		if p.kind == gqlName {
+			// the operation name
+			if err = p.next(); err != nil {
+				return fields, err
+			}
+		}
+		if p.is("(") {
+			if err = p.parseVariableDefinitions(variables); err != nil {
+				return fields, err
+			}
+		}
+	}
+	if fields, err = p.parseSelectionSet(); err != nil {
+		return fields, err
+	}
+	if p.kind != gqlEOF {
+		return fields, p.errorf("Only one operation is supported, found [%s]", p.tok)
+	}
+	return fields, nil
+}
+
+func (p *gqlParser) errorf(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	return &sdbc.SWARMDBError{Message: fmt.Sprintf("[graphql:parseGraphQL] %s at offset %d", msg, p.pos), ErrorCode: 439, ErrorMessage: fmt.Sprintf("GraphQL Syntax Error: %s", msg)}
+}
+
+func (p *gqlParser) is(punct string) bool {
+	return p.kind == gqlPunct && p.tok == punct
+}
+
+func (p *gqlParser) expect(punct string) (err error) {
+	if !p.is(punct) {
+		return p.errorf("Expected [%s], found [%s]", punct, p.tok)
+	}
+	return p.next()
+}
+
+func (p *gqlParser) name() (name string, err error) {
+	if p.kind != gqlName {
+		return name, p.errorf("Expected a name, found [%s]", p.tok)
+	}
+	name = p.tok
+	return name, p.next()
+}
+
+// next reads the next token; commas are insignificant like white space
+func (p *gqlParser) next() (err error) {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+		} else if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		} else {
+			break
+		}
+	}
+	p.tok = ""
+	if p.pos >= len(p.src) {
+		p.kind = gqlEOF
+		return nil
+	}
+	start := p.pos
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.kind = gqlPunct
+	case strings.IndexByte("{}()[]:!$=@", c) >= 0:
+		p.pos++
+		p.kind = gqlPunct
+	case isNameChar(c) && !isDigit(c):
+		for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+			p.pos++
+		}
+		p.kind = gqlName
+	case c == '-' || isDigit(c):
+		p.pos++
+		p.kind = gqlInt
+		for ; p.pos < len(p.src); p.pos++ {
+			d := p.src[p.pos]
+			if d == '.' || d == 'e' || d == 'E' || (d == '+' || d == '-') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E') {
+				p.kind = gqlFloat
+			} else if !isDigit(d) {
+				break
+			}
+		}
+	case c == '"':
+		if strings.HasPrefix(p.src[p.pos:], `"""`) {
+			return p.errorf("Block strings are not supported")
+		}
+		for p.pos++; p.pos < len(p.src) && p.src[p.pos] != '"'; p.pos++ {
+			if p.src[p.pos] == '\n' {
+				break
+			} else if p.src[p.pos] == '\\' {
+				p.pos++
+			}
+		}
+		if p.pos >= len(p.src) || p.src[p.pos] != '"' {
+			return p.errorf("Unterminated string")
+		}
+		p.pos++
+		p.kind = gqlString
+	default:
+		return p.errorf("Unexpected character [%c]", c)
+	}
+	p.tok = p.src[start:p.pos]
+	return nil
+}
+
+// parseVariableDefinitions reads "($name: Type = default, ...)", taking the values from variables
+func (p *gqlParser) parseVariableDefinitions(variables map[string]interface{}) (err error) {
+	if err = p.expect("("); err != nil {
+		return err
+	}
+	for !p.is(")") {
+		if err = p.expect("$"); err != nil {
+			return err
+		}
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		if err = p.expect(":"); err != nil {
+			return err
+		}
+		if p.is("[") {
+			return p.errorf("List types are not supported")
+		}
+		typ, err := p.name()
+		if err != nil {
+			return err
+		}
+		required := p.is("!")
+		if required {
+			if err = p.next(); err != nil {
+				return err
+			}
+		}
+		value, ok := variables[name]
+		if p.is("=") {
+			if err = p.next(); err != nil {
+				return err
+			}
+			def, err := p.parseValue(true)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				value, ok = def, true
+			}
+		}
+		if required && (!ok || value == nil) {
+			return p.errorf("Variable [$%s] of required type [%s!] was not provided", name, typ)
+		}
+		p.vars[name] = value
+	}
+	return p.next()
+}
+
+func (p *gqlParser) parseSelectionSet() (fields []*gqlField, err error) {
+	if err = p.expect("{"); err != nil {
+		return fields, err
+	}
+	for !p.is("}") {
+		if p.is("...") {
+			return fields, p.errorf("Fragments are not supported")
+		}
+		f := new(gqlField)
+		if f.Name, err = p.name(); err != nil {
+			return fields, err
+		}
+		f.Alias = f.Name
+		if p.is(":") {
+			if err = p.next(); err != nil {
+				return fields, err
+			}
+			if f.Name, err = p.name(); err != nil {
+				return fields, err
+			}
+		}
+		if p.is("(") {
+			if f.Arguments, err = p.parseArguments(); err != nil {
+				return fields, err
+			}
+		}
+		if p.is("@") {
+			return fields, p.errorf("Directives are not supported")
+		}
+		if p.is("{") {
+			if f.Fields, err = p.parseSelectionSet(); err != nil {
+				return fields, err
+			}
+		}
+		for _, g := range fields {
+			if g.Alias == f.Alias {
+				return fields, p.errorf("Duplicate field [%s]", f.Alias)
+			}
+		}
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return fields, p.errorf("Empty selection set")
+	}
+	return fields, p.next()
+}
+
+func (p *gqlParser) parseArguments() (args map[string]interface{}, err error) {
+	if err = p.expect("("); err != nil {
+		return args, err
+	}
+	args = make(map[string]interface{})
+	for !p.is(")") {
+		name, err := p.name()
+		if err != nil {
+			return args, err
+		}
+		if _, ok := args[name]; ok {
+			return args, p.errorf("Duplicate argument [%s]", name)
+		}
+		if err = p.expect(":"); err != nil {
+			return args, err
+		}
+		if args[name], err = p.parseValue(false); err != nil {
+			return args, err
+		}
+	}
+	return args, p.next()
+}
+
+// parseValue reads a scalar or, unless constant, a variable
+func (p *gqlParser) parseValue(constant bool) (v interface{}, err error) {
+	switch {
+	case p.is("$") && !constant:
+		if err = p.next(); err != nil {
+			return v, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return v, err
+		}
+		value, ok := p.vars[name]
+		if !ok {
+			return value, p.errorf("Variable [$%s] is not defined", name)
+		}
+		return value, nil
+	case p.kind == gqlInt:
+		if v, err = strconv.Atoi(p.tok); err != nil {
+			return v, p.errorf("Invalid Int [%s]", p.tok)
+		}
+	case p.kind == gqlFloat:
+		if v, err = strconv.ParseFloat(p.tok, 64); err != nil {
+			return v, p.errorf("Invalid Float [%s]", p.tok)
+		}
+	case p.kind == gqlString:
+		// GraphQL strings escape as JSON strings do
+		var s string
+		if err = json.Unmarshal([]byte(p.tok), &s); err != nil {
+			return v, p.errorf("Invalid String [%s]", p.tok)
+		}
+		v = s
+	case p.kind == gqlName && (p.tok == "true" || p.tok == "false"):
+		v = p.tok == "true"
+	case p.kind == gqlName && p.tok == "null":
+		v = nil
+	default:
+		return v, p.errorf("Unsupported value [%s]", p.tok)
+	}
+	return v, p.next()
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	pb "github.com/ethereum/go-ethereum/swarmdb/swarmdbpb"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"net"
+	"time"
+)
+
+// GRPCServer implements pb.SwarmDBServer alongside the JSON protocol.  Unary calls go through HandleRequest;
+// Query and Scan send their rows as they are read from the index (see stream.go), so large results are never held
+// in memory.
+//
+// gRPC calls share no session, so each one authenticates with its metadata (see auth.go): GRPC_METADATA_CHALLENGE
+// names a challenge returned by the Challenge call and GRPC_METADATA_SIGNATURE is the hex signature of
+// SignHash(challenge), or GRPC_METADATA_CAPABILITY carries a capability token.
+const (
+	GRPC_METADATA_CHALLENGE  = "swarmdb-challenge"
+	GRPC_METADATA_SIGNATURE  = "swarmdb-signature"
+	GRPC_METADATA_CAPABILITY = "swarmdb-capability"
+)
+
+type GRPCServer struct {
+	swarmdb *SwarmDB
+	config  *SWARMDBConfig
+	auth    *Authenticator
+	limiter *RateLimiter
+	server  *grpc.Server
+}
+
+func NewGRPCServer(swarmdb *SwarmDB, config *SWARMDBConfig) *GRPCServer {
+	return &GRPCServer{swarmdb: swarmdb, config: config, auth: NewAuthenticator(swarmdb, config), limiter: NewRateLimiter(config)}
+}
+
+func (self *GRPCServer) ListenAndServe() (err error) {
+	addr := fmt.Sprintf("%s:%d", self.config.ListenAddrGRPC, self.config.PortGRPC)
+	tlsConfig, err := self.config.ServerTLSConfig()
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[grpcserver:ListenAndServe] ServerTLSConfig %s", err.Error()))
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[grpcserver:ListenAndServe] Listen %s", err.Error()), ErrorCode: 485, ErrorMessage: "Unable to start gRPC server"}
+	}
+	// gRPC negotiates HTTP/2 through ALPN, so TLS goes through its credentials rather than a wrapped listener
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	self.server = grpc.NewServer(opts...)
+	pb.RegisterSwarmDBServer(self.server, self)
+	log.Debug(fmt.Sprintf("[grpcserver:ListenAndServe] listening on %s", addr))
+	return self.server.Serve(listener)
+}
+
+// Shutdown stops accepting RPCs and waits for pending ones; when ctx is done the remaining RPCs are cancelled
+func (self *GRPCServer) Shutdown(ctx context.Context) (err error) {
+	if self.server == nil {
+		return nil
+	}
+	stopped := make(chan struct{})
+	go func() {
+		self.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		self.server.Stop()
+	}
+	return nil
+}
+
+// Challenge returns a new challenge, which one call may carry with its signature until it expires
+func (self *GRPCServer) Challenge(ctx context.Context, req *pb.ChallengeRequest) (*pb.ChallengeResponse, error) {
+	challenge, expires, err := self.auth.Challenge()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ChallengeResponse{Challenge: challenge, ExpiresMs: expires.UnixNano() / int64(time.Millisecond)}, nil
+}
+
+func (self *GRPCServer) CreateTable(ctx context.Context, req *pb.CreateTableRequest) (*pb.Response, error) {
+	var columns []sdbc.Column
+	for _, c := range req.Columns {
+		columns = append(columns, sdbc.Column{ColumnName: c.ColumnName, ColumnType: sdbc.ColumnType(c.ColumnType), IndexType: sdbc.IndexType(c.IndexType), Primary: int(c.Primary)})
+	}
+	return self.unary(ctx, &sdbc.RequestOption{RequestType: sdbc.RT_CREATE_TABLE, Owner: req.Owner, Database: req.Database, Table: req.Table, Columns: columns})
+}
+
+func (self *GRPCServer) Put(ctx context.Context, req *pb.PutRequest) (*pb.Response, error) {
+	var rows []sdbc.Row
+	for _, r := range req.Rows {
+		row := sdbc.NewRow()
+		if err := json.Unmarshal(r.RowJson, &row); err != nil {
+			return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[grpcserver:Put] Unmarshal %s", err.Error()), ErrorCode: 435, ErrorMessage: "Invalid Row Data"}
+		}
+		rows = append(rows, row)
+	}
+	return self.unary(ctx, &sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: req.Owner, Database: req.Database, Table: req.Table, Rows: rows})
+}
+
+func (self *GRPCServer) Get(ctx context.Context, req *pb.KeyRequest) (*pb.Response, error) {
+	return self.unary(ctx, &sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: req.Owner, Database: req.Database, Table: req.Table, Key: req.Key})
+}
+
+func (self *GRPCServer) Delete(ctx context.Context, req *pb.KeyRequest) (*pb.Response, error) {
+	return self.unary(ctx, &sdbc.RequestOption{RequestType: sdbc.RT_DELETE, Owner: req.Owner, Database: req.Database, Table: req.Table, Key: req.Key})
+}
+
+func (self *GRPCServer) Query(req *pb.QueryRequest, stream pb.SwarmDB_QueryServer) error {
+	d := &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: req.Owner, Database: req.Database, RawQuery: req.Query}
+	u, err := self.authenticate(stream.Context(), d)
+	if err != nil {
+		return err
+	}
+	release, err := self.limiter.Admit(nil, u, requestSize(d), true)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return self.swarmdb.QueryStream(u, d, func(row sdbc.Row) error { return sendRow(stream.Send, row) })
+}
+
+func (self *GRPCServer) Scan(req *pb.TableRequest, stream pb.SwarmDB_ScanServer) error {
+	d := &sdbc.RequestOption{RequestType: sdbc.RT_SCAN, Owner: req.Owner, Database: req.Database, Table: req.Table}
+	u, err := self.authenticate(stream.Context(), d)
+	if err != nil {
+		return err
+	}
+	release, err := self.limiter.Admit(nil, u, requestSize(d), true)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return self.swarmdb.ScanStream(u, d, func(row sdbc.Row) error { return sendRow(stream.Send, row) })
+}
+
+// authenticate returns the user the call of d runs as, from the credentials in the metadata of ctx, carrying the
+// deadline of the call so abandoned calls stop early.  A capability fills in the owner and database of d.
+func (self *GRPCServer) authenticate(ctx context.Context, d *sdbc.RequestOption) (u *SWARMDBUser, err error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	value := func(key string) string {
+		if values := md[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	u, err = self.auth.Authenticate(Credentials{Challenge: value(GRPC_METADATA_CHALLENGE), Signature: value(GRPC_METADATA_SIGNATURE), Capability: value(GRPC_METADATA_CAPABILITY)}, d)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		u = u.WithDeadline(deadline)
+	}
+	return u, nil
+}
+
+func (self *GRPCServer) unary(ctx context.Context, req *sdbc.RequestOption) (*pb.Response, error) {
+	u, err := self.authenticate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	release, err := self.limiter.Admit(nil, u, requestSize(req), isQueryRequest(req.RequestType))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	resp, err := self.swarmdb.HandleRequest(u, req)
+	if err != nil {
+		return nil, err
+	}
+	out := &pb.Response{AffectedRowCount: int32(resp.AffectedRowCount), MatchedRowCount: int32(resp.MatchedRowCount)}
+	for _, row := range resp.Data {
+		rowJson, err := json.Marshal(row)
+		if err != nil {
+			return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[grpcserver:unary] Marshal %s", err.Error()), ErrorCode: 435, ErrorMessage: "Invalid Row Data"}
+		}
+		out.Rows = append(out.Rows, &pb.Row{RowJson: rowJson})
+	}
+	return out, nil
+}
+
+func sendRow(send func(*pb.Row) error, row sdbc.Row) error {
+	rowJson, err := json.Marshal(row)
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[grpcserver:sendRow] Marshal %s", err.Error()), ErrorCode: 435, ErrorMessage: "Invalid Row Data"}
+	}
+	return send(&pb.Row{RowJson: rowJson})
+}
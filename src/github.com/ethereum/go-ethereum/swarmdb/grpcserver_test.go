@@ -0,0 +1,127 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/crypto"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"github.com/ethereum/go-ethereum/swarmdb/swarmdblib"
+	pb "github.com/ethereum/go-ethereum/swarmdb/swarmdbpb"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"strings"
+	sdb "swarmdb"
+	"testing"
+)
+
+// scanStream collects the rows a Scan call sends
+type scanStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	rows []sdbc.Row
+}
+
+func (s *scanStream) Context() context.Context { return s.ctx }
+
+func (s *scanStream) Send(r *pb.Row) error {
+	row := sdbc.NewRow()
+	if err := json.Unmarshal(r.RowJson, &row); err != nil {
+		return err
+	}
+	s.rows = append(s.rows, row)
+	return nil
+}
+
+func grpcErrorCode(err error) int {
+	if sErr, ok := err.(*sdbc.SWARMDBError); ok {
+		return sErr.ErrorCode
+	}
+	return 0
+}
+
+func TestGRPCServerAuthentication(t *testing.T) {
+	owner, database, tableName := make_owner_table(t, strings.ToLower(u.Address), "grpcauth")
+	authConfig := *config
+	authConfig.Authentication = 1
+	server := sdb.NewGRPCServer(swarmdb, &authConfig)
+
+	rowJson, _ := json.Marshal(map[string]interface{}{"email": "grpc@wolk.com", "name": "Grace", "age": 42})
+	put := &pb.PutRequest{Owner: owner, Database: database, Table: tableName, Rows: []*pb.Row{{RowJson: rowJson}}}
+
+	// calls without credentials are refused
+	if _, err := server.Put(context.Background(), put); grpcErrorCode(err) != 489 {
+		t.Fatalf("[grpcserver_test:TestGRPCServerAuthentication] Put without credentials returned %v", err)
+	}
+
+	// a signed challenge authenticates one call as the signer
+	secretKey, err := crypto.HexToECDSA(strings.TrimPrefix(config.PrivateKey, "0x"))
+	if err != nil {
+		t.Fatalf("[grpcserver_test:TestGRPCServerAuthentication] HexToECDSA %s", err)
+	}
+	var challenge *pb.ChallengeResponse
+	var sig []byte
+	sign := func() context.Context {
+		if challenge, err = server.Challenge(context.Background(), &pb.ChallengeRequest{}); err != nil {
+			t.Fatalf("[grpcserver_test:TestGRPCServerAuthentication] Challenge %s", err)
+		}
+		if sig, err = crypto.Sign(sdb.SignHash([]byte(challenge.Challenge)), secretKey); err != nil {
+			t.Fatalf("[grpcserver_test:TestGRPCServerAuthentication] Sign %s", err)
+		}
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(sdb.GRPC_METADATA_CHALLENGE, challenge.Challenge, sdb.GRPC_METADATA_SIGNATURE, fmt.Sprintf("%x", sig)))
+	}
+	signed := sign()
+	if _, err = server.Put(signed, put); err != nil {
+		t.Fatalf("[grpcserver_test:TestGRPCServerAuthentication] Put with signed challenge %s", err)
+	}
+	if _, err = server.Put(signed, put); grpcErrorCode(err) != 489 {
+		t.Fatalf("[grpcserver_test:TestGRPCServerAuthentication] Put replaying a signed challenge returned %v", err)
+	}
+	stream := &scanStream{ctx: sign()}
+	if err = server.Scan(&pb.TableRequest{Owner: owner, Database: database, Table: tableName}, stream); err != nil {
+		t.Fatalf("[grpcserver_test:TestGRPCServerAuthentication] Scan %s", err)
+	}
+	if len(stream.rows) != 1 || stream.rows[0]["email"] != "grpc@wolk.com" {
+		t.Fatalf("[grpcserver_test:TestGRPCServerAuthentication] Scan streamed %v", stream.rows)
+	}
+
+	// a challenge the server did not issue is refused, as is a bad signature
+	unknown := metadata.NewIncomingContext(context.Background(), metadata.Pairs(sdb.GRPC_METADATA_CHALLENGE, "00", sdb.GRPC_METADATA_SIGNATURE, fmt.Sprintf("%x", sig)))
+	if _, err = server.Put(unknown, put); grpcErrorCode(err) != 489 {
+		t.Fatalf("[grpcserver_test:TestGRPCServerAuthentication] Put with unknown challenge returned %v", err)
+	}
+	badSig := metadata.NewIncomingContext(context.Background(), metadata.Pairs(sdb.GRPC_METADATA_CHALLENGE, challenge.Challenge, sdb.GRPC_METADATA_SIGNATURE, "zz"))
+	if _, err = server.Put(badSig, put); grpcErrorCode(err) != 419 {
+		t.Fatalf("[grpcserver_test:TestGRPCServerAuthentication] Put with bad signature returned %v", err)
+	}
+
+	// a capability runs the call as its owner within its scope
+	token, err := swarmdblib.MintCapability(config.PrivateKey, wire.Capability{Database: database, Table: tableName, Permission: "read"})
+	if err != nil {
+		t.Fatalf("[grpcserver_test:TestGRPCServerAuthentication] MintCapability %s", err)
+	}
+	capable := metadata.NewIncomingContext(context.Background(), metadata.Pairs(sdb.GRPC_METADATA_CAPABILITY, token))
+	stream = &scanStream{ctx: capable}
+	if err = server.Scan(&pb.TableRequest{Database: database, Table: tableName}, stream); err != nil || len(stream.rows) != 1 {
+		t.Fatalf("[grpcserver_test:TestGRPCServerAuthentication] Scan with capability %v %v", stream.rows, err)
+	}
+	if _, err = server.Put(capable, put); err == nil {
+		t.Fatalf("[grpcserver_test:TestGRPCServerAuthentication] Put with a read capability succeeded")
+	}
+}
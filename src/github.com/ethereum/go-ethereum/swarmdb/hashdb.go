@@ -18,6 +18,7 @@ package swarmdb
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/ethereum/go-ethereum/crypto/sha3"
 	"github.com/ethereum/go-ethereum/log"
@@ -236,17 +237,15 @@ func (self *Node) add(u *SWARMDBUser, addnode *Node, version int, nodekey []byte
 			addnode.Stored = false
 			addnode.Next = false
 			addnode.NodeKey = []byte(string(self.NodeKey) + "|" + strconv.Itoa(bin))
-			sdata := make([]byte, 4096)
-			copy(sdata[64:], convertToByte(addnode.Value))
-			copy(sdata[96:], addnode.Key)
 			self.Bin[bin] = addnode
 		}
 	} else {
 		if strings.Compare(string(self.Key), string(addnode.Key)) == 0 {
-			sdata := make([]byte, 4096)
+			sdata := getChunkBuffer()
 			copy(sdata[64:], convertToByte(addnode.Value))
 			copy(sdata[96:], addnode.Key)
 			dhash, err := swarmdb.StoreDBChunk(u, sdata, encrypted)
+			releaseChunkBuffer(sdata)
 			if err != nil {
 				return self, &sdbc.SWARMDBError{Message: `[hashdb:add] StoreDBChunk ` + err.Error()}
 			}
@@ -255,10 +254,6 @@ func (self *Node) add(u *SWARMDBUser, addnode *Node, version int, nodekey []byte
 			return self, nil
 		}
 		if len(self.Key) == 0 {
-			// TODO: may be able to remove sdata
-			sdata := make([]byte, 4096)
-			copy(sdata[64:], convertToByte(addnode.Value))
-			copy(sdata[96:], addnode.Key)
 			addnode.Next = false
 			addnode.Loaded = true
 			self = addnode
@@ -355,19 +350,22 @@ func (self *Node) storeBinToNetwork(u *SWARMDBUser, swarmdb *SwarmDB, encrypted
 	return adhash, err
 }
 
+// isKeyNotFound reports whether err, or an error it wraps, is a KeyNotFoundError
+func isKeyNotFound(err error) bool {
+	var notFound *sdbc.KeyNotFoundError
+	return errors.As(err, &notFound)
+}
+
 func (self *HashDB) Get(u *SWARMDBUser, k []byte) ([]byte, bool, error) {
 	log.Debug("[hashdb:Get]")
 	stack := newStack()
 	ret, err := self.rootnode.Get(u, k, self.swarmdb, self.columnType, stack)
 	if err != nil {
-		switch err.(type) {
-		case *sdbc.KeyNotFoundError:
-
+		if isKeyNotFound(err) {
 			return nil, false, nil
-		default:
-			log.Debug(fmt.Sprintf("***** ERROR retrieving key [%s] ****** [%s]\n", k, err))
-			return nil, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("Error Retrieving key [%s]", k))
 		}
+		log.Debug(fmt.Sprintf("***** ERROR retrieving key [%s] ****** [%s]\n", k, err))
+		return nil, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("Error Retrieving key [%s]", k))
 	}
 	value := bytes.Trim(convertToByte(ret), "\x00")
 	b := true
@@ -503,12 +501,10 @@ func (self *HashDB) Insert(u *SWARMDBUser, k []byte, v []byte) (bool, error) {
 func (self *HashDB) Delete(u *SWARMDBUser, k []byte) (bool, error) {
 	_, b, err := self.rootnode.Delete(u, k, self.swarmdb, self.columnType)
 	if err != nil {
-		switch err.(type) {
-		case *sdbc.KeyNotFoundError:
+		if isKeyNotFound(err) {
 			return false, nil
-		default:
-			return false, err
 		}
+		return false, err
 	}
 	return b, nil
 }
@@ -636,10 +632,11 @@ func (self *Node) flushBuffer(u *SWARMDBUser, swarmdb *SwarmDB, encrypted int) (
 					return nil, err
 				}
 			} else if bin.Stored == false && len(bytes.Trim(convertToByte(bin.Value), "\x00")) > 0 {
-				sdata := make([]byte, 4096)
+				sdata := getChunkBuffer()
 				copy(sdata[64:], convertToByte(bin.Value))
 				copy(sdata[96:], bin.Key)
 				dhash, err := swarmdb.StoreDBChunk(u, sdata, encrypted)
+				releaseChunkBuffer(sdata)
 				if err != nil {
 					return nil, &sdbc.SWARMDBError{Message: `[hashdb:flushBuffer] StoreDBChunk ` + err.Error()}
 				}
@@ -653,12 +650,12 @@ func (self *Node) flushBuffer(u *SWARMDBUser, swarmdb *SwarmDB, encrypted int) (
 	return self.NodeHash, err
 }
 
-func (self *HashDB) Print(u *SWARMDBUser) {
-	self.rootnode.print(u, self.swarmdb, self.columnType)
-	return
+// Print returns a line describing each bin of the tree, loading the bins not loaded yet, for the caller to log
+func (self *HashDB) Print(u *SWARMDBUser) []string {
+	return self.rootnode.print(u, self.swarmdb, self.columnType, nil)
 }
 
-func (self *Node) print(u *SWARMDBUser, swarmdb *SwarmDB, columnType sdbc.ColumnType) {
+func (self *Node) print(u *SWARMDBUser, swarmdb *SwarmDB, columnType sdbc.ColumnType, lines []string) []string {
 	for binnum, bin := range self.Bin {
 		if bin != nil {
 			if bin.Loaded == false {
@@ -666,13 +663,14 @@ func (self *Node) print(u *SWARMDBUser, swarmdb *SwarmDB, columnType sdbc.Column
 				bin.Loaded = true
 			}
 			if bin.Next != true {
-				fmt.Printf("leaf key = %v Value = %x binnum = %d level = %d Value len = %d\n", bin.Key, bin.Value, binnum, bin.Level, len(bytes.Trim(convertToByte(bin.Value), "\x00")))
+				lines = append(lines, fmt.Sprintf("leaf key=%v value=%x binnum=%d level=%d valueLen=%d", bin.Key, bin.Value, binnum, bin.Level, len(bytes.Trim(convertToByte(bin.Value), "\x00"))))
 			} else {
-				fmt.Printf("node key = %v Value = %x binnum = %d level = %d\n", bin.Key, bin.Value, binnum, bin.Level)
-				bin.print(u, swarmdb, columnType)
+				lines = append(lines, fmt.Sprintf("node key=%v value=%x binnum=%d level=%d", bin.Key, bin.Value, binnum, bin.Level))
+				lines = bin.print(u, swarmdb, columnType, lines)
 			}
 		}
 	}
+	return lines
 }
 
 func (self *HashDB) Seek(u *SWARMDBUser, k []byte) (OrderedDatabaseCursor, bool, error) {
@@ -63,7 +63,9 @@ func TestHashDBPutInteger(t *testing.T) {
 	}
 	// flush B+tree in memory to SWARM
 	r.FlushBuffer(u)
-	r.Print(u)
+	for _, line := range r.Print(u) {
+		t.Log(line)
+	}
 
 	hashid = r.GetRootHash()
 	s, _ := wolkdb.NewHashDB(u, hashid, swarmdb, sdbc.CT_INTEGER, HASHDB_ENCRYPTED)
@@ -0,0 +1,233 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"io"
+	"strings"
+)
+
+// The planner estimates how many rows a WHERE clause selects from equi-depth histograms of the keys of every ordered
+// (IT_BPLUSTREE) index: HISTOGRAM_BUCKETS buckets holding about as many keys each, bounded by the largest key they
+// hold.  A flush rebuilds the histograms once the writes since they were built exceed 1/HISTOGRAM_STALE_FRACTION of
+// the rows, walking the keys of the indexes (no rows are read), so that rebuilding costs a constant number of key
+// reads per write.  A secondary index keeps one entry per distinct value, so the histogram of a secondary column
+// counts its distinct values and an equality matches rows/distinct rows of the table.  Histograms are kept in
+// memory only: a table opened from ENS has none until its first flush, and the planner then estimates nothing.
+const (
+	HISTOGRAM_BUCKETS        = 16
+	HISTOGRAM_STALE_FRACTION = 10
+)
+
+// HistogramBucket holds the keys above the Upper key of the bucket before it, up to and including Upper
+type HistogramBucket struct {
+	Upper []byte
+	Count int
+}
+
+// Histogram is the equi-depth histogram of the keys of an index
+type Histogram struct {
+	Column     string
+	columnType sdbc.ColumnType
+	Keys       int    // distinct keys in the index
+	Lower      []byte // smallest key
+	Buckets    []HistogramBucket
+}
+
+// buildHistogram walks the keys of an ordered index in ascending order
+func buildHistogram(u *SWARMDBUser, c *ColumnInfo) (h *Histogram, err error) {
+	h = &Histogram{Column: c.columnName, columnType: c.columnType}
+	index, ok := c.dbaccess.(OrderedDatabase)
+	if !ok {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[histogram:buildHistogram] column [%s] is not ordered", c.columnName), ErrorCode: 431, ErrorMessage: fmt.Sprintf("Scans on Column [%s] not unsupported due to indextype", c.columnName)}
+	}
+	cursor, err := index.SeekFirst(u)
+	if err == io.EOF {
+		return h, nil
+	} else if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[histogram:buildHistogram] SeekFirst %s", err.Error()))
+	}
+	var keys [][]byte
+	for {
+		k, _, err := cursor.Next(u)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[histogram:buildHistogram] Next %s", err.Error()))
+		}
+		keys = append(keys, k)
+	}
+	h.Keys = len(keys)
+	if h.Keys == 0 {
+		return h, nil
+	}
+	h.Lower = keys[0]
+	buckets := HISTOGRAM_BUCKETS
+	if buckets > h.Keys {
+		buckets = h.Keys
+	}
+	start := 0
+	for i := 1; i <= buckets; i++ {
+		end := i * h.Keys / buckets
+		h.Buckets = append(h.Buckets, HistogramBucket{Upper: keys[end-1], Count: end - start})
+		start = end
+	}
+	return h, nil
+}
+
+// below estimates the fraction of the keys less than k, or equal to k when inclusive, assuming the keys of the bucket
+// k falls in are spread evenly over it
+func (h *Histogram) below(k []byte, inclusive bool) float64 {
+	if h.Keys == 0 {
+		return 0
+	}
+	cmp := keyComparator(h.columnType)
+	k = padKey(k)
+	if c := cmp(k, h.Lower); c < 0 || (c == 0 && !inclusive) {
+		return 0
+	}
+	n := 0
+	for _, b := range h.Buckets {
+		c := cmp(k, b.Upper)
+		if c > 0 || (c == 0 && inclusive) {
+			n += b.Count
+			continue
+		}
+		if c < 0 {
+			n += b.Count / 2
+		} else {
+			n += b.Count - 1
+		}
+		break
+	}
+	return float64(n) / float64(h.Keys)
+}
+
+// selectivity estimates the fraction of the keys "column operator k" matches; ok is false for operators a histogram
+// says nothing about
+func (h *Histogram) selectivity(operator string, k []byte) (fraction float64, ok bool) {
+	switch operator {
+	case "=":
+		cmp, k := keyComparator(h.columnType), padKey(k)
+		if h.Keys == 0 || cmp(k, h.Lower) < 0 || cmp(k, h.Buckets[len(h.Buckets)-1].Upper) > 0 {
+			return 0, true
+		}
+		return 1 / float64(h.Keys), true
+	case "<":
+		return h.below(k, false), true
+	case "<=":
+		return h.below(k, true), true
+	case ">":
+		return 1 - h.below(k, true), true
+	case ">=":
+		return 1 - h.below(k, false), true
+	}
+	return 0, false
+}
+
+// Row is the histogram as {"column", "keys", "buckets"}, with an {"upper", "count"} row per bucket
+func (h *Histogram) Row() (row sdbc.Row) {
+	row = sdbc.NewRow()
+	row["column"] = h.Column
+	row["keys"] = h.Keys
+	buckets := make([]sdbc.Row, 0, len(h.Buckets))
+	for _, b := range h.Buckets {
+		r := sdbc.NewRow()
+		r["upper"] = strings.TrimRight(KeyToString(h.columnType, b.Upper), "\x00")
+		r["count"] = b.Count
+		buckets = append(buckets, r)
+	}
+	row["buckets"] = buckets
+	return row
+}
+
+// Histogram returns the histogram of the index of columnName as of the last flush that rebuilt it
+func (t *Table) Histogram(columnName string) (h *Histogram, ok bool) {
+	t.histogramsMu.RLock()
+	defer t.histogramsMu.RUnlock()
+	h, ok = t.histograms[columnName]
+	return h, ok
+}
+
+// noteHistogramWrite counts a write against the histograms
+func (t *Table) noteHistogramWrite() {
+	t.histogramsMu.Lock()
+	t.histogramWrites++
+	t.histogramsMu.Unlock()
+}
+
+// refreshHistograms rebuilds the histograms of t after a flush when they are missing or stale; a failure keeps the
+// histograms there were, as they only guide the planner
+func (t *Table) refreshHistograms(u *SWARMDBUser) {
+	t.histogramsMu.RLock()
+	rows := 0
+	if h, ok := t.histograms[t.primaryColumnName]; ok {
+		rows = h.Keys
+	}
+	stale := t.histograms == nil || t.histogramWrites*HISTOGRAM_STALE_FRACTION > rows
+	t.histogramsMu.RUnlock()
+	if !stale {
+		return
+	}
+	histograms := make(map[string]*Histogram)
+	for name, c := range t.columns {
+		if c.indexType != sdbc.IT_BPLUSTREE || c.encrypted {
+			continue
+		}
+		h, err := buildHistogram(u, c)
+		if err != nil {
+			log.Debug(fmt.Sprintf("[histogram:refreshHistograms] %s buildHistogram %s", name, err.Error()), "trace", u.TraceID(), "table", t.tableName)
+			return
+		}
+		histograms[name] = h
+	}
+	t.histogramsMu.Lock()
+	t.histograms, t.histogramWrites = histograms, 0
+	t.histogramsMu.Unlock()
+}
+
+// estimateRows estimates how many rows of t the WHERE clause selects, taking the predicates of a conjunction as
+// independent; ok is false without a histogram of every column filtered, and of the primary key to count the rows
+func (t *Table) estimateRows(where Where) (rows int, ok bool) {
+	primary, found := t.Histogram(t.primaryColumnName)
+	if !found {
+		return 0, false
+	}
+	estimate := float64(primary.Keys)
+	for _, p := range where.Predicates() {
+		c, found := t.columns[p.Left]
+		if !found {
+			return 0, false
+		}
+		h, found := t.Histogram(p.Left)
+		if !found {
+			return 0, false
+		}
+		k, err := convertJSONValueToKey(c.columnType, p.Right)
+		if err != nil {
+			return 0, false
+		}
+		fraction, found := h.selectivity(p.Operator, k)
+		if !found {
+			return 0, false
+		}
+		estimate *= fraction
+	}
+	return int(estimate + 0.5), true
+}
@@ -0,0 +1,347 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPServer exposes table operations over a REST interface so web applications can use SWARMDB without the TCP client:
+//
+//	GET|PUT|DELETE /owner/{id}/table/{name}/row/{key}?database={db}
+//	POST           /query  (body: {"owner":..., "database":..., "query":"select ..."})
+//	GET|POST       /graphql/{owner}/{database}  (GET: the schema, POST body: {"query":"{ ... }", "variables":{...}})
+//	GET            /challenge  (returns {"challenge":..., "expiresMs":...})
+//
+// Every request is translated into a RequestOption and passed through SelectHandler, so the semantics match the TCP server.
+// Requests authenticate as described in auth.go: an X-Swarmdb-Challenge header names a challenge returned by
+// /challenge and X-Swarmdb-Signature carries its signature, or X-Swarmdb-Capability a capability token; each request
+// runs as the user they verify, and a challenge authenticates one request only.
+// Responses use the swarmdbwire.Response envelope; an X-Request-Id header is echoed as its requestId, and an
+// X-Trace-Id header (generated when absent) is echoed and tags the server log lines of the request.
+const (
+	HTTP_HEADER_CHALLENGE  = "X-Swarmdb-Challenge"
+	HTTP_HEADER_SIGNATURE  = "X-Swarmdb-Signature"
+	HTTP_HEADER_CAPABILITY = "X-Swarmdb-Capability"
+)
+
+type HTTPServer struct {
+	swarmdb *SwarmDB
+	config  *SWARMDBConfig
+	auth    *Authenticator
+	limiter *RateLimiter
+	server  *http.Server
+
+	idempotency *IdempotencyCache // results of writes sent with an Idempotency-Key header or a unique X-Request-Id
+}
+
+func NewHTTPServer(swarmdb *SwarmDB, config *SWARMDBConfig) *HTTPServer {
+	return &HTTPServer{swarmdb: swarmdb, config: config, auth: NewAuthenticator(swarmdb, config), limiter: NewRateLimiter(config), idempotency: NewIdempotencyCache(config.GetIdempotencyTTL())}
+}
+
+func (self *HTTPServer) ListenAndServe() (err error) {
+	addr := fmt.Sprintf("%s:%d", self.config.ListenAddrHTTP, self.config.PortHTTP)
+	listener, err := self.config.Listen(addr)
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[httpserver:ListenAndServe] Listen %s", err.Error()), ErrorCode: 483, ErrorMessage: "Unable to start HTTP server"}
+	}
+	log.Debug(fmt.Sprintf("[httpserver:ListenAndServe] listening on %s", addr))
+	self.server = &http.Server{Handler: self}
+	if self.config.RequestTimeout > 0 {
+		self.server.ReadTimeout = time.Duration(self.config.RequestTimeout) * time.Second
+		self.server.WriteTimeout = time.Duration(self.config.RequestTimeout) * time.Second
+	}
+	if self.config.IdleTimeout > 0 {
+		self.server.IdleTimeout = time.Duration(self.config.IdleTimeout) * time.Second
+	}
+	err = self.server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting requests and waits for in-flight requests until ctx is done
+func (self *HTTPServer) Shutdown(ctx context.Context) (err error) {
+	if self.server == nil {
+		return nil
+	}
+	return self.server.Shutdown(ctx)
+}
+
+func (self *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the request id is echoed in the header and in the response envelope
+	if requestID := r.Header.Get("X-Request-Id"); len(requestID) > 0 {
+		w.Header().Set("X-Request-Id", requestID)
+	}
+	// as is the idempotency key: a write retried with the same key, or the same unique request id, returns the first
+	// result with X-Replayed set instead of being applied again
+	if key := r.Header.Get("Idempotency-Key"); len(key) > 0 {
+		w.Header().Set("Idempotency-Key", key)
+	}
+	traceID := r.Header.Get("X-Trace-Id")
+	if len(traceID) == 0 {
+		traceID = NewTraceID()
+	}
+	w.Header().Set("X-Trace-Id", traceID)
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "challenge" {
+		self.handleChallenge(w)
+		return
+	}
+	if path == "query" {
+		self.handleQuery(traceID, w, r)
+		return
+	}
+	if strings.HasPrefix(path, "graphql/") {
+		self.handleGraphQL(traceID, w, r, path)
+		return
+	}
+	owner, table, key, err := parseRowPath(path)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	database := r.URL.Query().Get("database")
+
+	switch r.Method {
+	case http.MethodGet:
+		self.handleRequest(traceID, w, r, &sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: table, Key: key})
+	case http.MethodDelete:
+		self.handleRequest(traceID, w, r, &sdbc.RequestOption{RequestType: sdbc.RT_DELETE, Owner: owner, Database: database, Table: table, Key: key})
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeHTTPError(w, &sdbc.SWARMDBError{Message: fmt.Sprintf("[httpserver:ServeHTTP] ReadAll %s", err.Error()), ErrorCode: 432, ErrorMessage: "Unable to Parse Request"})
+			return
+		}
+		row := sdbc.NewRow()
+		if err := json.Unmarshal(body, &row); err != nil {
+			writeHTTPError(w, &sdbc.SWARMDBError{Message: fmt.Sprintf("[httpserver:ServeHTTP] Unmarshal %s", err.Error()), ErrorCode: 435, ErrorMessage: "Invalid Row Data"})
+			return
+		}
+		d := &sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: table, Rows: []sdbc.Row{row}}
+		u, err := self.authenticate(traceID, r, d)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		tbl, err := self.swarmdb.GetTable(u, d.Owner, d.Database, d.Table)
+		if err != nil {
+			writeHTTPError(w, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[httpserver:ServeHTTP] GetTable %s", err.Error())))
+			return
+		}
+		// the key in the URL is authoritative for the primary column
+		row[tbl.primaryColumnName] = key
+		self.serveRequest(u, w, d)
+	default:
+		writeHTTPError(w, &sdbc.SWARMDBError{Message: fmt.Sprintf("[httpserver:ServeHTTP] Method [%s] not allowed", r.Method), ErrorCode: 418, ErrorMessage: "Request Invalid"})
+	}
+}
+
+// handleChallenge issues a challenge for the X-Swarmdb-Challenge and X-Swarmdb-Signature headers of a later request
+func (self *HTTPServer) handleChallenge(w http.ResponseWriter) {
+	challenge, expires, err := self.auth.Challenge()
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTPJSON(w, http.StatusOK, map[string]interface{}{"challenge": challenge, "expiresMs": expires.UnixNano() / int64(time.Millisecond)})
+}
+
+// authenticate returns the user the request d runs as, from the credentials in the headers of r
+func (self *HTTPServer) authenticate(traceID string, r *http.Request, d *sdbc.RequestOption) (u *SWARMDBUser, err error) {
+	creds := Credentials{Challenge: r.Header.Get(HTTP_HEADER_CHALLENGE), Signature: r.Header.Get(HTTP_HEADER_SIGNATURE), Capability: r.Header.Get(HTTP_HEADER_CAPABILITY)}
+	if u, err = self.auth.Authenticate(creds, d); err != nil {
+		return nil, err
+	}
+	return u.WithTrace(traceID), nil
+}
+
+func (self *HTTPServer) handleQuery(traceID string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeHTTPError(w, &sdbc.SWARMDBError{Message: fmt.Sprintf("[httpserver:handleQuery] Method [%s] not allowed", r.Method), ErrorCode: 418, ErrorMessage: "Request Invalid"})
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeHTTPError(w, &sdbc.SWARMDBError{Message: fmt.Sprintf("[httpserver:handleQuery] ReadAll %s", err.Error()), ErrorCode: 432, ErrorMessage: "Unable to Parse Request"})
+		return
+	}
+	d, err := parseData(string(body))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	d.RequestType = sdbc.RT_QUERY
+	self.handleRequest(traceID, w, r, d)
+}
+
+// handleGraphQL returns the GraphQL schema of a database on GET and runs a query against it on POST, see graphql.go
+func (self *HTTPServer) handleGraphQL(traceID string, w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 {
+		writeHTTPError(w, &sdbc.SWARMDBError{Message: fmt.Sprintf("[httpserver:handleGraphQL] Invalid path [%s]", path), ErrorCode: 418, ErrorMessage: "Request Invalid: expected /graphql/{owner}/{database}"})
+		return
+	}
+	owner, database := parts[1], parts[2]
+	// capabilities are scoped to the request types of the TCP protocol, so GraphQL takes signed challenges only
+	u, err := self.authenticate(traceID, r, &sdbc.RequestOption{RequestType: "GraphQL", Owner: owner, Database: database})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		sdl, err := self.swarmdb.GraphQLSchema(u, owner, database)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(sdl))
+	case http.MethodPost:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeHTTPError(w, &sdbc.SWARMDBError{Message: fmt.Sprintf("[httpserver:handleGraphQL] ReadAll %s", err.Error()), ErrorCode: 432, ErrorMessage: "Unable to Parse Request"})
+			return
+		}
+		var req GraphQLRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeHTTPError(w, &sdbc.SWARMDBError{Message: fmt.Sprintf("[httpserver:handleGraphQL] Unmarshal %s", err.Error()), ErrorCode: 432, ErrorMessage: "Unable to Parse Request"})
+			return
+		}
+		release, err := self.limiter.Admit(nil, u, len(body), true)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		defer release()
+		resp, err := self.swarmdb.GraphQL(u, owner, database, &req)
+		if err != nil {
+			// a query that cannot be parsed has errors but no data
+			writeHTTPJSON(w, http.StatusBadRequest, GraphQLResponse{Errors: []GraphQLError{{Message: graphQLMessage(err)}}})
+			return
+		}
+		writeHTTPJSON(w, http.StatusOK, resp)
+	default:
+		writeHTTPError(w, &sdbc.SWARMDBError{Message: fmt.Sprintf("[httpserver:handleGraphQL] Method [%s] not allowed", r.Method), ErrorCode: 418, ErrorMessage: "Request Invalid"})
+	}
+}
+
+func (self *HTTPServer) handleRequest(traceID string, w http.ResponseWriter, r *http.Request, req *sdbc.RequestOption) {
+	u, err := self.authenticate(traceID, r, req)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	self.serveRequest(u, w, req)
+}
+
+// serveRequest runs the authenticated request req as u
+func (self *HTTPServer) serveRequest(u *SWARMDBUser, w http.ResponseWriter, req *sdbc.RequestOption) {
+	release, err := self.limiter.Admit(nil, u, requestSize(req), isQueryRequest(req.RequestType))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	defer release()
+	var resp sdbc.SWARMDBResponse
+	replayed := false
+	if key := idempotencyKeyOf(req, w.Header().Get("Idempotency-Key"), w.Header().Get("X-Request-Id")); len(key) > 0 {
+		resp, replayed, err = self.idempotency.Do(req.Owner, key, func() (sdbc.SWARMDBResponse, error) {
+			return self.swarmdb.HandleRequest(u, req)
+		})
+	} else {
+		resp, err = self.swarmdb.HandleRequest(u, req)
+	}
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if req.RequestType == sdbc.RT_GET && resp.MatchedRowCount == 0 {
+		status = http.StatusNotFound
+	}
+	out := wire.NewResponse(w.Header().Get("X-Request-Id"), resp)
+	if replayed {
+		w.Header().Set("X-Replayed", "true")
+		out.Replayed = true
+	}
+	writeHTTPJSON(w, status, out)
+}
+
+func requestSize(req *sdbc.RequestOption) int {
+	out, _ := json.Marshal(req)
+	return len(out)
+}
+
+// parseRowPath splits "owner/{id}/table/{name}/row/{key}" into its components
+func parseRowPath(path string) (owner string, table string, key string, err error) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 6 || parts[0] != "owner" || parts[2] != "table" || parts[4] != "row" {
+		return owner, table, key, &sdbc.SWARMDBError{Message: fmt.Sprintf("[httpserver:parseRowPath] Invalid path [%s]", path), ErrorCode: 418, ErrorMessage: "Request Invalid: expected /owner/{id}/table/{name}/row/{key}"}
+	}
+	return parts[1], parts[3], parts[5], nil
+}
+
+func writeHTTPJSON(w http.ResponseWriter, status int, v interface{}) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		log.Debug(fmt.Sprintf("[httpserver:writeHTTPJSON] Marshal %s", err.Error()))
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// httpStatus maps the envelope error codes onto HTTP status codes
+var httpStatus = map[wire.ErrorCode]int{
+	wire.ErrNoSuchTable:    http.StatusNotFound,
+	wire.ErrNoSuchDatabase: http.StatusNotFound,
+	wire.ErrNoSuchColumn:   http.StatusBadRequest,
+	wire.ErrDuplicateKey:   http.StatusConflict,
+	wire.ErrBadRequest:     http.StatusBadRequest,
+	wire.ErrUnauthorized:   http.StatusUnauthorized,
+	wire.ErrAccessDenied:   http.StatusForbidden,
+	wire.ErrThrottled:      http.StatusTooManyRequests,
+	wire.ErrTimeout:        http.StatusGatewayTimeout,
+	wire.ErrUnavailable:    http.StatusServiceUnavailable,
+	wire.ErrBudgetExceeded: http.StatusUnprocessableEntity,
+	wire.ErrTooLarge:       http.StatusRequestEntityTooLarge,
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	if tErr, ok := err.(*ThrottledError); ok {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(tErr.RetryAfter.Seconds())+1))
+	}
+	resp := newErrorResponse(w.Header().Get("X-Request-Id"), err)
+	status, ok := httpStatus[resp.ErrorCode]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	writeHTTPJSON(w, status, resp)
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/crypto"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	sdb "swarmdb"
+	"testing"
+)
+
+// signedTransport sends every request with a fresh challenge of the server signed with the node key
+type signedTransport struct {
+	t   *testing.T
+	url string
+}
+
+func (s *signedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.Get(s.url + "/challenge")
+	if err != nil {
+		return nil, err
+	}
+	var challenge struct {
+		Challenge string `json:"challenge"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&challenge)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	secretKey, err := crypto.HexToECDSA(strings.TrimPrefix(config.PrivateKey, "0x"))
+	if err != nil {
+		s.t.Fatalf("[httpserver_test:RoundTrip] HexToECDSA %s", err)
+	}
+	sig, err := crypto.Sign(sdb.SignHash([]byte(challenge.Challenge)), secretKey)
+	if err != nil {
+		s.t.Fatalf("[httpserver_test:RoundTrip] Sign %s", err)
+	}
+	req.Header.Set(sdb.HTTP_HEADER_CHALLENGE, challenge.Challenge)
+	req.Header.Set(sdb.HTTP_HEADER_SIGNATURE, fmt.Sprintf("%x", sig))
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// signedClient returns a client of the HTTP server at url authenticated as the node
+func signedClient(t *testing.T, url string) *http.Client {
+	return &http.Client{Transport: &signedTransport{t: t, url: url}}
+}
+
+func TestHTTPServer(t *testing.T) {
+	owner, database, tableName := make_table(t, "http")
+	srv := httptest.NewServer(sdb.NewHTTPServer(swarmdb, config))
+	defer srv.Close()
+	client := signedClient(t, srv.URL)
+
+	rowURL := fmt.Sprintf("%s/owner/%s/table/%s/row/%s?database=%s", srv.URL, owner, tableName, "rodney@wolk.com", database)
+
+	// PUT
+	req, _ := http.NewRequest(http.MethodPut, rowURL, bytes.NewBufferString(`{"name":"Rodney","age":38}`))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("[httpserver_test:TestHTTPServer] PUT %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("[httpserver_test:TestHTTPServer] PUT status %d", resp.StatusCode)
+	}
+
+	if len(resp.Header.Get("X-Trace-Id")) == 0 {
+		t.Fatalf("[httpserver_test:TestHTTPServer] PUT response has no X-Trace-Id")
+	}
+
+	// GET, following a client chosen trace id
+	req, _ = http.NewRequest(http.MethodGet, rowURL, nil)
+	req.Header.Set("X-Trace-Id", "httptrace")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("[httpserver_test:TestHTTPServer] GET %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("X-Trace-Id") != "httptrace" {
+		t.Fatalf("[httpserver_test:TestHTTPServer] GET X-Trace-Id %s", resp.Header.Get("X-Trace-Id"))
+	}
+	var res sdbc.SWARMDBResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatalf("[httpserver_test:TestHTTPServer] GET Unmarshal %s [%s]", err, body)
+	}
+	if len(res.Data) != 1 || res.Data[0]["name"] != "Rodney" {
+		t.Fatalf("[httpserver_test:TestHTTPServer] GET returned %s", body)
+	}
+	fmt.Printf("GET Output: %s\n", body)
+
+	// POST /query
+	q, _ := json.Marshal(&sdbc.RequestOption{Owner: owner, Database: database, Table: tableName, RawQuery: fmt.Sprintf("select email, name from %s where age >= 30", tableName)})
+	resp, err = client.Post(srv.URL+"/query", "application/json", bytes.NewBuffer(q))
+	if err != nil {
+		t.Fatalf("[httpserver_test:TestHTTPServer] POST /query %s", err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("[httpserver_test:TestHTTPServer] POST /query status %d [%s]", resp.StatusCode, body)
+	}
+	fmt.Printf("QUERY Output: %s\n", body)
+
+	// DELETE then GET ==> 404
+	req, _ = http.NewRequest(http.MethodDelete, rowURL, nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("[httpserver_test:TestHTTPServer] DELETE %s", err)
+	}
+	resp.Body.Close()
+	resp, err = client.Get(rowURL)
+	if err != nil {
+		t.Fatalf("[httpserver_test:TestHTTPServer] GET %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("[httpserver_test:TestHTTPServer] GET after DELETE status %d", resp.StatusCode)
+	}
+
+	// bad path
+	resp, err = client.Get(srv.URL + "/owner/" + owner)
+	if err != nil {
+		t.Fatalf("[httpserver_test:TestHTTPServer] GET %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("[httpserver_test:TestHTTPServer] bad path status %d", resp.StatusCode)
+	}
+}
+
+func TestHTTPServerAuthentication(t *testing.T) {
+	owner, database, tableName := make_table(t, "httpauth")
+	authConfig := *config
+	authConfig.Authentication = 1
+	srv := httptest.NewServer(sdb.NewHTTPServer(swarmdb, &authConfig))
+	defer srv.Close()
+
+	rowURL := fmt.Sprintf("%s/owner/%s/table/%s/row/%s?database=%s", srv.URL, owner, tableName, "auth@wolk.com", database)
+	put := func(client *http.Client, header map[string]string) int {
+		req, _ := http.NewRequest(http.MethodPut, rowURL, bytes.NewBufferString(`{"name":"Auth","age":5}`))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("[httpserver_test:TestHTTPServerAuthentication] PUT %s", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// requests without credentials, or with a challenge the server did not issue, are refused
+	if status := put(http.DefaultClient, nil); status != http.StatusUnauthorized {
+		t.Fatalf("[httpserver_test:TestHTTPServerAuthentication] PUT without credentials status %d", status)
+	}
+	if status := put(http.DefaultClient, map[string]string{sdb.HTTP_HEADER_CHALLENGE: "00", sdb.HTTP_HEADER_SIGNATURE: "00"}); status != http.StatusUnauthorized {
+		t.Fatalf("[httpserver_test:TestHTTPServerAuthentication] PUT with unknown challenge status %d", status)
+	}
+	// a signed challenge runs the request as the signer
+	if status := put(signedClient(t, srv.URL), nil); status != http.StatusOK {
+		t.Fatalf("[httpserver_test:TestHTTPServerAuthentication] PUT with signed challenge status %d", status)
+	}
+}
+
+func TestHTTPServerRequestDedup(t *testing.T) {
+	owner, database, tableName := make_table(t, "httpdedup")
+	srv := httptest.NewServer(sdb.NewHTTPServer(swarmdb, config))
+	defer srv.Close()
+	client := signedClient(t, srv.URL)
+
+	q, _ := json.Marshal(&sdbc.RequestOption{Owner: owner, Database: database, Table: tableName, RawQuery: fmt.Sprintf("insert into %s (email, name, age) values ('dedup@wolk.com', 'Dedo', 4)", tableName)})
+	insert := func(requestID string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/query", bytes.NewBuffer(q))
+		req.Header.Set("X-Request-Id", requestID)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("[httpserver_test:TestHTTPServerRequestDedup] POST /query %s", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	// a retry with the same unique request id returns the first result instead of failing on the duplicate key
+	for i := 0; i < 2; i++ {
+		resp := insert("4f9b2c61-7d0e-4a8f-9c3b-5e1d2a7f8b90")
+		if resp.StatusCode != http.StatusOK || (i == 1) != (resp.Header.Get("X-Replayed") == "true") {
+			t.Fatalf("[httpserver_test:TestHTTPServerRequestDedup] insert %d status %d replayed [%s]", i, resp.StatusCode, resp.Header.Get("X-Replayed"))
+		}
+	}
+	if resp := insert("0c8e5a13-2b6f-4d97-8e1a-3f4b5c6d7e8f"); resp.StatusCode == http.StatusOK {
+		t.Fatalf("[httpserver_test:TestHTTPServerRequestDedup] insert with a new request id did not fail on the duplicate key")
+	}
+}
+
+func TestGraphQL(t *testing.T) {
+	owner, database, tableName := make_table(t, "gql")
+	srv := httptest.NewServer(sdb.NewHTTPServer(swarmdb, config))
+	defer srv.Close()
+	client := signedClient(t, srv.URL)
+
+	rows := []sdbc.Row{
+		sdbc.Row{"email": "rodney@wolk.com", "name": "Rodney", "age": 38},
+		sdbc.Row{"email": "sourabh@wolk.com", "name": "Sourabh", "age": 45},
+	}
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: rows}); err != nil {
+		t.Fatalf("[httpserver_test:TestGraphQL] PUT %s", err)
+	}
+	graphqlURL := fmt.Sprintf("%s/graphql/%s/%s", srv.URL, owner, database)
+
+	// the schema has a field per table, with the indexed columns as arguments
+	resp, err := client.Get(graphqlURL)
+	if err != nil {
+		t.Fatalf("[httpserver_test:TestGraphQL] GET %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if field := fmt.Sprintf("%s(email: String, age: Int, name: String, limit: Int): [%s!]!", tableName, tableName); !bytes.Contains(body, []byte(field)) {
+		t.Fatalf("[httpserver_test:TestGraphQL] schema has no [%s]: %s", field, body)
+	}
+	if !bytes.Contains(body, []byte("  email: String!\n  age: Int\n  name: String\n")) {
+		t.Fatalf("[httpserver_test:TestGraphQL] schema fields: %s", body)
+	}
+
+	post := func(query string, variables map[string]interface{}) (status int, res sdb.GraphQLResponse) {
+		q, _ := json.Marshal(&sdb.GraphQLRequest{Query: query, Variables: variables})
+		resp, err := client.Post(graphqlURL, "application/json", bytes.NewBuffer(q))
+		if err != nil {
+			t.Fatalf("[httpserver_test:TestGraphQL] POST %s", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatalf("[httpserver_test:TestGraphQL] Unmarshal %s [%s]", err, body)
+		}
+		return resp.StatusCode, res
+	}
+
+	// a secondary index argument from a variable, a primary key argument and an alias
+	query := fmt.Sprintf(`query People($age: Int) {
+		older: %s(age: $age) { email name __typename }
+		one: %s(email: "rodney@wolk.com") { age }
+		all: %s(limit: 1) { email }
+	}`, tableName, tableName, tableName)
+	status, res := post(query, map[string]interface{}{"age": 45})
+	if status != http.StatusOK || len(res.Errors) > 0 {
+		t.Fatalf("[httpserver_test:TestGraphQL] query status %d errors %v", status, res.Errors)
+	}
+	out, _ := json.Marshal(res.Data)
+	var data map[string][]map[string]interface{}
+	json.Unmarshal(out, &data)
+	older := data["older"]
+	if len(older) != 1 || older[0]["name"] != "Sourabh" || older[0]["__typename"] != tableName || len(older[0]) != 3 {
+		t.Fatalf("[httpserver_test:TestGraphQL] older: %s", out)
+	}
+	if one := data["one"]; len(one) != 1 || one[0]["age"] != float64(38) {
+		t.Fatalf("[httpserver_test:TestGraphQL] one: %s", out)
+	}
+	if len(data["all"]) != 1 {
+		t.Fatalf("[httpserver_test:TestGraphQL] all: %s", out)
+	}
+	fmt.Printf("GRAPHQL Output: %s\n", out)
+
+	// an unknown field fails that field only
+	status, res = post(fmt.Sprintf("{ bad: %s { salary } good: %s { email } }", tableName, tableName), nil)
+	if status != http.StatusOK || len(res.Errors) != 1 || res.Data["bad"] != nil || res.Data["good"] == nil {
+		t.Fatalf("[httpserver_test:TestGraphQL] unknown field status %d data %v errors %v", status, res.Data, res.Errors)
+	}
+
+	// a syntax error fails the query
+	if status, res = post(fmt.Sprintf("{ %s { email ", tableName), nil); status != http.StatusBadRequest || len(res.Errors) != 1 || res.Data != nil {
+		t.Fatalf("[httpserver_test:TestGraphQL] syntax error status %d data %v errors %v", status, res.Data, res.Errors)
+	}
+	if status, _ = post(fmt.Sprintf("mutation { %s { email } }", tableName), nil); status != http.StatusBadRequest {
+		t.Fatalf("[httpserver_test:TestGraphQL] mutation status %d", status)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	IDEMPOTENCY_MAX_KEYS = 100000
+
+	// IDEMPOTENCY_MIN_REQUEST_ID is the length from which a request ID is taken to be unique per owner, as a UUID
+	// is; a write carrying one is deduplicated as if it carried an idempotency key.  Shorter IDs, like the
+	// per-connection counters of swarmdblib, are not.
+	IDEMPOTENCY_MIN_REQUEST_ID = 16
+)
+
+// IdempotencyCache remembers the results of writes sent with an idempotency key, so that a client retrying a write
+// whose response it never received gets the original result back instead of the write being applied twice.
+type IdempotencyCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	results map[string]*idempotentResult
+	order   []string // keys in insertion order, oldest first
+}
+
+type idempotentResult struct {
+	done    chan struct{} // closed once resp and err are set
+	resp    sdbc.SWARMDBResponse
+	err     error
+	expires time.Time
+}
+
+func NewIdempotencyCache(ttl time.Duration) *IdempotencyCache {
+	return &IdempotencyCache{ttl: ttl, results: make(map[string]*idempotentResult)}
+}
+
+// Do runs fn once per owner and key within the TTL; concurrent and later calls with the same key wait for and
+// return the first result, with replayed set.  Failed writes are forgotten, so that they can be retried.
+func (self *IdempotencyCache) Do(owner string, key string, fn func() (sdbc.SWARMDBResponse, error)) (resp sdbc.SWARMDBResponse, replayed bool, err error) {
+	id := owner + "|" + key
+	now := time.Now()
+	self.mu.Lock()
+	self.expire(now)
+	if r, ok := self.results[id]; ok {
+		self.mu.Unlock()
+		<-r.done
+		return r.resp, true, r.err
+	}
+	r := &idempotentResult{done: make(chan struct{}), expires: now.Add(self.ttl)}
+	self.results[id] = r
+	self.order = append(self.order, id)
+	self.mu.Unlock()
+
+	r.resp, r.err = fn()
+	close(r.done)
+	if r.err != nil {
+		self.mu.Lock()
+		if self.results[id] == r {
+			delete(self.results, id)
+		}
+		self.mu.Unlock()
+	}
+	return r.resp, false, r.err
+}
+
+// expire drops the results past their TTL and the oldest ones beyond IDEMPOTENCY_MAX_KEYS
+func (self *IdempotencyCache) expire(now time.Time) {
+	n := 0
+	for ; n < len(self.order); n++ {
+		r, ok := self.results[self.order[n]]
+		if ok && now.Before(r.expires) && len(self.order)-n <= IDEMPOTENCY_MAX_KEYS {
+			break
+		}
+		if ok {
+			delete(self.results, self.order[n])
+		}
+	}
+	self.order = self.order[n:]
+}
+
+// idempotencyKeyOf returns the key deduplicating d: the explicit idempotency key, or for a write the request ID when
+// it is long enough to be unique; "" when d is not deduplicated
+func idempotencyKeyOf(d *sdbc.RequestOption, idempotencyKey string, requestID string) string {
+	if len(idempotencyKey) > 0 {
+		return idempotencyKey
+	}
+	if len(requestID) >= IDEMPOTENCY_MIN_REQUEST_ID && isDedupWrite(d) {
+		return "request:" + requestID
+	}
+	return ""
+}
+
+// isDedupWrite reports whether d changes the table: Put, Delete and Increment, and queries other than SELECT
+func isDedupWrite(d *sdbc.RequestOption) bool {
+	if d.RequestType == sdbc.RT_QUERY {
+		fields := strings.Fields(d.RawQuery)
+		return len(fields) > 0 && strings.ToUpper(fields[0]) != "SELECT"
+	}
+	return isWriteRequest(d.RequestType)
+}
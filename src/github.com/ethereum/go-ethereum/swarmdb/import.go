@@ -0,0 +1,190 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ImportError reports a CSV record that was not imported; Record counts data records from 1, after the header
+type ImportError struct {
+	Record int
+	Error  string
+}
+
+// ImportCSV loads CSV with a header line into the table.  Each header names a column, or is renamed by mapping
+// (header to column name); headers mapped to "" are skipped, empty cells leave the column unset.  Records that
+// do not convert to the column types, or lack the primary key, are rejected with an ImportError and the rest are
+// loaded.  The rows are sorted by primary key and written under one buffer, so the B+tree is built by appending
+// to its rightmost leaves and the whole import is published with one root hash update, or not at all.
+func (t *Table) ImportCSV(u *SWARMDBUser, r io.Reader, mapping map[string]string) (imported int, rejected []ImportError, err error) {
+	if err = t.checkWritable(); err != nil {
+		return 0, nil, err
+	}
+	primary, err := t.getPrimaryColumn()
+	if err != nil {
+		return 0, nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[import:ImportCSV] getPrimaryColumn %s", err.Error()))
+	}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return 0, nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[import:ImportCSV] header %s", err.Error()), ErrorCode: 505, ErrorMessage: "Invalid CSV: missing or malformed header line"}
+	}
+	columns := make([]string, len(header))
+	hasPrimary := false
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if mapped, ok := mapping[name]; ok {
+			name = mapped
+		}
+		if len(name) == 0 {
+			continue
+		}
+		if _, ok := t.columns[name]; !ok {
+			return 0, nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[import:ImportCSV] header %s has no column", header[i]), ErrorCode: 404, ErrorMessage: fmt.Sprintf("Column Does Not Exist in table definition: [%s]", name)}
+		}
+		columns[i] = name
+		hasPrimary = hasPrimary || name == t.primaryColumnName
+	}
+	if !hasPrimary {
+		return 0, nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[import:ImportCSV] no header maps to %s", t.primaryColumnName), ErrorCode: 428, ErrorMessage: "Row missing primary key"}
+	}
+
+	type keyedRow struct {
+		key []byte
+		row sdbc.Row
+	}
+	var rows []keyedRow
+	for n := 1; ; n++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return 0, rejected, &sdbc.SWARMDBError{Message: fmt.Sprintf("[import:ImportCSV] record %d %s", n, err.Error()), ErrorCode: 505, ErrorMessage: "Invalid CSV: unable to read records"}
+			}
+			rejected = append(rejected, ImportError{Record: n, Error: err.Error()})
+			continue
+		}
+		if len(record) != len(columns) {
+			rejected = append(rejected, ImportError{Record: n, Error: fmt.Sprintf("%d fields, the header has %d", len(record), len(columns))})
+			continue
+		}
+		row := sdbc.NewRow()
+		for i, cell := range record {
+			if len(columns[i]) > 0 && len(cell) > 0 {
+				row[columns[i]] = cell
+			}
+		}
+		if _, ok := row[t.primaryColumnName]; !ok {
+			rejected = append(rejected, ImportError{Record: n, Error: fmt.Sprintf("missing primary key %s", t.primaryColumnName)})
+			continue
+		}
+		if _, err := t.assignRowColumnTypes([]sdbc.Row{row}); err != nil {
+			rejected = append(rejected, ImportError{Record: n, Error: userMessage(err)})
+			continue
+		}
+		k, err := convertJSONValueToKey(primary.columnType, row[t.primaryColumnName])
+		if err != nil {
+			rejected = append(rejected, ImportError{Record: n, Error: userMessage(err)})
+			continue
+		}
+		rows = append(rows, keyedRow{key: padKey(k), row: row})
+	}
+	cmp := keyComparator(primary.columnType)
+	sort.SliceStable(rows, func(i, j int) bool { return cmp(rows[i].key, rows[j].key) < 0 })
+
+	// a buffer the caller already holds (a transaction or batch) is left to the caller to flush
+	owned := !t.buffered
+	if owned {
+		if err = t.StartBuffer(u); err != nil {
+			return 0, rejected, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[import:ImportCSV] StartBuffer %s", err.Error()))
+		}
+		t.holdFlush = true
+	}
+	for _, kr := range rows {
+		if err = t.Put(u, kr.row); err != nil {
+			break
+		}
+	}
+	if err == nil && owned {
+		err = t.FlushBuffer(u)
+	}
+	if err != nil {
+		if owned {
+			// drop the partly written buffer; the table reopens from its last published root hash
+			t.swarmdb.UnregisterTable(t.Owner, t.Database, t.tableName)
+		}
+		return 0, rejected, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[import:ImportCSV] %s", err.Error()))
+	}
+	if owned {
+		t.buffered, t.holdFlush = false, false
+	}
+	log.Debug(fmt.Sprintf("[import:ImportCSV] %d rows imported into %s, %d rejected", len(rows), t.tableName, len(rejected)), "trace", u.TraceID())
+	return len(rows), rejected, nil
+}
+
+// userMessage is the user facing message of err
+func userMessage(err error) string {
+	var serr *sdbc.SWARMDBError
+	if errors.As(err, &serr) && len(serr.ErrorMessage) > 0 {
+		return serr.ErrorMessage
+	}
+	return err.Error()
+}
+
+// importCSV runs an RT_IMPORT_CSV request: d.RawQuery holds the CSV, optional d.Rows[0] maps headers to columns
+func (self *SwarmDB) importCSV(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[import:importCSV] GetTable %s", err.Error()))
+	}
+	if err = tbl.checkAccess(u, ACL_WRITE); err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[import:importCSV] checkAccess %s", err.Error()))
+	}
+	mapping := make(map[string]string)
+	if len(d.Rows) > 0 {
+		for header, column := range d.Rows[0] {
+			name, ok := column.(string)
+			if !ok {
+				return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[import:importCSV] mapping of %s is %v", header, column), ErrorCode: 418, ErrorMessage: "Request Invalid: ImportCSV maps headers to column names"}
+			}
+			mapping[header] = name
+		}
+	}
+	imported, rejected, err := tbl.ImportCSV(u, strings.NewReader(d.RawQuery), mapping)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[import:importCSV] ImportCSV %s", err.Error()))
+	}
+	resp.AffectedRowCount = imported
+	resp.MatchedRowCount = imported + len(rejected)
+	for _, r := range rejected {
+		row := sdbc.NewRow()
+		row["record"] = r.Record
+		row["error"] = r.Error
+		resp.Data = append(resp.Data, row)
+	}
+	return resp, nil
+}
@@ -0,0 +1,244 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"time"
+	"unicode"
+)
+
+// Chunk kinds understood by InspectChunk
+const (
+	CHUNK_DESCRIPTOR = "descriptor" // a table descriptor, the chunk the table's root hash names
+	CHUNK_BPLUS      = "bplus"      // an internal (X) or leaf (D) node of a B+tree index
+	CHUNK_HASHDB     = "hashdb"     // a bin node or leaf of a hash index
+	CHUNK_ROW        = "row"        // a row with its Kademlia header (ChunkHeader)
+	CHUNK_RAW        = "raw"        // anything else, shown as its non-zero bytes
+
+	ADMIN_INSPECT_CHUNK = "InspectChunk" // Rows[0] {"key": hex, optional "kind"}; answered with the decoded chunk
+)
+
+// InspectChunk decodes the chunk stored under key for debugging: a descriptor lists its columns and their index
+// roots, an index node its keys and children, a row its header and value.  kind is one of the CHUNK_* kinds, or
+// empty to guess it from the chunk; index nodes carry no type of their own, so a hash index node is only recognized
+// when asked for.  Keys of index nodes are shown as text when printable and in hex otherwise, the hashes they
+// point to always in hex.
+func (self *SwarmDB) InspectChunk(u *SWARMDBUser, key []byte, kind string) (info sdbc.Row, err error) {
+	data, ok, err := self.dbchunkstore.RetrieveStoredChunk(key)
+	if err != nil {
+		return info, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[inspect:InspectChunk] RetrieveStoredChunk %s", err.Error()))
+	}
+	if !ok {
+		return info, &sdbc.SWARMDBError{Message: fmt.Sprintf("[inspect:InspectChunk] %x not found", key), ErrorCode: 498, ErrorMessage: fmt.Sprintf("Chunk Not Found: [%x]", key)}
+	}
+	buf, err := self.dbchunkstore.openStoredChunk(u, data)
+	if err != nil {
+		return info, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[inspect:InspectChunk] openStoredChunk %s", err.Error()))
+	}
+	if len(kind) == 0 {
+		kind = chunkKind(buf)
+	}
+	info = sdbc.NewRow()
+	info["key"] = hex.EncodeToString(key)
+	info["kind"] = kind
+	info["size"] = len(data)
+	switch kind {
+	case CHUNK_DESCRIPTOR:
+		inspectDescriptor(buf, info)
+	case CHUNK_BPLUS:
+		inspectBPlus(buf, info)
+	case CHUNK_HASHDB:
+		inspectHashDB(buf, info)
+	case CHUNK_ROW:
+		if err = self.inspectRow(u, buf, info); err != nil {
+			return info, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[inspect:InspectChunk] %s", err.Error()))
+		}
+	case CHUNK_RAW:
+		info["bytes"] = hex.EncodeToString(bytes.TrimRight(buf, "\x00"))
+	default:
+		return info, &sdbc.SWARMDBError{Message: fmt.Sprintf("[inspect:InspectChunk] kind [%s]", kind), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: unknown chunk kind [%s]", kind)}
+	}
+	return info, nil
+}
+
+// chunkKind guesses the kind of the opened chunk buf
+func chunkKind(buf []byte) string {
+	if len(buf) < CHUNK_SIZE {
+		return CHUNK_RAW
+	}
+	if string(buf[CHUNK_START_CHUNKTYPE:CHUNK_END_CHUNKTYPE]) == "k" {
+		return CHUNK_ROW
+	}
+	if nodetype := get_chunk_nodetype(buf); nodetype == "X" || nodetype == "D" {
+		return CHUNK_BPLUS
+	}
+	for i := 2048; i < 4000 && buf[i] != 0; i = i + 64 {
+		if buf[i+26] == 1 {
+			return CHUNK_DESCRIPTOR
+		}
+	}
+	return CHUNK_RAW
+}
+
+func inspectDescriptor(buf []byte, info sdbc.Row) {
+	var columns []sdbc.Row
+	for i := 2048; i < 4000 && buf[i] != 0; i = i + 64 {
+		c := sdbc.NewRow()
+		c["name"] = string(bytes.Trim(buf[i:i+COLUMN_NAME_LENGTH_MAX], "\x00"))
+		c["primary"] = int(buf[i+26])
+		if columnType, err := ByteToColumnType(buf[i+28]); err == nil {
+			c["columnType"] = columnType
+		}
+		c["indexType"] = ByteToIndexType(buf[i+30])
+		c["encrypted"] = buf[i+COLUMN_ENCRYPTED_OFFSET] == 1
+		c["expires"] = buf[i+COLUMN_EXPIRES_OFFSET] == 1
+		switch buf[i+COLUMN_EXPIRES_OFFSET] {
+		case COLUMN_CREATED_AT:
+			c["timestamp"] = "created"
+		case COLUMN_UPDATED_AT:
+			c["timestamp"] = "updated"
+		}
+		c["roothash"] = hex.EncodeToString(buf[i+32 : i+64])
+		columns = append(columns, c)
+	}
+	info["columns"] = columns
+	info["encrypted"] = BytesToInt(buf[4000:4024])
+	if p := readFlushPolicy(buf); p.enabled() {
+		policy := sdbc.NewRow()
+		policy["mutations"] = p.MaxMutations
+		policy["bytes"] = p.MaxBytes
+		policy["seconds"] = int(p.MaxAge / time.Second)
+		info["flushPolicy"] = policy
+	}
+	if splits := readShardSplits(buf); len(splits) > 0 {
+		info["shards"] = len(splits) + 1
+	}
+}
+
+func inspectBPlus(buf []byte, info sdbc.Row) {
+	var entries []sdbc.Row
+	nodetype := get_chunk_nodetype(buf)
+	info["nodetype"] = nodetype
+	if nodetype == "X" {
+		info["childtype"] = get_chunk_childtype(buf)
+	} else {
+		info["prev"] = hex.EncodeToString(buf[CHUNK_SIZE-HASH_SIZE*2 : CHUNK_SIZE-HASH_SIZE])
+		info["next"] = hex.EncodeToString(buf[CHUNK_SIZE-HASH_SIZE : CHUNK_SIZE])
+	}
+	for _, entry := range get_chunk_entries(buf) {
+		e := sdbc.NewRow()
+		e["key"] = printableKey(entry.k)
+		e["value"] = hex.EncodeToString(entry.v)
+		entries = append(entries, e)
+	}
+	info["entries"] = entries
+}
+
+func inspectHashDB(buf []byte, info sdbc.Row) {
+	if binary.LittleEndian.Uint64(buf[0:8]) != 1 {
+		info["nodetype"] = "leaf"
+		info["value"] = hex.EncodeToString(buf[64:96])
+		info["itemkey"] = printableKey(buf[96:128])
+		return
+	}
+	info["nodetype"] = "bin"
+	info["level"] = binary.LittleEndian.Uint64(buf[9:17])
+	var bins []sdbc.Row
+	for i := 0; i < 64 && 64+32*(i+1) <= len(buf); i++ {
+		if hashid := buf[64+32*i : 64+32*(i+1)]; valid_hashid(hashid) {
+			b := sdbc.NewRow()
+			b["bin"] = i
+			b["hash"] = hex.EncodeToString(hashid)
+			bins = append(bins, b)
+		}
+	}
+	info["bins"] = bins
+}
+
+func (self *SwarmDB) inspectRow(u *SWARMDBUser, buf []byte, info sdbc.Row) (err error) {
+	header, err := ParseChunkHeader(buf)
+	if err != nil {
+		return err
+	}
+	info["owner"] = string(bytes.Trim(header.Owner, "\x00"))
+	info["database"] = string(bytes.Trim(header.Database, "\x00"))
+	info["table"] = string(bytes.Trim(header.Table, "\x00"))
+	info["rowkey"] = printableKey(header.Key)
+	info["version"] = header.Version
+	info["prevVersion"] = hex.EncodeToString(header.PrevVersion)
+	info["birthts"] = header.Birthts
+	info["lastUpdatets"] = header.LastUpdatets
+	info["updateMs"] = header.UpdateMs
+	info["writer"] = common.BytesToAddress(header.Writer).Hex()
+	info["encrypted"] = header.Encrypted
+	info["minReplication"] = header.MinReplication
+	info["maxReplication"] = header.MaxReplication
+	value := bytes.TrimRight(buf[CHUNK_START_CHUNKVAL:CHUNK_END_CHUNKVAL], "\x00")
+	if isBinaryRow(value) {
+		// binary rows name their cells by column id: the schema comes from the table, when it can be opened
+		info["format"] = "binary"
+		var names []string
+		if tbl, err := self.GetTable(u, info["owner"].(string), info["database"].(string), info["table"].(string)); err == nil {
+			names = tbl.columnOrder()
+		}
+		if j, err := rowJSON(names, value); err == nil {
+			value = j
+		}
+	}
+	row := sdbc.NewRow()
+	if err := json.Unmarshal(value, &row); err == nil {
+		info["row"] = row
+	} else {
+		info["value"] = string(value)
+	}
+	return nil
+}
+
+// printableKey shows a zero padded key as text if it is printable, in hex otherwise
+func printableKey(k []byte) string {
+	trimmed := bytes.TrimRight(k, "\x00")
+	for _, r := range string(trimmed) {
+		if !unicode.IsPrint(r) {
+			return hex.EncodeToString(k)
+		}
+	}
+	return string(trimmed)
+}
+
+// inspectChunk answers ADMIN_INSPECT_CHUNK
+func (self *SwarmDB) inspectChunk(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	if len(d.Rows) == 0 {
+		return resp, &sdbc.SWARMDBError{Message: "[inspect:inspectChunk] missing key", ErrorCode: 418, ErrorMessage: "Request Invalid: InspectChunk requires a chunk key"}
+	}
+	k, _ := d.Rows[0]["key"].(string)
+	kind, _ := d.Rows[0]["kind"].(string)
+	key, err := hex.DecodeString(k)
+	if err != nil || len(key) == 0 {
+		return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[inspect:inspectChunk] key [%s]", k), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: bad chunk key [%s]", k)}
+	}
+	info, err := self.InspectChunk(u, key, kind)
+	if err != nil {
+		return resp, err
+	}
+	return sdbc.SWARMDBResponse{Data: []sdbc.Row{info}, MatchedRowCount: 1}, nil
+}
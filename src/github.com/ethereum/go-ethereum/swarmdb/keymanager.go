@@ -26,6 +26,7 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
 	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
 	"golang.org/x/crypto/nacl/box"
 	// "os"
@@ -90,39 +91,35 @@ func NewKeyManagerWithoutConfig(filename string, passphrase string) (keymgr KeyM
 	if err != nil {
 		return keymgr, &sdbc.SWARMDBError{Message: fmt.Sprintf("[keymanager:NewKeyManagerWithoutConfig] NewAccount %s", err.Error()), ErrorCode: 453, ErrorMessage: "Error creating new account"}
 	}
-	fmt.Printf("Account: %v\n", account)
 
 	// get address of the new account
 	// address := common.HexToAddress(u.Address)
 	address := fmt.Sprintf("%x", account.Address.Bytes())
-	fmt.Printf("Address: %v\n", address)
+	log.Info(fmt.Sprintf("[keymanager:NewKeyManagerWithoutConfig] created account %s", address))
 
 	// unlocking the account using the passphrase
 	err = keymgr.keystore.Unlock(account, passphrase)
 	if err != nil {
 		return keymgr, &sdbc.SWARMDBError{Message: fmt.Sprintf("[keymanager:NewKeyManagerWithoutConfig] Unlock %s", err.Error()), ErrorCode: 454, ErrorMessage: "Error Unlocking Account"}
 	}
-	fmt.Printf("Unlocked account with passphras %s\n", passphrase)
 
 	// get the Key of the new account account from the keystore
 	_, k, err := keymgr.keystore.WgetDecryptedKey(account, passphrase)
 	if err != nil {
 		return keymgr, &sdbc.SWARMDBError{Message: fmt.Sprintf("[keymanager:NewKeyManagerWithoutConfig] WgetDecryptedKey %s", err.Error()), ErrorCode: 452, ErrorMessage: "Error Decrypting Account"}
 	}
-	fmt.Printf("Key: %v\n", k)
 
 	// make a config using the { privatekey, address, passphrase }
 	privateKey := hex.EncodeToString(crypto.FromECDSA(k.PrivateKey))
 
 	config := GenerateSampleSWARMDBConfig(privateKey, address, passphrase)
-	fmt.Printf("SWARMDBConfig: %v\n", config)
 
 	// save it!
 	err = SaveSWARMDBConfig(config, filename)
 	if err != nil {
 		return keymgr, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[keymanager:NewKeyManagerWithoutConfig] SaveSWARMDBConfig %s", err.Error()))
 	}
-	fmt.Printf("Saved config in %s:\n", filename)
+	log.Info(fmt.Sprintf("[keymanager:NewKeyManagerWithoutConfig] saved config in %s", filename))
 	return keymgr, nil
 }
 
@@ -0,0 +1,425 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"io"
+	"sync"
+)
+
+// A keyspace is a named map of byte keys to byte values of an owner, for users who do not need tables: no schema,
+// no columns, no secondary indexes, no SQL.  It is one index, a B+tree (ordered keys) or a HashDB (keys in hash
+// order), whose values point at value chunks, published through ENS like a table under the table key of the owner,
+// an empty database and the keyspace name; database names are never empty, so keyspaces and tables do not collide.
+// The published chunk is a small descriptor:
+//
+//	[0:4] KEYSPACE_MAGIC, [4] index type, [5] encrypted, [32:64] root hash of the index
+//
+// A value chunk holds the length of the value, 4 bytes big endian, followed by the value.  Every write is flushed
+// and published before it returns, as on an unbuffered table.
+const (
+	KEYSPACE_MAGIC          = "ksp\x01"
+	KEYSPACE_VALUE_SIZE_MAX = CHUNK_SIZE - 4
+	KEYSPACE_PAGE_MAX       = 1000 // pairs one IterateKV request returns at most, and by default
+)
+
+// Keyspace is an open keyspace, see kv.go
+type Keyspace struct {
+	mu        sync.Mutex
+	swarmdb   *SwarmDB
+	Owner     string
+	Name      string
+	indexType sdbc.IndexType
+	encrypted int
+	index     OrderedDatabase
+	roothash  []byte // of the published descriptor
+}
+
+type keyspaceRegistry struct {
+	mu   sync.Mutex
+	open map[string]*Keyspace // by keyspaceKey
+}
+
+func (self *SwarmDB) keyspaceKey(owner string, name string) string {
+	return self.GetTableKey(owner, "", name)
+}
+
+// newKeyspaceIndex opens the index of a keyspace at root, or an empty one when root is not a hash
+func (self *SwarmDB) newKeyspaceIndex(u *SWARMDBUser, indexType sdbc.IndexType, root []byte, encrypted int) (index OrderedDatabase, err error) {
+	if !valid_hashid(root) {
+		root = nil
+	}
+	switch indexType {
+	case sdbc.IT_BPLUSTREE:
+		return NewBPlusTreeDB(u, self, root, sdbc.CT_STRING, false, sdbc.CT_STRING, encrypted)
+	case sdbc.IT_HASHTREE:
+		return NewHashDB(u, root, self, sdbc.CT_STRING, encrypted)
+	}
+	return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[kv:newKeyspaceIndex] index type %v", indexType), ErrorCode: 408, ErrorMessage: "Invalid IndexType: a keyspace is a B+tree or a HashDB"}
+}
+
+// CreateKeyspace creates the named, empty keyspace of owner, indexed by a B+tree or a HashDB
+func (self *SwarmDB) CreateKeyspace(u *SWARMDBUser, owner string, name string, indexType sdbc.IndexType, encrypted int) (err error) {
+	if err = self.checkWritable(); err != nil {
+		return err
+	}
+	if !validName(name, TABLE_NAME_LENGTH_MAX) {
+		return invalidRequest("keyspace", name, fmt.Sprintf("must be up to %d letters, digits, '_', '-' or '.'", TABLE_NAME_LENGTH_MAX))
+	}
+	index, err := self.newKeyspaceIndex(u, indexType, nil, encrypted)
+	if err != nil {
+		return err
+	}
+	roothash, err := self.GetRootHash(u, []byte(self.keyspaceKey(owner, name)))
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:CreateKeyspace] GetRootHash %s", err.Error()))
+	}
+	if valid_hashid(roothash) {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[kv:CreateKeyspace] %s exists", name), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: keyspace [%s] exists", name)}
+	}
+	ks := &Keyspace{swarmdb: self, Owner: owner, Name: name, indexType: indexType, encrypted: encrypted, index: index}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if err = ks.publish(u); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:CreateKeyspace] publish %s", err.Error()))
+	}
+	self.keyspaces.mu.Lock()
+	defer self.keyspaces.mu.Unlock()
+	if self.keyspaces.open == nil {
+		self.keyspaces.open = make(map[string]*Keyspace)
+	}
+	self.keyspaces.open[self.keyspaceKey(owner, name)] = ks
+	return nil
+}
+
+// GetKeyspace returns the named keyspace of owner, opening it from its published descriptor when it is not open
+func (self *SwarmDB) GetKeyspace(u *SWARMDBUser, owner string, name string) (ks *Keyspace, err error) {
+	key := self.keyspaceKey(owner, name)
+	self.keyspaces.mu.Lock()
+	defer self.keyspaces.mu.Unlock()
+	if ks, ok := self.keyspaces.open[key]; ok {
+		return ks, nil
+	}
+	roothash, err := self.GetRootHash(u, []byte(key))
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:GetKeyspace] GetRootHash %s", err.Error()))
+	}
+	if !valid_hashid(roothash) {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[kv:GetKeyspace] %s not found", key), ErrorCode: 403, ErrorMessage: fmt.Sprintf("Keyspace Does Not Exist: Keyspace [%s] Owner [%s]", name, owner)}
+	}
+	buf, err := self.RetrieveDBChunk(u, roothash)
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:GetKeyspace] RetrieveDBChunk %s", err.Error()))
+	}
+	if len(buf) < 64 || string(buf[0:4]) != KEYSPACE_MAGIC {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[kv:GetKeyspace] %s: not a keyspace descriptor", key), ErrorCode: 439, ErrorMessage: "Unable to Parse Chunk"}
+	}
+	ks = &Keyspace{swarmdb: self, Owner: owner, Name: name, indexType: ByteToIndexType(buf[4]), encrypted: int(buf[5]), roothash: roothash}
+	if ks.index, err = self.newKeyspaceIndex(u, ks.indexType, buf[32:64], ks.encrypted); err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:GetKeyspace] newKeyspaceIndex %s", err.Error()))
+	}
+	if self.keyspaces.open == nil {
+		self.keyspaces.open = make(map[string]*Keyspace)
+	}
+	self.keyspaces.open[key] = ks
+	return ks, nil
+}
+
+// publish flushes the index of ks and publishes its descriptor; when another writer published meanwhile, ks is
+// dropped so the next request reopens the keyspace at the other writer's root hash
+func (ks *Keyspace) publish(u *SWARMDBUser) (err error) {
+	if _, err = ks.index.FlushBuffer(u); err != nil {
+		ks.close()
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:publish] FlushBuffer %s", err.Error()))
+	}
+	buf := getChunkBuffer()
+	defer releaseChunkBuffer(buf)
+	copy(buf[0:4], KEYSPACE_MAGIC)
+	buf[4] = byte(IndexTypeToInt(ks.indexType))
+	buf[5] = byte(ks.encrypted)
+	copy(buf[32:64], ks.index.GetRootHash())
+	swarmhash, err := ks.swarmdb.StoreDBChunk(u, buf, 0)
+	if err != nil {
+		ks.close()
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:publish] StoreDBChunk %s", err.Error()))
+	}
+	if err = ks.swarmdb.StoreRootHash(u, []byte(ks.swarmdb.keyspaceKey(ks.Owner, ks.Name)), ks.roothash, swarmhash); err != nil {
+		ks.close()
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:publish] StoreRootHash %s", err.Error()))
+	}
+	ks.roothash = swarmhash
+	return nil
+}
+
+// close drops ks from the open keyspaces
+func (ks *Keyspace) close() {
+	r := &ks.swarmdb.keyspaces
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := ks.swarmdb.keyspaceKey(ks.Owner, ks.Name)
+	if r.open[key] == ks {
+		delete(r.open, key)
+	}
+}
+
+func keyspaceKeyOf(key []byte) (k []byte, err error) {
+	if len(key) == 0 || len(key) > K_SIZE {
+		return nil, invalidRequest("key", string(key), fmt.Sprintf("must be 1 to %d bytes", K_SIZE))
+	}
+	k = make([]byte, K_SIZE)
+	copy(k, key)
+	return k, nil
+}
+
+// Put stores value under key
+func (ks *Keyspace) Put(u *SWARMDBUser, key []byte, value []byte) (err error) {
+	if err = ks.swarmdb.checkWritable(); err != nil {
+		return err
+	}
+	k, err := keyspaceKeyOf(key)
+	if err != nil {
+		return err
+	}
+	if len(value) > KEYSPACE_VALUE_SIZE_MAX {
+		return invalidRequest("value", fmt.Sprintf("%d bytes", len(value)), fmt.Sprintf("must be at most %d bytes", KEYSPACE_VALUE_SIZE_MAX))
+	}
+	vhash, err := ks.swarmdb.storeValueChunk(u, value, ks.encrypted)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:Put] %s", err.Error()))
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if _, err = ks.index.Put(u, k, vhash); err != nil {
+		ks.close()
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:Put] index.Put %s", err.Error()))
+	}
+	return ks.publish(u)
+}
+
+// Get returns the value stored under key
+func (ks *Keyspace) Get(u *SWARMDBUser, key []byte) (value []byte, ok bool, err error) {
+	k, err := keyspaceKeyOf(key)
+	if err != nil {
+		return nil, false, err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.get(u, k)
+}
+
+func (ks *Keyspace) get(u *SWARMDBUser, k []byte) (value []byte, ok bool, err error) {
+	v, ok, err := ks.index.Get(u, k)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if value, err = ks.swarmdb.retrieveValueChunk(u, v); err != nil {
+		return nil, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:get] %s", err.Error()))
+	}
+	return value, true, nil
+}
+
+// storeValueChunk stores value, of up to KEYSPACE_VALUE_SIZE_MAX bytes, in a chunk of its own, as its length, 4
+// bytes big endian, followed by the value, and returns the chunk hash
+func (self *SwarmDB) storeValueChunk(u *SWARMDBUser, value []byte, encrypted int) (vhash []byte, err error) {
+	buf := getChunkBuffer()
+	defer releaseChunkBuffer(buf)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(value)))
+	copy(buf[4:], value)
+	if vhash, err = self.StoreDBChunk(u, buf, encrypted); err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:storeValueChunk] StoreDBChunk %s", err.Error()))
+	}
+	return vhash, nil
+}
+
+// retrieveValueChunk returns the value of the chunk storeValueChunk stored at vhash
+func (self *SwarmDB) retrieveValueChunk(u *SWARMDBUser, vhash []byte) (value []byte, err error) {
+	// the HashDB trims the zeros a chunk hash may end in
+	vhash = padKey(vhash)
+	buf, err := self.RetrieveDBChunk(u, vhash)
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:retrieveValueChunk] RetrieveDBChunk %s", err.Error()))
+	}
+	if len(buf) < 4 || int(binary.BigEndian.Uint32(buf[0:4])) > len(buf)-4 {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[kv:retrieveValueChunk] value chunk %x", vhash), ErrorCode: 439, ErrorMessage: "Unable to Parse Chunk"}
+	}
+	return buf[4 : 4+binary.BigEndian.Uint32(buf[0:4])], nil
+}
+
+// Delete removes key and reports whether it was there
+func (ks *Keyspace) Delete(u *SWARMDBUser, key []byte) (ok bool, err error) {
+	if err = ks.swarmdb.checkWritable(); err != nil {
+		return false, err
+	}
+	k, err := keyspaceKeyOf(key)
+	if err != nil {
+		return false, err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ok, err = ks.index.Delete(u, k); err != nil {
+		ks.close()
+		return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:Delete] index.Delete %s", err.Error()))
+	}
+	if !ok {
+		return false, nil
+	}
+	return true, ks.publish(u)
+}
+
+// Iterate calls fn with the pairs of the keyspace, from start on, until fn returns false: in key order in a B+tree
+// keyspace, in hash order in a HashDB one, where start, when given, must be a key of the keyspace
+func (ks *Keyspace) Iterate(u *SWARMDBUser, start []byte, fn func(key []byte, value []byte) bool) (err error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	var cursor OrderedDatabaseCursor
+	if len(start) == 0 {
+		cursor, err = ks.index.SeekFirst(u)
+	} else {
+		k, kerr := keyspaceKeyOf(start)
+		if kerr != nil {
+			return kerr
+		}
+		cursor, _, err = ks.index.Seek(u, k)
+	}
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:Iterate] Seek %s", err.Error()))
+	}
+	for {
+		if err = u.checkDeadline("kv:Iterate"); err != nil {
+			return err
+		}
+		k, _, err := cursor.Next(u)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:Iterate] cursor %s", err.Error()))
+		}
+		value, ok, err := ks.get(u, k)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:Iterate] get %s", err.Error()))
+		}
+		if !ok {
+			continue
+		}
+		if !fn(bytes.TrimRight(k, "\x00"), value) {
+			return nil
+		}
+	}
+}
+
+// PutKV stores value under key in the named keyspace of owner
+func (self *SwarmDB) PutKV(u *SWARMDBUser, owner string, name string, key []byte, value []byte) (err error) {
+	ks, err := self.GetKeyspace(u, owner, name)
+	if err != nil {
+		return err
+	}
+	return ks.Put(u, key, value)
+}
+
+// GetKV returns the value stored under key in the named keyspace of owner
+func (self *SwarmDB) GetKV(u *SWARMDBUser, owner string, name string, key []byte) (value []byte, ok bool, err error) {
+	ks, err := self.GetKeyspace(u, owner, name)
+	if err != nil {
+		return nil, false, err
+	}
+	return ks.Get(u, key)
+}
+
+// DeleteKV removes key from the named keyspace of owner and reports whether it was there
+func (self *SwarmDB) DeleteKV(u *SWARMDBUser, owner string, name string, key []byte) (ok bool, err error) {
+	ks, err := self.GetKeyspace(u, owner, name)
+	if err != nil {
+		return false, err
+	}
+	return ks.Delete(u, key)
+}
+
+// IterateKV calls fn with the pairs of the named keyspace of owner from start on, see Keyspace.Iterate
+func (self *SwarmDB) IterateKV(u *SWARMDBUser, owner string, name string, start []byte, fn func(key []byte, value []byte) bool) (err error) {
+	ks, err := self.GetKeyspace(u, owner, name)
+	if err != nil {
+		return err
+	}
+	return ks.Iterate(u, start, fn)
+}
+
+// keyspaceRequest answers RT_CREATE_KEYSPACE, RT_PUT_KV, RT_GET_KV, RT_DELETE_KV and RT_ITERATE_KV on the keyspace
+// d.Table of d.Owner
+func (self *SwarmDB) keyspaceRequest(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	key, _ := d.Key.(string)
+	var arg sdbc.Row
+	if len(d.Rows) > 0 {
+		arg = d.Rows[0]
+	}
+	switch d.RequestType {
+	case wire.RT_CREATE_KEYSPACE:
+		var indexType sdbc.IndexType = sdbc.IT_BPLUSTREE
+		if len(d.Columns) > 0 {
+			indexType = d.Columns[0].IndexType
+		}
+		if err = self.CreateKeyspace(u, d.Owner, d.Table, indexType, d.Encrypted); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:keyspaceRequest] CreateKeyspace %s", err.Error()))
+		}
+		resp.AffectedRowCount = 1
+	case wire.RT_PUT_KV:
+		value, ok := arg["value"].(string)
+		if !ok {
+			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[kv:keyspaceRequest] value %v", arg["value"]), ErrorCode: 418, ErrorMessage: "Request Invalid: PutKV needs Rows[0] {\"value\": string}"}
+		}
+		if err = self.PutKV(u, d.Owner, d.Table, []byte(key), []byte(value)); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:keyspaceRequest] PutKV %s", err.Error()))
+		}
+		resp.AffectedRowCount = 1
+	case wire.RT_GET_KV:
+		value, ok, err := self.GetKV(u, d.Owner, d.Table, []byte(key))
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:keyspaceRequest] GetKV %s", err.Error()))
+		}
+		if ok {
+			resp.Data = append(resp.Data, sdbc.Row{"key": key, "value": string(value)})
+		}
+	case wire.RT_DELETE_KV:
+		ok, err := self.DeleteKV(u, d.Owner, d.Table, []byte(key))
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:keyspaceRequest] DeleteKV %s", err.Error()))
+		}
+		if ok {
+			resp.AffectedRowCount = 1
+		}
+	case wire.RT_ITERATE_KV:
+		start, _ := arg["start"].(string)
+		limit := KEYSPACE_PAGE_MAX
+		if n, ok := toFloat(arg["limit"]); ok && n > 0 && n < KEYSPACE_PAGE_MAX {
+			limit = int(n)
+		}
+		err = self.IterateKV(u, d.Owner, d.Table, []byte(start), func(k []byte, v []byte) bool {
+			resp.Data = append(resp.Data, sdbc.Row{"key": string(k), "value": string(v)})
+			return len(resp.Data) < limit
+		})
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[kv:keyspaceRequest] IterateKV %s", err.Error()))
+		}
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
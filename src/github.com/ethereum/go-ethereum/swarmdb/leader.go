@@ -0,0 +1,283 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"github.com/ethereum/go-ethereum/swarmdb/swarmdblib"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// When several SwarmDB nodes front the tables of one owner, config.Election lets only one of them write: the
+// nodes compete for a lease per owner, an ENS record naming the leader and when its lease expires that is only
+// changed by compare-and-swap.  The leader renews its lease every third of its length; once a lease has expired
+// (plus LEASE_SKEW for clocks that disagree) any node may take it over with the next term.  Writes for an elected
+// owner are only accepted by the leader, so the nodes never publish competing root hashes: the others forward
+// them to the leader when Election.Proxy is set and reject them with ErrorCode 504 otherwise.  Forwarded writes
+// run on the leader as this node's key, so the nodes of an owner share the owner's key.
+const (
+	LEASE_PREFIX   = "swarmdb-lease:" // ENS record of the lease of an owner
+	LEASE_DURATION = 10 * time.Second
+	LEASE_SKEW     = time.Second
+)
+
+// ElectionConfig selects the owners whose tables only an elected leader writes
+type ElectionConfig struct {
+	Owners       []string `json:"owners,omitempty"`       // owners the nodes elect a leader for
+	NodeID       string   `json:"nodeID,omitempty"`       // unique among the nodes, defaults to Advertise
+	Advertise    string   `json:"advertise,omitempty"`    // ip:port of the TCP server others forward writes to, defaults to ListenAddrTCP:PortTCP
+	LeaseSeconds int      `json:"leaseSeconds,omitempty"` // lease length, 0 uses LEASE_DURATION
+	Proxy        int      `json:"proxy,omitempty"`        // 1 - forward writes to the leader, 0 - reject them
+}
+
+// Lease is the ENS record of the leader of an owner
+type Lease struct {
+	Leader    string `json:"leader"`  // NodeID
+	Address   string `json:"address"` // Advertise
+	Term      int64  `json:"term"`    // counts the leaders, it only grows
+	ExpiresMs int64  `json:"expires"` // unix milliseconds of the leader's clock
+}
+
+type Elector struct {
+	swarmdb    *SwarmDB
+	owners     map[string]bool
+	nodeID     string
+	advertise  string
+	lease      time.Duration
+	proxy      bool
+	privateKey string
+
+	mu     sync.Mutex
+	leases map[string]Lease            // last lease read or written, by owner
+	pools  map[string]*swarmdblib.Pool // connections to leaders, by address
+}
+
+// NewElector returns the Elector of swarmdb for config.Election and makes swarmdb refuse writes for the elected
+// owners until it leads them
+func NewElector(swarmdb *SwarmDB, config *SWARMDBConfig) *Elector {
+	election := config.Election
+	e := &Elector{swarmdb: swarmdb, owners: make(map[string]bool), nodeID: election.NodeID, advertise: election.Advertise, lease: time.Duration(election.LeaseSeconds) * time.Second, proxy: election.Proxy > 0, privateKey: config.PrivateKey, leases: make(map[string]Lease), pools: make(map[string]*swarmdblib.Pool)}
+	for _, owner := range election.Owners {
+		e.owners[owner] = true
+	}
+	if len(e.advertise) == 0 {
+		e.advertise = fmt.Sprintf("%s:%d", config.ListenAddrTCP, config.PortTCP)
+	}
+	if len(e.nodeID) == 0 {
+		e.nodeID = e.advertise
+	}
+	if e.lease <= 0 {
+		e.lease = LEASE_DURATION
+	}
+	swarmdb.elector = e
+	return e
+}
+
+// Campaign takes or renews the lease of every elected owner whose lease is free, expired or already held
+func (e *Elector) Campaign(u *SWARMDBUser) (err error) {
+	for owner := range e.owners {
+		if err = e.campaign(u, owner); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[leader:Campaign] %s %s", owner, err.Error()))
+		}
+	}
+	return nil
+}
+
+func (e *Elector) campaign(u *SWARMDBUser, owner string) (err error) {
+	key := []byte(LEASE_PREFIX + owner)
+	current, err := e.swarmdb.ens.GetRootHash(u, key)
+	if err != nil {
+		return err
+	}
+	now := nowMs()
+	held := Lease{}
+	if len(current) > 0 {
+		json.Unmarshal(current, &held)
+	}
+	next := Lease{Leader: e.nodeID, Address: e.advertise, Term: held.Term, ExpiresMs: now + int64(e.lease/time.Millisecond)}
+	switch {
+	case len(current) == 0:
+		next.Term = 1
+		err = e.swarmdb.ens.createRootHash(key, e.marshal(next))
+	case held.Leader == e.nodeID:
+		err = e.swarmdb.ens.swapRootHash(key, current, e.marshal(next))
+	case now > held.ExpiresMs+int64(LEASE_SKEW/time.Millisecond):
+		next.Term++
+		err = e.swarmdb.ens.swapRootHash(key, current, e.marshal(next))
+	default:
+		e.setLease(owner, held)
+		return nil
+	}
+	if err != nil {
+		if isConflict(err) {
+			// another node won the lease meanwhile
+			if current, err = e.swarmdb.ens.GetRootHash(u, key); err != nil {
+				return err
+			}
+			held = Lease{}
+			json.Unmarshal(current, &held)
+			e.setLease(owner, held)
+			return nil
+		}
+		return err
+	}
+	if held.Leader != e.nodeID || held.Term != next.Term {
+		log.Debug(fmt.Sprintf("[leader:campaign] %s leads %s in term %d", e.nodeID, owner, next.Term))
+	}
+	e.setLease(owner, next)
+	return nil
+}
+
+func (e *Elector) marshal(lease Lease) []byte {
+	out, _ := json.Marshal(lease)
+	return out
+}
+
+func (e *Elector) setLease(owner string, lease Lease) {
+	e.mu.Lock()
+	e.leases[owner] = lease
+	e.mu.Unlock()
+}
+
+// Resign gives up the leases this node holds so another node can take over without waiting for them to expire
+func (e *Elector) Resign(u *SWARMDBUser) (err error) {
+	for owner := range e.owners {
+		if !e.IsLeader(owner) {
+			continue
+		}
+		key := []byte(LEASE_PREFIX + owner)
+		current, err := e.swarmdb.ens.GetRootHash(u, key)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[leader:Resign] GetRootHash %s", err.Error()))
+		}
+		held := Lease{}
+		json.Unmarshal(current, &held)
+		if held.Leader != e.nodeID {
+			continue
+		}
+		held.ExpiresMs = 0
+		if err = e.swarmdb.ens.swapRootHash(key, current, e.marshal(held)); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[leader:Resign] swapRootHash %s", err.Error()))
+		}
+		e.setLease(owner, held)
+	}
+	return nil
+}
+
+// Start campaigns now and then every third of the lease until stop is called, which resigns and lets the SwarmDB
+// write for the elected owners again
+func (e *Elector) Start(u *SWARMDBUser) (stop func()) {
+	if err := e.Campaign(u); err != nil {
+		log.Debug(fmt.Sprintf("[leader:Start] %s", err.Error()))
+	}
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(e.lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				if err := e.Campaign(u); err != nil {
+					log.Debug(fmt.Sprintf("[leader:Start] %s", err.Error()))
+				}
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		<-done
+		if err := e.Resign(u); err != nil {
+			log.Debug(fmt.Sprintf("[leader:Start] %s", err.Error()))
+		}
+		if e.swarmdb.elector == e {
+			e.swarmdb.elector = nil
+		}
+		e.mu.Lock()
+		for _, p := range e.pools {
+			p.Close()
+		}
+		e.mu.Unlock()
+	}
+}
+
+// IsLeader reports whether this node may write the tables of owner: it holds an unexpired lease for it, or owner
+// is not elected
+func (e *Elector) IsLeader(owner string) bool {
+	if !e.owners[owner] {
+		return true
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	lease := e.leases[owner]
+	return lease.Leader == e.nodeID && nowMs() < lease.ExpiresMs
+}
+
+// Leader returns the lease of the current leader of owner as last seen by this node
+func (e *Elector) Leader(owner string) (lease Lease, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	lease, ok = e.leases[owner]
+	return lease, ok && nowMs() < lease.ExpiresMs+int64(LEASE_SKEW/time.Millisecond)
+}
+
+// checkLeader refuses writes for owner unless this node leads it
+func (e *Elector) checkLeader(owner string) (err error) {
+	if e.IsLeader(owner) {
+		return nil
+	}
+	lease, ok := e.Leader(owner)
+	if !ok {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[leader:checkLeader] no leader for %s", owner), ErrorCode: 504, ErrorMessage: fmt.Sprintf("Service Unavailable: no leader is elected for owner [%s]", owner)}
+	}
+	return &sdbc.SWARMDBError{Message: fmt.Sprintf("[leader:checkLeader] %s leads %s", lease.Leader, owner), ErrorCode: 504, ErrorMessage: fmt.Sprintf("Service Unavailable: writes for owner [%s] go to the leader at [%s]", owner, lease.Address)}
+}
+
+// forward sends a write this node does not lead to the leader, or refuses it
+func (e *Elector) forward(d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	lease, ok := e.Leader(d.Owner)
+	if !ok || !e.proxy || lease.Leader == e.nodeID {
+		return resp, e.checkLeader(d.Owner)
+	}
+	resp, err = e.pool(lease.Address).ProcessRequestCtx(context.Background(), *d)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[leader:forward] %s %s", lease.Address, err.Error()))
+	}
+	return resp, nil
+}
+
+func (e *Elector) pool(address string) *swarmdblib.Pool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	p, ok := e.pools[address]
+	if !ok {
+		host, portStr, _ := net.SplitHostPort(address)
+		port, _ := strconv.Atoi(portStr)
+		p = swarmdblib.NewPool(swarmdblib.PoolConfig{IP: host, Port: port, PrivateKey: e.privateKey})
+		e.pools[address] = p
+	}
+	return p
+}
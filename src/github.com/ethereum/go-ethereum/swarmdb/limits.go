@@ -0,0 +1,63 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+)
+
+// A row value is kept in the CHUNK_START_CHUNKVAL..CHUNK_END_CHUNKVAL bytes of one K-chunk and the value of an indexed
+// column, primary or secondary, in an index key of at most K_SIZE_MAX bytes (see bplus.go).  buildSdata cuts off
+// whatever does not fit, so a larger row would be stored corrupted, and an index refuses a larger key only once the
+// other indexes of the row hold it.  Every write of one is refused up front instead, with ROW_TOO_LARGE or
+// KEY_TOO_LARGE, which clients see as wire.ErrTooLarge.  config.MaxRowSize and config.MaxKeySize may lower the
+// limits, 0 or anything larger keeping those of the chunk layout.  The key limit applies to string and blob keys, the
+// only ones whose size the writer chooses.
+const (
+	ROW_TOO_LARGE = 510
+	KEY_TOO_LARGE = 511
+)
+
+// sizeLimit returns configured if it lowers physical, otherwise physical
+func sizeLimit(configured int, physical int) int {
+	if configured > 0 && configured < physical {
+		return configured
+	}
+	return physical
+}
+
+// checkRowSize refuses a row value of n bytes larger than the row size limit
+func (self *SwarmDB) checkRowSize(n int) error {
+	max := sizeLimit(self.maxRowSize, ROW_VALUE_SIZE_MAX)
+	if n <= max {
+		return nil
+	}
+	return &sdbc.SWARMDBError{Message: fmt.Sprintf("[limits:checkRowSize] %d bytes, limit %d", n, max), ErrorCode: ROW_TOO_LARGE, ErrorMessage: fmt.Sprintf("Row Too Large: the row is %d bytes as stored, at most %d are allowed", n, max)}
+}
+
+// checkKeySize refuses an indexed column value of columnType longer than the key size limit
+func (self *SwarmDB) checkKeySize(columnType sdbc.ColumnType, value interface{}) error {
+	if columnType != sdbc.CT_STRING && columnType != sdbc.CT_BLOB {
+		return nil
+	}
+	key, ok := value.(string)
+	max := sizeLimit(self.maxKeySize, K_SIZE_MAX)
+	if !ok || len(key) <= max {
+		return nil
+	}
+	return &sdbc.SWARMDBError{Message: fmt.Sprintf("[limits:checkKeySize] %d bytes, limit %d", len(key), max), ErrorCode: KEY_TOO_LARGE, ErrorMessage: fmt.Sprintf("Key Too Large: the key [%s] is %d bytes, at most %d are allowed", key, len(key), max)}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"github.com/ethereum/go-ethereum/swarmdb/swarmdblog"
+	"sort"
+)
+
+// Subsystem loggers, whose levels can be set apart from the default level in config.Log or with ADMIN_LOG_LEVEL
+var (
+	bplusLog  = swarmdblog.New("bplus")
+	hashdbLog = swarmdblog.New("hashdb")
+	queryLog  = swarmdblog.New("query")
+	ensLog    = swarmdblog.New("ens")
+)
+
+// ADMIN_LOG_LEVEL sets the level of the subsystem in Rows[0] {"subsystem", "level"} (the default level for an empty
+// subsystem), if given, and answers with a {"subsystem", "level"} row per level
+const ADMIN_LOG_LEVEL = "LogLevel"
+
+// logLevel answers ADMIN_LOG_LEVEL
+func logLevel(d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	if len(d.Rows) > 0 {
+		subsystem, _ := d.Rows[0]["subsystem"].(string)
+		level, _ := d.Rows[0]["level"].(string)
+		if err = swarmdblog.SetLevel(subsystem, level); err != nil {
+			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[logging:logLevel] SetLevel %s", err.Error()), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: unknown log level [%s]", level)}
+		}
+		resp.AffectedRowCount = 1
+	}
+	levels := swarmdblog.Levels()
+	subsystems := make([]string, 0, len(levels))
+	for subsystem := range levels {
+		subsystems = append(subsystems, subsystem)
+	}
+	sort.Strings(subsystems)
+	for _, subsystem := range subsystems {
+		row := sdbc.NewRow()
+		row["subsystem"] = subsystem
+		row["level"] = levels[subsystem]
+		resp.Data = append(resp.Data, row)
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
@@ -38,6 +38,8 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 
 	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -51,10 +53,28 @@ type SWARMDBNode struct {
 	port     int
 }
 
+// A Validator job reads its input lines from In and writes its output lines to Out, os.Stdin and os.Stdout when nil,
+// so that the jobs can be chained with sort in a shell pipeline as above; malformed input is logged and skipped.
 type Validator struct {
 	nodes         []SWARMDBNode
 	BandwidthCost int
 	StorageCost   int
+	In            io.Reader
+	Out           io.Writer
+}
+
+func (self *Validator) input() io.Reader {
+	if self.In == nil {
+		return os.Stdin
+	}
+	return self.In
+}
+
+func (self *Validator) output() io.Writer {
+	if self.Out == nil {
+		return os.Stdout
+	}
+	return self.Out
 }
 
 // swapMapRed:      SwapLogEntry => BandwidthLogEntry
@@ -152,7 +172,7 @@ func (self *Validator) getSWARMDBLogs(logtype string, path string, epoch string)
 		fn := fmt.Sprintf("%s/%s-%s.%s", path, epoch, n.farmerID, logtype)
 		d1 := []byte(body)
 		err = ioutil.WriteFile(fn, d1, 0644)
-		fmt.Printf("SAVING %s (%d bytes)\n", fn, len(d1))
+		log.Debug(fmt.Sprintf("[mapred:getSWARMDBLogs] saving %s (%d bytes)", fn, len(d1)))
 		if err != nil {
 			//
 		}
@@ -217,16 +237,16 @@ Output:
 */
 
 func (self *Validator) SmashMap() (err error) {
-	scanner := bufio.NewScanner(os.Stdin)
+	scanner := bufio.NewScanner(self.input())
 	for scanner.Scan() {
 		inp := scanner.Text()
 		var e SmashLogEntry
 		err := json.Unmarshal([]byte(inp), &e)
 		if err != nil {
-			fmt.Printf(" error %v\n", err)
+			log.Warn(fmt.Sprintf("[mapred:SmashMap] Unmarshal %s", err.Error()))
 		} else {
 			if len(e.ChunkID) > 0 {
-				fmt.Printf("%s\t%s\n", e.ChunkID, inp)
+				fmt.Fprintf(self.output(), "%s\t%s\n", e.ChunkID, inp)
 
 			}
 		}
@@ -239,7 +259,7 @@ func (self *Validator) SmashMap() (err error) {
 }
 
 func (self *Validator) SmashRed() (err error) {
-	scanner := bufio.NewScanner(os.Stdin)
+	scanner := bufio.NewScanner(self.input())
 	previd := ""
 
 	var buyerLog []SmashLogEntry
@@ -259,7 +279,7 @@ func (self *Validator) SmashRed() (err error) {
 			var e SmashLogEntry
 			err := json.Unmarshal([]byte(sa[1]), &e)
 			if err != nil {
-				fmt.Printf(" error %v\n", err)
+				log.Warn(fmt.Sprintf("[mapred:SmashRed] Unmarshal %s", err.Error()))
 			} else {
 				if len(e.FarmerID) > 0 {
 					farmerLog = append(farmerLog, e)
@@ -343,7 +363,7 @@ func (self *Validator) tallySmash(chunkID string, buyerLog []SmashLogEntry, farm
 		if err != nil {
 			// fmt.Printf(" error %v\n", err)
 		}
-		fmt.Printf("%s\n", string(o))
+		fmt.Fprintf(self.output(), "%s\n", string(o))
 
 	} else if len(buyerLog) > 0 {
 		// buyer requested chunk storage but no farmers made claims
@@ -370,27 +390,27 @@ Output:
 */
 
 func (self *Validator) SwapMap() (err error) {
-	scanner := bufio.NewScanner(os.Stdin)
+	scanner := bufio.NewScanner(self.input())
 	for scanner.Scan() {
 		inp := scanner.Text()
 		var e SwapLogEntry
 		err := json.Unmarshal([]byte(inp), &e)
 		if err != nil {
-			fmt.Printf(" error %v\n", err)
+			log.Warn(fmt.Sprintf("[mapred:SwapMap] Unmarshal %s", err.Error()))
 		} else {
 		}
 		if len(e.LocalID) > 0 && len(e.RemoteID) > 0 {
 			if e.B < 0 { // the person receiving the check is the author of this line
 				if self.validateSwapSignature(e.SwapID, e.Sig, e.LocalID) {
 					// TODO: check for valid signature here - sig should be the localID
-					fmt.Printf("%s\t%s\t%s\t%d\n", e.SwapID, e.LocalID, e.RemoteID, e.B)
+					fmt.Fprintf(self.output(), "%s\t%s\t%s\t%d\n", e.SwapID, e.LocalID, e.RemoteID, e.B)
 				} else {
 					// TODO: provide feedback to localID
 				}
 			} else {
 				if self.validateSwapSignature(e.SwapID, e.Sig, e.RemoteID) {
 					// TODO: check for valid signature here - sig should be the remoteID
-					fmt.Printf("%s\t%s\t%s\t%d\n", e.SwapID, e.LocalID, e.RemoteID, e.B)
+					fmt.Fprintf(self.output(), "%s\t%s\t%s\t%d\n", e.SwapID, e.LocalID, e.RemoteID, e.B)
 				} else {
 					// TODO: provide feedback to remoteID
 				}
@@ -410,7 +430,7 @@ func (self *Validator) validateSwapSignature(swapID string, sig string, signer s
 }
 
 func (self *Validator) SwapRed() (err error) {
-	scanner := bufio.NewScanner(os.Stdin)
+	scanner := bufio.NewScanner(self.input())
 	previd := ""
 	beneficiary1 := ""
 	sender1 := ""
@@ -466,7 +486,7 @@ func (self *Validator) tallySwap(swapID string, beneficiary1 string, sender1 str
 		if err != nil {
 			// fmt.Printf(" error %v\n", err)
 		}
-		fmt.Printf("%s\n", string(out))
+		fmt.Fprintf(self.output(), "%s\n", string(out))
 
 		e.ID = sender2
 		e.B = b2
@@ -474,7 +494,7 @@ func (self *Validator) tallySwap(swapID string, beneficiary1 string, sender1 str
 		if err != nil {
 			// fmt.Printf(" error %v\n", err)
 		}
-		fmt.Printf("%s\n", string(out))
+		fmt.Fprintf(self.output(), "%s\n", string(out))
 	}
 }
 
@@ -494,7 +514,7 @@ Output: (this is the input of the collation map reduce job)
 */
 func (self *Validator) StorageMap() (err error) {
 	thresh := 100
-	scanner := bufio.NewScanner(os.Stdin)
+	scanner := bufio.NewScanner(self.input())
 	var tallyBuyer map[string]int
 	var tallyFarmer map[string]int
 
@@ -506,7 +526,8 @@ func (self *Validator) StorageMap() (err error) {
 		var e StorageLogEntry
 		err := json.Unmarshal([]byte(inp), &e)
 		if err != nil {
-			fmt.Printf(" error %v\n", err)
+			log.Warn(fmt.Sprintf("[mapred:StorageMap] Unmarshal %s", err.Error()))
+			continue
 		}
 		buyer, farmers := e.selectedBuyerAndFarmers()
 		if len(farmers) < 3 {
@@ -521,7 +542,7 @@ func (self *Validator) StorageMap() (err error) {
 			tallyFarmer[farmerID]++
 		}
 		if c == thresh {
-			fmt.Printf("%v | %v\n", tallyBuyer, tallyFarmer)
+			log.Trace(fmt.Sprintf("[mapred:StorageMap] buyers %v farmers %v", tallyBuyer, tallyFarmer))
 			self.tallyStorage(tallyBuyer, tallyFarmer)
 			tallyBuyer = make(map[string]int)
 			tallyFarmer = make(map[string]int)
@@ -536,10 +557,10 @@ func (self *Validator) StorageMap() (err error) {
 
 func (self *Validator) tallyStorage(tallyBuyer map[string]int, tallyFarmer map[string]int) {
 	for buyer, sb := range tallyBuyer {
-		fmt.Printf("%s\t%d\t0\n", buyer, sb)
+		fmt.Fprintf(self.output(), "%s\t%d\t0\n", buyer, sb)
 	}
 	for farmer, sf := range tallyFarmer {
-		fmt.Printf("%s\t0\t%d\n", farmer, sf)
+		fmt.Fprintf(self.output(), "%s\t0\t%d\n", farmer, sf)
 	}
 }
 
@@ -558,7 +579,7 @@ func (self *StorageLogEntry) selectedBuyerAndFarmers() (buyer string, farmers []
 }
 
 func (self *Validator) StorageRed() (err error) {
-	scanner := bufio.NewScanner(os.Stdin)
+	scanner := bufio.NewScanner(self.input())
 	previd := ""
 	tsb := 0
 	tsf := 0
@@ -600,7 +621,7 @@ func (self *Validator) tallyStorageSummary(id string, tsb int, tsf int) {
 	if err != nil {
 		return
 	}
-	fmt.Printf("%s\n", out)
+	fmt.Fprintf(self.output(), "%s\n", out)
 }
 
 /*
@@ -617,15 +638,16 @@ Input: (this is the output of the "swap" job)
 {"id":"0xd80a4004350027f618107fe3240937d54e46c21b","b":1290}
 */
 func (self *Validator) BandwidthMap() (err error) {
-	scanner := bufio.NewScanner(os.Stdin)
+	scanner := bufio.NewScanner(self.input())
 	for scanner.Scan() {
 		inp := scanner.Text()
 		var e BandwidthLogEntry
 		err := json.Unmarshal([]byte(inp), &e)
 		if err != nil {
-			fmt.Printf(" error %v\n", err)
+			log.Warn(fmt.Sprintf("[mapred:BandwidthMap] Unmarshal %s", err.Error()))
+			continue
 		}
-		fmt.Printf("%s\t%d\n", e.ID, e.B)
+		fmt.Fprintf(self.output(), "%s\t%d\n", e.ID, e.B)
 	}
 	return nil
 }
@@ -633,7 +655,7 @@ func (self *Validator) BandwidthMap() (err error) {
 func (self *Validator) BandwidthRed() (err error) {
 	previd := ""
 	tb := 0
-	scanner := bufio.NewScanner(os.Stdin)
+	scanner := bufio.NewScanner(self.input())
 	for scanner.Scan() {
 		line := scanner.Text()
 
@@ -668,7 +690,7 @@ func (self *Validator) tallyBandwidthSummary(id string, b int) {
 	if err != nil {
 		return
 	}
-	fmt.Printf("%s\n", out)
+	fmt.Fprintf(self.output(), "%s\n", out)
 }
 
 /*
@@ -692,15 +714,16 @@ OUTPUT:
 */
 
 func (self *Validator) CollationMap() (err error) {
-	scanner := bufio.NewScanner(os.Stdin)
+	scanner := bufio.NewScanner(self.input())
 	for scanner.Scan() {
 		inp := scanner.Text()
 		var e CollationLogEntry
 		err := json.Unmarshal([]byte(inp), &e)
 		if err != nil {
-			fmt.Printf(" error %v\n", err)
+			log.Warn(fmt.Sprintf("[mapred:CollationMap] Unmarshal %s", err.Error()))
+			continue
 		}
-		fmt.Printf("%s\t%d\t%d\n", e.ID, e.B, e.S)
+		fmt.Fprintf(self.output(), "%s\t%d\t%d\n", e.ID, e.B, e.S)
 	}
 	return nil
 }
@@ -710,7 +733,7 @@ func (self *Validator) CollationRed() (err error) {
 	previd := ""
 	tb := 0
 	ts := 0
-	scanner := bufio.NewScanner(os.Stdin)
+	scanner := bufio.NewScanner(self.input())
 	for scanner.Scan() {
 		line := scanner.Text()
 
@@ -747,7 +770,7 @@ func (self *Validator) tallyCollationSummary(id string, b int, s int) {
 	if err != nil {
 		return
 	}
-	fmt.Printf("%s\n", string(out))
+	fmt.Fprintf(self.output(), "%s\n", string(out))
 }
 
 func genID(i int, maxlen int) (out string) {
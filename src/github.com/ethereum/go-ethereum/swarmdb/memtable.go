@@ -0,0 +1,77 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"sync"
+)
+
+// The Puts and Deletes buffered on a table since its last flush are also kept in its memtable, keyed by primary key,
+// so a read of a row written meanwhile is answered from memory before the primary index is walked or the K-chunk is
+// fetched.  Entries hold the row value as stored, binary or JSON, or a tombstone for a Delete.  resetDirty drains
+// the memtable once the buffer is published, and a table reopened to discard its buffer starts with none.  Snapshots
+// read the published roots only and never consult it.
+type memtable struct {
+	mu      sync.Mutex
+	entries map[string][]byte // nil value: deleted
+	hits    int
+}
+
+// put records the stored value of the row key
+func (m *memtable) put(key []byte, value []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entries == nil {
+		m.entries = make(map[string][]byte)
+	}
+	m.entries[memtableKey(key)] = value
+}
+
+// delete records that the row key was deleted
+func (m *memtable) delete(key []byte) {
+	m.put(key, nil)
+}
+
+// get returns the stored value of the row key; held is false when the memtable knows nothing of key, and value is
+// nil when the row was deleted
+func (m *memtable) get(key []byte) (value []byte, held bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if value, held = m.entries[memtableKey(key)]; held {
+		m.hits++
+	}
+	return value, held
+}
+
+// memtableKey trims the zero padding of key, which callers of Get may leave out
+func memtableKey(key []byte) string {
+	return string(bytes.TrimRight(key, "\x00"))
+}
+
+// drain forgets every entry
+func (m *memtable) drain() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = nil
+}
+
+// MemtableStats returns the rows held in the memtable of t and the reads it has answered
+func (t *Table) MemtableStats() (rows int, hits int) {
+	t.memtable.mu.Lock()
+	defer t.memtable.mu.Unlock()
+	return len(t.memtable.entries), t.memtable.hits
+}
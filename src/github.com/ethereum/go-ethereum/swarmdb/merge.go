@@ -0,0 +1,158 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+)
+
+// A flush whose root hash compare-and-swap finds that another writer published the table meanwhile (ErrorCode 499)
+// normally fails, and the client retries its writes.  A table with a ConflictResolver instead merges: it keeps a log
+// of the Puts and Deletes since its last flush, reopens the table at the other writer's root hash and replays the log
+// on top of it, then publishes the result.  Rows are last-writer-wins per key, except that a Put of a row the other
+// writer stored differently is passed to the resolver.
+const MERGE_MAX_ATTEMPTS = 3 // merges tried before a flush gives up with the conflict
+
+// ConflictResolver decides a Put of ours over the row theirs that another writer stored under the same key: it
+// returns the row to store, nil to keep theirs, or an error to abort the merge
+type ConflictResolver func(key interface{}, ours sdbc.Row, theirs sdbc.Row) (merged sdbc.Row, err error)
+
+// LastWriterWins is the ConflictResolver storing our row
+func LastWriterWins(key interface{}, ours sdbc.Row, theirs sdbc.Row) (merged sdbc.Row, err error) {
+	return ours, nil
+}
+
+// rowOp is a logged Put (row set) or Delete (row nil) of a table with a ConflictResolver
+type rowOp struct {
+	key    interface{}
+	row    sdbc.Row
+	sealed map[string]bool // the columns of row encryptColumns sealed
+}
+
+// SetConflictResolver makes flushes of the table merge on conflicts using r; nil turns merging off
+func (t *Table) SetConflictResolver(r ConflictResolver) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.resolver = r
+	t.ops = nil
+}
+
+// logOp records a write for replay by merge
+func (t *Table) logOp(key interface{}, row sdbc.Row) {
+	if t.resolver == nil {
+		return
+	}
+	op := rowOp{key: key, row: row}
+	for name, c := range t.columns {
+		if _, ok := row[name]; ok && c.encrypted {
+			if op.sealed == nil {
+				op.sealed = make(map[string]bool)
+			}
+			op.sealed[name] = true
+		}
+	}
+	t.ops = append(t.ops, op)
+}
+
+// isConflict reports whether err, or an error it wraps, is the 499 of a root hash published by another writer
+func isConflict(err error) bool {
+	var sErr *sdbc.SWARMDBError
+	return errors.As(err, &sErr) && sErr.ErrorCode == 499
+}
+
+// merge replays the logged writes on the table as another writer published it and publishes the result; on
+// success t continues at the merged root hash and is registered again
+func (t *Table) merge(u *SWARMDBUser) (err error) {
+	for attempt := 1; ; attempt++ {
+		theirs := t.swarmdb.NewTable(t.Owner, t.Database, t.tableName)
+		if err = theirs.OpenTable(u); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[merge:merge] OpenTable %s", err.Error()))
+		}
+		if err = theirs.StartBuffer(u); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[merge:merge] StartBuffer %s", err.Error()))
+		}
+		if err = theirs.replay(u, t.ops, t.resolver); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[merge:merge] replay %s", err.Error()))
+		}
+		roots, err := theirs.flushColumns(u)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[merge:merge] flushColumns %s", err.Error()))
+		}
+		err = theirs.publishDescriptor(u, roots)
+		if err == nil {
+			theirs.setColumnRoots(roots)
+			t.columns, t.roothash, t.acl, t.flushPolicy, t.familyMask = theirs.columns, theirs.roothash, theirs.acl, theirs.flushPolicy, theirs.familyMask
+			t.swarmdb.RegisterTable(t.Owner, t.Database, t.tableName, t)
+			log.Debug(fmt.Sprintf("[merge:merge] merged %d writes into [%s] after %d attempts", len(t.ops), t.tableName, attempt), "trace", u.TraceID())
+			return nil
+		}
+		if !isConflict(err) || attempt >= MERGE_MAX_ATTEMPTS {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[merge:merge] publishDescriptor %s", err.Error()))
+		}
+	}
+}
+
+// replay applies ops to t, consulting resolve for Puts over rows stored differently
+func (t *Table) replay(u *SWARMDBUser, ops []rowOp, resolve ConflictResolver) (err error) {
+	for _, op := range ops {
+		if op.row == nil {
+			if _, err = t.Delete(u, op.key); err != nil {
+				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[merge:replay] Delete %s", err.Error()))
+			}
+			continue
+		}
+		row := op.row
+		var stored sdbc.Row
+		k, err := convertJSONValueToKey(t.columns[t.primaryColumnName].columnType, op.key)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[merge:replay] convertJSONValueToKey %s", err.Error()))
+		}
+		byteRow, ok, err := t.Get(u, k)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[merge:replay] Get %s", err.Error()))
+		}
+		if ok {
+			if stored, err = t.byteArrayToRow(byteRow); err != nil {
+				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[merge:replay] byteArrayToRow %s", err.Error()))
+			}
+			// rows are compared by their printed form, which sorts the columns
+			if fmt.Sprint(stored) != fmt.Sprint(row) {
+				if row, err = resolve(op.key, op.row, stored); err != nil {
+					return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[merge:replay] resolve %v %s", op.key, err.Error()))
+				}
+				if row == nil {
+					continue
+				}
+			}
+		}
+		// the cells sealed when ours or theirs were written are stored as they are, see encryptColumns
+		t.replaySealed = make(map[string]bool)
+		for name, value := range row {
+			if (op.sealed[name] && value == op.row[name]) || (isSealed(stored[name]) && value == stored[name]) {
+				t.replaySealed[name] = true
+			}
+		}
+		err = t.Put(u, row)
+		t.replaySealed = nil
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[merge:replay] Put %s", err.Error()))
+		}
+	}
+	return nil
+}
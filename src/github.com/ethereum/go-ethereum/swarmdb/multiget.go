@@ -0,0 +1,146 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"sync"
+)
+
+const (
+	MULTI_GET_KEYS_MAX    = 1000 // keys of one RT_GET_MULTI request
+	MULTI_GET_PARALLELISM = 16   // K-chunks GetMulti fetches at once
+)
+
+// GetMulti reads the rows of keys, as Get does one: out[i] is the row of keys[i], or nil when there is none.  The
+// primary index is walked for every key first, one after the other as index nodes load lazily, and the K-chunks of
+// the rows found are then fetched in parallel.
+func (t *Table) GetMulti(u *SWARMDBUser, keys [][]byte) (out [][]byte, err error) {
+	out = make([][]byte, len(keys))
+	if t.IsSharded() {
+		byShard := make(map[int][]int)
+		for i, k := range keys {
+			s := t.shardOf(k)
+			byShard[s] = append(byShard[s], i)
+		}
+		for s, idx := range byShard {
+			shard, err := t.shard(u, s)
+			if err != nil {
+				return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[multiget:GetMulti] shard %s", err.Error()))
+			}
+			shardKeys := make([][]byte, len(idx))
+			for j, i := range idx {
+				shardKeys[j] = keys[i]
+			}
+			rows, err := shard.GetMulti(u, shardKeys)
+			if err != nil {
+				return nil, err
+			}
+			for j, i := range idx {
+				out[i] = rows[j]
+			}
+		}
+		return out, nil
+	}
+	primary, ok := t.columns[t.primaryColumnName]
+	if !ok {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[multiget:GetMulti] columns array missing %s ", t.primaryColumnName), ErrorCode: 479, ErrorMessage: fmt.Sprintf("Table Definition Missing Selected Column [%s]", t.primaryColumnName)}
+	}
+	var found []int
+	for i, k := range keys {
+		_, ok, err := primary.dbaccess.Get(u, k)
+		if err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[multiget:GetMulti] dbaccess.Get %s", err.Error()))
+		}
+		if ok {
+			found = append(found, i)
+		}
+	}
+
+	errs := make(chan error, len(found))
+	sem := make(chan struct{}, MULTI_GET_PARALLELISM)
+	var wg sync.WaitGroup
+	for _, i := range found {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			var row []byte
+			var err error
+			if t.asOfMs > 0 {
+				row, _, err = t.getAsOf(u, keys[i], t.asOfMs)
+			} else {
+				row, _, err = t.readRow(u, keys[i])
+			}
+			if err != nil {
+				errs <- sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[multiget:GetMulti] key %x %s", keys[i], err.Error()))
+				return
+			}
+			out[i] = row
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	if err = <-errs; err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// getMulti answers RT_GET_MULTI: d.Key lists the primary keys, and a row is answered for each key found, in the
+// order of the keys
+func (self *SwarmDB) getMulti(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[multiget:getMulti] GetTable %s", err.Error()))
+	}
+	hidden, err := tbl.checkRead(u)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[multiget:getMulti] checkRead %s", err.Error()))
+	}
+	list, ok := d.Key.([]interface{})
+	if !ok || len(list) == 0 {
+		return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[multiget:getMulti] Key %v", d.Key), ErrorCode: 433, ErrorMessage: "GET Request Missing Key: Key must list the primary keys"}
+	}
+	primary, ok := tbl.columns[tbl.primaryColumnName]
+	if !ok {
+		return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[multiget:getMulti] Primary Key Not found in Column Definition"), ErrorCode: 479, ErrorMessage: "Table Definition Missing Primary Key"}
+	}
+	keys := make([][]byte, len(list))
+	for i, key := range list {
+		if keys[i], err = convertJSONValueToKey(primary.columnType, key); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[multiget:getMulti] convertJSONValueToKey %s", err.Error()))
+		}
+	}
+	rows, err := tbl.GetMulti(u, keys)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[multiget:getMulti] GetMulti %s", err.Error()))
+	}
+	for _, byteRow := range rows {
+		if byteRow == nil {
+			continue
+		}
+		row, err := tbl.byteArrayToRow(byteRow)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[multiget:getMulti] byteArrayToRow %s", err.Error()))
+		}
+		resp.Data = append(resp.Data, maskRow(row, hidden))
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
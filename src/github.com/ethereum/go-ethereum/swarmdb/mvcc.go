@@ -0,0 +1,202 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"time"
+)
+
+// Rows are versioned in their K-chunk header: a Put stamps the new row with its version (counting from 0), the
+// wall-clock time of the write in unix milliseconds and the writer's address, archives the version it overwrites
+// under versionChunkKey and records that hash as the previous version.  The versions of a row thus form a chain
+// from the current chunk back to version 0, which snapshots and AS OF reads walk to the version current at a given
+// time.  Deletes only remove the row from the indexes and are not part of the chain.
+
+// RowVersion is one version of a row, see RowVersions
+type RowVersion struct {
+	Version int
+	Updated time.Time
+	Writer  common.Address
+	Value   []byte
+}
+
+func nowMs() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// versionChunkKey is the key version of the row stored under contentPrefix is archived under once overwritten
+func versionChunkKey(contentPrefix []byte, version int) []byte {
+	return crypto.Keccak256(contentPrefix, IntToByte(version))
+}
+
+// archiveVersion copies the current version of the row k, if any, to its version chunk before a Put overwrites it,
+// and returns the header fields of the new version
+func (t *Table) archiveVersion(u *SWARMDBUser, k []byte) (birthts int, version int, prevVersion []byte, err error) {
+	chunkKey := t.GenerateKChunkKey(k)
+	current, err := t.swarmdb.dbchunkstore.RetrieveChunk(u, chunkKey)
+	if err != nil {
+		return birthts, version, nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[mvcc:archiveVersion] RetrieveChunk %s", err.Error()))
+	}
+	if len(bytes.Trim(current, "\x00")) == 0 {
+		return int(time.Now().Unix()), 0, nil, nil
+	}
+	header, err := ParseChunkHeader(current)
+	if err != nil {
+		return birthts, version, nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[mvcc:archiveVersion] ParseChunkHeader %s", err.Error()))
+	}
+	prevVersion = versionChunkKey(chunkKey, header.Version)
+	if err = t.swarmdb.dbchunkstore.StoreKChunk(u, prevVersion, current, t.encrypted); err != nil {
+		return birthts, version, nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[mvcc:archiveVersion] StoreKChunk %s", err.Error()))
+	}
+	return header.Birthts, header.Version + 1, prevVersion, nil
+}
+
+// walkVersions calls fn with the header and value of each version of the row k, newest first, until fn returns false
+func (t *Table) walkVersions(u *SWARMDBUser, k []byte, fn func(header ChunkHeader, value []byte) bool) (err error) {
+	chunkKey := t.GenerateKChunkKey(k)
+	for {
+		chunk, err := t.swarmdb.dbchunkstore.RetrieveChunk(u, chunkKey)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[mvcc:walkVersions] RetrieveChunk %s", err.Error()))
+		}
+		if len(bytes.Trim(chunk, "\x00")) == 0 {
+			return nil
+		}
+		header, err := ParseChunkHeader(chunk)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[mvcc:walkVersions] ParseChunkHeader %s", err.Error()))
+		}
+		if !fn(header, bytes.Trim(chunk[CHUNK_START_CHUNKVAL:CHUNK_END_CHUNKVAL], "\x00")) {
+			return nil
+		}
+		if len(bytes.Trim(header.PrevVersion, "\x00")) == 0 {
+			return nil
+		}
+		chunkKey = header.PrevVersion
+	}
+}
+
+// RowVersions returns the stored versions of the row k, newest first
+func (t *Table) RowVersions(u *SWARMDBUser, k []byte) (versions []RowVersion, err error) {
+	err = t.walkVersions(u, k, func(header ChunkHeader, value []byte) bool {
+		versions = append(versions, RowVersion{Version: header.Version, Updated: time.Unix(0, header.UpdateMs*int64(time.Millisecond)), Writer: common.BytesToAddress(header.Writer), Value: t.decryptColumns(u, t.rowJSON(value))})
+		return true
+	})
+	return versions, err
+}
+
+// GetAsOf reads the version of the row k that was current at asOf.  Rows written before versioning carry no write
+// time and count as current at any time.
+func (t *Table) GetAsOf(u *SWARMDBUser, k []byte, asOf time.Time) (out []byte, ok bool, err error) {
+	return t.getAsOf(u, k, asOf.UnixNano()/int64(time.Millisecond))
+}
+
+func (t *Table) getAsOf(u *SWARMDBUser, k []byte, asOfMs int64) (out []byte, ok bool, err error) {
+	value, ok, err := t.valueAsOf(u, k, asOfMs)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	for _, f := range maskFamilies(t.familyMask) {
+		cells, found, err := t.valueAsOf(u, familyKey(k, f), asOfMs)
+		if err != nil {
+			return nil, false, err
+		}
+		if found {
+			value = t.mergeRowValues(value, cells)
+		}
+	}
+	if t.expiredAt(value, asOfMs) {
+		return nil, false, nil
+	}
+	return t.decryptColumns(u, t.rowJSON(value)), true, nil
+}
+
+// valueAsOf returns the value, as stored, of the version of the K-chunk of k that was current at asOfMs
+func (t *Table) valueAsOf(u *SWARMDBUser, k []byte, asOfMs int64) (out []byte, ok bool, err error) {
+	err = t.walkVersions(u, k, func(header ChunkHeader, value []byte) bool {
+		if header.UpdateMs > asOfMs {
+			return true
+		}
+		out, ok = value, len(value) > 0
+		return false
+	})
+	if err != nil {
+		return nil, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[mvcc:GetAsOf] %s", err.Error()))
+	}
+	return out, ok, nil
+}
+
+// versions answers RT_VERSIONS: the versions of the row d.Key, newest first, or with Rows[0] {"asof": unix
+// milliseconds} the one version current at that time.  Versions with a signed value also name its "signer".
+func (self *SwarmDB) versions(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[mvcc:versions] GetTable %s", err.Error()))
+	}
+	hidden, err := tbl.checkRead(u)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[mvcc:versions] checkRead %s", err.Error()))
+	}
+	if isNil(d.Key) {
+		return resp, &sdbc.SWARMDBError{Message: "[mvcc:versions] missing key", ErrorCode: 433, ErrorMessage: "Versions Request Missing Key"}
+	}
+	primary, err := tbl.getPrimaryColumn()
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[mvcc:versions] getPrimaryColumn %s", err.Error()))
+	}
+	k, err := convertJSONValueToKey(primary.columnType, d.Key)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[mvcc:versions] convertJSONValueToKey %s", err.Error()))
+	}
+	asOfMs := int64(-1)
+	if len(d.Rows) > 0 {
+		asOf, ok := toFloat(d.Rows[0]["asof"])
+		if !ok {
+			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[mvcc:versions] asof %v", d.Rows[0]["asof"]), ErrorCode: 418, ErrorMessage: "Request Invalid: asof must be unix milliseconds"}
+		}
+		asOfMs = int64(asOf)
+	}
+	var decodeErr error
+	err = tbl.walkVersions(u, k, func(header ChunkHeader, value []byte) bool {
+		if asOfMs >= 0 && header.UpdateMs > asOfMs {
+			return true
+		}
+		row, err := tbl.byteArrayToRow(value)
+		if err != nil {
+			decodeErr = err
+			return false
+		}
+		version := sdbc.Row{"version": header.Version, "updated": header.UpdateMs, "writer": common.BytesToAddress(header.Writer).Hex(), "row": maskRow(row, hidden)}
+		if signer, ok, err := rowSigner(header, value); err == nil && ok {
+			version["signer"] = signer.Hex()
+		}
+		resp.Data = append(resp.Data, version)
+		return asOfMs < 0
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[mvcc:versions] %s", err.Error()))
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
@@ -3,6 +3,7 @@ package swarmdb
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/ethereum/go-ethereum/log"
 	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
 	"io/ioutil"
 	"math/big"
@@ -75,8 +76,6 @@ func NewNetstats(config *SWARMDBConfig) (self *Netstats) {
 	ns.SStat["SwapRL"] = big.NewInt(0)  // # of checks received long-term
 	ns.SStat["SwapRAL"] = big.NewInt(0) // amount of checks received long-term
 
-	fmt.Printf("Q: %s\n", ns.SStat)
-
 	t := time.NewTicker(20 * time.Second)
 	go func(ns *Netstats) {
 		for {
@@ -236,7 +235,7 @@ func (self *Netstats) Save() (err error) {
 	if err != nil {
 		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[netstats:Save] WriteFile %s", err.Error()), ErrorCode: 461, ErrorMessage: "Unable to Save Netstats"}
 	} else {
-		fmt.Printf("netstats file written: [%s]\n", netstatsFullPath)
+		log.Debug(fmt.Sprintf("[netstats:Save] written [%s]", netstatsFullPath))
 		return nil
 	}
 }
@@ -0,0 +1,351 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/swarm/pss"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"strings"
+	"sync"
+)
+
+// A Notifier pushes the root hash of every table flushed on this node to subscribed peers over swarm PSS, so read
+// replicas pick up a write within a PSS round trip instead of on their next ENS poll.  A node subscribes to the
+// writers listed in config.PSSPublishers when its Notifier starts; on a root notice it syncs the table from its
+// peers if it has a Syncer and then, on a replica, follows the new root.  Notices only trigger that work: the root
+// hash followed is always the one published to the local ENS, so a forged notice cannot redirect a table.
+//
+// Peers may also watch the rows of a table, or those whose keys start with a prefix (see TableWatch): the Notifier
+// filters the committed Puts and Deletes and pushes each one a watcher selected to it, as long as the address of
+// its public key may read the table, without the restricted columns it may not read.  Changes are encrypted to the
+// watcher's key, so a watch sent for somebody else's key tells the sender nothing.  A watching node receives them on
+// the feed of SubscribeWatchedChanges.
+const (
+	PSS_SUBSCRIBE   = "subscribe"
+	PSS_UNSUBSCRIBE = "unsubscribe"
+	PSS_ROOT        = "root"
+	PSS_WATCH       = "watch"
+	PSS_UNWATCH     = "unwatch"
+	PSS_CHANGE      = "change"
+
+	PSS_WATCHES_MAX = 64 // watches per peer
+)
+
+var PSS_TOPIC = pss.BytesToTopic([]byte("swarmdb"))
+
+// PSS is the part of *pss.Pss a Notifier uses
+type PSS interface {
+	Register(topic *pss.Topic, handler pss.Handler) func()
+	SetPeerPublicKey(pubkey *ecdsa.PublicKey, topic pss.Topic, address *pss.PssAddress) error
+	SendAsym(pubkeyid string, topic pss.Topic, msg []byte) error
+}
+
+// PSSMessage is a message of the swarmdb PSS topic
+type PSSMessage struct {
+	Type      string      `json:"type"`
+	PublicKey string      `json:"publicKey,omitempty"` // of the subscriber, hex of the uncompressed key
+	Owner     string      `json:"owner,omitempty"`
+	Database  string      `json:"database,omitempty"`
+	Table     string      `json:"table,omitempty"`
+	Roothash  string      `json:"roothash,omitempty"`
+	KeyPrefix string      `json:"keyPrefix,omitempty"` // of a watch
+	Op        string      `json:"op,omitempty"`        // of a change, TE_PUT or TE_DELETE
+	Key       interface{} `json:"key,omitempty"`       // of a change
+	Row       sdbc.Row    `json:"row,omitempty"`       // of a change, as put
+}
+
+type Notifier struct {
+	swarmdb    *SwarmDB
+	pss        PSS
+	syncer     *Syncer // nil when the node shares its chunk store and ENS with the writer
+	key        *ecdsa.PrivateKey
+	publishers []string
+
+	mu          sync.Mutex
+	subscribers map[string]bool                // pubkeyids of the peers notified of flushes
+	watches     map[string]map[TableWatch]bool // by pubkeyid of the peers watching
+	changes     event.Feed                     // TableEvents pushed by the publishers watched
+}
+
+// NewNotifier returns a Notifier of swarmdb sending over ps as the key config.PrivateKey; syncer may be nil
+func NewNotifier(swarmdb *SwarmDB, ps PSS, syncer *Syncer, config *SWARMDBConfig) (n *Notifier, err error) {
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(config.PrivateKey, "0x"))
+	if err != nil {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[notifier:NewNotifier] HexToECDSA %s", err.Error()), ErrorCode: 455, ErrorMessage: "Keymanager Unable to Sign Message"}
+	}
+	return &Notifier{swarmdb: swarmdb, pss: ps, syncer: syncer, key: key, publishers: config.PSSPublishers, subscribers: make(map[string]bool), watches: make(map[string]map[TableWatch]bool)}, nil
+}
+
+// pssKeyID is the PSS id of pubkey
+func pssKeyID(pubkey *ecdsa.PublicKey) string {
+	return common.ToHex(crypto.FromECDSAPub(pubkey))
+}
+
+// Subscribers returns the number of peers notified of flushes
+func (n *Notifier) Subscribers() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.subscribers)
+}
+
+// Start registers the PSS handler, subscribes to the publishers and sends a root notice on every flush until stop
+// is called, which also unsubscribes from the publishers
+func (n *Notifier) Start(u *SWARMDBUser) (stop func(), err error) {
+	unregister := n.pss.Register(&PSS_TOPIC, func(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
+		return n.handle(u, msg)
+	})
+	for _, publisher := range n.publishers {
+		if err = n.send(publisher, PSSMessage{Type: PSS_SUBSCRIBE, PublicKey: pssKeyID(&n.key.PublicKey)}); err != nil {
+			unregister()
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[notifier:Start] subscribe %s", err.Error()))
+		}
+	}
+	events := make(chan TableEvent, 128)
+	sub := n.swarmdb.SubscribeTableEvents(events)
+	quit := make(chan struct{})
+	go func() {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case <-quit:
+				return
+			case ev := <-events:
+				if ev.Type == TE_FLUSH {
+					n.notify(ev)
+				} else {
+					n.push(u, ev)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		for _, publisher := range n.publishers {
+			n.send(publisher, PSSMessage{Type: PSS_UNSUBSCRIBE, PublicKey: pssKeyID(&n.key.PublicKey)})
+		}
+		unregister()
+	}, nil
+}
+
+// notify sends the root notice of ev to every subscriber
+func (n *Notifier) notify(ev TableEvent) {
+	n.mu.Lock()
+	subscribers := make([]string, 0, len(n.subscribers))
+	for keyid := range n.subscribers {
+		subscribers = append(subscribers, keyid)
+	}
+	n.mu.Unlock()
+	msg := PSSMessage{Type: PSS_ROOT, Owner: ev.Owner, Database: ev.Database, Table: ev.Table, Roothash: ev.Roothash}
+	for _, keyid := range subscribers {
+		if err := n.send(keyid, msg); err != nil {
+			log.Debug(fmt.Sprintf("[notifier:notify] %s %s", keyid, err.Error()))
+		}
+	}
+}
+
+// Watch asks the node with PSS id publisher to push the changes w selects to this node
+func (n *Notifier) Watch(publisher string, w TableWatch) (err error) {
+	return n.send(publisher, PSSMessage{Type: PSS_WATCH, PublicKey: pssKeyID(&n.key.PublicKey), Owner: w.Owner, Database: w.Database, Table: w.Table, KeyPrefix: w.KeyPrefix})
+}
+
+// Unwatch asks the node with PSS id publisher to stop pushing the changes w selects
+func (n *Notifier) Unwatch(publisher string, w TableWatch) (err error) {
+	return n.send(publisher, PSSMessage{Type: PSS_UNWATCH, PublicKey: pssKeyID(&n.key.PublicKey), Owner: w.Owner, Database: w.Database, Table: w.Table, KeyPrefix: w.KeyPrefix})
+}
+
+// SubscribeWatchedChanges sends the changes pushed to this node for its watches to ch
+func (n *Notifier) SubscribeWatchedChanges(ch chan<- TableEvent) event.Subscription {
+	return n.changes.Subscribe(ch)
+}
+
+// Watches returns the number of watches of peers
+func (n *Notifier) Watches() (count int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, watches := range n.watches {
+		count += len(watches)
+	}
+	return count
+}
+
+// watcher returns the user a watcher with PSS id keyid reads tables as
+func watcher(keyid string) (u *SWARMDBUser, err error) {
+	pubkey, err := pssPublicKey(keyid)
+	if err != nil {
+		return nil, err
+	}
+	return &SWARMDBUser{Address: crypto.PubkeyToAddress(*pubkey).Hex()}, nil
+}
+
+// watch adds or, with add unset, removes the watch of msg by its sender
+func (n *Notifier) watch(u *SWARMDBUser, msg PSSMessage, add bool) (err error) {
+	w := TableWatch{Owner: msg.Owner, Database: msg.Database, Table: msg.Table, KeyPrefix: msg.KeyPrefix}
+	reader, err := watcher(msg.PublicKey)
+	if err != nil {
+		return err
+	}
+	if add {
+		tbl, err := n.swarmdb.GetTable(u, w.Owner, w.Database, w.Table)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[notifier:watch] GetTable %s", err.Error()))
+		}
+		if _, err = tbl.checkRead(reader); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[notifier:watch] checkRead %s", err.Error()))
+		}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	watches := n.watches[msg.PublicKey]
+	if !add {
+		delete(watches, w)
+		if len(watches) == 0 {
+			delete(n.watches, msg.PublicKey)
+		}
+		return nil
+	}
+	if watches == nil {
+		watches = make(map[TableWatch]bool)
+		n.watches[msg.PublicKey] = watches
+	}
+	if len(watches) >= PSS_WATCHES_MAX && !watches[w] {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[notifier:watch] %s has %d watches", msg.PublicKey, len(watches)), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: at most %d watches per peer", PSS_WATCHES_MAX)}
+	}
+	watches[w] = true
+	return nil
+}
+
+// push sends the change ev to every peer with a watch selecting it that may still read its table
+func (n *Notifier) push(u *SWARMDBUser, ev TableEvent) {
+	var keyids []string
+	n.mu.Lock()
+	for keyid, watches := range n.watches {
+		for w := range watches {
+			if w.Matches(&ev) {
+				keyids = append(keyids, keyid)
+				break
+			}
+		}
+	}
+	n.mu.Unlock()
+	if len(keyids) == 0 {
+		return
+	}
+	tbl, err := n.swarmdb.GetTable(u, ev.Owner, ev.Database, ev.Table)
+	if err != nil {
+		log.Debug(fmt.Sprintf("[notifier:push] GetTable %s", err.Error()))
+		return
+	}
+	for _, keyid := range keyids {
+		reader, err := watcher(keyid)
+		if err != nil {
+			continue
+		}
+		// grants may have been revoked since the watch
+		hidden, err := tbl.checkRead(reader)
+		if err != nil {
+			log.Debug(fmt.Sprintf("[notifier:push] %s %s", keyid, err.Error()))
+			continue
+		}
+		msg := PSSMessage{Type: PSS_CHANGE, Owner: ev.Owner, Database: ev.Database, Table: ev.Table, Op: ev.Type, Key: ev.Key}
+		if ev.Row != nil {
+			msg.Row = maskRow(ev.Row, hidden)
+		}
+		if err = n.send(keyid, msg); err != nil {
+			log.Debug(fmt.Sprintf("[notifier:push] %s %s", keyid, err.Error()))
+		}
+	}
+}
+
+// send sends msg to the peer with PSS id keyid
+func (n *Notifier) send(keyid string, msg PSSMessage) (err error) {
+	pubkey, err := pssPublicKey(keyid)
+	if err != nil {
+		return err
+	}
+	if err = n.pss.SetPeerPublicKey(pubkey, PSS_TOPIC, &pss.PssAddress{}); err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[notifier:send] SetPeerPublicKey %s", err.Error()), ErrorCode: 418, ErrorMessage: "Request Invalid: bad PSS peer"}
+	}
+	out, err := json.Marshal(msg)
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[notifier:send] Marshal %s", err.Error()), ErrorCode: 418, ErrorMessage: "Request Invalid: bad PSS message"}
+	}
+	if err = n.pss.SendAsym(keyid, PSS_TOPIC, out); err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[notifier:send] SendAsym %s", err.Error()), ErrorCode: 418, ErrorMessage: "Request Invalid: PSS send failed"}
+	}
+	return nil
+}
+
+func pssPublicKey(keyid string) (pubkey *ecdsa.PublicKey, err error) {
+	pubkey = crypto.ToECDSAPub(common.FromHex(keyid))
+	if pubkey == nil || pubkey.X == nil {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[notifier:pssPublicKey] %s", keyid), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: bad PSS public key [%s]", keyid)}
+	}
+	return pubkey, nil
+}
+
+// handle runs a message of the swarmdb PSS topic
+func (n *Notifier) handle(u *SWARMDBUser, data []byte) (err error) {
+	var msg PSSMessage
+	if err = json.Unmarshal(data, &msg); err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[notifier:handle] Unmarshal %s", err.Error()), ErrorCode: 418, ErrorMessage: "Request Invalid: bad PSS message"}
+	}
+	switch msg.Type {
+	case PSS_SUBSCRIBE, PSS_UNSUBSCRIBE:
+		if _, err = pssPublicKey(msg.PublicKey); err != nil {
+			return err
+		}
+		n.mu.Lock()
+		if msg.Type == PSS_SUBSCRIBE {
+			n.subscribers[msg.PublicKey] = true
+		} else {
+			delete(n.subscribers, msg.PublicKey)
+		}
+		n.mu.Unlock()
+	case PSS_WATCH, PSS_UNWATCH:
+		return n.watch(u, msg, msg.Type == PSS_WATCH)
+	case PSS_ROOT:
+		go n.catchUp(u, msg)
+	case PSS_CHANGE:
+		n.changes.Send(TableEvent{Type: msg.Op, Owner: msg.Owner, Database: msg.Database, Table: msg.Table, Key: msg.Key, Row: msg.Row})
+	default:
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[notifier:handle] type %s", msg.Type), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: unknown PSS message [%s]", msg.Type)}
+	}
+	return nil
+}
+
+// catchUp brings the table of a root notice up to date
+func (n *Notifier) catchUp(u *SWARMDBUser, msg PSSMessage) {
+	if n.syncer != nil {
+		if _, err := n.syncer.SyncTable(context.Background(), u, msg.Owner, msg.Database, msg.Table); err != nil {
+			log.Debug(fmt.Sprintf("[notifier:catchUp] SyncTable %s", err.Error()))
+			return
+		}
+	}
+	if n.swarmdb.IsReplica() {
+		if _, err := n.swarmdb.Follow(u); err != nil {
+			log.Debug(fmt.Sprintf("[notifier:catchUp] Follow %s", err.Error()))
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"encoding/json"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"strings"
+)
+
+// A client may send requests without waiting for the responses of the ones before (see swarmdblib.Pipeline).  The
+// server reads them ahead, up to PIPELINE_DEPTH, while the request before is executing, but executes them one after
+// the other and answers them in the order they came: requests of a connection share its session (transactions,
+// RT_USE defaults, authenticated owners), so each must see the effects of the ones before it.  Responses carry the
+// RequestID of their request, so clients can match them either way.
+//
+// Only RT_COMPRESSION changes how the messages after it are framed; reading ahead stops after one until the server has
+// answered it and switched, see renegotiates.
+const PIPELINE_DEPTH = 16
+
+type inboundMessage struct {
+	line string
+	err  error
+}
+
+// readAhead reads the messages of the session into the returned channel until the connection fails or done is closed
+func (session *TCPSession) readAhead(done <-chan struct{}) <-chan inboundMessage {
+	in := make(chan inboundMessage, PIPELINE_DEPTH)
+	compression := session.compression
+	go func() {
+		defer close(in)
+		for {
+			msg, err := wire.ReadMessage(session.reader, compression)
+			line := strings.TrimSpace(string(msg))
+			select {
+			case in <- inboundMessage{line: line, err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+			if renegotiates(line) {
+				select {
+				case compression = <-session.framing:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return in
+}
+
+// renegotiates reports whether the request line may change the framing of the messages after it; the connection
+// loop hands the compression in effect after answering it to readAhead through session.framing
+func renegotiates(line string) bool {
+	if !strings.HasPrefix(line, "{") || !strings.Contains(line, wire.RT_COMPRESSION) {
+		return false
+	}
+	var req wire.Request
+	return json.Unmarshal([]byte(line), &req) == nil && req.RequestType == wire.RT_COMPRESSION
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/ethereum/go-ethereum/crypto"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// A row chunk is stored under a fixed key, the hash of its owner, database, table and primary key, so in the swarm
+// network every copy of a row would be held by the one neighborhood nearest that address.  With config.Placement
+// set, the chunk store also stores each chunk, rows, index nodes and descriptors alike, at further addresses derived
+// from its key by salting, chosen so that each falls into a different neighborhood (the first PLACEMENT_DEPTH bits
+// of the address): the MinReplication of the writer, or of the table (see replication.go), then maps to storers that
+// are physically distinct.  The addresses are a deterministic sequence, so readers derive them without knowing the
+// writer's replication and fall back to them when the chunk itself is missing.
+const (
+	PLACEMENT_DEPTH        = 8
+	PLACEMENT_MAX_REPLICAS = 1 << PLACEMENT_DEPTH
+)
+
+// Neighborhood returns the neighborhood of the chunk address addr
+func Neighborhood(addr []byte) int {
+	return int(binary.BigEndian.Uint16(addr[0:2]) >> (16 - PLACEMENT_DEPTH))
+}
+
+// ReplicaAddresses returns the addresses of the replicas of the row chunk stored under key, key first: each is in
+// its own neighborhood and the addresses of a smaller replication are a prefix of those of a larger one
+func ReplicaAddresses(key []byte, replication int) (addrs [][]byte) {
+	if replication < 1 {
+		replication = 1
+	} else if replication > PLACEMENT_MAX_REPLICAS {
+		replication = PLACEMENT_MAX_REPLICAS
+	}
+	addrs = append(addrs, key)
+	used := map[int]bool{Neighborhood(key): true}
+	for salt := 1; len(addrs) < replication; salt++ {
+		addr := crypto.Keccak256(key, IntToByte(salt))
+		if used[Neighborhood(addr)] {
+			continue
+		}
+		used[Neighborhood(addr)] = true
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// storeReplicas stores the chunk val, already stored under key, at the other addresses of its placement for the
+// MinReplication of u.  A K-chunk (keyed) is stored at each as a K-chunk of its own, which is audited on its own; any
+// other chunk is copied as stored.
+func (self *DBChunkstore) storeReplicas(u *SWARMDBUser, key []byte, val []byte, encrypted int, keyed bool) (err error) {
+	if !self.placement {
+		return nil
+	}
+	var data []byte
+	if !keyed {
+		if data, err = self.ldb.Get(key, nil); err != nil {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[placement:storeReplicas] Get %s", err.Error()), ErrorCode: 439, ErrorMessage: "Unable to Store Chunk"}
+		}
+	}
+	for _, addr := range ReplicaAddresses(key, u.MinReplication)[1:] {
+		if keyed {
+			_, err = self.storeChunkInDB(u, val, encrypted, addr)
+		} else {
+			err = self.ldb.Put(addr, data, nil)
+		}
+		if err != nil {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[placement:storeReplicas] %x %s", addr, err.Error()), ErrorCode: 439, ErrorMessage: "Unable to Store Chunk"}
+		}
+	}
+	return nil
+}
+
+// retrieveReplica returns the stored form of the chunk of key from the first of its replicas, up to the
+// MaxReplication of u, that holds it, or leveldb.ErrNotFound
+func (self *DBChunkstore) retrieveReplica(u *SWARMDBUser, key []byte) (data []byte, err error) {
+	if !self.placement {
+		return nil, leveldb.ErrNotFound
+	}
+	for _, addr := range ReplicaAddresses(key, u.MaxReplication)[1:] {
+		if data, err = self.ldb.Get(addr, nil); err != leveldb.ErrNotFound {
+			return data, err
+		}
+	}
+	return nil, leveldb.ErrNotFound
+}
+
+// RowPlacement returns the addresses the row k is stored at by a write of u
+func (t *Table) RowPlacement(u *SWARMDBUser, k []byte) (addrs [][]byte) {
+	chunkKey := t.GenerateKChunkKey(k)
+	if !t.swarmdb.placement {
+		return [][]byte{chunkKey}
+	}
+	return ReplicaAddresses(chunkKey, t.replicatedBy(u).MinReplication)
+}
@@ -0,0 +1,290 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+)
+
+// SELECT, UPDATE and DELETE find the rows of their WHERE clause, a predicate or a conjunction of predicates ("p1 AND
+// p2 AND ..."), one of three ways: with "=" on the primary key they look the row up; with bounds (<, <=, >, >=) on the
+// primary key they walk the primary index from the greatest lower bound to the least upper bound, the intersection of
+// the ranges of all the bounds; otherwise they scan the primary index.  Either way, every row fetched is then filtered
+// with all the predicates.  Secondary indexes keep one primary key per value, the row last written with it, so they
+// cannot tell every row with a value and are never intersected: a predicate on any other column filters the rows the
+// primary key selects, and with none on the primary key every row of the table is fetched, which is slow, as every
+// chunk read counts against the query budget, but correct.  "EXPLAIN <statement>" answers the plan of the statement,
+// with a warning for every scan, instead of running it.
+const (
+	PLAN_PRIMARY_GET   = "primary key lookup"
+	PLAN_PRIMARY_RANGE = "primary key range"
+	PLAN_FULL_SCAN     = "full scan"
+)
+
+// unindexed stands in for the index of an IT_NONE column, which is kept in its rows only
+type unindexed struct{}
+
+func (unindexed) GetRootHash() []byte                                           { return make([]byte, 32) }
+func (unindexed) Insert(u *SWARMDBUser, key []byte, value []byte) (bool, error) { return false, nil }
+func (unindexed) Put(u *SWARMDBUser, key []byte, value []byte) (bool, error)    { return false, nil }
+func (unindexed) Get(u *SWARMDBUser, key []byte) ([]byte, bool, error)          { return nil, false, nil }
+func (unindexed) Delete(u *SWARMDBUser, key []byte) (bool, error)               { return false, nil }
+func (unindexed) StartBuffer(u *SWARMDBUser) (bool, error)                      { return true, nil }
+func (unindexed) FlushBuffer(u *SWARMDBUser) (bool, error)                      { return true, nil }
+func (unindexed) Close(u *SWARMDBUser) (bool, error)                            { return true, nil }
+func (unindexed) Print(u *SWARMDBUser) []string                                 { return nil }
+
+// QueryPlan is how a statement finds its rows
+type QueryPlan struct {
+	Table    string
+	Access   string // PLAN_PRIMARY_GET, PLAN_PRIMARY_RANGE or PLAN_FULL_SCAN
+	Column   string // filtered on
+	Estimate int    // rows the WHERE clause selects, from the histograms of histogram.go; -1 without one
+	Warnings []string
+
+	key       []byte // looked up, with PLAN_PRIMARY_GET
+	lower     []byte // greatest lower bound of the primary key, with PLAN_PRIMARY_RANGE; nil for none
+	upper     []byte // least upper bound of the primary key, with PLAN_PRIMARY_RANGE; nil for none
+	upperIncl bool   // whether upper is in the range
+}
+
+// Predicates lists the predicates of the clause, which must all match
+func (where Where) Predicates() (predicates []Where) {
+	first := where
+	first.And = nil
+	return append([]Where{first}, where.And...)
+}
+
+// planQuery plans query on t
+func (t *Table) planQuery(query *QueryOption) (plan *QueryPlan) {
+	where := query.Where
+	plan = &QueryPlan{Table: t.tableName, Access: PLAN_FULL_SCAN, Column: where.Left, Estimate: -1}
+	if rows, ok := t.estimateRows(where); ok {
+		plan.Estimate = rows
+	}
+	predicates := where.Predicates()
+	t.planPrimaryKey(plan, predicates)
+	if plan.Access == PLAN_PRIMARY_GET {
+		return plan
+	}
+	for _, p := range predicates {
+		c, ok := t.columns[p.Left]
+		switch {
+		case len(p.Left) == 0:
+			if plan.Access == PLAN_FULL_SCAN {
+				plan.Warnings = append(plan.Warnings, "no column filtered: every row of the table is fetched")
+			}
+		case !ok:
+		case c.primary > 0:
+			if plan.Access == PLAN_FULL_SCAN {
+				plan.Warnings = append(plan.Warnings, fmt.Sprintf("[%s] on the primary key [%s]: every row of the table is fetched and filtered", p.Operator, p.Left))
+			}
+		case plan.Access == PLAN_PRIMARY_RANGE:
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("column [%s] is filtered on the rows of the primary key range", p.Left))
+		case c.indexType == sdbc.IT_NONE:
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("column [%s] has no index: every row of the table is fetched and filtered", p.Left))
+		default:
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("the index on [%s] is not used: every row of the table is fetched and filtered", p.Left))
+		}
+	}
+	return plan
+}
+
+// planPrimaryKey picks PLAN_PRIMARY_GET for an equality on the primary key, or else PLAN_PRIMARY_RANGE for the
+// intersection of the bounds on an ordered primary key
+func (t *Table) planPrimaryKey(plan *QueryPlan, predicates []Where) {
+	c, ok := t.columns[t.primaryColumnName]
+	if !ok {
+		return
+	}
+	cmp := keyComparator(c.columnType)
+	for _, p := range predicates {
+		if p.Left != t.primaryColumnName {
+			continue
+		}
+		k, err := convertJSONValueToKey(c.columnType, p.Right)
+		if err != nil {
+			continue // applyWhere reports it
+		}
+		k = padKey(k)
+		switch p.Operator {
+		case "=":
+			plan.Access, plan.Column, plan.key = PLAN_PRIMARY_GET, p.Left, k
+			return
+		case ">", ">=":
+			if plan.lower == nil || cmp(k, plan.lower) > 0 {
+				plan.lower = k
+			}
+		case "<":
+			if plan.upper == nil || cmp(k, plan.upper) <= 0 {
+				plan.upper, plan.upperIncl = k, false
+			}
+		case "<=":
+			if plan.upper == nil || cmp(k, plan.upper) < 0 {
+				plan.upper, plan.upperIncl = k, true
+			}
+		}
+	}
+	if (plan.lower != nil || plan.upper != nil) && c.indexType == sdbc.IT_BPLUSTREE {
+		plan.Access, plan.Column = PLAN_PRIMARY_RANGE, t.primaryColumnName
+	}
+}
+
+// Row is the EXPLAIN answer: {"table", "access", "column", "estimate", "warnings"}
+func (plan *QueryPlan) Row() (row sdbc.Row) {
+	row = sdbc.NewRow()
+	row["table"] = plan.Table
+	row["access"] = plan.Access
+	row["column"] = plan.Column
+	row["estimate"] = plan.Estimate
+	warnings := make([]string, len(plan.Warnings))
+	copy(warnings, plan.Warnings)
+	row["warnings"] = warnings
+	return row
+}
+
+// Explain returns the plan of query without running it
+func (self *SwarmDB) Explain(u *SWARMDBUser, query *QueryOption) (plan *QueryPlan, err error) {
+	tbl, err := self.GetTable(u, query.Owner, query.Database, query.Table)
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[plan:Explain] GetTable %s", err.Error()))
+	}
+	return self.statements.plan(tbl, query), nil
+}
+
+// queryRows returns the rows of t the WHERE clause of query selects, as planQuery plans or planned for the statement
+func (self *SwarmDB) queryRows(u *SWARMDBUser, t *Table, query *QueryOption) (rows []sdbc.Row, err error) {
+	err = self.queryEach(u, t, query, func(row sdbc.Row) (bool, error) {
+		rows = append(rows, row)
+		return true, nil
+	})
+	return rows, err
+}
+
+// queryEach calls fn with each row of t the WHERE clause of query selects, as it is fetched, until fn returns false or
+// an error
+func (self *SwarmDB) queryEach(u *SWARMDBUser, t *Table, query *QueryOption, fn func(row sdbc.Row) (bool, error)) (err error) {
+	plan := self.statements.plan(t, query)
+	for _, warning := range plan.Warnings {
+		log.Debug(fmt.Sprintf("[plan:queryEach] %s", warning), "trace", u.TraceID(), "table", t.tableName)
+	}
+	where := func(row sdbc.Row) (bool, error) {
+		matched, err := t.applyWhere([]sdbc.Row{row}, query.Where)
+		if err != nil {
+			return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[plan:queryEach] applyWhere %s", err.Error()))
+		}
+		for _, row := range matched {
+			if more, err := fn(row); err != nil || !more {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	switch plan.Access {
+	case PLAN_PRIMARY_GET:
+		byteRow, ok, err := t.Get(u, plan.key)
+		if err != nil || !ok {
+			return err
+		}
+		row, err := t.byteArrayToRow(byteRow)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[plan:queryEach] byteArrayToRow %s", err.Error()))
+		}
+		_, err = where(row)
+		return err
+	case PLAN_PRIMARY_RANGE:
+		if err = t.scanPlanRange(u, plan, query.Ascending, where); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[plan:queryEach] scanPlanRange %s", err.Error()))
+		}
+	default:
+		if err = t.scanRows(u, query.Ascending, where); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[plan:queryEach] scanRows %s", err.Error()))
+		}
+	}
+	return nil
+}
+
+// scanPlanRange calls fn with the rows of the primary key range of plan, in ascending or descending key order, until
+// fn returns false or an error
+func (t *Table) scanPlanRange(u *SWARMDBUser, plan *QueryPlan, ascending int, fn func(row sdbc.Row) (bool, error)) (err error) {
+	cmp := keyComparator(t.columns[t.primaryColumnName].columnType)
+	end := plan.upper
+	if plan.upperIncl {
+		end = nil // ScanRange excludes its end, so the keys above upper are skipped here
+	}
+	var fnErr error
+	err = t.ScanRange(u, plan.lower, end, ascending, func(k []byte, row sdbc.Row) bool {
+		if plan.upperIncl && cmp(k, plan.upper) > 0 {
+			return ascending != 1
+		}
+		more, err := fn(row)
+		fnErr = err
+		return more && err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// applyConjunction keeps the rows that match every predicate of where
+func (t *Table) applyConjunction(rawRows []sdbc.Row, where Where) (outRows []sdbc.Row, err error) {
+	outRows = rawRows
+	for _, p := range where.Predicates() {
+		matched, err := t.applyWhere(outRows, p)
+		if err != nil {
+			return nil, err
+		}
+		outRows = outRows[:0:0]
+		for _, row := range matched {
+			if len(row) > 0 {
+				outRows = append(outRows, row)
+			}
+		}
+	}
+	return outRows, nil
+}
+
+// checkQuery checks that u may run query on t, and that the columns it names are columns of t; hidden are the columns
+// of a SELECT that u may not read, to be masked in its rows
+func (t *Table) checkQuery(u *SWARMDBUser, query *QueryOption) (hidden map[string]bool, tblInfo map[string]sdbc.Column, err error) {
+	if err = t.checkAccess(u, queryPermission(query)); err != nil {
+		return nil, nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[plan:checkQuery] checkAccess %s", err.Error()))
+	}
+	if query.Type == "Select" {
+		if hidden, err = t.checkRead(u); err != nil {
+			return nil, nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[plan:checkQuery] checkRead %s", err.Error()))
+		}
+		for _, p := range query.Where.Predicates() {
+			if hidden[p.Left] {
+				// filtering on a column the caller may not read would reveal its values
+				return nil, nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[plan:checkQuery] WHERE on restricted column [%s]", p.Left), ErrorCode: 490, ErrorMessage: fmt.Sprintf("Access Denied to Column [%s]", p.Left)}
+			}
+		}
+	}
+	tblInfo, err = t.DescribeTable()
+	if err != nil {
+		return nil, nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[plan:checkQuery] DescribeTable %s", err.Error()))
+	}
+	for _, reqCol := range query.RequestColumns {
+		if _, ok := tblInfo[reqCol.ColumnName]; !ok {
+			return nil, nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[plan:checkQuery] Requested col [%s] does not exist in table [%+v]", reqCol.ColumnName, tblInfo), ErrorCode: 404, ErrorMessage: fmt.Sprintf("Column Does Not Exist in table definition: [%s]", reqCol.ColumnName)}
+		}
+	}
+	return hidden, tblInfo, nil
+}
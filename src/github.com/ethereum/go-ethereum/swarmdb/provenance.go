@@ -0,0 +1,118 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"time"
+)
+
+// The header signature of a K-chunk covers its metadata only.  With config.SignRows the node also signs the row
+// value, bound to the chunk key and the write time, into [CHUNK_START_ROWSIG:CHUNK_END_ROWSIG] of the header, which
+// the header signature then covers as well.  Anyone holding the (decrypted) chunk, e.g. a light client that checked
+// it against a root hash, can thus recover the address that wrote the row with VerifyRowChunk.
+
+// RowProvenance tells who wrote the current version of a row, see Table.Provenance
+type RowProvenance struct {
+	Version int
+	Updated time.Time
+	Writer  common.Address // the address the write was made as, from the chunk header
+	Signed  bool           // the row value carries a signature
+	Signer  common.Address // the address that signed the row value, when Signed
+}
+
+// rowSigningHash is the hash a row signature signs: the chunk key, the write time and the value
+func rowSigningHash(chunkKey []byte, updateMs []byte, value []byte) []byte {
+	payload := make([]byte, 0, len(chunkKey)+len(updateMs)+len(value))
+	payload = append(payload, chunkKey...)
+	payload = append(payload, updateMs...)
+	payload = append(payload, value...)
+	return SignHash(payload)
+}
+
+// signRow signs value into the header metadata of its K-chunk, which must hold the chunk key and write time
+func (t *Table) signRow(metadata []byte, value []byte) (err error) {
+	hash := rowSigningHash(metadata[CHUNK_START_KEY:CHUNK_END_KEY], metadata[CHUNK_START_UPDATEMS:CHUNK_END_UPDATEMS], value)
+	sig, err := t.swarmdb.dbchunkstore.GetKeyManager().SignMessage(hash)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[provenance:signRow] SignMessage %s", err.Error()))
+	}
+	copy(metadata[CHUNK_START_ROWSIG:CHUNK_END_ROWSIG], sig)
+	return nil
+}
+
+// VerifyRowChunk returns the address that signed the row value of a decrypted K-chunk; ok is false when the row
+// was written without a signature
+func VerifyRowChunk(chunk []byte) (signer common.Address, ok bool, err error) {
+	if len(chunk) < CHUNK_SIZE {
+		return signer, false, &sdbc.SWARMDBError{Message: fmt.Sprintf("[provenance:VerifyRowChunk] chunk of %d bytes", len(chunk)), ErrorCode: 439, ErrorMessage: "Unable to Parse Chunk"}
+	}
+	header, err := ParseChunkHeader(chunk)
+	if err != nil {
+		return signer, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[provenance:VerifyRowChunk] ParseChunkHeader %s", err.Error()))
+	}
+	return rowSigner(header, bytes.TrimRight(chunk[CHUNK_START_CHUNKVAL:CHUNK_END_CHUNKVAL], "\x00"))
+}
+
+// rowSigner recovers the address that signed value, the row value of the chunk with header
+func rowSigner(header ChunkHeader, value []byte) (signer common.Address, ok bool, err error) {
+	if len(bytes.Trim(header.RowSig, "\x00")) == 0 {
+		return signer, false, nil
+	}
+	hash := rowSigningHash(header.Key, IntToByte(int(header.UpdateMs)), value)
+	sig := make([]byte, len(header.RowSig))
+	copy(sig, header.RowSig)
+	if sig[64] > 4 {
+		sig[64] -= 27
+	}
+	pubKey, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return signer, false, &sdbc.SWARMDBError{Message: fmt.Sprintf("[provenance:rowSigner] SigToPub %s", err.Error()), ErrorCode: 420, ErrorMessage: "Invalid Signature: Unable to Retrieve Public Key"}
+	}
+	return crypto.PubkeyToAddress(*pubKey), true, nil
+}
+
+// Provenance returns the writer of the current version of the row k and, when the row value is signed, the
+// address the signature recovers to.  A value altered after it was signed recovers to some other address.
+func (t *Table) Provenance(u *SWARMDBUser, k []byte) (p RowProvenance, ok bool, err error) {
+	if t.IsSharded() {
+		shard, err := t.shardFor(u, k)
+		if err != nil {
+			return p, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[provenance:Provenance] shardFor %s", err.Error()))
+		}
+		return shard.Provenance(u, k)
+	}
+	chunk, err := t.swarmdb.dbchunkstore.RetrieveChunk(u, t.GenerateKChunkKey(k))
+	if err != nil {
+		return p, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[provenance:Provenance] RetrieveChunk %s", err.Error()))
+	}
+	if len(bytes.Trim(chunk, "\x00")) == 0 {
+		return p, false, nil
+	}
+	header, err := ParseChunkHeader(chunk)
+	if err != nil {
+		return p, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[provenance:Provenance] ParseChunkHeader %s", err.Error()))
+	}
+	p = RowProvenance{Version: header.Version, Updated: time.Unix(0, header.UpdateMs*int64(time.Millisecond)), Writer: common.BytesToAddress(header.Writer)}
+	if p.Signer, p.Signed, err = VerifyRowChunk(chunk); err != nil {
+		return p, true, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[provenance:Provenance] VerifyRowChunk %s", err.Error()))
+	}
+	return p, true, nil
+}
@@ -21,12 +21,18 @@ import (
 	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
 	"github.com/xwb1989/sqlparser"
 	"strconv"
+	"strings"
 )
 
 //at the moment, only parses a query with a single un-nested where clause, i.e.
 //'Select name, age from contacts where email = "rodney@wolk.com"'
 //TODO: nested where clauses
 func ParseQuery(rawQuery string) (query QueryOption, err error) {
+	if fields := strings.Fields(rawQuery); len(fields) > 1 && strings.ToUpper(fields[0]) == "EXPLAIN" {
+		query, err = ParseQuery(strings.TrimSpace(rawQuery)[len(fields[0]):])
+		query.Explain = true
+		return query, err
+	}
 	stmt, err := sqlparser.Parse(rawQuery)
 	if err != nil {
 		return query, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:ParseQuery] Parse [%v]", err), ErrorCode: 401, ErrorMessage: fmt.Sprintf("SQL Parsing error: [%s]", err.Error())}
@@ -68,7 +74,7 @@ func ParseQuery(rawQuery string) (query QueryOption, err error) {
 				return query, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:ParseQuery] parseWhere [%s]", rawQuery))
 			}
 		} else if stmt.Where.Type == sqlparser.HavingStr { //Having
-			fmt.Printf("type: %s\n", stmt.Where.Type)
+			queryLog.Debug("unsupported where clause", "type", stmt.Where.Type)
 			//TODO: fill in having
 			return query, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:ParseQuery] Parse Having Clause Not currently supported"), ErrorCode: 401, ErrorMessage: fmt.Sprintf("SQL Parsing error: [HAVING clause not currently supported]", err.Error())}
 		}
@@ -204,7 +210,7 @@ func ParseQuery(rawQuery string) (query QueryOption, err error) {
 
 		//Targets
 		for _, t := range stmt.Targets {
-			fmt.Printf("Targets: %s\n", t.Name)
+			queryLog.Trace("target", "name", t.Name)
 		}
 
 		//Where
@@ -256,9 +262,7 @@ func parseWhere(expr sqlparser.Expr) (where Where, err error) {
 		where.Right = readable(expr.Right)
 		where.Operator = "OR" //should be const
 	case *sqlparser.AndExpr:
-		where.Left = readable(expr.Left)
-		where.Right = readable(expr.Right)
-		where.Operator = "AND" //shoud be const
+		return parseConjunction(expr)
 	case *sqlparser.IsExpr:
 		where.Right = readable(expr.Expr)
 		where.Operator = expr.Operator
@@ -278,6 +282,25 @@ func parseWhere(expr sqlparser.Expr) (where Where, err error) {
 	return where, err
 }
 
+// parseConjunction parses "p1 AND p2 AND ..." into p1 with the other predicates in And; every predicate must be a
+// comparison on a column
+func parseConjunction(expr *sqlparser.AndExpr) (where Where, err error) {
+	var predicates []Where
+	for _, e := range []sqlparser.Expr{expr.Left, expr.Right} {
+		p, err := parseWhere(e)
+		if err != nil {
+			return where, err
+		}
+		if p.Operator == "OR" {
+			return where, &sdbc.SWARMDBError{Message: fmt.Sprintf("[query:parseConjunction] OR in a conjunction [%s]", readable(expr)), ErrorCode: 401, ErrorMessage: "SQL Parsing error: [OR within AND not currently supported]"}
+		}
+		predicates = append(predicates, p.Predicates()...)
+	}
+	where = predicates[0]
+	where.And = predicates[1:]
+	return where, nil
+}
+
 func trimQuotes(s string) string {
 	if len(s) > 0 && s[0] == '\'' {
 		s = s[1:]
@@ -34,10 +34,10 @@ func TestParseQuery(t *testing.T) {
 		`insert`:       `insert into contacts(email, name, age) values("bertie@gmail.com","Bertie Basset", 7)`,
 		`update`:       `UPDATE contacts set age = 8, name = "Bertie B" where email = "bertie@gmail.com"`,
 		`delete`:       `delete from contacts where age >= 25`,
+		`and`:          `select name, age from contacts where email = 'rodney@wolk.com' and age = 38`,
 		//`precedence`:   `select * from a where a=b and c=d or e=f`,
 		//`like`:         `select name, age from contacts where email like '%wolk%'`,
 		//`is`:           `select name, age from contacts where age is not null`,
-		//`and`:          `select name, age from contacts where email = 'rodney@wolk.com' and age = 38`,
 		//`or`:           `select name, age from contacts where email = 'rodney@wolk.com' or age = 35`,
 		//`groupby`:      `select name, age from contacts where age >= 35 group by email`,
 	}
@@ -82,6 +82,18 @@ func TestParseQuery(t *testing.T) {
 		Where:     swarmdb.Where{Left: "email", Right: "rodney@wolk.com", Operator: "!="},
 		Ascending: 1,
 	}
+	expected[`and`] = swarmdb.QueryOption{
+		Type:  "Select",
+		Table: "contacts",
+		RequestColumns: []sdbc.Column{
+			sdbc.Column{ColumnName: "name"},
+			sdbc.Column{ColumnName: "age"},
+		},
+		Where: swarmdb.Where{Left: "email", Right: "rodney@wolk.com", Operator: "=", And: []swarmdb.Where{
+			swarmdb.Where{Left: "age", Right: "38", Operator: "="},
+		}},
+		Ascending: 1,
+	}
 	expected[`insert`] = swarmdb.QueryOption{
 		Type:  "Insert",
 		Table: "contacts",
@@ -0,0 +1,141 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"container/list"
+	"encoding/hex"
+	"encoding/json"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"sync"
+)
+
+// A SELECT reads a Snapshot of the published root hash of its table, and the root hash identifies the table's
+// contents, so its result can be kept under the parsed query and the root hash and served again until a flush
+// publishes a new root.  Three exceptions read more than the root hash holds and bypass the cache: tables with
+// buffered writes, whose rows a snapshot sees as of now, sessions reading their own buffer (see consistency.go) and
+// tables whose rows expire, which lose rows as time passes (see expiry.go).
+// Rows are cached before restricted columns are masked, so every caller gets its own view of a shared entry.
+const QUERY_CACHE_ENTRIES = 256 // results kept when config.QueryCache is 0
+
+type queryCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]*list.Element
+	order   *list.List // of *queryCacheEntry, most recently used first
+	hits    int
+	misses  int
+}
+
+type queryCacheEntry struct {
+	key  string
+	rows []sdbc.Row
+}
+
+// newQueryCache returns a cache of max results, or nil, which caches nothing, when max is negative
+func newQueryCache(max int) *queryCache {
+	if max < 0 {
+		return nil
+	}
+	if max == 0 {
+		max = QUERY_CACHE_ENTRIES
+	}
+	return &queryCache{max: max, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// queryCacheKey returns the key of the result of query on t for u; ok is false when the result may not be cached
+func queryCacheKey(u *SWARMDBUser, t *Table, query *QueryOption) (key string, ok bool) {
+	if query.Type != "Select" || t.IsSharded() || u.readsBuffer(t) {
+		return "", false
+	}
+	if _, expires := t.ExpiryColumn(); expires {
+		return "", false
+	}
+	t.mu.Lock()
+	roothash, dirty := t.roothash, t.dirtyMutations > 0
+	t.mu.Unlock()
+	if dirty || !valid_hashid(roothash) {
+		return "", false
+	}
+	normalized, err := json.Marshal(query)
+	if err != nil {
+		return "", false
+	}
+	return t.swarmdb.GetTableKey(t.Owner, t.Database, t.tableName) + "|" + hex.EncodeToString(roothash) + "|" + string(normalized), true
+}
+
+// get returns a copy of the rows cached under key
+func (c *queryCache) get(key string) (rows []sdbc.Row, ok bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.order.MoveToFront(e)
+	return copyRows(e.Value.(*queryCacheEntry).rows), true
+}
+
+// put caches a copy of rows under key, evicting the least recently used result when full
+func (c *queryCache) put(key string, rows []sdbc.Row) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*queryCacheEntry).rows = copyRows(rows)
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&queryCacheEntry{key: key, rows: copyRows(rows)})
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*queryCacheEntry).key)
+	}
+}
+
+// stats adds the entry count, hits and misses of the cache to row
+func (c *queryCache) stats(row sdbc.Row) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	row["queryCacheEntries"] = c.order.Len()
+	row["queryCacheHits"] = c.hits
+	row["queryCacheMisses"] = c.misses
+}
+
+func copyRows(rows []sdbc.Row) (out []sdbc.Row) {
+	if rows == nil {
+		return nil
+	}
+	out = make([]sdbc.Row, len(rows))
+	for i, row := range rows {
+		out[i] = sdbc.NewRow()
+		for k, v := range row {
+			out[i][k] = v
+		}
+	}
+	return out
+}
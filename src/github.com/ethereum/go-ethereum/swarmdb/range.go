@@ -0,0 +1,281 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"io"
+)
+
+// Range scans iterate the primary key B+tree over [start, end) instead of materializing the whole table.
+// Pages are resumed with an opaque continuation token (see scanToken) holding the primary key to resume at
+// (ascending: first key of the next page, descending: exclusive upper bound) and the root hash of the primary index
+// and the time the first page read, so that later pages read the same Snapshot even when the table is flushed
+// between them.  The root only chooses which keys of the table are read, with the ACL of the table, so a forged
+// token reveals nothing a Get could not.  Scans of a session's buffered writes (see consistency.go) and of sharded
+// tables are not pinned to a root and see the writes made between pages.
+const (
+	SCAN_RANGE_DEFAULT_LIMIT = 100
+	SCAN_RANGE_MAX_LIMIT     = 10000
+)
+
+// scanToken is the decoded form of a continuation token, which is the base64url of the direction ('a' or 'd'),
+// the first 4 bytes of the keccak of the table key, asOfMs (8 bytes, big endian), the length of root (0 or 32)
+// and root, then key
+type scanToken struct {
+	ascending int
+	asOfMs    int64  // the row versions the scan reads, 0 for the live table
+	root      []byte // the primary index root the scan is pinned to
+	key       []byte
+}
+
+func (tok *scanToken) encode(tableKey string) string {
+	buf := []byte{'d'}
+	if tok.ascending == 1 {
+		buf[0] = 'a'
+	}
+	buf = append(buf, crypto.Keccak256([]byte(tableKey))[0:4]...)
+	asOf := make([]byte, 8)
+	binary.BigEndian.PutUint64(asOf, uint64(tok.asOfMs))
+	buf = append(buf, asOf...)
+	buf = append(buf, byte(len(tok.root)))
+	buf = append(buf, tok.root...)
+	buf = append(buf, tok.key...)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// decodeScanToken decodes token, which must continue an ascending or descending scan of the table tableKey
+func decodeScanToken(token string, tableKey string, ascending int) (tok scanToken, err error) {
+	invalid := &sdbc.SWARMDBError{Message: fmt.Sprintf("[range:decodeScanToken] invalid token [%s]", token), ErrorCode: 418, ErrorMessage: "Request Invalid: continuation token does not belong to this scan"}
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(buf) < 14 {
+		return tok, invalid
+	}
+	direction := byte('d')
+	if ascending == 1 {
+		direction = 'a'
+	}
+	if buf[0] != direction || !bytes.Equal(buf[1:5], crypto.Keccak256([]byte(tableKey))[0:4]) {
+		return tok, invalid
+	}
+	tok.ascending = ascending
+	tok.asOfMs = int64(binary.BigEndian.Uint64(buf[5:13]))
+	n := int(buf[13])
+	if (n != 0 && n != 32) || len(buf) < 14+n || tok.asOfMs < 0 {
+		return tok, invalid
+	}
+	if n > 0 {
+		tok.root = buf[14 : 14+n]
+	}
+	tok.key = buf[14+n:]
+	return tok, nil
+}
+
+// keyComparator is the ordering of the B+tree index for columnType, see NewBPlusTreeDB
+func keyComparator(columnType sdbc.ColumnType) Cmp {
+	switch columnType {
+	case sdbc.CT_FLOAT:
+		return cmpFloat
+	case sdbc.CT_STRING:
+		return cmpString
+	case sdbc.CT_INTEGER:
+		return cmpInt64
+	}
+	return cmpBytes
+}
+
+func padKey(k []byte) []byte {
+	if k == nil {
+		return nil
+	}
+	padded := make([]byte, K_SIZE)
+	copy(padded, k)
+	return padded
+}
+
+// ScanRange calls fn for each row whose primary key is in [start, end) (nil bounds are open), in ascending or
+// descending key order, until fn returns false
+func (t *Table) ScanRange(u *SWARMDBUser, start []byte, end []byte, ascending int, fn func(k []byte, row sdbc.Row) bool) (err error) {
+	log.Debug("[range:ScanRange]", "trace", u.TraceID(), "table", t.tableName, "start", fmt.Sprintf("%x", start), "end", fmt.Sprintf("%x", end))
+	if t.IsSharded() {
+		return t.scanRangeShards(u, start, end, ascending, fn)
+	}
+	column, err := t.getPrimaryColumn()
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[range:ScanRange] getPrimaryColumn %s", err.Error()))
+	}
+	c, ok := column.dbaccess.(OrderedDatabase)
+	if !ok {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[range:ScanRange] column [%s] is not ordered", t.primaryColumnName), ErrorCode: 431, ErrorMessage: fmt.Sprintf("Scans on Column [%s] not unsupported due to indextype", t.primaryColumnName)}
+	}
+	cmp := keyComparator(column.columnType)
+	start, end = padKey(start), padKey(end)
+
+	var res OrderedDatabaseCursor
+	switch {
+	case ascending == 1 && start == nil:
+		res, err = c.SeekFirst(u)
+	case ascending == 1:
+		res, _, err = c.Seek(u, start)
+	case end == nil:
+		res, err = c.SeekLast(u)
+	default:
+		res, _, err = c.Seek(u, end)
+	}
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[range:ScanRange] Seek %s", err.Error()))
+	}
+
+	for {
+		if err = u.checkDeadline("range:ScanRange"); err != nil {
+			return err
+		}
+		var k []byte
+		if ascending == 1 {
+			k, _, err = res.Next(u)
+		} else {
+			k, _, err = res.Prev(u)
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[range:ScanRange] cursor %s", err.Error()))
+		}
+		if end != nil && cmp(k, end) >= 0 {
+			if ascending == 1 {
+				return nil
+			}
+			continue // Seek(end) positions the descending cursor on end itself
+		}
+		if start != nil && cmp(k, start) < 0 {
+			if ascending == 1 {
+				continue
+			}
+			return nil
+		}
+		out, ok, err := t.Get(u, k)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[range:ScanRange] Get %s", err.Error()))
+		}
+		if !ok {
+			continue
+		}
+		row, err := t.byteArrayToRow(out)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[range:ScanRange] byteArrayToRow %s", err.Error()))
+		}
+		if !fn(k, row) {
+			return nil
+		}
+	}
+}
+
+// ScanRange returns one page of a range scan of the table and the token of the next page ("" after the last page)
+func (self *SwarmDB) ScanRange(u *SWARMDBUser, owner string, database string, tableName string, r *wire.ScanRange) (resp sdbc.SWARMDBResponse, nextToken string, err error) {
+	tbl, err := self.GetTable(u, owner, database, tableName)
+	if err != nil {
+		return resp, nextToken, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[range:ScanRange] GetTable %s", err.Error()))
+	}
+	hidden, err := tbl.checkRead(u)
+	if err != nil {
+		return resp, nextToken, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[range:ScanRange] checkRead %s", err.Error()))
+	}
+	tableKey := self.GetTableKey(owner, database, tableName)
+	var tok scanToken
+	if len(r.Token) > 0 {
+		if tok, err = decodeScanToken(r.Token, tableKey, r.Ascending); err != nil {
+			return resp, nextToken, err
+		}
+	}
+	if tok.asOfMs > 0 {
+		tbl, err = tbl.snapshotAt(u, tok.root, tok.asOfMs)
+	} else {
+		tbl, err = tbl.readView(u)
+	}
+	if err != nil {
+		return resp, nextToken, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[range:ScanRange] readView %s", err.Error()))
+	}
+	column, err := tbl.getPrimaryColumn()
+	if err != nil {
+		return resp, nextToken, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[range:ScanRange] getPrimaryColumn %s", err.Error()))
+	}
+
+	var start, end []byte
+	if r.Start != nil {
+		if start, err = convertJSONValueToKey(column.columnType, r.Start); err != nil {
+			return resp, nextToken, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[range:ScanRange] start %s", err.Error()))
+		}
+	}
+	if r.End != nil {
+		if end, err = convertJSONValueToKey(column.columnType, r.End); err != nil {
+			return resp, nextToken, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[range:ScanRange] end %s", err.Error()))
+		}
+	}
+	if len(r.Token) > 0 {
+		// resume within the bounds of the scan, whatever key the token holds
+		cmp := keyComparator(column.columnType)
+		if r.Ascending == 1 && (start == nil || cmp(padKey(tok.key), padKey(start)) > 0) {
+			start = tok.key
+		} else if r.Ascending != 1 && (end == nil || cmp(padKey(tok.key), padKey(end)) < 0) {
+			end = tok.key
+		}
+	}
+	// the next page reads the same versions and, unless the scan reads the live table, the same primary index
+	next := scanToken{ascending: r.Ascending, asOfMs: tbl.asOfMs}
+	if tbl.snapshot && !tbl.IsSharded() {
+		next.root = column.dbaccess.GetRootHash()
+	}
+	limit := r.Limit
+	if limit <= 0 {
+		limit = SCAN_RANGE_DEFAULT_LIMIT
+	} else if limit > SCAN_RANGE_MAX_LIMIT {
+		limit = SCAN_RANGE_MAX_LIMIT
+	}
+
+	var lastKey []byte
+	err = tbl.ScanRange(u, start, end, r.Ascending, func(k []byte, row sdbc.Row) bool {
+		if len(resp.Data) == limit {
+			// one more row exists: the page is full
+			if r.Ascending == 1 {
+				next.key = k
+			} else {
+				next.key = lastKey
+			}
+			nextToken = next.encode(tableKey)
+			return false
+		}
+		resp.Data = append(resp.Data, maskRow(row, hidden))
+		lastKey = k
+		return true
+	})
+	if err != nil {
+		return resp, nextToken, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[range:ScanRange] %s", err.Error()))
+	}
+	if resp.Data, err = tbl.assignRowColumnTypes(resp.Data); err != nil {
+		return resp, nextToken, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[range:ScanRange] assignRowColumnTypes %s", err.Error()))
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nextToken, nil
+}
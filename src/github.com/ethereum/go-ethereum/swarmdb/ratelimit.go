@@ -0,0 +1,195 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A user's limiter is dropped once it has been idle for RATE_LIMIT_IDLE with no query running: its buckets are full
+// again after a second, so a new one admits the same.
+const RATE_LIMIT_IDLE = time.Minute
+
+// RateLimitConfig limits are enforced with token buckets holding one second of burst; 0 means unlimited
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond,omitempty"`
+	BytesPerSecond    float64 `json:"bytesPerSecond,omitempty"`
+	ConcurrentQueries int     `json:"concurrentQueries,omitempty"`
+}
+
+// ThrottledError is returned when a request exceeds a rate limit; clients should retry after RetryAfter
+type ThrottledError struct {
+	Limit      string
+	User       string
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("[ratelimit] %s limit exceeded for user [%s], retry after %s", e.Limit, e.User, e.RetryAfter)
+}
+
+func (e *ThrottledError) SWARMDBError() *sdbc.SWARMDBError {
+	return &sdbc.SWARMDBError{Message: e.Error(), ErrorCode: 493, ErrorMessage: fmt.Sprintf("Throttled: %s limit exceeded", e.Limit)}
+}
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// take removes n tokens; a request larger than the burst is admitted only against a full bucket
+func (b *tokenBucket) take(n float64) (ok bool, retryAfter time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	need := n
+	if need > b.rate {
+		need = b.rate
+	}
+	if b.tokens < need {
+		return false, time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+	}
+	b.tokens -= n
+	return true, 0
+}
+
+// RequestLimiter holds the buckets of one connection or one user
+type RequestLimiter struct {
+	requests *tokenBucket
+	bytes    *tokenBucket
+	queries  chan struct{}
+	last     time.Time // of the last request admitted for a user, see RATE_LIMIT_IDLE
+}
+
+func NewRequestLimiter(c RateLimitConfig) *RequestLimiter {
+	l := &RequestLimiter{requests: newTokenBucket(c.RequestsPerSecond), bytes: newTokenBucket(c.BytesPerSecond)}
+	if c.ConcurrentQueries > 0 {
+		l.queries = make(chan struct{}, c.ConcurrentQueries)
+	}
+	return l
+}
+
+func (l *RequestLimiter) admit(user string, nbytes int, query bool) (release func(), err error) {
+	release = func() {}
+	if l == nil {
+		return release, nil
+	}
+	if ok, retry := l.requests.take(1); !ok {
+		return release, &ThrottledError{Limit: "requests/sec", User: user, RetryAfter: retry}
+	}
+	if ok, retry := l.bytes.take(float64(nbytes)); !ok {
+		return release, &ThrottledError{Limit: "bytes/sec", User: user, RetryAfter: retry}
+	}
+	if query && l.queries != nil {
+		select {
+		case l.queries <- struct{}{}:
+			return func() { <-l.queries }, nil
+		default:
+			return release, &ThrottledError{Limit: "concurrent queries", User: user, RetryAfter: time.Second}
+		}
+	}
+	return release, nil
+}
+
+// RateLimiter applies RateLimit to every connection and, per authenticated user, UserRateLimits[address] (or RateLimit
+// when the user has no entry), whatever owner the requests name
+type RateLimiter struct {
+	config *SWARMDBConfig
+	mu     sync.Mutex
+	users  map[string]*RequestLimiter
+	swept  time.Time
+}
+
+func NewRateLimiter(config *SWARMDBConfig) *RateLimiter {
+	return &RateLimiter{config: config, users: make(map[string]*RequestLimiter), swept: time.Now()}
+}
+
+// NewConnection returns the limiter for a new client connection
+func (self *RateLimiter) NewConnection() *RequestLimiter {
+	return NewRequestLimiter(self.config.RateLimit)
+}
+
+func (self *RateLimiter) userLimiter(user string) *RequestLimiter {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	now := time.Now()
+	if now.Sub(self.swept) > RATE_LIMIT_IDLE {
+		for addr, l := range self.users {
+			if now.Sub(l.last) > RATE_LIMIT_IDLE && len(l.queries) == 0 {
+				delete(self.users, addr)
+			}
+		}
+		self.swept = now
+	}
+	l, ok := self.users[user]
+	if !ok {
+		c := self.config.RateLimit
+		for addr, limits := range self.config.UserRateLimits {
+			if strings.EqualFold(addr, user) {
+				c = limits
+			}
+		}
+		l = NewRequestLimiter(c)
+		self.users[user] = l
+	}
+	l.last = now
+	return l
+}
+
+// Admit checks conn (may be nil) and the limits of u, the authenticated user, for a request of nbytes; release must be
+// called when the request completes
+func (self *RateLimiter) Admit(conn *RequestLimiter, u *SWARMDBUser, nbytes int, query bool) (release func(), err error) {
+	user := strings.ToLower(u.Address)
+	releaseConn, err := conn.admit(user, nbytes, query)
+	if err != nil {
+		return releaseConn, err
+	}
+	releaseUser, err := self.userLimiter(user).admit(user, nbytes, query)
+	if err != nil {
+		releaseConn()
+		return releaseUser, err
+	}
+	return func() {
+		releaseUser()
+		releaseConn()
+	}, nil
+}
+
+func isQueryRequest(requestType string) bool {
+	return requestType == sdbc.RT_QUERY || requestType == sdbc.RT_SCAN || requestType == wire.RT_SCAN_RANGE
+}
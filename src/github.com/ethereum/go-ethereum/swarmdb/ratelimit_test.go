@@ -0,0 +1,70 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb_test
+
+import (
+	sdb "swarmdb"
+	"testing"
+)
+
+func TestRateLimiter(t *testing.T) {
+	c := &sdb.SWARMDBConfig{
+		RateLimit:      sdb.RateLimitConfig{RequestsPerSecond: 2, ConcurrentQueries: 1},
+		UserRateLimits: map[string]sdb.RateLimitConfig{"0xB0B0000000000000000000000000000000000000": sdb.RateLimitConfig{RequestsPerSecond: 100}},
+	}
+	user := &sdb.SWARMDBUser{Address: "0xa11ce00000000000000000000000000000000000"}
+	vip := &sdb.SWARMDBUser{Address: "0xb0b0000000000000000000000000000000000000"}
+	querier := &sdb.SWARMDBUser{Address: "0xc0ffee0000000000000000000000000000000000"}
+	limiter := sdb.NewRateLimiter(c)
+	conn := limiter.NewConnection()
+
+	for i := 0; i < 2; i++ {
+		release, err := limiter.Admit(conn, user, 10, false)
+		if err != nil {
+			t.Fatalf("[ratelimit_test:TestRateLimiter] request %d throttled %s", i, err)
+		}
+		release()
+	}
+	_, err := limiter.Admit(conn, user, 10, false)
+	if _, ok := err.(*sdb.ThrottledError); !ok {
+		t.Fatalf("[ratelimit_test:TestRateLimiter] expected ThrottledError, got %v", err)
+	}
+
+	// the limits of a user hold on every connection, whatever owner the requests name
+	if _, err = limiter.Admit(limiter.NewConnection(), user, 10, false); err == nil {
+		t.Fatalf("[ratelimit_test:TestRateLimiter] request of a throttled user admitted on another connection")
+	}
+
+	// per user limits are independent of the connection limits of other connections
+	for i := 0; i < 10; i++ {
+		release, err := limiter.Admit(nil, vip, 10, false)
+		if err != nil {
+			t.Fatalf("[ratelimit_test:TestRateLimiter] vip request %d throttled %s", i, err)
+		}
+		release()
+	}
+
+	// concurrent queries
+	other := limiter.NewConnection()
+	release, err := limiter.Admit(other, querier, 10, true)
+	if err != nil {
+		t.Fatalf("[ratelimit_test:TestRateLimiter] first query throttled %s", err)
+	}
+	if _, err := limiter.Admit(other, querier, 10, true); err == nil {
+		t.Fatalf("[ratelimit_test:TestRateLimiter] second concurrent query admitted")
+	}
+	release()
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"strings"
+	"time"
+)
+
+// A replica (config Replica = 1) serves reads of tables that another node writes: it opens tables read-only from
+// the root hashes the writer publishes to ENS and follows them, reopening an open table whenever its root hash
+// moves (see Follow).  Rows and index nodes are read from the local chunk store, which the chunk network keeps
+// filled; a replica never publishes root hashes, so any number of them can serve reads without coordinating
+// with the writer.  Readers that hold a table across a reopen keep reading the version they opened.
+const (
+	REPLICA_POLL_INTERVAL = 2 * time.Second
+)
+
+// NewReplica returns a read-only SwarmDB over the chunk store and ENS of self with a table cache of its own
+func (self *SwarmDB) NewReplica() *SwarmDB {
+	return &SwarmDB{tables: make(map[string]*Table), dbchunkstore: self.dbchunkstore, ens: self.ens, swapdb: self.swapdb, Netstats: self.Netstats, replica: true, placement: self.placement, usage: self.usage, ledger: self.ledger}
+}
+
+// IsReplica reports whether self serves reads only
+func (self *SwarmDB) IsReplica() bool {
+	return self.replica
+}
+
+// checkWritable refuses writes to a replica
+func (self *SwarmDB) checkWritable() (err error) {
+	if self.replica {
+		return &sdbc.SWARMDBError{Message: "[replica:checkWritable] write to a replica", ErrorCode: 502, ErrorMessage: "Request Invalid: this node is a read-only replica"}
+	}
+	return nil
+}
+
+// isReplicaRead reports whether a replica answers d
+func isReplicaRead(d *sdbc.RequestOption) bool {
+	switch d.RequestType {
+	case sdbc.RT_GET, sdbc.RT_SCAN, sdbc.RT_DESCRIBE_TABLE, sdbc.RT_LIST_TABLES, sdbc.RT_LIST_DATABASES, RT_LIST_GRANTS, RT_BALANCE, wire.RT_VERSIONS, wire.RT_SCAN_RANGE, wire.RT_GET_MULTI, wire.RT_TABLE_INFO, wire.RT_GET_BLOB, wire.RT_GET_KV, wire.RT_ITERATE_KV, wire.RT_FIND_DOCS, wire.RT_TRAVERSE:
+		return true
+	case sdbc.RT_QUERY:
+		fields := strings.Fields(d.RawQuery)
+		return len(fields) > 0 && (strings.ToUpper(fields[0]) == "SELECT" || strings.ToUpper(fields[0]) == "EXPLAIN")
+	}
+	return false
+}
+
+// Follow reopens the open tables whose root hash moved since they were opened and drops those no longer
+// published, returning the number of tables reopened
+func (self *SwarmDB) Follow(u *SWARMDBUser) (reopened int, err error) {
+	for _, t := range self.openTables() {
+		tblKey := self.GetTableKey(t.Owner, t.Database, t.tableName)
+		roothash, err := self.GetRootHash(u, []byte(tblKey))
+		if err != nil {
+			return reopened, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[replica:Follow] GetRootHash %s", err.Error()))
+		}
+		if bytes.Equal(roothash, t.roothash) {
+			continue
+		}
+		if len(bytes.Trim(roothash, "\x00")) == 0 {
+			self.UnregisterTable(t.Owner, t.Database, t.tableName)
+			continue
+		}
+		latest := self.NewTable(t.Owner, t.Database, t.tableName)
+		if err = latest.OpenTable(u); err != nil {
+			return reopened, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[replica:Follow] OpenTable %s", err.Error()))
+		}
+		self.RegisterTable(t.Owner, t.Database, t.tableName, latest)
+		reopened++
+	}
+	return reopened, nil
+}
+
+// StartFollower calls Follow every interval until stop is called
+func (self *SwarmDB) StartFollower(u *SWARMDBUser, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = REPLICA_POLL_INTERVAL
+	}
+	quit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				if _, err := self.Follow(u); err != nil {
+					log.Debug(fmt.Sprintf("[replica:StartFollower] %s", err.Error()))
+				}
+			}
+		}
+	}()
+	return func() { close(quit) }
+}
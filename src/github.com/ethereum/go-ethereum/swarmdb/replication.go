@@ -0,0 +1,133 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+)
+
+// Chunks are written with the MinReplication and MaxReplication of their writer, unless the table sets its own: then
+// every chunk of the table, rows, index nodes and descriptor alike, carries the replication of the table in its
+// header, the chunk store of a node with config.Placement stores it at that many addresses (see placement.go), and
+// the ledger debits the owner bid × the MinReplication of the table per row chunk, so that important tables get more
+// redundancy, and pay for it, whoever writes them.  The setting is stored in the unused front of the descriptor
+// chunk, after the family mask:
+//
+//	464-468 magic, 468-470 min replication, 470-472 max replication
+//
+// Descriptors written before kept it at 4040-4048, outside the hashed bytes; it is still read from there.
+const (
+	REPLICATION_MAGIC_START = 464
+	REPLICATION_MIN_START   = 468
+	REPLICATION_MAX_START   = 470
+	REPLICATION_END         = 472
+
+	LEGACY_REPLICATION_MAGIC_START = 4040
+)
+
+var REPLICATION_MAGIC = []byte("rep\x01")
+
+func readReplication(descriptor []byte) (min int, max int) {
+	start := REPLICATION_MAGIC_START
+	if !bytes.Equal(descriptor[start:start+len(REPLICATION_MAGIC)], REPLICATION_MAGIC) {
+		start = LEGACY_REPLICATION_MAGIC_START
+		if !bytes.Equal(descriptor[start:start+len(REPLICATION_MAGIC)], REPLICATION_MAGIC) {
+			return 0, 0
+		}
+	}
+	min = int(binary.BigEndian.Uint16(descriptor[start+4 : start+6]))
+	max = int(binary.BigEndian.Uint16(descriptor[start+6 : start+8]))
+	return min, max
+}
+
+func writeReplication(descriptor []byte, min int, max int) {
+	if min == 0 {
+		return
+	}
+	copy(descriptor[REPLICATION_MAGIC_START:], REPLICATION_MAGIC)
+	binary.BigEndian.PutUint16(descriptor[REPLICATION_MIN_START:REPLICATION_MAX_START], uint16(min))
+	binary.BigEndian.PutUint16(descriptor[REPLICATION_MAX_START:REPLICATION_END], uint16(max))
+}
+
+// Replication returns the replication the chunks of the table are written with; 0, 0 when it is the writer's
+func (t *Table) Replication() (min int, max int) {
+	return t.minReplication, t.maxReplication
+}
+
+// SetReplication has the chunks of the table written from now on kept in min to max replicas, 1 <= min <= max <=
+// PLACEMENT_MAX_REPLICAS, or with the replication of their writer when both are 0.  Chunks written before keep
+// theirs.  Only the owner, who pays for the replicas, may set it.
+func (t *Table) SetReplication(u *SWARMDBUser, min int, max int) (err error) {
+	if !t.isOwner(u) {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[replication:SetReplication] user [%+v] is not the owner of table [%s]", u, t.tableName), ErrorCode: 490, ErrorMessage: fmt.Sprintf("Access Denied to Table [%s]: only the owner sets its replication", t.tableName)}
+	}
+	if !(min == 0 && max == 0) && (min < 1 || max < min || max > PLACEMENT_MAX_REPLICAS) {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[replication:SetReplication] min %d max %d", min, max), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: replication must be 1 <= min <= max <= %d, or 0 for the writer's", PLACEMENT_MAX_REPLICAS)}
+	}
+	if t.IsSharded() {
+		if err = t.eachShard(u, func(i int, shard *Table) error { return shard.SetReplication(u, min, max) }); err != nil {
+			return err
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.minReplication, t.maxReplication = min, max
+	return t.updateTableInfo(u)
+}
+
+// replicatedBy returns u to write or read the chunks of the table with: a copy of u with the replication of the
+// table, when it has one
+func (t *Table) replicatedBy(u *SWARMDBUser) *SWARMDBUser {
+	if u == nil || t.minReplication == 0 || (u.MinReplication == t.minReplication && u.MaxReplication == t.maxReplication) {
+		return u
+	}
+	replicated := *u
+	replicated.MinReplication, replicated.MaxReplication = t.minReplication, t.maxReplication
+	return &replicated
+}
+
+// setReplication runs an RT_REPLICATION request: d.Rows[0] {"min", "max"} sets the replication of the table, 0 and 0
+// clearing it; without Rows it is only read
+func (self *SwarmDB) setReplication(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[replication:setReplication] GetTable %s", err.Error()))
+	}
+	if len(d.Rows) == 1 {
+		var limits [2]int
+		for i, name := range []string{"min", "max"} {
+			v, ok := toFloat(d.Rows[0][name])
+			if !ok && d.Rows[0][name] != nil {
+				return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[replication:setReplication] %s is %v", name, d.Rows[0][name]), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: replication %s must be a number", name)}
+			}
+			limits[i] = int(v)
+		}
+		if err = tbl.SetReplication(u, limits[0], limits[1]); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[replication:setReplication] SetReplication %s", err.Error()))
+		}
+		resp.AffectedRowCount = 1
+	}
+	min, max := tbl.Replication()
+	row := sdbc.NewRow()
+	row["min"] = min
+	row["max"] = max
+	resp.Data = []sdbc.Row{row}
+	resp.MatchedRowCount = 1
+	return resp, nil
+}
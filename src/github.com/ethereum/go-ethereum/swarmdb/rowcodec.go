@@ -0,0 +1,270 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"math"
+	"strconv"
+)
+
+// Row values are stored in a compact binary form rather than as JSON maps:
+//
+//	ROW_FORMAT_BINARY, then per cell: column id, cell tag, payload, and finally ROW_FORMAT_END
+//
+// The column id is the position of the column in Table.columnOrder, which only depends on the schema, or
+// ROW_COLUMN_NAMED followed by the uvarint length and the name for cells of columns outside the schema.  Payloads are
+// zigzag varints for CELL_INT, 8 byte IEEE 754 for CELL_FLOAT and uvarint length prefixed bytes for CELL_STRING and
+// CELL_JSON; CELL_NULL, CELL_FALSE and CELL_TRUE have none.  The end byte keeps a value that ends in zeros intact
+// when the zero padding of its chunk is trimmed.
+//
+// JSON values start with '{', so rows written before the codec, or with config.JSONRows, still read: rowJSON passes
+// them through.  A row is only stored binary when that is shorter than its JSON.
+const (
+	ROW_FORMAT_BINARY = 0x01
+	ROW_FORMAT_END    = 0xff
+	ROW_COLUMN_NAMED  = 0xfe
+
+	CELL_NULL   = 0
+	CELL_FALSE  = 1
+	CELL_TRUE   = 2
+	CELL_INT    = 3
+	CELL_FLOAT  = 4
+	CELL_STRING = 5
+	CELL_JSON   = 6 // objects, arrays and anything else, as JSON
+)
+
+// isBinaryRow reports whether value, a row value with its padding trimmed, is in the binary form
+func isBinaryRow(value []byte) bool {
+	return len(value) > 0 && value[0] == ROW_FORMAT_BINARY
+}
+
+// encodeRow encodes row in the binary form for a table whose columnOrder is names
+func encodeRow(names []string, row map[string]interface{}) (out []byte, err error) {
+	ids := make(map[string]int, len(names))
+	for i, name := range names {
+		ids[name] = i
+	}
+	out = make([]byte, 1, 256)
+	out[0] = ROW_FORMAT_BINARY
+	var tmp [binary.MaxVarintLen64]byte
+	appendBytes := func(b []byte) {
+		out = append(out, tmp[:binary.PutUvarint(tmp[:], uint64(len(b)))]...)
+		out = append(out, b...)
+	}
+	for name, value := range row {
+		if id, ok := ids[name]; ok && id < ROW_COLUMN_NAMED {
+			out = append(out, byte(id))
+		} else {
+			out = append(out, ROW_COLUMN_NAMED)
+			appendBytes([]byte(name))
+		}
+		if n, ok := value.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				value = i
+			} else if f, err := n.Float64(); err == nil {
+				value = f
+			}
+		}
+		switch v := value.(type) {
+		case nil:
+			out = append(out, CELL_NULL)
+		case bool:
+			if v {
+				out = append(out, CELL_TRUE)
+			} else {
+				out = append(out, CELL_FALSE)
+			}
+		case int:
+			out = append(out, CELL_INT)
+			out = append(out, tmp[:binary.PutVarint(tmp[:], int64(v))]...)
+		case int64:
+			out = append(out, CELL_INT)
+			out = append(out, tmp[:binary.PutVarint(tmp[:], v)]...)
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+				// JSON writes integral floats as integers, so they come back the same
+				out = append(out, CELL_INT)
+				out = append(out, tmp[:binary.PutVarint(tmp[:], int64(v))]...)
+				break
+			}
+			out = append(out, CELL_FLOAT)
+			binary.BigEndian.PutUint64(tmp[:8], math.Float64bits(v))
+			out = append(out, tmp[:8]...)
+		case string:
+			out = append(out, CELL_STRING)
+			appendBytes([]byte(v))
+		default:
+			j, err := json.Marshal(v)
+			if err != nil {
+				return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[rowcodec:encodeRow] Marshal %s %s", name, err.Error()), ErrorCode: 435, ErrorMessage: "Invalid Row Data"}
+			}
+			out = append(out, CELL_JSON)
+			appendBytes(j)
+		}
+	}
+	return append(out, ROW_FORMAT_END), nil
+}
+
+// encodeValue returns the value row is stored as in a K-chunk of t: binary, unless config.JSONRows is set or the
+// JSON is shorter
+func (t *Table) encodeValue(row map[string]interface{}) (v []byte, err error) {
+	v, err = json.Marshal(row)
+	if err != nil {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[rowcodec:encodeValue] Marshal %s", err.Error()), ErrorCode: 435, ErrorMessage: "Invalid Row Data"}
+	}
+	if !t.swarmdb.jsonRows {
+		if b, err := encodeRow(t.columnOrder(), row); err == nil && len(b) < len(v) {
+			v = b
+		}
+	}
+	return v, nil
+}
+
+// forEachCell calls fn with the name, tag and payload of each cell of the binary row value, until fn returns false.
+// Payloads are slices of value, so walking a row only allocates the names of columns outside the schema.
+func forEachCell(names []string, value []byte, fn func(name string, tag byte, payload []byte) bool) (err error) {
+	if !isBinaryRow(value) {
+		return rowCodecError("not a binary row")
+	}
+	p := 1
+	readBytes := func() ([]byte, bool) {
+		n, w := binary.Uvarint(value[p:])
+		if w <= 0 || uint64(len(value)-p-w) < n {
+			return nil, false
+		}
+		b := value[p+w : p+w+int(n)]
+		p += w + int(n)
+		return b, true
+	}
+	for p < len(value) && value[p] != ROW_FORMAT_END {
+		id := int(value[p])
+		p++
+		var name string
+		if id == ROW_COLUMN_NAMED {
+			b, ok := readBytes()
+			if !ok {
+				return rowCodecError("truncated column name")
+			}
+			name = string(b)
+		} else if id < len(names) {
+			name = names[id]
+		} else {
+			name = fmt.Sprintf("#%d", id)
+		}
+		if p >= len(value) {
+			return rowCodecError("truncated cell")
+		}
+		tag := value[p]
+		p++
+		var payload []byte
+		switch tag {
+		case CELL_NULL, CELL_FALSE, CELL_TRUE:
+		case CELL_INT:
+			_, w := binary.Varint(value[p:])
+			if w <= 0 {
+				return rowCodecError("truncated integer")
+			}
+			payload = value[p : p+w]
+			p += w
+		case CELL_FLOAT:
+			if len(value)-p < 8 {
+				return rowCodecError("truncated float")
+			}
+			payload = value[p : p+8]
+			p += 8
+		case CELL_STRING, CELL_JSON:
+			b, ok := readBytes()
+			if !ok {
+				return rowCodecError("truncated value")
+			}
+			payload = b
+		default:
+			return rowCodecError(fmt.Sprintf("unknown cell tag %d", tag))
+		}
+		if !fn(name, tag, payload) {
+			return nil
+		}
+	}
+	if p >= len(value) {
+		return rowCodecError("missing end byte")
+	}
+	return nil
+}
+
+func rowCodecError(reason string) error {
+	return &sdbc.SWARMDBError{Message: fmt.Sprintf("[rowcodec:forEachCell] %s", reason), ErrorCode: 439, ErrorMessage: "Unable to Parse Chunk"}
+}
+
+// rowJSON returns the row value as JSON, converting binary rows of a table whose columnOrder is names
+func rowJSON(names []string, value []byte) (out []byte, err error) {
+	if !isBinaryRow(value) {
+		return value, nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, 2*len(value)))
+	buf.WriteByte('{')
+	err = forEachCell(names, value, func(name string, tag byte, payload []byte) bool {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		buf.Write(key)
+		buf.WriteByte(':')
+		writeCellJSON(buf, tag, payload)
+		return true
+	})
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[rowcodec:rowJSON] %s", err.Error()))
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// writeCellJSON writes the cell with tag and payload to buf as a JSON value
+func writeCellJSON(buf *bytes.Buffer, tag byte, payload []byte) {
+	switch tag {
+	case CELL_NULL:
+		buf.WriteString("null")
+	case CELL_FALSE:
+		buf.WriteString("false")
+	case CELL_TRUE:
+		buf.WriteString("true")
+	case CELL_INT:
+		i, _ := binary.Varint(payload)
+		buf.WriteString(strconv.FormatInt(i, 10))
+	case CELL_FLOAT:
+		f, _ := json.Marshal(math.Float64frombits(binary.BigEndian.Uint64(payload)))
+		buf.Write(f)
+	case CELL_STRING:
+		s, _ := json.Marshal(string(payload))
+		buf.Write(s)
+	case CELL_JSON:
+		buf.Write(payload)
+	}
+}
+
+// rowJSON returns the row value, read from a K-chunk of t, as JSON; a value that does not decode is returned as is
+func (t *Table) rowJSON(value []byte) []byte {
+	out, err := rowJSON(t.columnOrder(), value)
+	if err != nil {
+		return value
+	}
+	return out
+}
@@ -0,0 +1,247 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"io"
+)
+
+// A leaf node keeps the keys of its entries as slices of the chunk it was read from (see d.swarmGet), and chunks read
+// from the chunk store are never reused, so a scan can hand out those keys without copying them.  ScanEach yields a
+// RowView per entry of the primary index: the key is such a view, and the row is only read from its K-chunk and
+// decoded when the callback asks for it, as a whole (Row) or one cell at a time (Cell).  The RowView is reused for
+// every entry, so the key and anything returned from it are only valid until the callback returns: a callback that
+// keeps them must copy them.
+type RowView struct {
+	t      *Table
+	u      *SWARMDBUser
+	key    []byte
+	read   bool   // value, found and err are set
+	value  []byte // as stored, binary or JSON
+	found  bool
+	err    error
+	row    sdbc.Row
+	hasRow bool
+}
+
+func (r *RowView) reset(key []byte) {
+	r.key, r.read, r.value, r.found, r.err, r.row, r.hasRow = key, false, nil, false, nil, nil, false
+}
+
+// Key returns the primary key of the entry, zero padded as the index holds it
+func (r *RowView) Key() []byte {
+	return r.key
+}
+
+// KeyString returns the primary key of the entry as KeyToString does
+func (r *RowView) KeyString() string {
+	return KeyToString(r.t.columns[r.t.primaryColumnName].columnType, r.key)
+}
+
+// load reads the value of the entry once; snapshots read the version as of their time, which is already JSON
+func (r *RowView) load() (err error) {
+	if r.read {
+		return r.err
+	}
+	r.read = true
+	if r.t.asOfMs > 0 {
+		r.value, r.found, r.err = r.t.getAsOf(r.u, r.key, r.t.asOfMs)
+	} else {
+		r.value, r.found, r.err = r.t.readValue(r.u, r.key)
+	}
+	return r.err
+}
+
+// Value returns the row as JSON, as Table.Get does; ok is false when there is no row
+func (r *RowView) Value() (value []byte, ok bool, err error) {
+	if err = r.load(); err != nil || !r.found {
+		return nil, false, err
+	}
+	if r.t.asOfMs > 0 {
+		return r.value, true, nil
+	}
+	return r.t.decryptColumns(r.u, r.t.rowJSON(r.value)), true, nil
+}
+
+// Row returns the row, decoded once however often it is asked for
+func (r *RowView) Row() (row sdbc.Row, ok bool, err error) {
+	if r.hasRow {
+		return r.row, true, nil
+	}
+	value, ok, err := r.Value()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if r.row, err = r.t.byteArrayToRow(value); err != nil {
+		return nil, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[rowview:Row] byteArrayToRow %s", err.Error()))
+	}
+	r.hasRow = true
+	return r.row, true, nil
+}
+
+// Cell returns the value of the column name of the row.  The cells of binary rows are found without decoding the
+// others; encrypted columns, JSON rows and snapshots go through Row.
+func (r *RowView) Cell(name string) (value interface{}, ok bool, err error) {
+	if c, isColumn := r.t.columns[name]; r.hasRow || !isColumn || c.encrypted || r.t.asOfMs > 0 {
+		return r.cellOfRow(name)
+	}
+	if err = r.load(); err != nil || !r.found {
+		return nil, false, err
+	}
+	if !isBinaryRow(r.value) {
+		return r.cellOfRow(name)
+	}
+	var cell *bytes.Buffer
+	err = forEachCell(r.t.columnOrder(), r.value, func(n string, tag byte, payload []byte) bool {
+		if n != name {
+			return true
+		}
+		key, _ := json.Marshal(name)
+		cell = bytes.NewBuffer(make([]byte, 0, len(key)+len(payload)+8))
+		cell.WriteByte('{')
+		cell.Write(key)
+		cell.WriteByte(':')
+		writeCellJSON(cell, tag, payload)
+		cell.WriteByte('}')
+		return false
+	})
+	if err != nil {
+		return nil, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[rowview:Cell] forEachCell %s", err.Error()))
+	}
+	if cell == nil {
+		return nil, false, nil
+	}
+	row, err := r.t.byteArrayToRow(cell.Bytes())
+	if err != nil {
+		return nil, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[rowview:Cell] byteArrayToRow %s", err.Error()))
+	}
+	value, ok = row[name]
+	return value, ok, nil
+}
+
+func (r *RowView) cellOfRow(name string) (value interface{}, ok bool, err error) {
+	row, found, err := r.Row()
+	if err != nil || !found {
+		return nil, false, err
+	}
+	value, ok = row[name]
+	return value, ok, nil
+}
+
+// ScanEach calls fn with a RowView of every entry of the primary index, in ascending order when ascending is 1 and
+// descending otherwise, until fn returns false or an error.  Sharded tables are scanned shard after shard, as their
+// shards split the primary keys in ranges.
+func (t *Table) ScanEach(u *SWARMDBUser, ascending int, fn func(r *RowView) (bool, error)) (err error) {
+	if t.IsSharded() {
+		n := len(t.shardSplits) + 1
+		for j := 0; j < n; j++ {
+			i := j
+			if ascending != 1 {
+				i = n - 1 - j
+			}
+			shard, err := t.shard(u, i)
+			if err != nil {
+				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[rowview:ScanEach] shard %s", err.Error()))
+			}
+			more := true
+			err = shard.ScanEach(u, ascending, func(r *RowView) (bool, error) {
+				more, err = fn(r)
+				return more, err
+			})
+			if err != nil || !more {
+				return err
+			}
+		}
+		return nil
+	}
+	column, err := t.getPrimaryColumn()
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[rowview:ScanEach] getPrimaryColumn %s", err.Error()))
+	}
+	return t.scanIndex(u, column, ascending, fn)
+}
+
+// scanIndex calls fn with a RowView of the row of every entry of the index of column, in the order of the index.  An
+// entry of a secondary index holds the primary key of its row as value, and stays behind when the row is deleted or
+// its cell changes, so the entries whose row no longer holds their key are skipped.
+func (t *Table) scanIndex(u *SWARMDBUser, column *ColumnInfo, ascending int, fn func(r *RowView) (bool, error)) (err error) {
+	c, ok := column.dbaccess.(OrderedDatabase)
+	if !ok {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[rowview:scanIndex] column [%s] is not ordered", column.columnName), ErrorCode: 431, ErrorMessage: fmt.Sprintf("Scans on Column [%s] not unsupported due to indextype", column.columnName)}
+	}
+	var res OrderedDatabaseCursor
+	if ascending == 1 {
+		res, err = c.SeekFirst(u)
+	} else {
+		res, err = c.SeekLast(u)
+	}
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[rowview:scanIndex] Seek %s", err.Error()))
+	}
+
+	r := &RowView{t: t, u: u}
+	for {
+		if err = u.checkDeadline("rowview:scanIndex"); err != nil {
+			return err
+		}
+		var k, v []byte
+		if ascending == 1 {
+			k, v, err = res.Next(u)
+		} else {
+			k, v, err = res.Prev(u)
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[rowview:scanIndex] cursor %s", err.Error()))
+		}
+		if column.primary > 0 {
+			r.reset(k)
+		} else {
+			r.reset(v)
+			holds, err := r.holdsKey(column, k)
+			if err != nil {
+				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[rowview:scanIndex] holdsKey %s", err.Error()))
+			}
+			if !holds {
+				continue
+			}
+		}
+		more, err := fn(r)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+// holdsKey reports whether the row of r is there and its cell of column has the index key k
+func (r *RowView) holdsKey(column *ColumnInfo, k []byte) (ok bool, err error) {
+	cell, found, err := r.Cell(column.columnName)
+	if err != nil || !found {
+		return false, err
+	}
+	key, err := convertJSONValueToKey(column.columnType, cell)
+	if err != nil {
+		return false, nil
+	}
+	return bytes.Equal(padKey(key), padKey(k)), nil
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PublicSwarmDBAPI is registered under the "swarmdb" namespace of the standard go-ethereum JSON-RPC server, giving
+// swarmdb_get, swarmdb_put, swarmdb_query and swarmdb_createTable to web3-style clients over HTTP, WS and IPC.  Each
+// call authenticates with the Credentials of its last parameter (see auth.go), a challenge from swarmdb_challenge and
+// its signature or a capability token, and runs as the user they verify; with Authentication 0 it may be omitted.
+type PublicSwarmDBAPI struct {
+	swarmdb *SwarmDB
+	config  *SWARMDBConfig
+	auth    *Authenticator
+}
+
+func NewPublicSwarmDBAPI(swarmdb *SwarmDB, config *SWARMDBConfig) *PublicSwarmDBAPI {
+	return &PublicSwarmDBAPI{swarmdb: swarmdb, config: config, auth: NewAuthenticator(swarmdb, config)}
+}
+
+// RPC_CHANGES_BACKLOG is the number of events a tableChanges subscriber may fall behind before it is dropped
+const RPC_CHANGES_BACKLOG = 1024
+
+// RPCChallenge is the result of swarmdb_challenge
+type RPCChallenge struct {
+	Challenge string `json:"challenge"`
+	ExpiresMs int64  `json:"expiresMs"`
+}
+
+func (self *SwarmDB) APIs(config *SWARMDBConfig) []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "swarmdb",
+			Version:   "1.0",
+			Service:   NewPublicSwarmDBAPI(self, config),
+			Public:    true,
+		},
+	}
+}
+
+// Challenge returns a challenge for the Credentials of a later call
+func (self *PublicSwarmDBAPI) Challenge() (RPCChallenge, error) {
+	challenge, expires, err := self.auth.Challenge()
+	if err != nil {
+		return RPCChallenge{}, err
+	}
+	return RPCChallenge{Challenge: challenge, ExpiresMs: expires.UnixNano() / int64(time.Millisecond)}, nil
+}
+
+func (self *PublicSwarmDBAPI) Get(owner string, database string, table string, key interface{}, creds *Credentials) (sdbc.SWARMDBResponse, error) {
+	return self.handleRequest(creds, &sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: table, Key: key})
+}
+
+func (self *PublicSwarmDBAPI) Put(owner string, database string, table string, rows []sdbc.Row, creds *Credentials) (sdbc.SWARMDBResponse, error) {
+	return self.handleRequest(creds, &sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: table, Rows: rows})
+}
+
+func (self *PublicSwarmDBAPI) Query(owner string, database string, query string, creds *Credentials) (sdbc.SWARMDBResponse, error) {
+	return self.handleRequest(creds, &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, RawQuery: query})
+}
+
+func (self *PublicSwarmDBAPI) CreateTable(owner string, database string, table string, columns []sdbc.Column, creds *Credentials) (sdbc.SWARMDBResponse, error) {
+	return self.handleRequest(creds, &sdbc.RequestOption{RequestType: sdbc.RT_CREATE_TABLE, Owner: owner, Database: database, Table: table, Columns: columns})
+}
+
+// authenticate returns the user the call of d runs as, from creds, which the caller may have omitted
+func (self *PublicSwarmDBAPI) authenticate(creds *Credentials, d *sdbc.RequestOption) (u *SWARMDBUser, err error) {
+	if creds == nil {
+		creds = new(Credentials)
+	}
+	return self.auth.Authenticate(*creds, d)
+}
+
+func (self *PublicSwarmDBAPI) handleRequest(creds *Credentials, d *sdbc.RequestOption) (sdbc.SWARMDBResponse, error) {
+	u, err := self.authenticate(creds, d)
+	if err != nil {
+		return sdbc.SWARMDBResponse{}, err
+	}
+	return self.swarmdb.HandleRequest(u, d)
+}
+
+// TableChanges is a subscription (swarmdb_subscribe "tableChanges") pushing committed Put/Delete/Flush events for a table,
+// optionally restricted to keys starting with keyPrefix.  The subscriber must be able to read the table, and receives
+// the rows without the columns it may not read.  A subscriber falling RPC_CHANGES_BACKLOG events behind is dropped.
+func (self *PublicSwarmDBAPI) TableChanges(ctx context.Context, owner string, database string, table string, keyPrefix string, creds *Credentials) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	d := &sdbc.RequestOption{RequestType: sdbc.RT_SCAN, Owner: owner, Database: database, Table: table}
+	u, err := self.authenticate(creds, d)
+	if err != nil {
+		return &rpc.Subscription{}, err
+	}
+	tbl, err := self.swarmdb.GetTable(u, d.Owner, d.Database, d.Table)
+	if err != nil {
+		return &rpc.Subscription{}, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[rpcapi:TableChanges] GetTable %s", err.Error()))
+	}
+	if _, err = tbl.checkRead(u); err != nil {
+		return &rpc.Subscription{}, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[rpcapi:TableChanges] checkRead %s", err.Error()))
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	// notifications go out on their own goroutine, so that a slow connection does not hold up the table feed
+	backlog := make(chan TableEvent, RPC_CHANGES_BACKLOG)
+	go func() {
+		for ev := range backlog {
+			notifier.Notify(rpcSub.ID, ev)
+		}
+	}()
+	go func() {
+		defer close(backlog)
+		events := make(chan TableEvent, 128)
+		sub := self.swarmdb.SubscribeTableEvents(events)
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case ev := <-events:
+				if !ev.Matches(d.Owner, d.Database, d.Table, keyPrefix) {
+					continue
+				}
+				// grants may have been revoked since the subscription
+				tbl, err := self.swarmdb.GetTable(u, d.Owner, d.Database, d.Table)
+				if err != nil {
+					log.Debug(fmt.Sprintf("[rpcapi:TableChanges] GetTable %s", err.Error()))
+					return
+				}
+				hidden, err := tbl.checkRead(u)
+				if err != nil {
+					log.Debug(fmt.Sprintf("[rpcapi:TableChanges] checkRead %s", err.Error()))
+					return
+				}
+				if ev.Row != nil {
+					ev.Row = maskRow(ev.Row, hidden)
+				}
+				select {
+				case backlog <- ev:
+				default:
+					log.Debug(fmt.Sprintf("[rpcapi:TableChanges] subscription %s dropped %d events behind", rpcSub.ID, RPC_CHANGES_BACKLOG))
+					return
+				}
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
+// StartRPC serves the swarmdb APIs over HTTP and WS on ListenAddrRPC:PortRPC (WS is selected by the Upgrade header)
+// and over IPC when IPCPath is set.  It returns once the listeners are up.  WS accepts the origins of RPCOrigins,
+// which, when empty, go-ethereum limits to localhost.
+func StartRPC(swarmdb *SwarmDB, config *SWARMDBConfig) (err error) {
+	apis := swarmdb.APIs(config)
+	if len(config.IPCPath) > 0 {
+		_, _, err = rpc.StartIPCEndpoint(config.IPCPath, apis)
+		if err != nil {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[rpcapi:StartRPC] StartIPCEndpoint %s", err.Error()), ErrorCode: 484, ErrorMessage: "Unable to start RPC server"}
+		}
+		log.Debug(fmt.Sprintf("[rpcapi:StartRPC] IPC endpoint opened at %s", config.IPCPath))
+	}
+	if config.PortRPC == 0 {
+		return nil
+	}
+
+	srv := rpc.NewServer()
+	for _, api := range apis {
+		if err = srv.RegisterName(api.Namespace, api.Service); err != nil {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[rpcapi:StartRPC] RegisterName %s", err.Error()), ErrorCode: 484, ErrorMessage: "Unable to start RPC server"}
+		}
+	}
+	wsHandler := srv.WebsocketHandler(config.RPCOrigins)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.ToLower(r.Header.Get("Upgrade")) == "websocket" {
+			wsHandler.ServeHTTP(w, r)
+			return
+		}
+		srv.ServeHTTP(w, r)
+	})
+
+	addr := fmt.Sprintf("%s:%d", config.ListenAddrRPC, config.PortRPC)
+	listener, err := config.Listen(addr)
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[rpcapi:StartRPC] Listen %s", err.Error()), ErrorCode: 484, ErrorMessage: "Unable to start RPC server"}
+	}
+	go http.Serve(listener, handler)
+	log.Debug(fmt.Sprintf("[rpcapi:StartRPC] HTTP/WS endpoint opened at %s", addr))
+	return nil
+}
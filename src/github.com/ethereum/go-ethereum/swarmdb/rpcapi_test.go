@@ -0,0 +1,205 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"strings"
+	sdb "swarmdb"
+	"testing"
+	"time"
+)
+
+// rpcCredentials returns a challenge of the server behind client signed with the node key
+func rpcCredentials(t *testing.T, client *rpc.Client) *sdb.Credentials {
+	secretKey, err := crypto.HexToECDSA(strings.TrimPrefix(config.PrivateKey, "0x"))
+	if err != nil {
+		t.Fatalf("[rpcapi_test:rpcCredentials] HexToECDSA %s", err)
+	}
+	return rpcKeyCredentials(t, client, secretKey)
+}
+
+// rpcKeyCredentials returns a challenge of the server behind client signed with secretKey
+func rpcKeyCredentials(t *testing.T, client *rpc.Client, secretKey *ecdsa.PrivateKey) *sdb.Credentials {
+	var challenge sdb.RPCChallenge
+	if err := client.Call(&challenge, "swarmdb_challenge"); err != nil {
+		t.Fatalf("[rpcapi_test:rpcKeyCredentials] swarmdb_challenge %s", err)
+	}
+	sig, err := crypto.Sign(sdb.SignHash([]byte(challenge.Challenge)), secretKey)
+	if err != nil {
+		t.Fatalf("[rpcapi_test:rpcKeyCredentials] Sign %s", err)
+	}
+	return &sdb.Credentials{Challenge: challenge.Challenge, Signature: fmt.Sprintf("%x", sig)}
+}
+
+func TestRPCAPI(t *testing.T) {
+	owner, database, tableName := make_table(t, "rpc")
+
+	srv := rpc.NewServer()
+	for _, api := range swarmdb.APIs(config) {
+		if err := srv.RegisterName(api.Namespace, api.Service); err != nil {
+			t.Fatalf("[rpcapi_test:TestRPCAPI] RegisterName %s", err)
+		}
+	}
+	client := rpc.DialInProc(srv)
+	defer client.Close()
+
+	var res sdbc.SWARMDBResponse
+	rows := []sdbc.Row{sdbc.Row{"email": "bertie@gmail.com", "name": "Bertie", "age": 7}}
+	if err := client.Call(&res, "swarmdb_put", owner, database, tableName, rows, rpcCredentials(t, client)); err != nil {
+		t.Fatalf("[rpcapi_test:TestRPCAPI] swarmdb_put %s", err)
+	}
+	if res.AffectedRowCount != 1 {
+		t.Fatalf("[rpcapi_test:TestRPCAPI] swarmdb_put affected %d rows", res.AffectedRowCount)
+	}
+
+	res = sdbc.SWARMDBResponse{}
+	if err := client.Call(&res, "swarmdb_get", owner, database, tableName, "bertie@gmail.com", rpcCredentials(t, client)); err != nil {
+		t.Fatalf("[rpcapi_test:TestRPCAPI] swarmdb_get %s", err)
+	}
+	if len(res.Data) != 1 || res.Data[0]["name"] != "Bertie" {
+		t.Fatalf("[rpcapi_test:TestRPCAPI] swarmdb_get returned %s", res.Stringify())
+	}
+
+	res = sdbc.SWARMDBResponse{}
+	sql := fmt.Sprintf("select email, name from %s where email = 'bertie@gmail.com'", tableName)
+	if err := client.Call(&res, "swarmdb_query", owner, database, sql, rpcCredentials(t, client)); err != nil {
+		t.Fatalf("[rpcapi_test:TestRPCAPI] swarmdb_query %s", err)
+	}
+	if len(res.Data) != 1 {
+		t.Fatalf("[rpcapi_test:TestRPCAPI] swarmdb_query returned %s", res.Stringify())
+	}
+	fmt.Printf("Output: %s\n", res.Stringify())
+}
+
+func TestRPCAPIAuthentication(t *testing.T) {
+	owner, database, tableName := make_table(t, "rpcauth")
+	authConfig := *config
+	authConfig.Authentication = 1
+
+	srv := rpc.NewServer()
+	for _, api := range swarmdb.APIs(&authConfig) {
+		if err := srv.RegisterName(api.Namespace, api.Service); err != nil {
+			t.Fatalf("[rpcapi_test:TestRPCAPIAuthentication] RegisterName %s", err)
+		}
+	}
+	client := rpc.DialInProc(srv)
+	defer client.Close()
+
+	var res sdbc.SWARMDBResponse
+	rows := []sdbc.Row{sdbc.Row{"email": "rpcauth@wolk.com", "name": "Auth", "age": 7}}
+	if err := client.Call(&res, "swarmdb_put", owner, database, tableName, rows); err == nil {
+		t.Fatalf("[rpcapi_test:TestRPCAPIAuthentication] swarmdb_put without credentials succeeded")
+	}
+	if err := client.Call(&res, "swarmdb_put", owner, database, tableName, rows, &sdb.Credentials{Challenge: "00", Signature: "00"}); err == nil {
+		t.Fatalf("[rpcapi_test:TestRPCAPIAuthentication] swarmdb_put with an unknown challenge succeeded")
+	}
+	if err := client.Call(&res, "swarmdb_put", owner, database, tableName, rows, rpcCredentials(t, client)); err != nil || res.AffectedRowCount != 1 {
+		t.Fatalf("[rpcapi_test:TestRPCAPIAuthentication] swarmdb_put with a signed challenge %v %s", err, res.Stringify())
+	}
+}
+
+func TestRPCTableChanges(t *testing.T) {
+	owner, database, tableName := make_table(t, "sub")
+
+	srv := rpc.NewServer()
+	for _, api := range swarmdb.APIs(config) {
+		srv.RegisterName(api.Namespace, api.Service)
+	}
+	client := rpc.DialInProc(srv)
+	defer client.Close()
+
+	events := make(chan sdb.TableEvent, 16)
+	sub, err := client.Subscribe(context.Background(), "swarmdb", events, "tableChanges", owner, database, tableName, "a", rpcCredentials(t, client))
+	if err != nil {
+		t.Fatalf("[rpcapi_test:TestRPCTableChanges] Subscribe %s", err)
+	}
+	defer sub.Unsubscribe()
+
+	var res sdbc.SWARMDBResponse
+	for _, email := range []string{"zed@wolk.com", "alina@wolk.com"} {
+		rows := []sdbc.Row{sdbc.Row{"email": email, "name": "x", "age": 1}}
+		if err := client.Call(&res, "swarmdb_put", owner, database, tableName, rows, rpcCredentials(t, client)); err != nil {
+			t.Fatalf("[rpcapi_test:TestRPCTableChanges] swarmdb_put %s", err)
+		}
+	}
+
+	select {
+	case ev := <-events:
+		if ev.Type != sdb.TE_PUT || ev.Key != "alina@wolk.com" {
+			t.Fatalf("[rpcapi_test:TestRPCTableChanges] unexpected event %+v", ev)
+		}
+		fmt.Printf("Event: %+v\n", ev)
+	case err := <-sub.Err():
+		t.Fatalf("[rpcapi_test:TestRPCTableChanges] subscription error %s", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("[rpcapi_test:TestRPCTableChanges] no event received")
+	}
+
+	// a reader receives the rows without the columns it may not read, and a stranger may not subscribe
+	readerKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("[rpcapi_test:TestRPCTableChanges] GenerateKey %s", err)
+	}
+	strangerKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("[rpcapi_test:TestRPCTableChanges] GenerateKey %s", err)
+	}
+	defer func(users []sdb.SWARMDBUser) { config.Users = users }(config.Users)
+	reader := crypto.PubkeyToAddress(readerKey.PublicKey).Hex()
+	config.Users = append(config.Users, sdb.SWARMDBUser{Address: reader}, sdb.SWARMDBUser{Address: crypto.PubkeyToAddress(strangerKey.PublicKey).Hex()})
+	if _, err = swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: wire.RT_RESTRICT_COLUMNS, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{{"columns": []interface{}{"name"}}}}); err != nil {
+		t.Fatalf("[rpcapi_test:TestRPCTableChanges] RESTRICT %s", err)
+	}
+	if _, err = swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdb.RT_GRANT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{{"address": reader, "permission": "read"}}}); err != nil {
+		t.Fatalf("[rpcapi_test:TestRPCTableChanges] GRANT %s", err)
+	}
+	if _, err = client.Subscribe(context.Background(), "swarmdb", make(chan sdb.TableEvent, 16), "tableChanges", owner, database, tableName, "", rpcKeyCredentials(t, client, strangerKey)); err == nil {
+		t.Fatalf("[rpcapi_test:TestRPCTableChanges] Subscribe as stranger succeeded")
+	}
+	readerEvents := make(chan sdb.TableEvent, 16)
+	readerSub, err := client.Subscribe(context.Background(), "swarmdb", readerEvents, "tableChanges", owner, database, tableName, "", rpcKeyCredentials(t, client, readerKey))
+	if err != nil {
+		t.Fatalf("[rpcapi_test:TestRPCTableChanges] Subscribe as reader %s", err)
+	}
+	defer readerSub.Unsubscribe()
+	rows := []sdbc.Row{sdbc.Row{"email": "restricted@wolk.com", "name": "hidden", "age": 2}}
+	if err := client.Call(&res, "swarmdb_put", owner, database, tableName, rows, rpcCredentials(t, client)); err != nil {
+		t.Fatalf("[rpcapi_test:TestRPCTableChanges] swarmdb_put %s", err)
+	}
+	for received := false; !received; {
+		select {
+		case ev := <-readerEvents:
+			if ev.Type != sdb.TE_PUT {
+				continue
+			}
+			if ev.Row["email"] != "restricted@wolk.com" || ev.Row["name"] != nil {
+				t.Fatalf("[rpcapi_test:TestRPCTableChanges] unexpected reader event %+v", ev)
+			}
+			received = true
+		case err := <-readerSub.Err():
+			t.Fatalf("[rpcapi_test:TestRPCTableChanges] reader subscription error %s", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("[rpcapi_test:TestRPCTableChanges] no reader event received")
+		}
+	}
+}
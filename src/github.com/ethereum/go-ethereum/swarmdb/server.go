@@ -0,0 +1,150 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"github.com/ethereum/go-ethereum/swarmdb/swarmdblog"
+	"time"
+)
+
+// Server runs one SwarmDB behind every listener enabled in its config (a port of 0 disables a listener)
+type Server struct {
+	config  *SWARMDBConfig
+	swarmdb *SwarmDB
+	tcp     *TCPServer
+	http    *HTTPServer
+	grpc    *GRPCServer
+
+	stopFlusher  func() // stops the flush policy flusher started by Start
+	stopFollower func() // stops the root hash follower, or the anti-entropy syncer, Start runs on a replica
+	stopElector  func() // resigns the leases of config.Election taken by Start
+	stopGossip   func() // stops the gossip rounds Start runs
+	stopUsage    func() // stops the periodic save of the usage meter
+	stopAuditor  func() // stops the periodic custody audits and saves of the ledger
+	stopEvictor  func() // stops the eviction of idle tables
+	stopPurger   func() // stops the purge of expired rows
+}
+
+type listenAndServer interface {
+	ListenAndServe() error
+}
+
+// NewServer sets up the log and opens the SwarmDB stores described by config; call Start to open the listeners
+func NewServer(config *SWARMDBConfig) (srv *Server, err error) {
+	if err = swarmdblog.Setup(config.Log); err != nil {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[server:NewServer] Setup %s", err.Error()), ErrorCode: 458, ErrorMessage: fmt.Sprintf("Invalid log config: %s", err.Error())}
+	}
+	swarmdb, err := NewSwarmDB(config)
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[server:NewServer] NewSwarmDB %s", err.Error()))
+	}
+	srv = &Server{config: config, swarmdb: swarmdb}
+	if config.PortTCP != 0 {
+		srv.tcp = NewTCPServer(swarmdb, config)
+	}
+	if config.PortHTTP != 0 {
+		srv.http = NewHTTPServer(swarmdb, config)
+	}
+	if config.PortGRPC != 0 {
+		srv.grpc = NewGRPCServer(swarmdb, config)
+	}
+	return srv, nil
+}
+
+func (self *Server) SwarmDB() *SwarmDB {
+	return self.swarmdb
+}
+
+// Start opens the listeners; errors of listeners that fail after starting are logged
+func (self *Server) Start() (err error) {
+	if err = StartRPC(self.swarmdb, self.config); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[server:Start] StartRPC %s", err.Error()))
+	}
+	self.stopFlusher = self.swarmdb.StartFlusher(self.config.GetSWARMDBUser(), FLUSH_POLICY_INTERVAL)
+	self.stopUsage = self.swarmdb.StartUsageSaver(USAGE_SAVE_INTERVAL)
+	self.stopAuditor = self.swarmdb.StartAuditor(LEDGER_AUDIT_INTERVAL)
+	self.stopEvictor = self.swarmdb.StartEvictor(time.Duration(self.config.TableIdleTimeout) * time.Second)
+	self.stopPurger = self.swarmdb.StartExpiryPurger(self.config.GetSWARMDBUser(), time.Duration(self.config.ExpiryPurgeInterval)*time.Second)
+	self.stopFollower = func() {}
+	self.stopElector = func() {}
+	self.stopGossip = func() {}
+	if len(self.config.Gossip.Seeds) > 0 || len(self.config.Gossip.Advertise) > 0 {
+		self.stopGossip = NewGossip(self.swarmdb, self.config).Start(time.Duration(self.config.Gossip.IntervalSeconds) * time.Second)
+	}
+	if len(self.config.Election.Owners) > 0 {
+		self.stopElector = NewElector(self.swarmdb, self.config).Start(self.config.GetSWARMDBUser())
+	}
+	if self.swarmdb.IsReplica() && len(self.config.Peers) > 0 {
+		syncer := NewSyncer(self.swarmdb, self.config)
+		stopSyncer := syncer.Start(self.config.GetSWARMDBUser(), time.Duration(self.config.SyncInterval)*time.Second)
+		self.stopFollower = func() {
+			stopSyncer()
+			syncer.Close()
+		}
+	} else if self.swarmdb.IsReplica() {
+		self.stopFollower = self.swarmdb.StartFollower(self.config.GetSWARMDBUser(), time.Duration(self.config.ReplicaPollInterval)*time.Second)
+	}
+	listeners := make(map[string]listenAndServer)
+	if self.tcp != nil {
+		listeners["tcp"] = self.tcp
+	}
+	if self.http != nil {
+		listeners["http"] = self.http
+	}
+	if self.grpc != nil {
+		listeners["grpc"] = self.grpc
+	}
+	for name, s := range listeners {
+		go func(name string, s listenAndServer) {
+			if err := s.ListenAndServe(); err != nil {
+				log.Error(fmt.Sprintf("[server:Start] %s server stopped: %s", name, err.Error()))
+			}
+		}(name, s)
+	}
+	return nil
+}
+
+// Run starts the server and blocks until SIGINT/SIGTERM has shut it down
+func (self *Server) Run() (err error) {
+	if err = self.Start(); err != nil {
+		return err
+	}
+	defer self.stopFlusher()
+	defer self.stopUsage()
+	defer self.stopAuditor()
+	defer self.stopEvictor()
+	defer self.stopPurger()
+	defer self.stopFollower()
+	defer self.stopElector()
+	defer self.stopGossip()
+	return WaitForShutdown(self.swarmdb, self.config.GetSWARMDBUser(), self.config.GetShutdownTimeout(), self.shutdowners()...)
+}
+
+func (self *Server) shutdowners() (servers []Shutdowner) {
+	if self.tcp != nil {
+		servers = append(servers, self.tcp)
+	}
+	if self.http != nil {
+		servers = append(servers, self.http)
+	}
+	if self.grpc != nil {
+		servers = append(servers, self.grpc)
+	}
+	return servers
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"sort"
+	"sync"
+)
+
+// A sharded table splits its primary key space into ranges, each held by an ordinary sub-table "<table>.<n>" with
+// its own index root hashes, so that no single B+tree grows with the whole table and shards flush independently.
+// The table itself holds no rows: its descriptor lists the split keys, shard n holding the keys from split n-1
+// (inclusive) up to split n (exclusive).  Get, Put, Delete and Increment are routed to the shard of the key, Scans
+// run on every shard in parallel and are joined in key order, range scans walk the shards in key order.
+//
+// The split keys are stored in the unused front of the descriptor chunk, after the flush policy:
+//
+//	540-544 magic, 544 number of splits, 545- split keys of K_SIZE bytes
+const (
+	SHARD_MAGIC_START  = 540
+	SHARD_COUNT_BYTE   = 544
+	SHARD_SPLITS_START = 545
+	SHARD_SPLITS_END   = 1024 // ACL_START
+
+	SHARD_MAX = 1 + (SHARD_SPLITS_END-SHARD_SPLITS_START)/K_SIZE
+)
+
+var SHARD_MAGIC = []byte("shd\x01")
+
+func readShardSplits(descriptor []byte) (splits [][]byte) {
+	if !bytes.Equal(descriptor[SHARD_MAGIC_START:SHARD_COUNT_BYTE], SHARD_MAGIC) {
+		return nil
+	}
+	n := int(descriptor[SHARD_COUNT_BYTE])
+	for i := 0; i < n && SHARD_SPLITS_START+(i+1)*K_SIZE <= SHARD_SPLITS_END; i++ {
+		split := make([]byte, K_SIZE)
+		copy(split, descriptor[SHARD_SPLITS_START+i*K_SIZE:])
+		splits = append(splits, split)
+	}
+	return splits
+}
+
+func writeShardSplits(descriptor []byte, splits [][]byte) {
+	if len(splits) == 0 {
+		return
+	}
+	copy(descriptor[SHARD_MAGIC_START:], SHARD_MAGIC)
+	descriptor[SHARD_COUNT_BYTE] = byte(len(splits))
+	for i, split := range splits {
+		copy(descriptor[SHARD_SPLITS_START+i*K_SIZE:SHARD_SPLITS_START+(i+1)*K_SIZE], split)
+	}
+}
+
+func shardTableName(tableName string, i int) string {
+	return fmt.Sprintf("%s.%d", tableName, i)
+}
+
+// IsSharded reports whether the rows of the table are held by shards
+func (t *Table) IsSharded() bool {
+	return len(t.shardSplits) > 0
+}
+
+// CreateShardedTable creates a table whose primary keys are split into len(splits)+1 shards at the given
+// ascending primary key values
+func (self *SwarmDB) CreateShardedTable(u *SWARMDBUser, owner string, database string, tableName string, columns []sdbc.Column, splits []interface{}) (tbl *Table, err error) {
+	if len(splits) == 0 || len(splits)+1 > SHARD_MAX {
+		return tbl, &sdbc.SWARMDBError{Message: fmt.Sprintf("[shard:CreateShardedTable] %d splits", len(splits)), ErrorCode: 503, ErrorMessage: fmt.Sprintf("Invalid Shards: a sharded table has 2 to %d shards", SHARD_MAX)}
+	}
+	if len(shardTableName(tableName, len(splits))) > TABLE_NAME_LENGTH_MAX {
+		return tbl, &sdbc.SWARMDBError{Message: fmt.Sprintf("[shard:CreateShardedTable] shard names of %s too long", tableName), ErrorCode: 500, ErrorMessage: fmt.Sprintf("Max table name length exceeded")}
+	}
+	var primary *sdbc.Column
+	for i := range columns {
+		if columns[i].Primary > 0 {
+			primary = &columns[i]
+		}
+	}
+	if primary == nil {
+		return tbl, &sdbc.SWARMDBError{Message: fmt.Sprintf("[shard:CreateShardedTable] no primary column indicated"), ErrorCode: 405, ErrorMessage: "No Primary Key specified in Create Table"}
+	}
+	cmp := keyComparator(primary.ColumnType)
+	keys := make([][]byte, len(splits))
+	for i, split := range splits {
+		k, err := convertJSONValueToKey(primary.ColumnType, split)
+		if err != nil {
+			return tbl, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[shard:CreateShardedTable] convertJSONValueToKey %s", err.Error()))
+		}
+		keys[i] = padKey(k)
+		if i > 0 && cmp(keys[i-1], keys[i]) >= 0 {
+			return tbl, &sdbc.SWARMDBError{Message: fmt.Sprintf("[shard:CreateShardedTable] split %v not above %v", split, splits[i-1]), ErrorCode: 503, ErrorMessage: "Invalid Shards: split keys must be ascending"}
+		}
+	}
+
+	for i := 0; i <= len(splits); i++ {
+		if _, err = self.CreateTable(u, owner, database, shardTableName(tableName, i), columns); err != nil {
+			return tbl, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[shard:CreateShardedTable] CreateTable shard %d %s", i, err.Error()))
+		}
+	}
+	if tbl, err = self.CreateTable(u, owner, database, tableName, columns); err != nil {
+		return tbl, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[shard:CreateShardedTable] CreateTable %s", err.Error()))
+	}
+	tbl.shardSplits = keys
+	if err = tbl.updateTableInfo(u); err != nil {
+		return tbl, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[shard:CreateShardedTable] updateTableInfo %s", err.Error()))
+	}
+	return tbl, nil
+}
+
+// shardOf is the index of the shard holding the primary key k
+func (t *Table) shardOf(k []byte) int {
+	cmp := keyComparator(t.columns[t.primaryColumnName].columnType)
+	k = padKey(k)
+	return sort.Search(len(t.shardSplits), func(i int) bool { return cmp(k, t.shardSplits[i]) < 0 })
+}
+
+// shard opens shard i; shards of a snapshot are read in their own snapshot
+func (t *Table) shard(u *SWARMDBUser, i int) (shard *Table, err error) {
+	shard, err = t.swarmdb.GetTable(u, t.Owner, t.Database, shardTableName(t.tableName, i))
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[shard:shard] GetTable %s", err.Error()))
+	}
+	if t.snapshot {
+		return shard.readView(u)
+	}
+	return shard, nil
+}
+
+// shardFor opens the shard holding the primary key value key
+func (t *Table) shardFor(u *SWARMDBUser, key interface{}) (shard *Table, err error) {
+	primary, err := t.getPrimaryColumn()
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[shard:shardFor] getPrimaryColumn %s", err.Error()))
+	}
+	k, ok := key.([]byte)
+	if !ok {
+		if k, err = convertJSONValueToKey(primary.columnType, key); err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[shard:shardFor] convertJSONValueToKey %s", err.Error()))
+		}
+	}
+	return t.shard(u, t.shardOf(k))
+}
+
+// eachShard calls fn on every shard in parallel and returns the first error
+func (t *Table) eachShard(u *SWARMDBUser, fn func(i int, shard *Table) error) (err error) {
+	errs := make([]error, len(t.shardSplits)+1)
+	var wg sync.WaitGroup
+	for i := range errs {
+		shard, err := t.shard(u, i)
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func(i int, shard *Table) {
+			defer wg.Done()
+			errs[i] = fn(i, shard)
+		}(i, shard)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanShards scans every shard in parallel, for at most limit rows each, and joins the rows in key order.  The shards
+// split the primary keys, so the rows of a secondary index are sorted again by their cell of the column.
+func (t *Table) scanShards(u *SWARMDBUser, columnName string, ascending int, limit int) (rows []sdbc.Row, err error) {
+	column, err := t.getColumn(columnName)
+	if err != nil {
+		return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[shard:scanShards] getColumn %s", err.Error()))
+	}
+	parts := make([][]sdbc.Row, len(t.shardSplits)+1)
+	err = t.eachShard(u, func(i int, shard *Table) (err error) {
+		parts[i], err = shard.ScanLimit(u, columnName, ascending, limit)
+		return err
+	})
+	if err != nil {
+		return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[shard:scanShards] %s", err.Error()))
+	}
+	for i := range parts {
+		if ascending != 1 {
+			i = len(parts) - 1 - i
+		}
+		rows = append(rows, parts[i]...)
+	}
+	if column.primary == 0 {
+		rows = sortRowsByColumn(rows, column, ascending)
+	}
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	log.Debug(fmt.Sprintf("[shard:scanShards] %d rows from %d shards of %s", len(rows), len(parts), t.tableName))
+	return rows, nil
+}
+
+// sortRowsByColumn returns rows in the order the index of column keeps their cells, rows with equal cells keeping
+// their order
+func sortRowsByColumn(rows []sdbc.Row, column *ColumnInfo, ascending int) []sdbc.Row {
+	cmp := keyComparator(column.columnType)
+	keys := make([][]byte, len(rows))
+	order := make([]int, len(rows))
+	for i, row := range rows {
+		k, _ := convertJSONValueToKey(column.columnType, row[column.columnName])
+		keys[i] = make([]byte, K_SIZE)
+		copy(keys[i], k)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		c := cmp(keys[order[i]], keys[order[j]])
+		if ascending != 1 {
+			return c > 0
+		}
+		return c < 0
+	})
+	sorted := make([]sdbc.Row, len(rows))
+	for i, n := range order {
+		sorted[i] = rows[n]
+	}
+	return sorted
+}
+
+// scanRangeShards runs ScanRange over the shards overlapping [start, end) in key order
+func (t *Table) scanRangeShards(u *SWARMDBUser, start []byte, end []byte, ascending int, fn func(k []byte, row sdbc.Row) bool) (err error) {
+	first, last := 0, len(t.shardSplits)
+	if start != nil {
+		first = t.shardOf(start)
+	}
+	if end != nil {
+		last = t.shardOf(end)
+	}
+	more := true
+	for n := first; n <= last && more; n++ {
+		i := n
+		if ascending != 1 {
+			i = first + last - n
+		}
+		shard, err := t.shard(u, i)
+		if err != nil {
+			return err
+		}
+		err = shard.ScanRange(u, start, end, ascending, func(k []byte, row sdbc.Row) bool {
+			more = fn(k, row)
+			return more
+		})
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[shard:scanRangeShards] shard %d %s", i, err.Error()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Shutdowner is implemented by TCPServer, HTTPServer and GRPCServer
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// Close flushes every open table, which stores its descriptor and publishes its final root hash to
+// subscribers, then closes the local stores.  The first error is returned after all tables were attempted.
+func (self *SwarmDB) Close(u *SWARMDBUser) (err error) {
+	for _, tbl := range self.openTables() {
+		tblKey := self.GetTableKey(tbl.Owner, tbl.Database, tbl.tableName)
+		if ferr := tbl.FlushBuffer(u); ferr != nil {
+			log.Debug(fmt.Sprintf("[shutdown:Close] FlushBuffer [%s] %s", tblKey, ferr.Error()))
+			if err == nil {
+				err = sdbc.GenerateSWARMDBError(ferr, fmt.Sprintf("[shutdown:Close] FlushBuffer [%s] %s", tblKey, ferr.Error()))
+			}
+			continue
+		}
+		tbl.buffered = false
+		log.Debug(fmt.Sprintf("[shutdown:Close] flushed [%s]", tblKey))
+	}
+	if serr := self.Netstats.Save(); serr != nil && err == nil {
+		err = sdbc.GenerateSWARMDBError(serr, fmt.Sprintf("[shutdown:Close] Netstats.Save %s", serr.Error()))
+	}
+	if serr := self.usage.Save(); serr != nil && err == nil {
+		err = sdbc.GenerateSWARMDBError(serr, fmt.Sprintf("[shutdown:Close] usage.Save %s", serr.Error()))
+	}
+	if serr := self.ledger.Save(); serr != nil && err == nil {
+		err = sdbc.GenerateSWARMDBError(serr, fmt.Sprintf("[shutdown:Close] ledger.Save %s", serr.Error()))
+	}
+	if cerr := self.swapdb.Close(); cerr != nil && err == nil {
+		err = sdbc.GenerateSWARMDBError(cerr, fmt.Sprintf("[shutdown:Close] swapdb.Close %s", cerr.Error()))
+	}
+	if cerr := self.ens.Close(); cerr != nil && err == nil {
+		err = sdbc.GenerateSWARMDBError(cerr, fmt.Sprintf("[shutdown:Close] ens.Close %s", cerr.Error()))
+	}
+	if cerr := self.dbchunkstore.Close(); cerr != nil && err == nil {
+		err = sdbc.GenerateSWARMDBError(cerr, fmt.Sprintf("[shutdown:Close] dbchunkstore.Close %s", cerr.Error()))
+	}
+	return err
+}
+
+// WaitForShutdown blocks until SIGINT or SIGTERM, then stops the servers (giving in-flight requests up to timeout)
+// and closes swarmdb.  The wolkdb server calls this after starting its listeners.
+func WaitForShutdown(swarmdb *SwarmDB, u *SWARMDBUser, timeout time.Duration, servers ...Shutdowner) (err error) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+	sig := <-sigc
+	log.Info(fmt.Sprintf("[shutdown:WaitForShutdown] received %s, shutting down", sig))
+	return Shutdown(swarmdb, u, timeout, servers...)
+}
+
+// Shutdown stops the servers and then closes swarmdb, so that no request can write after the final flush
+func Shutdown(swarmdb *SwarmDB, u *SWARMDBUser, timeout time.Duration, servers ...Shutdowner) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, srv := range servers {
+		if serr := srv.Shutdown(ctx); serr != nil {
+			log.Debug(fmt.Sprintf("[shutdown:Shutdown] %s", serr.Error()))
+			if err == nil {
+				err = serr
+			}
+		}
+	}
+	if cerr := swarmdb.Close(u); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
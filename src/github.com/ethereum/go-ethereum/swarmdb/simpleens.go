@@ -99,7 +99,7 @@ func (self *ENSSimple) StoreRootHash(indexName []byte, roothash []byte) (err err
 	if err2 != nil {
 		return err // log.Fatalf("Failed to set Content: %v", err2)
 	}
-	fmt.Printf("i32: %x r32: %x tx: %v\n", i32, r32, tx.Hash())
+	ensLog.Debug("SetContent", "index", fmt.Sprintf("%x", i32), "roothash", fmt.Sprintf("%x", r32), "tx", tx.Hash().Hex())
 
 	/*
 		sql_add := `INSERT OR REPLACE INTO ens ( indexName, roothash, storeDT ) values(?, ?, CURRENT_TIMESTAMP)`
@@ -149,7 +149,7 @@ func (self *ENSSimple) GetRootHash(indexName []byte) (val []byte, err error) {
 	//s, err := sens.Content(b)
 	s, err := self.sens.Content(nil, b2)
 	if err != nil {
-		fmt.Printf("GetContent failed:  %v", err)
+		ensLog.Warn("GetContent failed", "err", err)
 		return val, err
 	}
 	val = make([]byte, 32)
@@ -160,6 +160,6 @@ func (self *ENSSimple) GetRootHash(indexName []byte) (val []byte, err error) {
 		}
 	}
 	//copy(val[0:], s[0:32])
-	fmt.Printf("indexName: [%x] => s: [%x] val: [%x]\n", indexName, s, val)
+	ensLog.Debug("GetContent", "index", fmt.Sprintf("%x", indexName), "content", fmt.Sprintf("%x", s), "roothash", fmt.Sprintf("%x", val))
 	return val, nil
 }
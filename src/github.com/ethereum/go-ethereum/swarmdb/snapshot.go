@@ -0,0 +1,93 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+)
+
+// Snapshot returns a read-only copy of the table pinned to the root hashes its column indexes last flushed, so that a
+// long Scan over it never observes a half-flushed mixture of old and new nodes while writes go on in the table.
+// Writes still buffered when the snapshot is taken are not visible in it.  A flush stores changed index nodes under
+// new hashes (copy-on-write), so the pinned roots stay readable; row values are stored by key, so the snapshot reads
+// each row in the version that was current when it was taken (see mvcc.go).
+func (t *Table) Snapshot(u *SWARMDBUser) (snap *Table, err error) {
+	snap = &Table{swarmdb: t.swarmdb, tableName: t.tableName, Owner: t.Owner, Database: t.Database, roothash: t.roothash, primaryColumnName: t.primaryColumnName, encrypted: t.encrypted, acl: t.copyACL(), snapshot: true, asOfMs: nowMs(), shardSplits: t.shardSplits, familyMask: t.familyMask}
+	snap.columns = make(map[string]*ColumnInfo)
+	primaryColumnType := sdbc.ColumnType(sdbc.CT_INTEGER)
+	if primary, ok := t.columns[t.primaryColumnName]; ok {
+		primaryColumnType = primary.columnType
+	}
+	for name, c := range t.columns {
+		pinned := &ColumnInfo{columnName: c.columnName, indexType: c.indexType, roothash: c.dbaccess.GetRootHash(), primary: c.primary, columnType: c.columnType, encrypted: c.encrypted, restricted: c.restricted, family: c.family}
+		switch c.indexType {
+		case sdbc.IT_BPLUSTREE:
+			pinned.dbaccess, err = NewBPlusTreeDB(u, t.swarmdb, pinned.roothash, c.columnType, c.primary == 0, primaryColumnType, t.encrypted)
+		case sdbc.IT_HASHTREE:
+			pinned.dbaccess, err = NewHashDB(u, pinned.roothash, t.swarmdb, c.columnType, t.encrypted)
+		default:
+			pinned.dbaccess = c.dbaccess
+		}
+		if err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[snapshot:Snapshot] column %s %s", name, err.Error()))
+		}
+		snap.columns[name] = pinned
+	}
+	return snap, nil
+}
+
+// snapshotAt is a Snapshot reading the row versions current at asOfMs with its primary index pinned to primaryRoot,
+// when given: the table as it was when an earlier Snapshot was taken, for the later pages of a scan of it
+func (t *Table) snapshotAt(u *SWARMDBUser, primaryRoot []byte, asOfMs int64) (snap *Table, err error) {
+	if snap, err = t.Snapshot(u); err != nil {
+		return nil, err
+	}
+	snap.asOfMs = asOfMs
+	if len(primaryRoot) == 0 {
+		return snap, nil
+	}
+	primary, err := snap.getPrimaryColumn()
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[snapshot:snapshotAt] getPrimaryColumn %s", err.Error()))
+	}
+	if primary.indexType != sdbc.IT_BPLUSTREE {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[snapshot:snapshotAt] column [%s] is not ordered", primary.columnName), ErrorCode: 431, ErrorMessage: fmt.Sprintf("Scans on Column [%s] not unsupported due to indextype", primary.columnName)}
+	}
+	pinned := *primary
+	pinned.roothash = primaryRoot
+	if pinned.dbaccess, err = NewBPlusTreeDB(u, t.swarmdb, primaryRoot, primary.columnType, false, primary.columnType, t.encrypted); err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[snapshot:snapshotAt] NewBPlusTreeDB %s", err.Error()))
+	}
+	snap.columns[primary.columnName] = &pinned
+	return snap, nil
+}
+
+// checkWritable refuses writes to snapshots, to the tables of a replica and to tables of owners another node leads
+func (t *Table) checkWritable() (err error) {
+	if err = t.swarmdb.checkWritable(); err != nil {
+		return err
+	}
+	if t.swarmdb.elector != nil {
+		if err = t.swarmdb.elector.checkLeader(t.Owner); err != nil {
+			return err
+		}
+	}
+	if t.snapshot {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[snapshot:checkWritable] write to a snapshot of %s", t.tableName), ErrorCode: 418, ErrorMessage: "Request Invalid: table snapshots are read-only"}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"container/list"
+	"github.com/ethereum/go-ethereum/crypto"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"sync"
+)
+
+// Applications send the same statements over and over, so RT_QUERY keeps the parsed form of every statement text it
+// parsed, and the plan of the statement on the table it ran on, in a cache of the most recently used statements.  A
+// plan only depends on the statement and the columns of the table, so it is kept with the schema version of the
+// table (see schemaVersion) and planned again when the table has other columns, e.g. was dropped and created again;
+// the row estimate of a cached plan is refreshed from the histograms on every use.  Parsing does not depend on the
+// table, so parsed statements are only evicted when the cache is full.
+const STATEMENT_CACHE_ENTRIES = 256 // statements kept when config.StatementCache is 0
+
+type statementCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]*list.Element
+	order   *list.List // of *statementCacheEntry, most recently used first
+	hits    int
+	misses  int
+}
+
+type statementCacheEntry struct {
+	statement string
+	query     QueryOption
+	plan      *QueryPlan // on the table planTable with the schema version schema; nil until planned
+	planTable string
+	schema    []byte
+}
+
+// newStatementCache returns a cache of max statements, or nil, which caches nothing, when max is negative
+func newStatementCache(max int) *statementCache {
+	if max < 0 {
+		return nil
+	}
+	if max == 0 {
+		max = STATEMENT_CACHE_ENTRIES
+	}
+	return &statementCache{max: max, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// parse returns ParseQuery(statement), parsing it only when it is not cached
+func (c *statementCache) parse(statement string) (query QueryOption, err error) {
+	if c == nil {
+		return ParseQuery(statement)
+	}
+	c.mu.Lock()
+	if e, ok := c.entries[statement]; ok {
+		c.hits++
+		c.order.MoveToFront(e)
+		query = copyQuery(e.Value.(*statementCacheEntry).query)
+		c.mu.Unlock()
+		return query, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	if query, err = ParseQuery(statement); err != nil {
+		return query, err
+	}
+	query.statement = statement
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[statement]; !ok {
+		c.entries[statement] = c.order.PushFront(&statementCacheEntry{statement: statement, query: query})
+		for c.order.Len() > c.max {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*statementCacheEntry).statement)
+		}
+	}
+	return copyQuery(query), nil
+}
+
+// copyQuery copies what the executor may change of a cached query: the rows it inserts and the cells it sets
+func copyQuery(query QueryOption) QueryOption {
+	query.Inserts = copyRows(query.Inserts)
+	if query.Update != nil {
+		update := make(map[string]interface{}, len(query.Update))
+		for k, v := range query.Update {
+			update[k] = v
+		}
+		query.Update = update
+	}
+	return query
+}
+
+// plan returns t.planQuery(query), planning it only when the plan of the statement query was parsed from is not
+// cached for the current schema of t
+func (c *statementCache) plan(t *Table, query *QueryOption) (plan *QueryPlan) {
+	if c == nil || len(query.statement) == 0 {
+		return t.planQuery(query)
+	}
+	tblKey, schema := t.swarmdb.GetTableKey(t.Owner, t.Database, t.tableName), t.schemaVersion()
+	c.mu.Lock()
+	var cached *QueryPlan
+	e, ok := c.entries[query.statement]
+	if ok {
+		entry := e.Value.(*statementCacheEntry)
+		if entry.plan != nil && entry.planTable == tblKey && bytes.Equal(entry.schema, schema) {
+			cached = entry.plan
+		}
+	}
+	c.mu.Unlock()
+	if cached != nil {
+		copied := *cached
+		copied.Estimate = -1
+		if rows, ok := t.estimateRows(query.Where); ok {
+			copied.Estimate = rows
+		}
+		return &copied
+	}
+
+	plan = t.planQuery(query)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[query.statement]; ok {
+		entry := e.Value.(*statementCacheEntry)
+		entry.plan, entry.planTable, entry.schema = plan, tblKey, schema
+	}
+	return plan
+}
+
+// stats adds the entry count, hits and misses of the cache to row
+func (c *statementCache) stats(row sdbc.Row) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	row["statementCacheEntries"] = c.order.Len()
+	row["statementCacheHits"] = c.hits
+	row["statementCacheMisses"] = c.misses
+}
+
+// schemaVersion identifies the columns of t: their names, types, indexes and attributes, but not their contents
+func (t *Table) schemaVersion() []byte {
+	var buf bytes.Buffer
+	for _, name := range t.columnOrder() {
+		c := t.columns[name]
+		ct, _ := ColumnTypeToInt(c.columnType)
+		buf.WriteString(name)
+		buf.Write([]byte{0, c.primary, byte(ct), byte(IndexTypeToInt(c.indexType)), c.family})
+		for _, attribute := range []bool{c.encrypted, c.restricted} {
+			if attribute {
+				buf.WriteByte(1)
+			} else {
+				buf.WriteByte(0)
+			}
+		}
+	}
+	return crypto.Keccak256(buf.Bytes())
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+)
+
+// ScanStream and QueryStream run an RT_SCAN or RT_QUERY request with the checks of SelectHandler, but hand each row to
+// a callback as it is read from the index, rather than collecting the rows in a response, so that a server with a
+// streaming transport (see grpcserver.go) never holds a large result in memory.  Requests another node answers,
+// statements other than SELECT, SELECTs on views and EXPLAINs go through SelectHandler, and the callback gets the
+// rows of its response.
+
+// ScanStream calls fn with every row of the table of the RT_SCAN request d that u may read, in primary key order
+func (self *SwarmDB) ScanStream(u *SWARMDBUser, d *sdbc.RequestOption, fn func(row sdbc.Row) error) (err error) {
+	u, local, err := self.beginStream(u, d)
+	if err != nil || !local {
+		return self.streamResponse(u, d, fn, err)
+	}
+	done := traceRequest(u, d.RequestType, d.Owner, d.Database, d.Table)
+	defer func() { done(err) }()
+	tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[stream:ScanStream] GetTable %s", err.Error()))
+	}
+	hidden, err := tbl.checkRead(u)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[stream:ScanStream] checkRead %s", err.Error()))
+	}
+	return tbl.scanRows(u, 1, func(row sdbc.Row) (bool, error) {
+		return true, fn(maskRow(row, hidden))
+	})
+}
+
+// QueryStream calls fn with every row the RT_QUERY request d answers
+func (self *SwarmDB) QueryStream(u *SWARMDBUser, d *sdbc.RequestOption, fn func(row sdbc.Row) error) (err error) {
+	u, local, err := self.beginStream(u, d)
+	if err != nil || !local {
+		return self.streamResponse(u, d, fn, err)
+	}
+	query, err := self.statements.parse(d.RawQuery)
+	if err != nil || query.Type != "Select" || query.Explain {
+		return self.streamResponse(u, d, fn, nil)
+	}
+	if len(d.Table) == 0 {
+		d.Table = query.Table
+	}
+	if view, err := self.GetView(d.Owner, d.Database, d.Table); err != nil || view != nil {
+		return self.streamResponse(u, d, fn, nil)
+	}
+	done := traceRequest(u, d.RequestType, d.Owner, d.Database, d.Table)
+	defer func() { done(err) }()
+	query.Owner = d.Owner
+	query.Database = d.Database
+	query.Encrypted = d.Encrypted
+	tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[stream:QueryStream] GetTable %s", err.Error()))
+	}
+	hidden, tblInfo, err := tbl.checkQuery(u, &query)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[stream:QueryStream] checkQuery %s", err.Error()))
+	}
+	for _, p := range query.Where.Predicates() {
+		if _, ok := tblInfo[p.Left]; !ok && len(p.Left) > 0 {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[stream:QueryStream] Query col [%s] does not exist in table", p.Left), ErrorCode: 432, ErrorMessage: fmt.Sprintf("WHERE Clause contains invalid column [%s]", p.Left)}
+		}
+	}
+	self.usage.add(d.Owner, 0, 0, 0, 1)
+	return self.QuerySelectEach(u, &query, func(row sdbc.Row) (bool, error) {
+		return true, fn(maskRow(row, hidden))
+	})
+}
+
+// beginStream validates d and returns the user to run it with; local is false when SelectHandler has to run it
+func (self *SwarmDB) beginStream(u *SWARMDBUser, d *sdbc.RequestOption) (_ *SWARMDBUser, local bool, err error) {
+	if len(u.TraceID()) == 0 {
+		u = u.WithTrace(NewTraceID())
+	}
+	if err = validateRequest(d); err != nil {
+		return u, false, err
+	}
+	if err = u.checkDeadline("stream:beginStream"); err != nil {
+		return u, false, err
+	}
+	if self.gossip != nil {
+		if _, remote := self.gossip.remote(u, d); remote {
+			return u, false, nil
+		}
+	}
+	if !isReplicaRead(d) {
+		return u, false, nil
+	}
+	return self.withQueryBudget(u), true, nil
+}
+
+// streamResponse runs d through SelectHandler, unless err, and calls fn with the rows of its response
+func (self *SwarmDB) streamResponse(u *SWARMDBUser, d *sdbc.RequestOption, fn func(row sdbc.Row) error, err error) error {
+	if err != nil {
+		return err
+	}
+	resp, err := self.HandleRequest(u, d)
+	if err != nil {
+		return err
+	}
+	for _, row := range resp.Data {
+		if err = fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanRows calls fn with every row of a snapshot of t, typed as its columns, in ascending or descending primary key
+// order, until fn returns false or an error
+func (t *Table) scanRows(u *SWARMDBUser, ascending int, fn func(row sdbc.Row) (bool, error)) (err error) {
+	snap, err := t.readView(u)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[stream:scanRows] readView %s", err.Error()))
+	}
+	return snap.ScanEach(u, ascending, func(r *RowView) (bool, error) {
+		row, ok, err := r.Row()
+		if err != nil || !ok {
+			return err == nil, err
+		}
+		rows, err := t.assignRowColumnTypes([]sdbc.Row{row})
+		if err != nil {
+			return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[stream:scanRows] assignRowColumnTypes %s", err.Error()))
+		}
+		return fn(rows[0])
+	})
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Struct mapping: exported fields map onto columns by their `swarmdb:"column"` tag, or by field name when untagged.
+// `swarmdb:"-"` skips a field and `swarmdb:"column,omitempty"` leaves zero values out of the row.
+// Besides numbers and strings, time.Time is stored as RFC3339 in string columns and as unix seconds in integer columns.
+var timeType = reflect.TypeOf(time.Time{})
+
+type structField struct {
+	index     int
+	column    string
+	omitempty bool
+}
+
+// structFields lists the mapped fields of struct type typ
+func structFields(typ reflect.Type) (fields []structField) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if len(f.PkgPath) > 0 {
+			continue // unexported
+		}
+		field := structField{index: i, column: f.Name}
+		if tag, ok := f.Tag.Lookup("swarmdb"); ok {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if len(parts[0]) > 0 {
+				field.column = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" {
+					field.omitempty = true
+				}
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// structValue returns the struct v points to (or is)
+func structValue(v interface{}, settable bool) (sv reflect.Value, err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	} else if settable {
+		return sv, &sdbc.SWARMDBError{Message: fmt.Sprintf("[struct:structValue] %T is not a pointer to a struct", v), ErrorCode: 435, ErrorMessage: "Invalid Row Data: expected a pointer to a struct"}
+	}
+	if rv.Kind() != reflect.Struct {
+		return sv, &sdbc.SWARMDBError{Message: fmt.Sprintf("[struct:structValue] %T is not a struct", v), ErrorCode: 435, ErrorMessage: "Invalid Row Data: expected a struct"}
+	}
+	return rv, nil
+}
+
+// StructToRow converts the struct v into a row of the table
+func (t *Table) StructToRow(v interface{}) (row sdbc.Row, err error) {
+	sv, err := structValue(v, false)
+	if err != nil {
+		return row, err
+	}
+	row = sdbc.NewRow()
+	for _, field := range structFields(sv.Type()) {
+		c, ok := t.columns[field.column]
+		if !ok {
+			return row, &sdbc.SWARMDBError{Message: fmt.Sprintf("[struct:StructToRow] Invalid column %s", field.column), ErrorCode: 404, ErrorMessage: fmt.Sprintf("Column Does Not Exist in table definition: [%s]", field.column)}
+		}
+		fv := sv.Field(field.index)
+		if field.omitempty && isZero(fv) {
+			continue
+		}
+		value, err := fieldToCell(c.columnType, fv)
+		if err != nil {
+			return row, &sdbc.SWARMDBError{Message: fmt.Sprintf("[struct:StructToRow] %s: %s", field.column, err.Error()), ErrorCode: 427, ErrorMessage: fmt.Sprintf("The value passed in for [%s] cannot be converted to the column type", field.column)}
+		}
+		row[field.column] = value
+	}
+	return row, nil
+}
+
+// RowToStruct fills the struct v points to from row; columns without a field are ignored
+func (t *Table) RowToStruct(row sdbc.Row, v interface{}) (err error) {
+	sv, err := structValue(v, true)
+	if err != nil {
+		return err
+	}
+	for _, field := range structFields(sv.Type()) {
+		cell, ok := row[field.column]
+		if !ok || cell == nil {
+			continue
+		}
+		if err = cellToField(cell, sv.Field(field.index)); err != nil {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[struct:RowToStruct] %s: %s", field.column, err.Error()), ErrorCode: 427, ErrorMessage: fmt.Sprintf("The value of [%s] cannot be converted to the field type", field.column)}
+		}
+	}
+	return nil
+}
+
+// PutStruct stores the struct v as a row
+func (t *Table) PutStruct(u *SWARMDBUser, v interface{}) (err error) {
+	row, err := t.StructToRow(v)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[struct:PutStruct] StructToRow %s", err.Error()))
+	}
+	if _, ok := row[t.primaryColumnName]; !ok {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[struct:PutStruct] primary column %s not mapped", t.primaryColumnName), ErrorCode: 428, ErrorMessage: fmt.Sprintf("Row missing primary key [%s]", t.primaryColumnName)}
+	}
+	rows, err := t.assignRowColumnTypes([]sdbc.Row{row})
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[struct:PutStruct] assignRowColumnTypes %s", err.Error()))
+	}
+	return t.Put(u, rows[0])
+}
+
+// GetStruct reads the row with primary key key into the struct v points to; ok is false if there is no such row
+func (t *Table) GetStruct(u *SWARMDBUser, key interface{}, v interface{}) (ok bool, err error) {
+	primary, err := t.getPrimaryColumn()
+	if err != nil {
+		return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[struct:GetStruct] getPrimaryColumn %s", err.Error()))
+	}
+	k, err := convertJSONValueToKey(primary.columnType, key)
+	if err != nil {
+		return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[struct:GetStruct] convertJSONValueToKey %s", err.Error()))
+	}
+	out, ok, err := t.Get(u, k)
+	if err != nil {
+		return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[struct:GetStruct] Get %s", err.Error()))
+	}
+	if !ok {
+		return false, nil
+	}
+	row, err := t.byteArrayToRow(out)
+	if err != nil {
+		return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[struct:GetStruct] byteArrayToRow %s", err.Error()))
+	}
+	if err = t.RowToStruct(row, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func isZero(fv reflect.Value) bool {
+	if fv.Type() == timeType {
+		return fv.Interface().(time.Time).IsZero()
+	}
+	return reflect.DeepEqual(fv.Interface(), reflect.Zero(fv.Type()).Interface())
+}
+
+// fieldToCell converts a field into the representation assignRowColumnTypes expects for columnType
+func fieldToCell(columnType sdbc.ColumnType, fv reflect.Value) (cell interface{}, err error) {
+	if fv.Type() == timeType {
+		ts := fv.Interface().(time.Time)
+		switch columnType {
+		case sdbc.CT_STRING:
+			return ts.Format(time.RFC3339Nano), nil
+		case sdbc.CT_INTEGER:
+			return int(ts.Unix()), nil
+		case sdbc.CT_FLOAT:
+			return float64(ts.UnixNano()) / float64(time.Second), nil
+		}
+		return nil, fmt.Errorf("time.Time cannot be stored in column type %v", columnType)
+	}
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(fv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(fv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), nil
+	case reflect.String:
+		return fv.String(), nil
+	case reflect.Bool:
+		if columnType == sdbc.CT_STRING {
+			return strconv.FormatBool(fv.Bool()), nil
+		}
+		if fv.Bool() {
+			return 1, nil
+		}
+		return 0, nil
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() == reflect.Uint8 && columnType == sdbc.CT_STRING {
+			return string(fv.Bytes()), nil
+		}
+	}
+	return nil, fmt.Errorf("%s cannot be stored in column type %v", fv.Type(), columnType)
+}
+
+// cellToField sets fv from a row value (string, int or float64 as produced by byteArrayToRow)
+func cellToField(cell interface{}, fv reflect.Value) (err error) {
+	var s string
+	var f float64
+	isNumber := true
+	switch c := cell.(type) {
+	case int:
+		f, s = float64(c), strconv.Itoa(c)
+	case float64:
+		f, s = c, strconv.FormatFloat(c, 'f', -1, 64)
+	case string:
+		s, isNumber = c, false
+	default:
+		return fmt.Errorf("unsupported value %v", cell)
+	}
+	if fv.Type() == timeType {
+		var ts time.Time
+		if isNumber {
+			ts = time.Unix(0, int64(f*float64(time.Second)))
+		} else if ts, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(ts))
+		return nil
+	}
+	if !isNumber {
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(s)
+			return nil
+		case reflect.Slice:
+			if fv.Type().Elem().Kind() == reflect.Uint8 {
+				fv.SetBytes([]byte(s))
+				return nil
+			}
+		case reflect.Bool:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return err
+			}
+			fv.SetBool(b)
+			return nil
+		}
+		if f, err = strconv.ParseFloat(s, 64); err != nil {
+			return err
+		}
+	}
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fv.SetInt(int64(f))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fv.SetUint(uint64(f))
+	case reflect.Float32, reflect.Float64:
+		fv.SetFloat(f)
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		fv.SetBool(f != 0)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+	return nil
+}
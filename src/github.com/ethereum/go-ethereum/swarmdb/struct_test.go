@@ -0,0 +1,63 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb_test
+
+import (
+	"testing"
+)
+
+type testPerson struct {
+	Email   string `swarmdb:"email"`
+	Name    string `swarmdb:"name"`
+	Age     uint8  `swarmdb:"age"`
+	Comment string `swarmdb:"-"`
+}
+
+func TestTableStruct(t *testing.T) {
+	owner, database, tableName := make_table(t, "struct")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[struct_test:TestTableStruct] GetTable %s", err)
+	}
+	in := testPerson{Email: "struct@wolk.com", Name: "Edsger", Age: 72, Comment: "not stored"}
+	if err = tbl.PutStruct(u, in); err != nil {
+		t.Fatalf("[struct_test:TestTableStruct] PutStruct %s", err)
+	}
+	var out testPerson
+	ok, err := tbl.GetStruct(u, "struct@wolk.com", &out)
+	if err != nil || !ok {
+		t.Fatalf("[struct_test:TestTableStruct] GetStruct %v %s", ok, err)
+	}
+	in.Comment = ""
+	if out != in {
+		t.Fatalf("[struct_test:TestTableStruct] GetStruct returned %+v, expected %+v", out, in)
+	}
+	if ok, err = tbl.GetStruct(u, "nobody@wolk.com", &out); err != nil || ok {
+		t.Fatalf("[struct_test:TestTableStruct] GetStruct of missing key %v %s", ok, err)
+	}
+
+	// fields must map onto columns and targets must be pointers
+	bad := struct {
+		Email string `swarmdb:"email"`
+		Shoe  int    `swarmdb:"shoesize"`
+	}{Email: "bad@wolk.com", Shoe: 11}
+	if err = tbl.PutStruct(u, bad); err == nil {
+		t.Fatalf("[struct_test:TestTableStruct] PutStruct with unknown column succeeded")
+	}
+	if _, err = tbl.GetStruct(u, "struct@wolk.com", out); err == nil {
+		t.Fatalf("[struct_test:TestTableStruct] GetStruct into a non-pointer succeeded")
+	}
+}
@@ -107,6 +107,10 @@ func NewSwapDBStore(config *SWARMDBConfig, netstats *Netstats) (self *SwapDBStor
 	return self, nil
 }
 
+func (self *SwapDBStore) Close() (err error) {
+	return self.db.Close()
+}
+
 func (self *SwapDBStore) Issue(amount int, localAddress common.Address, peerAddress common.Address) (ch *SwapCheck, err error) {
 	// compute the swapID = Keccak256(sender, beneficiary, amount, timestamp ...)
 	timestamp := time.Now()
@@ -19,22 +19,52 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
 	//sdbc "github.com/wolkdb/swarmdb/swarmdbcommon"
 	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
 	"path/filepath"
 	"strings"
 	"swarmdb/ash"
+	"sync"
 	"time"
 )
 
+
 type SwarmDB struct {
 	tables       map[string]*Table
+	tablesMu     sync.RWMutex  // guards tables, which the flusher (StartFlusher) reads in the background
 	dbchunkstore *DBChunkstore // Sqlite3 based
 	ens          ENSSimulation
 	swapdb       *SwapDBStore
 	Netstats     *Netstats
+	tableFeed    event.Feed         // TableEvents for subscribers
+	tableEvents  eventQueue         // TableEvents waiting to be sent on tableFeed, see events.go
+	replica      bool               // serves reads only, see replica.go
+	elector      *Elector           // elects the one node writing the tables of config.Election.Owners, see leader.go
+	gossip       *Gossip            // learns the tables other nodes serve, see gossip.go
+	placement    bool               // stores rows at MinReplication addresses in distinct neighborhoods, see placement.go
+	usage        *UsageMeter        // per owner usage for billing, see usage.go
+	ledger       *Ledger            // bids, balances and escrow of storage payments, see accounting.go
+	signRows     bool               // signs every row value written, see provenance.go
+	jsonRows     bool               // stores row values as JSON rather than binary, see rowcodec.go
+	queryCache   *queryCache        // SELECT results by table root hash, see querycache.go
+	statements   *statementCache    // parsed statements and their plans by text, see stmtcache.go
+	changeStream *changeStream      // committed row changes per table, nil unless config.ChangeStream, see cdc.go
+	triggers     triggerRegistry    // actions run on the writes of tables, see trigger.go
+	viewsMu      sync.Mutex         // serializes the definitions and refreshes of views, see view.go
+	keyspaces    keyspaceRegistry   // open schemaless keyspaces, see kv.go
+	collections  collectionRegistry // open document collections, see doc.go
+	graphs       graphRegistry      // open graphs, see graph.go
+	databaseACLs databaseACLCache   // ACLs of the databases of the tables checked, see database.go
+	owners       ownerCache         // controllers of the ENS name owners checked, see database.go
+	chunkBudget  int                // chunks a read request may retrieve, see budget.go
+	byteBudget   int64              // bytes of chunks a read request may retrieve
+	maxRowSize   int                // bytes of the largest row value stored, see limits.go
+	maxKeySize   int                // bytes of the largest string or blob primary key
 }
 
 //for sql parsing
@@ -49,6 +79,8 @@ type QueryOption struct {
 	Update         map[string]interface{} //'SET' portion: map[columnName]value
 	Where          Where
 	Ascending      int //1 true, 0 false (descending)
+	Explain        bool   // answer the plan instead of running the query, see plan.go
+	statement      string // the text the query was parsed from, when cached, see stmtcache.go
 }
 
 //for sql parsing
@@ -56,6 +88,7 @@ type Where struct {
 	Left     string
 	Right    string //all values are strings in query parsing
 	Operator string //sqlparser.ComparisonExpr.Operator; sqlparser.BinaryExpr.Operator; sqlparser.IsExpr.Operator; sqlparser.AndExpr.Operator, sqlparser.OrExpr.Operator
+	And      []Where // further predicates the rows must match, when the clause is a conjunction, see plan.go
 }
 
 type DBChunkstorage interface {
@@ -97,8 +130,8 @@ type Database interface {
 	// Possible errors: NetworkError
 	Close(u *SWARMDBUser) (bool, error)
 
-	// prints what is in memory
-	Print(u *SWARMDBUser)
+	// describes what is in memory, a line per node, for the caller to log
+	Print(u *SWARMDBUser) []string
 }
 
 type OrderedDatabase interface {
@@ -153,6 +186,14 @@ const (
 	CHUNK_END_DB             = 254
 	CHUNK_START_TABLE        = 254
 	CHUNK_END_TABLE          = 286
+	CHUNK_START_PREVVERSION  = 286 // hash of the chunk holding the previous version of the row, see mvcc.go
+	CHUNK_END_PREVVERSION    = 318
+	CHUNK_START_WRITER       = 318
+	CHUNK_END_WRITER         = 338
+	CHUNK_START_UPDATEMS     = 338 // wall-clock time of the write in unix milliseconds
+	CHUNK_END_UPDATEMS       = 346
+	CHUNK_START_ROWSIG       = 346 // signature of the row value by the node that wrote it, see provenance.go
+	CHUNK_END_ROWSIG         = 411
 	//CHUNK_START_EPOCHTS      = 254
 	//CHUNK_END_EPOCHTS        = 286
 	CHUNK_START_ITERATOR = 416
@@ -164,6 +205,16 @@ const (
 func NewSwarmDB(config *SWARMDBConfig) (swdb *SwarmDB, err error) {
 	sd := new(SwarmDB)
 	sd.tables = make(map[string]*Table)
+	sd.replica = config.Replica > 0
+	sd.placement = config.Placement > 0
+	sd.signRows = config.SignRows > 0
+	sd.jsonRows = config.JSONRows > 0
+	sd.queryCache = newQueryCache(config.QueryCache)
+	sd.statements = newStatementCache(config.StatementCache)
+	sd.chunkBudget = config.QueryChunkBudget
+	sd.byteBudget = config.QueryByteBudget
+	sd.maxRowSize = config.MaxRowSize
+	sd.maxKeySize = config.MaxKeySize
 
 	sd.Netstats = NewNetstats(config)
 	dbchunkstore, err := NewDBChunkStore(config, sd.Netstats)
@@ -171,12 +222,13 @@ func NewSwarmDB(config *SWARMDBConfig) (swdb *SwarmDB, err error) {
 		return swdb, sdbc.GenerateSWARMDBError(err, `[swarmdb:NewSwarmDB] NewDBChunkStore `+err.Error())
 	} else {
 		sd.dbchunkstore = dbchunkstore
+		sd.dbchunkstore.placement = sd.placement
+	}
+	if config.ChangeStream > 0 {
+		sd.changeStream = newChangeStream(dbchunkstore.ldb)
 	}
 
-	// default /tmp/ens.db
-	ensdbFileName := "ens.db"
-	ensdbFullPath := filepath.Join(config.ChunkDBPath, ensdbFileName)
-	ens, errENS := NewENSSimulation(ensdbFullPath)
+	ens, errENS := NewENSSimulation(config.GetENSDBPath())
 	if errENS != nil {
 		return swdb, sdbc.GenerateSWARMDBError(errENS, `[swarmdb:NewSwarmDB] NewENSSimulation `+errENS.Error())
 	}
@@ -190,6 +242,15 @@ func NewSwarmDB(config *SWARMDBConfig) (swdb *SwarmDB, err error) {
 	}
 	sd.swapdb = swapdbObj
 
+	sd.usage, err = NewUsageMeter(filepath.Join(config.ChunkDBPath, USAGE_FILE))
+	if err != nil {
+		return swdb, sdbc.GenerateSWARMDBError(err, `[swarmdb:NewSwarmDB] NewUsageMeter `+err.Error())
+	}
+
+	sd.ledger, err = NewLedger(filepath.Join(config.ChunkDBPath, LEDGER_FILE), config.Accounting, config.DefaultBid)
+	if err != nil {
+		return swdb, sdbc.GenerateSWARMDBError(err, `[swarmdb:NewSwarmDB] NewLedger `+err.Error())
+	}
 	return sd, nil
 }
 
@@ -246,74 +307,78 @@ func (self *SwarmDB) GetRootHash(u *SWARMDBUser, tblKey []byte /* GetTableKeyVal
 	return self.ens.GetRootHash(u, tblKey)
 }
 
-func (self *SwarmDB) StoreRootHash(u *SWARMDBUser, fullTableName []byte /* GetTableKey Value */, roothash []byte) (err error) {
-	return self.ens.StoreRootHash(u, fullTableName, roothash)
+// StoreRootHash publishes roothash for fullTableName; unless expected is nil it fails with ErrorCode 499 when another
+// writer published since expected was read
+func (self *SwarmDB) StoreRootHash(u *SWARMDBUser, fullTableName []byte /* GetTableKey Value */, expected []byte, roothash []byte) (err error) {
+	return self.ens.StoreRootHash(u, fullTableName, expected, roothash)
 }
 
 // parse sql and return rows in bulk (order by, group by, etc.)
 func (self *SwarmDB) QuerySelect(u *SWARMDBUser, query *QueryOption) (rows []sdbc.Row, err error) {
+	err = self.QuerySelectEach(u, query, func(row sdbc.Row) (bool, error) {
+		rows = append(rows, row)
+		return true, nil
+	})
+	return rows, err
+}
+
+// QuerySelectEach calls fn with each row query selects, cut down to the requested columns, as it is fetched, until fn
+// returns false or an error
+func (self *SwarmDB) QuerySelectEach(u *SWARMDBUser, query *QueryOption, fn func(row sdbc.Row) (bool, error)) (err error) {
 	table, err := self.GetTable(u, query.Owner, query.Database, query.Table)
 	if err != nil {
-		return rows, sdbc.GenerateSWARMDBError(err, `[swarmdb:QuerySelect] GetTable `+err.Error())
+		return sdbc.GenerateSWARMDBError(err, `[swarmdb:QuerySelectEach] GetTable `+err.Error())
 	}
-
-	//var rawRows []sdbc.Row
 	log.Debug(fmt.Sprintf("QueryOwner is: [%s]\n", query.Owner))
-	colRows, err := self.Scan(u, query.Owner, query.Database, query.Table, table.primaryColumnName, query.Ascending)
-	if err != nil {
-		return rows, sdbc.GenerateSWARMDBError(err, `[swarmdb:QuerySelect] Scan `+err.Error())
-	}
-	//fmt.Printf("\nColRows = [%+v]", colRows)
-
-	//apply WHERE
-	whereRows, err := table.applyWhere(colRows, query.Where)
-	if err != nil {
-		return rows, sdbc.GenerateSWARMDBError(err, `[swarmdb:QuerySelect] applyWhere `+err.Error())
-	}
-	log.Debug(fmt.Sprintf("QuerySelect applied where rows: %+v and number of rows returned = %d", whereRows, len(whereRows)))
-
-	//filter for requested columns
-	for _, row := range whereRows {
-		// fmt.Printf("QS b4 filterRowByColumns row: %+v\n", row)
+	//apply WHERE, then filter for requested columns
+	err = self.queryEach(u, table, query, func(row sdbc.Row) (bool, error) {
 		fRow := filterRowByColumns(row, query.RequestColumns)
-		// fmt.Printf("QS after filterRowByColumns row: %+v\n", fRow)
-		if len(fRow) > 0 {
-			rows = append(rows, fRow)
+		if len(fRow) == 0 {
+			return true, nil
 		}
+		return fn(fRow)
+	})
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, `[swarmdb:QuerySelectEach] queryEach `+err.Error())
 	}
-	// fmt.Printf("\nNumber of FINAL rows returned : %d", len(rows))
-
 	//TODO: Put it in order for Ascending/GroupBy
-	// fmt.Printf("\nQS returning: %+v\n", rows)
-	return rows, nil
+	return nil
 }
 
 // Insert is for adding new data to the table
 // example: 'INSERT INTO tablename (col1, col2) VALUES (val1, val2)
-func (self *SwarmDB) QueryInsert(u *SWARMDBUser, query *QueryOption) (affectedRows int, err error) {
+// keys holds a {primary key} row per UUID primary key made up for a row inserted without one
+func (self *SwarmDB) QueryInsert(u *SWARMDBUser, query *QueryOption) (keys []sdbc.Row, affectedRows int, err error) {
 
 	table, err := self.GetTable(u, query.Owner, query.Database, query.Table)
 	if err != nil {
-		return 0, sdbc.GenerateSWARMDBError(err, `[swarmdb:QueryInsert] GetTable `+err.Error())
+		return keys, 0, sdbc.GenerateSWARMDBError(err, `[swarmdb:QueryInsert] GetTable `+err.Error())
 	}
 	affectedRows = 0
 	for _, row := range query.Inserts {
+		key, err := table.generateKey(row)
+		if err != nil {
+			return keys, affectedRows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:QueryInsert] generateKey %s", err.Error()))
+		}
+		if key != nil {
+			keys = append(keys, key)
+		}
 		// check if primary column exists in Row
 		if _, ok := row[table.primaryColumnName]; !ok {
-			return affectedRows, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:QueryInsert] Insert row %+v needs primary column '%s' value", row, table.primaryColumnName), ErrorCode: 446, ErrorMessage: fmt.Sprintf("Insert Query Missing Primary Key [%]", table.primaryColumnName)}
+			return keys, affectedRows, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:QueryInsert] Insert row %+v needs primary column '%s' value", row, table.primaryColumnName), ErrorCode: 446, ErrorMessage: fmt.Sprintf("Insert Query Missing Primary Key [%]", table.primaryColumnName)}
 		}
 		// check if Row already exists
 		if _, ok := table.columns[table.primaryColumnName]; !ok {
-			return affectedRows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:QueryInsert] table.columns check - %s", err.Error()))
+			return keys, affectedRows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:QueryInsert] table.columns check - %s", err.Error()))
 		}
 		convertedKey, err := convertJSONValueToKey(table.columns[table.primaryColumnName].columnType, row[table.primaryColumnName])
 		if err != nil {
-			return affectedRows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:QueryInsert] convertJSONValueToKey - %s", err.Error()))
+			return keys, affectedRows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:QueryInsert] convertJSONValueToKey - %s", err.Error()))
 		}
 		_, ok, err := table.Get(u, convertedKey)
 		//log.Debug(fmt.Sprintf("Row already exists | [%s] | [%+v] | [%d]", existingByteRow, existingByteRow, len(existingByteRow)))
 		if ok {
-			return affectedRows, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:QueryInsert] Insert row key %s already exists | Error: %s", row[table.primaryColumnName], err), ErrorCode: 434, ErrorMessage: fmt.Sprintf("Record with key [%s] already exists.  If you wish to modify, please use UPDATE SQL statement or PUT", bytes.Trim(convertedKey, "\x00"))}
+			return keys, affectedRows, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:QueryInsert] Insert row key %s already exists | Error: %s", row[table.primaryColumnName], err), ErrorCode: 434, ErrorMessage: fmt.Sprintf("Record with key [%s] already exists.  If you wish to modify, please use UPDATE SQL statement or PUT", bytes.Trim(convertedKey, "\x00"))}
 		}
 		if err != nil {
 			//TODO: why is this uncommented?
@@ -322,11 +387,11 @@ func (self *SwarmDB) QueryInsert(u *SWARMDBUser, query *QueryOption) (affectedRo
 		// put the new Row in
 		err = table.Put(u, row)
 		if err != nil {
-			return affectedRows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:QueryInsert] Put %s", err.Error()))
+			return keys, affectedRows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:QueryInsert] Put %s", err.Error()))
 		}
 		affectedRows = affectedRows + 1
 	}
-	return affectedRows, nil
+	return keys, affectedRows, nil
 }
 
 // Update is for modifying existing data in the table (can use a Where clause)
@@ -337,11 +402,6 @@ func (self *SwarmDB) QueryUpdate(u *SWARMDBUser, query *QueryOption) (affectedRo
 		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:QueryUpdate] GetTable %s", err.Error()))
 	}
 
-	// get all rows with Scan, using primary key column
-	rawRows, err := self.Scan(u, query.Owner, query.Database, query.Table, table.primaryColumnName, query.Ascending)
-	if err != nil {
-		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:QueryUpdate] Scan %s", err.Error()))
-	}
 
 	// check to see if Update cols are in pulled set
 	for colname, _ := range query.Update {
@@ -351,9 +411,9 @@ func (self *SwarmDB) QueryUpdate(u *SWARMDBUser, query *QueryOption) (affectedRo
 	}
 
 	// apply WHERE clause
-	filteredRows, err := table.applyWhere(rawRows, query.Where)
+	filteredRows, err := self.queryRows(u, table, query)
 	if err != nil {
-		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:QueryUpdate] applyWhere %s", err.Error()))
+		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:QueryUpdate] queryRows %s", err.Error()))
 	}
 
 	// set the appropriate columns in filtered set
@@ -390,16 +450,10 @@ func (self *SwarmDB) QueryDelete(u *SWARMDBUser, query *QueryOption) (affectedRo
 		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:QueryDelete] GetTable %s", err.Error()))
 	}
 
-	//get all rows with Scan, using Where's specified col
-	rawRows, err := self.Scan(u, query.Owner, query.Database, query.Table, query.Where.Left, query.Ascending)
-	if err != nil {
-		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:QueryDelete] Scan %s", err.Error()))
-	}
-
 	//apply WHERE clause
-	filteredRows, err := table.applyWhere(rawRows, query.Where)
+	filteredRows, err := self.queryRows(u, table, query)
 	if err != nil {
-		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:QueryDelete] applyWhere %s", err.Error()))
+		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:QueryDelete] queryRows %s", err.Error()))
 	}
 
 	//delete the selected rows
@@ -420,6 +474,13 @@ func (self *SwarmDB) QueryDelete(u *SWARMDBUser, query *QueryOption) (affectedRo
 }
 
 func (self *SwarmDB) Query(u *SWARMDBUser, query *QueryOption) (rows []sdbc.Row, affectedRows int, err error) {
+	if query.Explain {
+		plan, err := self.Explain(u, query)
+		if err != nil {
+			return rows, 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:Query] Explain %s", err.Error()))
+		}
+		return []sdbc.Row{plan.Row()}, 0, nil
+	}
 	switch query.Type {
 	case "Select":
 		rows, err = self.QuerySelect(u, query)
@@ -428,7 +489,7 @@ func (self *SwarmDB) Query(u *SWARMDBUser, query *QueryOption) (rows []sdbc.Row,
 		}
 		return rows, len(rows), nil
 	case "Insert":
-		affectedRows, err = self.QueryInsert(u, query)
+		rows, affectedRows, err = self.QueryInsert(u, query)
 		if err != nil {
 			return rows, affectedRows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:Query] QueryInsert %s", err.Error()))
 		}
@@ -450,19 +511,34 @@ func (self *SwarmDB) Query(u *SWARMDBUser, query *QueryOption) (rows []sdbc.Row,
 }
 
 func (self *SwarmDB) Scan(u *SWARMDBUser, owner string, database string, tableName string, columnName string, ascending int) (rows []sdbc.Row, err error) {
-	tblKey := self.GetTableKey(owner, database, tableName)
-	tbl, ok := self.tables[tblKey]
-	if !ok {
-		//TODO: how would this ever happen?
-		return rows, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:Scan] No such table to scan [%s:%s] - [%s]", owner, database, tblKey), ErrorCode: 403, ErrorMessage: fmt.Sprintf("Table Does Not Exist:  Table: [%s] Database [%s] Owner: [%s]", tableName, database, owner)}
+	return self.ScanLimit(u, owner, database, tableName, columnName, ascending, 0)
+}
+
+// ScanLimit returns the first limit rows of the table, all of them when limit is 0, in the order of the index of
+// columnName, as Table.ScanLimit does
+func (self *SwarmDB) ScanLimit(u *SWARMDBUser, owner string, database string, tableName string, columnName string, ascending int, limit int) (rows []sdbc.Row, err error) {
+	tbl, err := self.GetTable(u, owner, database, tableName)
+	if err != nil {
+		return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:ScanLimit] GetTable %s", err.Error()))
 	}
-	rows, err = tbl.Scan(u, columnName, ascending)
+	return self.scanTable(u, tbl, columnName, ascending, limit)
+}
+
+// scanTable is ScanLimit of the table tbl a caller already holds, which the evictor may have dropped since
+func (self *SwarmDB) scanTable(u *SWARMDBUser, tbl *Table, columnName string, ascending int, limit int) (rows []sdbc.Row, err error) {
+	// scan a snapshot, so that writes flushing meanwhile cannot mix old and new nodes into the result, unless the
+	// session reads its own buffered writes
+	snap, err := tbl.readView(u)
 	if err != nil {
-		return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:Scan] Error doing table scan: [%s] %s", columnName, err.Error()))
+		return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:scanTable] readView %s", err.Error()))
+	}
+	rows, err = snap.ScanLimit(u, columnName, ascending, limit)
+	if err != nil {
+		return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:scanTable] Error doing table scan: [%s] %s", columnName, err.Error()))
 	}
 	rows, err = tbl.assignRowColumnTypes(rows)
 	if err != nil {
-		return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:Scan] Error assigning column types to row values"))
+		return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:scanTable] Error assigning column types to row values"))
 	}
 	// fmt.Printf("swarmdb Scan finished ok: %+v\n", rows)
 	return rows, nil
@@ -479,17 +555,31 @@ func (self *SwarmDB) GetTable(u *SWARMDBUser, owner string, database string, tab
 		return tbl, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:GetTable] tablename missing "), ErrorCode: 426, ErrorMessage: "Table Name Missing"}
 	}
 	tblKey := self.GetTableKey(owner, database, tableName)
-	log.Debug(fmt.Sprintf("Getting Table [%s] with the Owner [%s] from TABLES [%v]", tableName, owner, self.tables))
-	if tbl, ok := self.tables[tblKey]; ok {
+	self.tablesMu.RLock()
+	tbl, ok := self.tables[tblKey]
+	self.tablesMu.RUnlock()
+	if ok {
 		log.Debug(fmt.Sprintf("Table[%v] with Owner [%s] Database %s found in tables, it is: %+v\n", tblKey, owner, database, tbl))
+		tbl.touch()
 		return tbl, nil
 	} else {
+		if !self.IsReplica() {
+			// the tables of a dropped database stay in ENS until DropDatabase clears them
+			e, err := self.getDatabaseEntry(u, owner, database)
+			if err != nil {
+				return tbl, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:GetTable] getDatabaseEntry %s", err.Error()))
+			}
+			if e == nil || !e.hasTable(tableName) {
+				return tbl, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:GetTable] table [%s] not in database [%s]", tableName, database), ErrorCode: 403, ErrorMessage: fmt.Sprintf("Table Does Not Exist: Table: [%s] Database [%s] Owner: [%s]", tableName, database, owner)}
+			}
+		}
 		tbl = self.NewTable(owner, database, tableName)
 		err = tbl.OpenTable(u)
 		if err != nil {
 			return tbl, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:GetTable] OpenTable %s", err.Error()))
 		}
-		self.RegisterTable(owner, database, tableName, tbl)
+		tbl = self.adoptTable(tbl)
+		tbl.touch()
 		return tbl, nil
 	}
 }
@@ -497,11 +587,34 @@ func (self *SwarmDB) GetTable(u *SWARMDBUser, owner string, database string, tab
 // TODO: when there are errors, the error must be parsable make user friendly developer errors that can be trapped by Node.js, Go library, JS CLI
 func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARMDBResponse, err error) {
 
-	log.Debug(fmt.Sprintf("SelectHandler Input: %s\n", data))
+	if len(u.TraceID()) == 0 {
+		u = u.WithTrace(NewTraceID())
+	}
+	log.Debug(fmt.Sprintf("SelectHandler Input: %s\n", data), "trace", u.TraceID())
 	d, err := parseData(data)
 	if err != nil {
 		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] parseData %s", err.Error()))
 	}
+	done := traceRequest(u, d.RequestType, d.Owner, d.Database, d.Table)
+	defer func() { done(err) }()
+	if err = u.checkDeadline("swarmdb:SelectHandler"); err != nil {
+		return resp, err
+	}
+	if self.gossip != nil {
+		if address, ok := self.gossip.remote(u, d); ok {
+			return self.gossip.forward(address, d)
+		}
+	}
+	if isReplicaRead(d) {
+		u = self.withQueryBudget(u)
+	} else {
+		if err = self.checkWritable(); err != nil {
+			return resp, err
+		}
+		if self.elector != nil && !self.elector.IsLeader(d.Owner) {
+			return self.elector.forward(d)
+		}
+	}
 
 	switch d.RequestType {
 	case sdbc.RT_CREATE_DATABASE:
@@ -544,6 +657,13 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 		return sdbc.SWARMDBResponse{AffectedRowCount: 1}, nil
 
 	case sdbc.RT_DROP_TABLE:
+		if tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table); err == nil {
+			if err = tbl.checkGrant(u); err != nil {
+				return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] checkGrant %s", err.Error()))
+			}
+		} else if err = self.checkDatabaseAccess(u, d.Owner, d.Database, ACL_GRANT); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] checkDatabaseAccess %s", err.Error()))
+		}
 		ok, err := self.DropTable(u, d.Owner, d.Database, d.Table)
 		if err != nil {
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] DropTable %s", err.Error()))
@@ -559,11 +679,15 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 		if err != nil {
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] GetTable %s", err.Error()))
 		}
-		rawRows, err := self.Scan(u, d.Owner, d.Database, d.Table, tbl.primaryColumnName, 1)
+		hidden, err := tbl.checkRead(u)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] checkRead %s", err.Error()))
+		}
+		rawRows, err := self.scanTable(u, tbl, tbl.primaryColumnName, 1, 0)
 		if err != nil {
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] GetTable %s", err.Error()))
 		}
-		resp.Data = rawRows
+		resp.Data = maskRows(rawRows, hidden)
 		resp.AffectedRowCount = len(resp.Data)
 		return resp, nil
 
@@ -572,6 +696,10 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 		if err != nil {
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] GetTable %s", err.Error()))
 		}
+		hidden, err := tbl.checkRead(u)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] checkRead %s", err.Error()))
+		}
 		tblcols, err := tbl.DescribeTable()
 		if err != nil {
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] DescribeTable %s", err.Error()))
@@ -580,6 +708,9 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:SelectHandler] Table [%s] not found", d.Table), ErrorCode: 482, ErrorMessage: fmt.Sprintf("Cannot Describe Table [%s] as it was not found", d.Table)}
 		}
 		for _, colInfo := range tblcols {
+			if hidden[colInfo.ColumnName] {
+				continue
+			}
 			r := sdbc.NewRow()
 			r["ColumnName"] = colInfo.ColumnName
 			r["IndexType"] = colInfo.IndexType
@@ -589,6 +720,15 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 		}
 		return resp, nil
 
+	case wire.RT_TABLE_INFO:
+		info, err := self.GetTableInfo(u, d.Owner, d.Database, d.Table)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] GetTableInfo %s", err.Error()))
+		}
+		resp.Data = []sdbc.Row{info.Row()}
+		resp.MatchedRowCount = 1
+		return resp, nil
+
 	case sdbc.RT_LIST_TABLES:
 		tableNames, err := self.ListTables(u, d.Owner, d.Database)
 		if err != nil {
@@ -603,6 +743,9 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 		if err != nil {
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] GetTable %s", err.Error()))
 		}
+		if err = tbl.checkAccess(u, ACL_WRITE); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] checkAccess %s", err.Error()))
+		}
 		tblInfo, err := tbl.DescribeTable()
 		if err != nil {
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] DescribeTable %s", err.Error()))
@@ -612,6 +755,18 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] assignRowColumnTypes %s", err.Error()))
 		}
 
+		// rows without a UUID primary key get one made up, see uuid.go
+		var keys []sdbc.Row
+		for _, row := range d.Rows {
+			key, err := tbl.generateKey(row)
+			if err != nil {
+				return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] generateKey %s", err.Error()))
+			}
+			if key != nil {
+				keys = append(keys, key)
+			}
+		}
+
 		//error checking for primary column, and valid columns
 		for _, row := range d.Rows {
 			log.Debug(fmt.Sprintf("checking row %v\n", row))
@@ -655,13 +810,17 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 			}
 			successfulRows++
 		}
-		return sdbc.SWARMDBResponse{AffectedRowCount: successfulRows}, nil
+		return sdbc.SWARMDBResponse{AffectedRowCount: successfulRows, Data: keys}, nil
 
 	case sdbc.RT_GET:
 		tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
 		if err != nil {
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] GetTable %s", err.Error()))
 		}
+		hidden, err := tbl.checkRead(u)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] checkRead %s", err.Error()))
+		}
 		if isNil(d.Key) {
 			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:SelectHandler] Get - Missing Key"), ErrorCode: 433, ErrorMessage: "GET Request Missing Key"}
 		}
@@ -683,7 +842,7 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 			if err2 != nil {
 				return resp, sdbc.GenerateSWARMDBError(err2, fmt.Sprintf("[swarmdb:SelectHandler] byteArrayToRow %s", err2.Error()))
 			}
-			resp.Data = append(resp.Data, validRow)
+			resp.Data = append(resp.Data, maskRow(validRow, hidden))
 			resp.MatchedRowCount = 1
 		}
 		return resp, nil
@@ -693,6 +852,9 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 		if err != nil {
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] GetTable %s", err.Error()))
 		}
+		if err = tbl.checkAccess(u, ACL_WRITE); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] checkAccess %s", err.Error()))
+		}
 		if isNil(d.Key) {
 			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:SelectHandler] Delete is Missing Key"), ErrorCode: 448, ErrorMessage: "Delete Statement missing KEY"}
 		}
@@ -710,6 +872,9 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 		if err != nil {
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] GetTable %s", err.Error()))
 		}
+		if err = tbl.checkAccess(u, ACL_WRITE); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] checkAccess %s", err.Error()))
+		}
 		err = tbl.StartBuffer(u)
 		if err != nil {
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] StartBuffer %s", err.Error()))
@@ -722,6 +887,9 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 		if err != nil {
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] GetTable %s", err.Error()))
 		}
+		if err = tbl.checkAccess(u, ACL_WRITE); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] checkAccess %s", err.Error()))
+		}
 		err = tbl.FlushBuffer(u)
 		if err != nil {
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] FlushBuffer %s", err.Error()))
@@ -733,41 +901,56 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 		if len(d.RawQuery) == 0 {
 			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:SelectHandler] RawQuery is blank"), ErrorCode: 425, ErrorMessage: "Invalid Query Request. Missing Rawquery"}
 		}
-		query, err := ParseQuery(d.RawQuery)
+		if resp, ok, err := self.viewStatement(u, d); ok {
+			return resp, err
+		}
+		query, err := self.statements.parse(d.RawQuery)
 		query.Encrypted = d.Encrypted
 		if err != nil {
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] ParseQuery [%s] %s", d.RawQuery, err.Error()))
 		}
 		query.Owner = d.Owner
 		query.Database = d.Database
+		self.usage.add(d.Owner, 0, 0, 0, 1)
 		if len(d.Table) == 0 {
 			//TODO: check if empty even after query.Table check
 			d.Table = query.Table //since table is specified in the query we do not have get it as a separate input
 		}
+		view, err := self.GetView(d.Owner, d.Database, d.Table)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] GetView %s", err.Error()))
+		}
+		if view != nil && !view.Materialized {
+			return self.selectView(u, d.Owner, d.Database, view, &query)
+		} else if view != nil {
+			if err = self.catchUpView(u, d.Owner, d.Database, view); err != nil {
+				return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] catchUpView %s", err.Error()))
+			}
+		}
 		tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
 		if err != nil {
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] GetTable %s", err.Error()))
 		}
-		tblInfo, err := tbl.DescribeTable()
+		hidden, tblInfo, err := tbl.checkQuery(u, &query)
 		if err != nil {
-			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] DescribeTable %s", err.Error()))
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] checkQuery %s", err.Error()))
 		}
 
-		//checking validity of columns
-		for _, reqCol := range query.RequestColumns {
-			if _, ok := tblInfo[reqCol.ColumnName]; !ok {
-				return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:SelectHandler] Requested col [%s] does not exist in table [%+v]", reqCol.ColumnName, tblInfo), ErrorCode: 404, ErrorMessage: fmt.Sprintf("Column Does Not Exist in table definition: [%s]", reqCol.ColumnName)}
-			}
+		if query.Explain {
+			plan := self.statements.plan(tbl, &query)
+			return sdbc.SWARMDBResponse{Data: []sdbc.Row{plan.Row()}, MatchedRowCount: 1}, nil
 		}
 
 		//checking the Where clause
 		if query.Type == "Select" && len(query.Where.Left) > 0 {
-			if _, ok := tblInfo[query.Where.Left]; !ok {
-				return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:SelectHandler] Query col [%s] does not exist in table", query.Where.Left), ErrorCode: 432, ErrorMessage: fmt.Sprintf("WHERE Clause contains invalid column [%s]", query.Where.Left)}
+			for _, p := range query.Where.Predicates() {
+				if _, ok := tblInfo[p.Left]; !ok && len(p.Left) > 0 {
+					return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:SelectHandler] Query col [%s] does not exist in table", p.Left), ErrorCode: 432, ErrorMessage: fmt.Sprintf("WHERE Clause contains invalid column [%s]", p.Left)}
+				}
 			}
 
 			//checking if the query is just a primary key Get
-			if query.Where.Left == tbl.primaryColumnName && query.Where.Operator == "=" {
+			if query.Where.Left == tbl.primaryColumnName && query.Where.Operator == "=" && len(query.Where.And) == 0 {
 				// fmt.Printf("Calling Get from Query\n")
 				if _, ok := tbl.columns[tbl.primaryColumnName]; !ok {
 					return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:SelectHandler] Query col [%s] does not exist in table", tbl.primaryColumnName), ErrorCode: 432, ErrorMessage: fmt.Sprintf("Primary key [%s] not defined in table", tbl.primaryColumnName)}
@@ -777,9 +960,13 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 					return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] convertJSONValueToKey %s", err.Error()))
 				}
 
-				byteRow, ok, err := tbl.Get(u, convertedKey)
+				columns := make([]string, len(query.RequestColumns))
+				for i, c := range query.RequestColumns {
+					columns[i] = c.ColumnName
+				}
+				byteRow, ok, err := tbl.GetColumns(u, convertedKey, columns)
 				if err != nil {
-					return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] Get %s", err.Error()))
+					return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] GetColumns %s", err.Error()))
 				}
 				if ok {
 					row, err := tbl.byteArrayToRow(byteRow)
@@ -788,7 +975,7 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 						return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] byteArrayToRow %s", err.Error()))
 					}
 
-					filteredRow := filterRowByColumns(row, query.RequestColumns)
+					filteredRow := filterRowByColumns(maskRow(row, hidden), query.RequestColumns)
 					// fmt.Printf("\nResponse filteredrow from Get: %s (%v)", filteredRow, filteredRow)
 					resp.Data = append(resp.Data, filteredRow)
 				}
@@ -796,12 +983,119 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 			}
 		}
 
-		// process the query
+		// process the query, unless its result on the current root hash is cached
+		cacheKey, cacheable := queryCacheKey(u, tbl, &query)
+		if cacheable {
+			if qRows, ok := self.queryCache.get(cacheKey); ok {
+				return sdbc.SWARMDBResponse{AffectedRowCount: len(qRows), Data: maskRows(qRows, hidden)}, nil
+			}
+		}
 		qRows, affectedRows, err := self.Query(u, &query)
 		if err != nil {
 			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] Query [%+v] %s", query, err.Error()))
 		}
-		return sdbc.SWARMDBResponse{AffectedRowCount: affectedRows, Data: qRows}, nil
+		if cacheable {
+			self.queryCache.put(cacheKey, qRows)
+		}
+		return sdbc.SWARMDBResponse{AffectedRowCount: affectedRows, Data: maskRows(qRows, hidden)}, nil
+
+
+	case RT_GRANT, RT_REVOKE:
+		// without a table the grants are on the database
+		var tbl *Table
+		if len(d.Table) > 0 {
+			if tbl, err = self.GetTable(u, d.Owner, d.Database, d.Table); err != nil {
+				return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] GetTable %s", err.Error()))
+			}
+		}
+		for _, row := range d.Rows {
+			grantee, perm, err := parseGrantRow(row)
+			if err != nil {
+				return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] parseGrantRow %s", err.Error()))
+			}
+			switch {
+			case tbl == nil && d.RequestType == RT_GRANT:
+				err = self.GrantDatabase(u, d.Owner, d.Database, grantee, perm)
+			case tbl == nil:
+				err = self.RevokeDatabase(u, d.Owner, d.Database, grantee, perm)
+			case d.RequestType == RT_GRANT:
+				err = tbl.Grant(u, grantee, perm)
+			default:
+				err = tbl.Revoke(u, grantee, perm)
+			}
+			if err != nil {
+				return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] %s %s", d.RequestType, err.Error()))
+			}
+			resp.AffectedRowCount++
+		}
+		return resp, nil
+
+	case wire.RT_INCREMENT:
+		return self.increment(u, d)
+
+	case RT_BALANCE, RT_SET_BID:
+		return self.balance(d)
+
+	case wire.RT_VERSIONS:
+		return self.versions(u, d)
+
+	case wire.RT_GET_MULTI:
+		return self.getMulti(u, d)
+
+	case wire.RT_FLUSH_POLICY:
+		return self.setFlushPolicy(u, d)
+
+	case wire.RT_ENCRYPT_COLUMNS:
+		return self.setEncryptedColumns(u, d)
+
+	case wire.RT_RESTRICT_COLUMNS:
+		return self.setRestrictedColumns(u, d)
+
+	case wire.RT_COLUMN_FAMILIES:
+		return self.setColumnFamilies(u, d)
+
+	case wire.RT_EXPIRY_COLUMN:
+		return self.setExpiryColumn(u, d)
+
+	case wire.RT_TIMESTAMP_COLUMNS:
+		return self.setTimestampColumns(u, d)
+
+	case wire.RT_REPLICATION:
+		return self.setReplication(u, d)
+
+	case wire.RT_CHANGES:
+		return self.readChanges(u, d)
+	case wire.RT_CREATE_KEYSPACE, wire.RT_PUT_KV, wire.RT_GET_KV, wire.RT_DELETE_KV, wire.RT_ITERATE_KV:
+		return self.keyspaceRequest(u, d)
+	case wire.RT_INSERT_DOC, wire.RT_FIND_DOCS, wire.RT_CREATE_DOC_INDEX:
+		return self.docRequest(u, d)
+	case wire.RT_PUT_EDGE, wire.RT_DELETE_EDGE, wire.RT_TRAVERSE:
+		return self.graphRequest(u, d)
+
+	case wire.RT_IMPORT_CSV:
+		return self.importCSV(u, d)
+
+	case wire.RT_PUT_BLOB_PART, wire.RT_PUT_BLOB, wire.RT_GET_BLOB:
+		return self.blobRequest(u, d)
+
+	case RT_LIST_GRANTS:
+		if len(d.Table) == 0 {
+			if resp.Data, err = self.DatabaseGrants(u, d.Owner, d.Database); err != nil {
+				return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] DatabaseGrants %s", err.Error()))
+			}
+			resp.MatchedRowCount = len(resp.Data)
+			return resp, nil
+		}
+		tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] GetTable %s", err.Error()))
+		}
+		if err = tbl.checkAccess(u, ACL_READ); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:SelectHandler] checkAccess %s", err.Error()))
+		}
+		resp.Data = tbl.ListGrants()
+		resp.MatchedRowCount = len(resp.Data)
+		return resp, nil
 
 	} //end switch
 
@@ -809,11 +1103,23 @@ func (self *SwarmDB) SelectHandler(u *SWARMDBUser, data string) (resp sdbc.SWARM
 
 }
 
+// HandleRequest runs an already-decoded request through SelectHandler, for servers that do not receive raw JSON (HTTP, RPC)
+func (self *SwarmDB) HandleRequest(u *SWARMDBUser, req *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:HandleRequest] Marshal %s", err.Error()), ErrorCode: 432, ErrorMessage: "Unable to Parse Request"}
+	}
+	return self.SelectHandler(u, string(data))
+}
+
 func parseData(data string) (*sdbc.RequestOption, error) {
 	udata := new(sdbc.RequestOption)
 	if err := json.Unmarshal([]byte(data), udata); err != nil {
 		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:parseData] Unmarshal %s", err.Error()), ErrorCode: 432, ErrorMessage: "Unable to Parse Request"}
 	}
+	if err := validateRequest(udata); err != nil {
+		return nil, err
+	}
 	return udata, nil
 }
 
@@ -824,22 +1130,39 @@ func (self *SwarmDB) NewTable(owner string, database string, tableName string) *
 	t.Database = database
 	t.tableName = tableName
 	t.columns = make(map[string]*ColumnInfo)
+	t.acl = make(map[common.Address]uint8)
 
 	return t
 }
 
+// RegisterTable registers t as the open handle of the table, replacing any other; GetTable uses adoptTable instead
 func (self *SwarmDB) RegisterTable(owner string, database string, tableName string, t *Table) {
 	// register the Table in SwarmDB
+	t.touch()
 	tblKey := self.GetTableKey(owner, database, tableName)
+	self.tablesMu.Lock()
+	defer self.tablesMu.Unlock()
 	self.tables[tblKey] = t
 }
 
 func (self *SwarmDB) UnregisterTable(owner string, database string, tableName string) {
 	// register the Table in SwarmDB
 	tblKey := self.GetTableKey(owner, database, tableName)
+	self.tablesMu.Lock()
+	defer self.tablesMu.Unlock()
 	delete(self.tables, tblKey)
 }
 
+// openTables lists the registered tables
+func (self *SwarmDB) openTables() (tables []*Table) {
+	self.tablesMu.RLock()
+	defer self.tablesMu.RUnlock()
+	for _, tbl := range self.tables {
+		tables = append(tables, tbl)
+	}
+	return tables
+}
+
 func (self *SwarmDB) BuildChunkHeader(u *SWARMDBUser, owner []byte, database []byte, tableName []byte, key []byte, value []byte, birthts int, version int, nodeType []byte, encrypted int) (ch []byte, err error) {
 	ch = make([]byte, CHUNK_START_CHUNKVAL)
 	copy(ch[CHUNK_START_OWNER:CHUNK_END_OWNER], owner)
@@ -891,6 +1214,9 @@ func (self *SwarmDB) BuildChunkHeader(u *SWARMDBUser, owner []byte, database []b
 // e.g.  key 1: wolktoken.eth (up to 64 chars)
 //       key 2: videos     => 32 byte hash, pointing to tables of "video'
 func (self *SwarmDB) CreateDatabase(u *SWARMDBUser, owner string, database string, encrypted int) (err error) {
+	if err = self.checkWritable(); err != nil {
+		return err
+	}
 	// this is the 32 byte version of the database name
 	if len(database) > DATABASE_NAME_LENGTH_MAX {
 		return &sdbc.SWARMDBError{Message: "[swarmdb:CreateDatabase] Database exists already", ErrorCode: 500, ErrorMessage: "Database Name too long (max is 32 chars)"}
@@ -914,6 +1240,9 @@ func (self *SwarmDB) CreateDatabase(u *SWARMDBUser, owner string, database strin
 		log.Debug(fmt.Sprintf("Creating new %s - %x\n", owner, ownerHash))
 		//Create New Owner Chunk
 		copy(ownerChunk[0:CHUNK_HASH_SIZE], []byte(ownerHash))
+		if u != nil && !common.IsHexAddress(owner) {
+			copy(ownerChunk[OWNER_CONTROLLER:OWNER_CONTROLLER+common.AddressLength], common.HexToAddress(u.Address).Bytes())
+		}
 	} else {
 		//Retrieve Owner Chunk "O" Chunk
 		ownerChunk, err = self.RetrieveDBChunk(u, ownerDatabaseChunkID)
@@ -964,10 +1293,11 @@ func (self *SwarmDB) CreateDatabase(u *SWARMDBUser, owner string, database strin
 				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:CreateDatabase] StoreDBChunk %s", err.Error()))
 			}
 
-			err = self.StoreRootHash(u, ownerHash, ownerDatabaseChunkID)
+			err = self.StoreRootHash(u, ownerHash, nil, ownerDatabaseChunkID)
 			if err != nil {
 				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:CreateDatabase] StoreRootHash %s", err.Error()))
 			}
+			self.databaseACLs.set(self.GetTableKey(owner, database, ""), nil)
 			return nil
 		}
 	}
@@ -1013,62 +1343,21 @@ func (self *SwarmDB) ListDatabases(u *SWARMDBUser, owner string) (ret []sdbc.Row
 	return ret, nil
 }
 
-// dropping a database removes the ENS entry
-func (self *SwarmDB) DropDatabase(u *SWARMDBUser, owner string, database string) (ok bool, err error) {
-	if len(database) > DATABASE_NAME_LENGTH_MAX {
-		return false, &sdbc.SWARMDBError{Message: "[swarmdb:CreateDatabase] Database exists already", ErrorCode: 500, ErrorMessage: "Database Name too long (max is 32 chars)"}
-	}
-
-	// this is the 32 byte version of the database name
-	ownerHash := crypto.Keccak256([]byte(owner))
-	dropDBName := make([]byte, DATABASE_NAME_LENGTH_MAX)
-	copy(dropDBName[0:], database)
-
-	// look up what databases the owner has already
-	ownerDatabaseChunkID, err := self.ens.GetRootHash(u, ownerHash)
-	if err != nil {
-		return false, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:DropDatabase] GetRootHash %s", err)}
-	}
-
-	ownerChunk := make([]byte, CHUNK_SIZE)
-	if EmptyBytes(ownerDatabaseChunkID) {
-		return false, nil // No error returned.  Just 'nil' it.  &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:DropDatabase] No database %s", err)}
-	} else {
-		ownerChunk, err = self.RetrieveDBChunk(u, ownerDatabaseChunkID)
-		if err != nil {
-			return false, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:DropDatabase] RetrieveDBChunk %s", err)}
-		}
-
-		// the first 32 bytes of the ownerChunk should match
-		if bytes.Compare(ownerChunk[0:CHUNK_HASH_SIZE], ownerHash[0:CHUNK_HASH_SIZE]) != 0 {
-			return false, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:DropDatabase] Invalid owner %x != %x", ownerHash, ownerChunk[0:CHUNK_HASH_SIZE])}
-		}
-
-		// check for the database entry
-		for i := CHUNK_START_CHUNKVAL + 64; i < CHUNK_SIZE; i += 64 {
-			if bytes.Compare(ownerChunk[i:(i+DATABASE_NAME_LENGTH_MAX)], dropDBName) == 0 {
-				// found it, zero out the database
-				copy(ownerChunk[i:(i+64)], make([]byte, 64))
-				ownerDatabaseChunkID, err = self.StoreDBChunk(u, ownerChunk, 0) // TODO: .eth disc
-				if err != nil {
-					return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:DropDatabase] StoreDBChunk %s", err.Error()))
-				}
-				err = self.StoreRootHash(u, ownerHash, ownerDatabaseChunkID)
-				if err != nil {
-					return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:DropDatabase] StoreRootHash %s", err.Error()))
-				}
-				return true, nil
-			}
-		}
-	}
-	return false, nil // &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:DropDatabase] Database could not be found")}
-}
-
 func (self *SwarmDB) DropTable(u *SWARMDBUser, owner string, database string, tableName string) (ok bool, err error) {
+	if err = self.checkWritable(); err != nil {
+		return false, err
+	}
 	log.Debug(fmt.Sprintf("Attempting to Drop table [%s]", tableName))
 	if len(tableName) > TABLE_NAME_LENGTH_MAX {
 		return false, &sdbc.SWARMDBError{Message: "[swarmdb:DropTable] Tablename length", ErrorCode: 500, ErrorMessage: "Table Name too long (max is 32 chars)"}
 	}
+	if tbl, errOpen := self.GetTable(u, owner, database, tableName); errOpen == nil && tbl.IsSharded() {
+		for i := 0; i <= len(tbl.shardSplits); i++ {
+			if _, err = self.DropTable(u, owner, database, shardTableName(tableName, i)); err != nil {
+				return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:DropTable] shard %d %s", i, err.Error()))
+			}
+		}
+	}
 
 	// this is the 32 byte version of the database name
 	ownerHash := crypto.Keccak256([]byte(owner))
@@ -1139,7 +1428,7 @@ func (self *SwarmDB) DropTable(u *SWARMDBUser, owner string, database string, ta
 						}
 
 						log.Debug(fmt.Sprintf("Storing new OwnerDatabaseChunkID of [%s]", ownerDatabaseChunkID))
-						err = self.StoreRootHash(u, ownerHash, ownerDatabaseChunkID)
+						err = self.StoreRootHash(u, ownerHash, nil, ownerDatabaseChunkID)
 						if err != nil {
 							return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:DropTable] StoreRootHash %s", err.Error()))
 						}
@@ -1154,7 +1443,7 @@ func (self *SwarmDB) DropTable(u *SWARMDBUser, owner string, database string, ta
 		//Drop Table from ENS hash as well as db columns
 		tblKey := self.GetTableKey(owner, database, tableName)
 		emptyRootHash := make([]byte, 64)
-		err = self.StoreRootHash(u, []byte(tblKey), emptyRootHash)
+		err = self.StoreRootHash(u, []byte(tblKey), nil, emptyRootHash)
 		//TODO: Empty out column info?
 		if err != nil {
 			return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:OpenTable] GetRootHash for table [%s]: %v", tblKey, err))
@@ -1228,6 +1517,12 @@ func (self *SwarmDB) ListTables(u *SWARMDBUser, owner string, database string) (
 // TODO: check for the existence in the owner-database combination before creating.
 // TODO: need to make sure the types of the columns are correct
 func (self *SwarmDB) CreateTable(u *SWARMDBUser, owner string, database string, tableName string, columns []sdbc.Column) (tbl *Table, err error) {
+	if err = self.checkWritable(); err != nil {
+		return tbl, err
+	}
+	if err = self.checkDatabaseAccess(u, owner, database, ACL_WRITE); err != nil {
+		return tbl, err
+	}
 	columnsMax := COLUMNS_PER_TABLE_MAX
 	primaryColumnName := ""
 	if len(columns) > columnsMax {
@@ -1249,7 +1544,8 @@ func (self *SwarmDB) CreateTable(u *SWARMDBUser, owner string, database string,
 		if !CheckColumnType(columninfo.ColumnType) {
 			return tbl, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:CreateTable] bad columntype"), ErrorCode: 407, ErrorMessage: "Invalid ColumnType: [columnType]"}
 		}
-		if !CheckIndexType(columninfo.IndexType) {
+		// only the primary key must be indexed; filters on unindexed columns scan, see plan.go
+		if !CheckIndexType(columninfo.IndexType) && (columninfo.Primary > 0 || columninfo.IndexType != sdbc.IT_NONE) {
 			return tbl, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdb:CreateTable] bad indextype"), ErrorCode: 408, ErrorMessage: "Invalid IndexType: [indexType]"}
 		}
 	}
@@ -1340,7 +1636,7 @@ func (self *SwarmDB) CreateTable(u *SWARMDBUser, owner string, database string,
 					return tbl, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:CreateTable] StoreDBChunk %s", err))
 				}
 				log.Debug(fmt.Sprintf("[swarmdb:CreateTable] Storing Hash of (%x) and ChunkID: [%s]", ownerHash, ownerDatabaseChunkID))
-				err = self.StoreRootHash(u, ownerHash, ownerDatabaseChunkID)
+				err = self.StoreRootHash(u, ownerHash, nil, ownerDatabaseChunkID)
 				if err != nil {
 					return tbl, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:CreateTable] StoreRootHash %s", err.Error()))
 				}
@@ -1360,6 +1656,8 @@ func (self *SwarmDB) CreateTable(u *SWARMDBUser, owner string, database string,
 	log.Debug(fmt.Sprintf("Creating Table [%s] - Owner [%s] Database [%s]\n", tableName, owner, database))
 	tbl = self.NewTable(owner, database, tableName)
 	tbl.encrypted = encrypted
+	// the table descriptor starts from an empty chunk rather than the owner's database list
+	buf = make([]byte, CHUNK_SIZE)
 	for i, columninfo := range columns {
 		copy(buf[2048+i*64:], columninfo.ColumnName)
 		b := make([]byte, 1)
@@ -1391,7 +1689,7 @@ func (self *SwarmDB) CreateTable(u *SWARMDBUser, owner string, database string,
 	tblKey := self.GetTableKey(tbl.Owner, tbl.Database, tbl.tableName)
 
 	log.Debug(fmt.Sprintf("**** CreateTable (owner [%s] database [%s] tableName: [%s]) Primary: [%s] tblKey: [%s] Roothash:[%x]\n", tbl.Owner, tbl.Database, tbl.tableName, tbl.primaryColumnName, tblKey, swarmhash))
-	err = self.StoreRootHash(u, []byte(tblKey), []byte(swarmhash))
+	err = self.StoreRootHash(u, []byte(tblKey), nil, []byte(swarmhash))
 	if err != nil {
 		return tbl, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[swarmdb:CreateTable] StoreRootHash %s", err.Error()))
 	}
@@ -16,12 +16,24 @@
 package swarmdb_test
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/swarm/pss"
 	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	sdb "swarmdb"
+	"sync"
 	"testing"
 	"time"
 )
@@ -51,6 +63,7 @@ var u *sdb.SWARMDBUser
 
 func TestMain(m *testing.M) {
 	config, _ = sdb.LoadSWARMDBConfig(sdb.SWARMDBCONF_FILE)
+	config.ChangeStream = 1 // see TestChangeStream
 	var err error
 	swarmdb, err = sdb.NewSwarmDB(config)
 	if err != nil {
@@ -69,6 +82,44 @@ func make_name(prefix string) (nm string) {
 	return fmt.Sprintf("%s%d", prefix, int32(time.Now().Unix()))
 }
 
+// make_table creates a fresh owner/database with a table keyed on the string column "email"
+func make_table(t *testing.T, prefix string, extra ...sdbc.Column) (owner string, database string, tableName string) {
+	return make_owner_table(t, make_name(prefix+"owner.eth"), prefix, extra...)
+}
+
+// make_owner_table is make_table for a given owner, e.g. the address a TCP session authenticates as; extra columns
+// follow email, name and age
+func make_owner_table(t *testing.T, owner string, prefix string, extra ...sdbc.Column) (_ string, database string, tableName string) {
+	database = make_name(prefix + "db")
+	tableName = make_name(prefix + "tbl")
+
+	tReq := new(sdbc.RequestOption)
+	tReq.RequestType = sdbc.RT_CREATE_DATABASE
+	tReq.Owner = owner
+	tReq.Database = database
+	mReq, _ := json.Marshal(tReq)
+	if _, err := swarmdb.SelectHandler(u, string(mReq)); err != nil {
+		t.Fatalf("[swarmdb_test:make_table] CREATE DATABASE: %s", err)
+	}
+
+	tReq = new(sdbc.RequestOption)
+	tReq.RequestType = sdbc.RT_CREATE_TABLE
+	tReq.Owner = owner
+	tReq.Database = database
+	tReq.Table = tableName
+	tReq.Columns = []sdbc.Column{
+		sdbc.Column{ColumnName: "email", Primary: 1, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_STRING},
+		sdbc.Column{ColumnName: "name", Primary: 0, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_STRING},
+		sdbc.Column{ColumnName: "age", Primary: 0, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_INTEGER},
+	}
+	tReq.Columns = append(tReq.Columns, extra...)
+	mReq, _ = json.Marshal(tReq)
+	if _, err := swarmdb.SelectHandler(u, string(mReq)); err != nil {
+		t.Fatalf("[swarmdb_test:make_table] CREATE TABLE: %s", err)
+	}
+	return owner, database, tableName
+}
+
 func TestCoreTables(t *testing.T) {
 
 	owner := make_name("owner.eth")
@@ -1278,3 +1329,2429 @@ func TestSmallOps(t *testing.T) {
 		}
 	}
 }
+
+func TestRootHashConflict(t *testing.T) {
+	owner, database, tableName := make_table(t, "cas")
+	stale, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestRootHashConflict] GetTable %s", err)
+	}
+	// a second writer opens the same table and publishes first
+	swarmdb.UnregisterTable(owner, database, tableName)
+	fresh, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestRootHashConflict] GetTable %s", err)
+	}
+	for i, tbl := range []*sdb.Table{fresh, stale} {
+		if err = tbl.StartBuffer(u); err != nil {
+			t.Fatalf("[swarmdb_test:TestRootHashConflict] StartBuffer %s", err)
+		}
+		if err = tbl.Put(u, map[string]interface{}{"email": fmt.Sprintf("cas%d@wolk.com", i), "name": "Cas", "age": i}); err != nil {
+			t.Fatalf("[swarmdb_test:TestRootHashConflict] Put %s", err)
+		}
+		err = tbl.FlushBuffer(u)
+		if i == 0 && err != nil {
+			t.Fatalf("[swarmdb_test:TestRootHashConflict] FlushBuffer %s", err)
+		}
+	}
+	// the stale writer must not overwrite the published root hash
+	if sErr, ok := err.(*sdbc.SWARMDBError); !ok || sErr.ErrorCode != 499 {
+		t.Fatalf("[swarmdb_test:TestRootHashConflict] stale FlushBuffer returned %v", err)
+	}
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestRootHashConflict] GetTable %s", err)
+	}
+	if _, ok, err := tbl.Get(u, []byte("cas0@wolk.com")); err != nil || !ok {
+		t.Fatalf("[swarmdb_test:TestRootHashConflict] row of the first writer lost %v %v", ok, err)
+	}
+}
+
+func TestTableSnapshot(t *testing.T) {
+	owner, database, tableName := make_table(t, "snap")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestTableSnapshot] GetTable %s", err)
+	}
+	put := func(tbl *sdb.Table, i int) error {
+		return tbl.Put(u, map[string]interface{}{"email": fmt.Sprintf("snap%d@wolk.com", i), "name": "Snap", "age": i})
+	}
+	for i := 0; i < 2; i++ {
+		if err = put(tbl, i); err != nil {
+			t.Fatalf("[swarmdb_test:TestTableSnapshot] Put %s", err)
+		}
+	}
+	snap, err := tbl.Snapshot(u)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestTableSnapshot] Snapshot %s", err)
+	}
+
+	// writes after the snapshot, buffered or flushed, are not visible in it
+	if err = tbl.StartBuffer(u); err != nil {
+		t.Fatalf("[swarmdb_test:TestTableSnapshot] StartBuffer %s", err)
+	}
+	for i := 2; i < 4; i++ {
+		if err = put(tbl, i); err != nil {
+			t.Fatalf("[swarmdb_test:TestTableSnapshot] Put %s", err)
+		}
+	}
+	if rows, err := snap.Scan(u, "email", 1); err != nil || len(rows) != 2 {
+		t.Fatalf("[swarmdb_test:TestTableSnapshot] Scan during buffered writes %d %v", len(rows), err)
+	}
+	if err = tbl.FlushBuffer(u); err != nil {
+		t.Fatalf("[swarmdb_test:TestTableSnapshot] FlushBuffer %s", err)
+	}
+	if rows, err := snap.Scan(u, "email", 1); err != nil || len(rows) != 2 {
+		t.Fatalf("[swarmdb_test:TestTableSnapshot] Scan after flush %d %v", len(rows), err)
+	}
+	if latest, err := tbl.Snapshot(u); err != nil {
+		t.Fatalf("[swarmdb_test:TestTableSnapshot] Snapshot %s", err)
+	} else if rows, err := latest.Scan(u, "email", 1); err != nil || len(rows) != 4 {
+		t.Fatalf("[swarmdb_test:TestTableSnapshot] Scan of a new snapshot %d %v", len(rows), err)
+	}
+	if err = put(snap, 9); err == nil {
+		t.Fatalf("[swarmdb_test:TestTableSnapshot] Put to a snapshot succeeded")
+	}
+}
+
+func TestTableFlushDescriptor(t *testing.T) {
+	owner, database, tableName := make_table(t, "flush")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestTableFlushDescriptor] GetTable %s", err)
+	}
+	if err = tbl.StartBuffer(u); err != nil {
+		t.Fatalf("[swarmdb_test:TestTableFlushDescriptor] StartBuffer %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = tbl.Put(u, map[string]interface{}{"email": fmt.Sprintf("flush%d@wolk.com", i), "name": fmt.Sprintf("Flo%d", i), "age": i}); err != nil {
+			t.Fatalf("[swarmdb_test:TestTableFlushDescriptor] Put %s", err)
+		}
+	}
+	if err = tbl.FlushBuffer(u); err != nil {
+		t.Fatalf("[swarmdb_test:TestTableFlushDescriptor] FlushBuffer %s", err)
+	}
+
+	// reopened from the published descriptor, primary and secondary indexes are at the same generation
+	swarmdb.UnregisterTable(owner, database, tableName)
+	tbl, err = swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestTableFlushDescriptor] reopen %s", err)
+	}
+	for _, column := range []string{"email", "name", "age"} {
+		if rows, err := tbl.Scan(u, column, 1); err != nil || len(rows) != 3 {
+			t.Fatalf("[swarmdb_test:TestTableFlushDescriptor] Scan %s after reopen %d %v", column, len(rows), err)
+		}
+	}
+}
+
+func TestCoordinator(t *testing.T) {
+	owner, accountsDB, accounts := make_table(t, "accounts")
+	_, ledgerDB, ledger := make_owner_table(t, owner, "ledger")
+	count := func(database string, tableName string) int {
+		tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestCoordinator] GetTable %s", err)
+		}
+		rows, err := tbl.Scan(u, "email", 1)
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestCoordinator] Scan %s", err)
+		}
+		return len(rows)
+	}
+	stage := func(c *sdb.Coordinator, database string, tableName string, i int) {
+		tbl, err := c.Stage(u, owner, database, tableName)
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestCoordinator] Stage %s", err)
+		}
+		if err = tbl.Put(u, map[string]interface{}{"email": fmt.Sprintf("%s%d@wolk.com", tableName, i), "name": "Coco", "age": i}); err != nil {
+			t.Fatalf("[swarmdb_test:TestCoordinator] Put %s", err)
+		}
+	}
+
+	// both tables are published by one commit
+	c := swarmdb.NewCoordinator()
+	stage(c, accountsDB, accounts, 0)
+	stage(c, ledgerDB, ledger, 0)
+	if err := c.Commit(u); err != nil {
+		t.Fatalf("[swarmdb_test:TestCoordinator] Commit %s", err)
+	}
+	swarmdb.UnregisterTable(owner, accountsDB, accounts)
+	swarmdb.UnregisterTable(owner, ledgerDB, ledger)
+	if count(accountsDB, accounts) != 1 || count(ledgerDB, ledger) != 1 {
+		t.Fatalf("[swarmdb_test:TestCoordinator] after Commit accounts has %d rows, ledger %d", count(accountsDB, accounts), count(ledgerDB, ledger))
+	}
+
+	// another writer publishing the ledger meanwhile aborts the whole commit
+	c = swarmdb.NewCoordinator()
+	stage(c, accountsDB, accounts, 1)
+	stage(c, ledgerDB, ledger, 1)
+	swarmdb.UnregisterTable(owner, ledgerDB, ledger)
+	other, err := swarmdb.GetTable(u, owner, ledgerDB, ledger)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestCoordinator] GetTable %s", err)
+	}
+	if err = other.Put(u, map[string]interface{}{"email": "other@wolk.com", "name": "Otto", "age": 9}); err != nil {
+		t.Fatalf("[swarmdb_test:TestCoordinator] Put %s", err)
+	}
+	if err = c.Commit(u); err == nil {
+		t.Fatalf("[swarmdb_test:TestCoordinator] Commit over a conflicting write succeeded")
+	}
+	if count(accountsDB, accounts) != 1 || count(ledgerDB, ledger) != 2 {
+		t.Fatalf("[swarmdb_test:TestCoordinator] after the aborted Commit accounts has %d rows, ledger %d", count(accountsDB, accounts), count(ledgerDB, ledger))
+	}
+}
+
+func TestFlushPolicy(t *testing.T) {
+	owner, database, tableName := make_table(t, "flushpolicy")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestFlushPolicy] GetTable %s", err)
+	}
+	if err = tbl.SetFlushPolicy(u, sdb.FlushPolicy{MaxMutations: 2, MaxAge: time.Hour}); err != nil {
+		t.Fatalf("[swarmdb_test:TestFlushPolicy] SetFlushPolicy %s", err)
+	}
+	// the policy is kept in the descriptor
+	swarmdb.UnregisterTable(owner, database, tableName)
+	if tbl, err = swarmdb.GetTable(u, owner, database, tableName); err != nil {
+		t.Fatalf("[swarmdb_test:TestFlushPolicy] GetTable %s", err)
+	}
+	if p := tbl.FlushPolicy(); p.MaxMutations != 2 || p.MaxAge != time.Hour {
+		t.Fatalf("[swarmdb_test:TestFlushPolicy] reopened policy %+v", p)
+	}
+
+	published := func() int {
+		snap, err := tbl.Snapshot(u)
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestFlushPolicy] Snapshot %s", err)
+		}
+		rows, err := snap.Scan(u, "email", 1)
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestFlushPolicy] Scan %s", err)
+		}
+		return len(rows)
+	}
+	if err = tbl.StartBuffer(u); err != nil {
+		t.Fatalf("[swarmdb_test:TestFlushPolicy] StartBuffer %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = tbl.Put(u, map[string]interface{}{"email": fmt.Sprintf("policy%d@wolk.com", i), "name": "Polly", "age": i}); err != nil {
+			t.Fatalf("[swarmdb_test:TestFlushPolicy] Put %s", err)
+		}
+		if _, err = swarmdb.FlushDue(u); err != nil {
+			t.Fatalf("[swarmdb_test:TestFlushPolicy] FlushDue %s", err)
+		}
+		// flushed after every second write, and still buffered after the flush
+		if n := published(); n != (i+1)/2*2 {
+			t.Fatalf("[swarmdb_test:TestFlushPolicy] %d rows published after %d writes", n, i+1)
+		}
+	}
+}
+
+func TestMergeOnConflict(t *testing.T) {
+	owner, database, tableName := make_table(t, "merge")
+	ours, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestMergeOnConflict] GetTable %s", err)
+	}
+	swarmdb.UnregisterTable(owner, database, tableName)
+	theirs, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestMergeOnConflict] GetTable %s", err)
+	}
+	conflicts := 0
+	ours.SetConflictResolver(func(key interface{}, mine sdbc.Row, other sdbc.Row) (sdbc.Row, error) {
+		conflicts++
+		mine["age"] = other["age"].(int) + mine["age"].(int)
+		return mine, nil
+	})
+
+	// both writers write their own row and one shared row; the other writer publishes first
+	if err = ours.StartBuffer(u); err != nil {
+		t.Fatalf("[swarmdb_test:TestMergeOnConflict] StartBuffer %s", err)
+	}
+	for _, row := range []map[string]interface{}{{"email": "ours@wolk.com", "name": "Ours", "age": 1}, {"email": "shared@wolk.com", "name": "Ours", "age": 2}} {
+		if err = ours.Put(u, row); err != nil {
+			t.Fatalf("[swarmdb_test:TestMergeOnConflict] Put %s", err)
+		}
+	}
+	for _, row := range []map[string]interface{}{{"email": "theirs@wolk.com", "name": "Theirs", "age": 3}, {"email": "shared@wolk.com", "name": "Theirs", "age": 4}} {
+		if err = theirs.Put(u, row); err != nil {
+			t.Fatalf("[swarmdb_test:TestMergeOnConflict] Put %s", err)
+		}
+	}
+	if err = ours.FlushBuffer(u); err != nil {
+		t.Fatalf("[swarmdb_test:TestMergeOnConflict] FlushBuffer did not merge: %s", err)
+	}
+	if conflicts != 1 {
+		t.Fatalf("[swarmdb_test:TestMergeOnConflict] resolver called %d times", conflicts)
+	}
+
+	swarmdb.UnregisterTable(owner, database, tableName)
+	merged, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestMergeOnConflict] GetTable %s", err)
+	}
+	for _, key := range []string{"ours@wolk.com", "theirs@wolk.com", "shared@wolk.com"} {
+		if _, ok, err := merged.Get(u, []byte(key)); err != nil || !ok {
+			t.Fatalf("[swarmdb_test:TestMergeOnConflict] row %s missing after merge %v %v", key, ok, err)
+		}
+	}
+	if row, _, _ := merged.Get(u, []byte("shared@wolk.com")); !strings.Contains(string(row), `"age":6`) {
+		t.Fatalf("[swarmdb_test:TestMergeOnConflict] shared row not resolved: %s", row)
+	}
+}
+
+func TestRowVersions(t *testing.T) {
+	owner, database, tableName := make_table(t, "mvcc")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestRowVersions] GetTable %s", err)
+	}
+	key := []byte("versioned@wolk.com")
+	if err = tbl.Put(u, map[string]interface{}{"email": "versioned@wolk.com", "name": "First", "age": 1}); err != nil {
+		t.Fatalf("[swarmdb_test:TestRowVersions] Put %s", err)
+	}
+	snap, err := tbl.Snapshot(u)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestRowVersions] Snapshot %s", err)
+	}
+	between := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	if err = tbl.Put(u, map[string]interface{}{"email": "versioned@wolk.com", "name": "Second", "age": 2}); err != nil {
+		t.Fatalf("[swarmdb_test:TestRowVersions] Put %s", err)
+	}
+
+	versions, err := tbl.RowVersions(u, key)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestRowVersions] RowVersions %s", err)
+	}
+	if len(versions) != 2 || versions[0].Version != 1 || versions[1].Version != 0 {
+		t.Fatalf("[swarmdb_test:TestRowVersions] expected versions 1, 0 got %+v", versions)
+	}
+	if !strings.Contains(string(versions[1].Value), `"First"`) || versions[0].Updated.Before(versions[1].Updated) {
+		t.Fatalf("[swarmdb_test:TestRowVersions] unexpected version 0 %s at %v", versions[1].Value, versions[1].Updated)
+	}
+	if row, ok, err := tbl.GetAsOf(u, key, between); err != nil || !ok || !strings.Contains(string(row), `"First"`) {
+		t.Fatalf("[swarmdb_test:TestRowVersions] GetAsOf %s %v %v", row, ok, err)
+	}
+	if row, ok, err := snap.Get(u, key); err != nil || !ok || !strings.Contains(string(row), `"First"`) {
+		t.Fatalf("[swarmdb_test:TestRowVersions] snapshot read %s %v %v", row, ok, err)
+	}
+	if row, ok, err := tbl.Get(u, key); err != nil || !ok || !strings.Contains(string(row), `"Second"`) {
+		t.Fatalf("[swarmdb_test:TestRowVersions] Get %s %v %v", row, ok, err)
+	}
+}
+
+func TestReplica(t *testing.T) {
+	owner, database, tableName := make_table(t, "replica")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestReplica] GetTable %s", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "first@wolk.com", "name": "First", "age": 1}); err != nil {
+		t.Fatalf("[swarmdb_test:TestReplica] Put %s", err)
+	}
+
+	replica := swarmdb.NewReplica()
+	followed, err := replica.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestReplica] replica GetTable %s", err)
+	}
+	if _, ok, err := followed.Get(u, []byte("first@wolk.com")); err != nil || !ok {
+		t.Fatalf("[swarmdb_test:TestReplica] replica Get %v %v", ok, err)
+	}
+	if err = followed.Put(u, map[string]interface{}{"email": "replica@wolk.com", "name": "Replica", "age": 2}); err == nil {
+		t.Fatalf("[swarmdb_test:TestReplica] Put to a replica succeeded")
+	}
+	if _, err = replica.CreateTable(u, owner, database, "replicatable", nil); err == nil {
+		t.Fatalf("[swarmdb_test:TestReplica] CreateTable on a replica succeeded")
+	}
+
+	// the replica sees the writer's rows once it follows the new root hash
+	if err = tbl.Put(u, map[string]interface{}{"email": "second@wolk.com", "name": "Second", "age": 3}); err != nil {
+		t.Fatalf("[swarmdb_test:TestReplica] Put %s", err)
+	}
+	if _, ok, _ := followed.Get(u, []byte("second@wolk.com")); ok {
+		t.Fatalf("[swarmdb_test:TestReplica] replica read a row before following it")
+	}
+	if reopened, err := replica.Follow(u); err != nil || reopened != 1 {
+		t.Fatalf("[swarmdb_test:TestReplica] Follow reopened %d %v", reopened, err)
+	}
+	if followed, err = replica.GetTable(u, owner, database, tableName); err != nil {
+		t.Fatalf("[swarmdb_test:TestReplica] replica GetTable %s", err)
+	}
+	if _, ok, err := followed.Get(u, []byte("second@wolk.com")); err != nil || !ok {
+		t.Fatalf("[swarmdb_test:TestReplica] replica Get after Follow %v %v", ok, err)
+	}
+}
+
+func TestShardedTable(t *testing.T) {
+	owner, database, _ := make_table(t, "shard")
+	columns := []sdbc.Column{
+		sdbc.Column{ColumnName: "email", Primary: 1, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_STRING},
+		sdbc.Column{ColumnName: "age", Primary: 0, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_INTEGER},
+	}
+	if _, err := swarmdb.CreateShardedTable(u, owner, database, "bad", columns, []interface{}{"p", "h"}); err == nil {
+		t.Fatalf("[swarmdb_test:TestShardedTable] CreateShardedTable accepted descending splits")
+	}
+	tbl, err := swarmdb.CreateShardedTable(u, owner, database, "sharded", columns, []interface{}{"h", "p"})
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestShardedTable] CreateShardedTable %s", err)
+	}
+	emails := []string{"zoe@wolk.com", "alice@wolk.com", "mia@wolk.com", "bob@wolk.com"}
+	for i, email := range emails {
+		if err = tbl.Put(u, map[string]interface{}{"email": email, "age": i}); err != nil {
+			t.Fatalf("[swarmdb_test:TestShardedTable] Put %s", err)
+		}
+	}
+
+	// each row lands in the shard of its key range, and reopening the table keeps the shards
+	for shard, n := range []int{2, 1, 1} {
+		sub, err := swarmdb.GetTable(u, owner, database, fmt.Sprintf("sharded.%d", shard))
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestShardedTable] GetTable shard %d %s", shard, err)
+		}
+		if rows, err := sub.Scan(u, "email", 1); err != nil || len(rows) != n {
+			t.Fatalf("[swarmdb_test:TestShardedTable] shard %d holds %d rows %v", shard, len(rows), err)
+		}
+	}
+	swarmdb.UnregisterTable(owner, database, "sharded")
+	if tbl, err = swarmdb.GetTable(u, owner, database, "sharded"); err != nil || !tbl.IsSharded() {
+		t.Fatalf("[swarmdb_test:TestShardedTable] reopened table not sharded %v", err)
+	}
+	if row, ok, err := tbl.Get(u, []byte("mia@wolk.com")); err != nil || !ok || !strings.Contains(string(row), `"age":2`) {
+		t.Fatalf("[swarmdb_test:TestShardedTable] Get %s %v %v", row, ok, err)
+	}
+
+	rows, err := swarmdb.Scan(u, owner, database, "sharded", "email", 1)
+	if err != nil || len(rows) != len(emails) {
+		t.Fatalf("[swarmdb_test:TestShardedTable] Scan %d %v", len(rows), err)
+	}
+	for i, want := range []string{"alice@wolk.com", "bob@wolk.com", "mia@wolk.com", "zoe@wolk.com"} {
+		if rows[i]["email"] != want {
+			t.Fatalf("[swarmdb_test:TestShardedTable] Scan row %d is %v, expected %s", i, rows[i]["email"], want)
+		}
+	}
+	if rows, err = swarmdb.Scan(u, owner, database, "sharded", "email", 0); err != nil || rows[0]["email"] != "zoe@wolk.com" {
+		t.Fatalf("[swarmdb_test:TestShardedTable] descending Scan %v %v", rows, err)
+	}
+	if ok, err := tbl.Delete(u, "zoe@wolk.com"); err != nil || !ok {
+		t.Fatalf("[swarmdb_test:TestShardedTable] Delete %v %v", ok, err)
+	}
+}
+
+// pssHub delivers the messages of its testPSS nodes to each other
+type pssHub struct {
+	mu    sync.Mutex
+	nodes map[string]*testPSS
+}
+
+type testPSS struct {
+	hub      *pssHub
+	keyid    string
+	handlers []pss.Handler
+}
+
+func (hub *pssHub) node(key *ecdsa.PrivateKey) *testPSS {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	n := &testPSS{hub: hub, keyid: fmt.Sprintf("0x%x", crypto.FromECDSAPub(&key.PublicKey))}
+	hub.nodes[n.keyid] = n
+	return n
+}
+
+func (n *testPSS) Register(topic *pss.Topic, handler pss.Handler) func() {
+	n.hub.mu.Lock()
+	defer n.hub.mu.Unlock()
+	n.handlers = append(n.handlers, handler)
+	return func() {}
+}
+
+func (n *testPSS) SetPeerPublicKey(pubkey *ecdsa.PublicKey, topic pss.Topic, address *pss.PssAddress) error {
+	return nil
+}
+
+func (n *testPSS) SendAsym(pubkeyid string, topic pss.Topic, msg []byte) error {
+	n.hub.mu.Lock()
+	to, ok := n.hub.nodes[pubkeyid]
+	n.hub.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown peer %s", pubkeyid)
+	}
+	for _, handler := range to.handlers {
+		go handler(msg, (*p2p.Peer)(nil), true, n.keyid)
+	}
+	return nil
+}
+
+func TestNotifier(t *testing.T) {
+	owner, database, tableName := make_table(t, "notifier")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestNotifier] GetTable %s", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "first@wolk.com", "name": "First", "age": 1}); err != nil {
+		t.Fatalf("[swarmdb_test:TestNotifier] Put %s", err)
+	}
+
+	hub := &pssHub{nodes: make(map[string]*testPSS)}
+	writerKey, _ := crypto.HexToECDSA(config.PrivateKey)
+	writer, err := sdb.NewNotifier(swarmdb, hub.node(writerKey), nil, config)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestNotifier] NewNotifier %s", err)
+	}
+	stopWriter, err := writer.Start(u)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestNotifier] Start %s", err)
+	}
+	defer stopWriter()
+
+	replica := swarmdb.NewReplica()
+	replicaKey, _ := crypto.GenerateKey()
+	replicaConfig := *config
+	replicaConfig.PrivateKey = fmt.Sprintf("%x", crypto.FromECDSA(replicaKey))
+	replicaConfig.PSSPublishers = []string{fmt.Sprintf("0x%x", crypto.FromECDSAPub(&writerKey.PublicKey))}
+	follower, err := sdb.NewNotifier(replica, hub.node(replicaKey), nil, &replicaConfig)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestNotifier] NewNotifier %s", err)
+	}
+	stopFollower, err := follower.Start(u)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestNotifier] Start %s", err)
+	}
+	defer stopFollower()
+	for i := 0; writer.Subscribers() == 0; i++ {
+		if i == 100 {
+			t.Fatalf("[swarmdb_test:TestNotifier] replica never subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err = replica.GetTable(u, owner, database, tableName); err != nil {
+		t.Fatalf("[swarmdb_test:TestNotifier] replica GetTable %s", err)
+	}
+
+	// the flush of a write reaches the replica without it polling ENS
+	if err = tbl.Put(u, map[string]interface{}{"email": "second@wolk.com", "name": "Second", "age": 2}); err != nil {
+		t.Fatalf("[swarmdb_test:TestNotifier] Put %s", err)
+	}
+	for i := 0; ; i++ {
+		followed, err := replica.GetTable(u, owner, database, tableName)
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestNotifier] replica GetTable %s", err)
+		}
+		if _, ok, _ := followed.Get(u, []byte("second@wolk.com")); ok {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("[swarmdb_test:TestNotifier] replica did not follow the notified root hash")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	owner, database, tableName := make_table(t, "watch")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestWatch] GetTable %s", err)
+	}
+
+	hub := &pssHub{nodes: make(map[string]*testPSS)}
+	writerKey, _ := crypto.HexToECDSA(config.PrivateKey)
+	writer, err := sdb.NewNotifier(swarmdb, hub.node(writerKey), nil, config)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestWatch] NewNotifier %s", err)
+	}
+	stopWriter, err := writer.Start(u)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestWatch] Start %s", err)
+	}
+	defer stopWriter()
+
+	watcherKey, _ := crypto.GenerateKey()
+	watcherConfig := *config
+	watcherConfig.PrivateKey = fmt.Sprintf("%x", crypto.FromECDSA(watcherKey))
+	watcher, err := sdb.NewNotifier(swarmdb.NewReplica(), hub.node(watcherKey), nil, &watcherConfig)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestWatch] NewNotifier %s", err)
+	}
+	stopWatcher, err := watcher.Start(u)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestWatch] Start %s", err)
+	}
+	defer stopWatcher()
+	changes := make(chan sdb.TableEvent, 16)
+	sub := watcher.SubscribeWatchedChanges(changes)
+	defer sub.Unsubscribe()
+
+	publisher := fmt.Sprintf("0x%x", crypto.FromECDSAPub(&writerKey.PublicKey))
+	if err = watcher.Watch(publisher, sdb.TableWatch{Owner: owner, Database: database, Table: tableName, KeyPrefix: "a"}); err != nil {
+		t.Fatalf("[swarmdb_test:TestWatch] Watch %s", err)
+	}
+	for i := 0; writer.Watches() == 0; i++ {
+		if i == 100 {
+			t.Fatalf("[swarmdb_test:TestWatch] watch never arrived")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// only the changes of keys starting with the prefix are pushed
+	for _, email := range []string{"bob@wolk.com", "alice@wolk.com"} {
+		if err = tbl.Put(u, map[string]interface{}{"email": email, "name": email, "age": 1}); err != nil {
+			t.Fatalf("[swarmdb_test:TestWatch] Put %s", err)
+		}
+	}
+	if _, err = tbl.Delete(u, "alice@wolk.com"); err != nil {
+		t.Fatalf("[swarmdb_test:TestWatch] Delete %s", err)
+	}
+	for _, op := range []string{sdb.TE_PUT, sdb.TE_DELETE} {
+		select {
+		case ev := <-changes:
+			if ev.Type != op || ev.Key != "alice@wolk.com" {
+				t.Fatalf("[swarmdb_test:TestWatch] pushed %+v, want %s of alice@wolk.com", ev, op)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[swarmdb_test:TestWatch] %s of alice@wolk.com not pushed", op)
+		}
+	}
+	select {
+	case ev := <-changes:
+		t.Fatalf("[swarmdb_test:TestWatch] unwatched change pushed %+v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPlacement(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	addrs := sdb.ReplicaAddresses(key, 5)
+	if len(addrs) != 5 || string(addrs[0]) != string(key) {
+		t.Fatalf("[swarmdb_test:TestPlacement] ReplicaAddresses %x", addrs)
+	}
+	neighborhoods := make(map[int]bool)
+	for _, addr := range addrs {
+		neighborhoods[sdb.Neighborhood(addr)] = true
+	}
+	if len(neighborhoods) != len(addrs) {
+		t.Fatalf("[swarmdb_test:TestPlacement] replicas share neighborhoods %x", addrs)
+	}
+	for i, addr := range sdb.ReplicaAddresses(key, 3) {
+		if string(addr) != string(addrs[i]) {
+			t.Fatalf("[swarmdb_test:TestPlacement] replica %d of a smaller replication moved", i)
+		}
+	}
+
+	// a node with placement stores every row at the MinReplication of its writer
+	dir := fmt.Sprintf("%s/swarmdbplacement%d", TEST_ENS_DIR, time.Now().UnixNano())
+	defer os.RemoveAll(dir)
+	os.MkdirAll(dir, 0755)
+	placementConfig := *config
+	placementConfig.ChunkDBPath = dir
+	placementConfig.ENSDBPath = dir + "/ens.db"
+	placementConfig.Placement = 1
+	node, err := sdb.NewSwarmDB(&placementConfig)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestPlacement] NewSwarmDB %s", err)
+	}
+	owner, database, tableName := make_name("placementowner.eth"), make_name("placementdb"), make_name("placementtbl")
+	if _, err = node.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_CREATE_DATABASE, Owner: owner, Database: database}); err != nil {
+		t.Fatalf("[swarmdb_test:TestPlacement] CreateDatabase %s", err)
+	}
+	columns := []sdbc.Column{sdbc.Column{ColumnName: "email", Primary: 1, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_STRING}}
+	if _, err = node.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_CREATE_TABLE, Owner: owner, Database: database, Table: tableName, Columns: columns}); err != nil {
+		t.Fatalf("[swarmdb_test:TestPlacement] CreateTable %s", err)
+	}
+	tbl, err := node.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestPlacement] GetTable %s", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "placed@wolk.com", "name": "Placed"}); err != nil {
+		t.Fatalf("[swarmdb_test:TestPlacement] Put %s", err)
+	}
+	placed := tbl.RowPlacement(u, []byte("placed@wolk.com"))
+	if len(placed) != u.MinReplication {
+		t.Fatalf("[swarmdb_test:TestPlacement] RowPlacement %d addresses, MinReplication %d", len(placed), u.MinReplication)
+	}
+	for _, addr := range placed {
+		chunk, err := node.RetrieveDBChunk(u, addr)
+		if err != nil || !strings.Contains(string(chunk), "Placed") {
+			t.Fatalf("[swarmdb_test:TestPlacement] replica %x missing %v", addr, err)
+		}
+	}
+
+	// the descriptor, like every other chunk, is placed as well
+	descriptor, err := node.GetRootHash(u, []byte(node.GetTableKey(owner, database, tableName)))
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestPlacement] GetRootHash %s", err)
+	}
+	stored, err := node.RetrieveDBChunk(u, descriptor)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestPlacement] RetrieveDBChunk %s", err)
+	}
+	for _, addr := range sdb.ReplicaAddresses(descriptor, u.MinReplication)[1:] {
+		chunk, err := node.RetrieveDBChunk(u, addr)
+		if err != nil || !bytes.Equal(chunk, stored) {
+			t.Fatalf("[swarmdb_test:TestPlacement] descriptor replica %x missing %v", addr, err)
+		}
+	}
+}
+
+func TestTableReplication(t *testing.T) {
+	owner, database, tableName := make_table(t, "replication")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestTableReplication] GetTable %s", err)
+	}
+	tableKey := []byte(swarmdb.GetTableKey(owner, database, tableName))
+	before, err := swarmdb.GetRootHash(u, tableKey)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestTableReplication] GetRootHash %s", err)
+	}
+	req := &sdbc.RequestOption{RequestType: wire.RT_REPLICATION, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{{"min": u.MinReplication + 2, "max": u.MaxReplication + 2}}}
+	if _, err = swarmdb.HandleRequest(u, req); err != nil {
+		t.Fatalf("[swarmdb_test:TestTableReplication] RT_REPLICATION %s", err)
+	}
+	// the replication is part of the hashed descriptor, so setting it publishes a new descriptor
+	after, err := swarmdb.GetRootHash(u, tableKey)
+	if err != nil || bytes.Equal(before, after) {
+		t.Fatalf("[swarmdb_test:TestTableReplication] descriptor root hash %x unchanged by SetReplication %v", after, err)
+	}
+	reopened := swarmdb.NewTable(owner, database, tableName)
+	if err = reopened.OpenTable(u); err != nil {
+		t.Fatalf("[swarmdb_test:TestTableReplication] OpenTable %s", err)
+	}
+	if min, max := reopened.Replication(); min != u.MinReplication+2 || max != u.MaxReplication+2 {
+		t.Fatalf("[swarmdb_test:TestTableReplication] reopened replication %d-%d", min, max)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "replicated@wolk.com", "name": "Replicated", "age": 5}); err != nil {
+		t.Fatalf("[swarmdb_test:TestTableReplication] Put %s", err)
+	}
+	chunk, err := swarmdb.RetrieveDBChunk(u, tbl.RowPlacement(u, []byte("replicated@wolk.com"))[0])
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestTableReplication] RetrieveDBChunk %s", err)
+	}
+	header, err := sdb.ParseChunkHeader(chunk)
+	if err != nil || header.MinReplication != u.MinReplication+2 || header.MaxReplication != u.MaxReplication+2 {
+		t.Fatalf("[swarmdb_test:TestTableReplication] row chunk replication %d-%d %v", header.MinReplication, header.MaxReplication, err)
+	}
+
+	resp, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: wire.RT_REPLICATION, Owner: owner, Database: database, Table: tableName})
+	if err != nil || len(resp.Data) != 1 || resp.Data[0]["min"] != u.MinReplication+2 {
+		t.Fatalf("[swarmdb_test:TestTableReplication] read replication %v %v", resp.Data, err)
+	}
+	if err = tbl.SetReplication(u, 3, 2); err == nil {
+		t.Fatalf("[swarmdb_test:TestTableReplication] min above max accepted")
+	}
+	// only the owner, who pays for the replicas, sets them
+	stranger := *u
+	stranger.Address = "0x0000000000000000000000000000000000000001"
+	if err = tbl.SetReplication(&stranger, u.MinReplication, u.MaxReplication); err == nil {
+		t.Fatalf("[swarmdb_test:TestTableReplication] SetReplication by a stranger accepted")
+	}
+	if err = tbl.SetReplication(u, 0, 0); err != nil {
+		t.Fatalf("[swarmdb_test:TestTableReplication] SetReplication %s", err)
+	}
+	if min, max := tbl.Replication(); min != 0 || max != 0 {
+		t.Fatalf("[swarmdb_test:TestTableReplication] cleared replication %d-%d", min, max)
+	}
+}
+
+func TestInspectChunk(t *testing.T) {
+	owner, database, tableName := make_table(t, "inspect")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestInspectChunk] GetTable %s", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "inspect@wolk.com", "name": "Inspected", "age": 7}); err != nil {
+		t.Fatalf("[swarmdb_test:TestInspectChunk] Put %s", err)
+	}
+	roothash, err := swarmdb.GetRootHash(u, []byte(swarmdb.GetTableKey(owner, database, tableName)))
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestInspectChunk] GetRootHash %s", err)
+	}
+
+	// descriptor -> primary index root -> row
+	descriptor, err := swarmdb.InspectChunk(u, roothash, "")
+	if err != nil || descriptor["kind"] != sdb.CHUNK_DESCRIPTOR {
+		t.Fatalf("[swarmdb_test:TestInspectChunk] descriptor %v %v", descriptor, err)
+	}
+	columns := descriptor["columns"].([]sdbc.Row)
+	if len(columns) != 3 || columns[0]["name"] != "email" || columns[0]["primary"] != 1 {
+		t.Fatalf("[swarmdb_test:TestInspectChunk] descriptor columns %v", columns)
+	}
+	primaryRoot, _ := hex.DecodeString(columns[0]["roothash"].(string))
+	node, err := swarmdb.InspectChunk(u, primaryRoot, "")
+	if err != nil || node["kind"] != sdb.CHUNK_BPLUS || node["nodetype"] != "D" {
+		t.Fatalf("[swarmdb_test:TestInspectChunk] primary index root %v %v", node, err)
+	}
+	entries := node["entries"].([]sdbc.Row)
+	if len(entries) != 1 || entries[0]["key"] != "inspect@wolk.com" {
+		t.Fatalf("[swarmdb_test:TestInspectChunk] leaf entries %v", entries)
+	}
+	rowKey, _ := hex.DecodeString(entries[0]["value"].(string))
+	row, err := swarmdb.InspectChunk(u, rowKey, "")
+	if err != nil || row["kind"] != sdb.CHUNK_ROW || row["table"] != tableName {
+		t.Fatalf("[swarmdb_test:TestInspectChunk] row %v %v", row, err)
+	}
+	if value := row["row"].(sdbc.Row); value["name"] != "Inspected" {
+		t.Fatalf("[swarmdb_test:TestInspectChunk] row value %v", value)
+	}
+
+	if _, err = swarmdb.InspectChunk(u, make([]byte, 32), ""); err == nil {
+		t.Fatalf("[swarmdb_test:TestInspectChunk] missing chunk inspected")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	owner, database, tableName := make_table(t, "verify")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestVerify] GetTable %s", err)
+	}
+	for i, email := range []string{"ann@wolk.com", "ben@wolk.com", "cy@wolk.com"} {
+		if err = tbl.Put(u, map[string]interface{}{"email": email, "name": "Verified", "age": 20 + i}); err != nil {
+			t.Fatalf("[swarmdb_test:TestVerify] Put %s", err)
+		}
+	}
+	// the index entry of age 20 outlives the update
+	if err = tbl.Put(u, map[string]interface{}{"email": "ann@wolk.com", "name": "Verified", "age": 40}); err != nil {
+		t.Fatalf("[swarmdb_test:TestVerify] Put %s", err)
+	}
+	reports, err := swarmdb.Verify(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestVerify] Verify %s", err)
+	}
+	if len(reports) != 1 || !reports[0].OK() || reports[0].Rows != 3 || reports[0].Warnings == 0 || reports[0].Chunks < 5 {
+		t.Fatalf("[swarmdb_test:TestVerify] report %+v", reports)
+	}
+
+	// point the primary index of a copy of the descriptor at a chunk that does not exist
+	tableKey := []byte(swarmdb.GetTableKey(owner, database, tableName))
+	roothash, err := swarmdb.GetRootHash(u, tableKey)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestVerify] GetRootHash %s", err)
+	}
+	descriptor, err := swarmdb.RetrieveDBChunk(u, roothash)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestVerify] RetrieveDBChunk %s", err)
+	}
+	missing := bytes.Repeat([]byte{0xab}, 32)
+	for i := 2048; i < 4000 && descriptor[i] != 0; i = i + 64 {
+		if descriptor[i+26] == 1 {
+			copy(descriptor[i+32:i+64], missing)
+		}
+	}
+	damaged, err := swarmdb.StoreDBChunk(u, descriptor, 0)
+	if err == nil {
+		err = swarmdb.StoreRootHash(u, tableKey, nil, damaged)
+	}
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestVerify] store damaged descriptor %s", err)
+	}
+	swarmdb.UnregisterTable(owner, database, tableName)
+	reports, err = swarmdb.Verify(u, owner, database, "")
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestVerify] Verify %s", err)
+	}
+	found := false
+	for _, report := range reports {
+		if report.Table != tableName {
+			continue
+		}
+		for _, issue := range report.Issues {
+			found = found || (issue.Check == sdb.VERIFY_REACHABILITY && issue.Chunk == hex.EncodeToString(missing))
+		}
+		if report.OK() || report.Rows != 0 || !found {
+			t.Fatalf("[swarmdb_test:TestVerify] damaged report %+v", report)
+		}
+	}
+	if !found {
+		t.Fatalf("[swarmdb_test:TestVerify] %s missing from the reports of %s", tableName, database)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	owner, database, tableName := make_table(t, "usage")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestUsage] GetTable %s", err)
+	}
+	for _, email := range []string{"amy@wolk.com", "bo@wolk.com"} {
+		if err = tbl.Put(u, map[string]interface{}{"email": email, "name": "Metered", "age": 30}); err != nil {
+			t.Fatalf("[swarmdb_test:TestUsage] Put %s", err)
+		}
+	}
+	if _, _, err = tbl.Get(u, sdb.StringToKey(sdbc.CT_STRING, "amy@wolk.com")); err != nil {
+		t.Fatalf("[swarmdb_test:TestUsage] Get %s", err)
+	}
+	tReq := &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, RawQuery: fmt.Sprintf("select * from %s where email = 'bo@wolk.com'", tableName)}
+	mReq, _ := json.Marshal(tReq)
+	if _, err = swarmdb.SelectHandler(u, string(mReq)); err != nil {
+		t.Fatalf("[swarmdb_test:TestUsage] Query %s", err)
+	}
+
+	usage, err := swarmdb.ExportUsage(owner, true)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestUsage] ExportUsage %s", err)
+	}
+	if len(usage) != 1 || usage[0].Owner != owner || usage[0].ChunksWritten != 2 || usage[0].BytesStored == 0 || usage[0].ChunksRead < 2 || usage[0].Queries != 1 {
+		t.Fatalf("[swarmdb_test:TestUsage] usage %+v", usage)
+	}
+
+	// the reset started a new period, which was saved
+	meter, err := sdb.NewUsageMeter(filepath.Join(config.ChunkDBPath, sdb.USAGE_FILE))
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestUsage] NewUsageMeter %s", err)
+	}
+	saved, err := meter.Export(owner, false)
+	if err != nil || len(saved) != 1 || saved[0].ChunksWritten != 0 || saved[0].SinceMs < usage[0].SinceMs {
+		t.Fatalf("[swarmdb_test:TestUsage] saved usage %+v %v", saved, err)
+	}
+}
+
+func TestAccounting(t *testing.T) {
+	dir := fmt.Sprintf("%s/swarmdbaccounting%d", TEST_ENS_DIR, time.Now().UnixNano())
+	defer os.RemoveAll(dir)
+	os.MkdirAll(dir, 0755)
+	accountingConfig := *config
+	accountingConfig.ChunkDBPath = dir
+	accountingConfig.ENSDBPath = dir + "/ens.db"
+	accountingConfig.Accounting = sdb.ACCOUNTING_ENFORCE
+	node, err := sdb.NewSwarmDB(&accountingConfig)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestAccounting] NewSwarmDB %s", err)
+	}
+	owner, database, tableName := make_name("accountingowner.eth"), make_name("accountingdb"), make_name("accountingtbl")
+	if _, err = node.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_CREATE_DATABASE, Owner: owner, Database: database}); err != nil {
+		t.Fatalf("[swarmdb_test:TestAccounting] CreateDatabase %s", err)
+	}
+	columns := []sdbc.Column{sdbc.Column{ColumnName: "email", Primary: 1, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_STRING}}
+	if _, err = node.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_CREATE_TABLE, Owner: owner, Database: database, Table: tableName, Columns: columns}); err != nil {
+		t.Fatalf("[swarmdb_test:TestAccounting] CreateTable %s", err)
+	}
+	tbl, err := node.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestAccounting] GetTable %s", err)
+	}
+
+	// an owner without a balance cannot write
+	err = tbl.Put(u, map[string]interface{}{"email": "unpaid@wolk.com"})
+	if sErr, ok := err.(*sdbc.SWARMDBError); !ok || sErr.ErrorCode != 507 {
+		t.Fatalf("[swarmdb_test:TestAccounting] unpaid Put %v", err)
+	}
+
+	bid := int64(5)
+	if _, err = node.Ledger().Deposit(owner, 100); err != nil {
+		t.Fatalf("[swarmdb_test:TestAccounting] Deposit %s", err)
+	}
+	resp, err := node.HandleRequest(u, &sdbc.RequestOption{RequestType: sdb.RT_SET_BID, Owner: owner, Rows: []sdbc.Row{{"bid": float64(bid)}}})
+	if err != nil || resp.Data[0]["credits"] != int64(100)/bid {
+		t.Fatalf("[swarmdb_test:TestAccounting] SetBid %v %v", resp, err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "paid@wolk.com"}); err != nil {
+		t.Fatalf("[swarmdb_test:TestAccounting] Put %s", err)
+	}
+	cost := bid * int64(u.MinReplication)
+	account := node.Ledger().Balances(owner)[0]
+	if account["balance"] != 100-cost || account["debited"] != cost {
+		t.Fatalf("[swarmdb_test:TestAccounting] charged account %v, cost %d", account, cost)
+	}
+
+	// proving custody pays the farmer one bid; a second audit pays nothing more
+	resp, err = node.Admin(u, &accountingConfig, sdb.ADMIN_AUDIT, &sdbc.RequestOption{})
+	if err != nil || resp.Data[0]["audited"] != 1 || resp.Data[0]["failed"] != 0 || resp.Data[0]["earned"] != bid {
+		t.Fatalf("[swarmdb_test:TestAccounting] Audit %v %v", resp, err)
+	}
+	farmer := resp.Data[0]["farmer"].(string)
+	if audited, _, earned, err := node.Audit(0); err != nil || audited != 0 || earned != 0 {
+		t.Fatalf("[swarmdb_test:TestAccounting] second Audit %d %d %v", audited, earned, err)
+	}
+
+	// the ledger survives a restart
+	if err = node.Ledger().Save(); err != nil {
+		t.Fatalf("[swarmdb_test:TestAccounting] Save %s", err)
+	}
+	ledger, err := sdb.NewLedger(filepath.Join(dir, sdb.LEDGER_FILE), sdb.ACCOUNTING_ENFORCE, 0)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestAccounting] NewLedger %s", err)
+	}
+	if account = ledger.Balances(farmer)[0]; account["earned"] != bid || account["balance"] != bid {
+		t.Fatalf("[swarmdb_test:TestAccounting] saved farmer %v", account)
+	}
+	if account = ledger.Balances(owner)[0]; account["balance"] != 100-cost || account["bid"] != bid {
+		t.Fatalf("[swarmdb_test:TestAccounting] saved owner %v", account)
+	}
+}
+
+func TestColumnEncryption(t *testing.T) {
+	owner, database, tableName := make_table(t, "colenc")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestColumnEncryption] GetTable %s", err)
+	}
+	if err = tbl.SetEncryptedColumns(u, []string{"email"}); err == nil {
+		t.Fatalf("[swarmdb_test:TestColumnEncryption] encrypted the primary key")
+	}
+	tReq := &sdbc.RequestOption{RequestType: wire.RT_ENCRYPT_COLUMNS, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{{"columns": []string{"age"}}}}
+	if resp, err := swarmdb.HandleRequest(u, tReq); err != nil || len(resp.Data) != 1 || resp.Data[0]["column"] != "age" {
+		t.Fatalf("[swarmdb_test:TestColumnEncryption] EncryptColumns %v %v", resp, err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "sealed@wolk.com", "name": "Plain Name", "age": 42}); err != nil {
+		t.Fatalf("[swarmdb_test:TestColumnEncryption] Put %s", err)
+	}
+
+	// the stored row holds the age sealed and the name in the clear
+	key := sdb.StringToKey(sdbc.CT_STRING, "sealed@wolk.com")
+	chunk, err := swarmdb.RetrieveDBChunk(u, tbl.GenerateKChunkKey(key))
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestColumnEncryption] RetrieveDBChunk %s", err)
+	}
+	if !bytes.Contains(chunk, []byte(sdb.ENCRYPTED_CELL_PREFIX)) || !bytes.Contains(chunk, []byte("Plain Name")) {
+		t.Fatalf("[swarmdb_test:TestColumnEncryption] stored row %s", bytes.Trim(chunk, "\x00"))
+	}
+
+	// Get opens it for the writer, also after the table is reopened from its descriptor
+	swarmdb.UnregisterTable(owner, database, tableName)
+	if tbl, err = swarmdb.GetTable(u, owner, database, tableName); err != nil {
+		t.Fatalf("[swarmdb_test:TestColumnEncryption] reopen GetTable %s", err)
+	}
+	if encrypted := tbl.EncryptedColumns(); len(encrypted) != 1 || encrypted[0] != "age" {
+		t.Fatalf("[swarmdb_test:TestColumnEncryption] reopened EncryptedColumns %v", encrypted)
+	}
+	out, ok, err := tbl.Get(u, key)
+	if err != nil || !ok {
+		t.Fatalf("[swarmdb_test:TestColumnEncryption] Get %v %v", ok, err)
+	}
+	var row map[string]interface{}
+	if err = json.Unmarshal(out, &row); err != nil || row["age"] != float64(42) || row["name"] != "Plain Name" {
+		t.Fatalf("[swarmdb_test:TestColumnEncryption] Get %s %v", out, err)
+	}
+
+	// a value looking sealed is sealed all the same, and reads back as written
+	forged := sdb.ENCRYPTED_CELL_PREFIX + "forged"
+	if err = tbl.Put(u, map[string]interface{}{"email": "forged@wolk.com", "age": forged}); err != nil {
+		t.Fatalf("[swarmdb_test:TestColumnEncryption] forged Put %s", err)
+	}
+	key = sdb.StringToKey(sdbc.CT_STRING, "forged@wolk.com")
+	if chunk, err = swarmdb.RetrieveDBChunk(u, tbl.GenerateKChunkKey(key)); err != nil || bytes.Contains(chunk, []byte(forged)) {
+		t.Fatalf("[swarmdb_test:TestColumnEncryption] forged stored row %s %v", bytes.Trim(chunk, "\x00"), err)
+	}
+	if out, ok, err = tbl.Get(u, key); err != nil || !ok || json.Unmarshal(out, &row) != nil || row["age"] != forged {
+		t.Fatalf("[swarmdb_test:TestColumnEncryption] forged Get %s %v %v", out, ok, err)
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	owner, database, tableName := make_table(t, "validate")
+	for _, c := range []struct {
+		name string
+		req  sdbc.RequestOption
+	}{
+		{"table name charset", sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: "bad table;", Key: "a"}},
+		{"database name length", sdbc.RequestOption{RequestType: sdbc.RT_LIST_TABLES, Owner: owner, Database: strings.Repeat("d", sdb.DATABASE_NAME_LENGTH_MAX+1)}},
+		{"key size", sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: strings.Repeat("k", 33)}},
+		{"column count", sdbc.RequestOption{RequestType: sdbc.RT_CREATE_TABLE, Owner: owner, Database: database, Table: "wide", Columns: make([]sdbc.Column, sdb.COLUMNS_PER_TABLE_MAX+1)}},
+		{"column name", sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{{"email": "v@wolk.com", "na me": "x"}}}},
+		{"value size", sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{{"email": "v@wolk.com", "name": strings.Repeat("x", sdb.ROW_VALUE_SIZE_MAX)}}}},
+		{"bid range", sdbc.RequestOption{RequestType: sdb.RT_SET_BID, Owner: owner, Rows: []sdbc.Row{{"bid": float64(-1)}}}},
+	} {
+		mReq, _ := json.Marshal(c.req)
+		_, err := swarmdb.SelectHandler(u, string(mReq))
+		if sErr, ok := err.(*sdbc.SWARMDBError); !ok || sErr.ErrorCode != sdb.INVALID_REQUEST {
+			t.Fatalf("[swarmdb_test:TestValidateRequest] %s returned %v", c.name, err)
+		}
+	}
+
+	// nothing was written by the refused PUTs
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestValidateRequest] GetTable %s", err)
+	}
+	if _, ok, err := tbl.Get(u, sdb.StringToKey(sdbc.CT_STRING, "v@wolk.com")); err != nil || ok {
+		t.Fatalf("[swarmdb_test:TestValidateRequest] refused row stored %v %v", ok, err)
+	}
+}
+
+func TestRowProvenance(t *testing.T) {
+	dir := fmt.Sprintf("%s/swarmdbprovenance%d", TEST_ENS_DIR, time.Now().UnixNano())
+	defer os.RemoveAll(dir)
+	os.MkdirAll(dir, 0755)
+	signConfig := *config
+	signConfig.ChunkDBPath = dir
+	signConfig.ENSDBPath = dir + "/ens.db"
+	signConfig.SignRows = 1
+	node, err := sdb.NewSwarmDB(&signConfig)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestRowProvenance] NewSwarmDB %s", err)
+	}
+	owner, database, tableName := make_name("provenanceowner.eth"), make_name("provenancedb"), make_name("provenancetbl")
+	if _, err = node.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_CREATE_DATABASE, Owner: owner, Database: database}); err != nil {
+		t.Fatalf("[swarmdb_test:TestRowProvenance] CreateDatabase %s", err)
+	}
+	columns := []sdbc.Column{sdbc.Column{ColumnName: "email", Primary: 1, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_STRING}}
+	if _, err = node.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_CREATE_TABLE, Owner: owner, Database: database, Table: tableName, Columns: columns}); err != nil {
+		t.Fatalf("[swarmdb_test:TestRowProvenance] CreateTable %s", err)
+	}
+	tbl, err := node.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestRowProvenance] GetTable %s", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "signed@wolk.com", "name": "Signed"}); err != nil {
+		t.Fatalf("[swarmdb_test:TestRowProvenance] Put %s", err)
+	}
+
+	key, err := crypto.HexToECDSA(config.PrivateKey)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestRowProvenance] HexToECDSA %s", err)
+	}
+	nodeAddress := crypto.PubkeyToAddress(key.PublicKey)
+	k := sdb.StringToKey(sdbc.CT_STRING, "signed@wolk.com")
+	p, ok, err := tbl.Provenance(u, k)
+	if err != nil || !ok || !p.Signed || p.Signer != nodeAddress || p.Version != 0 {
+		t.Fatalf("[swarmdb_test:TestRowProvenance] Provenance %+v %v %v", p, ok, err)
+	}
+
+	// a light client holding the chunk verifies it, and notices an altered value
+	chunk, err := node.RetrieveDBChunk(u, tbl.GenerateKChunkKey(k))
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestRowProvenance] RetrieveDBChunk %s", err)
+	}
+	if signer, ok, err := sdb.VerifyRowChunk(chunk); err != nil || !ok || signer != nodeAddress {
+		t.Fatalf("[swarmdb_test:TestRowProvenance] VerifyRowChunk %x %v %v", signer, ok, err)
+	}
+	copy(chunk[sdb.CHUNK_START_CHUNKVAL:], []byte(`{"email":"signed@wolk.com","name":"Forged"}`))
+	if signer, _, _ := sdb.VerifyRowChunk(chunk); signer == nodeAddress {
+		t.Fatalf("[swarmdb_test:TestRowProvenance] altered value verified")
+	}
+
+	// without SignRows rows carry no signature
+	owner, database, tableName = make_table(t, "unsigned")
+	if tbl, err = swarmdb.GetTable(u, owner, database, tableName); err != nil {
+		t.Fatalf("[swarmdb_test:TestRowProvenance] GetTable %s", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "unsigned@wolk.com", "name": "Unsigned", "age": 1}); err != nil {
+		t.Fatalf("[swarmdb_test:TestRowProvenance] Put %s", err)
+	}
+	if p, ok, err = tbl.Provenance(u, sdb.StringToKey(sdbc.CT_STRING, "unsigned@wolk.com")); err != nil || !ok || p.Signed {
+		t.Fatalf("[swarmdb_test:TestRowProvenance] unsigned Provenance %+v %v %v", p, ok, err)
+	}
+}
+
+func TestRowCodec(t *testing.T) {
+	owner, database, tableName := make_table(t, "rowcodec")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestRowCodec] GetTable %s", err)
+	}
+	row := map[string]interface{}{"email": "codec@wolk.com", "name": "Cody \"C\" <Dec>", "age": 7, "score": -12.75, "active": true, "tags": []string{"a", "b"}, "note": nil}
+	if err = tbl.Put(u, row); err != nil {
+		t.Fatalf("[swarmdb_test:TestRowCodec] Put %s", err)
+	}
+
+	// the row is stored binary, and smaller than its JSON
+	key := sdb.StringToKey(sdbc.CT_STRING, "codec@wolk.com")
+	chunk, err := swarmdb.RetrieveDBChunk(u, tbl.GenerateKChunkKey(key))
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestRowCodec] RetrieveDBChunk %s", err)
+	}
+	stored := bytes.TrimRight(chunk[sdb.CHUNK_START_CHUNKVAL:], "\x00")
+	asJSON, _ := json.Marshal(row)
+	if stored[0] != sdb.ROW_FORMAT_BINARY || len(stored) >= len(asJSON) {
+		t.Fatalf("[swarmdb_test:TestRowCodec] stored %d bytes %x, JSON %d bytes", len(stored), stored, len(asJSON))
+	}
+
+	// and reads back as the JSON row it was written as
+	out, ok, err := tbl.Get(u, key)
+	if err != nil || !ok {
+		t.Fatalf("[swarmdb_test:TestRowCodec] Get %v %v", ok, err)
+	}
+	var got, want map[string]interface{}
+	json.Unmarshal(asJSON, &want)
+	if err = json.Unmarshal(out, &got); err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("[swarmdb_test:TestRowCodec] Get %s %v, want %s", out, err, asJSON)
+	}
+	res, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, Table: tableName, RawQuery: fmt.Sprintf("select email, name, age from %s where age = 7", tableName)})
+	if err != nil || len(res.Data) != 1 || res.Data[0]["name"] != want["name"] {
+		t.Fatalf("[swarmdb_test:TestRowCodec] Query %v %v", res, err)
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	owner, database, tableName := make_table(t, "multiget")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestGetMulti] GetTable %s", err)
+	}
+	var keys []interface{}
+	for i := 0; i < 40; i++ {
+		email := fmt.Sprintf("multi%02d@wolk.com", i)
+		if i%2 == 0 {
+			if err = tbl.Put(u, map[string]interface{}{"email": email, "name": fmt.Sprintf("Multi %d", i), "age": i}); err != nil {
+				t.Fatalf("[swarmdb_test:TestGetMulti] Put %s", err)
+			}
+		}
+		keys = append(keys, email)
+	}
+
+	// the rows of the even keys come back in the order of the keys; the odd ones have none
+	res, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: wire.RT_GET_MULTI, Owner: owner, Database: database, Table: tableName, Key: keys})
+	if err != nil || res.MatchedRowCount != 20 {
+		t.Fatalf("[swarmdb_test:TestGetMulti] GetMulti %v %v", res, err)
+	}
+	for i, row := range res.Data {
+		if row["email"] != keys[2*i] || row["name"] != fmt.Sprintf("Multi %d", 2*i) {
+			t.Fatalf("[swarmdb_test:TestGetMulti] row %d %v", i, row)
+		}
+	}
+
+	tooMany := make([]interface{}, sdb.MULTI_GET_KEYS_MAX+1)
+	for i := range tooMany {
+		tooMany[i] = "k"
+	}
+	_, err = swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: wire.RT_GET_MULTI, Owner: owner, Database: database, Table: tableName, Key: tooMany})
+	if sErr, ok := err.(*sdbc.SWARMDBError); !ok || sErr.ErrorCode != sdb.INVALID_REQUEST {
+		t.Fatalf("[swarmdb_test:TestGetMulti] %d keys returned %v", len(tooMany), err)
+	}
+}
+
+func TestQueryCache(t *testing.T) {
+	owner, database, tableName := make_table(t, "querycache")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestQueryCache] GetTable %s", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "cached1@wolk.com", "name": "Cached", "age": 30}); err != nil {
+		t.Fatalf("[swarmdb_test:TestQueryCache] Put %s", err)
+	}
+	query := &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, Table: tableName, RawQuery: fmt.Sprintf("select email, name from %s where age = 30", tableName)}
+	hits := func() int {
+		res, err := swarmdb.Admin(u, config, sdb.ADMIN_CACHE_STATS, &sdbc.RequestOption{})
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestQueryCache] CacheStats %s", err)
+		}
+		return res.Data[0]["queryCacheHits"].(int)
+	}
+
+	before := hits()
+	for i := 0; i < 2; i++ {
+		if res, err := swarmdb.HandleRequest(u, query); err != nil || len(res.Data) != 1 {
+			t.Fatalf("[swarmdb_test:TestQueryCache] query %d %v %v", i, res, err)
+		}
+	}
+	if hits() != before+1 {
+		t.Fatalf("[swarmdb_test:TestQueryCache] repeated query not served from the cache")
+	}
+
+	// the next write publishes a new root hash, which the cached result does not answer for
+	if err = tbl.Put(u, map[string]interface{}{"email": "cached2@wolk.com", "name": "Cached Too", "age": 30}); err != nil {
+		t.Fatalf("[swarmdb_test:TestQueryCache] Put %s", err)
+	}
+	if res, err := swarmdb.HandleRequest(u, query); err != nil || len(res.Data) != 2 {
+		t.Fatalf("[swarmdb_test:TestQueryCache] query after write %v %v", res, err)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	owner, database, tableName := make_table(t, "warm")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestWarm] GetTable %s", err)
+	}
+	for i := 0; i < 3*sdb.NODE_ENTRIES_MAX; i++ {
+		if err = tbl.Put(u, map[string]interface{}{"email": fmt.Sprintf("warm%03d@wolk.com", i), "name": "Warm", "age": i}); err != nil {
+			t.Fatalf("[swarmdb_test:TestWarm] Put %s", err)
+		}
+	}
+
+	// reopened, the indexes only hold their root nodes
+	if _, err = swarmdb.Admin(u, config, sdb.ADMIN_CLOSE_TABLE, &sdbc.RequestOption{Owner: owner, Database: database, Table: tableName}); err != nil {
+		t.Fatalf("[swarmdb_test:TestWarm] CloseTable %s", err)
+	}
+	res, err := swarmdb.Admin(u, config, sdb.ADMIN_WARM, &sdbc.RequestOption{Owner: owner, Database: database, Table: tableName})
+	if err != nil || len(res.Data) != 1 {
+		t.Fatalf("[swarmdb_test:TestWarm] Warm %v %v", res, err)
+	}
+	if res.Data[0]["nodes"].(int) == 0 {
+		t.Fatalf("[swarmdb_test:TestWarm] no index nodes loaded %v", res.Data[0])
+	}
+	tbl, err = swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestWarm] GetTable %s", err)
+	}
+	if again, err := tbl.Warm(u, sdb.WARM_DEPTH); err != nil || again != 0 {
+		t.Fatalf("[swarmdb_test:TestWarm] warming twice loaded %d nodes %v", again, err)
+	}
+	row, ok, err := tbl.Get(u, []byte("warm042@wolk.com"))
+	if err != nil || !ok || !strings.Contains(string(row), "warm042@wolk.com") {
+		t.Fatalf("[swarmdb_test:TestWarm] Get %s %v", row, err)
+	}
+}
+
+func TestScanEach(t *testing.T) {
+	owner, database, tableName := make_table(t, "scaneach")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestScanEach] GetTable %s", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err = tbl.Put(u, map[string]interface{}{"email": fmt.Sprintf("each%02d@wolk.com", i), "name": "Each", "age": i}); err != nil {
+			t.Fatalf("[swarmdb_test:TestScanEach] Put %s", err)
+		}
+	}
+
+	// cells are read without decoding the row, and the scan stops when asked to
+	var keys []string
+	sum := 0
+	err = tbl.ScanEach(u, 1, func(r *sdb.RowView) (bool, error) {
+		age, ok, err := r.Cell("age")
+		if err != nil || !ok {
+			return false, fmt.Errorf("Cell %v %v", ok, err)
+		}
+		sum += age.(int)
+		keys = append(keys, strings.TrimRight(r.KeyString(), "\x00"))
+		return len(keys) < 5, nil
+	})
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestScanEach] ScanEach %s", err)
+	}
+	if len(keys) != 5 || keys[0] != "each00@wolk.com" || keys[4] != "each04@wolk.com" || sum != 0+1+2+3+4 {
+		t.Fatalf("[swarmdb_test:TestScanEach] ascending keys %v sum %d", keys, sum)
+	}
+
+	var last sdbc.Row
+	err = tbl.ScanEach(u, 0, func(r *sdb.RowView) (bool, error) {
+		row, ok, err := r.Row()
+		if err != nil || !ok {
+			return false, fmt.Errorf("Row %v %v", ok, err)
+		}
+		last = row
+		return false, nil
+	})
+	if err != nil || last["email"] != "each19@wolk.com" || last["name"] != "Each" {
+		t.Fatalf("[swarmdb_test:TestScanEach] descending first row %v %v", last, err)
+	}
+}
+
+func TestScanSecondary(t *testing.T) {
+	owner, database, tableName := make_table(t, "scansecondary")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestScanSecondary] GetTable %s", err)
+	}
+	for _, row := range []map[string]interface{}{
+		{"email": "a@wolk.com", "name": "Cy", "age": 30},
+		{"email": "b@wolk.com", "name": "Al", "age": 10},
+		{"email": "c@wolk.com", "name": "Bo", "age": 20},
+		{"email": "d@wolk.com", "name": "Di", "age": 40},
+		{"email": "c@wolk.com", "name": "Ed", "age": 20},
+	} {
+		if err = tbl.Put(u, row); err != nil {
+			t.Fatalf("[swarmdb_test:TestScanSecondary] Put %s", err)
+		}
+	}
+	if _, err = tbl.Delete(u, "d@wolk.com"); err != nil {
+		t.Fatalf("[swarmdb_test:TestScanSecondary] Delete %s", err)
+	}
+	emails := func(rows []sdbc.Row) (out []string) {
+		for _, row := range rows {
+			out = append(out, row["email"].(string))
+		}
+		return out
+	}
+
+	// the entries left behind by the update of c and the delete of d are skipped
+	for _, tc := range []struct {
+		column    string
+		ascending int
+		limit     int
+		expected  string
+	}{
+		{"name", 1, 0, "b@wolk.com a@wolk.com c@wolk.com"},
+		{"age", 0, 0, "a@wolk.com c@wolk.com b@wolk.com"},
+		{"name", 1, 2, "b@wolk.com a@wolk.com"},
+		{"email", 0, 1, "c@wolk.com"},
+	} {
+		rows, err := tbl.ScanLimit(u, tc.column, tc.ascending, tc.limit)
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestScanSecondary] ScanLimit %s %s", tc.column, err)
+		}
+		if got := strings.Join(emails(rows), " "); got != tc.expected {
+			t.Fatalf("[swarmdb_test:TestScanSecondary] ScanLimit %s %d %d: %s, expected %s", tc.column, tc.ascending, tc.limit, got, tc.expected)
+		}
+	}
+	if rows, err := tbl.Scan(u, "age", 1); err != nil || len(rows) != 3 || rows[0]["name"] != "Al" {
+		t.Fatalf("[swarmdb_test:TestScanSecondary] Scan age %v %v", rows, err)
+	}
+}
+
+func TestMemtable(t *testing.T) {
+	owner, database, tableName := make_table(t, "memtable")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestMemtable] GetTable %s", err)
+	}
+	if err = tbl.StartBuffer(u); err != nil {
+		t.Fatalf("[swarmdb_test:TestMemtable] StartBuffer %s", err)
+	}
+	for _, email := range []string{"ann@wolk.com", "bob@wolk.com"} {
+		if err = tbl.Put(u, map[string]interface{}{"email": email, "name": "Mem", "age": 7}); err != nil {
+			t.Fatalf("[swarmdb_test:TestMemtable] Put %s", err)
+		}
+	}
+
+	// buffered writes are read back from memory
+	if row, ok, err := tbl.Get(u, []byte("ann@wolk.com")); err != nil || !ok || !strings.Contains(string(row), `"Mem"`) {
+		t.Fatalf("[swarmdb_test:TestMemtable] Get %s %v %v", row, ok, err)
+	}
+	if ok, err := tbl.Delete(u, "bob@wolk.com"); err != nil || !ok {
+		t.Fatalf("[swarmdb_test:TestMemtable] Delete %v %v", ok, err)
+	}
+	if _, ok, err := tbl.Get(u, []byte("bob@wolk.com")); err != nil || ok {
+		t.Fatalf("[swarmdb_test:TestMemtable] Get deleted row %v %v", ok, err)
+	}
+	if rows, hits := tbl.MemtableStats(); rows != 2 || hits < 2 {
+		t.Fatalf("[swarmdb_test:TestMemtable] MemtableStats rows %d hits %d", rows, hits)
+	}
+
+	// the flush drains it, and reads go to the indexes again
+	if err = tbl.FlushBuffer(u); err != nil {
+		t.Fatalf("[swarmdb_test:TestMemtable] FlushBuffer %s", err)
+	}
+	if rows, _ := tbl.MemtableStats(); rows != 0 {
+		t.Fatalf("[swarmdb_test:TestMemtable] %d rows after FlushBuffer", rows)
+	}
+	if row, ok, err := tbl.Get(u, []byte("ann@wolk.com")); err != nil || !ok || !strings.Contains(string(row), `"Mem"`) {
+		t.Fatalf("[swarmdb_test:TestMemtable] Get after FlushBuffer %s %v %v", row, ok, err)
+	}
+}
+
+func TestColumnFamilies(t *testing.T) {
+	owner, database, tableName := make_table(t, "families")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestColumnFamilies] GetTable %s", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "old@wolk.com", "name": "Old", "age": 1}); err != nil {
+		t.Fatalf("[swarmdb_test:TestColumnFamilies] Put %s", err)
+	}
+	if err = tbl.SetColumnFamilies(u, map[string]int{"email": 1}); err == nil {
+		t.Fatalf("[swarmdb_test:TestColumnFamilies] primary key moved to family 1")
+	}
+	if err = tbl.SetColumnFamilies(u, map[string]int{"age": 1}); err != nil {
+		t.Fatalf("[swarmdb_test:TestColumnFamilies] SetColumnFamilies %s", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "new@wolk.com", "name": "New", "age": 2}); err != nil {
+		t.Fatalf("[swarmdb_test:TestColumnFamilies] Put %s", err)
+	}
+
+	// the families survive reopening the table, and Get puts rows back together
+	if _, err = swarmdb.Admin(u, config, sdb.ADMIN_CLOSE_TABLE, &sdbc.RequestOption{Owner: owner, Database: database, Table: tableName}); err != nil {
+		t.Fatalf("[swarmdb_test:TestColumnFamilies] CloseTable %s", err)
+	}
+	if tbl, err = swarmdb.GetTable(u, owner, database, tableName); err != nil {
+		t.Fatalf("[swarmdb_test:TestColumnFamilies] GetTable %s", err)
+	}
+	if families := tbl.ColumnFamilies(); len(families) != 1 || families["age"] != 1 {
+		t.Fatalf("[swarmdb_test:TestColumnFamilies] ColumnFamilies %v", families)
+	}
+	newKey := sdb.StringToKey(sdbc.CT_STRING, "new@wolk.com")
+	if row, ok, err := tbl.Get(u, newKey); err != nil || !ok || !strings.Contains(string(row), `"New"`) || !strings.Contains(string(row), `"age":2`) {
+		t.Fatalf("[swarmdb_test:TestColumnFamilies] Get %s %v %v", row, ok, err)
+	}
+
+	// a projection on family 0 leaves the age family unread; rows written before it was set still answer age
+	if row, ok, err := tbl.GetColumns(u, newKey, []string{"name"}); err != nil || !ok || !strings.Contains(string(row), `"New"`) || strings.Contains(string(row), "age") {
+		t.Fatalf("[swarmdb_test:TestColumnFamilies] GetColumns name %s %v %v", row, ok, err)
+	}
+	if row, ok, err := tbl.GetColumns(u, newKey, []string{"age"}); err != nil || !ok || !strings.Contains(string(row), `"age":2`) {
+		t.Fatalf("[swarmdb_test:TestColumnFamilies] GetColumns age %s %v %v", row, ok, err)
+	}
+	if row, ok, err := tbl.GetColumns(u, sdb.StringToKey(sdbc.CT_STRING, "old@wolk.com"), []string{"age"}); err != nil || !ok || !strings.Contains(string(row), `"age":1`) {
+		t.Fatalf("[swarmdb_test:TestColumnFamilies] GetColumns age of old row %s %v %v", row, ok, err)
+	}
+}
+
+func TestChangeStream(t *testing.T) {
+	owner, database, tableName := make_table(t, "cdc")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestChangeStream] GetTable %s", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "cdc@wolk.com", "name": "First", "age": 1}); err != nil {
+		t.Fatalf("[swarmdb_test:TestChangeStream] Put %s", err)
+	}
+
+	// buffered writes are recorded on commit only, rolled back ones never
+	tx, err := swarmdb.Begin(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestChangeStream] Begin %s", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "rolledback@wolk.com", "name": "Rolled Back", "age": 9}); err != nil {
+		t.Fatalf("[swarmdb_test:TestChangeStream] Put %s", err)
+	}
+	if err = tx.Rollback(u); err != nil {
+		t.Fatalf("[swarmdb_test:TestChangeStream] Rollback %s", err)
+	}
+	if tbl, err = swarmdb.GetTable(u, owner, database, tableName); err != nil {
+		t.Fatalf("[swarmdb_test:TestChangeStream] GetTable %s", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "cdc@wolk.com", "name": "Second", "age": 2}); err != nil {
+		t.Fatalf("[swarmdb_test:TestChangeStream] Put %s", err)
+	}
+	if _, err = tbl.Delete(u, "cdc@wolk.com"); err != nil {
+		t.Fatalf("[swarmdb_test:TestChangeStream] Delete %s", err)
+	}
+
+	changes, err := tbl.Changes(u, "", 0)
+	if err != nil || len(changes) != 3 {
+		t.Fatalf("[swarmdb_test:TestChangeStream] Changes %v %v", changes, err)
+	}
+	if c := changes[0]; c.Op != sdb.TE_PUT || c.Before != nil || c.After["name"] != "First" || c.Version != 0 {
+		t.Fatalf("[swarmdb_test:TestChangeStream] first change %+v", c)
+	}
+	if c := changes[1]; c.Op != sdb.TE_PUT || c.Before["name"] != "First" || c.After["name"] != "Second" || c.Version != 1 || len(c.Roothash) == 0 {
+		t.Fatalf("[swarmdb_test:TestChangeStream] second change %+v", c)
+	}
+	if c := changes[2]; c.Op != sdb.TE_DELETE || c.Before["name"] != "Second" || c.After != nil {
+		t.Fatalf("[swarmdb_test:TestChangeStream] third change %+v", c)
+	}
+
+	// tailing from a position returns the records after it
+	rest, err := tbl.Changes(u, changes[0].Position, 1)
+	if err != nil || len(rest) != 1 || rest[0].Position != changes[1].Position {
+		t.Fatalf("[swarmdb_test:TestChangeStream] Changes after %s: %v %v", changes[0].Position, rest, err)
+	}
+	if _, err = tbl.Changes(u, "not a position", 0); err == nil {
+		t.Fatalf("[swarmdb_test:TestChangeStream] bad position accepted")
+	}
+}
+
+func TestTriggers(t *testing.T) {
+	owner, database, tableName := make_table(t, "trigger")
+	sOwner, sDatabase, summaryName := make_table(t, "triggersummary")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestTriggers] GetTable %s", err)
+	}
+
+	var ops []string
+	record := func(u *sdb.SWARMDBUser, ev *sdb.TriggerEvent) error {
+		ops = append(ops, ev.Op)
+		return nil
+	}
+	if err = swarmdb.CreateTrigger(owner, database, tableName, sdb.Trigger{Name: "record", Action: record}); err != nil {
+		t.Fatalf("[swarmdb_test:TestTriggers] CreateTrigger %s", err)
+	}
+	// the summary table counts the rows per name in its age column
+	if err = swarmdb.CreateTrigger(owner, database, tableName, sdb.Trigger{Name: "count", Action: swarmdb.CountAction(sOwner, sDatabase, summaryName, "name", "age")}); err != nil {
+		t.Fatalf("[swarmdb_test:TestTriggers] CreateTrigger %s", err)
+	}
+	if err = swarmdb.CreateTrigger(owner, database, tableName, sdb.Trigger{Name: "count", Action: record}); err == nil {
+		t.Fatalf("[swarmdb_test:TestTriggers] duplicate trigger name accepted")
+	}
+	if names := swarmdb.ListTriggers(owner, database, tableName); len(names) != 2 || names[0] != "count" || names[1] != "record" {
+		t.Fatalf("[swarmdb_test:TestTriggers] ListTriggers %v", names)
+	}
+
+	for _, row := range []map[string]interface{}{
+		{"email": "a@wolk.com", "name": "Alice", "age": 1},
+		{"email": "b@wolk.com", "name": "Alice", "age": 2},
+		{"email": "b@wolk.com", "name": "Bob", "age": 2},
+	} {
+		if err = tbl.Put(u, row); err != nil {
+			t.Fatalf("[swarmdb_test:TestTriggers] Put %s", err)
+		}
+	}
+	if _, err = tbl.Delete(u, "a@wolk.com"); err != nil {
+		t.Fatalf("[swarmdb_test:TestTriggers] Delete %s", err)
+	}
+	if len(ops) != 4 || ops[0] != sdb.TRIGGER_INSERT || ops[1] != sdb.TRIGGER_INSERT || ops[2] != sdb.TRIGGER_UPDATE || ops[3] != sdb.TRIGGER_DELETE {
+		t.Fatalf("[swarmdb_test:TestTriggers] ops %v", ops)
+	}
+	for name, count := range map[string]int{"Alice": 0, "Bob": 1} {
+		mReq, _ := json.Marshal(&sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: sOwner, Database: sDatabase, Table: summaryName, Key: name})
+		res, err := swarmdb.SelectHandler(u, string(mReq))
+		if err != nil || len(res.Data) != 1 {
+			t.Fatalf("[swarmdb_test:TestTriggers] Get %s %v %v", name, res.Data, err)
+		}
+		if fmt.Sprintf("%v", res.Data[0]["age"]) != fmt.Sprintf("%d", count) {
+			t.Fatalf("[swarmdb_test:TestTriggers] count of %s %v, expected %d", name, res.Data[0]["age"], count)
+		}
+	}
+
+	// a failing action fails the write
+	fail := func(u *sdb.SWARMDBUser, ev *sdb.TriggerEvent) error {
+		return fmt.Errorf("refused")
+	}
+	if err = swarmdb.CreateTrigger(owner, database, tableName, sdb.Trigger{Name: "fail", Ops: []string{sdb.TRIGGER_DELETE}, Action: fail}); err != nil {
+		t.Fatalf("[swarmdb_test:TestTriggers] CreateTrigger %s", err)
+	}
+	if _, err = tbl.Delete(u, "b@wolk.com"); err == nil {
+		t.Fatalf("[swarmdb_test:TestTriggers] Delete with a failing trigger succeeded")
+	}
+	if !swarmdb.DropTrigger(owner, database, tableName, "fail") || swarmdb.DropTrigger(owner, database, tableName, "fail") {
+		t.Fatalf("[swarmdb_test:TestTriggers] DropTrigger")
+	}
+}
+
+func TestViews(t *testing.T) {
+	owner, database, tableName := make_table(t, "view")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestViews] GetTable %s", err)
+	}
+	for _, row := range []map[string]interface{}{
+		{"email": "kid@wolk.com", "name": "Kid", "age": 9},
+		{"email": "adult@wolk.com", "name": "Adult", "age": 30},
+	} {
+		if err = tbl.Put(u, row); err != nil {
+			t.Fatalf("[swarmdb_test:TestViews] Put %s", err)
+		}
+	}
+	query := func(rawQuery string) sdbc.SWARMDBResponse {
+		res, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, RawQuery: rawQuery})
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestViews] %s: %s", rawQuery, err)
+		}
+		return res
+	}
+	names := func(res sdbc.SWARMDBResponse) (out []string) {
+		for _, row := range res.Data {
+			out = append(out, fmt.Sprintf("%v", row["name"]))
+		}
+		sort.Strings(out)
+		return out
+	}
+
+	// a view runs its SELECT on every read
+	query(fmt.Sprintf("CREATE VIEW adults AS select email, name from %s where age >= 18", tableName))
+	if got := names(query("select name from adults where email != ''")); !reflect.DeepEqual(got, []string{"Adult"}) {
+		t.Fatalf("[swarmdb_test:TestViews] adults %v", got)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "kid@wolk.com", "name": "Kid", "age": 19}); err != nil {
+		t.Fatalf("[swarmdb_test:TestViews] Put %s", err)
+	}
+	if got := names(query("select name from adults where email != ''")); !reflect.DeepEqual(got, []string{"Adult", "Kid"}) {
+		t.Fatalf("[swarmdb_test:TestViews] adults after Put %v", got)
+	}
+	if _, err = swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, RawQuery: "select age from adults where email != ''"}); err == nil {
+		t.Fatalf("[swarmdb_test:TestViews] column outside the view selected")
+	}
+
+	// a materialized view is a table, kept up to date from the change stream of its source
+	query(fmt.Sprintf("CREATE MATERIALIZED VIEW seniors AS select email, name, age from %s where age >= 30", tableName))
+	if got := names(query("select name from seniors where age >= 0")); !reflect.DeepEqual(got, []string{"Adult"}) {
+		t.Fatalf("[swarmdb_test:TestViews] seniors %v", got)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "kid@wolk.com", "name": "Kid", "age": 40}); err != nil {
+		t.Fatalf("[swarmdb_test:TestViews] Put %s", err)
+	}
+	if _, err = tbl.Delete(u, "adult@wolk.com"); err != nil {
+		t.Fatalf("[swarmdb_test:TestViews] Delete %s", err)
+	}
+	if got := names(query("select name from seniors where age >= 0")); !reflect.DeepEqual(got, []string{"Kid"}) {
+		t.Fatalf("[swarmdb_test:TestViews] seniors after changes %v", got)
+	}
+	if res := query("REFRESH MATERIALIZED VIEW seniors"); res.AffectedRowCount != 0 {
+		t.Fatalf("[swarmdb_test:TestViews] refresh of an up to date view changed %d rows", res.AffectedRowCount)
+	}
+
+	if res := query("DROP VIEW adults"); res.AffectedRowCount != 1 {
+		t.Fatalf("[swarmdb_test:TestViews] DROP VIEW %+v", res)
+	}
+	if res := query("DROP MATERIALIZED VIEW seniors"); res.AffectedRowCount != 1 {
+		t.Fatalf("[swarmdb_test:TestViews] DROP MATERIALIZED VIEW %+v", res)
+	}
+	if v, err := swarmdb.GetView(owner, database, "seniors"); err != nil || v != nil {
+		t.Fatalf("[swarmdb_test:TestViews] GetView after drop %v %v", v, err)
+	}
+}
+
+func TestKeyspace(t *testing.T) {
+	owner := make_name("kvowner.eth")
+	for i, indexType := range []sdbc.IndexType{sdbc.IT_BPLUSTREE, sdbc.IT_HASHTREE} {
+		name := make_name(fmt.Sprintf("kv%d-", i))
+		if err := swarmdb.CreateKeyspace(u, owner, name, indexType, 0); err != nil {
+			t.Fatalf("[swarmdb_test:TestKeyspace] CreateKeyspace %s", err)
+		}
+		if err := swarmdb.CreateKeyspace(u, owner, name, indexType, 0); err == nil {
+			t.Fatalf("[swarmdb_test:TestKeyspace] duplicate keyspace created")
+		}
+		for _, k := range []string{"c", "a", "b"} {
+			if err := swarmdb.PutKV(u, owner, name, []byte(k), []byte("value of "+k)); err != nil {
+				t.Fatalf("[swarmdb_test:TestKeyspace] PutKV %s", err)
+			}
+		}
+		if err := swarmdb.PutKV(u, owner, name, []byte("b"), []byte("new value of b")); err != nil {
+			t.Fatalf("[swarmdb_test:TestKeyspace] PutKV %s", err)
+		}
+		if v, ok, err := swarmdb.GetKV(u, owner, name, []byte("b")); err != nil || !ok || string(v) != "new value of b" {
+			t.Fatalf("[swarmdb_test:TestKeyspace] GetKV b %q %v %v", v, ok, err)
+		}
+		if ok, err := swarmdb.DeleteKV(u, owner, name, []byte("a")); err != nil || !ok {
+			t.Fatalf("[swarmdb_test:TestKeyspace] DeleteKV a %v %v", ok, err)
+		}
+		if ok, err := swarmdb.DeleteKV(u, owner, name, []byte("a")); err != nil || ok {
+			t.Fatalf("[swarmdb_test:TestKeyspace] DeleteKV of a missing key %v %v", ok, err)
+		}
+		if _, ok, err := swarmdb.GetKV(u, owner, name, []byte("a")); err != nil || ok {
+			t.Fatalf("[swarmdb_test:TestKeyspace] GetKV of a deleted key %v %v", ok, err)
+		}
+
+		var keys []string
+		err := swarmdb.IterateKV(u, owner, name, nil, func(k []byte, v []byte) bool {
+			keys = append(keys, string(k))
+			return true
+		})
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestKeyspace] IterateKV %s", err)
+		}
+		if indexType == sdbc.IT_HASHTREE {
+			sort.Strings(keys)
+		}
+		if !reflect.DeepEqual(keys, []string{"b", "c"}) {
+			t.Fatalf("[swarmdb_test:TestKeyspace] IterateKV %v", keys)
+		}
+
+		// through the wire
+		req, _ := json.Marshal(&sdbc.RequestOption{RequestType: wire.RT_GET_KV, Owner: owner, Table: name, Key: "c"})
+		resp, err := swarmdb.SelectHandler(u, string(req))
+		if err != nil || len(resp.Data) != 1 || resp.Data[0]["value"] != "value of c" {
+			t.Fatalf("[swarmdb_test:TestKeyspace] RT_GET_KV %+v %v", resp.Data, err)
+		}
+	}
+	if _, _, err := swarmdb.GetKV(u, owner, make_name("kvmissing"), []byte("a")); err == nil {
+		t.Fatalf("[swarmdb_test:TestKeyspace] GetKV of a missing keyspace")
+	}
+}
+
+func TestDocuments(t *testing.T) {
+	owner := make_name("docowner.eth")
+	name := make_name("people")
+	for _, doc := range []map[string]interface{}{
+		{"_id": "alice", "age": 31, "address": map[string]interface{}{"city": "Oakland"}, "tags": []interface{}{"admin", "dev"}},
+		{"_id": "bob", "age": 25, "address": map[string]interface{}{"city": "Berkeley"}, "tags": []interface{}{"dev"}},
+		{"_id": "carol", "age": 42, "address": map[string]interface{}{"city": "Oakland"}},
+	} {
+		if _, err := swarmdb.InsertDoc(u, owner, name, doc); err != nil {
+			t.Fatalf("[swarmdb_test:TestDocuments] InsertDoc %s", err)
+		}
+	}
+	if _, err := swarmdb.InsertDoc(u, owner, name, map[string]interface{}{"_id": "bob"}); err == nil {
+		t.Fatalf("[swarmdb_test:TestDocuments] duplicate _id inserted")
+	}
+	id, err := swarmdb.InsertDoc(u, owner, name, map[string]interface{}{"age": 7})
+	if err != nil || len(id) == 0 {
+		t.Fatalf("[swarmdb_test:TestDocuments] InsertDoc without _id %q %v", id, err)
+	}
+
+	ids := func(filter string) (out []string) {
+		docs, err := swarmdb.FindDocs(u, owner, name, filter, 0)
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestDocuments] FindDocs %s %s", filter, err)
+		}
+		for _, doc := range docs {
+			out = append(out, doc["_id"].(string))
+		}
+		sort.Strings(out)
+		return out
+	}
+	if got := ids(`{}`); len(got) != 4 {
+		t.Fatalf("[swarmdb_test:TestDocuments] all %v", got)
+	}
+	if got := ids(`{"tags": "dev", "age": {"$lt": 30}}`); !reflect.DeepEqual(got, []string{"bob"}) {
+		t.Fatalf("[swarmdb_test:TestDocuments] tags and age %v", got)
+	}
+	if got := ids(`{"age": {"$in": [25, 42]}}`); !reflect.DeepEqual(got, []string{"bob", "carol"}) {
+		t.Fatalf("[swarmdb_test:TestDocuments] $in %v", got)
+	}
+
+	// address.city is indexed after DOC_AUTO_INDEX_FILTERS filters on it, and answers the same
+	c, err := swarmdb.GetCollection(u, owner, name, false)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestDocuments] GetCollection %s", err)
+	}
+	for i := 0; i < sdb.DOC_AUTO_INDEX_FILTERS; i++ {
+		if got := ids(`{"address.city": "Oakland"}`); !reflect.DeepEqual(got, []string{"alice", "carol"}) {
+			t.Fatalf("[swarmdb_test:TestDocuments] address.city %v", got)
+		}
+	}
+	if paths := c.IndexPaths(); !reflect.DeepEqual(paths, []string{"address.city"}) {
+		t.Fatalf("[swarmdb_test:TestDocuments] IndexPaths %v", paths)
+	}
+	if _, err = swarmdb.InsertDoc(u, owner, name, map[string]interface{}{"_id": "dave", "address": map[string]interface{}{"city": "Oakland"}}); err != nil {
+		t.Fatalf("[swarmdb_test:TestDocuments] InsertDoc %s", err)
+	}
+	if got := ids(`{"address.city": "Oakland", "age": {"$gt": 40}}`); !reflect.DeepEqual(got, []string{"carol"}) {
+		t.Fatalf("[swarmdb_test:TestDocuments] indexed address.city and age %v", got)
+	}
+	if got := ids(`{"address.city": "Oakland"}`); !reflect.DeepEqual(got, []string{"alice", "carol", "dave"}) {
+		t.Fatalf("[swarmdb_test:TestDocuments] indexed address.city %v", got)
+	}
+
+	if err = swarmdb.CreateDocIndex(u, owner, name, "tags"); err != nil {
+		t.Fatalf("[swarmdb_test:TestDocuments] CreateDocIndex %s", err)
+	}
+	if got := ids(`{"tags": {"$eq": "admin"}}`); !reflect.DeepEqual(got, []string{"alice"}) {
+		t.Fatalf("[swarmdb_test:TestDocuments] indexed tags %v", got)
+	}
+	if _, err = swarmdb.FindDocs(u, owner, name, `{"age": {"$near": 1}}`, 0); err == nil {
+		t.Fatalf("[swarmdb_test:TestDocuments] unknown operator accepted")
+	}
+	if _, err = swarmdb.FindDocs(u, owner, make_name("nobody"), `{}`, 0); err == nil {
+		t.Fatalf("[swarmdb_test:TestDocuments] FindDocs on a missing collection")
+	}
+}
+
+func TestGraph(t *testing.T) {
+	owner := make_name("graphowner.eth")
+	name := make_name("social")
+	for _, e := range []sdb.Edge{
+		{Src: "alice", Label: "follows", Dst: "bob", Props: map[string]interface{}{"since": 2016}},
+		{Src: "alice", Label: "follows", Dst: "carol", Props: map[string]interface{}{"since": 2018}},
+		{Src: "bob", Label: "follows", Dst: "dave"},
+		{Src: "carol", Label: "follows", Dst: "alice"},
+		{Src: "carol", Label: "blocks", Dst: "erin"},
+	} {
+		if err := swarmdb.PutEdge(u, owner, name, e); err != nil {
+			t.Fatalf("[swarmdb_test:TestGraph] PutEdge %s", err)
+		}
+	}
+
+	ends := func(steps []sdb.TraversalStep) (out []string) {
+		for _, s := range steps {
+			out = append(out, fmt.Sprintf("%s-%s-%d", s.Src, s.Dst, s.Depth))
+		}
+		sort.Strings(out)
+		return out
+	}
+	steps, err := swarmdb.Traverse(u, owner, name, "alice", 2, &sdb.TraverseFilter{Labels: []string{"follows"}})
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestGraph] Traverse %s", err)
+	}
+	if got := ends(steps); !reflect.DeepEqual(got, []string{"alice-bob-1", "alice-carol-1", "bob-dave-2", "carol-alice-2"}) {
+		t.Fatalf("[swarmdb_test:TestGraph] friends of friends %v", got)
+	}
+	steps, err = swarmdb.Traverse(u, owner, name, "alice", 1, &sdb.TraverseFilter{Where: map[string]interface{}{"since": map[string]interface{}{"$gte": 2017}}})
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestGraph] Traverse %s", err)
+	}
+	if got := ends(steps); !reflect.DeepEqual(got, []string{"alice-carol-1"}) {
+		t.Fatalf("[swarmdb_test:TestGraph] where since %v", got)
+	}
+	steps, err = swarmdb.Traverse(u, owner, name, "alice", 1, &sdb.TraverseFilter{Direction: sdb.GRAPH_IN})
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestGraph] Traverse %s", err)
+	}
+	if got := ends(steps); !reflect.DeepEqual(got, []string{"carol-alice-1"}) {
+		t.Fatalf("[swarmdb_test:TestGraph] followers %v", got)
+	}
+	// alice and carol follow each other: the edges between them are returned once each
+	steps, err = swarmdb.Traverse(u, owner, name, "carol", 1, &sdb.TraverseFilter{Direction: sdb.GRAPH_BOTH})
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestGraph] Traverse %s", err)
+	}
+	if got := ends(steps); !reflect.DeepEqual(got, []string{"alice-carol-1", "carol-alice-1", "carol-erin-1"}) {
+		t.Fatalf("[swarmdb_test:TestGraph] both directions %v", got)
+	}
+
+	if ok, err := swarmdb.DeleteEdge(u, owner, name, "alice", "follows", "bob"); err != nil || !ok {
+		t.Fatalf("[swarmdb_test:TestGraph] DeleteEdge %v %v", ok, err)
+	}
+	req, _ := json.Marshal(&sdbc.RequestOption{RequestType: wire.RT_TRAVERSE, Owner: owner, Table: name, Key: "alice", Rows: []sdbc.Row{{"depth": 3}}})
+	resp, err := swarmdb.SelectHandler(u, string(req))
+	if err != nil || len(resp.Data) != 3 {
+		t.Fatalf("[swarmdb_test:TestGraph] RT_TRAVERSE after DeleteEdge %+v %v", resp.Data, err)
+	}
+	if _, err = swarmdb.Traverse(u, owner, name, "alice", sdb.GRAPH_DEPTH_MAX+1, nil); err == nil {
+		t.Fatalf("[swarmdb_test:TestGraph] depth over GRAPH_DEPTH_MAX accepted")
+	}
+}
+
+func TestQueryBudget(t *testing.T) {
+	owner, database, tableName := make_table(t, "budget")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestQueryBudget] GetTable %s", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err = tbl.Put(u, map[string]interface{}{"email": fmt.Sprintf("budget%d@wolk.com", i), "name": "Budget", "age": 20 + i}); err != nil {
+			t.Fatalf("[swarmdb_test:TestQueryBudget] Put %s", err)
+		}
+	}
+	scan := &sdbc.RequestOption{RequestType: sdbc.RT_SCAN, Owner: owner, Database: database, Table: tableName}
+
+	limited := u.WithBudget(2, 0)
+	_, err = swarmdb.HandleRequest(limited, scan)
+	if sErr, ok := err.(*sdbc.SWARMDBError); !ok || sErr.ErrorCode != 509 {
+		t.Fatalf("[swarmdb_test:TestQueryBudget] scan over budget returned %v", err)
+	}
+
+	unlimited := u.WithBudget(0, 0)
+	if res, err := swarmdb.HandleRequest(unlimited, scan); err != nil || len(res.Data) != 5 {
+		t.Fatalf("[swarmdb_test:TestQueryBudget] scan %v %v", res, err)
+	}
+	if chunks, bytes := unlimited.BudgetUsed(); chunks < 5 || bytes <= 0 {
+		t.Fatalf("[swarmdb_test:TestQueryBudget] scan charged %d chunks, %d bytes", chunks, bytes)
+	}
+	if _, err := swarmdb.HandleRequest(u.WithBudget(0, 1), scan); err == nil {
+		t.Fatalf("[swarmdb_test:TestQueryBudget] scan over byte budget succeeded")
+	}
+}
+
+func TestSizeLimits(t *testing.T) {
+	owner, database, tableName := make_table(t, "limits", sdbc.Column{ColumnName: "notes", IndexType: sdbc.IT_NONE, ColumnType: sdbc.CT_STRING})
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestSizeLimits] GetTable %s", err)
+	}
+	long := strings.Repeat("k", sdb.K_SIZE_MAX) + "@wolk.com"
+	err = tbl.Put(u, map[string]interface{}{"email": long, "name": "Long", "age": 30})
+	if sErr, ok := err.(*sdbc.SWARMDBError); !ok || sErr.ErrorCode != sdb.KEY_TOO_LARGE {
+		t.Fatalf("[swarmdb_test:TestSizeLimits] Put of a %d byte key returned %v", len(long), err)
+	}
+
+	// keys longer than K_SIZE are kept whole, not cut to their first K_SIZE bytes
+	long = strings.Repeat("k", sdb.K_SIZE) + "@wolk.com"
+	if err = tbl.Put(u, map[string]interface{}{"email": long, "name": "Long", "age": 30}); err != nil {
+		t.Fatalf("[swarmdb_test:TestSizeLimits] Put of a %d byte key %s", len(long), err)
+	}
+	if out, ok, err := tbl.Get(u, []byte(long)); err != nil || !ok || !strings.Contains(string(out), long) {
+		t.Fatalf("[swarmdb_test:TestSizeLimits] Get of a %d byte key %s %v %v", len(long), out, ok, err)
+	}
+	if _, ok, err := tbl.Get(u, []byte(long[:sdb.K_SIZE])); err != nil || ok {
+		t.Fatalf("[swarmdb_test:TestSizeLimits] truncated key stored %v %v", ok, err)
+	}
+
+	// the value of a secondary index is limited as a primary key is
+	longName := map[string]interface{}{"email": "longname@wolk.com", "name": strings.Repeat("n", sdb.K_SIZE_MAX+1), "age": 31}
+	err = tbl.Put(u, longName)
+	if sErr, ok := err.(*sdbc.SWARMDBError); !ok || sErr.ErrorCode != sdb.KEY_TOO_LARGE {
+		t.Fatalf("[swarmdb_test:TestSizeLimits] Put of a %d byte secondary key returned %v", sdb.K_SIZE_MAX+1, err)
+	}
+	if _, ok, err := tbl.Get(u, []byte("longname@wolk.com")); err != nil || ok {
+		t.Fatalf("[swarmdb_test:TestSizeLimits] row with a truncated secondary key stored %v %v", ok, err)
+	}
+	longName["name"] = strings.Repeat("n", sdb.K_SIZE_MAX)
+	if err = tbl.Put(u, longName); err != nil {
+		t.Fatalf("[swarmdb_test:TestSizeLimits] Put of a %d byte secondary key %s", sdb.K_SIZE_MAX, err)
+	}
+
+	// an oversize row, in a column that is not indexed
+	big := map[string]interface{}{"email": "big@wolk.com", "name": "Big", "age": 30, "notes": strings.Repeat("n", sdb.ROW_VALUE_SIZE_MAX)}
+	err = tbl.Put(u, big)
+	if code := wire.ErrorOf(err); code == nil || code.Code != wire.ErrTooLarge {
+		t.Fatalf("[swarmdb_test:TestSizeLimits] Put of an oversize row returned %v", err)
+	}
+	if _, ok, err := tbl.Get(u, []byte("big@wolk.com")); err != nil || ok {
+		t.Fatalf("[swarmdb_test:TestSizeLimits] oversize row stored %v %v", ok, err)
+	}
+	delete(big, "notes")
+	if err = tbl.Put(u, big); err != nil {
+		t.Fatalf("[swarmdb_test:TestSizeLimits] Put %s", err)
+	}
+}
+
+func TestGetTableInfo(t *testing.T) {
+	owner, database, tableName := make_table(t, "tableinfo")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestGetTableInfo] GetTable %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = tbl.Put(u, map[string]interface{}{"email": fmt.Sprintf("info%d@wolk.com", i), "name": "Info", "age": 40 + i}); err != nil {
+			t.Fatalf("[swarmdb_test:TestGetTableInfo] Put %s", err)
+		}
+	}
+
+	// the table is opened from ENS when it is not open
+	swarmdb.UnregisterTable(owner, database, tableName)
+	res, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: wire.RT_TABLE_INFO, Owner: owner, Database: database, Table: tableName})
+	if err != nil || len(res.Data) != 1 {
+		t.Fatalf("[swarmdb_test:TestGetTableInfo] TABLE INFO %v %v", res, err)
+	}
+	info := res.Data[0]
+	if info["rowcount"] != 3 || len(info["roothash"].(string)) != 64 {
+		t.Fatalf("[swarmdb_test:TestGetTableInfo] TABLE INFO %v", info)
+	}
+	columns := info["columns"].([]sdbc.Row)
+	if len(columns) != 3 || columns[0]["ColumnName"] != "email" || columns[0]["Primary"] != 1 || len(columns[0]["roothash"].(string)) == 0 {
+		t.Fatalf("[swarmdb_test:TestGetTableInfo] columns %v", columns)
+	}
+	if _, err = swarmdb.GetTableInfo(u, owner, database, "notatable"); err == nil {
+		t.Fatalf("[swarmdb_test:TestGetTableInfo] missing table described")
+	}
+}
+
+func TestTableLifecycle(t *testing.T) {
+	owner, database, tableName := make_table(t, "lifecycle")
+	tbl, err := swarmdb.AcquireTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestTableLifecycle] AcquireTable %s", err)
+	}
+	if err = tbl.StartBuffer(u); err != nil {
+		t.Fatalf("[swarmdb_test:TestTableLifecycle] StartBuffer %s", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "lifecycle@wolk.com", "name": "Life", "age": 1}); err != nil {
+		t.Fatalf("[swarmdb_test:TestTableLifecycle] Put %s", err)
+	}
+
+	// neither a referenced nor a buffered table is evicted, and a referenced one cannot be closed
+	swarmdb.EvictIdle(0)
+	if again, err := swarmdb.GetTable(u, owner, database, tableName); err != nil || again != tbl {
+		t.Fatalf("[swarmdb_test:TestTableLifecycle] referenced table evicted %v", err)
+	}
+	if _, err = swarmdb.CloseTable(u, owner, database, tableName); err == nil {
+		t.Fatalf("[swarmdb_test:TestTableLifecycle] referenced table closed")
+	}
+	swarmdb.ReleaseTable(tbl)
+	swarmdb.EvictIdle(0)
+	if again, err := swarmdb.GetTable(u, owner, database, tableName); err != nil || again != tbl {
+		t.Fatalf("[swarmdb_test:TestTableLifecycle] buffered table evicted %v", err)
+	}
+
+	// closing flushes the buffer first, and the next GetTable opens the table again from ENS
+	closed, err := swarmdb.CloseTable(u, owner, database, tableName)
+	if err != nil || !closed {
+		t.Fatalf("[swarmdb_test:TestTableLifecycle] CloseTable %v %v", closed, err)
+	}
+	reopened, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil || reopened == tbl {
+		t.Fatalf("[swarmdb_test:TestTableLifecycle] GetTable after close %v", err)
+	}
+	if _, ok, err := reopened.Get(u, []byte("lifecycle@wolk.com")); err != nil || !ok {
+		t.Fatalf("[swarmdb_test:TestTableLifecycle] Get after close %v %v", ok, err)
+	}
+
+	// an idle table is evicted
+	if n := swarmdb.EvictIdle(0); n == 0 {
+		t.Fatalf("[swarmdb_test:TestTableLifecycle] idle table not evicted")
+	}
+	if again, err := swarmdb.GetTable(u, owner, database, tableName); err != nil || again == reopened {
+		t.Fatalf("[swarmdb_test:TestTableLifecycle] GetTable after eviction %v", err)
+	}
+}
+
+func TestBlob(t *testing.T) {
+	owner, database, tableName := make_table(t, "blob")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestBlob] GetTable %s", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "blob@wolk.com", "name": "Blob", "age": 7}); err != nil {
+		t.Fatalf("[swarmdb_test:TestBlob] Put %s", err)
+	}
+	// three parts, the last one short, under a node of level 2
+	data := make([]byte, 2*sdb.BLOB_PART_SIZE+1000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	manifest, err := tbl.PutBlob(u, "blob@wolk.com", "name", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestBlob] PutBlob %s", err)
+	}
+	if _, err = tbl.PutBlob(u, "blob@wolk.com", "age", bytes.NewReader(data)); err == nil {
+		t.Fatalf("[swarmdb_test:TestBlob] blob stored in an integer column")
+	}
+
+	// only the manifest hash is in the row, whose other columns are kept
+	res, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: "blob@wolk.com"})
+	if err != nil || len(res.Data) != 1 || res.Data[0]["name"] != fmt.Sprintf("%x", manifest) || res.Data[0]["age"] != 7 {
+		t.Fatalf("[swarmdb_test:TestBlob] GET %v %v", res, err)
+	}
+
+	br, ok, err := tbl.GetBlob(u, "blob@wolk.com", "name")
+	if err != nil || !ok || br.Size() != int64(len(data)) {
+		t.Fatalf("[swarmdb_test:TestBlob] GetBlob %v %v", ok, err)
+	}
+	read, err := ioutil.ReadAll(br)
+	if err != nil || !bytes.Equal(read, data) {
+		t.Fatalf("[swarmdb_test:TestBlob] ReadAll %d bytes %v", len(read), err)
+	}
+	// a read across the boundary of two parts
+	off := int64(sdb.BLOB_PART_SIZE - 10)
+	p := make([]byte, 20)
+	if n, err := br.ReadAt(p, off); err != nil || n != 20 || !bytes.Equal(p, data[off:off+20]) {
+		t.Fatalf("[swarmdb_test:TestBlob] ReadAt %d %v", n, err)
+	}
+	if _, ok, err = tbl.GetBlob(u, "noblob@wolk.com", "name"); err != nil || ok {
+		t.Fatalf("[swarmdb_test:TestBlob] GetBlob of a missing row %v %v", ok, err)
+	}
+
+	// an empty blob
+	manifest, _, err = swarmdb.StoreBlob(u, bytes.NewReader(nil), 0)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestBlob] StoreBlob %s", err)
+	}
+	if br, err = swarmdb.OpenBlob(u, manifest); err != nil || br.Size() != 0 {
+		t.Fatalf("[swarmdb_test:TestBlob] OpenBlob %v", err)
+	}
+}
+
+func TestUnindexedFilter(t *testing.T) {
+	owner, database, tableName := make_name("planowner.eth"), make_name("plandb"), make_name("plantbl")
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_CREATE_DATABASE, Owner: owner, Database: database}); err != nil {
+		t.Fatalf("[swarmdb_test:TestUnindexedFilter] CreateDatabase %s", err)
+	}
+	columns := []sdbc.Column{
+		sdbc.Column{ColumnName: "email", Primary: 1, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_STRING},
+		sdbc.Column{ColumnName: "note", Primary: 0, IndexType: sdbc.IT_NONE, ColumnType: sdbc.CT_STRING},
+	}
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_CREATE_TABLE, Owner: owner, Database: database, Table: tableName, Columns: columns}); err != nil {
+		t.Fatalf("[swarmdb_test:TestUnindexedFilter] CreateTable %s", err)
+	}
+	for i := 0; i < 4; i++ {
+		row := sdbc.Row{"email": fmt.Sprintf("plan%d@wolk.com", i), "note": fmt.Sprintf("note%d", i%2)}
+		if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{row}}); err != nil {
+			t.Fatalf("[swarmdb_test:TestUnindexedFilter] Put %s", err)
+		}
+	}
+	query := func(sql string) sdbc.SWARMDBResponse {
+		res, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, Table: tableName, RawQuery: sql})
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestUnindexedFilter] %s: %s", sql, err)
+		}
+		return res
+	}
+
+	plan := query(fmt.Sprintf("EXPLAIN SELECT email, note FROM %s WHERE note = 'note1'", tableName)).Data[0]
+	if plan["access"] != sdb.PLAN_FULL_SCAN || len(plan["warnings"].([]string)) != 1 || !strings.Contains(plan["warnings"].([]string)[0], "no index") {
+		t.Fatalf("[swarmdb_test:TestUnindexedFilter] EXPLAIN %v", plan)
+	}
+	plan = query(fmt.Sprintf("explain select email from %s where email = 'plan1@wolk.com'", tableName)).Data[0]
+	if plan["access"] != sdb.PLAN_PRIMARY_GET || len(plan["warnings"].([]string)) != 0 {
+		t.Fatalf("[swarmdb_test:TestUnindexedFilter] EXPLAIN on the primary key %v", plan)
+	}
+
+	// the unindexed column is filtered by scanning, by SELECT, UPDATE and DELETE alike
+	if res := query(fmt.Sprintf("SELECT email, note FROM %s WHERE note = 'note1'", tableName)); len(res.Data) != 2 {
+		t.Fatalf("[swarmdb_test:TestUnindexedFilter] SELECT %v", res.Data)
+	}
+	if res := query(fmt.Sprintf("UPDATE %s SET note = 'note2' WHERE note = 'note0'", tableName)); res.AffectedRowCount != 2 {
+		t.Fatalf("[swarmdb_test:TestUnindexedFilter] UPDATE affected %d rows", res.AffectedRowCount)
+	}
+	if res := query(fmt.Sprintf("DELETE FROM %s WHERE note = 'note2'", tableName)); res.AffectedRowCount != 2 {
+		t.Fatalf("[swarmdb_test:TestUnindexedFilter] DELETE affected %d rows", res.AffectedRowCount)
+	}
+	if res := query(fmt.Sprintf("SELECT email FROM %s WHERE email > 'plan'", tableName)); len(res.Data) != 2 {
+		t.Fatalf("[swarmdb_test:TestUnindexedFilter] rows left %v", res.Data)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	owner, database, tableName := make_table(t, "hist")
+	for i := 0; i < 40; i++ {
+		row := sdbc.Row{"email": fmt.Sprintf("hist%02d@wolk.com", i), "name": fmt.Sprintf("n%d", i%4), "age": i}
+		if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{row}}); err != nil {
+			t.Fatalf("[swarmdb_test:TestHistogram] Put %s", err)
+		}
+	}
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestHistogram] GetTable %s", err)
+	}
+	// rebuilt on flush once a tenth of the rows changed, so the last rows may not be counted yet
+	h, ok := tbl.Histogram("age")
+	if !ok || h.Keys < 36 || len(h.Buckets) != sdb.HISTOGRAM_BUCKETS {
+		t.Fatalf("[swarmdb_test:TestHistogram] age histogram %v", h)
+	}
+	n := 0
+	for _, b := range h.Buckets {
+		n += b.Count
+	}
+	if n != h.Keys {
+		t.Fatalf("[swarmdb_test:TestHistogram] buckets hold %d of %d keys", n, h.Keys)
+	}
+	if h, ok = tbl.Histogram("name"); !ok || h.Keys != 4 {
+		t.Fatalf("[swarmdb_test:TestHistogram] name histogram %v", h)
+	}
+
+	estimate := func(where string) int {
+		res, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, Table: tableName, RawQuery: fmt.Sprintf("EXPLAIN SELECT email FROM %s WHERE %s", tableName, where)})
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestHistogram] EXPLAIN %s: %s", where, err)
+		}
+		return res.Data[0]["estimate"].(int)
+	}
+	if e := estimate("age < 10"); e < 6 || e > 14 {
+		t.Fatalf("[swarmdb_test:TestHistogram] age < 10 estimated at %d rows", e)
+	}
+	if e := estimate("age > 100"); e != 0 {
+		t.Fatalf("[swarmdb_test:TestHistogram] age > 100 estimated at %d rows", e)
+	}
+	if e := estimate("name = 'n1'"); e < 8 || e > 12 {
+		t.Fatalf("[swarmdb_test:TestHistogram] name = 'n1' estimated at %d rows", e)
+	}
+	if e := estimate("email = 'hist01@wolk.com'"); e != 1 {
+		t.Fatalf("[swarmdb_test:TestHistogram] primary key lookup estimated at %d rows", e)
+	}
+}
+
+func TestConjunction(t *testing.T) {
+	owner, database, tableName := make_table(t, "and")
+	for i := 0; i < 10; i++ {
+		row := sdbc.Row{"email": fmt.Sprintf("and%02d@wolk.com", i), "name": fmt.Sprintf("n%d", i%2), "age": i}
+		if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{row}}); err != nil {
+			t.Fatalf("[swarmdb_test:TestConjunction] Put %s", err)
+		}
+	}
+	query := func(sql string) sdbc.SWARMDBResponse {
+		res, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, Table: tableName, RawQuery: fmt.Sprintf(sql, tableName)})
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestConjunction] %s: %s", sql, err)
+		}
+		return res
+	}
+
+	// the bounds on the primary key are intersected into one range, the other predicates filter its rows
+	ranged := "SELECT email FROM %s WHERE email >= 'and03@wolk.com' AND email < 'and07@wolk.com' AND name = 'n1'"
+	if plan := query("EXPLAIN " + ranged).Data[0]; plan["access"] != sdb.PLAN_PRIMARY_RANGE {
+		t.Fatalf("[swarmdb_test:TestConjunction] EXPLAIN %v", plan)
+	}
+	if res := query(ranged); len(res.Data) != 2 || res.Data[0]["email"] != "and03@wolk.com" || res.Data[1]["email"] != "and05@wolk.com" {
+		t.Fatalf("[swarmdb_test:TestConjunction] range %v", res.Data)
+	}
+	if res := query("SELECT email FROM %s WHERE age > 2 AND age <= 5"); len(res.Data) != 3 {
+		t.Fatalf("[swarmdb_test:TestConjunction] scan %v", res.Data)
+	}
+	if res := query("SELECT email FROM %s WHERE email = 'and01@wolk.com' AND name = 'n0'"); len(res.Data) != 0 {
+		t.Fatalf("[swarmdb_test:TestConjunction] lookup %v", res.Data)
+	}
+	if res := query("DELETE FROM %s WHERE email > 'and07@wolk.com' AND age >= 9"); res.AffectedRowCount != 1 {
+		t.Fatalf("[swarmdb_test:TestConjunction] DELETE affected %d rows", res.AffectedRowCount)
+	}
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, Table: tableName, RawQuery: fmt.Sprintf("SELECT email FROM %s WHERE age > 2 AND (age = 3 OR age = 4)", tableName)}); err == nil {
+		t.Fatalf("[swarmdb_test:TestConjunction] OR within AND accepted")
+	}
+}
+
+func TestStatementCache(t *testing.T) {
+	owner, database, tableName := make_table(t, "stmt")
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{{"email": "stmt1@wolk.com", "name": "Stmt", "age": 1}}}); err != nil {
+		t.Fatalf("[swarmdb_test:TestStatementCache] Put %s", err)
+	}
+	query := func(sql string) sdbc.SWARMDBResponse {
+		res, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, Table: tableName, RawQuery: sql})
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestStatementCache] %s: %s", sql, err)
+		}
+		return res
+	}
+	hits := func() int {
+		res, err := swarmdb.Admin(u, config, sdb.ADMIN_CACHE_STATS, &sdbc.RequestOption{})
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestStatementCache] CacheStats %s", err)
+		}
+		return res.Data[0]["statementCacheHits"].(int)
+	}
+
+	update := fmt.Sprintf("UPDATE %s SET name = 'Cached' WHERE email = 'stmt1@wolk.com'", tableName)
+	before := hits()
+	for i := 0; i < 2; i++ {
+		if res := query(update); res.AffectedRowCount != 1 {
+			t.Fatalf("[swarmdb_test:TestStatementCache] UPDATE %d affected %d rows", i, res.AffectedRowCount)
+		}
+	}
+	if hits() != before+1 {
+		t.Fatalf("[swarmdb_test:TestStatementCache] repeated statement parsed again")
+	}
+
+	// the cached plan of a lookup on the primary key does not outlive the primary key
+	explain := fmt.Sprintf("EXPLAIN SELECT email FROM %s WHERE email = 'stmt1@wolk.com'", tableName)
+	if plan := query(explain).Data[0]; plan["access"] != sdb.PLAN_PRIMARY_GET {
+		t.Fatalf("[swarmdb_test:TestStatementCache] EXPLAIN %v", plan)
+	}
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_DROP_TABLE, Owner: owner, Database: database, Table: tableName}); err != nil {
+		t.Fatalf("[swarmdb_test:TestStatementCache] DropTable %s", err)
+	}
+	columns := []sdbc.Column{
+		sdbc.Column{ColumnName: "id", Primary: 1, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_INTEGER},
+		sdbc.Column{ColumnName: "email", Primary: 0, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_STRING},
+	}
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_CREATE_TABLE, Owner: owner, Database: database, Table: tableName, Columns: columns}); err != nil {
+		t.Fatalf("[swarmdb_test:TestStatementCache] CreateTable %s", err)
+	}
+	if plan := query(explain).Data[0]; plan["access"] != sdb.PLAN_FULL_SCAN {
+		t.Fatalf("[swarmdb_test:TestStatementCache] EXPLAIN after the schema changed %v", plan)
+	}
+}
+
+func TestRowExpiry(t *testing.T) {
+	owner, database, tableName := make_table(t, "expiry")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestRowExpiry] GetTable %s", err)
+	}
+	if err = tbl.SetExpiryColumn(u, "email"); err == nil {
+		t.Fatalf("[swarmdb_test:TestRowExpiry] primary key made the expiry column")
+	}
+	tReq := &sdbc.RequestOption{RequestType: wire.RT_EXPIRY_COLUMN, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{{"column": "age"}}}
+	if res, err := swarmdb.HandleRequest(u, tReq); err != nil || len(res.Data) != 1 || res.Data[0]["column"] != "age" {
+		t.Fatalf("[swarmdb_test:TestRowExpiry] ExpiryColumn %v %v", res, err)
+	}
+	later := int(time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond))
+	for _, row := range []map[string]interface{}{
+		{"email": "expired@wolk.com", "name": "ttl", "age": 1},
+		{"email": "live@wolk.com", "name": "ttl", "age": later},
+		{"email": "forever@wolk.com", "name": "ttl"},
+	} {
+		if err = tbl.Put(u, row); err != nil {
+			t.Fatalf("[swarmdb_test:TestRowExpiry] Put %s", err)
+		}
+	}
+
+	// expired rows are not read, and the flag survives reopening the table
+	if _, err = swarmdb.Admin(u, config, sdb.ADMIN_CLOSE_TABLE, &sdbc.RequestOption{Owner: owner, Database: database, Table: tableName}); err != nil {
+		t.Fatalf("[swarmdb_test:TestRowExpiry] CloseTable %s", err)
+	}
+	if tbl, err = swarmdb.GetTable(u, owner, database, tableName); err != nil {
+		t.Fatalf("[swarmdb_test:TestRowExpiry] GetTable %s", err)
+	}
+	if name, ok := tbl.ExpiryColumn(); !ok || name != "age" {
+		t.Fatalf("[swarmdb_test:TestRowExpiry] ExpiryColumn %s %v", name, ok)
+	}
+	if _, ok, err := tbl.Get(u, sdb.StringToKey(sdbc.CT_STRING, "expired@wolk.com")); err != nil || ok {
+		t.Fatalf("[swarmdb_test:TestRowExpiry] Get expired row %v %v", ok, err)
+	}
+	if _, ok, err := tbl.Get(u, sdb.StringToKey(sdbc.CT_STRING, "live@wolk.com")); err != nil || !ok {
+		t.Fatalf("[swarmdb_test:TestRowExpiry] Get live row %v %v", ok, err)
+	}
+	tReq = &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, Table: tableName, RawQuery: fmt.Sprintf("SELECT email FROM %s WHERE name = 'ttl'", tableName)}
+	if res, err := swarmdb.HandleRequest(u, tReq); err != nil || len(res.Data) != 2 {
+		t.Fatalf("[swarmdb_test:TestRowExpiry] SELECT %v %v", res, err)
+	}
+
+	// the purge deletes the expired row only
+	if n, err := tbl.PurgeExpired(u); err != nil || n != 1 {
+		t.Fatalf("[swarmdb_test:TestRowExpiry] PurgeExpired %d %v", n, err)
+	}
+	if n, err := swarmdb.PurgeExpired(u); err != nil || n != 0 {
+		t.Fatalf("[swarmdb_test:TestRowExpiry] PurgeExpired again %d %v", n, err)
+	}
+}
+
+func TestUUIDKeys(t *testing.T) {
+	owner, database, tableName := make_table(t, "uuid")
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_DROP_TABLE, Owner: owner, Database: database, Table: tableName}); err != nil {
+		t.Fatalf("[swarmdb_test:TestUUIDKeys] DropTable %s", err)
+	}
+	columns := []sdbc.Column{
+		sdbc.Column{ColumnName: "id", Primary: 1, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdb.CT_UUID},
+		sdbc.Column{ColumnName: "name", Primary: 0, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_STRING},
+	}
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_CREATE_TABLE, Owner: owner, Database: database, Table: tableName, Columns: columns}); err != nil {
+		t.Fatalf("[swarmdb_test:TestUUIDKeys] CreateTable %s", err)
+	}
+	put := func(row sdbc.Row) (sdbc.SWARMDBResponse, error) {
+		return swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{row}})
+	}
+
+	// a row without a key gets a version 4 UUID, answered to the writer
+	res, err := put(sdbc.Row{"name": "generated"})
+	if err != nil || len(res.Data) != 1 {
+		t.Fatalf("[swarmdb_test:TestUUIDKeys] Put without key %v %v", res, err)
+	}
+	id, _ := res.Data[0]["id"].(string)
+	if len(id) != 36 || id[14] != '4' {
+		t.Fatalf("[swarmdb_test:TestUUIDKeys] generated key [%s]", id)
+	}
+	get := func(key string) sdbc.SWARMDBResponse {
+		res, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: key})
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestUUIDKeys] Get %s: %s", key, err)
+		}
+		return res
+	}
+	if res = get(id); len(res.Data) != 1 || res.Data[0]["name"] != "generated" {
+		t.Fatalf("[swarmdb_test:TestUUIDKeys] Get generated %v", res.Data)
+	}
+
+	// keys given are stored in canonical form, found in any form, and keys that are not UUIDs are rejected
+	given := "{0F8FAD5B-D9CB-469F-A165-70867728950E}"
+	if res, err = put(sdbc.Row{"id": given, "name": "given"}); err != nil || len(res.Data) != 0 {
+		t.Fatalf("[swarmdb_test:TestUUIDKeys] Put with key %v %v", res, err)
+	}
+	if res = get("0f8fad5bd9cb469fa16570867728950e"); len(res.Data) != 1 || res.Data[0]["id"] != "0f8fad5b-d9cb-469f-a165-70867728950e" {
+		t.Fatalf("[swarmdb_test:TestUUIDKeys] Get given %v", res.Data)
+	}
+	if _, err = put(sdbc.Row{"id": "not-a-uuid", "name": "bad"}); err == nil {
+		t.Fatalf("[swarmdb_test:TestUUIDKeys] Put with a bad key accepted")
+	}
+	query := func(sql string) sdbc.SWARMDBResponse {
+		res, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, Table: tableName, RawQuery: fmt.Sprintf(sql, tableName)})
+		if err != nil {
+			t.Fatalf("[swarmdb_test:TestUUIDKeys] %s: %s", sql, err)
+		}
+		return res
+	}
+	if res = query("INSERT INTO %s (name) VALUES ('inserted')"); res.AffectedRowCount != 1 || len(res.Data) != 1 {
+		t.Fatalf("[swarmdb_test:TestUUIDKeys] INSERT without key %v", res)
+	}
+	if res = query("SELECT name FROM %s WHERE id = '0F8FAD5B-D9CB-469F-A165-70867728950E'"); len(res.Data) != 1 || res.Data[0]["name"] != "given" {
+		t.Fatalf("[swarmdb_test:TestUUIDKeys] SELECT %v", res.Data)
+	}
+	if res = query("SELECT name FROM %s WHERE name >= 'a'"); len(res.Data) != 3 {
+		t.Fatalf("[swarmdb_test:TestUUIDKeys] scan %v", res.Data)
+	}
+}
+
+func TestTimestampColumns(t *testing.T) {
+	owner, database, tableName := make_table(t, "stamp")
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_DROP_TABLE, Owner: owner, Database: database, Table: tableName}); err != nil {
+		t.Fatalf("[swarmdb_test:TestTimestampColumns] DropTable %s", err)
+	}
+	columns := []sdbc.Column{
+		sdbc.Column{ColumnName: "id", Primary: 1, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_STRING},
+		sdbc.Column{ColumnName: "note", Primary: 0, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_STRING},
+		sdbc.Column{ColumnName: "created", Primary: 0, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_INTEGER},
+		sdbc.Column{ColumnName: "updated", Primary: 0, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_STRING},
+	}
+	if _, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_CREATE_TABLE, Owner: owner, Database: database, Table: tableName, Columns: columns}); err != nil {
+		t.Fatalf("[swarmdb_test:TestTimestampColumns] CreateTable %s", err)
+	}
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[swarmdb_test:TestTimestampColumns] GetTable %s", err)
+	}
+	if err = tbl.SetTimestampColumns(u, "created", "created"); err == nil {
+		t.Fatalf("[swarmdb_test:TestTimestampColumns] one column both created_at and updated_at")
+	}
+	tReq := &sdbc.RequestOption{RequestType: wire.RT_TIMESTAMP_COLUMNS, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{{"created": "created", "updated": "updated"}}}
+	if res, err := swarmdb.HandleRequest(u, tReq); err != nil || res.Data[0]["created"] != "created" || res.Data[0]["updated"] != "updated" {
+		t.Fatalf("[swarmdb_test:TestTimestampColumns] TimestampColumns %v %v", res, err)
+	}
+	get := func() sdbc.Row {
+		res, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: "stamp"})
+		if err != nil || len(res.Data) != 1 {
+			t.Fatalf("[swarmdb_test:TestTimestampColumns] Get %v %v", res, err)
+		}
+		return res.Data[0]
+	}
+
+	// the values written are overridden; created_at is kept by later writes, updated_at moves on
+	start := time.Now().Add(-time.Millisecond)
+	if err = tbl.Put(u, map[string]interface{}{"id": "stamp", "note": "first", "created": 5, "updated": "yesterday"}); err != nil {
+		t.Fatalf("[swarmdb_test:TestTimestampColumns] Put %s", err)
+	}
+	first := get()
+	created, _ := first["created"].(int)
+	updated, err := time.Parse(sdb.TIMESTAMP_FORMAT, fmt.Sprintf("%v", first["updated"]))
+	if err != nil || created < int(start.UnixNano()/int64(time.Millisecond)) || updated.Before(start) {
+		t.Fatalf("[swarmdb_test:TestTimestampColumns] first write %v %v", first, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	query := fmt.Sprintf("UPDATE %s SET note = 'second' WHERE id = 'stamp'", tableName)
+	if _, err = swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, Table: tableName, RawQuery: query}); err != nil {
+		t.Fatalf("[swarmdb_test:TestTimestampColumns] UPDATE %s", err)
+	}
+	second := get()
+	if second["note"] != "second" || second["created"] != first["created"] || fmt.Sprintf("%v", second["updated"]) <= fmt.Sprintf("%v", first["updated"]) {
+		t.Fatalf("[swarmdb_test:TestTimestampColumns] second write %v after %v", second, first)
+	}
+
+	// the rows modified recently are selected by comparing the strings
+	query = fmt.Sprintf("SELECT id FROM %s WHERE updated > '%v'", tableName, first["updated"])
+	if res, err := swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, Table: tableName, RawQuery: query}); err != nil || len(res.Data) != 1 {
+		t.Fatalf("[swarmdb_test:TestTimestampColumns] SELECT %v %v", res, err)
+	}
+}
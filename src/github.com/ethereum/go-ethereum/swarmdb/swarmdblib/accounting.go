@@ -0,0 +1,70 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+)
+
+// Balance returns the storage account of owner (see swarmdb.Account), with the chunk replicas its balance still
+// pays for as "credits"
+func (dbc *SWARMDBConnection) Balance(owner string) (account sdbc.Row, err error) {
+	resp, err := dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: "Balance", Owner: owner})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data[0], nil
+}
+
+// SetBid sets the price owner pays per chunk replica it writes; 0 pays the default bid of the server
+func (dbc *SWARMDBConnection) SetBid(owner string, bid int64) (account sdbc.Row, err error) {
+	resp, err := dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: "SetBid", Owner: owner, Rows: []sdbc.Row{{"bid": bid}}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data[0], nil
+}
+
+// Deposit credits amount to the balance of address, recording a payment the server received.  The connection must
+// be authenticated as an admin of the server.
+func (dbc *SWARMDBConnection) Deposit(address string, amount int64) (account sdbc.Row, err error) {
+	resp, err := dbc.Admin("Deposit", sdbc.RequestOption{Rows: []sdbc.Row{{"address": address, "amount": amount}}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data[0], nil
+}
+
+// Balances returns the storage account of address, or of every owner and farmer when address is empty.  The
+// connection must be authenticated as an admin of the server.
+func (dbc *SWARMDBConnection) Balances(address string) (accounts []sdbc.Row, err error) {
+	resp, err := dbc.Admin("Balances", sdbc.RequestOption{Rows: []sdbc.Row{{"address": address}}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// Audit has the server prove custody of up to limit (all if 0) chunks held in escrow, crediting its farmer for each
+// proof; answered with a row of the chunks audited and failed and the amount earned.  The connection must be
+// authenticated as an admin of the server.
+func (dbc *SWARMDBConnection) Audit(limit int) (result sdbc.Row, err error) {
+	resp, err := dbc.Admin("Audit", sdbc.RequestOption{Rows: []sdbc.Row{{"limit": limit}}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data[0], nil
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	"encoding/base64"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"io"
+	"io/ioutil"
+)
+
+// Backup writes an archive of the table at its published root hash to w and returns the number of chunks in it;
+// the connection must be authenticated as an admin of the server (see swarmdb.ADMIN_BACKUP)
+func (dbc *SWARMDBConnection) Backup(owner string, database string, table string, w io.Writer) (chunks int, err error) {
+	row := sdbc.NewRow()
+	row["owner"] = owner
+	row["database"] = database
+	row["table"] = table
+	resp, err := dbc.Admin("Backup", sdbc.RequestOption{Rows: []sdbc.Row{row}})
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Data) == 0 {
+		return 0, &wire.Error{Code: wire.ErrInternal, Number: 506, Message: "Invalid Backup: the server sent no archive"}
+	}
+	archive, _ := resp.Data[0]["archive"].(string)
+	data, err := base64.StdEncoding.DecodeString(archive)
+	if err != nil {
+		return 0, &wire.Error{Code: wire.ErrInternal, Number: 506, Message: fmt.Sprintf("Invalid Backup: %s", err.Error())}
+	}
+	if _, err = w.Write(data); err != nil {
+		return 0, err
+	}
+	n, _ := resp.Data[0]["chunks"].(float64)
+	return int(n), nil
+}
+
+// Restore sends an archive written by Backup to the server, which stores its chunks and publishes the table it holds;
+// resp.Data has a {"owner", "database", "table", "roothash"} row per restored table
+func (dbc *SWARMDBConnection) Restore(r io.Reader) (resp sdbc.SWARMDBResponse, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return resp, err
+	}
+	row := sdbc.NewRow()
+	row["archive"] = base64.StdEncoding.EncodeToString(data)
+	return dbc.Admin("Restore", sdbc.RequestOption{Rows: []sdbc.Row{row}})
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	"context"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Benchmark workloads; each op is drawn at random with the given odds
+const (
+	BENCH_INSERT = "insert" // puts of new rows only
+	BENCH_READ   = "read"   // 95% gets of existing rows, 5% puts
+	BENCH_SCAN   = "scan"   // 90% range scans of ScanLimit rows, 10% puts
+	BENCH_MIXED  = "mixed"  // 50% gets, 30% puts, 20% range scans
+
+	BENCH_DATABASE = "bench"
+)
+
+var benchMix = map[string][3]int{ // percent of gets, puts, scans
+	BENCH_INSERT: {0, 100, 0},
+	BENCH_READ:   {95, 5, 0},
+	BENCH_SCAN:   {0, 10, 90},
+	BENCH_MIXED:  {50, 30, 20},
+}
+
+type BenchConfig struct {
+	Workload    string // one of BENCH_*
+	Owner       string
+	Database    string // created if missing, BENCH_DATABASE by default
+	Table       string // created, a new "bench<unix seconds>" table by default
+	Rows        int    // rows loaded before the timed run, the key space of gets and scans
+	Ops         int    // timed operations over all connections
+	Concurrency int    // connections running ops in parallel
+	ValueSize   int    // bytes of the value column of each row
+	ScanLimit   int    // rows read by each range scan
+}
+
+// BenchLatency summarizes the latencies of one kind of op
+type BenchLatency struct {
+	Ops    int
+	Errors int
+	P50    time.Duration
+	P90    time.Duration
+	P99    time.Duration
+	Max    time.Duration
+}
+
+type BenchResult struct {
+	Config     BenchConfig
+	Elapsed    time.Duration
+	Throughput float64                  // ops per second over all connections
+	Latency    map[string]*BenchLatency // by op: "get", "put", "scan"
+}
+
+func (r *BenchResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "workload %s: %d ops on %d connections in %s, %.1f ops/s\n", r.Config.Workload, r.Config.Ops, r.Config.Concurrency, r.Elapsed, r.Throughput)
+	for _, op := range []string{"get", "put", "scan"} {
+		if l, ok := r.Latency[op]; ok {
+			fmt.Fprintf(&b, "  %-4s %8d ops %4d errors  p50 %-10s p90 %-10s p99 %-10s max %s\n", op, l.Ops, l.Errors, l.P50, l.P90, l.P99, l.Max)
+		}
+	}
+	return b.String()
+}
+
+func (c *BenchConfig) defaults() {
+	if len(c.Workload) == 0 {
+		c.Workload = BENCH_MIXED
+	}
+	if len(c.Database) == 0 {
+		c.Database = BENCH_DATABASE
+	}
+	if len(c.Table) == 0 {
+		c.Table = fmt.Sprintf("bench%d", time.Now().Unix())
+	}
+	if c.Rows <= 0 {
+		c.Rows = 1000
+	}
+	if c.Ops <= 0 {
+		c.Ops = 10000
+	}
+	if c.Concurrency <= 0 {
+		c.Concurrency = 4
+	}
+	if c.ValueSize <= 0 {
+		c.ValueSize = 100
+	}
+	if c.ScanLimit <= 0 {
+		c.ScanLimit = 50
+	}
+}
+
+func benchKey(i int) string {
+	return fmt.Sprintf("key%010d", i)
+}
+
+// RunBench creates the benchmark table, loads config.Rows rows and then runs config.Ops ops of the workload on
+// config.Concurrency connections opened with open, which a server or an embedded instance (OpenEmbeddedConnection)
+// can back alike.  Failed ops are counted, not returned; err reports a failure to set up the table.
+func RunBench(ctx context.Context, open func() (*SWARMDBConnection, error), config BenchConfig) (result *BenchResult, err error) {
+	config.defaults()
+	mix, ok := benchMix[config.Workload]
+	if !ok {
+		return nil, fmt.Errorf("unknown workload [%s]", config.Workload)
+	}
+	dbc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer dbc.Close()
+	if err = benchSetup(dbc, &config); err != nil {
+		return nil, err
+	}
+	value := strings.Repeat("v", config.ValueSize)
+	for start := 0; start < config.Rows; start += 100 {
+		var rows []sdbc.Row
+		for i := start; i < start+100 && i < config.Rows; i++ {
+			rows = append(rows, sdbc.Row{"key": benchKey(i), "value": value, "n": i})
+		}
+		if _, err = dbc.PutCtx(ctx, config.Owner, config.Database, config.Table, rows); err != nil {
+			return nil, err
+		}
+	}
+
+	conns := make([]*SWARMDBConnection, config.Concurrency)
+	for i := range conns {
+		if conns[i], err = open(); err != nil {
+			for _, c := range conns[:i] {
+				c.Close()
+			}
+			return nil, err
+		}
+	}
+	latencies := map[string][]time.Duration{"get": nil, "put": nil, "scan": nil}
+	errors := make(map[string]int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	next := config.Rows
+	begin := time.Now()
+	for w, c := range conns {
+		ops := config.Ops / config.Concurrency
+		if w < config.Ops%config.Concurrency {
+			ops++
+		}
+		wg.Add(1)
+		go func(c *SWARMDBConnection, ops int, seed int64) {
+			defer wg.Done()
+			defer c.Close()
+			rnd := rand.New(rand.NewSource(seed))
+			for i := 0; i < ops && ctx.Err() == nil; i++ {
+				op, p := "scan", rnd.Intn(100)
+				if p < mix[0] {
+					op = "get"
+				} else if p < mix[0]+mix[1] {
+					op = "put"
+				}
+				k := rnd.Intn(config.Rows)
+				start := time.Now()
+				var err error
+				switch op {
+				case "get":
+					_, err = c.GetCtx(ctx, config.Owner, config.Database, config.Table, benchKey(k))
+				case "put":
+					mu.Lock()
+					n := next
+					next++
+					mu.Unlock()
+					_, err = c.PutCtx(ctx, config.Owner, config.Database, config.Table, []sdbc.Row{{"key": benchKey(n), "value": value, "n": n}})
+				case "scan":
+					_, _, err = c.ScanPage(ctx, config.Owner, config.Database, config.Table, wire.ScanRange{Start: benchKey(k), Limit: config.ScanLimit, Ascending: 1})
+				}
+				took := time.Since(start)
+				mu.Lock()
+				latencies[op] = append(latencies[op], took)
+				if err != nil {
+					errors[op]++
+				}
+				mu.Unlock()
+			}
+		}(c, ops, int64(w)+begin.UnixNano())
+	}
+	wg.Wait()
+
+	result = &BenchResult{Config: config, Elapsed: time.Since(begin), Latency: make(map[string]*BenchLatency)}
+	total := 0
+	for op, l := range latencies {
+		if len(l) == 0 {
+			continue
+		}
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		result.Latency[op] = &BenchLatency{Ops: len(l), Errors: errors[op], P50: percentile(l, 50), P90: percentile(l, 90), P99: percentile(l, 99), Max: l[len(l)-1]}
+		total += len(l)
+	}
+	result.Throughput = float64(total) / result.Elapsed.Seconds()
+	return result, nil
+}
+
+// percentile of the sorted latencies l
+func percentile(l []time.Duration, p int) time.Duration {
+	i := (len(l)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return l[i]
+}
+
+// benchSetup creates the database, if missing, and the table of config
+func benchSetup(dbc *SWARMDBConnection, config *BenchConfig) (err error) {
+	resp, err := dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_LIST_DATABASES, Owner: config.Owner})
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, row := range resp.Data {
+		found = found || row["database"] == config.Database
+	}
+	if !found {
+		if _, err = dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_CREATE_DATABASE, Owner: config.Owner, Database: config.Database}); err != nil {
+			return err
+		}
+	}
+	columns := []sdbc.Column{
+		{ColumnName: "key", Primary: 1, IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_STRING},
+		{ColumnName: "value", IndexType: sdbc.IT_NONE, ColumnType: sdbc.CT_STRING},
+		{ColumnName: "n", IndexType: sdbc.IT_BPLUSTREE, ColumnType: sdbc.CT_INTEGER},
+	}
+	_, err = dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_CREATE_TABLE, Owner: config.Owner, Database: config.Database, Table: config.Table, Columns: columns})
+	return err
+}
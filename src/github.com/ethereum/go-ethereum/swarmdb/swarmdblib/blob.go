@@ -0,0 +1,199 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	"encoding/base64"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"io"
+)
+
+// BlobWriter uploads a blob into a string column of a row while it is written, one wire.BLOB_PART_SIZE part per
+// request; Close stores the blob in the row.  Nothing is visible in the table before Close.
+type BlobWriter struct {
+	dbc      *SWARMDBConnection
+	req      sdbc.RequestOption // of the parts
+	key      interface{}
+	column   string
+	buf      []byte
+	parts    []interface{}
+	manifest string
+	closed   bool
+}
+
+// NewBlobWriter returns a writer of the blob of column of the row with the given primary key
+func (dbc *SWARMDBConnection) NewBlobWriter(owner string, database string, table string, key interface{}, column string) *BlobWriter {
+	return &BlobWriter{dbc: dbc, req: sdbc.RequestOption{RequestType: wire.RT_PUT_BLOB_PART, Owner: owner, Database: database, Table: table}, key: key, column: column}
+}
+
+// Write implements io.Writer
+func (w *BlobWriter) Write(p []byte) (n int, err error) {
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= wire.BLOB_PART_SIZE {
+		if err = w.putPart(w.buf[:wire.BLOB_PART_SIZE]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[wire.BLOB_PART_SIZE:]
+	}
+	return len(p), nil
+}
+
+func (w *BlobWriter) putPart(data []byte) (err error) {
+	req := w.req
+	req.Rows = []sdbc.Row{{"data": base64.StdEncoding.EncodeToString(data)}}
+	resp, err := w.dbc.ProcessRequestResponseCommand(req)
+	if err != nil {
+		return err
+	}
+	if len(resp.Data) != 1 {
+		return &wire.Error{Code: wire.ErrInternal, Number: 506, Message: "Invalid Blob: the server sent no part hash"}
+	}
+	w.parts = append(w.parts, resp.Data[0]["part"])
+	return nil
+}
+
+// Close uploads what is left of the blob and stores the blob in the row
+func (w *BlobWriter) Close() (err error) {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if len(w.buf) > 0 || len(w.parts) == 0 {
+		if err = w.putPart(w.buf); err != nil {
+			return err
+		}
+		w.buf = nil
+	}
+	req := w.req
+	req.RequestType = wire.RT_PUT_BLOB
+	req.Key = w.key
+	req.Rows = []sdbc.Row{{"column": w.column, "parts": w.parts}}
+	resp, err := w.dbc.ProcessRequestResponseCommand(req)
+	if err != nil {
+		return err
+	}
+	if len(resp.Data) == 1 {
+		w.manifest, _ = resp.Data[0]["manifest"].(string)
+	}
+	return nil
+}
+
+// Manifest returns the hash of the manifest of the blob, in hex, once Close stored it
+func (w *BlobWriter) Manifest() string {
+	return w.manifest
+}
+
+// PutBlob uploads everything read from r as the blob of column of the row with the given primary key, creating the
+// row if needed, and returns the hash of its manifest in hex
+func (dbc *SWARMDBConnection) PutBlob(owner string, database string, table string, key interface{}, column string, r io.Reader) (manifest string, err error) {
+	w := dbc.NewBlobWriter(owner, database, table, key, column)
+	if _, err = io.Copy(w, r); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+	return w.Manifest(), nil
+}
+
+// BlobReader reads the blob of a row, downloading up to wire.BLOB_PART_SIZE bytes per request as it is read
+type BlobReader struct {
+	dbc      *SWARMDBConnection
+	req      sdbc.RequestOption
+	column   string
+	manifest string
+	size     int64
+	off      int64
+	buf      []byte // the bytes of the blob from bufOff on, downloaded last
+	bufOff   int64
+}
+
+// GetBlob opens the blob of column of the row with the given primary key; ok is false when the row has none
+func (dbc *SWARMDBConnection) GetBlob(owner string, database string, table string, key interface{}, column string) (r *BlobReader, ok bool, err error) {
+	r = &BlobReader{dbc: dbc, req: sdbc.RequestOption{RequestType: wire.RT_GET_BLOB, Owner: owner, Database: database, Table: table, Key: key}, column: column}
+	if ok, err = r.fetch(0); err != nil || !ok {
+		return nil, false, err
+	}
+	return r, true, nil
+}
+
+// fetch downloads the part of the blob from off on
+func (r *BlobReader) fetch(off int64) (ok bool, err error) {
+	req := r.req
+	req.Rows = []sdbc.Row{{"column": r.column, "offset": off, "length": wire.BLOB_PART_SIZE}}
+	resp, err := r.dbc.ProcessRequestResponseCommand(req)
+	if err != nil || len(resp.Data) == 0 {
+		return false, err
+	}
+	row := resp.Data[0]
+	data, err := base64.StdEncoding.DecodeString(fmt.Sprintf("%v", row["data"]))
+	if err != nil {
+		return false, err
+	}
+	size, _ := row["size"].(float64)
+	r.manifest, _ = row["manifest"].(string)
+	r.size, r.buf, r.bufOff = int64(size), data, off
+	return true, nil
+}
+
+// Read implements io.Reader
+func (r *BlobReader) Read(p []byte) (n int, err error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if r.off < r.bufOff || r.off >= r.bufOff+int64(len(r.buf)) {
+		ok, err := r.fetch(r.off)
+		if err != nil {
+			return 0, err
+		}
+		if !ok || len(r.buf) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+	}
+	n = copy(p, r.buf[r.off-r.bufOff:])
+	r.off += int64(n)
+	return n, nil
+}
+
+// Seek implements io.Seeker
+func (r *BlobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return r.off, &wire.Error{Code: wire.ErrBadRequest, Number: 418, Message: fmt.Sprintf("Request Invalid: negative blob offset %d", offset)}
+	}
+	r.off = offset
+	return offset, nil
+}
+
+// Size returns the size of the blob in bytes
+func (r *BlobReader) Size() int64 {
+	return r.size
+}
+
+// Manifest returns the hash of the manifest of the blob in hex
+func (r *BlobReader) Manifest() string {
+	return r.manifest
+}
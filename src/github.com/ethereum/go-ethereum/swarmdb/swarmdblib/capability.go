@@ -0,0 +1,52 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/crypto"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"strings"
+	"time"
+)
+
+// MintCapability signs c with privateKey (hex), the key of c.Owner, and returns the token to hand to a third party.
+// The third party sets it as the Capability of its connection, on which requests then run as c.Owner within the
+// scope of c without authenticating.  An empty c.Owner is filled in from privateKey.
+func MintCapability(privateKey string, c wire.Capability) (token string, err error) {
+	secretKey, err := crypto.HexToECDSA(strings.TrimPrefix(privateKey, "0x"))
+	if err != nil {
+		return token, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdblib:MintCapability] HexToECDSA %s", err.Error()), ErrorCode: 455, ErrorMessage: "Keymanager Unable to Sign Message"}
+	}
+	if len(c.Owner) == 0 {
+		c.Owner = strings.ToLower(crypto.PubkeyToAddress(secretKey.PublicKey).Hex())
+	}
+	if c.ExpiresMs == 0 {
+		c.ExpiresMs = time.Now().Add(CAPABILITY_TTL).UnixNano() / int64(time.Millisecond)
+	}
+	payload := c.SigningPayload()
+	msg := fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(payload), payload)
+	sig, err := crypto.Sign(crypto.Keccak256([]byte(msg)), secretKey)
+	if err != nil {
+		return token, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdblib:MintCapability] Sign %s", err.Error()), ErrorCode: 455, ErrorMessage: "Keymanager Unable to Sign Message"}
+	}
+	c.Signature = fmt.Sprintf("%x", sig)
+	return wire.EncodeCapability(c), nil
+}
+
+// CAPABILITY_TTL is the lifetime of capabilities minted without an expiry
+const CAPABILITY_TTL = 24 * time.Hour
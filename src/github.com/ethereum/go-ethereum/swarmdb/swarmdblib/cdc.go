@@ -0,0 +1,40 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+)
+
+// Changes reads up to limit records of the change stream of the table that follow the position after, oldest
+// first, and returns the position to pass to the next call: after itself when there are no new records.  Each
+// record is a row {"position", "op", "key", "before", "after", "roothash", "version", "time"}.  The server keeps
+// the stream only with config.ChangeStream set.
+func (dbc *SWARMDBConnection) Changes(owner string, database string, table string, after string, limit int) (changes []sdbc.Row, next string, err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_CHANGES, Owner: owner, Database: database, Table: table, Rows: []sdbc.Row{{"after": after, "limit": limit}}}
+	resp, err := dbc.ProcessRequestResponseCommand(req)
+	if err != nil {
+		return nil, after, err
+	}
+	next = after
+	if n := len(resp.Data); n > 0 {
+		if position, ok := resp.Data[n-1]["position"].(string); ok {
+			next = position
+		}
+	}
+	return resp.Data, next, nil
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	"context"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// GetCtx reads the row with the given primary key; a missing row is reported as ErrNotFound
+func (dbc *SWARMDBConnection) GetCtx(ctx context.Context, owner string, database string, table string, key interface{}) (resp sdbc.SWARMDBResponse, err error) {
+	resp, err = dbc.ProcessRequestCtx(ctx, sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: table, Key: key})
+	if err == nil && len(resp.Data) == 0 {
+		return resp, &wire.Error{Code: wire.ErrNotFound, Number: 498, Message: fmt.Sprintf("Row Not Found: key [%v] in table [%s]", key, table)}
+	}
+	return resp, err
+}
+
+// GetMultiCtx reads the rows of keys in one round trip; resp.Data holds the rows found, in the order of the keys
+func (dbc *SWARMDBConnection) GetMultiCtx(ctx context.Context, owner string, database string, table string, keys []interface{}) (resp sdbc.SWARMDBResponse, err error) {
+	return dbc.ProcessRequestCtx(ctx, sdbc.RequestOption{RequestType: wire.RT_GET_MULTI, Owner: owner, Database: database, Table: table, Key: keys})
+}
+
+// PutCtx inserts or replaces rows
+func (dbc *SWARMDBConnection) PutCtx(ctx context.Context, owner string, database string, table string, rows []sdbc.Row) (resp sdbc.SWARMDBResponse, err error) {
+	return dbc.ProcessRequestCtx(ctx, sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: table, Rows: rows})
+}
+
+// IncrementCtx adds deltas (column name to number) to the counter columns of the row with the given primary key
+// on the server, so concurrent increments are never lost; resp.Data holds the updated row
+func (dbc *SWARMDBConnection) IncrementCtx(ctx context.Context, owner string, database string, table string, key interface{}, deltas sdbc.Row) (resp sdbc.SWARMDBResponse, err error) {
+	return dbc.ProcessRequestCtx(ctx, sdbc.RequestOption{RequestType: wire.RT_INCREMENT, Owner: owner, Database: database, Table: table, Key: key, Rows: []sdbc.Row{deltas}})
+}
+
+// QueryCtx runs a SQL query
+func (dbc *SWARMDBConnection) QueryCtx(ctx context.Context, owner string, database string, query string) (resp sdbc.SWARMDBResponse, err error) {
+	return dbc.ProcessRequestCtx(ctx, sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, RawQuery: query})
+}
+
+// ImportCSVCtx loads CSV with a header line into a table in one server side transaction; mapping renames CSV
+// headers to column names and may be nil.  resp.AffectedRowCount is the number of rows imported, resp.Data holds a
+// {"record", "error"} row for every record the server rejected
+func (dbc *SWARMDBConnection) ImportCSVCtx(ctx context.Context, owner string, database string, table string, csv io.Reader, mapping map[string]string) (resp sdbc.SWARMDBResponse, err error) {
+	data, err := ioutil.ReadAll(csv)
+	if err != nil {
+		return resp, err
+	}
+	req := sdbc.RequestOption{RequestType: wire.RT_IMPORT_CSV, Owner: owner, Database: database, Table: table, RawQuery: string(data)}
+	if len(mapping) > 0 {
+		row := sdbc.NewRow()
+		for header, column := range mapping {
+			row[header] = column
+		}
+		req.Rows = []sdbc.Row{row}
+	}
+	return dbc.ProcessRequestCtx(ctx, req)
+}
+
+func (dbc *SWARMDBConnection) Get(owner string, database string, table string, key interface{}) (resp sdbc.SWARMDBResponse, err error) {
+	return dbc.GetCtx(context.Background(), owner, database, table, key)
+}
+
+func (dbc *SWARMDBConnection) GetMulti(owner string, database string, table string, keys []interface{}) (resp sdbc.SWARMDBResponse, err error) {
+	return dbc.GetMultiCtx(context.Background(), owner, database, table, keys)
+}
+
+func (dbc *SWARMDBConnection) Put(owner string, database string, table string, rows []sdbc.Row) (resp sdbc.SWARMDBResponse, err error) {
+	return dbc.PutCtx(context.Background(), owner, database, table, rows)
+}
+
+func (dbc *SWARMDBConnection) Increment(owner string, database string, table string, key interface{}, deltas sdbc.Row) (resp sdbc.SWARMDBResponse, err error) {
+	return dbc.IncrementCtx(context.Background(), owner, database, table, key, deltas)
+}
+
+func (dbc *SWARMDBConnection) Query(owner string, database string, query string) (resp sdbc.SWARMDBResponse, err error) {
+	return dbc.QueryCtx(context.Background(), owner, database, query)
+}
+
+func (dbc *SWARMDBConnection) ImportCSV(owner string, database string, table string, csv io.Reader, mapping map[string]string) (resp sdbc.SWARMDBResponse, err error) {
+	return dbc.ImportCSVCtx(context.Background(), owner, database, table, csv, mapping)
+}
+
+// roundTripCtx is roundTrip interrupted by ctx: the connection deadline follows ctx and cancellation expires it at once
+func (dbc *SWARMDBConnection) roundTripCtx(ctx context.Context, out []byte) (resp wire.Response, err error) {
+	if ctx.Done() == nil {
+		return dbc.roundTrip(out)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		dbc.connection.SetDeadline(deadline)
+	}
+	stop := make(chan struct{})
+	var watcher sync.WaitGroup
+	watcher.Add(1)
+	go func() {
+		defer watcher.Done()
+		select {
+		case <-ctx.Done():
+			dbc.connection.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	resp, err = dbc.roundTrip(out)
+	close(stop)
+	watcher.Wait()
+	ctxErr := ctx.Err()
+	if deadline, ok := ctx.Deadline(); ok && ctxErr == nil && !time.Now().Before(deadline) {
+		// the connection deadline can fire just before the context timer
+		ctxErr = context.DeadlineExceeded
+	}
+	if ctxErr != nil && err != nil && resp.Status != wire.STATUS_ERROR {
+		dbc.Close()
+		return resp, contextError(ctxErr)
+	}
+	dbc.connection.SetDeadline(time.Time{})
+	return resp, err
+}
+
+// contextError converts a context error into the wire error clients switch on
+func contextError(err error) error {
+	if err == context.DeadlineExceeded {
+		return &wire.Error{Code: wire.ErrTimeout, Number: 496, Message: fmt.Sprintf("Request Deadline Exceeded: %s", err.Error())}
+	}
+	return &wire.Error{Code: wire.ErrAborted, Number: 497, Message: fmt.Sprintf("Request Canceled: %s", err.Error())}
+}
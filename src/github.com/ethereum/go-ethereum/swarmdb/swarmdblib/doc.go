@@ -0,0 +1,53 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+)
+
+// InsertDoc inserts doc, a JSON object of any shape, into the named collection of owner, which the first insert
+// creates, and returns its "_id": the one of doc, or one the server makes up
+func (dbc *SWARMDBConnection) InsertDoc(owner string, collection string, doc map[string]interface{}) (id string, err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_INSERT_DOC, Owner: owner, Table: collection, Rows: []sdbc.Row{doc}}
+	resp, err := dbc.ProcessRequestResponseCommand(req)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Data) > 0 {
+		id, _ = resp.Data[0]["_id"].(string)
+	}
+	return id, nil
+}
+
+// FindDocs returns up to limit documents of the named collection of owner matching filterJSON, e.g.
+// {"address.city": "Oakland", "age": {"$gte": 21}}; the server indexes the paths filtered on often
+func (dbc *SWARMDBConnection) FindDocs(owner string, collection string, filterJSON string, limit int) (docs []sdbc.Row, err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_FIND_DOCS, Owner: owner, Table: collection, RawQuery: filterJSON, Rows: []sdbc.Row{{"limit": limit}}}
+	resp, err := dbc.ProcessRequestResponseCommand(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// CreateDocIndex indexes the documents of the named collection of owner by their value at the dotted path
+func (dbc *SWARMDBConnection) CreateDocIndex(owner string, collection string, path string) (err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_CREATE_DOC_INDEX, Owner: owner, Table: collection, Key: path}
+	_, err = dbc.ProcessRequestResponseCommand(req)
+	return err
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+)
+
+// EncryptColumns encrypts the given columns of the table, and no others, in the rows written from now on, and
+// returns the encrypted columns.  The values of encrypted columns are sealed with the keys of the writer before they
+// are stored and are not indexed.  A nil columns only lists the encrypted columns; an empty one encrypts none.
+func (dbc *SWARMDBConnection) EncryptColumns(owner string, database string, table string, columns []string) (encrypted []string, err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_ENCRYPT_COLUMNS, Owner: owner, Database: database, Table: table}
+	if columns != nil {
+		req.Rows = []sdbc.Row{{"columns": columns}}
+	}
+	resp, err := dbc.ProcessRequestResponseCommand(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range resp.Data {
+		if name, ok := row["column"].(string); ok {
+			encrypted = append(encrypted, name)
+		}
+	}
+	return encrypted, nil
+}
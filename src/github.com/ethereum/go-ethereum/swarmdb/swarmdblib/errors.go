@@ -0,0 +1,42 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+)
+
+// Errors returned by the client are *swarmdbwire.Error values; they match these sentinels with errors.Is by their
+// stable ErrorCode, e.g.
+//
+//	if _, err := dbc.Get(owner, database, table, key); errors.Is(err, swarmdblib.ErrNotFound) { ... }
+var (
+	ErrNoSuchTable      = &wire.Error{Code: wire.ErrNoSuchTable, Message: "no such table"}
+	ErrNoSuchDatabase   = &wire.Error{Code: wire.ErrNoSuchDatabase, Message: "no such database"}
+	ErrNoSuchColumn     = &wire.Error{Code: wire.ErrNoSuchColumn, Message: "no such column"}
+	ErrDuplicateKey     = &wire.Error{Code: wire.ErrDuplicateKey, Message: "duplicate key"}
+	ErrNotFound         = &wire.Error{Code: wire.ErrNotFound, Message: "row not found"}
+	ErrBadRequest       = &wire.Error{Code: wire.ErrBadRequest, Message: "bad request"}
+	ErrUnauthorized     = &wire.Error{Code: wire.ErrUnauthorized, Message: "authentication required"}
+	ErrPermissionDenied = &wire.Error{Code: wire.ErrAccessDenied, Message: "permission denied"}
+	ErrThrottled        = &wire.Error{Code: wire.ErrThrottled, Message: "throttled"}
+	ErrTimeout          = &wire.Error{Code: wire.ErrTimeout, Message: "timeout"}
+	ErrUnavailable      = &wire.Error{Code: wire.ErrUnavailable, Message: "server unavailable"}
+	ErrAborted          = &wire.Error{Code: wire.ErrAborted, Message: "aborted"}
+	ErrConflict         = &wire.Error{Code: wire.ErrConflict, Message: "conflict"}
+	ErrBudgetExceeded   = &wire.Error{Code: wire.ErrBudgetExceeded, Message: "query budget exceeded"}
+	ErrTooLarge         = &wire.Error{Code: wire.ErrTooLarge, Message: "row or key too large"}
+)
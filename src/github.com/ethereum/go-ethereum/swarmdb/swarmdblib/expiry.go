@@ -0,0 +1,42 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+)
+
+// SetExpiryColumn makes the rows of the table expire at the time, in unix milliseconds, held by the given integer or
+// float column, or never when column is "".  Expired rows are no longer read and are purged by the server.
+func (dbc *SWARMDBConnection) SetExpiryColumn(owner string, database string, table string, column string) (err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_EXPIRY_COLUMN, Owner: owner, Database: database, Table: table, Rows: []sdbc.Row{{"column": column}}}
+	_, err = dbc.ProcessRequestResponseCommand(req)
+	return err
+}
+
+// ExpiryColumn returns the column holding the expiry of the rows of the table, or "" when they never expire
+func (dbc *SWARMDBConnection) ExpiryColumn(owner string, database string, table string) (column string, err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_EXPIRY_COLUMN, Owner: owner, Database: database, Table: table}
+	resp, err := dbc.ProcessRequestResponseCommand(req)
+	if err != nil {
+		return "", err
+	}
+	for _, row := range resp.Data {
+		column, _ = row["column"].(string)
+	}
+	return column, nil
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// EXPORT_PAGE is the number of rows ExportTable fetches per round trip
+const EXPORT_PAGE = 500
+
+// ExportTable writes every row of a table to w in primary key order, as JSON Lines ("jsonl") or as CSV ("csv", a
+// header line of the column names, primary key first) and returns the number of rows written.  Rows are fetched a
+// page at a time and the next page is only requested once w has taken the last one.
+func (dbc *SWARMDBConnection) ExportTable(ctx context.Context, owner string, database string, table string, format string, w io.Writer) (n int, err error) {
+	if format != "jsonl" && format != "csv" {
+		return 0, &wire.Error{Code: wire.ErrBadRequest, Number: 418, Message: fmt.Sprintf("Request Invalid: unknown export format [%s], use jsonl or csv", format)}
+	}
+	buf := bufio.NewWriter(w)
+	var cw *csv.Writer
+	var columns []string
+	if format == "csv" {
+		if columns, err = dbc.columnOrder(ctx, owner, database, table); err != nil {
+			return 0, err
+		}
+		cw = csv.NewWriter(buf)
+		if err = cw.Write(columns); err != nil {
+			return 0, err
+		}
+	}
+	var werr error
+	err = dbc.ScanRange(ctx, owner, database, table, nil, nil, EXPORT_PAGE, 1, func(row sdbc.Row) bool {
+		if cw != nil {
+			record := make([]string, len(columns))
+			for i, name := range columns {
+				record[i] = exportCell(row[name])
+			}
+			werr = cw.Write(record)
+		} else {
+			var line []byte
+			if line, werr = json.Marshal(row); werr == nil {
+				line = append(line, '\n')
+				_, werr = buf.Write(line)
+			}
+		}
+		if werr != nil {
+			return false
+		}
+		n++
+		return true
+	})
+	if err != nil {
+		return n, err
+	}
+	if werr != nil {
+		return n, werr
+	}
+	if cw != nil {
+		cw.Flush()
+		if err = cw.Error(); err != nil {
+			return n, err
+		}
+	}
+	return n, buf.Flush()
+}
+
+// columnOrder lists the columns of a table, primary key first and the others by name, as the server exports them
+func (dbc *SWARMDBConnection) columnOrder(ctx context.Context, owner string, database string, table string) (columns []string, err error) {
+	resp, err := dbc.ProcessRequestCtx(ctx, sdbc.RequestOption{RequestType: sdbc.RT_DESCRIBE_TABLE, Owner: owner, Database: database, Table: table})
+	if err != nil {
+		return nil, err
+	}
+	var primary string
+	for _, c := range resp.Data {
+		name, _ := c["ColumnName"].(string)
+		if p, _ := c["Primary"].(float64); p > 0 {
+			primary = name
+		} else {
+			columns = append(columns, name)
+		}
+	}
+	sort.Strings(columns)
+	if len(primary) > 0 {
+		columns = append([]string{primary}, columns...)
+	}
+	return columns, nil
+}
+
+// exportCell formats a cell for CSV; JSON numbers without a fraction are written as integers
+func exportCell(cell interface{}) string {
+	switch v := cell.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", cell)
+}
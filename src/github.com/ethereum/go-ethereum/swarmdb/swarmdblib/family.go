@@ -0,0 +1,45 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+)
+
+// ColumnFamilies puts the given columns of the table in column families 1 to 15, and all others in family 0, for
+// the rows written from now on, and returns the families of the columns outside family 0.  The cells of each family
+// are stored in a chunk of their own, so a SELECT of a few columns by primary key only fetches their families.  A
+// nil families only lists them.
+func (dbc *SWARMDBConnection) ColumnFamilies(owner string, database string, table string, families map[string]int) (out map[string]int, err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_COLUMN_FAMILIES, Owner: owner, Database: database, Table: table}
+	if families != nil {
+		req.Rows = []sdbc.Row{{"families": families}}
+	}
+	resp, err := dbc.ProcessRequestResponseCommand(req)
+	if err != nil {
+		return nil, err
+	}
+	out = make(map[string]int)
+	for _, row := range resp.Data {
+		name, ok := row["column"].(string)
+		f, ok2 := row["family"].(float64)
+		if ok && ok2 {
+			out[name] = int(f)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+)
+
+// PutEdge stores the edge labelled label from src to dst, with the properties props, in the named graph of owner,
+// which the first edge creates; putting an edge again replaces its properties
+func (dbc *SWARMDBConnection) PutEdge(owner string, graph string, src string, label string, dst string, props map[string]interface{}) (err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_PUT_EDGE, Owner: owner, Table: graph, Rows: []sdbc.Row{{"src": src, "dst": dst, "label": label, "props": props}}}
+	_, err = dbc.ProcessRequestResponseCommand(req)
+	return err
+}
+
+// DeleteEdge removes the edge labelled label from src to dst of the named graph of owner and reports whether it
+// was there
+func (dbc *SWARMDBConnection) DeleteEdge(owner string, graph string, src string, label string, dst string) (ok bool, err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_DELETE_EDGE, Owner: owner, Table: graph, Rows: []sdbc.Row{{"src": src, "dst": dst, "label": label}}}
+	resp, err := dbc.ProcessRequestResponseCommand(req)
+	if err != nil {
+		return false, err
+	}
+	return resp.AffectedRowCount > 0, nil
+}
+
+// Traverse walks the named graph of owner breadth first from start, up to depth edges away, following the edges
+// in direction ("out", "in" or "both") with any of labels (any label when empty) whose properties match where, a
+// filter as in FindDocs, and returns a {"src", "dst", "label", "props", "depth"} row per edge followed
+func (dbc *SWARMDBConnection) Traverse(owner string, graph string, start string, depth int, direction string, labels []string, where map[string]interface{}) (edges []sdbc.Row, err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_TRAVERSE, Owner: owner, Table: graph, Key: start, Rows: []sdbc.Row{{"depth": depth, "direction": direction, "labels": labels, "where": where}}}
+	resp, err := dbc.ProcessRequestResponseCommand(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
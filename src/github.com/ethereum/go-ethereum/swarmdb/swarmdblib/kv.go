@@ -0,0 +1,68 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+)
+
+// CreateKeyspace creates the named, empty keyspace of owner: a schemaless map of keys to values without a table,
+// indexed by a B+tree (sdbc.IT_BPLUSTREE, iterated in key order) or a HashDB (sdbc.IT_HASHTREE)
+func (dbc *SWARMDBConnection) CreateKeyspace(owner string, name string, indexType sdbc.IndexType, encrypted int) (err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_CREATE_KEYSPACE, Owner: owner, Table: name, Encrypted: encrypted, Columns: []sdbc.Column{{IndexType: indexType}}}
+	_, err = dbc.ProcessRequestResponseCommand(req)
+	return err
+}
+
+// PutKV stores value under key in the named keyspace of owner
+func (dbc *SWARMDBConnection) PutKV(owner string, name string, key string, value string) (err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_PUT_KV, Owner: owner, Table: name, Key: key, Rows: []sdbc.Row{{"value": value}}}
+	_, err = dbc.ProcessRequestResponseCommand(req)
+	return err
+}
+
+// GetKV returns the value stored under key in the named keyspace of owner, and whether there is one
+func (dbc *SWARMDBConnection) GetKV(owner string, name string, key string) (value string, ok bool, err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_GET_KV, Owner: owner, Table: name, Key: key}
+	resp, err := dbc.ProcessRequestResponseCommand(req)
+	if err != nil || len(resp.Data) == 0 {
+		return "", false, err
+	}
+	value, ok = resp.Data[0]["value"].(string)
+	return value, ok, nil
+}
+
+// DeleteKV removes key from the named keyspace of owner and reports whether it was there
+func (dbc *SWARMDBConnection) DeleteKV(owner string, name string, key string) (ok bool, err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_DELETE_KV, Owner: owner, Table: name, Key: key}
+	resp, err := dbc.ProcessRequestResponseCommand(req)
+	if err != nil {
+		return false, err
+	}
+	return resp.AffectedRowCount > 0, nil
+}
+
+// IterateKV returns up to limit {"key", "value"} pairs of the named keyspace of owner from start on, in key order in
+// a B+tree keyspace; pass the key of the last pair, dropping it from the result, to read the next page
+func (dbc *SWARMDBConnection) IterateKV(owner string, name string, start string, limit int) (pairs []sdbc.Row, err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_ITERATE_KV, Owner: owner, Table: name, Rows: []sdbc.Row{{"start": start, "limit": limit}}}
+	resp, err := dbc.ProcessRequestResponseCommand(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
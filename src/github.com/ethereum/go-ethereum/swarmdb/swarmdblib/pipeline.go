@@ -0,0 +1,83 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	"encoding/json"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"strconv"
+)
+
+// Pipeline sends reqs one after the other without waiting for the responses in between, and returns a response and
+// an error (nil or *swarmdbwire.Error) per request.  Unlike ProcessBatch, every request is a request of its own: the
+// server executes them in order, each seeing the effects of the ones before, and the pipe stays full while it does.
+// err is only set when the connection failed, which leaves it closed.
+func (dbc *SWARMDBConnection) Pipeline(reqs []sdbc.RequestOption) (resps []sdbc.SWARMDBResponse, errs []error, err error) {
+	if dbc.Version < 2 {
+		return nil, nil, &wire.Error{Code: wire.ErrBadRequest, Number: 418, Message: fmt.Sprintf("Request Invalid: pipelining needs protocol version 2, the server speaks %d", dbc.Version)}
+	}
+	requestIDs := make([]string, len(reqs))
+	msgs := make([][]byte, len(reqs))
+	for i, req := range reqs {
+		dbc.requestID++
+		requestIDs[i] = strconv.FormatUint(dbc.requestID, 10)
+		if msgs[i], err = json.Marshal(wire.Request{RequestID: requestIDs[i], Capability: dbc.Capability, RequestOption: req}); err != nil {
+			return nil, nil, &wire.Error{RequestID: requestIDs[i], Code: wire.ErrBadRequest, Number: 432, Message: fmt.Sprintf("Unable to Parse Request: %s", err.Error())}
+		}
+	}
+
+	// requests are written while the responses are read, so that neither side waits on a full pipe
+	written := make(chan error, 1)
+	go func() {
+		for _, msg := range msgs {
+			if err := wire.WriteMessage(dbc.writer, dbc.compression, msg); err != nil {
+				dbc.connection.Close()
+				written <- err
+				return
+			}
+		}
+		written <- nil
+	}()
+	fail := func(err error) ([]sdbc.SWARMDBResponse, []error, error) {
+		dbc.Close()
+		<-written
+		return nil, nil, err
+	}
+
+	resps = make([]sdbc.SWARMDBResponse, len(reqs))
+	errs = make([]error, len(reqs))
+	for i := range reqs {
+		line, err := wire.ReadMessage(dbc.reader, dbc.compression)
+		if err != nil {
+			return fail(&wire.Error{Code: wire.ErrUnavailable, Number: 488, Message: fmt.Sprintf("Unable to connect to SWARMDB server: %s", err.Error())})
+		}
+		envelope, err := wire.DecodeResponse(dbc.Version, line)
+		if err != nil {
+			return fail(&wire.Error{Code: wire.ErrInternal, Number: 432, Message: fmt.Sprintf("Unable to Parse Response: %s", err.Error())})
+		}
+		if envelope.RequestID != requestIDs[i] {
+			return fail(&wire.Error{RequestID: requestIDs[i], Code: wire.ErrInternal, Number: 432, Message: fmt.Sprintf("Response for request [%s] received for request [%s]", envelope.RequestID, requestIDs[i])})
+		}
+		resps[i], errs[i] = envelope.SWARMDBResponse(), envelope.Err()
+	}
+	if err = <-written; err != nil {
+		dbc.broken = true
+		return nil, nil, &wire.Error{Code: wire.ErrUnavailable, Number: 488, Message: fmt.Sprintf("Unable to connect to SWARMDB server: %s", err.Error())}
+	}
+	return resps, errs, nil
+}
@@ -0,0 +1,340 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"sync"
+	"time"
+)
+
+const (
+	POOL_MAX_OPEN              = 16
+	POOL_MAX_IDLE              = 4
+	POOL_HEALTH_CHECK          = 30 * time.Second
+	POOL_PING_TIMEOUT          = 5 * time.Second
+	POOL_RECONNECT_ATTEMPTS    = 5
+	POOL_RECONNECT_MIN_BACKOFF = 100 * time.Millisecond
+	POOL_RECONNECT_MAX_BACKOFF = 5 * time.Second
+	POOL_RETRY_ATTEMPTS        = 3
+	POOL_RETRY_MIN_BACKOFF     = 50 * time.Millisecond
+	POOL_RETRY_MAX_BACKOFF     = time.Second
+)
+
+// PoolConfig describes the server and how connections to it are opened; zero values take the POOL_* defaults
+type PoolConfig struct {
+	IP          string
+	Port        int
+	TLS         *TLSOptions    // nil for plain TCP
+	PrivateKey  string         // when set, every connection authenticates with it
+	Compression []string       // when set, every connection negotiates one of these
+	Embedded    EmbeddedServer // when set, connections are opened in-process and IP, Port and TLS are ignored
+
+	DialTimeout    time.Duration // see ConnectionConfig
+	RequestTimeout time.Duration
+
+	MaxOpen           int           // connections open at once, in use or idle
+	MaxIdle           int           // idle connections kept for reuse
+	HealthCheck       time.Duration // idle connections unused for longer are pinged before reuse
+	KeepAlive         time.Duration // when set, idle connections are pinged this often and dead ones replaced
+	PingTimeout       time.Duration // a connection that does not answer a ping within this is dead
+	ReconnectAttempts int           // dial attempts before Get gives up
+	MinBackoff        time.Duration // wait after the first failed dial, doubled after each further failure
+	MaxBackoff        time.Duration
+	Retry             RetryPolicy
+}
+
+// RetryPolicy controls how Pool.ProcessRequestCtx retries requests that failed transiently: the connection broke
+// before the response arrived, or the server was unavailable or throttled the request.  Writes are retried with an
+// idempotency key, so the server applies them once (servers predating idempotency keys ignore them).
+type RetryPolicy struct {
+	MaxAttempts int           // attempts in total, 1 disables retries
+	MinBackoff  time.Duration // wait before the first retry, doubled before each further one
+	MaxBackoff  time.Duration
+}
+
+// Pool shares connections to one server between goroutines.  A SWARMDBConnection is not safe for concurrent use;
+// the pool hands each one to a single caller at a time, replaces connections broken by transport errors and redials
+// with exponential backoff.
+type Pool struct {
+	config PoolConfig
+
+	mu     sync.Mutex
+	idle   []*pooledConnection
+	open   int
+	closed bool
+	freed  chan struct{} // signalled when a connection is returned or closed
+	quit   chan struct{} // stops the keep-alive loop
+}
+
+type pooledConnection struct {
+	dbc      *SWARMDBConnection
+	lastUsed time.Time
+}
+
+func NewPool(config PoolConfig) *Pool {
+	if config.MaxOpen <= 0 {
+		config.MaxOpen = POOL_MAX_OPEN
+	}
+	if config.MaxIdle <= 0 {
+		config.MaxIdle = POOL_MAX_IDLE
+	}
+	if config.MaxIdle > config.MaxOpen {
+		config.MaxIdle = config.MaxOpen
+	}
+	if config.HealthCheck <= 0 {
+		config.HealthCheck = POOL_HEALTH_CHECK
+	}
+	if config.PingTimeout <= 0 {
+		config.PingTimeout = POOL_PING_TIMEOUT
+	}
+	if config.ReconnectAttempts <= 0 {
+		config.ReconnectAttempts = POOL_RECONNECT_ATTEMPTS
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = POOL_RECONNECT_MIN_BACKOFF
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = POOL_RECONNECT_MAX_BACKOFF
+	}
+	if config.Retry.MaxAttempts <= 0 {
+		config.Retry.MaxAttempts = POOL_RETRY_ATTEMPTS
+	}
+	if config.Retry.MinBackoff <= 0 {
+		config.Retry.MinBackoff = POOL_RETRY_MIN_BACKOFF
+	}
+	if config.Retry.MaxBackoff <= 0 {
+		config.Retry.MaxBackoff = POOL_RETRY_MAX_BACKOFF
+	}
+	p := &Pool{config: config, freed: make(chan struct{}, 1), quit: make(chan struct{})}
+	if config.KeepAlive > 0 {
+		go p.keepAlive()
+	}
+	return p
+}
+
+// Get returns a healthy connection, reusing an idle one or dialing a new one; it waits while MaxOpen are in use.
+// The connection must be handed back with Put.
+func (p *Pool) Get(ctx context.Context) (dbc *SWARMDBConnection, err error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, &wire.Error{Code: wire.ErrUnavailable, Number: 488, Message: "Unable to connect to SWARMDB server: pool closed"}
+		}
+		if n := len(p.idle); n > 0 {
+			pc := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			p.mu.Unlock()
+			if time.Since(pc.lastUsed) < p.config.HealthCheck || p.ping(pc.dbc) == nil {
+				return pc.dbc, nil
+			}
+			p.discard(pc.dbc)
+			continue
+		}
+		if p.open < p.config.MaxOpen {
+			p.open++
+			p.mu.Unlock()
+			dbc, err = p.dial(ctx)
+			if err != nil {
+				p.mu.Lock()
+				p.open--
+				p.mu.Unlock()
+				p.signal()
+				return nil, err
+			}
+			return dbc, nil
+		}
+		p.mu.Unlock()
+		select {
+		case <-p.freed:
+		case <-ctx.Done():
+			return nil, contextError(ctx.Err())
+		}
+	}
+}
+
+// Put returns dbc to the pool; broken connections and those beyond MaxIdle are closed
+func (p *Pool) Put(dbc *SWARMDBConnection) {
+	p.mu.Lock()
+	if dbc.broken || p.closed || len(p.idle) >= p.config.MaxIdle {
+		p.mu.Unlock()
+		p.discard(dbc)
+		return
+	}
+	p.idle = append(p.idle, &pooledConnection{dbc: dbc, lastUsed: time.Now()})
+	p.mu.Unlock()
+	p.signal()
+}
+
+// ProcessRequestCtx runs req on a pooled connection, retrying transient failures as configured by PoolConfig.Retry.
+// Writes carry one idempotency key over all attempts.
+func (p *Pool) ProcessRequestCtx(ctx context.Context, req sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	idempotencyKey := ""
+	if !isReadRequest(req.RequestType) {
+		idempotencyKey = NewIdempotencyKey()
+	}
+	backoff := p.config.Retry.MinBackoff
+	for attempt := 1; ; attempt++ {
+		dbc, err := p.Get(ctx)
+		if err != nil {
+			return resp, err
+		}
+		resp, err = dbc.ProcessIdempotentRequest(ctx, req, idempotencyKey)
+		broken := dbc.broken
+		p.Put(dbc)
+		if err == nil || attempt >= p.config.Retry.MaxAttempts || ctx.Err() != nil || !isTransient(err, broken) {
+			return resp, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return resp, contextError(ctx.Err())
+		}
+		if backoff *= 2; backoff > p.config.Retry.MaxBackoff {
+			backoff = p.config.Retry.MaxBackoff
+		}
+	}
+}
+
+// Close closes idle connections and makes Get fail; connections in use are closed when they are Put back
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		close(p.quit)
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	for _, pc := range idle {
+		p.discard(pc.dbc)
+	}
+}
+
+// Stats reports the number of open and idle connections
+func (p *Pool) Stats() (open int, idle int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.open, len(p.idle)
+}
+
+// keepAlive pings the idle connections every KeepAlive, so half-open connections are found and replaced by the
+// pool rather than by a user request running into its timeout
+func (p *Pool) keepAlive() {
+	ticker := time.NewTicker(p.config.KeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.quit:
+			return
+		}
+		// take the idle connections out while they are pinged, so that Get does not hand them out meanwhile
+		p.mu.Lock()
+		idle := p.idle
+		p.idle = nil
+		p.mu.Unlock()
+		dead := 0
+		for _, pc := range idle {
+			if p.ping(pc.dbc) != nil {
+				p.discard(pc.dbc)
+				dead++
+				continue
+			}
+			p.Put(pc.dbc)
+		}
+		// redial the dead ones now, so the next requests do not wait for it
+		for ; dead > 0; dead-- {
+			ctx, cancel := context.WithTimeout(context.Background(), p.config.PingTimeout)
+			dbc, err := p.Get(ctx)
+			cancel()
+			if err != nil {
+				break
+			}
+			p.Put(dbc)
+		}
+	}
+}
+
+func (p *Pool) ping(dbc *SWARMDBConnection) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.PingTimeout)
+	defer cancel()
+	return dbc.PingCtx(ctx)
+}
+
+// dial opens and prepares a connection, retrying with exponential backoff
+func (p *Pool) dial(ctx context.Context) (dbc *SWARMDBConnection, err error) {
+	backoff := p.config.MinBackoff
+	for attempt := 1; ; attempt++ {
+		dbc, err = p.connect()
+		if err == nil {
+			return dbc, nil
+		}
+		if attempt >= p.config.ReconnectAttempts {
+			return nil, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, contextError(ctx.Err())
+		}
+		if backoff *= 2; backoff > p.config.MaxBackoff {
+			backoff = p.config.MaxBackoff
+		}
+	}
+}
+
+func (p *Pool) connect() (dbc *SWARMDBConnection, err error) {
+	return Open(ConnectionConfig{IP: p.config.IP, Port: p.config.Port, TLS: p.config.TLS, Embedded: p.config.Embedded, PrivateKey: p.config.PrivateKey, Compression: p.config.Compression, DialTimeout: p.config.DialTimeout, RequestTimeout: p.config.RequestTimeout})
+}
+
+func (p *Pool) discard(dbc *SWARMDBConnection) {
+	dbc.Close()
+	p.mu.Lock()
+	p.open--
+	p.mu.Unlock()
+	p.signal()
+}
+
+func (p *Pool) signal() {
+	select {
+	case p.freed <- struct{}{}:
+	default:
+	}
+}
+
+// isTransient reports whether a request failing with err may succeed when retried
+func isTransient(err error, broken bool) bool {
+	return broken || errors.Is(err, ErrUnavailable) || errors.Is(err, ErrThrottled)
+}
+
+func isReadRequest(requestType string) bool {
+	switch requestType {
+	case sdbc.RT_GET, sdbc.RT_SCAN, sdbc.RT_DESCRIBE_TABLE, sdbc.RT_LIST_DATABASES, sdbc.RT_LIST_TABLES, wire.RT_TABLE_INFO, wire.RT_GET_BLOB:
+		return true
+	}
+	return false
+}
+
+func (p *Pool) String() string {
+	open, idle := p.Stats()
+	return fmt.Sprintf("swarmdblib.Pool(%s:%d open=%d idle=%d)", p.config.IP, p.config.Port, open, idle)
+}
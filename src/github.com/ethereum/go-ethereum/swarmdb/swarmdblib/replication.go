@@ -0,0 +1,47 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+)
+
+// SetReplication has the server keep the chunks of the table written from now on in min to max replicas, and charge
+// the owner for min of them, whoever writes the table; 0 and 0 go back to the replication of each writer
+func (dbc *SWARMDBConnection) SetReplication(owner string, database string, table string, min int, max int) (err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_REPLICATION, Owner: owner, Database: database, Table: table, Rows: []sdbc.Row{{"min": min, "max": max}}}
+	_, err = dbc.ProcessRequestResponseCommand(req)
+	return err
+}
+
+// Replication returns the replication of the chunks of the table, 0 and 0 when it is that of each writer
+func (dbc *SWARMDBConnection) Replication(owner string, database string, table string) (min int, max int, err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_REPLICATION, Owner: owner, Database: database, Table: table}
+	resp, err := dbc.ProcessRequestResponseCommand(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, row := range resp.Data {
+		if v, ok := row["min"].(float64); ok {
+			min = int(v)
+		}
+		if v, ok := row["max"].(float64); ok {
+			max = int(v)
+		}
+	}
+	return min, max, nil
+}
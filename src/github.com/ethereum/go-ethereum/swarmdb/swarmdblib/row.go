@@ -0,0 +1,201 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SWARMDBRow wraps a row returned by the server with typed accessors.  When the table's columns are known (from
+// DescribeTable or CreateTable) conversions follow the column type, e.g. GetBytes base64-decodes a CT_BLOB column
+// while returning the raw bytes of a CT_STRING one.
+type SWARMDBRow struct {
+	Cells   sdbc.Row
+	Columns map[string]sdbc.Column // optional schema
+}
+
+func NewSWARMDBRow() *SWARMDBRow {
+	return &SWARMDBRow{Cells: sdbc.NewRow()}
+}
+
+// NewSWARMDBRows wraps the rows of resp, attaching the schema given by columns (which may be nil)
+func NewSWARMDBRows(resp sdbc.SWARMDBResponse, columns []sdbc.Column) (rows []*SWARMDBRow) {
+	schema := make(map[string]sdbc.Column)
+	for _, c := range columns {
+		schema[c.ColumnName] = c
+	}
+	for _, cells := range resp.Data {
+		rows = append(rows, &SWARMDBRow{Cells: cells, Columns: schema})
+	}
+	return rows
+}
+
+func (r *SWARMDBRow) Set(name string, value interface{}) {
+	r.Cells[name] = value
+}
+
+// Get returns the value of name as a string
+func (r *SWARMDBRow) Get(name string) (s string, err error) {
+	cell, err := r.cell(name)
+	if err != nil {
+		return s, err
+	}
+	switch c := cell.(type) {
+	case string:
+		return c, nil
+	case float64:
+		return strconv.FormatFloat(c, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(c), nil
+	case bool:
+		return strconv.FormatBool(c), nil
+	}
+	return fmt.Sprintf("%v", cell), nil
+}
+
+func (r *SWARMDBRow) GetInt(name string) (i int64, err error) {
+	cell, err := r.cell(name)
+	if err != nil {
+		return i, err
+	}
+	switch c := cell.(type) {
+	case float64:
+		if c != float64(int64(c)) {
+			return i, conversionError(name, cell, "integer")
+		}
+		return int64(c), nil
+	case int:
+		return int64(c), nil
+	case string:
+		if i, err = strconv.ParseInt(strings.TrimSpace(c), 10, 64); err != nil {
+			return i, conversionError(name, cell, "integer")
+		}
+		return i, nil
+	}
+	return i, conversionError(name, cell, "integer")
+}
+
+func (r *SWARMDBRow) GetFloat(name string) (f float64, err error) {
+	cell, err := r.cell(name)
+	if err != nil {
+		return f, err
+	}
+	switch c := cell.(type) {
+	case float64:
+		return c, nil
+	case int:
+		return float64(c), nil
+	case string:
+		if f, err = strconv.ParseFloat(strings.TrimSpace(c), 64); err != nil {
+			return f, conversionError(name, cell, "float")
+		}
+		return f, nil
+	}
+	return f, conversionError(name, cell, "float")
+}
+
+// GetBool accepts booleans, the numbers 0 and 1 and the strings strconv.ParseBool understands
+func (r *SWARMDBRow) GetBool(name string) (b bool, err error) {
+	cell, err := r.cell(name)
+	if err != nil {
+		return b, err
+	}
+	switch c := cell.(type) {
+	case bool:
+		return c, nil
+	case float64:
+		if c == 0 || c == 1 {
+			return c == 1, nil
+		}
+	case int:
+		if c == 0 || c == 1 {
+			return c == 1, nil
+		}
+	case string:
+		if b, err = strconv.ParseBool(strings.TrimSpace(c)); err == nil {
+			return b, nil
+		}
+	}
+	return b, conversionError(name, cell, "bool")
+}
+
+// GetTime reads integer and float columns as unix seconds and string columns as RFC3339
+func (r *SWARMDBRow) GetTime(name string) (ts time.Time, err error) {
+	cell, err := r.cell(name)
+	if err != nil {
+		return ts, err
+	}
+	switch c := cell.(type) {
+	case float64:
+		return time.Unix(0, int64(c*float64(time.Second))), nil
+	case int:
+		return time.Unix(int64(c), 0), nil
+	case string:
+		if ts, err = time.Parse(time.RFC3339Nano, strings.TrimSpace(c)); err == nil {
+			return ts, nil
+		}
+		if secs, perr := strconv.ParseInt(strings.TrimSpace(c), 10, 64); perr == nil {
+			return time.Unix(secs, 0), nil
+		}
+	}
+	return ts, conversionError(name, cell, "time")
+}
+
+// GetBytes decodes CT_BLOB columns from base64 (or 0x-prefixed hex) and returns other strings as is
+func (r *SWARMDBRow) GetBytes(name string) (b []byte, err error) {
+	cell, err := r.cell(name)
+	if err != nil {
+		return b, err
+	}
+	s, ok := cell.(string)
+	if !ok {
+		return b, conversionError(name, cell, "bytes")
+	}
+	if c, ok := r.Columns[name]; !ok || c.ColumnType != sdbc.CT_BLOB {
+		return []byte(s), nil
+	}
+	if strings.HasPrefix(s, "0x") {
+		b, err = hex.DecodeString(s[2:])
+	} else {
+		b, err = base64.StdEncoding.DecodeString(s)
+	}
+	if err != nil {
+		return nil, conversionError(name, cell, "bytes")
+	}
+	return b, nil
+}
+
+func (r *SWARMDBRow) cell(name string) (cell interface{}, err error) {
+	cell, ok := r.Cells[name]
+	if !ok || cell == nil {
+		if _, known := r.Columns[name]; len(r.Columns) > 0 && !known {
+			return nil, &wire.Error{Code: wire.ErrNoSuchColumn, Number: 404, Message: fmt.Sprintf("Column Does Not Exist in table definition: [%s]", name)}
+		}
+		return nil, &wire.Error{Code: wire.ErrNoSuchColumn, Number: 404, Message: fmt.Sprintf("Row has no value for column [%s]", name)}
+	}
+	return cell, nil
+}
+
+func conversionError(name string, cell interface{}, to string) error {
+	return &wire.Error{Code: wire.ErrBadRequest, Number: 427, Message: fmt.Sprintf("The value [%v] of [%s] cannot be converted to %s", cell, name, to)}
+}
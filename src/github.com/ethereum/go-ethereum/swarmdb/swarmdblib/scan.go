@@ -0,0 +1,68 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"strconv"
+)
+
+// ScanPage returns one page of rows with primary keys in [r.Start, r.End) and the token continuing the scan
+// (empty after the last page).  Pass the token back in r.Token, keeping the other fields, for the next page.
+func (dbc *SWARMDBConnection) ScanPage(ctx context.Context, owner string, database string, table string, r wire.ScanRange) (rows []sdbc.Row, nextToken string, err error) {
+	if dbc.Version < 2 {
+		return nil, "", &wire.Error{Code: wire.ErrBadRequest, Number: 418, Message: fmt.Sprintf("Request Invalid: range scans need protocol version 2, the server speaks %d", dbc.Version)}
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, "", contextError(err)
+	}
+	dbc.requestID++
+	requestID := strconv.FormatUint(dbc.requestID, 10)
+	out, err := json.Marshal(wire.Request{RequestID: requestID, RequestOption: sdbc.RequestOption{RequestType: wire.RT_SCAN_RANGE, Owner: owner, Database: database, Table: table}, Range: &r})
+	if err != nil {
+		return nil, "", &wire.Error{RequestID: requestID, Code: wire.ErrBadRequest, Number: 432, Message: fmt.Sprintf("Unable to Parse Request: %s", err.Error())}
+	}
+	envelope, err := dbc.roundTripCtx(ctx, out)
+	if err != nil {
+		return nil, "", err
+	}
+	return envelope.Data, envelope.NextToken, nil
+}
+
+// ScanRange calls fn for every row with a primary key in [start, end) (nil for an open bound), fetching limit rows
+// per round trip, until the range is exhausted or fn returns false
+func (dbc *SWARMDBConnection) ScanRange(ctx context.Context, owner string, database string, table string, start interface{}, end interface{}, limit int, ascending int, fn func(row sdbc.Row) bool) (err error) {
+	r := wire.ScanRange{Start: start, End: end, Limit: limit, Ascending: ascending}
+	for {
+		rows, nextToken, err := dbc.ScanPage(ctx, owner, database, table, r)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if !fn(row) {
+				return nil
+			}
+		}
+		if len(nextToken) == 0 {
+			return nil
+		}
+		r.Token = nextToken
+	}
+}
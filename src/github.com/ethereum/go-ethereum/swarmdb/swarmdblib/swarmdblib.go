@@ -0,0 +1,414 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package swarmdblib is the Go client for the SWARMDB TCP server.
+package swarmdblib
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/crypto"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SWARMDBConnection struct {
+	connection net.Conn
+	reader     *bufio.Reader
+	writer     *bufio.Writer
+	challenge  string
+	requestID  uint64
+	Owner      string   // set by the first Authenticate to the address the server bound this session to
+	Owners     []string // every address authenticated on the connection, Owner first
+	Capability string   // token sent with every request, which then runs within its scope (see MintCapability)
+
+	compression    string        // negotiated with NegotiateCompression
+	Version        int           // protocol version negotiated on open, see swarmdbwire.PROTOCOL_VERSION
+	broken         bool          // a transport error left the stream in an unknown state
+	requestTimeout time.Duration // ConnectionConfig.RequestTimeout
+}
+
+// ConnectionConfig describes a connection: the server, how it is reached and who the connection authenticates as.
+// Tables, databases and their ENS entries are the server's business; a client only names them in requests.
+type ConnectionConfig struct {
+	IP          string
+	Port        int
+	TLS         *TLSOptions    // nil for plain TCP
+	Embedded    EmbeddedServer // when set, the connection is opened in-process and IP, Port and TLS are ignored
+	PrivateKey  string         // when set, the connection authenticates with it (hex)
+	Capability  string         // when set, sent with every request, see SWARMDBConnection.Capability
+	Compression []string       // when set, the connection negotiates one of these
+
+	DialTimeout    time.Duration // connecting to the server, 0 leaves it to the operating system
+	RequestTimeout time.Duration // deadline of the requests whose context has none, 0 for none
+}
+
+// TLSOptions configures an encrypted connection; CAFile verifies the server, CertFile/KeyFile are presented for mutual TLS
+type TLSOptions struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// Open connects to the server config describes, then authenticates with config.PrivateKey and negotiates one of
+// config.Compression when they are set
+func Open(config ConnectionConfig) (dbc *SWARMDBConnection, err error) {
+	if config.Embedded != nil {
+		dbc, err = OpenEmbeddedConnection(config.Embedded)
+	} else {
+		dbc, err = dial(config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	dbc.Capability = config.Capability
+	dbc.requestTimeout = config.RequestTimeout
+	if len(config.PrivateKey) > 0 {
+		if _, err = dbc.Authenticate(config.PrivateKey); err != nil {
+			dbc.Close()
+			return nil, err
+		}
+	}
+	if len(config.Compression) > 0 {
+		if _, err = dbc.NegotiateCompression(config.Compression...); err != nil {
+			dbc.Close()
+			return nil, err
+		}
+	}
+	return dbc, nil
+}
+
+// OpenConnection connects to a SWARMDB TCP server in cleartext
+func OpenConnection(ip string, port int) (dbc *SWARMDBConnection, err error) {
+	return Open(ConnectionConfig{IP: ip, Port: port})
+}
+
+// OpenTLSConnection connects to a SWARMDB TCP server, using TLS unless opts is nil
+func OpenTLSConnection(ip string, port int, opts *TLSOptions) (dbc *SWARMDBConnection, err error) {
+	return Open(ConnectionConfig{IP: ip, Port: port, TLS: opts})
+}
+
+func dial(config ConnectionConfig) (dbc *SWARMDBConnection, err error) {
+	addr := fmt.Sprintf("%s:%d", config.IP, config.Port)
+	dialer := &net.Dialer{Timeout: config.DialTimeout}
+	var conn net.Conn
+	if config.TLS == nil {
+		conn, err = dialer.Dial("tcp", addr)
+	} else {
+		tlsConfig, cerr := config.TLS.tlsConfig(config.IP)
+		if cerr != nil {
+			return nil, cerr
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	}
+	if err != nil {
+		return nil, &wire.Error{Code: wire.ErrUnavailable, Number: 488, Message: fmt.Sprintf("Unable to connect to SWARMDB server: %s", err.Error())}
+	}
+	return newConnection(conn)
+}
+
+// EmbeddedServer serves in-process connections, e.g. the *swarmdb.TCPServer of swarmdb.NewEmbeddedServer
+type EmbeddedServer interface {
+	ServeConn(conn net.Conn)
+}
+
+// OpenEmbeddedConnection connects to server within the process over a net.Pipe instead of TCP.  The connection
+// speaks the same protocol as a TCP connection, so the whole API (and Authenticate) works unchanged.
+func OpenEmbeddedConnection(server EmbeddedServer) (dbc *SWARMDBConnection, err error) {
+	conn, serverConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	return newConnection(conn)
+}
+
+// newConnection reads the challenge and negotiates the protocol version on a freshly opened conn
+func newConnection(conn net.Conn) (dbc *SWARMDBConnection, err error) {
+	dbc = &SWARMDBConnection{connection: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn), Version: wire.MIN_PROTOCOL_VERSION}
+	challenge, err := dbc.reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, &wire.Error{Code: wire.ErrUnavailable, Number: 488, Message: fmt.Sprintf("Unable to connect to SWARMDB server: %s", err.Error())}
+	}
+	dbc.challenge = strings.TrimSpace(challenge)
+	if err = dbc.hello(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return dbc, nil
+}
+
+// hello negotiates the protocol version; servers that predate RT_HELLO reject it and are spoken to in version 1
+func (dbc *SWARMDBConnection) hello() (err error) {
+	out, err := json.Marshal(wire.Request{RequestOption: sdbc.RequestOption{RequestType: wire.RT_HELLO}, Version: wire.PROTOCOL_VERSION})
+	if err != nil {
+		return &wire.Error{Code: wire.ErrBadRequest, Number: 432, Message: fmt.Sprintf("Unable to Parse Request: %s", err.Error())}
+	}
+	resp, err := dbc.roundTrip(out)
+	if _, ok := err.(*wire.Error); ok && resp.Status == wire.STATUS_ERROR {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(resp.Data) == 1 {
+		if version, ok := resp.Data[0]["version"].(float64); ok && int(version) >= wire.MIN_PROTOCOL_VERSION {
+			dbc.Version = int(version)
+		}
+	}
+	return nil
+}
+
+// Authenticate signs the server challenge with privateKey (hex) so that all following requests run as its address.
+// Calling it again with other keys adds owners to the connection; a request then runs as its Owner when that owner
+// was authenticated, otherwise as the first one.
+func (dbc *SWARMDBConnection) Authenticate(privateKey string) (owner string, err error) {
+	secretKey, err := crypto.HexToECDSA(strings.TrimPrefix(privateKey, "0x"))
+	if err != nil {
+		return owner, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdblib:Authenticate] HexToECDSA %s", err.Error()), ErrorCode: 455, ErrorMessage: "Keymanager Unable to Sign Message"}
+	}
+	msg := fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(dbc.challenge), dbc.challenge)
+	sig, err := crypto.Sign(crypto.Keccak256([]byte(msg)), secretKey)
+	if err != nil {
+		return owner, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdblib:Authenticate] Sign %s", err.Error()), ErrorCode: 455, ErrorMessage: "Keymanager Unable to Sign Message"}
+	}
+	resp, err := dbc.roundTrip([]byte(fmt.Sprintf("%x", sig)))
+	if err != nil {
+		return owner, err
+	}
+	if len(resp.Data) == 0 {
+		return owner, &wire.Error{Code: wire.ErrUnauthorized, Number: 489, Message: "Authentication response missing owner"}
+	}
+	owner, _ = resp.Data[0]["owner"].(string)
+	if len(dbc.Owner) == 0 {
+		dbc.Owner = owner
+	}
+	if !dbc.IsOwner(owner) {
+		dbc.Owners = append(dbc.Owners, owner)
+	}
+	return owner, nil
+}
+
+// IsOwner reports whether requests with Owner owner run as that owner on this connection
+func (dbc *SWARMDBConnection) IsOwner(owner string) bool {
+	for _, o := range dbc.Owners {
+		if strings.EqualFold(o, owner) {
+			return true
+		}
+	}
+	return false
+}
+
+func (opts *TLSOptions) tlsConfig(ip string) (tlsConfig *tls.Config, err error) {
+	tlsConfig = &tls.Config{ServerName: opts.ServerName, InsecureSkipVerify: opts.InsecureSkipVerify, MinVersion: tls.VersionTLS12}
+	if len(tlsConfig.ServerName) == 0 {
+		tlsConfig.ServerName = ip
+	}
+	if len(opts.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdblib:tlsConfig] ReadFile %s", err.Error()), ErrorCode: 486, ErrorMessage: "Unable to load TLS certificate"}
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdblib:tlsConfig] no certificates in %s", opts.CAFile), ErrorCode: 486, ErrorMessage: "Unable to load TLS certificate"}
+		}
+	}
+	if len(opts.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[swarmdblib:tlsConfig] LoadX509KeyPair %s", err.Error()), ErrorCode: 486, ErrorMessage: "Unable to load TLS certificate"}
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func (dbc *SWARMDBConnection) Close() (err error) {
+	dbc.broken = true
+	return dbc.connection.Close()
+}
+
+// ProcessRequestResponseCommand sends req to the server and waits for its response; server errors are returned as *swarmdbwire.Error
+func (dbc *SWARMDBConnection) ProcessRequestResponseCommand(req sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	return dbc.ProcessRequestCtx(context.Background(), req)
+}
+
+// ProcessRequestCtx is ProcessRequestResponseCommand honoring the cancellation and deadline of ctx.  The deadline is
+// sent to the server, which stops working on the request once it passes.  A request interrupted by ctx leaves the
+// connection in an unknown state, so the connection is closed.
+func (dbc *SWARMDBConnection) ProcessRequestCtx(ctx context.Context, req sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	return dbc.ProcessIdempotentRequest(ctx, req, "")
+}
+
+// ProcessIdempotentRequest is ProcessRequestCtx for a write carrying idempotencyKey (see NewIdempotencyKey): the
+// server applies it once however often it is sent with the same key, so it can be resent after a transport failure.
+func (dbc *SWARMDBConnection) ProcessIdempotentRequest(ctx context.Context, req sdbc.RequestOption, idempotencyKey string) (resp sdbc.SWARMDBResponse, err error) {
+	if err = ctx.Err(); err != nil {
+		return resp, contextError(err)
+	}
+	if _, ok := ctx.Deadline(); !ok && dbc.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dbc.requestTimeout)
+		defer cancel()
+	}
+	dbc.requestID++
+	requestID := strconv.FormatUint(dbc.requestID, 10)
+	request := wire.Request{RequestID: requestID, IdempotencyKey: idempotencyKey, Capability: dbc.Capability, RequestOption: req}
+	if deadline, ok := ctx.Deadline(); ok {
+		request.Deadline = deadline.UnixNano() / int64(time.Millisecond)
+	}
+	out, err := json.Marshal(request)
+	if err != nil {
+		return resp, &wire.Error{RequestID: requestID, Code: wire.ErrBadRequest, Number: 432, Message: fmt.Sprintf("Unable to Parse Request: %s", err.Error())}
+	}
+	envelope, err := dbc.roundTripCtx(ctx, out)
+	if err != nil {
+		return resp, err
+	}
+	if dbc.Version >= 2 && envelope.RequestID != requestID {
+		return resp, &wire.Error{RequestID: requestID, Code: wire.ErrInternal, Number: 432, Message: fmt.Sprintf("Response for request [%s] received for request [%s]", envelope.RequestID, requestID)}
+	}
+	return envelope.SWARMDBResponse(), nil
+}
+
+// NewIdempotencyKey returns a random key for ProcessIdempotentRequest
+func NewIdempotencyKey() string {
+	key := make([]byte, 16)
+	rand.Read(key)
+	return fmt.Sprintf("%x", key)
+}
+
+// Ping checks that the server answers on the connection
+func (dbc *SWARMDBConnection) Ping() (err error) {
+	return dbc.PingCtx(context.Background())
+}
+
+// PingCtx sends a keep-alive ping and waits for the pong until ctx is done.  Any answer counts, so servers that
+// predate RT_PING (and answer with an error) are alive too; a connection that does not answer is closed.
+func (dbc *SWARMDBConnection) PingCtx(ctx context.Context) (err error) {
+	out, err := json.Marshal(wire.Request{RequestOption: sdbc.RequestOption{RequestType: wire.RT_PING}})
+	if err != nil {
+		return &wire.Error{Code: wire.ErrBadRequest, Number: 432, Message: fmt.Sprintf("Unable to Parse Request: %s", err.Error())}
+	}
+	if _, err = dbc.roundTripCtx(ctx, out); err != nil && dbc.broken {
+		dbc.Close()
+		return err
+	}
+	return nil
+}
+
+// Use sets the default owner and database of the connection; later requests may leave Owner and Database empty.
+// On an authenticated connection owner must be empty or the authenticated owner.
+func (dbc *SWARMDBConnection) Use(owner string, database string) (err error) {
+	_, err = dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: wire.RT_USE, Owner: owner, Database: database})
+	return err
+}
+
+// Admin runs an admin command (swarmdb.ADMIN_*); req names the table for commands that take one.
+// The connection must be authenticated as an admin address.
+func (dbc *SWARMDBConnection) Admin(command string, req sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	dbc.requestID++
+	requestID := strconv.FormatUint(dbc.requestID, 10)
+	req.RequestType = wire.RT_ADMIN
+	out, err := json.Marshal(wire.Request{RequestID: requestID, RequestOption: req, Command: command})
+	if err != nil {
+		return resp, &wire.Error{RequestID: requestID, Code: wire.ErrBadRequest, Number: 432, Message: fmt.Sprintf("Unable to Parse Request: %s", err.Error())}
+	}
+	envelope, err := dbc.roundTrip(out)
+	if err != nil {
+		return resp, err
+	}
+	return envelope.SWARMDBResponse(), nil
+}
+
+// ProcessBatch sends reqs in one round trip and returns a response and an error (nil or *swarmdbwire.Error) per request.
+// With atomic set, all writes to a table are committed together or not at all.
+func (dbc *SWARMDBConnection) ProcessBatch(reqs []sdbc.RequestOption, atomic bool) (resps []sdbc.SWARMDBResponse, errs []error, err error) {
+	if dbc.Version < 2 {
+		return nil, nil, &wire.Error{Code: wire.ErrBadRequest, Number: 418, Message: fmt.Sprintf("Request Invalid: batches need protocol version 2, the server speaks %d", dbc.Version)}
+	}
+	dbc.requestID++
+	requestID := strconv.FormatUint(dbc.requestID, 10)
+	batch := wire.Request{RequestID: requestID, RequestOption: sdbc.RequestOption{RequestType: wire.RT_BATCH}, Atomic: atomic}
+	for i, req := range reqs {
+		batch.Batch = append(batch.Batch, wire.Request{RequestID: strconv.Itoa(i), RequestOption: req})
+	}
+	out, err := json.Marshal(batch)
+	if err != nil {
+		return nil, nil, &wire.Error{RequestID: requestID, Code: wire.ErrBadRequest, Number: 432, Message: fmt.Sprintf("Unable to Parse Request: %s", err.Error())}
+	}
+	envelope, err := dbc.roundTrip(out)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(envelope.Results) != len(reqs) {
+		return nil, nil, &wire.Error{RequestID: requestID, Code: wire.ErrInternal, Number: 432, Message: fmt.Sprintf("Batch of %d requests answered with %d results", len(reqs), len(envelope.Results))}
+	}
+	resps = make([]sdbc.SWARMDBResponse, len(reqs))
+	errs = make([]error, len(reqs))
+	for i, r := range envelope.Results {
+		resps[i], errs[i] = r.SWARMDBResponse(), r.Err()
+	}
+	return resps, errs, nil
+}
+
+// NegotiateCompression offers algs (swarmdbwire.COMPRESSION_SNAPPY, COMPRESSION_GZIP) in order of preference and
+// returns the one the server chose; subsequent requests and responses are compressed with it.
+// An empty result means the server supports none of them and the connection stays uncompressed.
+func (dbc *SWARMDBConnection) NegotiateCompression(algs ...string) (compression string, err error) {
+	dbc.requestID++
+	requestID := strconv.FormatUint(dbc.requestID, 10)
+	out, err := json.Marshal(wire.Request{RequestID: requestID, RequestOption: sdbc.RequestOption{RequestType: wire.RT_COMPRESSION}, Compression: algs})
+	if err != nil {
+		return compression, &wire.Error{RequestID: requestID, Code: wire.ErrBadRequest, Number: 432, Message: fmt.Sprintf("Unable to Parse Request: %s", err.Error())}
+	}
+	envelope, err := dbc.roundTrip(out)
+	if err != nil {
+		return compression, err
+	}
+	if len(envelope.Data) == 1 {
+		compression, _ = envelope.Data[0]["compression"].(string)
+	}
+	dbc.compression = compression
+	return compression, nil
+}
+
+// roundTrip writes one message and reads the reply envelope, returning its error if the status is not ok
+func (dbc *SWARMDBConnection) roundTrip(out []byte) (resp wire.Response, err error) {
+	if err = wire.WriteMessage(dbc.writer, dbc.compression, out); err != nil {
+		dbc.broken = true
+		return resp, &wire.Error{Code: wire.ErrUnavailable, Number: 488, Message: fmt.Sprintf("Unable to connect to SWARMDB server: %s", err.Error())}
+	}
+	line, err := wire.ReadMessage(dbc.reader, dbc.compression)
+	if err != nil {
+		dbc.broken = true
+		return resp, &wire.Error{Code: wire.ErrUnavailable, Number: 488, Message: fmt.Sprintf("Unable to connect to SWARMDB server: %s", err.Error())}
+	}
+	if resp, err = wire.DecodeResponse(dbc.Version, line); err != nil {
+		dbc.broken = true
+		return resp, &wire.Error{Code: wire.ErrInternal, Number: 432, Message: fmt.Sprintf("Unable to Parse Response: %s", err.Error())}
+	}
+	return resp, resp.Err()
+}
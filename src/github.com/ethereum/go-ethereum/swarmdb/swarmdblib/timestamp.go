@@ -0,0 +1,44 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+)
+
+// SetTimestampColumns has the server set the created column of every row written from now on to the time of its
+// first write, and the updated column to the time of its last write, "" for none.  Integer columns get unix
+// milliseconds, string columns the UTC time as "2006-01-02T15:04:05.000Z", which sort as the times do.
+func (dbc *SWARMDBConnection) SetTimestampColumns(owner string, database string, table string, created string, updated string) (err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_TIMESTAMP_COLUMNS, Owner: owner, Database: database, Table: table, Rows: []sdbc.Row{{"created": created, "updated": updated}}}
+	_, err = dbc.ProcessRequestResponseCommand(req)
+	return err
+}
+
+// TimestampColumns returns the created and updated columns the server sets in the rows of the table, "" for none
+func (dbc *SWARMDBConnection) TimestampColumns(owner string, database string, table string) (created string, updated string, err error) {
+	req := sdbc.RequestOption{RequestType: wire.RT_TIMESTAMP_COLUMNS, Owner: owner, Database: database, Table: table}
+	resp, err := dbc.ProcessRequestResponseCommand(req)
+	if err != nil {
+		return "", "", err
+	}
+	for _, row := range resp.Data {
+		created, _ = row["created"].(string)
+		updated, _ = row["updated"].(string)
+	}
+	return created, updated, nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	"context"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+)
+
+// SWARMDBTx is a transaction on one table: its writes become visible together on Commit, or not at all after
+// Rollback.  It occupies the connection until it finishes; closing the connection rolls it back.
+type SWARMDBTx struct {
+	dbc      *SWARMDBConnection
+	Owner    string
+	Database string
+	Table    string
+	done     bool
+}
+
+// Begin starts a transaction on a table; it fails with ErrConflict while another transaction holds the table
+func (dbc *SWARMDBConnection) Begin(owner string, database string, table string) (tx *SWARMDBTx, err error) {
+	if _, err = dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: wire.RT_BEGIN, Owner: owner, Database: database, Table: table}); err != nil {
+		return nil, err
+	}
+	return &SWARMDBTx{dbc: dbc, Owner: owner, Database: database, Table: table}, nil
+}
+
+// ProcessRequestCtx runs req within the transaction, filling in the transaction's owner, database and table
+func (tx *SWARMDBTx) ProcessRequestCtx(ctx context.Context, req sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	req.Owner, req.Database = tx.Owner, tx.Database
+	if len(req.Table) == 0 {
+		req.Table = tx.Table
+	}
+	return tx.dbc.ProcessRequestCtx(ctx, req)
+}
+
+func (tx *SWARMDBTx) Get(key interface{}) (resp sdbc.SWARMDBResponse, err error) {
+	return tx.dbc.GetCtx(context.Background(), tx.Owner, tx.Database, tx.Table, key)
+}
+
+func (tx *SWARMDBTx) Put(rows []sdbc.Row) (resp sdbc.SWARMDBResponse, err error) {
+	return tx.ProcessRequestCtx(context.Background(), sdbc.RequestOption{RequestType: sdbc.RT_PUT, Rows: rows})
+}
+
+func (tx *SWARMDBTx) Delete(key interface{}) (resp sdbc.SWARMDBResponse, err error) {
+	return tx.ProcessRequestCtx(context.Background(), sdbc.RequestOption{RequestType: sdbc.RT_DELETE, Key: key})
+}
+
+// Query runs an SQL query, e.g. an UPDATE or INSERT on the transaction's table
+func (tx *SWARMDBTx) Query(query string) (resp sdbc.SWARMDBResponse, err error) {
+	return tx.ProcessRequestCtx(context.Background(), sdbc.RequestOption{RequestType: sdbc.RT_QUERY, RawQuery: query})
+}
+
+// Commit makes the writes of the transaction visible
+func (tx *SWARMDBTx) Commit() (err error) {
+	return tx.finish(wire.RT_COMMIT)
+}
+
+// Rollback discards the writes of the transaction; after Commit it does nothing, so it can be deferred
+func (tx *SWARMDBTx) Rollback() (err error) {
+	return tx.finish(wire.RT_ROLLBACK)
+}
+
+func (tx *SWARMDBTx) finish(requestType string) (err error) {
+	if tx.done {
+		return nil
+	}
+	tx.done = true
+	_, err = tx.dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: requestType})
+	return err
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+)
+
+// Usage returns the usage the server metered for owner, or for every owner when owner is empty, as a row per owner
+// (see swarmdb.OwnerUsage); with reset the server starts a new billing period.  The connection must be
+// authenticated as an admin of the server.
+func (dbc *SWARMDBConnection) Usage(owner string, reset bool) (usage []sdbc.Row, err error) {
+	row := sdbc.NewRow()
+	row["owner"] = owner
+	row["reset"] = reset
+	resp, err := dbc.Admin("Usage", sdbc.RequestOption{Rows: []sdbc.Row{row}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdblib
+
+import (
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+)
+
+// Verify checks the tables of owner on the server, every table of every database when database is empty and every
+// table of database when table is empty, and returns a report row per table (see swarmdb.VerifyReport): "ok" is
+// false when a table is damaged and "issues" lists what was found.  The connection must be authenticated as an
+// admin of the server.
+func (dbc *SWARMDBConnection) Verify(owner string, database string, table string) (reports []sdbc.Row, err error) {
+	row := sdbc.NewRow()
+	row["owner"] = owner
+	row["database"] = database
+	row["table"] = table
+	resp, err := dbc.Admin("Verify", sdbc.RequestOption{Rows: []sdbc.Row{row}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package swarmdblog sets up the go-ethereum logger for SWARMDB: a level (trace, debug, info, warn or error), an
+// output format (terminal, logfmt or json), a sink (stderr or a file) and levels per subsystem that can be changed
+// while the server runs.  Subsystems log through New, which tags their records with the subsystem; records of other
+// loggers are held to the default level.
+package swarmdblog
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	"os"
+	"sync"
+)
+
+const (
+	FORMAT_TERMINAL = "terminal"
+	FORMAT_LOGFMT   = "logfmt"
+	FORMAT_JSON     = "json"
+
+	DEFAULT_LEVEL = "info"
+
+	SUBSYSTEM_KEY = "subsystem" // context key naming the subsystem of a record
+)
+
+type Config struct {
+	Level      string            `json:"level,omitempty"`      // default level, DEFAULT_LEVEL when empty
+	Format     string            `json:"format,omitempty"`     // FORMAT_TERMINAL (default), FORMAT_LOGFMT or FORMAT_JSON
+	File       string            `json:"file,omitempty"`       // file appended to instead of stderr
+	Subsystems map[string]string `json:"subsystems,omitempty"` // levels of subsystems that differ from Level
+}
+
+// levelHandler passes records at or above the level of their subsystem on to sink
+type levelHandler struct {
+	mu         sync.RWMutex
+	level      log.Lvl
+	subsystems map[string]log.Lvl
+	sink       log.Handler
+}
+
+var handler = &levelHandler{level: log.LvlInfo, subsystems: make(map[string]log.Lvl), sink: log.StreamHandler(os.Stderr, log.TerminalFormat(false))}
+
+func (h *levelHandler) Log(r *log.Record) error {
+	h.mu.RLock()
+	level := h.level
+	for i := 0; i+1 < len(r.Ctx); i += 2 {
+		if r.Ctx[i] == SUBSYSTEM_KEY {
+			if l, ok := h.subsystems[fmt.Sprintf("%v", r.Ctx[i+1])]; ok {
+				level = l
+			}
+			break
+		}
+	}
+	sink := h.sink
+	h.mu.RUnlock()
+	// levels count down from crit (0) to trace (5)
+	if r.Lvl > level {
+		return nil
+	}
+	return sink.Log(r)
+}
+
+// New returns the logger of subsystem
+func New(subsystem string) log.Logger {
+	return log.Root().New(SUBSYSTEM_KEY, subsystem)
+}
+
+// Setup sends the records of every logger to the sink of config, at the levels of config
+func Setup(config Config) (err error) {
+	format := log.TerminalFormat(false)
+	switch config.Format {
+	case "", FORMAT_TERMINAL:
+	case FORMAT_LOGFMT:
+		format = log.LogfmtFormat()
+	case FORMAT_JSON:
+		format = log.JSONFormat()
+	default:
+		return fmt.Errorf("unknown log format [%s]", config.Format)
+	}
+	sink := log.StreamHandler(os.Stderr, format)
+	if len(config.File) > 0 {
+		if sink, err = log.FileHandler(config.File, format); err != nil {
+			return err
+		}
+	}
+	if len(config.Level) == 0 {
+		config.Level = DEFAULT_LEVEL
+	}
+	level, err := log.LvlFromString(config.Level)
+	if err != nil {
+		return err
+	}
+	subsystems := make(map[string]log.Lvl)
+	for subsystem, name := range config.Subsystems {
+		if subsystems[subsystem], err = log.LvlFromString(name); err != nil {
+			return fmt.Errorf("subsystem %s: %s", subsystem, err.Error())
+		}
+	}
+	handler.mu.Lock()
+	handler.level, handler.subsystems, handler.sink = level, subsystems, sink
+	handler.mu.Unlock()
+	log.Root().SetHandler(handler)
+	return nil
+}
+
+// SetLevel changes the level of subsystem, or the default level when subsystem is empty; level "" returns the
+// subsystem to the default level.  Records go to stderr unless Setup chose another sink.
+func SetLevel(subsystem string, level string) (err error) {
+	var l log.Lvl
+	if len(level) > 0 || len(subsystem) == 0 {
+		if l, err = log.LvlFromString(level); err != nil {
+			return err
+		}
+	}
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	switch {
+	case len(subsystem) == 0:
+		handler.level = l
+	case len(level) == 0:
+		delete(handler.subsystems, subsystem)
+	default:
+		handler.subsystems[subsystem] = l
+	}
+	log.Root().SetHandler(handler)
+	return nil
+}
+
+// Levels returns the default level under "" and the level of every subsystem set apart from it
+func Levels() (levels map[string]string) {
+	handler.mu.RLock()
+	defer handler.mu.RUnlock()
+	levels = map[string]string{"": levelName(handler.level)}
+	for subsystem, l := range handler.subsystems {
+		levels[subsystem] = levelName(l)
+	}
+	return levels
+}
+
+// levelName is the name of l Setup and SetLevel take, where l.String() abbreviates
+func levelName(l log.Lvl) string {
+	switch l {
+	case log.LvlTrace:
+		return "trace"
+	case log.LvlDebug:
+		return "debug"
+	case log.LvlInfo:
+		return "info"
+	case log.LvlWarn:
+		return "warn"
+	case log.LvlError:
+		return "error"
+	}
+	return "crit"
+}
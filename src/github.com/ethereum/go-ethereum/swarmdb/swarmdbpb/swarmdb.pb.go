@@ -0,0 +1,925 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: swarmdb.proto
+
+package swarmdbpb
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type ChallengeRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ChallengeRequest) Reset()         { *m = ChallengeRequest{} }
+func (m *ChallengeRequest) String() string { return proto.CompactTextString(m) }
+func (*ChallengeRequest) ProtoMessage()    {}
+func (*ChallengeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_swarmdb_a87223fc97aa7589, []int{0}
+}
+func (m *ChallengeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ChallengeRequest.Unmarshal(m, b)
+}
+func (m *ChallengeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ChallengeRequest.Marshal(b, m, deterministic)
+}
+func (dst *ChallengeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ChallengeRequest.Merge(dst, src)
+}
+func (m *ChallengeRequest) XXX_Size() int {
+	return xxx_messageInfo_ChallengeRequest.Size(m)
+}
+func (m *ChallengeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ChallengeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ChallengeRequest proto.InternalMessageInfo
+
+type ChallengeResponse struct {
+	Challenge            string   `protobuf:"bytes,1,opt,name=challenge" json:"challenge,omitempty"`
+	ExpiresMs            int64    `protobuf:"varint,2,opt,name=expires_ms,json=expiresMs" json:"expires_ms,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ChallengeResponse) Reset()         { *m = ChallengeResponse{} }
+func (m *ChallengeResponse) String() string { return proto.CompactTextString(m) }
+func (*ChallengeResponse) ProtoMessage()    {}
+func (*ChallengeResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_swarmdb_a87223fc97aa7589, []int{1}
+}
+func (m *ChallengeResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ChallengeResponse.Unmarshal(m, b)
+}
+func (m *ChallengeResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ChallengeResponse.Marshal(b, m, deterministic)
+}
+func (dst *ChallengeResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ChallengeResponse.Merge(dst, src)
+}
+func (m *ChallengeResponse) XXX_Size() int {
+	return xxx_messageInfo_ChallengeResponse.Size(m)
+}
+func (m *ChallengeResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ChallengeResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ChallengeResponse proto.InternalMessageInfo
+
+func (m *ChallengeResponse) GetChallenge() string {
+	if m != nil {
+		return m.Challenge
+	}
+	return ""
+}
+
+func (m *ChallengeResponse) GetExpiresMs() int64 {
+	if m != nil {
+		return m.ExpiresMs
+	}
+	return 0
+}
+
+type Column struct {
+	ColumnName           string   `protobuf:"bytes,1,opt,name=column_name,json=columnName" json:"column_name,omitempty"`
+	ColumnType           string   `protobuf:"bytes,2,opt,name=column_type,json=columnType" json:"column_type,omitempty"`
+	IndexType            string   `protobuf:"bytes,3,opt,name=index_type,json=indexType" json:"index_type,omitempty"`
+	Primary              int32    `protobuf:"varint,4,opt,name=primary" json:"primary,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Column) Reset()         { *m = Column{} }
+func (m *Column) String() string { return proto.CompactTextString(m) }
+func (*Column) ProtoMessage()    {}
+func (*Column) Descriptor() ([]byte, []int) {
+	return fileDescriptor_swarmdb_a87223fc97aa7589, []int{2}
+}
+func (m *Column) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Column.Unmarshal(m, b)
+}
+func (m *Column) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Column.Marshal(b, m, deterministic)
+}
+func (dst *Column) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Column.Merge(dst, src)
+}
+func (m *Column) XXX_Size() int {
+	return xxx_messageInfo_Column.Size(m)
+}
+func (m *Column) XXX_DiscardUnknown() {
+	xxx_messageInfo_Column.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Column proto.InternalMessageInfo
+
+func (m *Column) GetColumnName() string {
+	if m != nil {
+		return m.ColumnName
+	}
+	return ""
+}
+
+func (m *Column) GetColumnType() string {
+	if m != nil {
+		return m.ColumnType
+	}
+	return ""
+}
+
+func (m *Column) GetIndexType() string {
+	if m != nil {
+		return m.IndexType
+	}
+	return ""
+}
+
+func (m *Column) GetPrimary() int32 {
+	if m != nil {
+		return m.Primary
+	}
+	return 0
+}
+
+type TableRequest struct {
+	Owner                string   `protobuf:"bytes,1,opt,name=owner" json:"owner,omitempty"`
+	Database             string   `protobuf:"bytes,2,opt,name=database" json:"database,omitempty"`
+	Table                string   `protobuf:"bytes,3,opt,name=table" json:"table,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TableRequest) Reset()         { *m = TableRequest{} }
+func (m *TableRequest) String() string { return proto.CompactTextString(m) }
+func (*TableRequest) ProtoMessage()    {}
+func (*TableRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_swarmdb_a87223fc97aa7589, []int{3}
+}
+func (m *TableRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TableRequest.Unmarshal(m, b)
+}
+func (m *TableRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TableRequest.Marshal(b, m, deterministic)
+}
+func (dst *TableRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TableRequest.Merge(dst, src)
+}
+func (m *TableRequest) XXX_Size() int {
+	return xxx_messageInfo_TableRequest.Size(m)
+}
+func (m *TableRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_TableRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TableRequest proto.InternalMessageInfo
+
+func (m *TableRequest) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+func (m *TableRequest) GetDatabase() string {
+	if m != nil {
+		return m.Database
+	}
+	return ""
+}
+
+func (m *TableRequest) GetTable() string {
+	if m != nil {
+		return m.Table
+	}
+	return ""
+}
+
+type CreateTableRequest struct {
+	Owner                string    `protobuf:"bytes,1,opt,name=owner" json:"owner,omitempty"`
+	Database             string    `protobuf:"bytes,2,opt,name=database" json:"database,omitempty"`
+	Table                string    `protobuf:"bytes,3,opt,name=table" json:"table,omitempty"`
+	Columns              []*Column `protobuf:"bytes,4,rep,name=columns" json:"columns,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *CreateTableRequest) Reset()         { *m = CreateTableRequest{} }
+func (m *CreateTableRequest) String() string { return proto.CompactTextString(m) }
+func (*CreateTableRequest) ProtoMessage()    {}
+func (*CreateTableRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_swarmdb_a87223fc97aa7589, []int{4}
+}
+func (m *CreateTableRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CreateTableRequest.Unmarshal(m, b)
+}
+func (m *CreateTableRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CreateTableRequest.Marshal(b, m, deterministic)
+}
+func (dst *CreateTableRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CreateTableRequest.Merge(dst, src)
+}
+func (m *CreateTableRequest) XXX_Size() int {
+	return xxx_messageInfo_CreateTableRequest.Size(m)
+}
+func (m *CreateTableRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_CreateTableRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CreateTableRequest proto.InternalMessageInfo
+
+func (m *CreateTableRequest) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+func (m *CreateTableRequest) GetDatabase() string {
+	if m != nil {
+		return m.Database
+	}
+	return ""
+}
+
+func (m *CreateTableRequest) GetTable() string {
+	if m != nil {
+		return m.Table
+	}
+	return ""
+}
+
+func (m *CreateTableRequest) GetColumns() []*Column {
+	if m != nil {
+		return m.Columns
+	}
+	return nil
+}
+
+type PutRequest struct {
+	Owner                string   `protobuf:"bytes,1,opt,name=owner" json:"owner,omitempty"`
+	Database             string   `protobuf:"bytes,2,opt,name=database" json:"database,omitempty"`
+	Table                string   `protobuf:"bytes,3,opt,name=table" json:"table,omitempty"`
+	Rows                 []*Row   `protobuf:"bytes,4,rep,name=rows" json:"rows,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PutRequest) Reset()         { *m = PutRequest{} }
+func (m *PutRequest) String() string { return proto.CompactTextString(m) }
+func (*PutRequest) ProtoMessage()    {}
+func (*PutRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_swarmdb_a87223fc97aa7589, []int{5}
+}
+func (m *PutRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PutRequest.Unmarshal(m, b)
+}
+func (m *PutRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PutRequest.Marshal(b, m, deterministic)
+}
+func (dst *PutRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PutRequest.Merge(dst, src)
+}
+func (m *PutRequest) XXX_Size() int {
+	return xxx_messageInfo_PutRequest.Size(m)
+}
+func (m *PutRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PutRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PutRequest proto.InternalMessageInfo
+
+func (m *PutRequest) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+func (m *PutRequest) GetDatabase() string {
+	if m != nil {
+		return m.Database
+	}
+	return ""
+}
+
+func (m *PutRequest) GetTable() string {
+	if m != nil {
+		return m.Table
+	}
+	return ""
+}
+
+func (m *PutRequest) GetRows() []*Row {
+	if m != nil {
+		return m.Rows
+	}
+	return nil
+}
+
+type KeyRequest struct {
+	Owner                string   `protobuf:"bytes,1,opt,name=owner" json:"owner,omitempty"`
+	Database             string   `protobuf:"bytes,2,opt,name=database" json:"database,omitempty"`
+	Table                string   `protobuf:"bytes,3,opt,name=table" json:"table,omitempty"`
+	Key                  string   `protobuf:"bytes,4,opt,name=key" json:"key,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *KeyRequest) Reset()         { *m = KeyRequest{} }
+func (m *KeyRequest) String() string { return proto.CompactTextString(m) }
+func (*KeyRequest) ProtoMessage()    {}
+func (*KeyRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_swarmdb_a87223fc97aa7589, []int{6}
+}
+func (m *KeyRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_KeyRequest.Unmarshal(m, b)
+}
+func (m *KeyRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_KeyRequest.Marshal(b, m, deterministic)
+}
+func (dst *KeyRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_KeyRequest.Merge(dst, src)
+}
+func (m *KeyRequest) XXX_Size() int {
+	return xxx_messageInfo_KeyRequest.Size(m)
+}
+func (m *KeyRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_KeyRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_KeyRequest proto.InternalMessageInfo
+
+func (m *KeyRequest) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+func (m *KeyRequest) GetDatabase() string {
+	if m != nil {
+		return m.Database
+	}
+	return ""
+}
+
+func (m *KeyRequest) GetTable() string {
+	if m != nil {
+		return m.Table
+	}
+	return ""
+}
+
+func (m *KeyRequest) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+type QueryRequest struct {
+	Owner                string   `protobuf:"bytes,1,opt,name=owner" json:"owner,omitempty"`
+	Database             string   `protobuf:"bytes,2,opt,name=database" json:"database,omitempty"`
+	Query                string   `protobuf:"bytes,3,opt,name=query" json:"query,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *QueryRequest) Reset()         { *m = QueryRequest{} }
+func (m *QueryRequest) String() string { return proto.CompactTextString(m) }
+func (*QueryRequest) ProtoMessage()    {}
+func (*QueryRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_swarmdb_a87223fc97aa7589, []int{7}
+}
+func (m *QueryRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_QueryRequest.Unmarshal(m, b)
+}
+func (m *QueryRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_QueryRequest.Marshal(b, m, deterministic)
+}
+func (dst *QueryRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_QueryRequest.Merge(dst, src)
+}
+func (m *QueryRequest) XXX_Size() int {
+	return xxx_messageInfo_QueryRequest.Size(m)
+}
+func (m *QueryRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_QueryRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_QueryRequest proto.InternalMessageInfo
+
+func (m *QueryRequest) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+func (m *QueryRequest) GetDatabase() string {
+	if m != nil {
+		return m.Database
+	}
+	return ""
+}
+
+func (m *QueryRequest) GetQuery() string {
+	if m != nil {
+		return m.Query
+	}
+	return ""
+}
+
+type Row struct {
+	RowJson              []byte   `protobuf:"bytes,1,opt,name=row_json,json=rowJson,proto3" json:"row_json,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Row) Reset()         { *m = Row{} }
+func (m *Row) String() string { return proto.CompactTextString(m) }
+func (*Row) ProtoMessage()    {}
+func (*Row) Descriptor() ([]byte, []int) {
+	return fileDescriptor_swarmdb_a87223fc97aa7589, []int{8}
+}
+func (m *Row) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Row.Unmarshal(m, b)
+}
+func (m *Row) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Row.Marshal(b, m, deterministic)
+}
+func (dst *Row) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Row.Merge(dst, src)
+}
+func (m *Row) XXX_Size() int {
+	return xxx_messageInfo_Row.Size(m)
+}
+func (m *Row) XXX_DiscardUnknown() {
+	xxx_messageInfo_Row.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Row proto.InternalMessageInfo
+
+func (m *Row) GetRowJson() []byte {
+	if m != nil {
+		return m.RowJson
+	}
+	return nil
+}
+
+type Response struct {
+	AffectedRowCount     int32    `protobuf:"varint,1,opt,name=affected_row_count,json=affectedRowCount" json:"affected_row_count,omitempty"`
+	MatchedRowCount      int32    `protobuf:"varint,2,opt,name=matched_row_count,json=matchedRowCount" json:"matched_row_count,omitempty"`
+	Rows                 []*Row   `protobuf:"bytes,3,rep,name=rows" json:"rows,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Response) Reset()         { *m = Response{} }
+func (m *Response) String() string { return proto.CompactTextString(m) }
+func (*Response) ProtoMessage()    {}
+func (*Response) Descriptor() ([]byte, []int) {
+	return fileDescriptor_swarmdb_a87223fc97aa7589, []int{9}
+}
+func (m *Response) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Response.Unmarshal(m, b)
+}
+func (m *Response) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Response.Marshal(b, m, deterministic)
+}
+func (dst *Response) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Response.Merge(dst, src)
+}
+func (m *Response) XXX_Size() int {
+	return xxx_messageInfo_Response.Size(m)
+}
+func (m *Response) XXX_DiscardUnknown() {
+	xxx_messageInfo_Response.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Response proto.InternalMessageInfo
+
+func (m *Response) GetAffectedRowCount() int32 {
+	if m != nil {
+		return m.AffectedRowCount
+	}
+	return 0
+}
+
+func (m *Response) GetMatchedRowCount() int32 {
+	if m != nil {
+		return m.MatchedRowCount
+	}
+	return 0
+}
+
+func (m *Response) GetRows() []*Row {
+	if m != nil {
+		return m.Rows
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*ChallengeRequest)(nil), "swarmdbpb.ChallengeRequest")
+	proto.RegisterType((*ChallengeResponse)(nil), "swarmdbpb.ChallengeResponse")
+	proto.RegisterType((*Column)(nil), "swarmdbpb.Column")
+	proto.RegisterType((*TableRequest)(nil), "swarmdbpb.TableRequest")
+	proto.RegisterType((*CreateTableRequest)(nil), "swarmdbpb.CreateTableRequest")
+	proto.RegisterType((*PutRequest)(nil), "swarmdbpb.PutRequest")
+	proto.RegisterType((*KeyRequest)(nil), "swarmdbpb.KeyRequest")
+	proto.RegisterType((*QueryRequest)(nil), "swarmdbpb.QueryRequest")
+	proto.RegisterType((*Row)(nil), "swarmdbpb.Row")
+	proto.RegisterType((*Response)(nil), "swarmdbpb.Response")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for SwarmDB service
+
+type SwarmDBClient interface {
+	Challenge(ctx context.Context, in *ChallengeRequest, opts ...grpc.CallOption) (*ChallengeResponse, error)
+	CreateTable(ctx context.Context, in *CreateTableRequest, opts ...grpc.CallOption) (*Response, error)
+	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*Response, error)
+	Get(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Response, error)
+	Delete(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Response, error)
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (SwarmDB_QueryClient, error)
+	Scan(ctx context.Context, in *TableRequest, opts ...grpc.CallOption) (SwarmDB_ScanClient, error)
+}
+
+type swarmDBClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewSwarmDBClient(cc *grpc.ClientConn) SwarmDBClient {
+	return &swarmDBClient{cc}
+}
+
+func (c *swarmDBClient) Challenge(ctx context.Context, in *ChallengeRequest, opts ...grpc.CallOption) (*ChallengeResponse, error) {
+	out := new(ChallengeResponse)
+	err := grpc.Invoke(ctx, "/swarmdbpb.SwarmDB/Challenge", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *swarmDBClient) CreateTable(ctx context.Context, in *CreateTableRequest, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := grpc.Invoke(ctx, "/swarmdbpb.SwarmDB/CreateTable", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *swarmDBClient) Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := grpc.Invoke(ctx, "/swarmdbpb.SwarmDB/Put", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *swarmDBClient) Get(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := grpc.Invoke(ctx, "/swarmdbpb.SwarmDB/Get", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *swarmDBClient) Delete(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := grpc.Invoke(ctx, "/swarmdbpb.SwarmDB/Delete", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *swarmDBClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (SwarmDB_QueryClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_SwarmDB_serviceDesc.Streams[0], c.cc, "/swarmdbpb.SwarmDB/Query", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &swarmDBQueryClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type SwarmDB_QueryClient interface {
+	Recv() (*Row, error)
+	grpc.ClientStream
+}
+
+type swarmDBQueryClient struct {
+	grpc.ClientStream
+}
+
+func (x *swarmDBQueryClient) Recv() (*Row, error) {
+	m := new(Row)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *swarmDBClient) Scan(ctx context.Context, in *TableRequest, opts ...grpc.CallOption) (SwarmDB_ScanClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_SwarmDB_serviceDesc.Streams[1], c.cc, "/swarmdbpb.SwarmDB/Scan", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &swarmDBScanClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type SwarmDB_ScanClient interface {
+	Recv() (*Row, error)
+	grpc.ClientStream
+}
+
+type swarmDBScanClient struct {
+	grpc.ClientStream
+}
+
+func (x *swarmDBScanClient) Recv() (*Row, error) {
+	m := new(Row)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Server API for SwarmDB service
+
+type SwarmDBServer interface {
+	Challenge(context.Context, *ChallengeRequest) (*ChallengeResponse, error)
+	CreateTable(context.Context, *CreateTableRequest) (*Response, error)
+	Put(context.Context, *PutRequest) (*Response, error)
+	Get(context.Context, *KeyRequest) (*Response, error)
+	Delete(context.Context, *KeyRequest) (*Response, error)
+	Query(*QueryRequest, SwarmDB_QueryServer) error
+	Scan(*TableRequest, SwarmDB_ScanServer) error
+}
+
+func RegisterSwarmDBServer(s *grpc.Server, srv SwarmDBServer) {
+	s.RegisterService(&_SwarmDB_serviceDesc, srv)
+}
+
+func _SwarmDB_Challenge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChallengeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SwarmDBServer).Challenge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/swarmdbpb.SwarmDB/Challenge",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SwarmDBServer).Challenge(ctx, req.(*ChallengeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SwarmDB_CreateTable_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateTableRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SwarmDBServer).CreateTable(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/swarmdbpb.SwarmDB/CreateTable",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SwarmDBServer).CreateTable(ctx, req.(*CreateTableRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SwarmDB_Put_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SwarmDBServer).Put(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/swarmdbpb.SwarmDB/Put",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SwarmDBServer).Put(ctx, req.(*PutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SwarmDB_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SwarmDBServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/swarmdbpb.SwarmDB/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SwarmDBServer).Get(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SwarmDB_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SwarmDBServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/swarmdbpb.SwarmDB/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SwarmDBServer).Delete(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SwarmDB_Query_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(QueryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SwarmDBServer).Query(m, &swarmDBQueryServer{stream})
+}
+
+type SwarmDB_QueryServer interface {
+	Send(*Row) error
+	grpc.ServerStream
+}
+
+type swarmDBQueryServer struct {
+	grpc.ServerStream
+}
+
+func (x *swarmDBQueryServer) Send(m *Row) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _SwarmDB_Scan_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TableRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SwarmDBServer).Scan(m, &swarmDBScanServer{stream})
+}
+
+type SwarmDB_ScanServer interface {
+	Send(*Row) error
+	grpc.ServerStream
+}
+
+type swarmDBScanServer struct {
+	grpc.ServerStream
+}
+
+func (x *swarmDBScanServer) Send(m *Row) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _SwarmDB_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "swarmdbpb.SwarmDB",
+	HandlerType: (*SwarmDBServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Challenge",
+			Handler:    _SwarmDB_Challenge_Handler,
+		},
+		{
+			MethodName: "CreateTable",
+			Handler:    _SwarmDB_CreateTable_Handler,
+		},
+		{
+			MethodName: "Put",
+			Handler:    _SwarmDB_Put_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _SwarmDB_Get_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _SwarmDB_Delete_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Query",
+			Handler:       _SwarmDB_Query_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Scan",
+			Handler:       _SwarmDB_Scan_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "swarmdb.proto",
+}
+
+func init() { proto.RegisterFile("swarmdb.proto", fileDescriptor_swarmdb_a87223fc97aa7589) }
+
+var fileDescriptor_swarmdb_a87223fc97aa7589 = []byte{
+	// 518 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x54, 0xdd, 0x8e, 0xd3, 0x3c,
+	0x10, 0x55, 0x9a, 0xfe, 0x65, 0xba, 0xdf, 0x47, 0x6b, 0x40, 0x84, 0xb2, 0x2b, 0xaa, 0x5c, 0x55,
+	0x80, 0x2a, 0xb6, 0xf0, 0x02, 0xd0, 0x15, 0x48, 0x20, 0x50, 0xf1, 0xae, 0xb8, 0xad, 0xdc, 0x74,
+	0xca, 0x16, 0x9a, 0x38, 0x6b, 0x3b, 0x4a, 0x7b, 0xcd, 0x0d, 0x3c, 0x01, 0xaf, 0x8b, 0xe2, 0xc4,
+	0xa9, 0xbb, 0x3f, 0x17, 0x88, 0xe5, 0x2e, 0x73, 0xce, 0x9c, 0x39, 0xe3, 0xf1, 0x38, 0xf0, 0x9f,
+	0xcc, 0x98, 0x88, 0x16, 0xf3, 0x51, 0x22, 0xb8, 0xe2, 0xc4, 0x2b, 0xc3, 0x64, 0x1e, 0x10, 0xe8,
+	0x4e, 0xce, 0xd9, 0x7a, 0x8d, 0xf1, 0x17, 0xa4, 0x78, 0x91, 0xa2, 0x54, 0xc1, 0x14, 0x7a, 0x16,
+	0x26, 0x13, 0x1e, 0x4b, 0x24, 0x87, 0xe0, 0x85, 0x06, 0xf4, 0x9d, 0x81, 0x33, 0xf4, 0xe8, 0x0e,
+	0x20, 0x47, 0x00, 0xb8, 0x49, 0x56, 0x02, 0xe5, 0x2c, 0x92, 0x7e, 0x6d, 0xe0, 0x0c, 0x5d, 0xea,
+	0x95, 0xc8, 0x07, 0x19, 0x7c, 0x77, 0xa0, 0x39, 0xe1, 0xeb, 0x34, 0x8a, 0xc9, 0x63, 0xe8, 0x84,
+	0xfa, 0x6b, 0x16, 0xb3, 0xc8, 0x54, 0x82, 0x02, 0xfa, 0xc8, 0x22, 0xb4, 0x12, 0xd4, 0x36, 0x41,
+	0xbf, 0x66, 0x27, 0x9c, 0x6d, 0x13, 0xed, 0xb5, 0x8a, 0x17, 0xb8, 0x29, 0x78, 0xb7, 0x68, 0x45,
+	0x23, 0x9a, 0xf6, 0xa1, 0x95, 0x88, 0x55, 0xc4, 0xc4, 0xd6, 0xaf, 0x0f, 0x9c, 0x61, 0x83, 0x9a,
+	0x30, 0xf8, 0x0c, 0x07, 0x67, 0x6c, 0xbe, 0x36, 0xe7, 0x24, 0xf7, 0xa0, 0xc1, 0xb3, 0x18, 0x45,
+	0xd9, 0x44, 0x11, 0x90, 0x3e, 0xb4, 0x17, 0x4c, 0xb1, 0x39, 0x93, 0xc6, 0xbc, 0x8a, 0x73, 0x85,
+	0xca, 0x2b, 0x94, 0xae, 0x45, 0x10, 0xfc, 0x74, 0x80, 0x4c, 0x04, 0x32, 0x85, 0xff, 0xa2, 0x3c,
+	0x79, 0x0a, 0xad, 0xe2, 0xf4, 0xd2, 0xaf, 0x0f, 0xdc, 0x61, 0x67, 0xdc, 0x1b, 0x55, 0xf7, 0x37,
+	0x2a, 0xa6, 0x4a, 0x4d, 0x46, 0xb0, 0x01, 0x98, 0xa6, 0xea, 0xb6, 0x5b, 0x08, 0xa0, 0x2e, 0x78,
+	0x66, 0xfc, 0xff, 0xb7, 0xfc, 0x29, 0xcf, 0xa8, 0xe6, 0x82, 0x25, 0xc0, 0x7b, 0xdc, 0xde, 0xb6,
+	0x73, 0x17, 0xdc, 0x6f, 0x58, 0xdc, 0xa4, 0x47, 0xf3, 0xcf, 0xfc, 0x16, 0x3f, 0xa5, 0x28, 0xfe,
+	0xce, 0xe9, 0x22, 0xaf, 0x60, 0x9c, 0x74, 0x10, 0x0c, 0xc0, 0xa5, 0x3c, 0x23, 0x0f, 0xa1, 0x2d,
+	0x78, 0x36, 0xfb, 0x2a, 0x79, 0xac, 0x2b, 0x1e, 0xd0, 0x96, 0xe0, 0xd9, 0x3b, 0xc9, 0xe3, 0xe0,
+	0x87, 0x03, 0xed, 0xea, 0x3d, 0x3c, 0x03, 0xc2, 0x96, 0x4b, 0x0c, 0x15, 0x2e, 0x66, 0xb9, 0x20,
+	0xe4, 0x69, 0xac, 0xb4, 0xa2, 0x41, 0xbb, 0x86, 0xa1, 0x3c, 0x9b, 0xe4, 0x38, 0x79, 0x02, 0xbd,
+	0x88, 0xa9, 0xf0, 0x7c, 0x2f, 0xb9, 0xa6, 0x93, 0xef, 0x94, 0x44, 0x95, 0x6b, 0x86, 0xed, 0xde,
+	0x3c, 0xec, 0xf1, 0x2f, 0x17, 0x5a, 0xa7, 0x39, 0x7e, 0xf2, 0x9a, 0xbc, 0x01, 0xaf, 0x7a, 0xae,
+	0xe4, 0x91, 0xbd, 0x1b, 0x97, 0x1e, 0x76, 0xff, 0xf0, 0x7a, 0xb2, 0x3c, 0xd1, 0x2b, 0xe8, 0x58,
+	0x5b, 0x4c, 0x8e, 0xec, 0xe4, 0x2b, 0xdb, 0xdd, 0xbf, 0x6b, 0xf7, 0x65, 0x4a, 0x1c, 0x83, 0x3b,
+	0x4d, 0x15, 0xb9, 0x6f, 0x71, 0xbb, 0x6d, 0xbc, 0x51, 0xf2, 0x16, 0xf7, 0x25, 0xbb, 0x35, 0xba,
+	0x5e, 0xf2, 0x12, 0x9a, 0x27, 0xb8, 0x46, 0x85, 0x7f, 0xa4, 0x1a, 0x43, 0x43, 0xef, 0x0d, 0x79,
+	0x60, 0xb1, 0xf6, 0x26, 0xf5, 0x2f, 0x8d, 0xfa, 0xb9, 0x43, 0x8e, 0xa1, 0x7e, 0x1a, 0xb2, 0x78,
+	0x4f, 0xb2, 0x37, 0x85, 0x2b, 0x92, 0x79, 0x53, 0xff, 0x62, 0x5f, 0xfc, 0x1e, 0x00, 0x61, 0xf1,
+	0xcc, 0x19, 0x73, 0x05, 0x00, 0x00,
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdbwire
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+)
+
+// Capability is a signed, expiring grant of scoped access to the tables of Owner.  Owner mints it with its key (see
+// swarmdblib.MintCapability) and hands the token to a third party, which sends it in Request.Capability; the server
+// checks the signature and the scope instead of requiring a session authenticated with Owner's key.
+type Capability struct {
+	Owner      string `json:"owner"`               // address whose key signs the token
+	Database   string `json:"database"`            // the database the token grants access to
+	Table      string `json:"table,omitempty"`     // the one table granted, or every table of Database when empty
+	KeyPrefix  string `json:"keyPrefix,omitempty"` // only rows whose primary key, as a string, starts with it
+	Permission string `json:"permission"`          // "read", "write" or "read,write"
+	ExpiresMs  int64  `json:"expiresMs"`           // unix milliseconds after which the token is refused
+	Nonce      string `json:"nonce,omitempty"`     // tells apart tokens of the same scope
+	Signature  string `json:"signature,omitempty"` // hex signature by Owner of SigningPayload, as swarmdb.SignHash
+}
+
+// SigningPayload returns the bytes Owner signs: the token without its signature, as JSON
+func (c Capability) SigningPayload() []byte {
+	c.Signature = ""
+	payload, _ := json.Marshal(c)
+	return payload
+}
+
+// EncodeCapability returns the token of c, base64url encoded JSON
+func EncodeCapability(c Capability) (token string) {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCapability parses a token made by EncodeCapability; the signature is not checked
+func DecodeCapability(token string) (c Capability, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil {
+		return c, &sdbc.SWARMDBError{Message: fmt.Sprintf("[capability:DecodeCapability] %s", err.Error()), ErrorCode: 489, ErrorMessage: "Authentication Required: malformed capability token"}
+	}
+	return c, nil
+}
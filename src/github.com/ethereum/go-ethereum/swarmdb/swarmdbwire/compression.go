@@ -0,0 +1,121 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdbwire
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"github.com/golang/snappy"
+	"io"
+	"io/ioutil"
+)
+
+// Compression is negotiated with an RT_COMPRESSION request listing the client's algorithms in order of preference;
+// the server answers (uncompressed) with a single row {"compression": <chosen>}.  From the next message on, both
+// sides exchange frames: a 4 byte big endian length followed by the compressed message.
+const (
+	RT_COMPRESSION = "Compression"
+
+	COMPRESSION_NONE   = ""
+	COMPRESSION_GZIP   = "gzip"
+	COMPRESSION_SNAPPY = "snappy"
+
+	MAX_FRAME_SIZE = 64 << 20
+)
+
+// SupportedCompressions in the server's order of preference
+var SupportedCompressions = []string{COMPRESSION_SNAPPY, COMPRESSION_GZIP}
+
+// ChooseCompression returns the first of the client's algorithms the server supports, or COMPRESSION_NONE
+func ChooseCompression(offered []string) string {
+	for _, alg := range offered {
+		for _, supported := range SupportedCompressions {
+			if alg == supported {
+				return alg
+			}
+		}
+	}
+	return COMPRESSION_NONE
+}
+
+// WriteMessage writes msg as a line, or as a compressed frame once compression is negotiated
+func WriteMessage(w *bufio.Writer, compression string, msg []byte) (err error) {
+	switch compression {
+	case COMPRESSION_NONE:
+		_, err = w.Write(append(msg, '\n'))
+	case COMPRESSION_SNAPPY:
+		err = writeFrame(w, snappy.Encode(nil, msg))
+	case COMPRESSION_GZIP:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err = zw.Write(msg); err == nil {
+			err = zw.Close()
+		}
+		if err == nil {
+			err = writeFrame(w, buf.Bytes())
+		}
+	default:
+		return fmt.Errorf("unsupported compression %s", compression)
+	}
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// ReadMessage reads one message written by WriteMessage with the same compression
+func ReadMessage(r *bufio.Reader, compression string) (msg []byte, err error) {
+	if compression == COMPRESSION_NONE {
+		msg, err = r.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimSpace(msg), nil
+	}
+	var size uint32
+	if err = binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size > MAX_FRAME_SIZE {
+		return nil, fmt.Errorf("frame of %d bytes exceeds %d", size, MAX_FRAME_SIZE)
+	}
+	frame := make([]byte, size)
+	if _, err = io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	switch compression {
+	case COMPRESSION_SNAPPY:
+		return snappy.Decode(nil, frame)
+	case COMPRESSION_GZIP:
+		zr, err := gzip.NewReader(bytes.NewReader(frame))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(zr)
+	}
+	return nil, fmt.Errorf("unsupported compression %s", compression)
+}
+
+func writeFrame(w *bufio.Writer, frame []byte) (err error) {
+	if err = binary.Write(w, binary.BigEndian, uint32(len(frame))); err != nil {
+		return err
+	}
+	_, err = w.Write(frame)
+	return err
+}
@@ -0,0 +1,317 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package swarmdbwire holds the request/response envelope shared by the SWARMDB servers and swarmdblib.
+package swarmdbwire
+
+import (
+	"errors"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+)
+
+const (
+	STATUS_OK    = "ok"
+	STATUS_ERROR = "error"
+
+	RT_BATCH = "Batch" // Request.Batch holds the operations, Response.Results their outcomes in the same order
+	RT_ADMIN = "Admin" // Request.Command names the admin command, see swarmdb.ADMIN_*
+	RT_USE   = "Use"   // sets the connection's default Owner/Database, given as fields or as RawQuery "USE owner/database"
+
+	RT_SCAN_RANGE = "ScanRange" // Request.Range bounds the scan, Response.NextToken continues it
+	RT_PING       = "Ping"      // answered with the single row {"pong": <server unix milliseconds>}, also before authentication
+	RT_INCREMENT  = "Increment" // Key names the row, Rows[0] maps counter columns to deltas; answered with the updated row
+	RT_VERSIONS   = "Versions"  // Key names the row, optional Rows[0] {"asof": unix milliseconds}; answered newest first
+	RT_GET_MULTI  = "GetMulti"  // Key lists primary keys; answered with the rows found, in the order of the keys
+
+	// RT_TABLE_INFO describes the table, opened on demand; answered with one {"owner", "database", "table",
+	// "roothash", "rowcount", "columns"} row, with a {"ColumnName", "ColumnType", "IndexType", "Primary", "roothash"}
+	// row per column in "columns"
+	RT_TABLE_INFO = "TableInfo"
+
+	// RT_FLUSH_POLICY sets the automatic flush policy of the table to Rows[0] {"mutations", "bytes", "seconds"}, or
+	// without Rows reads it; answered with the policy row
+	RT_FLUSH_POLICY = "FlushPolicy"
+
+	// RT_ENCRYPT_COLUMNS encrypts the columns named in Rows[0] {"columns": [...]}, and no others, in the rows written
+	// from now on, or without Rows lists them; answered with a {"column"} row per encrypted column
+	RT_ENCRYPT_COLUMNS = "EncryptColumns"
+
+	// RT_RESTRICT_COLUMNS restricts reading the columns named in Rows[0] {"columns": [...]}, and no others, to the
+	// owner and addresses granted "restricted", or without Rows lists them; answered with a {"column"} row per column
+	RT_RESTRICT_COLUMNS = "RestrictColumns"
+
+	// RT_COLUMN_FAMILIES puts the columns of Rows[0] {"families": {"column": family, ...}}, and no others, in the
+	// given column families for the rows written from now on, or without Rows lists them; answered with a
+	// {"column", "family"} row per column outside family 0
+	RT_COLUMN_FAMILIES = "ColumnFamilies"
+
+	// RT_EXPIRY_COLUMN makes the rows expire at the unix milliseconds of the column of Rows[0] {"column"}, or never
+	// when it is "", or without Rows reads it; answered with a {"column"} row when rows expire
+	RT_EXPIRY_COLUMN = "ExpiryColumn"
+
+	// RT_TIMESTAMP_COLUMNS has the server set the columns of Rows[0] {"created", "updated"}, "" for none, to the time
+	// of the first and of the last write of every row written from now on, or without Rows reads them; answered with
+	// a {"created", "updated"} row
+	RT_TIMESTAMP_COLUMNS = "TimestampColumns"
+
+	// RT_REPLICATION keeps the chunks of the table written from now on in Rows[0] {"min", "max"} replicas, 0 and 0
+	// for the replication of their writer, or without Rows reads it; answered with a {"min", "max"} row
+	RT_REPLICATION = "Replication"
+
+	// RT_CHANGES reads the change stream of the table after the position of optional Rows[0] {"after", "limit"};
+	// answered with a {"position", "op", "key", "before", "after", "roothash", "version", "time"} row per change
+	RT_CHANGES = "Changes"
+
+	// Keyspaces are schemaless maps of string keys to string values, see swarmdb.Keyspace: Table names the keyspace of
+	// Owner, Key the key.  RT_CREATE_KEYSPACE takes the index type of optional Columns[0], a B+tree by default;
+	// RT_PUT_KV the value of Rows[0] {"value"}; RT_ITERATE_KV optional Rows[0] {"start", "limit"}.  Pairs are
+	// answered as {"key", "value"} rows
+	RT_CREATE_KEYSPACE = "CreateKeyspace"
+	RT_PUT_KV          = "PutKV"
+	RT_GET_KV          = "GetKV"
+	RT_DELETE_KV       = "DeleteKV"
+	RT_ITERATE_KV      = "IterateKV"
+
+	// Collections are sets of JSON documents, see swarmdb.Collection: Table names the collection of Owner.
+	// RT_INSERT_DOC inserts the documents Rows, creating the collection, and answers their {"_id"}; RT_FIND_DOCS
+	// answers the documents matching the filter RawQuery, a JSON object, at most optional Rows[0] {"limit"};
+	// RT_CREATE_DOC_INDEX indexes the dotted path Key
+	RT_INSERT_DOC       = "InsertDoc"
+	RT_FIND_DOCS        = "FindDocs"
+	RT_CREATE_DOC_INDEX = "CreateDocIndex"
+
+	// Graphs are sets of labelled edges between nodes, see swarmdb.Graph: Table names the graph of Owner.
+	// RT_PUT_EDGE stores, and RT_DELETE_EDGE removes, the edges Rows {"src", "dst", "label", "props"}, the first
+	// creating the graph; RT_TRAVERSE walks from the node Key, optional Rows[0] {"depth", "direction", "labels",
+	// "where"}, and answers a {"src", "dst", "label", "props", "depth"} row per edge followed
+	RT_PUT_EDGE    = "PutEdge"
+	RT_DELETE_EDGE = "DeleteEdge"
+	RT_TRAVERSE    = "Traverse"
+
+	// RT_IMPORT_CSV loads RawQuery, CSV text with a header line, into the table; optional Rows[0] maps CSV header
+	// names to column names.  Answered with the imported row count and a {"record", "error"} row per rejected record
+	RT_IMPORT_CSV = "ImportCSV"
+
+	// Blobs are binary objects of any size kept in a string column of a table as the hash of their manifest, see
+	// swarmdb.Table.PutBlob.  RT_PUT_BLOB_PART stores Rows[0] {"data"}, base64 of at most BLOB_PART_SIZE bytes, and
+	// answers {"part"}; RT_PUT_BLOB joins Rows[0] {"column", "parts"}, every part but the last full, into the blob of
+	// the row Key and answers {"manifest", "size"}; RT_GET_BLOB answers {"manifest", "size", "offset", "data"} for
+	// optional Rows[0] {"offset", "length"} of the blob in Rows[0] {"column"} of the row Key
+	RT_PUT_BLOB_PART = "PutBlobPart"
+	RT_PUT_BLOB      = "PutBlob"
+	RT_GET_BLOB      = "GetBlob"
+	BLOB_PART_SIZE   = 127 * 4080 // bytes of a blob each RT_PUT_BLOB_PART but the last carries
+
+	// transactions span requests on one connection: RT_BEGIN names the table, whose writes are then buffered until
+	// RT_COMMIT or RT_ROLLBACK; closing the connection rolls back
+	RT_BEGIN    = "Begin"
+	RT_COMMIT   = "Commit"
+	RT_ROLLBACK = "Rollback"
+)
+
+// ErrorCode is the stable, client visible classification of an error.  The numeric SWARMDBError codes
+// are kept in ErrorNumber for diagnostics but may change between releases; clients should switch on ErrorCode.
+type ErrorCode string
+
+const (
+	ErrNoSuchTable    ErrorCode = "NoSuchTable"
+	ErrNoSuchDatabase ErrorCode = "NoSuchDatabase"
+	ErrNoSuchColumn   ErrorCode = "NoSuchColumn"
+	ErrDuplicateKey   ErrorCode = "DuplicateKey"
+	ErrNotFound       ErrorCode = "NotFound"
+	ErrBadRequest     ErrorCode = "BadRequest"
+	ErrUnauthorized   ErrorCode = "Unauthorized"
+	ErrAccessDenied   ErrorCode = "AccessDenied"
+	ErrThrottled      ErrorCode = "Throttled"
+	ErrTimeout        ErrorCode = "Timeout"
+	ErrUnavailable    ErrorCode = "Unavailable"
+	ErrAborted        ErrorCode = "Aborted"
+	ErrConflict       ErrorCode = "Conflict"
+	ErrBudgetExceeded ErrorCode = "BudgetExceeded"
+	ErrTooLarge       ErrorCode = "TooLarge"
+	ErrInternal       ErrorCode = "Internal"
+)
+
+// errorCodes maps SWARMDBError.ErrorCode values onto the stable enumeration; unlisted codes are Internal
+var errorCodes = map[int]ErrorCode{
+	403: ErrNoSuchTable,
+	481: ErrNoSuchTable,
+	482: ErrNoSuchTable,
+	443: ErrNoSuchDatabase,
+	404: ErrNoSuchColumn,
+	405: ErrBadRequest,
+	406: ErrBadRequest,
+	407: ErrBadRequest,
+	408: ErrBadRequest,
+	409: ErrBadRequest,
+	417: ErrBadRequest,
+	418: ErrBadRequest,
+	425: ErrBadRequest,
+	428: ErrBadRequest,
+	429: ErrBadRequest,
+	432: ErrBadRequest,
+	433: ErrBadRequest,
+	435: ErrBadRequest,
+	448: ErrBadRequest,
+	492: ErrBadRequest,
+	419: ErrUnauthorized,
+	420: ErrUnauthorized,
+	421: ErrUnauthorized,
+	489: ErrUnauthorized,
+	490: ErrAccessDenied,
+	493: ErrThrottled,
+	488: ErrUnavailable,
+	494: ErrUnavailable,
+	495: ErrAborted,
+	496: ErrTimeout,
+	497: ErrAborted,
+	498: ErrNotFound,
+	499: ErrConflict,
+	501: ErrBadRequest,
+	502: ErrBadRequest,
+	503: ErrBadRequest,
+	504: ErrUnavailable,
+	505: ErrBadRequest,
+	506: ErrBadRequest,
+	507: ErrAccessDenied,
+	508: ErrBadRequest,
+	509: ErrBudgetExceeded,
+	510: ErrTooLarge,
+	511: ErrTooLarge,
+}
+
+// Request is a RequestOption with an optional client chosen id that is echoed in the Response.
+// TraceID tags the server's log lines for the request; the server generates one when it is empty.
+type Request struct {
+	RequestID string `json:"requestId,omitempty"`
+	TraceID   string `json:"traceId,omitempty"`
+	Deadline  int64  `json:"deadline,omitempty"` // unix milliseconds after which the client no longer waits for the response
+
+	// IdempotencyKey, chosen by the client, makes the server apply a write once however often it is sent with the key
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+
+	// Capability, a token from EncodeCapability, runs the request as the Owner of the token within its scope, on any
+	// session and without the Owner's key
+	Capability string `json:"capability,omitempty"`
+	sdbc.RequestOption
+	Batch  []Request `json:"batch,omitempty"`  // operations of an RT_BATCH request
+	Atomic bool      `json:"atomic,omitempty"` // RT_BATCH: all writes to a table commit together or not at all
+
+	Compression []string   `json:"compression,omitempty"` // RT_COMPRESSION: algorithms offered by the client
+	Command     string     `json:"command,omitempty"`     // RT_ADMIN: the admin command to run
+	Version     int        `json:"version,omitempty"`     // RT_HELLO: the highest protocol version of the client
+	Range       *ScanRange `json:"range,omitempty"`       // RT_SCAN_RANGE, or RT_SCAN to page it: the bounds of the scan
+}
+
+// ScanRange selects the primary keys in [Start, End) (nil bounds are open), at most Limit rows per page.
+// Token, from Response.NextToken, resumes a scan with the same bounds and direction, reading the table as the first
+// page did.
+type ScanRange struct {
+	Start     interface{} `json:"start,omitempty"`
+	End       interface{} `json:"end,omitempty"`
+	Limit     int         `json:"limit,omitempty"`
+	Ascending int         `json:"ascending"` // 1 ascending, 0 descending
+	Token     string      `json:"token,omitempty"`
+}
+
+// Response is the envelope of every server reply
+type Response struct {
+	RequestID        string     `json:"requestId,omitempty"`
+	TraceID          string     `json:"traceId,omitempty"`
+	Status           string     `json:"status"`
+	ErrorCode        ErrorCode  `json:"errorCode,omitempty"`
+	ErrorNumber      int        `json:"errorNumber,omitempty"`
+	ErrorMessage     string     `json:"errorMessage,omitempty"`
+	Data             []sdbc.Row `json:"data,omitempty"`
+	AffectedRowCount int        `json:"affectedRowCount,omitempty"`
+	MatchedRowCount  int        `json:"matchedRowCount,omitempty"`
+	Results          []Response `json:"results,omitempty"`   // per operation responses of an RT_BATCH request
+	NextToken        string     `json:"nextToken,omitempty"` // RT_SCAN_RANGE: continues the scan, empty after the last page
+	Replayed         bool       `json:"replayed,omitempty"`  // the result of an earlier request with the same IdempotencyKey
+}
+
+// Error is the client side form of an error Response
+type Error struct {
+	RequestID string
+	Code      ErrorCode
+	Number    int
+	Message   string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Code, e.Number, e.Message)
+}
+
+// Is matches errors with the same Code, so that errors.Is(err, swarmdblib.ErrNoSuchTable) works on any server error
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+func NewResponse(requestID string, resp sdbc.SWARMDBResponse) Response {
+	return Response{RequestID: requestID, Status: STATUS_OK, Data: resp.Data, AffectedRowCount: resp.AffectedRowCount, MatchedRowCount: resp.MatchedRowCount}
+}
+
+func NewErrorResponse(requestID string, err error) Response {
+	e := ErrorOf(err)
+	return Response{RequestID: requestID, Status: STATUS_ERROR, ErrorCode: e.Code, ErrorNumber: e.Number, ErrorMessage: e.Message}
+}
+
+// ErrorOf classifies err as the *Error a client receives for it, looking through errors wrapped with fmt.Errorf's %w
+// for a SWARMDBError, a DuplicateKeyError or KeyNotFoundError, or an *Error; anything else is Internal.  It lets
+// callers inside the server match errors the way clients do: errors.Is(wire.ErrorOf(err), swarmdblib.ErrNotFound)
+func ErrorOf(err error) *Error {
+	var (
+		sErr      *sdbc.SWARMDBError
+		dupErr    *sdbc.DuplicateKeyError
+		notFound  *sdbc.KeyNotFoundError
+		wireError *Error
+	)
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &wireError) && wireError != nil:
+		return wireError
+	case errors.As(err, &sErr) && sErr != nil:
+		return &Error{Code: ErrorCodeOf(sErr.ErrorCode), Number: sErr.ErrorCode, Message: sErr.ErrorMessage}
+	case errors.As(err, &dupErr):
+		return &Error{Code: ErrDuplicateKey, Number: 500, Message: "Duplicate Key"}
+	case errors.As(err, &notFound):
+		return &Error{Code: ErrNotFound, Number: 500, Message: "Key Not Found"}
+	}
+	return &Error{Code: ErrInternal, Number: 500, Message: err.Error()}
+}
+
+// ErrorCodeOf classifies a SWARMDBError.ErrorCode
+func ErrorCodeOf(errorNumber int) ErrorCode {
+	if code, ok := errorCodes[errorNumber]; ok {
+		return code
+	}
+	return ErrInternal
+}
+
+// Err returns nil for an ok Response, otherwise its *Error
+func (r *Response) Err() error {
+	if r.Status == STATUS_ERROR {
+		return &Error{RequestID: r.RequestID, Code: r.ErrorCode, Number: r.ErrorNumber, Message: r.ErrorMessage}
+	}
+	return nil
+}
+
+// SWARMDBResponse strips the envelope
+func (r *Response) SWARMDBResponse() sdbc.SWARMDBResponse {
+	return sdbc.SWARMDBResponse{Data: r.Data, AffectedRowCount: r.AffectedRowCount, MatchedRowCount: r.MatchedRowCount}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdbwire
+
+import (
+	"encoding/json"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+)
+
+// Protocol versions:
+//
+//	1 - responses are a bare SWARMDBResponse, errors {"errorcode": n, "errormessage": "..."}; no request ids
+//	2 - responses are the Response envelope (request ids, trace ids, stable error codes, batch results)
+//
+// A connection speaks version 1 until the client sends RT_HELLO with its Version; the server answers in version 1
+// with the single row {"version": v}, v being the highest version both sides support, and both switch to v.
+// A server older than RT_HELLO answers with an error, upon which the client stays on version 1.
+const (
+	RT_HELLO = "Hello"
+
+	PROTOCOL_VERSION     = 2
+	MIN_PROTOCOL_VERSION = 1
+)
+
+// NegotiateVersion returns the version to speak with a client supporting up to clientVersion
+func NegotiateVersion(clientVersion int) (version int, err error) {
+	if clientVersion < MIN_PROTOCOL_VERSION {
+		return MIN_PROTOCOL_VERSION, &Error{Code: ErrBadRequest, Number: 418, Message: fmt.Sprintf("Request Invalid: protocol version %d is older than the oldest supported version %d", clientVersion, MIN_PROTOCOL_VERSION)}
+	}
+	if clientVersion > PROTOCOL_VERSION {
+		return PROTOCOL_VERSION, nil
+	}
+	return clientVersion, nil
+}
+
+// legacyError is the version 1 error encoding
+type legacyError struct {
+	ErrorCode    int    `json:"errorcode"`
+	ErrorMessage string `json:"errormessage"`
+}
+
+// EncodeResponse encodes resp in the given protocol version
+func EncodeResponse(version int, resp Response) ([]byte, error) {
+	if version >= 2 {
+		return json.Marshal(resp)
+	}
+	if resp.Status == STATUS_ERROR {
+		return json.Marshal(legacyError{ErrorCode: resp.ErrorNumber, ErrorMessage: resp.ErrorMessage})
+	}
+	return json.Marshal(resp.SWARMDBResponse())
+}
+
+// DecodeResponse decodes a response written by EncodeResponse in the given protocol version
+func DecodeResponse(version int, msg []byte) (resp Response, err error) {
+	if version >= 2 {
+		err = json.Unmarshal(msg, &resp)
+		return resp, err
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(msg, &fields); err != nil {
+		return resp, err
+	}
+	if _, ok := fields["errorcode"]; ok {
+		var e legacyError
+		if err = json.Unmarshal(msg, &e); err != nil {
+			return resp, err
+		}
+		return Response{Status: STATUS_ERROR, ErrorCode: ErrorCodeOf(e.ErrorCode), ErrorNumber: e.ErrorCode, ErrorMessage: e.ErrorMessage}, nil
+	}
+	var r sdbc.SWARMDBResponse
+	if err = json.Unmarshal(msg, &r); err != nil {
+		return resp, err
+	}
+	return NewResponse("", r), nil
+}
@@ -4,14 +4,18 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
-	"io"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 )
 
+const INDEX_PUT_PARALLELISM = 8 // secondary indexes a Put updates at once, see putSecondaryIndexes
+
 type Table struct {
 	buffered          bool
 	swarmdb           *SwarmDB
@@ -22,6 +26,30 @@ type Table struct {
 	columns           map[string]*ColumnInfo
 	primaryColumnName string
 	encrypted         int
+	pendingEvents     []TableEvent // published on FlushBuffer
+	acl               map[common.Address]uint8
+	snapshot          bool       // read-only copy pinned to flushed root hashes, see Snapshot
+	asOfMs            int64      // a snapshot reads the row versions written up to this unix millisecond
+	mu                sync.Mutex // serializes Put and Delete with Increment and automatic flushes
+	flushPolicy       FlushPolicy
+	holdFlush         bool             // the buffer belongs to a Transaction, atomic batch or Coordinator
+	dirtyMutations    int              // buffered writes since the last flush, see noteWrite
+	dirtyBytes        int              // bytes of row data buffered since the last flush
+	dirtySince        time.Time        // time of the first buffered write since the last flush
+	resolver          ConflictResolver // merges on root hash conflicts, see merge.go
+	ops               []rowOp          // writes since the last flush, logged for merge when resolver is set
+	replaySealed      map[string]bool  // while merge replays a row: its columns holding cells sealed already
+	shardSplits       [][]byte         // primary keys starting shards 1.., see shard.go
+	memtable          memtable         // rows written since the last flush, see memtable.go
+	familyMask        uint16           // column families rows may have cells in, see family.go
+	minReplication    int              // replication of the chunks of the table, 0 for the writer's, see replication.go
+	maxReplication    int
+	refs              int32            // AcquireTable references that keep the table open, see tablecache.go
+	lastUsed          int64            // unix nanoseconds GetTable last returned the table, for EvictIdle
+
+	histograms      map[string]*Histogram // of the ordered indexes, for the planner, see histogram.go
+	histogramWrites int                   // writes since the histograms were built
+	histogramsMu    sync.RWMutex
 }
 
 type ColumnInfo struct {
@@ -31,6 +59,11 @@ type ColumnInfo struct {
 	dbaccess   Database
 	primary    uint8
 	columnType sdbc.ColumnType
+	encrypted  bool // values are sealed for the writer and not indexed, see encryption.go
+	restricted bool // read only by the owner and addresses holding ACL_RESTRICTED, see acl.go
+	family     uint8 // column family the cells are stored in, see family.go
+	expires    bool  // holds the expiry of the rows, see expiry.go
+	timestamp  uint8 // COLUMN_CREATED_AT or COLUMN_UPDATED_AT when the server sets the column, see timestamp.go
 }
 
 func (t *Table) OpenTable(u *SWARMDBUser) (err error) {
@@ -45,6 +78,7 @@ func (t *Table) OpenTable(u *SWARMDBUser) (err error) {
 	}
 
 	log.Debug(fmt.Sprintf("[table:OpenTable] opening table @ %s roothash [%x]\n", t.tableName, roothash))
+	t.roothash = roothash
 
 	if err != nil {
 		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:OpenTable] GetRootHash for table [%s]: %v", tblKey, err))
@@ -58,7 +92,11 @@ func (t *Table) OpenTable(u *SWARMDBUser) (err error) {
 		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:OpenTable] RetrieveDBChunk %s", err.Error()))
 	}
 	t.encrypted = BytesToInt(columndata[4000:4024])
-	fmt.Sprintf("[table:OpenTable] t.encrypted [%d] buf [%+v]", t.encrypted, columndata[4000:4024])
+	t.acl = readACL(columndata)
+	t.flushPolicy = readFlushPolicy(columndata)
+	t.shardSplits = readShardSplits(columndata)
+	t.familyMask = readFamilyMask(columndata)
+	t.minReplication, t.maxReplication = readReplication(columndata)
 	columnbuf := columndata
 	primaryColumnType := sdbc.ColumnType(sdbc.CT_INTEGER)
 	for i := 2048; i < 4000; i = i + 64 {
@@ -73,6 +111,13 @@ func (t *Table) OpenTable(u *SWARMDBUser) (err error) {
 		columninfo.primary = uint8(buf[26])
 		columninfo.columnType, _ = ByteToColumnType(buf[28]) //:29
 		columninfo.indexType = ByteToIndexType(buf[30])
+		columninfo.encrypted = buf[COLUMN_ENCRYPTED_OFFSET] == 1
+		columninfo.restricted = buf[COLUMN_RESTRICTED_OFFSET] == 1
+		columninfo.family = buf[COLUMN_FAMILY_OFFSET]
+		columninfo.expires = buf[COLUMN_EXPIRES_OFFSET] == 1
+		if b := buf[COLUMN_EXPIRES_OFFSET]; b == COLUMN_CREATED_AT || b == COLUMN_UPDATED_AT {
+			columninfo.timestamp = b
+		}
 		columninfo.roothash = buf[32:]
 		secondary := false
 		if columninfo.primary == 0 {
@@ -93,6 +138,8 @@ func (t *Table) OpenTable(u *SWARMDBUser) (err error) {
 			if err != nil {
 				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:OpenTable] NewHashDB %s", err.Error()))
 			}
+		case sdbc.IT_NONE:
+			columninfo.dbaccess = unindexed{}
 		}
 		t.columns[columninfo.columnName] = columninfo
 		// fmt.Printf("  --- OpenTable columns: %s ==> %v ==> %v\n", columninfo.columnName, columninfo, t.columns)
@@ -139,6 +186,11 @@ func (t *Table) byteArrayToRow(byteData []byte) (out sdbc.Row, err error) {
 			return res, &sdbc.SWARMDBError{Message: fmt.Sprintf("[table:byteArrayToRow] colName not in t.columns %s for [%s]", err.Error(), byteData), ErrorCode: 436, ErrorMessage: "Unable to converty byte array to Row Object"}
 		}
 		colDef := t.columns[colName]
+		if isSealed(cell) {
+			// sealed for another reader, see decryptColumns
+			row[colName] = cell
+			continue
+		}
 		switch a := cell.(type) {
 		case int, int8, int16, int32, int64, uint8, uint16, uint32, uint64:
 			switch colDef.columnType {
@@ -169,7 +221,7 @@ func (t *Table) byteArrayToRow(byteData []byte) (out sdbc.Row, err error) {
 			case sdbc.CT_INTEGER:
 				row[colName], err = strconv.Atoi(a)
 
-			case sdbc.CT_STRING:
+			case sdbc.CT_STRING, CT_UUID:
 				row[colName] = a
 			case sdbc.CT_FLOAT:
 				row[colName], err = strconv.ParseFloat(a, 64)
@@ -180,7 +232,7 @@ func (t *Table) byteArrayToRow(byteData []byte) (out sdbc.Row, err error) {
 	return row, nil
 }
 
-func (self *Table) buildSdata(u *SWARMDBUser, key []byte, value []byte, birthts int, version int) (mergedBodycontent []byte, err error) {
+func (self *Table) buildSdata(u *SWARMDBUser, key []byte, value []byte, birthts int, version int, prevVersion []byte) (mergedBodycontent []byte, err error) {
 	contentPrefix := BuildSwarmdbPrefix([]byte(self.Owner), []byte(self.Database), []byte(self.tableName), key)
 	log.Debug(fmt.Sprintf("[table:buildSdata] contentPrefix is: %x", contentPrefix))
 
@@ -202,6 +254,14 @@ func (self *Table) buildSdata(u *SWARMDBUser, key []byte, value []byte, birthts
 	copy(metadataBody[CHUNK_START_LASTUPDATETS:CHUNK_END_LASTUPDATETS], IntToByte(lastupdatets))
 
 	copy(metadataBody[CHUNK_START_VERSION:CHUNK_END_VERSION], IntToByte(version))
+	copy(metadataBody[CHUNK_START_PREVVERSION:CHUNK_END_PREVVERSION], prevVersion)
+	copy(metadataBody[CHUNK_START_WRITER:CHUNK_END_WRITER], common.HexToAddress(u.Address).Bytes())
+	copy(metadataBody[CHUNK_START_UPDATEMS:CHUNK_END_UPDATEMS], IntToByte(int(nowMs())))
+	if self.swarmdb.signRows {
+		if err = self.signRow(metadataBody, value); err != nil {
+			return mergedBodycontent, err
+		}
+	}
 
 	unencryptedMetadata := metadataBody[CHUNK_END_MSGHASH:CHUNK_START_CHUNKVAL]
 	msg_hash := SignHash(unencryptedMetadata)
@@ -252,6 +312,22 @@ func BuildSwarmdbPrefix(owner []byte, database []byte, table []byte, id []byte)
 }
 
 func (t *Table) Get(u *SWARMDBUser, key []byte) (out []byte, ok bool, err error) {
+	log.Debug("[table:Get]", "trace", u.TraceID(), "table", t.tableName, "key", fmt.Sprintf("%x", key))
+	if t.IsSharded() {
+		shard, err := t.shardFor(u, key)
+		if err != nil {
+			return nil, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Get] shardFor %s", err.Error()))
+		}
+		return shard.Get(u, key)
+	}
+	if !t.snapshot {
+		if value, held := t.memtable.get(key); held {
+			if value == nil || t.expiredAt(value, nowMs()) {
+				return nil, false, nil
+			}
+			return t.decryptColumns(u, t.rowJSON(value)), true, nil
+		}
+	}
 	primaryColumnName := t.primaryColumnName
 	if _, ok := t.columns[primaryColumnName]; !ok {
 		return out, false, &sdbc.SWARMDBError{Message: fmt.Sprintf("[table:Get] columns array missing %s ", primaryColumnName), ErrorCode: 479, ErrorMessage: fmt.Sprintf("Table Definition Missing Selected Column [%s]", primaryColumnName)}
@@ -264,9 +340,50 @@ func (t *Table) Get(u *SWARMDBUser, key []byte) (out []byte, ok bool, err error)
 	if !ok {
 		return out, false, nil
 	}
+	if t.asOfMs > 0 {
+		return t.getAsOf(u, key, t.asOfMs)
+	}
+	return t.readRow(u, key)
+}
+
+// readRow reads the current version of the row key, which the primary index holds, from its K-chunk
+func (t *Table) readRow(u *SWARMDBUser, key []byte) (out []byte, ok bool, err error) {
+	stored, ok, err := t.readValue(u, key)
+	if !ok || err != nil {
+		return stored, ok, err
+	}
+	return t.decryptColumns(u, t.rowJSON(stored)), true, nil
+}
+
+// readValue is readRow without decoding: the value is returned as stored, binary or JSON, with its padding trimmed.
+// Expired rows are not found.
+func (t *Table) readValue(u *SWARMDBUser, key []byte) (out []byte, ok bool, err error) {
+	out, ok, err = t.readStoredValue(u, key)
+	if ok && t.expiredAt(out, nowMs()) {
+		return nil, false, nil
+	}
+	return out, ok, err
+}
+
+// readStoredValue is readValue finding expired rows too
+func (t *Table) readStoredValue(u *SWARMDBUser, key []byte) (out []byte, ok bool, err error) {
+	if !t.snapshot {
+		if value, held := t.memtable.get(key); held {
+			return value, value != nil, nil
+		}
+	}
+	out, ok, err = t.readRowChunk(u, key)
+	if !ok || err != nil || t.familyMask == 0 {
+		return out, ok, err
+	}
+	return t.readFamilies(u, key, out, t.familyMask)
+}
+
+// readRowChunk reads the value stored in the K-chunk of k, with its padding trimmed
+func (t *Table) readRowChunk(u *SWARMDBUser, key []byte) (out []byte, ok bool, err error) {
 	chunkKey := t.GenerateKChunkKey(key)
 	log.Debug(fmt.Sprintf("[table:Get] ChunkKey generated is: %x", chunkKey))
-	contentReader, err := t.swarmdb.dbchunkstore.RetrieveKChunk(u, chunkKey)
+	contentReader, err := t.swarmdb.dbchunkstore.RetrieveKChunk(t.replicatedBy(u), chunkKey)
 	if bytes.Trim(contentReader, "\x00") == nil {
 		log.Debug(fmt.Sprintf("RETURNING NIL CHUNK [%s]", out))
 		return out, false, nil
@@ -275,11 +392,25 @@ func (t *Table) Get(u *SWARMDBUser, key []byte) (out []byte, ok bool, err error)
 		return nil, false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Get] RetrieveKChunk - Cannot Retrieve Chunk (%s): %s", contentReader, err.Error()))
 	}
 	log.Debug(fmt.Sprintf("[dbchunkstore:Get] returning [%s]", contentReader))
-	fres := bytes.Trim(contentReader, "\x00")
-	return fres, true, nil
+	t.swarmdb.usage.add(t.Owner, 0, 0, 1, 0)
+	return bytes.Trim(contentReader, "\x00"), true, nil
 }
 
 func (t *Table) Delete(u *SWARMDBUser, key interface{}) (ok bool, err error) {
+	log.Debug("[table:Delete]", "trace", u.TraceID(), "table", t.tableName, "key", key)
+	if err = t.checkWritable(); err != nil {
+		return false, err
+	}
+	if t.IsSharded() {
+		shard, err := t.shardFor(u, key)
+		if err != nil {
+			return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Delete] shardFor %s", err.Error()))
+		}
+		return shard.Delete(u, key)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u = t.replicatedBy(u)
 	if _, ok := t.columns[t.primaryColumnName]; !ok {
 		return false, &sdbc.SWARMDBError{Message: fmt.Sprintf("[table:Get] columns array missing %s ", t.primaryColumnName), ErrorCode: 479, ErrorMessage: fmt.Sprintf("Table Definition Missing Selected Column [%s]", t.primaryColumnName)}
 	}
@@ -287,6 +418,12 @@ func (t *Table) Delete(u *SWARMDBUser, key interface{}) (ok bool, err error) {
 	if err != nil {
 		return ok, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Delete] convertJSONValueToKey %s", err.Error()))
 	}
+	var before []byte
+	if t.swarmdb.changeStream != nil || t.hasTriggers() {
+		if before, err = t.readBefore(u, k); err != nil {
+			return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Delete] readBefore %s", err.Error()))
+		}
+	}
 	ok = false
 	for _, ip := range t.columns {
 		ok2, err := ip.dbaccess.Delete(u, k)
@@ -300,10 +437,30 @@ func (t *Table) Delete(u *SWARMDBUser, key interface{}) (ok bool, err error) {
 		}
 	}
 	// TODO: K node deletion
+	if ok {
+		if t.buffered {
+			t.memtable.delete(k)
+		}
+		if err = t.fireTriggers(u, key, before, nil); err != nil {
+			return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Delete] fireTriggers %s", err.Error()))
+		}
+		t.noteWrite(len(k))
+		t.logOp(key, nil)
+		ev := t.newEvent(TE_DELETE)
+		ev.Key = key
+		ev.before = before
+		t.publishEvent(ev)
+	}
 	return ok, nil
 }
 
 func (t *Table) StartBuffer(u *SWARMDBUser) (err error) {
+	if err = t.checkWritable(); err != nil {
+		return err
+	}
+	if t.IsSharded() {
+		return t.eachShard(u, func(i int, shard *Table) error { return shard.StartBuffer(u) })
+	}
 	if t.buffered {
 		t.FlushBuffer(u)
 	} else {
@@ -320,28 +477,103 @@ func (t *Table) StartBuffer(u *SWARMDBUser) (err error) {
 }
 
 func (t *Table) FlushBuffer(u *SWARMDBUser) (err error) {
-	for _, ip := range t.columns {
+	if t.IsSharded() {
+		return t.eachShard(u, func(i int, shard *Table) error { return shard.FlushBuffer(u) })
+	}
+	err = t.flushBuffer(u)
+	if err != nil {
+		return err
+	}
+	t.publishPendingEvents()
+	return nil
+}
+
+// flushBuffer commits the buffered writes as one unit: every column index is flushed first, then a single descriptor
+// chunk holding all the new column root hashes is stored and published with a single ENS update.  Until that update
+// the published descriptor points at the previous generation of every index, so a crash or a failed column flush
+// never leaves the primary and secondary indexes at different generations.
+func (t *Table) flushBuffer(u *SWARMDBUser) (err error) {
+	roots, err := t.flushColumns(u)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:FlushBuffer] flushColumns %s", err.Error()))
+	}
+	err = t.publishDescriptor(u, roots)
+	if err != nil && t.resolver != nil && isConflict(err) {
+		// another writer published meanwhile: replay our writes on top of theirs
+		err = t.merge(u)
+		if err == nil {
+			t.resetDirty()
+			return nil
+		}
+	}
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:FlushBuffer] publishDescriptor %s", err.Error()))
+	}
+	t.setColumnRoots(roots)
+	t.resetDirty()
+	t.refreshHistograms(u)
+	return nil
+}
+
+// flushColumns flushes every column index and returns their new root hashes, which are not yet published
+func (t *Table) flushColumns(u *SWARMDBUser) (roots map[string][]byte, err error) {
+	u = t.replicatedBy(u)
+	roots = make(map[string][]byte)
+	for name, ip := range t.columns {
 		_, err := ip.dbaccess.FlushBuffer(u)
 		if err != nil {
-			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:FlushBuffer] dbaccess.FlushBuffer %s", err.Error()))
+			// some indexes may be flushed already; reopen the table from the published descriptor
+			t.swarmdb.UnregisterTable(t.Owner, t.Database, t.tableName)
+			return roots, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:flushColumns] dbaccess.FlushBuffer %s", err.Error()))
 		}
-		roothash := ip.dbaccess.GetRootHash()
-		ip.roothash = roothash
+		roots[name] = ip.dbaccess.GetRootHash()
 	}
-	err = t.updateTableInfo(u)
+	return roots, nil
+}
+
+// setColumnRoots records the column root hashes of a published descriptor
+func (t *Table) setColumnRoots(roots map[string][]byte) {
+	for name, ip := range t.columns {
+		ip.roothash = roots[name]
+	}
+}
+
+// updateTableInfo publishes the descriptor with the column root hashes of the last flush, e.g. after an ACL change
+func (t *Table) updateTableInfo(u *SWARMDBUser) (err error) {
+	roots := make(map[string][]byte)
+	for name, c := range t.columns {
+		roots[name] = c.roothash
+	}
+	return t.publishDescriptor(u, roots)
+}
+
+// publishDescriptor stores the table descriptor with the given column root hashes and publishes it
+func (t *Table) publishDescriptor(u *SWARMDBUser, roots map[string][]byte) (err error) {
+	swarmhash, err := t.storeDescriptor(u, roots)
 	if err != nil {
-		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:FlushBuffer] updateTableInfo %s", err.Error()))
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:publishDescriptor] storeDescriptor %s", err.Error()))
 	}
+	tblKey := t.swarmdb.GetTableKey(t.Owner, t.Database, t.tableName)
+	// compare-and-swap against the descriptor this table was opened with: if another writer published meanwhile,
+	// the table is dropped from the cache so the next request reopens it at the other writer's root hash
+	err = t.swarmdb.StoreRootHash(u, []byte(tblKey), t.roothash, swarmhash)
+	if err != nil {
+		t.swarmdb.UnregisterTable(t.Owner, t.Database, t.tableName)
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:publishDescriptor] StoreRootHash %s", err.Error()))
+	}
+	t.roothash = swarmhash
 	return nil
 }
 
-func (t *Table) updateTableInfo(u *SWARMDBUser) (err error) {
-	buf := make([]byte, 4096)
-	i := 0
-	for column_num, c := range t.columns {
+// storeDescriptor stores the table descriptor with the given column root hashes without publishing it
+func (t *Table) storeDescriptor(u *SWARMDBUser, roots map[string][]byte) (swarmhash []byte, err error) {
+	buf := getChunkBuffer()
+	defer releaseChunkBuffer(buf)
+	for i, name := range t.columnOrder() {
+		c := t.columns[name]
 		b := make([]byte, 1)
 
-		copy(buf[2048+i*64:], column_num)
+		copy(buf[2048+i*64:], name)
 
 		b[0] = byte(c.primary)
 		copy(buf[2048+i*64+26:], b)
@@ -354,21 +586,59 @@ func (t *Table) updateTableInfo(u *SWARMDBUser) (err error) {
 		b[0] = byte(itInt)
 		copy(buf[2048+i*64+30:], b)
 
-		copy(buf[2048+i*64+32:], c.roothash)
-		i++
+		if c.encrypted {
+			buf[2048+i*64+COLUMN_ENCRYPTED_OFFSET] = 1
+		}
+		if c.restricted {
+			buf[2048+i*64+COLUMN_RESTRICTED_OFFSET] = 1
+		}
+		buf[2048+i*64+COLUMN_FAMILY_OFFSET] = c.family
+		if c.expires {
+			buf[2048+i*64+COLUMN_EXPIRES_OFFSET] = 1
+		} else if c.timestamp > 0 {
+			buf[2048+i*64+COLUMN_EXPIRES_OFFSET] = c.timestamp
+		}
+
+		copy(buf[2048+i*64+32:], roots[name])
 	}
 	//update encryption buffer bytes
 	copy(buf[4000:4024], IntToByte(t.encrypted))
-	swarmhash, err := t.swarmdb.StoreDBChunk(u, buf, t.encrypted)
+	writeACL(buf, t.acl)
+	writeFlushPolicy(buf, t.flushPolicy)
+	writeShardSplits(buf, t.shardSplits)
+	writeFamilyMask(buf, t.familyMask)
+	writeReplication(buf, t.minReplication, t.maxReplication)
+	swarmhash, err = t.swarmdb.StoreDBChunk(t.replicatedBy(u), buf, t.encrypted)
 	if err != nil {
-		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:updateTableInfo] StoreDBChunk %s", err.Error()))
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:storeDescriptor] StoreDBChunk %s", err.Error()))
 	}
-	tblKey := t.swarmdb.GetTableKey(t.Owner, t.Database, t.tableName)
-	err = t.swarmdb.StoreRootHash(u, []byte(tblKey), []byte(swarmhash))
-	if err != nil {
-		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:updateTableInfo] StoreRootHash %s", err.Error()))
+	return swarmhash, nil
+}
+
+// columnOrder lists the primary column first, as OpenTable needs its type to open the secondary indexes, then the
+// others by name, so that the descriptor of unchanged columns is the same on every flush
+func (t *Table) columnOrder() (names []string) {
+	for name := range t.columns {
+		names = append(names, name)
 	}
-	return nil
+	return orderColumns(names, t.primaryColumnName)
+}
+
+// orderColumns sorts names as columnOrder does
+func orderColumns(names []string, primary string) (ordered []string) {
+	hasPrimary := false
+	for _, name := range names {
+		if name == primary {
+			hasPrimary = true
+		} else {
+			ordered = append(ordered, name)
+		}
+	}
+	sort.Strings(ordered)
+	if hasPrimary {
+		ordered = append([]string{primary}, ordered...)
+	}
+	return ordered
 }
 
 func (t *Table) DescribeTable() (tblInfo map[string]sdbc.Column, err error) {
@@ -392,84 +662,98 @@ func (t *Table) DescribeTable() (tblInfo map[string]sdbc.Column, err error) {
 	return tblInfo, nil
 }
 
+// Scan returns the rows of the table in the order of the index of columnName, ascending when ascending is 1 and
+// descending otherwise
 func (t *Table) Scan(u *SWARMDBUser, columnName string, ascending int) (rows []sdbc.Row, err error) {
+	return t.ScanLimit(u, columnName, ascending, 0)
+}
+
+// ScanLimit returns the first limit rows, or all of them when limit is 0, in the order of the index of columnName:
+// the primary index orders the rows by their primary key, a secondary index by their cell of the column, leaving out
+// the rows without one.
+func (t *Table) ScanLimit(u *SWARMDBUser, columnName string, ascending int, limit int) (rows []sdbc.Row, err error) {
+	log.Debug("[table:ScanLimit]", "trace", u.TraceID(), "table", t.tableName, "column", columnName, "limit", limit)
+	if t.IsSharded() {
+		return t.scanShards(u, columnName, ascending, limit)
+	}
 	column, err := t.getColumn(columnName)
 	if err != nil {
-		return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Scan] getColumn %s", err.Error()))
-	}
-	if t.primaryColumnName != columnName {
-		return rows, &sdbc.SWARMDBError{Message: fmt.Sprintf("[table:Scan] Skipping column %s", columnName), ErrorCode: -1, ErrorMessage: "Query Filters currently only supported on the primary key"}
-	}
-
-	var c OrderedDatabase
-	switch ctype := column.dbaccess.(type) {
-	case (OrderedDatabase):
-		c = column.dbaccess.(OrderedDatabase)
-	default:
-		return rows, &sdbc.SWARMDBError{Message: fmt.Sprintf("Attempt to scan a table with a column [%s] with an unsupported index type [%s]", columnName, ctype), ErrorCode: 431, ErrorMessage: fmt.Sprintf("Scans on Column [%s] not unsupported due to indextype", columnName)}
+		return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:ScanLimit] getColumn %s", err.Error()))
 	}
-
-	if ascending == 1 {
-		res, err := c.SeekFirst(u)
-		if err == io.EOF {
-			return rows, nil
-		} else if err != nil {
-			return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Scan] SeekFirst %s ", err.Error()))
-		} else {
-			records := 0
-			for k, v, err := res.Next(u); err == nil; k, v, err = res.Next(u) {
-				//fmt.Printf("\n *int*> %d: K: %s V: %v \n", records, KeyToString(column.columnType, k), v)
-				row, ok, errG := t.Get(u, k)
-				if errG != nil {
-					return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Scan] Get %s", errG.Error()))
-				}
-				if ok {
-					rowObj, errR := t.byteArrayToRow(row)
-					if errR != nil {
-						return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Scan] byteArrayToRow [%s] bytearray to row: [%s]", v, errR.Error()))
-					}
-					// fmt.Printf("table Scan, row set: %+v\n", row)
-					rows = append(rows, rowObj)
-					records++
-				}
-			}
-		}
-	} else {
-		res, err := c.SeekLast(u)
+	err = t.scanIndex(u, column, ascending, func(r *RowView) (bool, error) {
+		row, ok, err := r.Row()
 		if err != nil {
-			return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Scan] SeekLast %s", err.Error()))
-		} else {
-			records := 0
-			for k, v, err := res.Prev(u); err == nil; k, v, err = res.Prev(u) {
-				if false {
-					fmt.Printf(" *int*> %d: K: %s V: %v\n", records, KeyToString(sdbc.CT_STRING, k), KeyToString(column.columnType, v))
-				}
-				row, ok, errG := t.Get(u, k)
-				if errG != nil {
-					return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Scan] Get %s", errG.Error()))
-				}
-				if ok {
-					rowObj, errR := t.byteArrayToRow(row)
-					if errR != nil {
-						return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Scan] byteArrayToRow %s", err.Error()))
-					}
-					rows = append(rows, rowObj)
-					records++
-				}
-			}
+			return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:ScanLimit] Row %s", err.Error()))
 		}
+		if ok {
+			rows = append(rows, row)
+		}
+		return limit <= 0 || len(rows) < limit, nil
+	})
+	if err != nil {
+		return rows, err
 	}
-	log.Debug(fmt.Sprintf("table Scan, rows returned: %+v\n", rows))
+	log.Debug(fmt.Sprintf("[table:ScanLimit] %d rows of %s by %s", len(rows), t.tableName, columnName))
 	return rows, nil
 }
 
 func (t *Table) Put(u *SWARMDBUser, row map[string]interface{}) (err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.put(u, row)
+}
+
+// put is Put for callers holding t.mu
+func (t *Table) put(u *SWARMDBUser, row map[string]interface{}) (err error) {
+	log.Debug("[table:Put]", "trace", u.TraceID(), "table", t.tableName)
+	if err = t.checkWritable(); err != nil {
+		return err
+	}
+	if t.IsSharded() {
+		shard, err := t.shardFor(u, row[t.primaryColumnName])
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Put] shardFor %s", err.Error()))
+		}
+		return shard.Put(u, row)
+	}
+	u = t.replicatedBy(u)
+	if row, err = t.canonicalUUIDs(row); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Put] canonicalUUIDs %s", err.Error()))
+	}
+	if row, err = t.stampRow(u, row); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Put] stampRow %s", err.Error()))
+	}
+	if row, err = t.encryptColumns(u, row); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Put] encryptColumns %s", err.Error()))
+	}
 	rawvalue, err := json.Marshal(row)
 	if err != nil {
 		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[table:Put] Marshal %s", err.Error()), ErrorCode: 435, ErrorMessage: "Invalid Row Data"}
 	}
 
+	// the secondary index keys are converted up front, so a bad value fails the Put before the row is stored
+	secondary := make(map[*ColumnInfo][]byte)
+	for _, c := range t.columns {
+		pvalue, ok := row[c.columnName]
+		if c.primary > 0 || !ok || c.encrypted {
+			//OK b/c non-primary keys aren't required for rows, and sealed values are not indexed
+			continue
+		}
+		if c.indexType != sdbc.IT_NONE {
+			if err = t.swarmdb.checkKeySize(c.columnType, pvalue); err != nil {
+				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Put] checkKeySize %s %s", c.columnName, err.Error()))
+			}
+		}
+		k2, errPvalue := convertJSONValueToKey(c.columnType, pvalue)
+		if errPvalue != nil {
+			return sdbc.GenerateSWARMDBError(errPvalue, fmt.Sprintf("[table:Put] convertJSONValueToKey %s", errPvalue.Error()))
+		}
+		secondary[c] = k2
+	}
+
 	k := make([]byte, 32)
+	var before []byte // the row overwritten, for the change stream and triggers
+	version := 0
 
 	for _, c := range t.columns {
 		//fmt.Printf("\nProcessing a column %s and primary is %d", c.columnName, c.primary)
@@ -478,75 +762,109 @@ func (t *Table) Put(u *SWARMDBUser, row map[string]interface{}) (err error) {
 			if !ok {
 				return &sdbc.SWARMDBError{Message: fmt.Sprintf("[table:Put] Primary key %s not specified in input", t.primaryColumnName), ErrorCode: 428, ErrorMessage: "Row missing primary key"}
 			}
+			if err = t.swarmdb.checkKeySize(c.columnType, pvalue); err != nil {
+				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Put] checkKeySize %s", err.Error()))
+			}
 			k, err = convertJSONValueToKey(t.columns[t.primaryColumnName].columnType, pvalue)
 			if err != nil {
 				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Put] convertJSONValueToKey %s", err.Error()))
 			}
-			rawChunkBytes, err := t.swarmdb.dbchunkstore.RetrieveRawChunk(k)
-			if err != nil {
-				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Put] RetrieveRawChunk - Error Retrieving Data checking if [%s] exists %s", k, err.Error()))
-			}
-			var birthts int
-			var version int
-			if len(bytes.Trim(rawChunkBytes, "\x00")) == 0 {
-				birthts = int(time.Now().Unix())
-				version = 0
-			} else {
-				//TODO: retrieve birthdt and version from chunk
-				chunkHeader, err := ParseChunkHeader(rawChunkBytes)
-				if err != nil {
-					return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Put] Unable to parse Chunk Header"))
+			if t.swarmdb.changeStream != nil || t.hasTriggers() {
+				if before, err = t.readBefore(u, k); err != nil {
+					return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Put] readBefore %s", err.Error()))
 				}
-				birthts = chunkHeader.Birthts
-				version = chunkHeader.Version + 1
-			}
-			v := []byte(rawvalue)
-			sdata, errS := t.buildSdata(u, k, v, birthts, version)
-			if errS != nil {
-				return sdbc.GenerateSWARMDBError(err, `[kademliadb:Put] buildSdata `+errS.Error())
 			}
-
-			hashVal := sdata[CHUNK_START_KEY:CHUNK_END_KEY] // 32 bytes
-			log.Debug(fmt.Sprintf("Storing data with hashValue of %x %v", hashVal, hashVal))
-			errStore := t.swarmdb.dbchunkstore.StoreKChunk(u, hashVal, sdata, t.encrypted)
-			if errStore != nil {
-				return sdbc.GenerateSWARMDBError(err, `[table:Put] StoreKChunk `+errStore.Error())
+			hashVal, v, ver, err := t.storeRow(u, k, row)
+			if err != nil {
+				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Put] storeRow %s", err.Error()))
 			}
+			version = ver
 			_, err = c.dbaccess.Put(u, k, hashVal)
 			if err != nil {
 				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Put] dbaccess.Put %s", err.Error()))
 			}
-		} else {
-			k2 := make([]byte, 32)
-			var errPvalue error
-			pvalue, ok := row[c.columnName]
-			if !ok {
-				//OK b/c non-primary keys aren't required for rows
-				continue
-			}
-			k2, errPvalue = convertJSONValueToKey(c.columnType, pvalue)
-			if errPvalue != nil {
-				return sdbc.GenerateSWARMDBError(errPvalue, fmt.Sprintf("[table:Put] convertJSONValueToKey %s", errPvalue.Error()))
-			}
-
-			_, err = c.dbaccess.Put(u, k2, k)
-			if err != nil {
-				return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Put] dbaccess.Put %s", err.Error()))
+			if t.buffered {
+				t.memtable.put(k, v)
 			}
 		}
 	}
+	if err = t.putSecondaryIndexes(u, secondary, k); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Put] putSecondaryIndexes %s", err.Error()))
+	}
+	if err = t.fireTriggers(u, row[t.primaryColumnName], before, row); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Put] fireTriggers %s", err.Error()))
+	}
 
+	t.noteWrite(len(rawvalue))
+	t.logOp(row[t.primaryColumnName], row)
+	ev := t.newEvent(TE_PUT)
+	ev.Key = row[t.primaryColumnName]
+	ev.Row = row
+	ev.before, ev.version = before, version
 	if t.buffered {
 		// do nothing until FlushBuffer called
 	} else {
-		err = t.FlushBuffer(u)
+		err = t.flushBuffer(u)
 		if err != nil {
 			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:Put] FlushBuffer %s", err.Error()))
 		}
 	}
+	t.publishEvent(ev)
 	return nil
 }
 
+// storeRowChunk stores the row value v in the K-chunk of k, archiving the version it overwrites, and returns the
+// chunk key and the version stored
+func (t *Table) storeRowChunk(u *SWARMDBUser, k []byte, v []byte) (hashVal []byte, version int, err error) {
+	if err = t.swarmdb.checkRowSize(len(v)); err != nil {
+		return nil, 0, err
+	}
+	u = t.replicatedBy(u)
+	birthts, version, prevVersion, err := t.archiveVersion(u, k)
+	if err != nil {
+		return nil, 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:storeRowChunk] archiveVersion %s", err.Error()))
+	}
+	sdata, err := t.buildSdata(u, k, v, birthts, version, prevVersion)
+	if err != nil {
+		return nil, 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:storeRowChunk] buildSdata %s", err.Error()))
+	}
+
+	hashVal = sdata[CHUNK_START_KEY:CHUNK_END_KEY] // 32 bytes
+	log.Debug(fmt.Sprintf("Storing data with hashValue of %x %v", hashVal, hashVal))
+	if err = t.swarmdb.ledger.charge(t.Owner, hashVal, u.MinReplication); err != nil {
+		return nil, 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:storeRowChunk] charge %s", err.Error()))
+	}
+	if err = t.swarmdb.dbchunkstore.StoreKChunk(u, hashVal, sdata, t.encrypted); err != nil {
+		t.swarmdb.ledger.refund(hashVal)
+		return nil, 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:storeRowChunk] StoreKChunk %s", err.Error()))
+	}
+	t.swarmdb.usage.add(t.Owner, len(v), 1, 0, 0)
+	return hashVal, version, nil
+}
+
+// putSecondaryIndexes points the secondary index entries keys[c] of a row at its primary key k.  Every column has
+// an index of its own, each of which may have to load nodes from swarm, so they are updated in parallel, at most
+// INDEX_PUT_PARALLELISM at a time, and joined before returning the first error.
+func (t *Table) putSecondaryIndexes(u *SWARMDBUser, keys map[*ColumnInfo][]byte, k []byte) (err error) {
+	errs := make(chan error, len(keys))
+	sem := make(chan struct{}, INDEX_PUT_PARALLELISM)
+	var wg sync.WaitGroup
+	for c, k2 := range keys {
+		wg.Add(1)
+		go func(c *ColumnInfo, k2 []byte) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if _, err := c.dbaccess.Put(u, k2, k); err != nil {
+				errs <- sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:putSecondaryIndexes] %s dbaccess.Put %s", c.columnName, err.Error()))
+			}
+		}(c, k2)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
 func (t *Table) assignRowColumnTypes(rows []sdbc.Row) ([]sdbc.Row, error) {
 	// fmt.Printf("assignRowColumnTypes: %v\n", t.columns)
 	for _, row := range rows {
@@ -597,6 +915,12 @@ func (t *Table) assignRowColumnTypes(rows []sdbc.Row) ([]sdbc.Row, error) {
 					default:
 						return rows, &sdbc.SWARMDBError{Message: fmt.Sprintf("[table:assignRowColumnTypes] TypeConversion Error: value [%v] does not match column type [%v]", value, t.columns[name].columnType), ErrorCode: 427, ErrorMessage: fmt.Sprintf("The value passed in for [%s] is of an unsupported type", name)}
 					}
+				case CT_UUID:
+					id, err := canonicalUUID(value)
+					if err != nil {
+						return rows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:assignRowColumnTypes] canonicalUUID %s", err.Error()))
+					}
+					row[name] = id
 				//case sdbc.CT_BLOB:
 				// TODO: add blob support
 				default:
@@ -612,6 +936,9 @@ func (t *Table) assignRowColumnTypes(rows []sdbc.Row) ([]sdbc.Row, error) {
 
 //TODO: could overload the operators so this isn't so clunky
 func (t *Table) applyWhere(rawRows []sdbc.Row, where Where) (outRows []sdbc.Row, err error) {
+	if len(where.And) > 0 {
+		return t.applyConjunction(rawRows, where)
+	}
 	for _, row := range rawRows {
 		if _, ok := row[where.Left]; !ok {
 			continue
@@ -628,6 +955,9 @@ func (t *Table) applyWhere(rawRows []sdbc.Row, where Where) (outRows []sdbc.Row,
 			return outRows, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[table:applyWhere] stringToColumnType %s", err.Error()))
 		}
 		log.Debug(fmt.Sprintf("ColType [%d] and Right [%s]", colType, right))
+		if colType == CT_UUID {
+			colType = sdbc.CT_STRING // in canonical form, UUIDs compare as strings
+		}
 		fRow := sdbc.NewRow()
 		switch where.Operator {
 		case "=":
@@ -0,0 +1,157 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"sync/atomic"
+	"time"
+)
+
+// GetTable keeps every table it opens in self.tables, so that later requests share its buffer, flush policy and
+// memtable.  A caller that holds a handle across requests (a Transaction, a Coordinator, a stream) takes a reference
+// with AcquireTable and gives it back with ReleaseTable; everyone else just uses the handle GetTable returns, which
+// marks it as used.  With config.TableIdleTimeout set, the evictor started by StartEvictor drops the handles nobody
+// referenced, buffered into or used for that long, and the next GetTable opens the table again from ENS.
+// CloseTable flushes a table and drops its handle on request.
+
+const (
+	TABLE_EVICT_INTERVAL = 30 * time.Second // how often the evictor looks for idle tables, at most
+)
+
+// touch marks the table as used now
+func (t *Table) touch() {
+	atomic.StoreInt64(&t.lastUsed, time.Now().UnixNano())
+}
+
+// idleSince returns when the table was last used
+func (t *Table) idleSince() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&t.lastUsed))
+}
+
+// adoptTable registers a table GetTable opened, unless another request opened and registered it meanwhile, in which
+// case that handle, and the writes it may have buffered, wins
+func (self *SwarmDB) adoptTable(tbl *Table) *Table {
+	tblKey := self.GetTableKey(tbl.Owner, tbl.Database, tbl.tableName)
+	self.tablesMu.Lock()
+	defer self.tablesMu.Unlock()
+	if open, ok := self.tables[tblKey]; ok {
+		return open
+	}
+	self.tables[tblKey] = tbl
+	return tbl
+}
+
+// lookupTable returns the open table, if any, without opening it
+func (self *SwarmDB) lookupTable(owner string, database string, tableName string) (tbl *Table, ok bool) {
+	self.tablesMu.RLock()
+	defer self.tablesMu.RUnlock()
+	tbl, ok = self.tables[self.GetTableKey(owner, database, tableName)]
+	return tbl, ok
+}
+
+// AcquireTable returns the table like GetTable and keeps it open until the matching ReleaseTable
+func (self *SwarmDB) AcquireTable(u *SWARMDBUser, owner string, database string, tableName string) (tbl *Table, err error) {
+	tbl, err = self.GetTable(u, owner, database, tableName)
+	if err != nil {
+		return tbl, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[tablecache:AcquireTable] GetTable %s", err.Error()))
+	}
+	atomic.AddInt32(&tbl.refs, 1)
+	return tbl, nil
+}
+
+// ReleaseTable gives back a reference AcquireTable took
+func (self *SwarmDB) ReleaseTable(tbl *Table) {
+	if atomic.AddInt32(&tbl.refs, -1) < 0 {
+		atomic.StoreInt32(&tbl.refs, 0)
+	}
+	tbl.touch()
+}
+
+// CloseTable flushes the buffer of an open table and drops its handle; closing a table that is not open does
+// nothing.  A table in a Transaction, atomic batch or Coordinator, or acquired by someone, cannot be closed.
+func (self *SwarmDB) CloseTable(u *SWARMDBUser, owner string, database string, tableName string) (closed bool, err error) {
+	tbl, ok := self.lookupTable(owner, database, tableName)
+	if !ok {
+		return false, nil
+	}
+	tblKey := self.GetTableKey(owner, database, tableName)
+	if tbl.holdFlush {
+		return false, &sdbc.SWARMDBError{Message: fmt.Sprintf("[tablecache:CloseTable] table %s is in a transaction", tblKey), ErrorCode: 499, ErrorMessage: "Transaction Conflict: the table is in a transaction"}
+	}
+	if refs := atomic.LoadInt32(&tbl.refs); refs > 0 {
+		return false, &sdbc.SWARMDBError{Message: fmt.Sprintf("[tablecache:CloseTable] table %s has %d references", tblKey, refs), ErrorCode: 499, ErrorMessage: fmt.Sprintf("Table In Use: the table is held open by %d requests", refs)}
+	}
+	if err = tbl.FlushBuffer(u); err != nil {
+		return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[tablecache:CloseTable] FlushBuffer %s", err.Error()))
+	}
+	self.tablesMu.Lock()
+	defer self.tablesMu.Unlock()
+	// only drop the handle that was flushed; a replica follower may have swapped in a newer one meanwhile
+	if self.tables[tblKey] == tbl {
+		delete(self.tables, tblKey)
+	}
+	return true, nil
+}
+
+// EvictIdle drops the handles of the tables that are not referenced, not buffered and were not used for idle; it
+// returns how many were dropped
+func (self *SwarmDB) EvictIdle(idle time.Duration) (evicted int) {
+	cutoff := time.Now().Add(-idle)
+	self.tablesMu.Lock()
+	defer self.tablesMu.Unlock()
+	for tblKey, tbl := range self.tables {
+		if tbl.buffered || tbl.holdFlush || atomic.LoadInt32(&tbl.refs) > 0 || tbl.idleSince().After(cutoff) {
+			continue
+		}
+		delete(self.tables, tblKey)
+		evicted++
+	}
+	return evicted
+}
+
+// StartEvictor runs EvictIdle for tables idle longer than idle until the returned stop func is called; idle 0
+// keeps tables open until they are closed
+func (self *SwarmDB) StartEvictor(idle time.Duration) (stop func()) {
+	if idle <= 0 {
+		return func() {}
+	}
+	interval := idle / 2
+	if interval > TABLE_EVICT_INTERVAL {
+		interval = TABLE_EVICT_INTERVAL
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	quit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				if n := self.EvictIdle(idle); n > 0 {
+					log.Debug(fmt.Sprintf("[tablecache:StartEvictor] evicted %d idle tables", n))
+				}
+			}
+		}
+	}()
+	return func() { close(quit) }
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"errors"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+)
+
+// TableInfo describes a table as its readers see it now
+type TableInfo struct {
+	Owner    string
+	Database string
+	Table    string
+	RootHash []byte // of the table descriptor, as published in ENS
+	RowCount int    // -1 when the primary key is not ordered (IT_HASHTREE) and the rows cannot be counted
+	Columns  []ColumnStats
+}
+
+// ColumnStats describes a column and its index
+type ColumnStats struct {
+	ColumnName string
+	ColumnType sdbc.ColumnType
+	IndexType  sdbc.IndexType
+	Primary    int
+	RootHash   []byte // of the index of the column
+}
+
+// GetTableInfo describes the table, opening it from ENS when it is not open yet, with the root hashes of the table
+// and of every column index and the number of rows.  Counting walks the primary index, but reads no rows.
+func (self *SwarmDB) GetTableInfo(u *SWARMDBUser, owner string, database string, tableName string) (info *TableInfo, err error) {
+	tbl, err := self.GetTable(u, owner, database, tableName)
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[tableinfo:GetTableInfo] GetTable %s", err.Error()))
+	}
+	hidden, err := tbl.checkRead(u)
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[tableinfo:GetTableInfo] checkRead %s", err.Error()))
+	}
+	view, err := tbl.readView(u)
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[tableinfo:GetTableInfo] readView %s", err.Error()))
+	}
+	info = &TableInfo{Owner: owner, Database: database, Table: tableName, RootHash: view.roothash}
+	for _, name := range view.columnOrder() {
+		if hidden[name] {
+			continue
+		}
+		c := view.columns[name]
+		cs := ColumnStats{ColumnName: name, ColumnType: c.columnType, IndexType: c.indexType, Primary: int(c.primary)}
+		if c.dbaccess != nil {
+			cs.RootHash = c.dbaccess.GetRootHash()
+		}
+		info.Columns = append(info.Columns, cs)
+	}
+	err = view.ScanEach(u, 1, func(r *RowView) (bool, error) {
+		info.RowCount++
+		return true, nil
+	})
+	var serr *sdbc.SWARMDBError
+	if errors.As(err, &serr) && serr.ErrorCode == 431 {
+		info.RowCount = -1
+	} else if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[tableinfo:GetTableInfo] ScanEach %s", err.Error()))
+	}
+	return info, nil
+}
+
+// Row is the RT_TABLE_INFO answer: {"owner", "database", "table", "roothash", "rowcount", "columns"}, with a
+// {"ColumnName", "ColumnType", "IndexType", "Primary", "roothash"} row per column, the primary key first
+func (info *TableInfo) Row() (row sdbc.Row) {
+	row = sdbc.NewRow()
+	row["owner"] = info.Owner
+	row["database"] = info.Database
+	row["table"] = info.Table
+	row["roothash"] = fmt.Sprintf("%x", info.RootHash)
+	row["rowcount"] = info.RowCount
+	columns := make([]sdbc.Row, 0, len(info.Columns))
+	for _, c := range info.Columns {
+		r := sdbc.NewRow()
+		r["ColumnName"] = c.ColumnName
+		r["IndexType"] = c.IndexType
+		r["Primary"] = c.Primary
+		r["ColumnType"] = c.ColumnType
+		r["roothash"] = fmt.Sprintf("%x", c.RootHash)
+		columns = append(columns, r)
+	}
+	row["columns"] = columns
+	return row
+}
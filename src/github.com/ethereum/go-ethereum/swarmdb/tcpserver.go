@@ -0,0 +1,594 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TCPServer speaks the line protocol used by swarmdblib: each request is one swarmdbwire.Request JSON object
+// terminated by "\n", answered by one swarmdbwire.Response JSON object terminated by "\n".
+// On connect the server sends a hex challenge line; the client answers with the hex signature of
+// SignHash(challenge) and receives a Response whose single row {"owner": ...} names the owner the session is bound to.
+// Further signatures of the same challenge with other keys add owners to the session: a request then runs as its
+// Owner when that owner signed the challenge, otherwise as the RT_USE owner or the first authenticated owner.
+// The Owner of a request names the tables it acts on, which may be those of other owners: their ACLs decide.
+// With Authentication 0 the signature may be skipped and requests run as the default user.
+// A request carrying a capability token runs as the owner of the token within its scope, whatever the session is
+// authenticated as (see CheckCapability).
+// Responses are encoded in the protocol version negotiated with RT_HELLO (see swarmdbwire.EncodeResponse), version 1
+// for clients that never send it.
+// RT_USE sets per-connection defaults for Owner and Database, so later requests on the connection may omit them.
+// A client may negotiate payload compression with a swarmdbwire.RT_COMPRESSION request, after which both directions
+// use length-prefixed compressed frames instead of lines (see swarmdbwire.WriteMessage).
+// Clients need not wait for a response before sending the next request: requests are read ahead while the one
+// before executes, and answered in order (see pipeline.go).
+type TCPServer struct {
+	swarmdb *SwarmDB
+	config  *SWARMDBConfig
+	limiter *RateLimiter
+
+	idempotency *IdempotencyCache // results of requests sent with an IdempotencyKey or a unique RequestID
+
+	mu       sync.Mutex
+	listener net.Listener
+	sessions map[*TCPSession]struct{}
+	closing  bool
+	inflight sync.WaitGroup
+}
+
+func NewTCPServer(swarmdb *SwarmDB, config *SWARMDBConfig) *TCPServer {
+	return &TCPServer{swarmdb: swarmdb, config: config, limiter: NewRateLimiter(config), idempotency: NewIdempotencyCache(config.GetIdempotencyTTL()), sessions: make(map[*TCPSession]struct{})}
+}
+
+func (self *TCPServer) ListenAndServe() (err error) {
+	addr := fmt.Sprintf("%s:%d", self.config.ListenAddrTCP, self.config.PortTCP)
+	listener, err := self.config.Listen(addr)
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[tcpserver:ListenAndServe] Listen %s", err.Error()), ErrorCode: 487, ErrorMessage: "Unable to start TCP server"}
+	}
+	log.Debug(fmt.Sprintf("[tcpserver:ListenAndServe] listening on %s", addr))
+	return self.Serve(listener)
+}
+
+// Serve accepts connections on listener until it is closed; after Shutdown it returns nil
+func (self *TCPServer) Serve(listener net.Listener) (err error) {
+	self.mu.Lock()
+	self.listener = listener
+	self.mu.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if self.isClosing() {
+				return nil
+			}
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[tcpserver:Serve] Accept %s", err.Error()), ErrorCode: 487, ErrorMessage: "Unable to start TCP server"}
+		}
+		go self.handleConnection(conn)
+	}
+}
+
+// ServeConn serves one client connection that did not come from a listener, e.g. one end of a net.Pipe;
+// it returns when the connection is closed
+func (self *TCPServer) ServeConn(conn net.Conn) {
+	self.handleConnection(conn)
+}
+
+// NewEmbeddedServer opens the local chunk store of config and returns a server for in-process clients
+// (swarmdblib.OpenEmbeddedConnection) that does not listen on any port
+func NewEmbeddedServer(config *SWARMDBConfig) (srv *TCPServer, err error) {
+	swarmdb, err := NewSwarmDB(config)
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[tcpserver:NewEmbeddedServer] NewSwarmDB %s", err.Error()))
+	}
+	return NewTCPServer(swarmdb, config), nil
+}
+
+// TCPSession is the server side state of one client connection
+type TCPSession struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	writer    *bufio.Writer
+	challenge string
+	user      *SWARMDBUser
+	owner     string                  // first authenticated address; requests naming no other authenticated owner run as this one
+	users     map[string]*SWARMDBUser // every authenticated address, including owner
+	limiter   *RequestLimiter
+
+	defaultOwner    string // set by RT_USE, fills in requests without an Owner
+	defaultDatabase string // set by RT_USE, fills in requests without a Database
+
+	compression        string // negotiated payload compression, COMPRESSION_NONE until negotiated
+	pendingCompression string // takes effect after the negotiation response is written uncompressed
+	version            int    // negotiated protocol version, MIN_PROTOCOL_VERSION until RT_HELLO
+	pendingVersion     int    // takes effect after the RT_HELLO response is written
+
+	txn     *Transaction    // opened by RT_BEGIN, rolled back if the connection closes before RT_COMMIT
+	buffers map[string]bool // tables whose buffer this session started; its reads of them see the buffered writes
+
+	framing chan string // the compression after an RT_COMPRESSION request, handed to readAhead
+}
+
+func (self *TCPServer) handleConnection(conn net.Conn) {
+	defer conn.Close()
+	session := &TCPSession{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn), limiter: self.limiter.NewConnection(), version: wire.MIN_PROTOCOL_VERSION, pendingVersion: wire.MIN_PROTOCOL_VERSION, framing: make(chan string, 1)}
+	if !self.addSession(session) {
+		return
+	}
+	defer self.removeSession(session)
+	defer session.rollback()
+
+	// every connection starts with a random challenge the client must sign with its private key
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		log.Debug(fmt.Sprintf("[tcpserver:handleConnection] rand.Read %s", err.Error()))
+		return
+	}
+	session.challenge = fmt.Sprintf("%x", nonce)
+	if _, err := session.writer.WriteString(session.challenge + "\n"); err != nil {
+		return
+	}
+	if err := session.writer.Flush(); err != nil {
+		return
+	}
+
+	// requests are read ahead while the one before executes (see pipeline.go); the idle timeout only runs while
+	// none is waiting
+	idle := func() {
+		if self.config.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(time.Duration(self.config.IdleTimeout) * time.Second))
+		}
+	}
+	idle()
+	done := make(chan struct{})
+	defer close(done)
+	inbound := session.readAhead(done)
+	for msg := range inbound {
+		if msg.err != nil {
+			log.Debug(fmt.Sprintf("[tcpserver:handleConnection] ReadMessage %s", msg.err.Error()))
+			return
+		}
+		line := msg.line
+		if len(line) == 0 {
+			continue
+		}
+		if self.config.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Time{})
+		}
+		if !strings.HasPrefix(line, "{") {
+			owner, err := self.authenticate(session, line)
+			if err != nil {
+				session.writeMessage(newErrorResponse("", err))
+				if session.user == nil {
+					return
+				}
+				continue
+			}
+			authRow := sdbc.NewRow()
+			authRow["owner"] = owner
+			if err = session.writeMessage(wire.Response{Status: wire.STATUS_OK, Data: []sdbc.Row{authRow}}); err != nil {
+				return
+			}
+			if len(inbound) == 0 {
+				idle()
+			}
+			continue
+		}
+		if !self.beginRequest() {
+			session.writeMessage(newErrorResponse("", &sdbc.SWARMDBError{Message: "[tcpserver:handleConnection] server shutting down", ErrorCode: 494, ErrorMessage: "Server Shutting Down"}))
+			return
+		}
+		if self.config.RequestTimeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(time.Duration(self.config.RequestTimeout) * time.Second))
+		}
+		err := session.writeMessage(self.handleRequest(session, line))
+		self.inflight.Done()
+		if err != nil {
+			log.Debug(fmt.Sprintf("[tcpserver:handleConnection] writeMessage %s", err.Error()))
+			return
+		}
+		if session.pendingCompression != session.compression {
+			session.compression = session.pendingCompression
+			log.Debug(fmt.Sprintf("[tcpserver:handleConnection] compression %s negotiated", session.compression))
+		}
+		if session.pendingVersion != session.version {
+			session.version = session.pendingVersion
+			log.Debug(fmt.Sprintf("[tcpserver:handleConnection] protocol version %d negotiated", session.version))
+		}
+		if renegotiates(line) {
+			session.framing <- session.compression
+		}
+		if len(inbound) == 0 {
+			idle()
+		}
+	}
+}
+
+// authenticate verifies the hex signature of the session challenge and adds the signing address to the session owners;
+// the first one becomes the default owner of the session
+func (self *TCPServer) authenticate(session *TCPSession, sigHex string) (owner string, err error) {
+	sig, err := hex.DecodeString(strings.TrimPrefix(sigHex, "0x"))
+	if err != nil {
+		return owner, &sdbc.SWARMDBError{Message: fmt.Sprintf("[tcpserver:authenticate] DecodeString %s", err.Error()), ErrorCode: 419, ErrorMessage: "Invalid Signature Length: Must be 65 characters"}
+	}
+	km := self.swarmdb.dbchunkstore.GetKeyManager()
+	u, err := km.VerifyMessage(SignHash([]byte(session.challenge)), sig)
+	if err != nil {
+		return owner, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[tcpserver:authenticate] VerifyMessage %s", err.Error()))
+	}
+	owner = strings.ToLower(u.Address)
+	if session.user == nil {
+		session.user = u
+		session.owner = owner
+		session.users = make(map[string]*SWARMDBUser)
+	}
+	session.users[owner] = u
+	log.Debug(fmt.Sprintf("[tcpserver:authenticate] session authenticated as %s (%d owners)", owner, len(session.users)))
+	return owner, nil
+}
+
+func (self *TCPServer) handleRequest(session *TCPSession, line string) (out wire.Response) {
+	req := new(wire.Request)
+	if err := json.Unmarshal([]byte(line), req); err != nil {
+		return newErrorResponse("", &sdbc.SWARMDBError{Message: fmt.Sprintf("[tcpserver:handleRequest] Unmarshal %s", err.Error()), ErrorCode: 432, ErrorMessage: "Unable to Parse Request"})
+	}
+	if req.RequestType == sdbc.RT_SCAN && req.Range != nil {
+		// a Scan with a Range is returned in pages, with continuation tokens
+		req.RequestType = wire.RT_SCAN_RANGE
+	}
+	switch req.RequestType {
+	case wire.RT_HELLO:
+		// allowed before authentication, so clients learn the version before signing the challenge
+		return session.hello(req)
+	case wire.RT_PING:
+		// keep-alive: not authenticated and not rate limited
+		row := sdbc.NewRow()
+		row["pong"] = time.Now().UnixNano() / int64(time.Millisecond)
+		return wire.Response{RequestID: req.RequestID, Status: wire.STATUS_OK, Data: []sdbc.Row{row}}
+	}
+	u := session.user
+	if len(req.Capability) > 0 {
+		// the capability stands in for the owner's key, on any session
+		var err error
+		if u, err = self.swarmdb.CheckCapability(req); err != nil {
+			return newErrorResponse(req.RequestID, err)
+		}
+	} else if u != nil {
+		u = session.users[session.signerFor(req.Owner)]
+	} else {
+		if self.config.Authentication == 1 {
+			return newErrorResponse(req.RequestID, &sdbc.SWARMDBError{Message: "[tcpserver:handleRequest] request before challenge response", ErrorCode: 489, ErrorMessage: "Authentication Required: sign the challenge before sending requests"})
+		}
+		u = self.config.GetSWARMDBUser()
+	}
+	if len(req.TraceID) == 0 {
+		req.TraceID = NewTraceID()
+	}
+	u = u.WithTrace(req.TraceID)
+	if req.Deadline > 0 {
+		u = u.WithDeadline(time.Unix(0, req.Deadline*int64(time.Millisecond)))
+	}
+	u = u.WithBuffers(session.buffers)
+	out = self.dispatch(session, u, req, len(line))
+	out.TraceID = req.TraceID
+	return out
+}
+
+func (self *TCPServer) dispatch(session *TCPSession, u *SWARMDBUser, req *wire.Request, size int) (out wire.Response) {
+	d := &req.RequestOption
+	if d.RequestType == wire.RT_USE {
+		return session.use(req)
+	}
+	if len(req.Capability) == 0 {
+		session.applyContext(d)
+	}
+	release, err := self.limiter.Admit(session.limiter, u, size, isQueryRequest(d.RequestType))
+	if err != nil {
+		return newErrorResponse(req.RequestID, err)
+	}
+	defer release()
+	switch d.RequestType {
+	case wire.RT_BATCH:
+		return self.handleBatch(session, u, req)
+	case wire.RT_SCAN_RANGE:
+		if req.Range == nil {
+			return newErrorResponse(req.RequestID, &sdbc.SWARMDBError{Message: "[tcpserver:dispatch] ScanRange without range", ErrorCode: 418, ErrorMessage: "Request Invalid: ScanRange requires a range"})
+		}
+		resp, nextToken, err := self.swarmdb.ScanRange(u, d.Owner, d.Database, d.Table, req.Range)
+		if err != nil {
+			return newErrorResponse(req.RequestID, err)
+		}
+		out = wire.NewResponse(req.RequestID, resp)
+		out.NextToken = nextToken
+		return out
+	case wire.RT_ADMIN:
+		if session.user == nil {
+			return newErrorResponse(req.RequestID, &sdbc.SWARMDBError{Message: "[tcpserver:dispatch] admin command on unauthenticated session", ErrorCode: 489, ErrorMessage: "Authentication Required: sign the challenge before sending requests"})
+		}
+		if !self.config.IsAdmin(u.Address) {
+			return newErrorResponse(req.RequestID, &sdbc.SWARMDBError{Message: fmt.Sprintf("[tcpserver:dispatch] %s is not an admin", u.Address), ErrorCode: 490, ErrorMessage: "Access Denied: admin commands require an admin address"})
+		}
+		resp, err := self.swarmdb.Admin(u, self.config, req.Command, d)
+		if err != nil {
+			return newErrorResponse(req.RequestID, err)
+		}
+		return wire.NewResponse(req.RequestID, resp)
+	case wire.RT_BEGIN:
+		if session.txn != nil {
+			return newErrorResponse(req.RequestID, &sdbc.SWARMDBError{Message: "[tcpserver:dispatch] Begin within a transaction", ErrorCode: 418, ErrorMessage: "Request Invalid: a transaction is already open"})
+		}
+		session.txn, err = self.swarmdb.Begin(u, d.Owner, d.Database, d.Table)
+		if err != nil {
+			return newErrorResponse(req.RequestID, err)
+		}
+		session.trackBuffer(self.swarmdb.GetTableKey(session.txn.Table()), true)
+		return wire.Response{RequestID: req.RequestID, Status: wire.STATUS_OK}
+	case wire.RT_COMMIT, wire.RT_ROLLBACK:
+		if session.txn == nil {
+			return newErrorResponse(req.RequestID, &sdbc.SWARMDBError{Message: fmt.Sprintf("[tcpserver:dispatch] %s without a transaction", d.RequestType), ErrorCode: 418, ErrorMessage: "Request Invalid: no transaction open"})
+		}
+		tx := session.txn
+		session.txn = nil
+		session.trackBuffer(self.swarmdb.GetTableKey(tx.Table()), false)
+		if d.RequestType == wire.RT_COMMIT {
+			err = tx.Commit(u)
+		} else {
+			err = tx.Rollback(u)
+		}
+		if err != nil {
+			return newErrorResponse(req.RequestID, err)
+		}
+		return wire.Response{RequestID: req.RequestID, Status: wire.STATUS_OK}
+	case wire.RT_COMPRESSION:
+		session.pendingCompression = wire.ChooseCompression(req.Compression)
+		row := sdbc.NewRow()
+		row["compression"] = session.pendingCompression
+		return wire.Response{RequestID: req.RequestID, Status: wire.STATUS_OK, Data: []sdbc.Row{row}}
+	}
+	if key := idempotencyKeyOf(d, req.IdempotencyKey, req.RequestID); len(key) > 0 {
+		resp, replayed, err := self.idempotency.Do(strings.ToLower(u.Address), key, func() (sdbc.SWARMDBResponse, error) {
+			return self.swarmdb.HandleRequest(u, d)
+		})
+		if err != nil {
+			return newErrorResponse(req.RequestID, err)
+		}
+		self.trackBuffers(session, d)
+		out = wire.NewResponse(req.RequestID, resp)
+		out.Replayed = replayed
+		return out
+	}
+	resp, err := self.swarmdb.HandleRequest(u, d)
+	if err != nil {
+		return newErrorResponse(req.RequestID, err)
+	}
+	self.trackBuffers(session, d)
+	return wire.NewResponse(req.RequestID, resp)
+}
+
+// trackBuffers records the tables a successful RT_START_BUFFER or RT_FLUSH_BUFFER request of the session buffered
+// or flushed
+func (self *TCPServer) trackBuffers(session *TCPSession, d *sdbc.RequestOption) {
+	switch d.RequestType {
+	case sdbc.RT_START_BUFFER:
+		session.trackBuffer(self.swarmdb.GetTableKey(d.Owner, d.Database, d.Table), true)
+	case sdbc.RT_FLUSH_BUFFER:
+		session.trackBuffer(self.swarmdb.GetTableKey(d.Owner, d.Database, d.Table), false)
+	}
+}
+
+func (session *TCPSession) trackBuffer(tblKey string, buffered bool) {
+	if !buffered {
+		delete(session.buffers, tblKey)
+		return
+	}
+	if session.buffers == nil {
+		session.buffers = make(map[string]bool)
+	}
+	session.buffers[tblKey] = true
+}
+
+func (self *TCPServer) handleBatch(session *TCPSession, u *SWARMDBUser, req *wire.Request) (out wire.Response) {
+	ops := make([]*sdbc.RequestOption, len(req.Batch))
+	for i := range req.Batch {
+		ops[i] = &req.Batch[i].RequestOption
+		if session.user != nil {
+			// all operations of a batch run as the owner of the batch
+			ops[i].Owner = req.Owner
+		}
+		session.applyContext(ops[i])
+		if ops[i].RequestType == wire.RT_BATCH {
+			return newErrorResponse(req.RequestID, &sdbc.SWARMDBError{Message: fmt.Sprintf("[tcpserver:handleBatch] nested batch at op %d", i), ErrorCode: 418, ErrorMessage: "Request Invalid: batches cannot be nested"})
+		}
+	}
+	resps, errs := self.swarmdb.HandleBatch(u, ops, req.Atomic)
+	out = wire.Response{RequestID: req.RequestID, Status: wire.STATUS_OK, Results: make([]wire.Response, len(ops))}
+	for i := range ops {
+		if errs[i] != nil {
+			out.Results[i] = newErrorResponse(req.Batch[i].RequestID, errs[i])
+		} else {
+			out.Results[i] = wire.NewResponse(req.Batch[i].RequestID, resps[i])
+		}
+	}
+	return out
+}
+
+// hello negotiates the protocol version of the session
+func (session *TCPSession) hello(req *wire.Request) (out wire.Response) {
+	version, err := wire.NegotiateVersion(req.Version)
+	if err != nil {
+		return newErrorResponse(req.RequestID, err)
+	}
+	session.pendingVersion = version
+	row := sdbc.NewRow()
+	row["version"] = version
+	return wire.Response{RequestID: req.RequestID, Status: wire.STATUS_OK, Data: []sdbc.Row{row}}
+}
+
+// use sets the default owner and database of the session
+func (session *TCPSession) use(req *wire.Request) (out wire.Response) {
+	owner, database := req.Owner, req.Database
+	if len(req.RawQuery) > 0 {
+		fields := strings.Fields(req.RawQuery)
+		if len(fields) != 2 || strings.ToUpper(fields[0]) != "USE" {
+			return newErrorResponse(req.RequestID, &sdbc.SWARMDBError{Message: fmt.Sprintf("[tcpserver:use] invalid [%s]", req.RawQuery), ErrorCode: 418, ErrorMessage: "Request Invalid: expected USE owner/database or USE database"})
+		}
+		owner, database = "", fields[1]
+		if i := strings.LastIndex(fields[1], "/"); i >= 0 {
+			owner, database = fields[1][:i], fields[1][i+1:]
+		}
+	}
+	if len(owner) > 0 {
+		session.defaultOwner = session.canonicalOwner(owner)
+	}
+	session.defaultDatabase = database
+	row := sdbc.NewRow()
+	row["owner"] = session.defaultOwner
+	row["database"] = session.defaultDatabase
+	return wire.Response{RequestID: req.RequestID, Status: wire.STATUS_OK, Data: []sdbc.Row{row}}
+}
+
+// applyContext fills in the owner and database d leaves out from the RT_USE defaults, or the first authenticated
+// owner.  The owner d names is kept: d runs as an authenticated address (see signerFor) and the ACLs decide.
+func (session *TCPSession) applyContext(d *sdbc.RequestOption) {
+	if len(d.Owner) == 0 {
+		d.Owner = session.defaultOwner
+	}
+	if len(d.Owner) == 0 {
+		d.Owner = session.owner
+	}
+	d.Owner = session.canonicalOwner(d.Owner)
+	if len(d.Database) == 0 {
+		d.Database = session.defaultDatabase
+	}
+}
+
+// rollback discards the writes of a transaction left open
+func (session *TCPSession) rollback() {
+	if session.txn != nil {
+		session.txn.Rollback(session.user)
+		session.txn = nil
+	}
+}
+
+// signerFor returns the authenticated address a request naming owner runs as: owner when it signed the session
+// challenge, otherwise the RT_USE owner or the first authenticated owner
+func (session *TCPSession) signerFor(owner string) string {
+	for _, o := range []string{owner, session.defaultOwner} {
+		if _, ok := session.users[strings.ToLower(o)]; ok {
+			return strings.ToLower(o)
+		}
+	}
+	return session.owner
+}
+
+// canonicalOwner returns owner in lower case when it signed the session challenge, as authenticate names it
+func (session *TCPSession) canonicalOwner(owner string) string {
+	if _, ok := session.users[strings.ToLower(owner)]; ok {
+		return strings.ToLower(owner)
+	}
+	return owner
+}
+
+func (self *TCPServer) isClosing() bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.closing
+}
+
+func (self *TCPServer) addSession(session *TCPSession) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.closing {
+		return false
+	}
+	self.sessions[session] = struct{}{}
+	return true
+}
+
+func (self *TCPServer) removeSession(session *TCPSession) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	delete(self.sessions, session)
+}
+
+// beginRequest registers an in-flight request unless the server is shutting down
+func (self *TCPServer) beginRequest() bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.closing {
+		return false
+	}
+	self.inflight.Add(1)
+	return true
+}
+
+// Shutdown stops accepting connections, waits for in-flight requests (until ctx is done) and closes all sessions
+func (self *TCPServer) Shutdown(ctx context.Context) (err error) {
+	self.mu.Lock()
+	self.closing = true
+	if self.listener != nil {
+		self.listener.Close()
+	}
+	self.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		self.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = &sdbc.SWARMDBError{Message: fmt.Sprintf("[tcpserver:Shutdown] %s", ctx.Err()), ErrorCode: 494, ErrorMessage: "Server Shutting Down: in-flight requests did not finish"}
+	}
+
+	self.mu.Lock()
+	for session := range self.sessions {
+		session.conn.Close()
+	}
+	self.mu.Unlock()
+	return err
+}
+
+// newErrorResponse builds the error envelope for err, which is also logged
+func newErrorResponse(requestID string, err error) wire.Response {
+	log.Debug(fmt.Sprintf("[tcpserver:newErrorResponse] [%s] %s", requestID, err.Error()))
+	if tErr, ok := err.(*ThrottledError); ok {
+		err = tErr.SWARMDBError()
+	}
+	return wire.NewErrorResponse(requestID, err)
+}
+
+// writeMessage sends resp in the session's protocol version and compression
+func (session *TCPSession) writeMessage(resp wire.Response) (err error) {
+	out, err := wire.EncodeResponse(session.version, resp)
+	if err != nil {
+		return err
+	}
+	return wire.WriteMessage(session.writer, session.compression, out)
+}
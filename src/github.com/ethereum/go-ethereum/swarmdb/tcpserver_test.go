@@ -0,0 +1,1807 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/crypto"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"github.com/ethereum/go-ethereum/swarmdb/swarmdblib"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	sdb "swarmdb"
+	"sync"
+	"testing"
+	"time"
+)
+
+// write_test_cert writes a self-signed certificate for 127.0.0.1 into dir
+func write_test_cert(t *testing.T, dir string) (certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:write_test_cert] GenerateKey %s", err)
+	}
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "swarmdb test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:write_test_cert] CreateCertificate %s", err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func TestTCPServerTLS(t *testing.T) {
+	owner, database, tableName := make_owner_table(t, strings.ToLower(u.Address), "tls")
+	dir, _ := ioutil.TempDir("", "swarmdbtls")
+	defer os.RemoveAll(dir)
+	certFile, keyFile := write_test_cert(t, dir)
+
+	tlsConfig := *config
+	tlsConfig.TLSCertFile = certFile
+	tlsConfig.TLSKeyFile = keyFile
+	tlsConfig.TLSClientCAFile = certFile
+	tlsConfig.TLSClientAuth = sdb.TLS_CLIENTAUTH_REQUIRED
+	listener, err := tlsConfig.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerTLS] Listen %s", err)
+	}
+	defer listener.Close()
+	go sdb.NewTCPServer(swarmdb, &tlsConfig).Serve(listener)
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	// without a client certificate the handshake must fail
+	dbc, err := swarmdblib.OpenTLSConnection("127.0.0.1", port, &swarmdblib.TLSOptions{CAFile: certFile})
+	if err == nil {
+		_, err = dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: "x"})
+		dbc.Close()
+		if err == nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerTLS] request without client certificate succeeded")
+		}
+	}
+
+	dbc, err = swarmdblib.OpenTLSConnection("127.0.0.1", port, &swarmdblib.TLSOptions{CAFile: certFile, CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerTLS] OpenTLSConnection %s", err)
+	}
+	defer dbc.Close()
+	if _, err = dbc.Authenticate(config.PrivateKey); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerTLS] Authenticate %s", err)
+	}
+
+	row := sdbc.NewRow()
+	row["email"] = "tls@wolk.com"
+	row["name"] = "Tess"
+	row["age"] = 29
+	if _, err = dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{row}}); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerTLS] PUT %s", err)
+	}
+	res, err := dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: "tls@wolk.com"})
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerTLS] GET %s", err)
+	}
+	if len(res.Data) != 1 || res.Data[0]["name"] != "Tess" {
+		t.Fatalf("[tcpserver_test:TestTCPServerTLS] GET returned %s", res.Stringify())
+	}
+}
+
+func TestTCPServerAuthentication(t *testing.T) {
+	owner, database, tableName := make_owner_table(t, strings.ToLower(u.Address), "auth")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerAuthentication] Listen %s", err)
+	}
+	defer listener.Close()
+	authConfig := *config
+	authConfig.Authentication = 1
+	go sdb.NewTCPServer(swarmdb, &authConfig).Serve(listener)
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	// requests before the challenge is signed are refused
+	dbc, err := swarmdblib.OpenConnection("127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerAuthentication] OpenConnection %s", err)
+	}
+	_, err = dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: "x"})
+	if wErr, ok := err.(*wire.Error); !ok || wErr.Code != wire.ErrUnauthorized {
+		t.Fatalf("[tcpserver_test:TestTCPServerAuthentication] unauthenticated request returned %v", err)
+	}
+	dbc.Close()
+
+	dbc, err = swarmdblib.OpenConnection("127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerAuthentication] OpenConnection %s", err)
+	}
+	defer dbc.Close()
+	sessionOwner, err := dbc.Authenticate(config.PrivateKey)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerAuthentication] Authenticate %s", err)
+	}
+	if sessionOwner != owner {
+		t.Fatalf("[tcpserver_test:TestTCPServerAuthentication] session owner %s, expected %s", sessionOwner, owner)
+	}
+
+	// the Owner field names the table written, not the caller
+	row := sdbc.NewRow()
+	row["email"] = "auth@wolk.com"
+	row["name"] = "Ada"
+	row["age"] = 36
+	if _, err = dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: "someoneelse.eth", Database: database, Table: tableName, Rows: []sdbc.Row{row}}); err == nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerAuthentication] PUT into a table of another owner succeeded")
+	}
+	if _, err = dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_PUT, Database: database, Table: tableName, Rows: []sdbc.Row{row}}); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerAuthentication] PUT %s", err)
+	}
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerAuthentication] GetTable %s", err)
+	}
+	if _, ok, err := tbl.Get(u, []byte("auth@wolk.com")); err != nil || !ok {
+		t.Fatalf("[tcpserver_test:TestTCPServerAuthentication] row not stored under authenticated owner %v %s", ok, err)
+	}
+
+	// Open authenticates with the key of its config
+	configured, err := swarmdblib.Open(swarmdblib.ConnectionConfig{IP: "127.0.0.1", Port: port, PrivateKey: config.PrivateKey, DialTimeout: time.Second, RequestTimeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerAuthentication] Open %s", err)
+	}
+	defer configured.Close()
+	if configured.Owner != owner {
+		t.Fatalf("[tcpserver_test:TestTCPServerAuthentication] Open authenticated as %s, expected %s", configured.Owner, owner)
+	}
+	if res, err := configured.Get(owner, database, tableName, "auth@wolk.com"); err != nil || len(res.Data) != 1 {
+		t.Fatalf("[tcpserver_test:TestTCPServerAuthentication] Get on opened connection %v %v", res.Data, err)
+	}
+}
+
+func TestTCPServerMultiOwner(t *testing.T) {
+	// a second configured user, so the node accepts its signature
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerMultiOwner] GenerateKey %s", err)
+	}
+	defer func(users []sdb.SWARMDBUser) { config.Users = users }(config.Users)
+	config.Users = append(config.Users, sdb.SWARMDBUser{Address: crypto.PubkeyToAddress(key.PublicKey).Hex()})
+	owner1 := strings.ToLower(u.Address)
+	owner2 := strings.ToLower(crypto.PubkeyToAddress(key.PublicKey).Hex())
+	_, database1, tableName1 := make_owner_table(t, owner1, "multi1")
+	_, database2, tableName2 := make_owner_table(t, owner2, "multi2")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerMultiOwner] Listen %s", err)
+	}
+	authConfig := *config
+	authConfig.Authentication = 1
+	srv := sdb.NewTCPServer(swarmdb, &authConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+	dbc, err := swarmdblib.OpenConnection("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerMultiOwner] OpenConnection %s", err)
+	}
+	defer dbc.Close()
+	if _, err = dbc.Authenticate(config.PrivateKey); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerMultiOwner] Authenticate %s", err)
+	}
+	if owner, err := dbc.Authenticate(fmt.Sprintf("%x", crypto.FromECDSA(key))); err != nil || owner != owner2 {
+		t.Fatalf("[tcpserver_test:TestTCPServerMultiOwner] Authenticate second key %s %v", owner, err)
+	}
+	if dbc.Owner != owner1 || len(dbc.Owners) != 2 || !dbc.IsOwner(owner2) {
+		t.Fatalf("[tcpserver_test:TestTCPServerMultiOwner] owners %s %v", dbc.Owner, dbc.Owners)
+	}
+
+	// each request runs as the owner it names
+	for _, c := range []struct{ owner, database, tableName string }{{owner1, database1, tableName1}, {owner2, database2, tableName2}} {
+		row := sdbc.Row{"email": "multi@wolk.com", "name": c.owner, "age": 1}
+		if _, err = dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: c.owner, Database: c.database, Table: c.tableName, Rows: []sdbc.Row{row}}); err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerMultiOwner] PUT as %s %s", c.owner, err)
+		}
+		tbl, err := swarmdb.GetTable(u, c.owner, c.database, c.tableName)
+		if err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerMultiOwner] GetTable %s", err)
+		}
+		if _, ok, err := tbl.Get(u, []byte("multi@wolk.com")); err != nil || !ok {
+			t.Fatalf("[tcpserver_test:TestTCPServerMultiOwner] row not stored under %s %v %s", c.owner, ok, err)
+		}
+	}
+
+	// USE switches the default to another authenticated owner, which requests then run as
+	if err = dbc.Use(owner2, database2); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerMultiOwner] Use %s", err)
+	}
+	resp, err := dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_GET, Table: tableName2, Key: "multi@wolk.com"})
+	if err != nil || len(resp.Data) != 1 || resp.Data[0]["name"] != owner2 {
+		t.Fatalf("[tcpserver_test:TestTCPServerMultiOwner] GET after Use %v %v", resp.Data, err)
+	}
+}
+
+func TestTCPServerGrants(t *testing.T) {
+	// a grantee and a stranger, configured so the node accepts their signatures
+	granteeKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerGrants] GenerateKey %s", err)
+	}
+	strangerKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerGrants] GenerateKey %s", err)
+	}
+	defer func(users []sdb.SWARMDBUser) { config.Users = users }(config.Users)
+	grantee := crypto.PubkeyToAddress(granteeKey.PublicKey).Hex()
+	config.Users = append(config.Users, sdb.SWARMDBUser{Address: grantee}, sdb.SWARMDBUser{Address: crypto.PubkeyToAddress(strangerKey.PublicKey).Hex()})
+
+	owner, database, tableName := make_table(t, "tcpgrant")
+	row := sdbc.Row{"email": "grant@wolk.com", "name": "Gwen", "age": 33}
+	if _, err = swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{row}}); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerGrants] PUT %s", err)
+	}
+	grant := sdbc.Row{"address": grantee, "permission": "read"}
+	if _, err = swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdb.RT_GRANT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{grant}}); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerGrants] GRANT %s", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerGrants] Listen %s", err)
+	}
+	authConfig := *config
+	authConfig.Authentication = 1
+	srv := sdb.NewTCPServer(swarmdb, &authConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+	connect := func(key *ecdsa.PrivateKey) *swarmdblib.SWARMDBConnection {
+		dbc, err := swarmdblib.OpenConnection("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+		if err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerGrants] OpenConnection %s", err)
+		}
+		if _, err = dbc.Authenticate(fmt.Sprintf("%x", crypto.FromECDSA(key))); err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerGrants] Authenticate %s", err)
+		}
+		return dbc
+	}
+
+	// the grantee reads the table of the owner it names, as its grant allows
+	dbc := connect(granteeKey)
+	defer dbc.Close()
+	resp, err := dbc.Get(owner, database, tableName, "grant@wolk.com")
+	if err != nil || len(resp.Data) != 1 || resp.Data[0]["name"] != "Gwen" {
+		t.Fatalf("[tcpserver_test:TestTCPServerGrants] Get as grantee %v %v", resp.Data, err)
+	}
+	row["name"] = "Mallory"
+	if _, err = dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{row}}); !errors.Is(err, swarmdblib.ErrPermissionDenied) {
+		t.Fatalf("[tcpserver_test:TestTCPServerGrants] PUT as grantee returned %v", err)
+	}
+
+	// a stranger is refused
+	stranger := connect(strangerKey)
+	defer stranger.Close()
+	if _, err = stranger.Get(owner, database, tableName, "grant@wolk.com"); !errors.Is(err, swarmdblib.ErrPermissionDenied) {
+		t.Fatalf("[tcpserver_test:TestTCPServerGrants] Get as stranger returned %v", err)
+	}
+}
+
+func TestTCPServerShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerShutdown] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(listener) }()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	dbc, err := swarmdblib.OpenConnection("127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerShutdown] OpenConnection %s", err)
+	}
+	defer dbc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = srv.Shutdown(ctx); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerShutdown] Shutdown %s", err)
+	}
+	if err = <-served; err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerShutdown] Serve returned %s", err)
+	}
+	if _, err = dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_LIST_DATABASES}); err == nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerShutdown] request after Shutdown succeeded")
+	}
+	if _, err = swarmdblib.OpenConnection("127.0.0.1", port); err == nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerShutdown] connection accepted after Shutdown")
+	}
+}
+
+func TestTCPServerCompression(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerCompression] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	owner, database, tableName := make_table(t, "compress", sdbc.Column{ColumnName: "notes", IndexType: sdbc.IT_NONE, ColumnType: sdbc.CT_STRING})
+	for _, alg := range []string{wire.COMPRESSION_GZIP, wire.COMPRESSION_SNAPPY} {
+		dbc, err := swarmdblib.OpenConnection("127.0.0.1", port)
+		if err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerCompression] OpenConnection %s", err)
+		}
+		compression, err := dbc.NegotiateCompression(alg, "lz4")
+		if err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerCompression] NegotiateCompression %s", err)
+		}
+		if compression != alg {
+			t.Fatalf("[tcpserver_test:TestTCPServerCompression] negotiated %s, expected %s", compression, alg)
+		}
+		email := alg + "@wolk.com"
+		row := sdbc.Row{"email": email, "name": "Compressed", "age": 1, "notes": strings.Repeat("x", 3000)}
+		if _, err = dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{row}}); err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerCompression] Put %s", err)
+		}
+		resp, err := dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: email})
+		if err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerCompression] Get %s", err)
+		}
+		if len(resp.Data) != 1 || resp.Data[0]["notes"] != row["notes"] {
+			t.Fatalf("[tcpserver_test:TestTCPServerCompression] Get returned %v", resp.Data)
+		}
+		dbc.Close()
+	}
+}
+
+func TestTCPServerAdmin(t *testing.T) {
+	owner, database, tableName := make_owner_table(t, strings.ToLower(u.Address), "admin")
+	serve := func(admins []string) int {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerAdmin] Listen %s", err)
+		}
+		adminConfig := *config
+		adminConfig.Address = ""
+		adminConfig.Admins = admins
+		srv := sdb.NewTCPServer(swarmdb, &adminConfig)
+		go srv.Serve(listener)
+		return listener.Addr().(*net.TCPAddr).Port
+	}
+	connect := func(port int, authenticate bool) *swarmdblib.SWARMDBConnection {
+		dbc, err := swarmdblib.OpenConnection("127.0.0.1", port)
+		if err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerAdmin] OpenConnection %s", err)
+		}
+		if authenticate {
+			if _, err = dbc.Authenticate(config.PrivateKey); err != nil {
+				t.Fatalf("[tcpserver_test:TestTCPServerAdmin] Authenticate %s", err)
+			}
+		}
+		return dbc
+	}
+	tblOpen := func(resp sdbc.SWARMDBResponse) bool {
+		for _, row := range resp.Data {
+			if row["owner"] == owner && row["database"] == database && row["table"] == tableName {
+				return true
+			}
+		}
+		return false
+	}
+
+	// admin commands are refused before authentication and for non-admin addresses
+	port := serve([]string{u.Address})
+	dbc := connect(port, false)
+	_, err := dbc.Admin(sdb.ADMIN_LIST_TABLES, sdbc.RequestOption{})
+	if wErr, ok := err.(*wire.Error); !ok || wErr.Code != wire.ErrUnauthorized {
+		t.Fatalf("[tcpserver_test:TestTCPServerAdmin] unauthenticated admin command returned %v", err)
+	}
+	dbc.Close()
+	dbc = connect(serve(nil), true)
+	_, err = dbc.Admin(sdb.ADMIN_LIST_TABLES, sdbc.RequestOption{})
+	if wErr, ok := err.(*wire.Error); !ok || wErr.Code != wire.ErrAccessDenied {
+		t.Fatalf("[tcpserver_test:TestTCPServerAdmin] non-admin command returned %v", err)
+	}
+	dbc.Close()
+
+	dbc = connect(port, true)
+	defer dbc.Close()
+	resp, err := dbc.Admin(sdb.ADMIN_LIST_TABLES, sdbc.RequestOption{})
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerAdmin] ListOpenTables %s", err)
+	}
+	if !tblOpen(resp) {
+		t.Fatalf("[tcpserver_test:TestTCPServerAdmin] ListOpenTables missing %s: %v", tableName, resp.Data)
+	}
+	if _, err = dbc.Admin(sdb.ADMIN_CACHE_STATS, sdbc.RequestOption{}); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerAdmin] CacheStats %s", err)
+	}
+	if _, err = dbc.Admin(sdb.ADMIN_GC, sdbc.RequestOption{}); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerAdmin] GC %s", err)
+	}
+	if _, err = dbc.Admin(sdb.ADMIN_FLUSH, sdbc.RequestOption{Owner: owner, Database: database, Table: tableName}); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerAdmin] Flush %s", err)
+	}
+	resp, err = dbc.Admin(sdb.ADMIN_CONFIG, sdbc.RequestOption{})
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerAdmin] Config %s", err)
+	}
+	if len(resp.Data) != 1 || resp.Data[0]["privateKey"] != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerAdmin] Config not redacted: %v", resp.Data)
+	}
+	if _, err = dbc.Admin(sdb.ADMIN_CLOSE_TABLE, sdbc.RequestOption{Owner: owner, Database: database, Table: tableName}); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerAdmin] CloseTable %s", err)
+	}
+	resp, err = dbc.Admin(sdb.ADMIN_LIST_TABLES, sdbc.RequestOption{})
+	if err != nil || tblOpen(resp) {
+		t.Fatalf("[tcpserver_test:TestTCPServerAdmin] table still open after CloseTable: %v %v", resp.Data, err)
+	}
+}
+
+func TestTCPServerUse(t *testing.T) {
+	owner, database, tableName := make_table(t, "use")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerUse] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	dbc, err := swarmdblib.OpenConnection("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerUse] OpenConnection %s", err)
+	}
+	defer dbc.Close()
+	if err = dbc.Use(owner, database); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerUse] Use %s", err)
+	}
+	row := sdbc.Row{"email": "use@wolk.com", "name": "Grace", "age": 45}
+	if _, err = dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_PUT, Table: tableName, Rows: []sdbc.Row{row}}); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerUse] Put %s", err)
+	}
+	resp, err := dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: sdbc.RT_GET, Table: tableName, Key: "use@wolk.com"})
+	if err != nil || len(resp.Data) != 1 || resp.Data[0]["name"] != "Grace" {
+		t.Fatalf("[tcpserver_test:TestTCPServerUse] Get %v %v", resp.Data, err)
+	}
+
+	// the raw "USE owner/database" form
+	if _, err = dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: wire.RT_USE, RawQuery: "USE " + owner + "/" + database}); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerUse] USE %s", err)
+	}
+	if _, err = dbc.ProcessRequestResponseCommand(sdbc.RequestOption{RequestType: wire.RT_USE, RawQuery: "USE"}); err == nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerUse] malformed USE accepted")
+	}
+}
+
+func TestTCPServerProtocolVersion(t *testing.T) {
+	owner, database, tableName := make_table(t, "version")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerProtocolVersion] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	dbc, err := swarmdblib.OpenConnection("127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerProtocolVersion] OpenConnection %s", err)
+	}
+	defer dbc.Close()
+	if dbc.Version != wire.PROTOCOL_VERSION {
+		t.Fatalf("[tcpserver_test:TestTCPServerProtocolVersion] negotiated version %d", dbc.Version)
+	}
+
+	// a version 1 client never says hello and gets bare responses and legacy errors
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerProtocolVersion] Dial %s", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	if _, err = reader.ReadString('\n'); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerProtocolVersion] challenge %s", err)
+	}
+	roundTrip := func(req sdbc.RequestOption) map[string]interface{} {
+		out, _ := json.Marshal(req)
+		if _, err := conn.Write(append(out, '\n')); err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerProtocolVersion] Write %s", err)
+		}
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerProtocolVersion] ReadBytes %s", err)
+		}
+		var resp map[string]interface{}
+		if err = json.Unmarshal(line, &resp); err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerProtocolVersion] Unmarshal %s [%s]", err, line)
+		}
+		return resp
+	}
+	resp := roundTrip(sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: "nobody@wolk.com"})
+	if _, ok := resp["status"]; ok {
+		t.Fatalf("[tcpserver_test:TestTCPServerProtocolVersion] version 1 response has an envelope: %v", resp)
+	}
+	resp = roundTrip(sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: "notatable", Key: "x"})
+	if _, ok := resp["errorcode"]; !ok {
+		t.Fatalf("[tcpserver_test:TestTCPServerProtocolVersion] version 1 error not in legacy form: %v", resp)
+	}
+}
+
+func TestTCPServerContext(t *testing.T) {
+	owner, database, tableName := make_table(t, "ctx")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerContext] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	dbc, err := swarmdblib.OpenConnection("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerContext] OpenConnection %s", err)
+	}
+	defer dbc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	row := sdbc.Row{"email": "ctx@wolk.com", "name": "Barbara", "age": 61}
+	if _, err = dbc.PutCtx(ctx, owner, database, tableName, []sdbc.Row{row}); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerContext] PutCtx %s", err)
+	}
+	resp, err := dbc.GetCtx(ctx, owner, database, tableName, "ctx@wolk.com")
+	if err != nil || len(resp.Data) != 1 {
+		t.Fatalf("[tcpserver_test:TestTCPServerContext] GetCtx %v %v", resp.Data, err)
+	}
+
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if _, err = dbc.GetCtx(canceled, owner, database, tableName, "ctx@wolk.com"); err == nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerContext] canceled GetCtx succeeded")
+	} else if wErr, ok := err.(*wire.Error); !ok || wErr.Code != wire.ErrAborted {
+		t.Fatalf("[tcpserver_test:TestTCPServerContext] canceled GetCtx returned %v", err)
+	}
+
+	// the server refuses work whose deadline has passed
+	expired := u.WithDeadline(time.Now().Add(-time.Second))
+	_, err = swarmdb.HandleRequest(expired, &sdbc.RequestOption{RequestType: sdbc.RT_SCAN, Owner: owner, Database: database, Table: tableName})
+	if err == nil || wire.NewErrorResponse("", err).ErrorCode != wire.ErrTimeout {
+		t.Fatalf("[tcpserver_test:TestTCPServerContext] expired request returned %v", err)
+	}
+}
+
+func TestClientPool(t *testing.T) {
+	owner, database, tableName := make_table(t, "pool")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientPool] Listen %s", err)
+	}
+	addr := listener.Addr().String()
+	port := listener.Addr().(*net.TCPAddr).Port
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+
+	pool := swarmdblib.NewPool(swarmdblib.PoolConfig{IP: "127.0.0.1", Port: port, MaxOpen: 2, MaxIdle: 2, HealthCheck: time.Nanosecond, MinBackoff: 10 * time.Millisecond})
+	defer pool.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// concurrent use never opens more than MaxOpen connections
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		go func(i int) {
+			row := sdbc.Row{"email": fmt.Sprintf("pool%d@wolk.com", i), "name": "Pool", "age": i}
+			_, err := pool.ProcessRequestCtx(ctx, sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{row}})
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("[tcpserver_test:TestClientPool] Put %s", err)
+		}
+	}
+	if open, _ := pool.Stats(); open > 2 {
+		t.Fatalf("[tcpserver_test:TestClientPool] %d connections open, MaxOpen is 2", open)
+	}
+
+	// restart the server: idle connections fail their health check and are replaced
+	srv.Shutdown(context.Background())
+	listener, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientPool] Listen again %s", err)
+	}
+	srv = sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+	resp, err := pool.ProcessRequestCtx(ctx, sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: "pool3@wolk.com"})
+	if err != nil || len(resp.Data) != 1 {
+		t.Fatalf("[tcpserver_test:TestClientPool] Get after restart %v %v", resp.Data, err)
+	}
+}
+
+func TestClientEmbedded(t *testing.T) {
+	owner, database, tableName := make_table(t, "embedded")
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	defer srv.Shutdown(context.Background())
+
+	dbc, err := swarmdblib.OpenEmbeddedConnection(srv)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientEmbedded] OpenEmbeddedConnection %s", err)
+	}
+	defer dbc.Close()
+	if dbc.Version != wire.PROTOCOL_VERSION {
+		t.Fatalf("[tcpserver_test:TestClientEmbedded] negotiated version %d", dbc.Version)
+	}
+	row := sdbc.Row{"email": "embedded@wolk.com", "name": "Emma", "age": 31}
+	if _, err = dbc.Put(owner, database, tableName, []sdbc.Row{row}); err != nil {
+		t.Fatalf("[tcpserver_test:TestClientEmbedded] Put %s", err)
+	}
+	resp, err := dbc.Get(owner, database, tableName, "embedded@wolk.com")
+	if err != nil || len(resp.Data) != 1 || resp.Data[0]["name"] != "Emma" {
+		t.Fatalf("[tcpserver_test:TestClientEmbedded] Get %v %v", resp.Data, err)
+	}
+
+	// pools open embedded connections too
+	pool := swarmdblib.NewPool(swarmdblib.PoolConfig{Embedded: srv})
+	defer pool.Close()
+	resp, err = pool.ProcessRequestCtx(context.Background(), sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: "embedded@wolk.com"})
+	if err != nil || len(resp.Data) != 1 {
+		t.Fatalf("[tcpserver_test:TestClientEmbedded] pool Get %v %v", resp.Data, err)
+	}
+}
+
+func TestClientIdempotency(t *testing.T) {
+	owner, database, tableName := make_table(t, "idempotent")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientIdempotency] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+	dbc, err := swarmdblib.OpenConnection("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientIdempotency] OpenConnection %s", err)
+	}
+	defer dbc.Close()
+
+	// an insert resent with the same key returns the first result instead of failing on the duplicate key
+	insert := sdbc.RequestOption{RequestType: sdbc.RT_QUERY, Owner: owner, Database: database, RawQuery: fmt.Sprintf("insert into %s (email, name, age) values ('once@wolk.com', 'Once', 1)", tableName)}
+	key := swarmdblib.NewIdempotencyKey()
+	for i := 0; i < 2; i++ {
+		resp, err := dbc.ProcessIdempotentRequest(context.Background(), insert, key)
+		if err != nil || resp.AffectedRowCount != 1 {
+			t.Fatalf("[tcpserver_test:TestClientIdempotency] insert %d %v %v", i, resp.AffectedRowCount, err)
+		}
+	}
+	if _, err = dbc.ProcessIdempotentRequest(context.Background(), insert, swarmdblib.NewIdempotencyKey()); err == nil {
+		t.Fatalf("[tcpserver_test:TestClientIdempotency] insert with a new key did not fail on the duplicate key")
+	}
+
+	// pooled writes are retried with a key of their own
+	pool := swarmdblib.NewPool(swarmdblib.PoolConfig{IP: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port, Retry: swarmdblib.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}})
+	defer pool.Close()
+	insert.RawQuery = fmt.Sprintf("insert into %s (email, name, age) values ('pooled@wolk.com', 'Pooled', 2)", tableName)
+	if _, err = pool.ProcessRequestCtx(context.Background(), insert); err != nil {
+		t.Fatalf("[tcpserver_test:TestClientIdempotency] pooled insert %s", err)
+	}
+}
+
+func TestTCPServerTransaction(t *testing.T) {
+	owner, database, tableName := make_table(t, "txn")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerTransaction] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+	port := listener.Addr().(*net.TCPAddr).Port
+	dbc, err := swarmdblib.OpenConnection("127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerTransaction] OpenConnection %s", err)
+	}
+	defer dbc.Close()
+
+	// rolled back writes never happened
+	tx, err := dbc.Begin(owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerTransaction] Begin %s", err)
+	}
+	if _, err = tx.Put([]sdbc.Row{{"email": "rollback@wolk.com", "name": "Rolf", "age": 1}}); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerTransaction] Put %s", err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerTransaction] Rollback %s", err)
+	}
+	if _, err = dbc.Get(owner, database, tableName, "rollback@wolk.com"); !errors.Is(err, swarmdblib.ErrNotFound) {
+		t.Fatalf("[tcpserver_test:TestTCPServerTransaction] rolled back row read returned %v", err)
+	}
+
+	// committed writes are all there
+	if tx, err = dbc.Begin(owner, database, tableName); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerTransaction] Begin %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err = tx.Put([]sdbc.Row{{"email": fmt.Sprintf("commit%d@wolk.com", i), "name": "Cora", "age": i}}); err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerTransaction] Put %s", err)
+		}
+	}
+	if _, err = tx.Delete("commit0@wolk.com"); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerTransaction] Delete %s", err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerTransaction] Commit %s", err)
+	}
+	if _, err = dbc.Get(owner, database, tableName, "commit2@wolk.com"); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerTransaction] committed row read returned %v", err)
+	}
+	if _, err = dbc.Get(owner, database, tableName, "commit0@wolk.com"); !errors.Is(err, swarmdblib.ErrNotFound) {
+		t.Fatalf("[tcpserver_test:TestTCPServerTransaction] deleted row read returned %v", err)
+	}
+
+	// a table holds one transaction at a time, and a closed connection rolls its transaction back
+	other, err := swarmdblib.OpenConnection("127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerTransaction] OpenConnection %s", err)
+	}
+	if _, err = other.Begin(owner, database, tableName); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerTransaction] Begin %s", err)
+	}
+	if _, err = dbc.Begin(owner, database, tableName); !errors.Is(err, swarmdblib.ErrConflict) {
+		t.Fatalf("[tcpserver_test:TestTCPServerTransaction] second Begin returned %v", err)
+	}
+	other.Close()
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if tx, err = dbc.Begin(owner, database, tableName); err == nil {
+			tx.Rollback()
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("[tcpserver_test:TestTCPServerTransaction] transaction of a closed connection not rolled back: %v", err)
+		}
+	}
+}
+
+// acceptCounter counts the connections the server accepted
+type acceptCounter struct {
+	net.Listener
+	accepted chan struct{}
+}
+
+func (l *acceptCounter) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted <- struct{}{}
+	}
+	return conn, err
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientKeepAlive] Listen %s", err)
+	}
+	addr := listener.Addr().String()
+	port := listener.Addr().(*net.TCPAddr).Port
+	authConfig := *config
+	authConfig.Authentication = 1
+	srv := sdb.NewTCPServer(swarmdb, &authConfig)
+	go srv.Serve(listener)
+
+	// pings are answered before authentication
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientKeepAlive] Dial %s", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	if _, err = reader.ReadString('\n'); err != nil {
+		t.Fatalf("[tcpserver_test:TestClientKeepAlive] challenge %s", err)
+	}
+	if _, err = conn.Write([]byte(`{"requesttype":"Ping"}` + "\n")); err != nil {
+		t.Fatalf("[tcpserver_test:TestClientKeepAlive] Write %s", err)
+	}
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientKeepAlive] ReadBytes %s", err)
+	}
+	var resp sdbc.SWARMDBResponse
+	if err = json.Unmarshal(line, &resp); err != nil || len(resp.Data) != 1 || resp.Data[0]["pong"] == nil {
+		t.Fatalf("[tcpserver_test:TestClientKeepAlive] pong %s %v", line, err)
+	}
+
+	pool := swarmdblib.NewPool(swarmdblib.PoolConfig{IP: "127.0.0.1", Port: port, MaxOpen: 1, MaxIdle: 1, KeepAlive: 50 * time.Millisecond, PingTimeout: time.Second, MinBackoff: 10 * time.Millisecond})
+	defer pool.Close()
+	dbc, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientKeepAlive] Get %s", err)
+	}
+	pool.Put(dbc)
+
+	// restart the server: the keep-alive finds the dead idle connection and redials without any request
+	srv.Shutdown(context.Background())
+	listener, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientKeepAlive] Listen again %s", err)
+	}
+	counter := &acceptCounter{Listener: listener, accepted: make(chan struct{}, 4)}
+	srv = sdb.NewTCPServer(swarmdb, &authConfig)
+	go srv.Serve(counter)
+	defer srv.Shutdown(context.Background())
+	select {
+	case <-counter.accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("[tcpserver_test:TestClientKeepAlive] dead connection not replaced")
+	}
+}
+
+func TestClientRow(t *testing.T) {
+	columns := []sdbc.Column{
+		sdbc.Column{ColumnName: "email", ColumnType: sdbc.CT_STRING},
+		sdbc.Column{ColumnName: "age", ColumnType: sdbc.CT_INTEGER},
+		sdbc.Column{ColumnName: "score", ColumnType: sdbc.CT_FLOAT},
+		sdbc.Column{ColumnName: "joined", ColumnType: sdbc.CT_STRING},
+		sdbc.Column{ColumnName: "avatar", ColumnType: sdbc.CT_BLOB},
+	}
+	resp := sdbc.SWARMDBResponse{Data: []sdbc.Row{{"email": "row@wolk.com", "age": float64(42), "score": "3.5", "joined": "2018-03-01T10:00:00Z", "avatar": "aGk="}}}
+	rows := swarmdblib.NewSWARMDBRows(resp, columns)
+	if len(rows) != 1 {
+		t.Fatalf("[tcpserver_test:TestClientRow] %d rows", len(rows))
+	}
+	row := rows[0]
+	if age, err := row.GetInt("age"); err != nil || age != 42 {
+		t.Fatalf("[tcpserver_test:TestClientRow] GetInt %d %v", age, err)
+	}
+	if score, err := row.GetFloat("score"); err != nil || score != 3.5 {
+		t.Fatalf("[tcpserver_test:TestClientRow] GetFloat %f %v", score, err)
+	}
+	if joined, err := row.GetTime("joined"); err != nil || joined.Year() != 2018 {
+		t.Fatalf("[tcpserver_test:TestClientRow] GetTime %s %v", joined, err)
+	}
+	if avatar, err := row.GetBytes("avatar"); err != nil || string(avatar) != "hi" {
+		t.Fatalf("[tcpserver_test:TestClientRow] GetBytes %s %v", avatar, err)
+	}
+	if _, err := row.GetInt("email"); err == nil {
+		t.Fatalf("[tcpserver_test:TestClientRow] GetInt of a non-number succeeded")
+	}
+	if _, err := row.GetBool("shoesize"); err == nil {
+		t.Fatalf("[tcpserver_test:TestClientRow] GetBool of an unknown column succeeded")
+	} else if wErr, ok := err.(*wire.Error); !ok || wErr.Code != wire.ErrNoSuchColumn {
+		t.Fatalf("[tcpserver_test:TestClientRow] GetBool of an unknown column returned %v", err)
+	}
+}
+
+func TestTCPServerScanRange(t *testing.T) {
+	owner, database, tableName := make_table(t, "range")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerScanRange] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	dbc, err := swarmdblib.OpenConnection("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerScanRange] OpenConnection %s", err)
+	}
+	defer dbc.Close()
+	for i := 0; i < 10; i++ {
+		row := sdbc.Row{"email": fmt.Sprintf("r%d@wolk.com", i), "name": "Range", "age": i}
+		if _, err = dbc.Put(owner, database, tableName, []sdbc.Row{row}); err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerScanRange] Put %s", err)
+		}
+	}
+
+	ctx := context.Background()
+	scan := func(ascending int) (emails []string) {
+		err := dbc.ScanRange(ctx, owner, database, tableName, "r2@wolk.com", "r7@wolk.com", 2, ascending, func(row sdbc.Row) bool {
+			emails = append(emails, row["email"].(string))
+			return true
+		})
+		if err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerScanRange] ScanRange %s", err)
+		}
+		return emails
+	}
+	if got := strings.Join(scan(1), ","); got != "r2@wolk.com,r3@wolk.com,r4@wolk.com,r5@wolk.com,r6@wolk.com" {
+		t.Fatalf("[tcpserver_test:TestTCPServerScanRange] ascending scan returned %s", got)
+	}
+	if got := strings.Join(scan(0), ","); got != "r6@wolk.com,r5@wolk.com,r4@wolk.com,r3@wolk.com,r2@wolk.com" {
+		t.Fatalf("[tcpserver_test:TestTCPServerScanRange] descending scan returned %s", got)
+	}
+
+	rows, token, err := dbc.ScanPage(ctx, owner, database, tableName, wire.ScanRange{Limit: 3, Ascending: 1})
+	if err != nil || len(rows) != 3 || len(token) == 0 {
+		t.Fatalf("[tcpserver_test:TestTCPServerScanRange] first page %v [%s] %v", rows, token, err)
+	}
+	token0 := token
+	if _, _, err = dbc.ScanPage(ctx, owner, database, tableName, wire.ScanRange{Ascending: 0, Token: token}); err == nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerScanRange] token accepted for the opposite direction")
+	}
+
+	// the later pages read the table as the first page did, although writes were flushed in between
+	time.Sleep(5 * time.Millisecond)
+	changes := []sdbc.Row{{"email": "r5a@wolk.com", "name": "Late", "age": 5}, {"email": "r4@wolk.com", "name": "Changed", "age": 4}}
+	if _, err = dbc.Put(owner, database, tableName, changes); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerScanRange] Put %s", err)
+	}
+	var paged []string
+	for len(token) > 0 {
+		if rows, token, err = dbc.ScanPage(ctx, owner, database, tableName, wire.ScanRange{Limit: 3, Ascending: 1, Token: token}); err != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerScanRange] ScanPage %s", err)
+		}
+		for _, row := range rows {
+			paged = append(paged, fmt.Sprintf("%s:%s", row["email"], row["name"]))
+		}
+	}
+	if got := strings.Join(paged, ","); got != "r3@wolk.com:Range,r4@wolk.com:Range,r5@wolk.com:Range,r6@wolk.com:Range,r7@wolk.com:Range,r8@wolk.com:Range,r9@wolk.com:Range" {
+		t.Fatalf("[tcpserver_test:TestTCPServerScanRange] later pages returned %s", got)
+	}
+	_, otherDatabase, otherTable := make_owner_table(t, owner, "rangeother")
+	if _, _, err = dbc.ScanPage(ctx, owner, otherDatabase, otherTable, wire.ScanRange{Ascending: 1, Token: token0}); err == nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerScanRange] token accepted for another table")
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	owner, database, tableName := make_table(t, "errors")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientErrors] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	dbc, err := swarmdblib.OpenConnection("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientErrors] OpenConnection %s", err)
+	}
+	defer dbc.Close()
+	if _, err = dbc.Get(owner, database, tableName, "nobody@wolk.com"); !errors.Is(err, swarmdblib.ErrNotFound) {
+		t.Fatalf("[tcpserver_test:TestClientErrors] missing row returned %v", err)
+	}
+	if _, err = dbc.Get(owner, database, "notatable", "nobody@wolk.com"); !errors.Is(err, swarmdblib.ErrNoSuchTable) {
+		t.Fatalf("[tcpserver_test:TestClientErrors] missing table returned %v", err)
+	}
+	if errors.Is(err, swarmdblib.ErrNotFound) {
+		t.Fatalf("[tcpserver_test:TestClientErrors] %v matches ErrNotFound", err)
+	}
+
+	// in the server, ErrorOf classifies errors the same way, wrapped or not
+	_, err = swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: "notatable", Key: "nobody@wolk.com"})
+	if wErr := wire.ErrorOf(fmt.Errorf("lookup: %w", err)); !errors.Is(wErr, swarmdblib.ErrNoSuchTable) {
+		t.Fatalf("[tcpserver_test:TestClientErrors] ErrorOf missing table returned %v", wErr)
+	}
+	if wErr := wire.ErrorOf(new(sdbc.DuplicateKeyError)); !errors.Is(wErr, swarmdblib.ErrDuplicateKey) || len(wErr.Error()) == 0 {
+		t.Fatalf("[tcpserver_test:TestClientErrors] ErrorOf duplicate key returned %v", wErr)
+	}
+	if wire.ErrorOf(nil) != nil {
+		t.Fatalf("[tcpserver_test:TestClientErrors] ErrorOf(nil) is not nil")
+	}
+}
+
+func TestClientIncrement(t *testing.T) {
+	owner, database, tableName := make_table(t, "counter")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientIncrement] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+	pool := swarmdblib.NewPool(swarmdblib.PoolConfig{IP: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port, MaxOpen: 4})
+	defer pool.Close()
+
+	// concurrent increments of one counter, the first of which creates the row
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := pool.ProcessRequestCtx(context.Background(), sdbc.RequestOption{RequestType: wire.RT_INCREMENT, Owner: owner, Database: database, Table: tableName, Key: "counter@wolk.com", Rows: []sdbc.Row{{"age": 1}}})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("[tcpserver_test:TestClientIncrement] Increment %s", err)
+		}
+	}
+
+	dbc, err := swarmdblib.OpenConnection("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientIncrement] OpenConnection %s", err)
+	}
+	defer dbc.Close()
+	resp, err := dbc.Increment(owner, database, tableName, "counter@wolk.com", sdbc.Row{"age": -3})
+	if err != nil || len(resp.Data) != 1 {
+		t.Fatalf("[tcpserver_test:TestClientIncrement] Increment %v %s", resp, err)
+	}
+	if age := fmt.Sprintf("%v", resp.Data[0]["age"]); age != "7" {
+		t.Fatalf("[tcpserver_test:TestClientIncrement] age %s after 10 increments and one -3", age)
+	}
+	if _, err = dbc.Increment(owner, database, tableName, "counter@wolk.com", sdbc.Row{"name": 1}); !errors.Is(err, swarmdblib.ErrBadRequest) {
+		t.Fatalf("[tcpserver_test:TestClientIncrement] Increment of a string column: %v", err)
+	}
+}
+
+func TestClientImportCSV(t *testing.T) {
+	owner, database, tableName := make_table(t, "import")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientImportCSV] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+	dbc, err := swarmdblib.OpenConnection("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientImportCSV] OpenConnection %s", err)
+	}
+	defer dbc.Close()
+
+	csv := "e-mail,name,years,notes\n" +
+		"zoe@wolk.com,Zoe,31,skipped\n" +
+		"amy@wolk.com,Amy,old,\n" +
+		",Nobody,40,\n" +
+		"bob@wolk.com,\"Bob, Jr.\",22,\n" +
+		"cat@wolk.com,Cat,,\n"
+	mapping := map[string]string{"e-mail": "email", "years": "age", "notes": ""}
+	resp, err := dbc.ImportCSV(owner, database, tableName, strings.NewReader(csv), mapping)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientImportCSV] ImportCSV %s", err)
+	}
+	if resp.AffectedRowCount != 3 || resp.MatchedRowCount != 5 || len(resp.Data) != 2 {
+		t.Fatalf("[tcpserver_test:TestClientImportCSV] imported %d of %d, rejected %v", resp.AffectedRowCount, resp.MatchedRowCount, resp.Data)
+	}
+	for i, record := range []string{"2", "3"} {
+		if fmt.Sprintf("%v", resp.Data[i]["record"]) != record {
+			t.Fatalf("[tcpserver_test:TestClientImportCSV] rejected %v, expected records 2 and 3", resp.Data)
+		}
+	}
+
+	resp, err = dbc.Get(owner, database, tableName, "bob@wolk.com")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientImportCSV] Get %s", err)
+	}
+	if resp.Data[0]["name"] != "Bob, Jr." || fmt.Sprintf("%v", resp.Data[0]["age"]) != "22" {
+		t.Fatalf("[tcpserver_test:TestClientImportCSV] imported row %v", resp.Data[0])
+	}
+	resp, err = dbc.Get(owner, database, tableName, "cat@wolk.com")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientImportCSV] Get %s", err)
+	}
+	if _, ok := resp.Data[0]["age"]; ok {
+		t.Fatalf("[tcpserver_test:TestClientImportCSV] empty cell imported as %v", resp.Data[0]["age"])
+	}
+
+	// a header naming no column fails the whole import
+	if _, err = dbc.ImportCSV(owner, database, tableName, strings.NewReader("email,height\ndan@wolk.com,180\n"), nil); !errors.Is(err, swarmdblib.ErrNoSuchColumn) {
+		t.Fatalf("[tcpserver_test:TestClientImportCSV] ImportCSV with an unknown column: %v", err)
+	}
+	if _, err = dbc.Get(owner, database, tableName, "dan@wolk.com"); !errors.Is(err, swarmdblib.ErrNotFound) {
+		t.Fatalf("[tcpserver_test:TestClientImportCSV] row of a failed import: %v", err)
+	}
+}
+
+func TestExportTable(t *testing.T) {
+	owner, database, tableName := make_table(t, "export")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestExportTable] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+	dbc, err := swarmdblib.OpenConnection("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestExportTable] OpenConnection %s", err)
+	}
+	defer dbc.Close()
+	rows := []sdbc.Row{{"email": "zoe@wolk.com", "name": "Zoe", "age": 31}, {"email": "bob@wolk.com", "name": "Bob, Jr.", "age": 22}, {"email": "amy@wolk.com", "name": "Amy"}}
+	if _, err = dbc.Put(owner, database, tableName, rows); err != nil {
+		t.Fatalf("[tcpserver_test:TestExportTable] Put %s", err)
+	}
+
+	expected := "email,age,name\namy@wolk.com,,Amy\nbob@wolk.com,22,\"Bob, Jr.\"\nzoe@wolk.com,31,Zoe\n"
+	var local, remote bytes.Buffer
+	n, err := swarmdb.ExportTable(u, owner, database, tableName, sdb.EXPORT_CSV, &local)
+	if err != nil || n != 3 {
+		t.Fatalf("[tcpserver_test:TestExportTable] ExportTable %d rows %v", n, err)
+	}
+	if local.String() != expected {
+		t.Fatalf("[tcpserver_test:TestExportTable] ExportTable csv:\n%s", local.String())
+	}
+	if n, err = dbc.ExportTable(context.Background(), owner, database, tableName, "csv", &remote); err != nil || n != 3 {
+		t.Fatalf("[tcpserver_test:TestExportTable] client ExportTable %d rows %v", n, err)
+	}
+	if remote.String() != expected {
+		t.Fatalf("[tcpserver_test:TestExportTable] client ExportTable csv:\n%s", remote.String())
+	}
+
+	local.Reset()
+	if _, err = swarmdb.ExportTable(u, owner, database, tableName, sdb.EXPORT_JSONL, &local); err != nil {
+		t.Fatalf("[tcpserver_test:TestExportTable] ExportTable jsonl %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(local.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("[tcpserver_test:TestExportTable] %d JSON lines", len(lines))
+	}
+	var first sdbc.Row
+	if err = json.Unmarshal([]byte(lines[0]), &first); err != nil || first["email"] != "amy@wolk.com" {
+		t.Fatalf("[tcpserver_test:TestExportTable] first line %s %v", lines[0], err)
+	}
+	if _, err = swarmdb.ExportTable(u, owner, database, tableName, "xml", &local); err == nil {
+		t.Fatalf("[tcpserver_test:TestExportTable] export as xml succeeded")
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	owner, database, tableName := make_owner_table(t, strings.ToLower(u.Address), "backup")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestBackupRestore] GetTable %s", err)
+	}
+	for i, email := range []string{"first@wolk.com", "second@wolk.com", "third@wolk.com"} {
+		if err = tbl.Put(u, map[string]interface{}{"email": email, "name": "Before", "age": i + 1}); err != nil {
+			t.Fatalf("[tcpserver_test:TestBackupRestore] Put %s", err)
+		}
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "second@wolk.com", "name": "After", "age": 2}); err != nil {
+		t.Fatalf("[tcpserver_test:TestBackupRestore] Put %s", err)
+	}
+
+	serve := func(db *sdb.SwarmDB) *swarmdblib.SWARMDBConnection {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("[tcpserver_test:TestBackupRestore] Listen %s", err)
+		}
+		adminConfig := *config
+		adminConfig.Admins = []string{u.Address}
+		srv := sdb.NewTCPServer(db, &adminConfig)
+		go srv.Serve(listener)
+		dbc, err := swarmdblib.OpenConnection("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+		if err != nil {
+			t.Fatalf("[tcpserver_test:TestBackupRestore] OpenConnection %s", err)
+		}
+		if _, err = dbc.Authenticate(config.PrivateKey); err != nil {
+			t.Fatalf("[tcpserver_test:TestBackupRestore] Authenticate %s", err)
+		}
+		return dbc
+	}
+	dbc := serve(swarmdb)
+	defer dbc.Close()
+	var archive bytes.Buffer
+	chunks, err := dbc.Backup(owner, database, tableName, &archive)
+	if err != nil || chunks == 0 {
+		t.Fatalf("[tcpserver_test:TestBackupRestore] Backup %d chunks %v", chunks, err)
+	}
+
+	// restore on a node with an empty chunk store
+	dir, err := ioutil.TempDir("", "swarmdbrestore")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestBackupRestore] TempDir %s", err)
+	}
+	defer os.RemoveAll(dir)
+	otherConfig := *config
+	otherConfig.ChunkDBPath = dir
+	otherConfig.ENSDBPath = filepath.Join(dir, "ens.db")
+	other, err := sdb.NewSwarmDB(&otherConfig)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestBackupRestore] NewSwarmDB %s", err)
+	}
+	otherDbc := serve(other)
+	defer otherDbc.Close()
+	resp, err := otherDbc.Restore(bytes.NewReader(archive.Bytes()))
+	if err != nil || resp.AffectedRowCount != 1 || resp.Data[0]["table"] != tableName {
+		t.Fatalf("[tcpserver_test:TestBackupRestore] Restore %v %v", resp, err)
+	}
+	restored, err := other.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestBackupRestore] restored GetTable %s", err)
+	}
+	if out, ok, err := restored.Get(u, []byte("second@wolk.com")); err != nil || !ok || !strings.Contains(string(out), "After") {
+		t.Fatalf("[tcpserver_test:TestBackupRestore] restored Get %s %v %v", out, ok, err)
+	}
+	rows, err := restored.Scan(u, "email", 1)
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("[tcpserver_test:TestBackupRestore] restored Scan %d rows %v", len(rows), err)
+	}
+	tables, err := other.ListTables(u, owner, database)
+	if err != nil || len(tables) != 1 || tables[0]["table"] != tableName {
+		t.Fatalf("[tcpserver_test:TestBackupRestore] restored ListTables %v %v", tables, err)
+	}
+
+	// a truncated archive is refused
+	if _, err = otherDbc.Restore(bytes.NewReader(archive.Bytes()[:archive.Len()/2])); !errors.Is(err, swarmdblib.ErrBadRequest) {
+		t.Fatalf("[tcpserver_test:TestBackupRestore] Restore of a truncated archive: %v", err)
+	}
+}
+
+func TestTCPServerReadYourWrites(t *testing.T) {
+	owner, database, tableName := make_table(t, "ryw")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerReadYourWrites] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+	port := listener.Addr().(*net.TCPAddr).Port
+	dbc, err := swarmdblib.OpenConnection("127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerReadYourWrites] OpenConnection %s", err)
+	}
+	defer dbc.Close()
+	other, err := swarmdblib.OpenConnection("127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerReadYourWrites] OpenConnection %s", err)
+	}
+	defer other.Close()
+	scan := sdbc.RequestOption{RequestType: sdbc.RT_SCAN, Owner: owner, Database: database, Table: tableName}
+	before, err := dbc.ProcessRequestResponseCommand(scan)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerReadYourWrites] Scan %s", err)
+	}
+
+	tx, err := dbc.Begin(owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerReadYourWrites] Begin %s", err)
+	}
+	if _, err = tx.Put([]sdbc.Row{{"email": "ryw@wolk.com", "name": "Ryan", "age": 1}}); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerReadYourWrites] Put %s", err)
+	}
+	if _, err = tx.Get("ryw@wolk.com"); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerReadYourWrites] Get of the buffered row %s", err)
+	}
+	// the writing session scans its buffered row, other sessions scan the published table
+	if resp, err := dbc.ProcessRequestResponseCommand(scan); err != nil || len(resp.Data) != len(before.Data)+1 {
+		t.Fatalf("[tcpserver_test:TestTCPServerReadYourWrites] Scan in the transaction %d rows %v", len(resp.Data), err)
+	}
+	if resp, err := other.ProcessRequestResponseCommand(scan); err != nil || len(resp.Data) != len(before.Data) {
+		t.Fatalf("[tcpserver_test:TestTCPServerReadYourWrites] Scan outside the transaction %d rows %v", len(resp.Data), err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerReadYourWrites] Commit %s", err)
+	}
+	if resp, err := other.ProcessRequestResponseCommand(scan); err != nil || len(resp.Data) != len(before.Data)+1 {
+		t.Fatalf("[tcpserver_test:TestTCPServerReadYourWrites] Scan after Commit %d rows %v", len(resp.Data), err)
+	}
+}
+
+func TestFanoutQuery(t *testing.T) {
+	owner, database, tableName := make_table(t, "fanout")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestFanoutQuery] GetTable %s", err)
+	}
+	for i, email := range []string{"c@wolk.com", "a@wolk.com", "b@wolk.com"} {
+		if err = tbl.Put(u, map[string]interface{}{"email": email, "name": "Fanout", "age": i + 1}); err != nil {
+			t.Fatalf("[tcpserver_test:TestFanoutQuery] Put %s", err)
+		}
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestFanoutQuery] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	// the peer serves the same table as the local node, like a replica: every row comes back twice
+	openConfig.Peers = []sdb.PeerConfig{{IP: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port}}
+	openConfig.PrivateKey = ""
+	fanout := sdb.NewFanout(swarmdb, &openConfig)
+	defer fanout.Close()
+	q := &sdb.FanoutQuery{Owner: owner, Database: database, SQL: fmt.Sprintf("select email, age from %s where age >= 1", tableName)}
+	resp, err := fanout.Query(context.Background(), u, q)
+	if err != nil || len(resp.Data) != 6 {
+		t.Fatalf("[tcpserver_test:TestFanoutQuery] Query %d rows %v", len(resp.Data), err)
+	}
+
+	q.KeyColumn, q.OrderBy, q.Descending, q.Limit = "email", "age", true, 2
+	if resp, err = fanout.Query(context.Background(), u, q); err != nil || len(resp.Data) != 2 {
+		t.Fatalf("[tcpserver_test:TestFanoutQuery] merged Query %d rows %v", len(resp.Data), err)
+	}
+	if resp.Data[0]["email"] != "b@wolk.com" || resp.Data[1]["email"] != "a@wolk.com" {
+		t.Fatalf("[tcpserver_test:TestFanoutQuery] merged rows out of order %v", resp.Data)
+	}
+
+	q.Limit = 0
+	q.Aggregates = []sdb.Aggregate{{Func: "count", Column: "*", As: "n"}, {Func: "sum", Column: "age"}, {Func: "max", Column: "email"}}
+	if resp, err = fanout.Query(context.Background(), u, q); err != nil || len(resp.Data) != 1 {
+		t.Fatalf("[tcpserver_test:TestFanoutQuery] aggregate Query %v %v", resp.Data, err)
+	}
+	if agg := resp.Data[0]; fmt.Sprint(agg["n"]) != "3" || fmt.Sprint(agg["sum(age)"]) != "6" || agg["max(email)"] != "c@wolk.com" {
+		t.Fatalf("[tcpserver_test:TestFanoutQuery] aggregates %v", agg)
+	}
+	q.Aggregates = []sdb.Aggregate{{Func: "median", Column: "age"}}
+	if _, err = fanout.Query(context.Background(), u, q); err == nil {
+		t.Fatalf("[tcpserver_test:TestFanoutQuery] unknown aggregate accepted")
+	}
+}
+
+func TestAntiEntropy(t *testing.T) {
+	owner, database, tableName := make_table(t, "antientropy")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestAntiEntropy] GetTable %s", err)
+	}
+	for i, email := range []string{"first@wolk.com", "second@wolk.com", "third@wolk.com"} {
+		if err = tbl.Put(u, map[string]interface{}{"email": email, "name": "Before", "age": i + 1}); err != nil {
+			t.Fatalf("[tcpserver_test:TestAntiEntropy] Put %s", err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestAntiEntropy] Listen %s", err)
+	}
+	adminConfig := *config
+	adminConfig.Admins = []string{u.Address}
+	srv := sdb.NewTCPServer(swarmdb, &adminConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	dir, err := ioutil.TempDir("", "swarmdbsync")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestAntiEntropy] TempDir %s", err)
+	}
+	defer os.RemoveAll(dir)
+	replicaConfig := *config
+	replicaConfig.ChunkDBPath = dir
+	replicaConfig.ENSDBPath = filepath.Join(dir, "ens.db")
+	replicaConfig.Replica = 1
+	replicaConfig.Peers = []sdb.PeerConfig{{IP: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port}}
+	replica, err := sdb.NewSwarmDB(&replicaConfig)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestAntiEntropy] NewSwarmDB %s", err)
+	}
+	syncer := sdb.NewSyncer(replica, &replicaConfig)
+	defer syncer.Close()
+	ctx := context.Background()
+
+	// a replica that never saw the table fetches it whole
+	fetched, err := syncer.SyncTable(ctx, u, owner, database, tableName)
+	if err != nil || fetched == 0 {
+		t.Fatalf("[tcpserver_test:TestAntiEntropy] SyncTable fetched %d %v", fetched, err)
+	}
+	followed, err := replica.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestAntiEntropy] replica GetTable %s", err)
+	}
+	if out, ok, err := followed.Get(u, []byte("second@wolk.com")); err != nil || !ok || !strings.Contains(string(out), "Before") {
+		t.Fatalf("[tcpserver_test:TestAntiEntropy] replica Get %s %v %v", out, ok, err)
+	}
+	if fetched, err = syncer.SyncTable(ctx, u, owner, database, tableName); err != nil || fetched != 0 {
+		t.Fatalf("[tcpserver_test:TestAntiEntropy] SyncTable without changes fetched %d %v", fetched, err)
+	}
+
+	// an update in place and a new row are caught up without fetching the rest again
+	if err = tbl.Put(u, map[string]interface{}{"email": "second@wolk.com", "name": "After", "age": 2}); err != nil {
+		t.Fatalf("[tcpserver_test:TestAntiEntropy] Put %s", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "fourth@wolk.com", "name": "After", "age": 4}); err != nil {
+		t.Fatalf("[tcpserver_test:TestAntiEntropy] Put %s", err)
+	}
+	if fetched, err = syncer.SyncTable(ctx, u, owner, database, tableName); err != nil || fetched == 0 {
+		t.Fatalf("[tcpserver_test:TestAntiEntropy] SyncTable after writes fetched %d %v", fetched, err)
+	}
+	if _, err = replica.Follow(u); err != nil {
+		t.Fatalf("[tcpserver_test:TestAntiEntropy] Follow %s", err)
+	}
+	if followed, err = replica.GetTable(u, owner, database, tableName); err != nil {
+		t.Fatalf("[tcpserver_test:TestAntiEntropy] replica GetTable %s", err)
+	}
+	for _, email := range []string{"second@wolk.com", "fourth@wolk.com"} {
+		if out, ok, err := followed.Get(u, []byte(email)); err != nil || !ok || !strings.Contains(string(out), "After") {
+			t.Fatalf("[tcpserver_test:TestAntiEntropy] replica Get %s after sync %s %v %v", email, out, ok, err)
+		}
+	}
+	versions, err := followed.RowVersions(u, []byte("second@wolk.com"))
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("[tcpserver_test:TestAntiEntropy] replica RowVersions %d %v", len(versions), err)
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	// writes are forwarded on sessions authenticated with the node key, so the elected owner is its address
+	owner, database, tableName := make_owner_table(t, strings.ToLower(u.Address), "leader")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestLeaderElection] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	// node a leads; node b shares its ENS and forwards writes to it
+	configA := *config
+	configA.Election = sdb.ElectionConfig{Owners: []string{owner}, NodeID: "a", Advertise: listener.Addr().String(), LeaseSeconds: 1}
+	electorA := sdb.NewElector(swarmdb, &configA)
+	stopA := electorA.Start(u)
+	if !electorA.IsLeader(owner) {
+		t.Fatalf("[tcpserver_test:TestLeaderElection] first node did not take the lease")
+	}
+
+	dir, err := ioutil.TempDir("", "swarmdbleader")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestLeaderElection] TempDir %s", err)
+	}
+	defer os.RemoveAll(dir)
+	configB := *config
+	configB.ChunkDBPath = dir
+	configB.ENSDBPath = config.GetENSDBPath()
+	configB.Election = sdb.ElectionConfig{Owners: []string{owner}, NodeID: "b", LeaseSeconds: 1, Proxy: 1}
+	nodeB, err := sdb.NewSwarmDB(&configB)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestLeaderElection] NewSwarmDB %s", err)
+	}
+	electorB := sdb.NewElector(nodeB, &configB)
+	stopB := electorB.Start(u)
+	defer stopB()
+	if electorB.IsLeader(owner) {
+		t.Fatalf("[tcpserver_test:TestLeaderElection] both nodes lead")
+	}
+	if lease, ok := electorB.Leader(owner); !ok || lease.Leader != "a" || lease.Address != listener.Addr().String() {
+		t.Fatalf("[tcpserver_test:TestLeaderElection] Leader %+v %v", lease, ok)
+	}
+	row := sdbc.Row{"email": "forwarded@wolk.com", "name": "Forwarded", "age": 1}
+	if _, err = nodeB.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{row}}); err != nil {
+		t.Fatalf("[tcpserver_test:TestLeaderElection] forwarded Put %s", err)
+	}
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestLeaderElection] GetTable %s", err)
+	}
+	if _, ok, err := tbl.Get(u, []byte("forwarded@wolk.com")); err != nil || !ok {
+		t.Fatalf("[tcpserver_test:TestLeaderElection] leader Get %v %v", ok, err)
+	}
+
+	// once a resigns b takes over, and a without proxying refuses writes
+	stopA()
+	for i := 0; !electorB.IsLeader(owner); i++ {
+		if i == 300 {
+			t.Fatalf("[tcpserver_test:TestLeaderElection] second node never took over")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if lease, _ := electorB.Leader(owner); lease.Term != 2 {
+		t.Fatalf("[tcpserver_test:TestLeaderElection] takeover term %d", lease.Term)
+	}
+	stopA = sdb.NewElector(swarmdb, &configA).Start(u)
+	defer stopA()
+	_, err = swarmdb.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{row}})
+	if sErr, ok := err.(*sdbc.SWARMDBError); !ok || sErr.ErrorCode != 504 {
+		t.Fatalf("[tcpserver_test:TestLeaderElection] Put on a follower returned %v", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "direct@wolk.com", "name": "Direct", "age": 2}); err == nil {
+		t.Fatalf("[tcpserver_test:TestLeaderElection] Table Put on a follower succeeded")
+	}
+}
+
+func TestGossip(t *testing.T) {
+	// requests are forwarded on sessions authenticated with the node key, so the table is of its address
+	owner, database, tableName := make_owner_table(t, strings.ToLower(u.Address), "gossip")
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestGossip] GetTable %s", err)
+	}
+	if err = tbl.Put(u, map[string]interface{}{"email": "gossip@wolk.com", "name": "Gossip", "age": 1}); err != nil {
+		t.Fatalf("[tcpserver_test:TestGossip] Put %s", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestGossip] Listen %s", err)
+	}
+	adminConfig := *config
+	adminConfig.Admins = []string{u.Address}
+	adminConfig.Gossip = sdb.GossipConfig{Advertise: listener.Addr().String()}
+	srv := sdb.NewTCPServer(swarmdb, &adminConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+	stopA := sdb.NewGossip(swarmdb, &adminConfig).Start(time.Hour)
+	defer stopA()
+
+	// node b starts from a as its seed and has none of its tables
+	dir, err := ioutil.TempDir("", "swarmdbgossip")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestGossip] TempDir %s", err)
+	}
+	defer os.RemoveAll(dir)
+	configB := *config
+	configB.ChunkDBPath = dir
+	configB.ENSDBPath = filepath.Join(dir, "ens.db")
+	configB.Gossip = sdb.GossipConfig{Seeds: []sdb.PeerConfig{{IP: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port}}, Advertise: "127.0.0.1:1", Route: 1}
+	nodeB, err := sdb.NewSwarmDB(&configB)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestGossip] NewSwarmDB %s", err)
+	}
+	gossipB := sdb.NewGossip(nodeB, &configB)
+	if err = gossipB.Round(context.Background()); err != nil {
+		t.Fatalf("[tcpserver_test:TestGossip] Round %s", err)
+	}
+	if located := gossipB.Locate(owner, database, tableName); len(located) != 1 || located[0] != listener.Addr().String() {
+		t.Fatalf("[tcpserver_test:TestGossip] Locate %v", located)
+	}
+	resp, err := swarmdb.Admin(u, &adminConfig, sdb.ADMIN_GOSSIP, &sdbc.RequestOption{})
+	if err != nil || len(resp.Data) != 2 || resp.Data[1]["address"] != "127.0.0.1:1" {
+		t.Fatalf("[tcpserver_test:TestGossip] seed view %v %v", resp.Data, err)
+	}
+
+	// b forwards a request for the table to a
+	resp, err = nodeB.HandleRequest(u, &sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: "gossip@wolk.com"})
+	if err != nil || len(resp.Data) != 1 || resp.Data[0]["name"] != "Gossip" {
+		t.Fatalf("[tcpserver_test:TestGossip] routed Get %v %v", resp.Data, err)
+	}
+}
+
+func TestClientBench(t *testing.T) {
+	owner, database, _ := make_table(t, "bench")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientBench] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+	open := func() (*swarmdblib.SWARMDBConnection, error) {
+		return swarmdblib.OpenConnection("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	}
+
+	for i, workload := range []string{swarmdblib.BENCH_INSERT, swarmdblib.BENCH_READ, swarmdblib.BENCH_SCAN, swarmdblib.BENCH_MIXED} {
+		benchConfig := swarmdblib.BenchConfig{Workload: workload, Owner: owner, Database: database, Table: fmt.Sprintf("bench%d", i), Rows: 50, Ops: 100, Concurrency: 3, ValueSize: 10, ScanLimit: 5}
+		result, err := swarmdblib.RunBench(context.Background(), open, benchConfig)
+		if err != nil {
+			t.Fatalf("[tcpserver_test:TestClientBench] RunBench %s %s", workload, err)
+		}
+		ops := 0
+		for op, l := range result.Latency {
+			if l.Errors > 0 {
+				t.Fatalf("[tcpserver_test:TestClientBench] %s: %d %s errors", workload, l.Errors, op)
+			}
+			if l.P50 > l.P99 || l.P99 > l.Max {
+				t.Fatalf("[tcpserver_test:TestClientBench] %s: %s percentiles out of order %v", workload, op, l)
+			}
+			ops += l.Ops
+		}
+		if ops != 100 || (workload == swarmdblib.BENCH_INSERT && len(result.Latency) != 1) {
+			t.Fatalf("[tcpserver_test:TestClientBench] %s: ran %v", workload, result)
+		}
+	}
+	if _, err := swarmdblib.RunBench(context.Background(), open, swarmdblib.BenchConfig{Workload: "writeheavy", Owner: owner, Database: database}); err == nil {
+		t.Fatalf("[tcpserver_test:TestClientBench] RunBench accepted an unknown workload")
+	}
+}
+
+func TestTCPServerCapability(t *testing.T) {
+	owner, database, tableName := make_owner_table(t, strings.ToLower(u.Address), "cap")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerCapability] Listen %s", err)
+	}
+	authConfig := *config
+	authConfig.Authentication = 1
+	srv := sdb.NewTCPServer(swarmdb, &authConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+	row := sdbc.Row{"email": "cap1@wolk.com", "name": "Cap", "age": 1}
+	tbl, err := swarmdb.GetTable(u, owner, database, tableName)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerCapability] GetTable %s", err)
+	}
+	if err = tbl.Put(u, row); err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerCapability] Put %s", err)
+	}
+
+	// a read-only token on keys starting with "cap", used on a session that never signs the challenge
+	token, err := swarmdblib.MintCapability(config.PrivateKey, wire.Capability{Database: database, Table: tableName, KeyPrefix: "cap", Permission: "read"})
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerCapability] MintCapability %s", err)
+	}
+	dbc, err := swarmdblib.OpenConnection("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerCapability] OpenConnection %s", err)
+	}
+	defer dbc.Close()
+	dbc.Capability = token
+	resp, err := dbc.Get(owner, database, tableName, "cap1@wolk.com")
+	if err != nil || len(resp.Data) != 1 || resp.Data[0]["name"] != "Cap" {
+		t.Fatalf("[tcpserver_test:TestTCPServerCapability] GET with capability %v %v", resp.Data, err)
+	}
+	if _, err = dbc.Get(owner, database, tableName, "other@wolk.com"); !errors.Is(err, swarmdblib.ErrPermissionDenied) {
+		t.Fatalf("[tcpserver_test:TestTCPServerCapability] GET outside the key prefix returned %v", err)
+	}
+	if _, err = dbc.Put(owner, database, tableName, []sdbc.Row{{"email": "cap2@wolk.com", "name": "Cap", "age": 2}}); !errors.Is(err, swarmdblib.ErrPermissionDenied) {
+		t.Fatalf("[tcpserver_test:TestTCPServerCapability] PUT with a read-only capability returned %v", err)
+	}
+
+	// expired and tampered tokens are refused
+	expired, err := swarmdblib.MintCapability(config.PrivateKey, wire.Capability{Database: database, Permission: "read", ExpiresMs: 1})
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerCapability] MintCapability %s", err)
+	}
+	c, _ := wire.DecodeCapability(token)
+	c.Permission = "read,write"
+	for _, bad := range []string{expired, wire.EncodeCapability(c)} {
+		dbc.Capability = bad
+		if _, err = dbc.Get(owner, database, tableName, "cap1@wolk.com"); err == nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerCapability] GET accepted capability %s", bad)
+		}
+	}
+}
+
+func TestTCPServerPipeline(t *testing.T) {
+	owner, database, tableName := make_table(t, "pipeline")
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	defer srv.Shutdown(context.Background())
+	dbc, err := swarmdblib.OpenEmbeddedConnection(srv)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerPipeline] OpenEmbeddedConnection %s", err)
+	}
+	defer dbc.Close()
+
+	// more requests than the server reads ahead, each seeing the writes before it
+	n := 2*sdb.PIPELINE_DEPTH + 3
+	var reqs []sdbc.RequestOption
+	for i := 0; i < n; i++ {
+		email := fmt.Sprintf("pipe%02d@wolk.com", i)
+		reqs = append(reqs,
+			sdbc.RequestOption{RequestType: sdbc.RT_PUT, Owner: owner, Database: database, Table: tableName, Rows: []sdbc.Row{{"email": email, "name": "Pipe", "age": i}}},
+			sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: tableName, Key: email})
+	}
+	reqs = append(reqs, sdbc.RequestOption{RequestType: sdbc.RT_GET, Owner: owner, Database: database, Table: "nosuchtable", Key: "pipe00@wolk.com"})
+	resps, errs, err := dbc.Pipeline(reqs)
+	if err != nil || len(resps) != len(reqs) {
+		t.Fatalf("[tcpserver_test:TestTCPServerPipeline] Pipeline %d responses %v", len(resps), err)
+	}
+	for i := 0; i < n; i++ {
+		if errs[2*i] != nil || errs[2*i+1] != nil {
+			t.Fatalf("[tcpserver_test:TestTCPServerPipeline] request %d %v %v", i, errs[2*i], errs[2*i+1])
+		}
+		if get := resps[2*i+1]; len(get.Data) != 1 || get.Data[0]["email"] != fmt.Sprintf("pipe%02d@wolk.com", i) {
+			t.Fatalf("[tcpserver_test:TestTCPServerPipeline] Get %d answered %v", i, get.Data)
+		}
+	}
+	if errs[len(reqs)-1] == nil {
+		t.Fatalf("[tcpserver_test:TestTCPServerPipeline] Get of a missing table succeeded")
+	}
+
+	// the connection carries on with ordinary requests
+	if resp, err := dbc.Get(owner, database, tableName, "pipe00@wolk.com"); err != nil || len(resp.Data) != 1 {
+		t.Fatalf("[tcpserver_test:TestTCPServerPipeline] Get after pipeline %v %v", resp.Data, err)
+	}
+}
+
+func TestClientBlob(t *testing.T) {
+	owner, database, tableName := make_table(t, "clientblob")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientBlob] Listen %s", err)
+	}
+	openConfig := *config
+	openConfig.Authentication = 0
+	srv := sdb.NewTCPServer(swarmdb, &openConfig)
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	dbc, err := swarmdblib.OpenConnection("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		t.Fatalf("[tcpserver_test:TestClientBlob] OpenConnection %s", err)
+	}
+	defer dbc.Close()
+	data := make([]byte, wire.BLOB_PART_SIZE+5000)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+	// written in pieces that do not line up with the parts
+	w := dbc.NewBlobWriter(owner, database, tableName, "blob@wolk.com", "name")
+	for start := 0; start < len(data); start += 100000 {
+		end := start + 100000
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err = w.Write(data[start:end]); err != nil {
+			t.Fatalf("[tcpserver_test:TestClientBlob] Write %s", err)
+		}
+	}
+	if err = w.Close(); err != nil || len(w.Manifest()) != 64 {
+		t.Fatalf("[tcpserver_test:TestClientBlob] Close %q %v", w.Manifest(), err)
+	}
+
+	r, ok, err := dbc.GetBlob(owner, database, tableName, "blob@wolk.com", "name")
+	if err != nil || !ok || r.Size() != int64(len(data)) || r.Manifest() != w.Manifest() {
+		t.Fatalf("[tcpserver_test:TestClientBlob] GetBlob %v %v", ok, err)
+	}
+	read, err := ioutil.ReadAll(r)
+	if err != nil || !bytes.Equal(read, data) {
+		t.Fatalf("[tcpserver_test:TestClientBlob] ReadAll %d bytes %v", len(read), err)
+	}
+	if _, ok, err = dbc.GetBlob(owner, database, tableName, "noblob@wolk.com", "name"); err != nil || ok {
+		t.Fatalf("[tcpserver_test:TestClientBlob] GetBlob of a missing row %v %v", ok, err)
+	}
+}
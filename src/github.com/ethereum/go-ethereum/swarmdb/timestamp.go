@@ -0,0 +1,177 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"encoding/json"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"time"
+)
+
+// A table may have the server set a created_at and an updated_at column: every Put, and so every UPDATE and INSERT,
+// sets the updated_at column to the time of the write, and the created_at column to the time of the first write of
+// the row, overriding the values written.  Both encodings sort as the times do, in the cells and in the keys of
+// their indexes: an integer column holds unix milliseconds, a string column the UTC time in TIMESTAMP_FORMAT.  The
+// attribute is kept at COLUMN_EXPIRES_OFFSET of the column entry in the table descriptor, which holds 1 for the
+// expiry column (see expiry.go) or COLUMN_CREATED_AT or COLUMN_UPDATED_AT.
+const (
+	COLUMN_CREATED_AT = 2
+	COLUMN_UPDATED_AT = 3
+	TIMESTAMP_FORMAT  = "2006-01-02T15:04:05.000Z" // fixed width, so that the strings of UTC times sort as the times
+)
+
+// TimestampColumns returns the names of the created_at and updated_at columns of the table, "" for none
+func (t *Table) TimestampColumns() (created string, updated string) {
+	for name, c := range t.columns {
+		switch c.timestamp {
+		case COLUMN_CREATED_AT:
+			created = name
+		case COLUMN_UPDATED_AT:
+			updated = name
+		}
+	}
+	return created, updated
+}
+
+// SetTimestampColumns has the server set the columns created and updated, "" for none, in the rows written from
+// now on.  They must be distinct unencrypted integer or string columns other than the primary key and the expiry
+// column.
+func (t *Table) SetTimestampColumns(u *SWARMDBUser, created string, updated string) (err error) {
+	if err = t.checkGrant(u); err != nil {
+		return err
+	}
+	if len(created) > 0 && created == updated {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[timestamp:SetTimestampColumns] column %s", created), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: column [%s] cannot be both created_at and updated_at", created)}
+	}
+	for _, name := range []string{created, updated} {
+		if len(name) == 0 {
+			continue
+		}
+		c, ok := t.columns[name]
+		if !ok {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[timestamp:SetTimestampColumns] unknown column %s", name), ErrorCode: 404, ErrorMessage: fmt.Sprintf("Column Does Not Exist in table definition: [%s]", name)}
+		}
+		if c.primary > 0 || c.encrypted || c.expires || (c.columnType != sdbc.CT_INTEGER && c.columnType != sdbc.CT_STRING) {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[timestamp:SetTimestampColumns] column %s", name), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: the timestamp column [%s] must be an unencrypted integer or string column other than the primary key and the expiry column", name)}
+		}
+	}
+	if t.IsSharded() {
+		if err = t.eachShard(u, func(i int, shard *Table) error { return shard.SetTimestampColumns(u, created, updated) }); err != nil {
+			return err
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name, c := range t.columns {
+		switch name {
+		case created:
+			c.timestamp = COLUMN_CREATED_AT
+		case updated:
+			c.timestamp = COLUMN_UPDATED_AT
+		default:
+			c.timestamp = 0
+		}
+	}
+	return t.updateTableInfo(u)
+}
+
+// timestampCell returns the cell of a timestamp column of type columnType for the time ms
+func timestampCell(columnType sdbc.ColumnType, ms int64) interface{} {
+	if columnType == sdbc.CT_STRING {
+		return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format(TIMESTAMP_FORMAT)
+	}
+	return int(ms)
+}
+
+// stampRow returns row with its timestamp columns set for a write at now; row itself is left as is.  The created_at
+// column keeps the value of the row stored, if any.
+func (t *Table) stampRow(u *SWARMDBUser, row map[string]interface{}) (out map[string]interface{}, err error) {
+	created, updated := t.TimestampColumns()
+	if len(created) == 0 && len(updated) == 0 {
+		return row, nil
+	}
+	now := nowMs()
+	out = make(map[string]interface{}, len(row)+2)
+	for k, v := range row {
+		out[k] = v
+	}
+	if len(updated) > 0 {
+		out[updated] = timestampCell(t.columns[updated].columnType, now)
+	}
+	if len(created) == 0 {
+		return out, nil
+	}
+	out[created] = timestampCell(t.columns[created].columnType, now)
+	k, err := convertJSONValueToKey(t.columns[t.primaryColumnName].columnType, row[t.primaryColumnName])
+	if err != nil {
+		// Put reports the bad key
+		return out, nil
+	}
+	stored, ok, err := t.readValue(u, k)
+	if err != nil {
+		return row, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[timestamp:stampRow] readValue %s", err.Error()))
+	}
+	if !ok {
+		return out, nil
+	}
+	var before map[string]interface{}
+	if err = decodeJSONNumbers(t.rowJSON(stored), &before); err != nil {
+		return out, nil
+	}
+	switch v := before[created].(type) {
+	case json.Number:
+		if ms, err := v.Int64(); err == nil {
+			out[created] = timestampCell(t.columns[created].columnType, ms)
+		}
+	case string:
+		if ts, err := time.Parse(TIMESTAMP_FORMAT, v); err == nil {
+			out[created] = timestampCell(t.columns[created].columnType, ts.UnixNano()/int64(time.Millisecond))
+		}
+	}
+	return out, nil
+}
+
+// setTimestampColumns runs an RT_TIMESTAMP_COLUMNS request: d.Rows[0] {"created", "updated"} names the columns the
+// server sets, "" or missing for none; without Rows they are only read
+func (self *SwarmDB) setTimestampColumns(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	tbl, err := self.GetTable(u, d.Owner, d.Database, d.Table)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[timestamp:setTimestampColumns] GetTable %s", err.Error()))
+	}
+	if len(d.Rows) == 1 {
+		var names [2]string
+		for i, attribute := range []string{"created", "updated"} {
+			v := d.Rows[0][attribute]
+			name, ok := v.(string)
+			if !ok && v != nil {
+				return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[timestamp:setTimestampColumns] %s %v", attribute, v), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: %s must be a column name", attribute)}
+			}
+			names[i] = name
+		}
+		if err = tbl.SetTimestampColumns(u, names[0], names[1]); err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[timestamp:setTimestampColumns] SetTimestampColumns %s", err.Error()))
+		}
+		resp.AffectedRowCount = 1
+	}
+	created, updated := tbl.TimestampColumns()
+	row := sdbc.NewRow()
+	row["created"] = created
+	row["updated"] = updated
+	resp.Data = []sdbc.Row{row}
+	resp.MatchedRowCount = 1
+	return resp, nil
+}
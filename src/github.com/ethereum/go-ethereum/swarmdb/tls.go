@@ -0,0 +1,90 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"io/ioutil"
+	"net"
+)
+
+// TLSClientAuth values
+const (
+	TLS_CLIENTAUTH_NONE     = 0 // clients are not asked for a certificate
+	TLS_CLIENTAUTH_VERIFY   = 1 // a client certificate is verified against TLSClientCAFile if presented
+	TLS_CLIENTAUTH_REQUIRED = 2 // mutual TLS: every client must present a certificate signed by TLSClientCAFile
+)
+
+// ServerTLSConfig builds the tls.Config shared by all SWARMDB listeners; it returns nil when TLSCertFile is not set
+func (self *SWARMDBConfig) ServerTLSConfig() (tlsConfig *tls.Config, err error) {
+	if len(self.TLSCertFile) == 0 {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(self.TLSCertFile, self.TLSKeyFile)
+	if err != nil {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[tls:ServerTLSConfig] LoadX509KeyPair %s", err.Error()), ErrorCode: 486, ErrorMessage: "Unable to load TLS certificate"}
+	}
+	tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if self.TLSClientAuth == TLS_CLIENTAUTH_NONE {
+		return tlsConfig, nil
+	}
+	if len(self.TLSClientCAFile) == 0 {
+		return nil, &sdbc.SWARMDBError{Message: "[tls:ServerTLSConfig] tlsClientCAFile missing", ErrorCode: 486, ErrorMessage: "Unable to load TLS certificate: client verification requires tlsClientCAFile"}
+	}
+	pool, err := LoadCertPool(self.TLSClientCAFile)
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[tls:ServerTLSConfig] LoadCertPool %s", err.Error()))
+	}
+	tlsConfig.ClientCAs = pool
+	if self.TLSClientAuth == TLS_CLIENTAUTH_REQUIRED {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// Listen opens a TCP listener on addr, wrapped in TLS when the config has a certificate
+func (self *SWARMDBConfig) Listen(addr string) (listener net.Listener, err error) {
+	tlsConfig, err := self.ServerTLSConfig()
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[tls:Listen] ServerTLSConfig %s", err.Error()))
+	}
+	listener, err = net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		return tls.NewListener(listener, tlsConfig), nil
+	}
+	return listener, nil
+}
+
+// LoadCertPool reads PEM encoded CA certificates from filename
+func LoadCertPool(filename string) (pool *x509.CertPool, err error) {
+	pem, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[tls:LoadCertPool] ReadFile %s", err.Error()), ErrorCode: 486, ErrorMessage: "Unable to load TLS certificate"}
+	}
+	pool = x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[tls:LoadCertPool] no certificates in %s", filename), ErrorCode: 486, ErrorMessage: "Unable to load TLS certificate"}
+	}
+	return pool, nil
+}
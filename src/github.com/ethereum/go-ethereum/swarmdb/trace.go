@@ -0,0 +1,69 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	"time"
+)
+
+// Every request is tagged with a trace ID, either supplied by the client (swarmdbwire.Request.TraceID, the X-Trace-Id
+// HTTP header) or generated by SelectHandler.  The ID travels on the per-request copy of SWARMDBUser that is already
+// handed down through Table, Tree and DBChunkstore, and is logged as the "trace" context of each layer's log lines,
+// so `grep trace=<id>` follows one slow query across subsystems.
+const TRACE_ID_LENGTH = 8
+
+// NewTraceID returns a random hex trace ID
+func NewTraceID() string {
+	b := make([]byte, TRACE_ID_LENGTH)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%x", b)
+}
+
+// WithTrace returns a copy of u carrying traceID; u itself is shared between requests and is never modified
+func (u *SWARMDBUser) WithTrace(traceID string) *SWARMDBUser {
+	if u == nil {
+		return nil
+	}
+	traced := *u
+	traced.traceID = traceID
+	return &traced
+}
+
+// TraceID is the trace ID of the request u is acting for, or "" outside a request
+func (u *SWARMDBUser) TraceID() string {
+	if u == nil {
+		return ""
+	}
+	return u.traceID
+}
+
+// traceRequest logs the start of a request and returns a func logging its completion and duration
+func traceRequest(u *SWARMDBUser, requestType string, owner string, database string, table string) func(err error) {
+	start := time.Now()
+	log.Debug("[swarmdb:SelectHandler] request start", "trace", u.TraceID(), "type", requestType, "owner", owner, "database", database, "table", table)
+	return func(err error) {
+		if err != nil {
+			log.Debug("[swarmdb:SelectHandler] request failed", "trace", u.TraceID(), "type", requestType, "elapsed", time.Since(start), "err", err)
+			return
+		}
+		log.Debug("[swarmdb:SelectHandler] request done", "trace", u.TraceID(), "type", requestType, "elapsed", time.Since(start))
+	}
+}
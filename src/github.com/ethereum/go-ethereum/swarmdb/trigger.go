@@ -0,0 +1,227 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"encoding/json"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"sort"
+	"sync"
+)
+
+// Triggers let a table declare actions that run on its writes inside the server, e.g. appending to an audit table
+// (AuditAction) or keeping a summary table up to date (CountAction).  A trigger runs on the Put or Delete that
+// fires it, once the indexes of the table are updated and before they are flushed, so whatever it writes to a
+// buffered table is committed with the same flush, and an action that fails fails the write.  Actions run under
+// the lock of the table that fired them and must not write to it.
+//
+// The registry lives in memory: triggers are created by the code embedding the server, at startup, and are not
+// stored with the table.
+const (
+	TRIGGER_INSERT = "Insert" // a Put of a new row
+	TRIGGER_UPDATE = "Update" // a Put over an existing row
+	TRIGGER_DELETE = "Delete"
+)
+
+// TriggerEvent is the write a trigger fires on.  Rows are as stored: cells of encrypted columns stay sealed.
+type TriggerEvent struct {
+	Op       string // TRIGGER_INSERT, TRIGGER_UPDATE or TRIGGER_DELETE
+	Owner    string
+	Database string
+	Table    string
+	Key      interface{}
+	Before   sdbc.Row // nil on TRIGGER_INSERT
+	After    sdbc.Row // nil on TRIGGER_DELETE
+}
+
+type TriggerAction func(u *SWARMDBUser, ev *TriggerEvent) error
+
+// Trigger runs Action on the writes of Ops, all of them when Ops is empty
+type Trigger struct {
+	Name   string
+	Ops    []string
+	Action TriggerAction
+}
+
+func (tr *Trigger) firesOn(op string) bool {
+	if len(tr.Ops) == 0 {
+		return true
+	}
+	for _, o := range tr.Ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+type triggerRegistry struct {
+	mu      sync.RWMutex
+	byTable map[string][]*Trigger // by table key, in creation order
+}
+
+func (r *triggerRegistry) of(tableKey string) []*Trigger {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byTable[tableKey]
+}
+
+// CreateTrigger registers trigger on the table; its name must be new on the table
+func (self *SwarmDB) CreateTrigger(owner string, database string, tableName string, trigger Trigger) (err error) {
+	if len(trigger.Name) == 0 || trigger.Action == nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[trigger:CreateTrigger] trigger %+v", trigger), ErrorCode: 418, ErrorMessage: "Request Invalid: a trigger needs a name and an action"}
+	}
+	for _, op := range trigger.Ops {
+		if op != TRIGGER_INSERT && op != TRIGGER_UPDATE && op != TRIGGER_DELETE {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[trigger:CreateTrigger] op %s", op), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: triggers fire on %s, %s or %s", TRIGGER_INSERT, TRIGGER_UPDATE, TRIGGER_DELETE)}
+		}
+	}
+	tableKey := self.GetTableKey(owner, database, tableName)
+	self.triggers.mu.Lock()
+	defer self.triggers.mu.Unlock()
+	for _, tr := range self.triggers.byTable[tableKey] {
+		if tr.Name == trigger.Name {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[trigger:CreateTrigger] %s exists on %s", trigger.Name, tableKey), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: trigger [%s] exists", trigger.Name)}
+		}
+	}
+	if self.triggers.byTable == nil {
+		self.triggers.byTable = make(map[string][]*Trigger)
+	}
+	tr := trigger
+	// copied, so that the triggers of a table are only ever replaced, never changed, while writes read them
+	triggers := append([]*Trigger(nil), self.triggers.byTable[tableKey]...)
+	self.triggers.byTable[tableKey] = append(triggers, &tr)
+	return nil
+}
+
+// DropTrigger removes the named trigger of the table and reports whether there was one
+func (self *SwarmDB) DropTrigger(owner string, database string, tableName string, name string) (ok bool) {
+	tableKey := self.GetTableKey(owner, database, tableName)
+	self.triggers.mu.Lock()
+	defer self.triggers.mu.Unlock()
+	var kept []*Trigger
+	for _, tr := range self.triggers.byTable[tableKey] {
+		if tr.Name == name {
+			ok = true
+		} else {
+			kept = append(kept, tr)
+		}
+	}
+	if len(kept) == 0 {
+		delete(self.triggers.byTable, tableKey)
+	} else {
+		self.triggers.byTable[tableKey] = kept
+	}
+	return ok
+}
+
+// ListTriggers returns the names of the triggers of the table, sorted
+func (self *SwarmDB) ListTriggers(owner string, database string, tableName string) (names []string) {
+	for _, tr := range self.triggers.of(self.GetTableKey(owner, database, tableName)) {
+		names = append(names, tr.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hasTriggers reports whether writes to t fire any trigger
+func (t *Table) hasTriggers() bool {
+	return len(t.swarmdb.triggers.of(t.swarmdb.GetTableKey(t.Owner, t.Database, t.tableName))) > 0
+}
+
+// fireTriggers runs the triggers of t on the write of key; before is the row as stored before it, as JSON, or nil
+func (t *Table) fireTriggers(u *SWARMDBUser, key interface{}, before []byte, after sdbc.Row) (err error) {
+	triggers := t.swarmdb.triggers.of(t.swarmdb.GetTableKey(t.Owner, t.Database, t.tableName))
+	if len(triggers) == 0 {
+		return nil
+	}
+	ev := &TriggerEvent{Owner: t.Owner, Database: t.Database, Table: t.tableName, Key: key, After: after}
+	if before != nil {
+		if ev.Before, err = t.byteArrayToRow(before); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[trigger:fireTriggers] byteArrayToRow %s", err.Error()))
+		}
+	}
+	switch {
+	case after == nil:
+		ev.Op = TRIGGER_DELETE
+	case before == nil:
+		ev.Op = TRIGGER_INSERT
+	default:
+		ev.Op = TRIGGER_UPDATE
+	}
+	for _, tr := range triggers {
+		if !tr.firesOn(ev.Op) {
+			continue
+		}
+		if err = tr.Action(u, ev); err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[trigger:fireTriggers] %s %s", tr.Name, err.Error()))
+		}
+	}
+	return nil
+}
+
+// AuditAction appends a row per write to the audit table: {keyColumn: a new id, "op", "key", "time" in unix
+// milliseconds, "row": the row written, or deleted, as JSON}
+func (self *SwarmDB) AuditAction(owner string, database string, auditTable string, keyColumn string) TriggerAction {
+	return func(u *SWARMDBUser, ev *TriggerEvent) (err error) {
+		tbl, err := self.GetTable(u, owner, database, auditTable)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[trigger:AuditAction] GetTable %s", err.Error()))
+		}
+		row := ev.After
+		if row == nil {
+			row = ev.Before
+		}
+		rowJSON, err := json.Marshal(row)
+		if err != nil {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[trigger:AuditAction] Marshal %s", err.Error()), ErrorCode: 435, ErrorMessage: "Invalid Row Data"}
+		}
+		return tbl.Put(u, map[string]interface{}{keyColumn: NewTraceID(), "op": ev.Op, "key": fmt.Sprintf("%v", ev.Key), "time": nowMs(), "row": string(rowJSON)})
+	}
+}
+
+// CountAction keeps, in the summary table, the number of rows per value of column: the row of the summary table
+// whose primary key is the value holds the count in its counter column
+func (self *SwarmDB) CountAction(owner string, database string, summaryTable string, column string, counter string) TriggerAction {
+	return func(u *SWARMDBUser, ev *TriggerEvent) (err error) {
+		tbl, err := self.GetTable(u, owner, database, summaryTable)
+		if err != nil {
+			return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[trigger:CountAction] GetTable %s", err.Error()))
+		}
+		var from, to interface{}
+		if ev.Before != nil {
+			from = ev.Before[column]
+		}
+		if ev.After != nil {
+			to = ev.After[column]
+		}
+		if ev.Before != nil && ev.After != nil && fmt.Sprintf("%v", from) == fmt.Sprintf("%v", to) {
+			return nil
+		}
+		if from != nil {
+			if _, err = tbl.Increment(u, from, map[string]interface{}{counter: -1}); err != nil {
+				return err
+			}
+		}
+		if to != nil {
+			if _, err = tbl.Increment(u, to, map[string]interface{}{counter: 1}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+)
+
+// Transaction buffers the writes to one table across requests (Put, Delete and Update or Insert queries) until
+// Commit flushes them with a single FlushBuffer and root hash publish, or Rollback discards them by reopening the
+// table from its last stored root hash.  The buffer belongs to the table, so writes to it from outside the
+// transaction while it is open are committed or discarded with it.
+type Transaction struct {
+	swarmdb *SwarmDB
+	table   *Table
+	done    bool
+}
+
+// Begin starts a transaction on a table; a table that is already buffered (by another transaction, an atomic batch
+// or RT_START_BUFFER) cannot join one
+func (self *SwarmDB) Begin(u *SWARMDBUser, owner string, database string, tableName string) (tx *Transaction, err error) {
+	tbl, err := self.GetTable(u, owner, database, tableName)
+	if err != nil {
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[txn:Begin] GetTable %s", err.Error()))
+	}
+	if tbl.buffered {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[txn:Begin] table %s is already buffered", self.GetTableKey(owner, database, tableName)), ErrorCode: 499, ErrorMessage: "Transaction Conflict: the table is already in a transaction"}
+	}
+	if err = tbl.StartBuffer(u); err != nil {
+		self.UnregisterTable(owner, database, tableName)
+		return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[txn:Begin] StartBuffer %s", err.Error()))
+	}
+	tbl.holdFlush = true
+	log.Debug(fmt.Sprintf("[txn:Begin] transaction on [%s]", self.GetTableKey(owner, database, tableName)), "trace", u.TraceID())
+	return &Transaction{swarmdb: self, table: tbl}, nil
+}
+
+// Table returns the owner, database and name of the table of the transaction
+func (tx *Transaction) Table() (owner string, database string, tableName string) {
+	return tx.table.Owner, tx.table.Database, tx.table.tableName
+}
+
+// Commit flushes the buffered writes; if that fails they are rolled back
+func (tx *Transaction) Commit(u *SWARMDBUser) (err error) {
+	if tx.done {
+		return &sdbc.SWARMDBError{Message: "[txn:Commit] transaction already finished", ErrorCode: 418, ErrorMessage: "Request Invalid: no transaction open"}
+	}
+	tx.done = true
+	if err = tx.table.FlushBuffer(u); err != nil {
+		tx.swarmdb.UnregisterTable(tx.Table())
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[txn:Commit] FlushBuffer %s", err.Error()))
+	}
+	tx.table.buffered, tx.table.holdFlush = false, false
+	return nil
+}
+
+// Rollback discards the buffered writes
+func (tx *Transaction) Rollback(u *SWARMDBUser) (err error) {
+	if tx.done {
+		return &sdbc.SWARMDBError{Message: "[txn:Rollback] transaction already finished", ErrorCode: 418, ErrorMessage: "Request Invalid: no transaction open"}
+	}
+	tx.done = true
+	log.Debug(fmt.Sprintf("[txn:Rollback] rolling back [%s]", tx.swarmdb.GetTableKey(tx.Table())), "trace", u.TraceID())
+	tx.swarmdb.UnregisterTable(tx.Table())
+	return nil
+}
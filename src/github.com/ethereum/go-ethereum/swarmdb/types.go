@@ -90,6 +90,10 @@ type ChunkHeader struct {
 	Owner          []byte
 	Database       []byte
 	Table          []byte
+	PrevVersion    []byte
+	Writer         []byte
+	UpdateMs       int64
+	RowSig         []byte
 	//Epochts       []byte -- Do we need this in our Chunk?
 	//Trailing Bytes
 }
@@ -117,6 +121,10 @@ func ParseChunkHeader(chunk []byte) (ch ChunkHeader, err error) {
 	ch.Owner = chunk[CHUNK_START_OWNER:CHUNK_END_OWNER]
 	ch.Database = chunk[CHUNK_START_DB:CHUNK_END_DB]
 	ch.Table = chunk[CHUNK_START_TABLE:CHUNK_END_TABLE]
+	ch.PrevVersion = chunk[CHUNK_START_PREVVERSION:CHUNK_END_PREVVERSION]
+	ch.Writer = chunk[CHUNK_START_WRITER:CHUNK_END_WRITER]
+	ch.UpdateMs = BytesToInt64(chunk[CHUNK_START_UPDATEMS:CHUNK_END_UPDATEMS])
+	ch.RowSig = chunk[CHUNK_START_ROWSIG:CHUNK_END_ROWSIG]
 	//ch.Epochts = chunk[CHUNK_START_EPOCHTS:CHUNK_END_EPOCHTS])
 	return ch, err
 }
@@ -157,6 +165,8 @@ func stringToColumnType(in string, columnType sdbc.ColumnType) (out interface{},
 		out = in
 	case sdbc.CT_FLOAT:
 		out, err = strconv.ParseFloat(in, 64)
+	case CT_UUID:
+		out, err = canonicalUUID(in)
 	//case: sdbc.CT_BLOB:
 	//?
 	default:
@@ -197,7 +207,7 @@ func CheckColumnType(colType sdbc.ColumnType) bool {
 		}
 	*/
 	ct := colType
-	if ct == sdbc.CT_INTEGER || ct == sdbc.CT_STRING || ct == sdbc.CT_FLOAT { //|| ct == sdbc.CT_BLOB {
+	if ct == sdbc.CT_INTEGER || ct == sdbc.CT_STRING || ct == sdbc.CT_FLOAT || ct == CT_UUID { //|| ct == sdbc.CT_BLOB {
 		return true
 	}
 	return false
@@ -210,8 +220,10 @@ func CheckIndexType(it sdbc.IndexType) bool {
 	return false
 }
 
+// StringToKey returns the index key of key: zero padded to K_SIZE bytes, strings and blobs keeping all theirs when
+// they are longer (see checkKeySize)
 func StringToKey(columnType sdbc.ColumnType, key string) (k []byte) {
-	k = make([]byte, 32)
+	k = make([]byte, K_SIZE)
 	switch columnType {
 	case sdbc.CT_INTEGER:
 		// convert using atoi to int
@@ -219,14 +231,18 @@ func StringToKey(columnType sdbc.ColumnType, key string) (k []byte) {
 		k8 := IntToByte(i) // 8 byte
 		copy(k, k8)        // 32 byte
 	case sdbc.CT_STRING:
-		copy(k, []byte(key))
+		k = paddedBytes([]byte(key), K_SIZE)
 	case sdbc.CT_FLOAT:
 		f, _ := strconv.ParseFloat(key, 64)
 		k8 := FloatToByte(f) // 8 byte
 		copy(k, k8)          // 32 byte
 	case sdbc.CT_BLOB:
 		// TODO: do this correctly with JSON treatment of binary
-		copy(k, []byte(key))
+		k = paddedBytes([]byte(key), K_SIZE)
+	case CT_UUID:
+		if b, err := parseUUID(key); err == nil {
+			copy(k, b)
+		}
 	}
 	return k
 }
@@ -244,6 +260,8 @@ func KeyToString(columnType sdbc.ColumnType, k []byte) (out string) {
 		bits := binary.BigEndian.Uint64(k)
 		f := math.Float64frombits(bits)
 		return fmt.Sprintf("%f", f)
+	case CT_UUID:
+		return formatUUID(k)
 	}
 	return "unknown key type"
 
@@ -291,6 +309,8 @@ func ByteToColumnType(b byte) (ct sdbc.ColumnType, err error) {
 		return sdbc.CT_FLOAT, err
 	case 4:
 		return sdbc.CT_BLOB, err
+	case 5:
+		return CT_UUID, err
 	default:
 		return sdbc.CT_INTEGER, &sdbc.SWARMDBError{Message: "Invalid Column Type", ErrorCode: 407, ErrorMessage: "Invalid Column Type"}
 	}
@@ -319,6 +339,8 @@ func ColumnTypeToInt(ct sdbc.ColumnType) (v int, err error) {
 		return 3, err
 	case sdbc.CT_BLOB:
 		return 4, err
+	case CT_UUID:
+		return 5, err
 	default:
 		return -1, &sdbc.SWARMDBError{Message: "[types|ColumnTypeToInt] columnType not found", ErrorCode: 434, ErrorMessage: fmt.Sprintf("ColumnType [%s] not SUPPORTED. Value [%s] rejected", ct, v)}
 	}
@@ -387,6 +409,9 @@ func SHA256(inp string) (k []byte) {
 
 func convertJSONValueToKey(columnType sdbc.ColumnType, pvalue interface{}) (k []byte, err error) {
 	// fmt.Printf(" *** convertJSONValueToKey: CONVERT %v (columnType %v)\n", pvalue, columnType)
+	if columnType == CT_UUID {
+		return uuidKey(pvalue)
+	}
 	switch svalue := pvalue.(type) {
 	case (int):
 		i := fmt.Sprintf("%d", svalue)
@@ -0,0 +1,206 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Usage is metered per owner for billing: bytes of row values stored, row chunks written and read, and queries
+// run.  The counters cover a billing period, from SinceMs until ExportUsage resets them, and are saved to
+// USAGE_FILE in the chunk store directory every USAGE_SAVE_INTERVAL and on Close, so a restart loses at most one
+// interval of usage.
+const (
+	USAGE_FILE          = "usage.json"
+	USAGE_SAVE_INTERVAL = 60 * time.Second
+
+	ADMIN_USAGE = "Usage" // optional Rows[0] {"owner", "reset"}; answered with an OwnerUsage row per owner
+)
+
+type OwnerUsage struct {
+	Owner         string `json:"owner"`
+	BytesStored   int64  `json:"bytesStored"`
+	ChunksWritten int64  `json:"chunksWritten"`
+	ChunksRead    int64  `json:"chunksRead"`
+	Queries       int64  `json:"queries"`
+	SinceMs       int64  `json:"sinceMs"`   // start of the billing period
+	UpdatedMs     int64  `json:"updatedMs"` // last metered request
+}
+
+func (o *OwnerUsage) Row() (row sdbc.Row) {
+	row = sdbc.NewRow()
+	row["owner"] = o.Owner
+	row["bytesStored"] = o.BytesStored
+	row["chunksWritten"] = o.ChunksWritten
+	row["chunksRead"] = o.ChunksRead
+	row["queries"] = o.Queries
+	row["sinceMs"] = o.SinceMs
+	row["updatedMs"] = o.UpdatedMs
+	return row
+}
+
+type UsageMeter struct {
+	mu     sync.Mutex
+	path   string
+	owners map[string]*OwnerUsage
+	dirty  bool // metered since the last save
+}
+
+// NewUsageMeter returns a meter saving to path, starting from the usage saved there if any
+func NewUsageMeter(path string) (m *UsageMeter, err error) {
+	m = &UsageMeter{path: path, owners: make(map[string]*OwnerUsage)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[usage:NewUsageMeter] ReadFile %s", err.Error()), ErrorCode: 461, ErrorMessage: "Unable to Load Usage"}
+	}
+	var owners []*OwnerUsage
+	if err = json.Unmarshal(data, &owners); err != nil {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[usage:NewUsageMeter] Unmarshal %s", err.Error()), ErrorCode: 461, ErrorMessage: "Unable to Load Usage"}
+	}
+	for _, o := range owners {
+		m.owners[o.Owner] = o
+	}
+	return m, nil
+}
+
+// add meters one request of owner; a nil meter meters nothing
+func (m *UsageMeter) add(owner string, bytesStored int, chunksWritten int, chunksRead int, queries int) {
+	if m == nil || len(owner) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := nowMs()
+	o, ok := m.owners[owner]
+	if !ok {
+		o = &OwnerUsage{Owner: owner, SinceMs: now}
+		m.owners[owner] = o
+	}
+	o.BytesStored += int64(bytesStored)
+	o.ChunksWritten += int64(chunksWritten)
+	o.ChunksRead += int64(chunksRead)
+	o.Queries += int64(queries)
+	o.UpdatedMs = now
+	m.dirty = true
+}
+
+// Export returns the usage of owner, or of every owner sorted by owner when owner is empty.  With reset the exported
+// counters start a new billing period, which is saved at once so that no usage is billed twice.
+func (m *UsageMeter) Export(owner string, reset bool) (usage []OwnerUsage, err error) {
+	m.mu.Lock()
+	for name, o := range m.owners {
+		if len(owner) > 0 && name != owner {
+			continue
+		}
+		usage = append(usage, *o)
+		if reset {
+			m.owners[name] = &OwnerUsage{Owner: name, SinceMs: nowMs()}
+			m.dirty = true
+		}
+	}
+	m.mu.Unlock()
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Owner < usage[j].Owner })
+	if reset {
+		err = m.Save()
+	}
+	return usage, err
+}
+
+// Save writes the usage to the meter's file, unless nothing was metered since the last save
+func (m *UsageMeter) Save() (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.dirty {
+		return nil
+	}
+	owners := make([]*OwnerUsage, 0, len(m.owners))
+	for _, o := range m.owners {
+		owners = append(owners, o)
+	}
+	sort.Slice(owners, func(i, j int) bool { return owners[i].Owner < owners[j].Owner })
+	data, err := json.MarshalIndent(owners, "", " ")
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[usage:Save] MarshalIndent %s", err.Error()), ErrorCode: 461, ErrorMessage: "Unable to Save Usage"}
+	}
+	// replace the file whole, so a crash never leaves half of it
+	tmp := m.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0600); err == nil {
+		err = os.Rename(tmp, m.path)
+	}
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[usage:Save] %s", err.Error()), ErrorCode: 461, ErrorMessage: "Unable to Save Usage"}
+	}
+	m.dirty = false
+	return nil
+}
+
+// ExportUsage returns the usage metered for owner, or for every owner when owner is empty, for billing; see
+// UsageMeter.Export
+func (self *SwarmDB) ExportUsage(owner string, reset bool) (usage []OwnerUsage, err error) {
+	return self.usage.Export(owner, reset)
+}
+
+// StartUsageSaver saves the usage every interval (USAGE_SAVE_INTERVAL if 0) until the returned stop func is called
+func (self *SwarmDB) StartUsageSaver(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = USAGE_SAVE_INTERVAL
+	}
+	quit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				if err := self.usage.Save(); err != nil {
+					log.Debug(fmt.Sprintf("[usage:StartUsageSaver] %s", err.Error()))
+				}
+			}
+		}
+	}()
+	return func() { close(quit) }
+}
+
+// exportUsage answers ADMIN_USAGE
+func (self *SwarmDB) exportUsage(d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	owner, reset := "", false
+	if len(d.Rows) > 0 {
+		owner, _ = d.Rows[0]["owner"].(string)
+		reset, _ = d.Rows[0]["reset"].(bool)
+	}
+	usage, err := self.ExportUsage(owner, reset)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[usage:exportUsage] %s", err.Error()))
+	}
+	for i := range usage {
+		resp.Data = append(resp.Data, usage[i].Row())
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
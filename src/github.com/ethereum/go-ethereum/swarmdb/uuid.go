@@ -0,0 +1,136 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"strings"
+)
+
+// A CT_UUID column holds RFC 4122 UUIDs: rows and answers carry them in the canonical form
+// "xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx", lower case, and index keys as their 16 bytes, which sort as the canonical
+// forms do.  A row put or inserted without a CT_UUID primary key gets a random (version 4) one made up by the
+// server, so that writers of a table need not agree on keys; the keys made up are answered as a {primary key} row
+// per row.  The other column types are defined in swarmdbcommon; the table descriptor holds CT_UUID as 5.
+const (
+	CT_UUID     sdbc.ColumnType = "UUID"
+	UUID_LENGTH                 = 16
+)
+
+// NewUUID returns a random (version 4) UUID in canonical form
+func NewUUID() (id string, err error) {
+	b := make([]byte, UUID_LENGTH)
+	if _, err = rand.Read(b); err != nil {
+		return "", &sdbc.SWARMDBError{Message: fmt.Sprintf("[uuid:NewUUID] rand.Read %s", err.Error()), ErrorCode: 462, ErrorMessage: "Unable to Make UUID"}
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return formatUUID(b), nil
+}
+
+// parseUUID returns the 16 bytes of the UUID s, with or without its dashes and braces, in either case
+func parseUUID(s string) (b []byte, err error) {
+	h := strings.Replace(strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}"), "-", "", -1)
+	if len(h) != 2*UUID_LENGTH {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[uuid:parseUUID] length of [%s]", s), ErrorCode: 427, ErrorMessage: fmt.Sprintf("The value [%s] is not a UUID", s)}
+	}
+	if b, err = hex.DecodeString(h); err != nil {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[uuid:parseUUID] DecodeString %s", err.Error()), ErrorCode: 427, ErrorMessage: fmt.Sprintf("The value [%s] is not a UUID", s)}
+	}
+	return b, nil
+}
+
+// formatUUID returns the canonical form of the UUID in the first 16 bytes of b
+func formatUUID(b []byte) string {
+	h := hex.EncodeToString(b[:UUID_LENGTH])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// canonicalUUID returns the UUID value in canonical form
+func canonicalUUID(value interface{}) (id string, err error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", &sdbc.SWARMDBError{Message: fmt.Sprintf("[uuid:canonicalUUID] %v", value), ErrorCode: 427, ErrorMessage: fmt.Sprintf("The value [%v] is not a UUID", value)}
+	}
+	b, err := parseUUID(s)
+	if err != nil {
+		return "", err
+	}
+	return formatUUID(b), nil
+}
+
+// uuidKey returns the index key of the UUID value
+func uuidKey(value interface{}) (k []byte, err error) {
+	id, err := canonicalUUID(value)
+	if err != nil {
+		return nil, err
+	}
+	k = make([]byte, 32)
+	b, _ := parseUUID(id)
+	copy(k, b)
+	return k, nil
+}
+
+// generateKey gives row a new UUID primary key when the primary key of t is a CT_UUID column and row has none; key
+// is the {primary key} row answering the key made up, or nil when row had a key
+func (t *Table) generateKey(row sdbc.Row) (key sdbc.Row, err error) {
+	c, ok := t.columns[t.primaryColumnName]
+	if !ok || c.columnType != CT_UUID {
+		return nil, nil
+	}
+	if v, ok := row[t.primaryColumnName]; ok && !isNil(v) {
+		return nil, nil
+	}
+	id, err := NewUUID()
+	if err != nil {
+		return nil, err
+	}
+	row[t.primaryColumnName] = id
+	key = sdbc.NewRow()
+	key[t.primaryColumnName] = id
+	return key, nil
+}
+
+// canonicalUUIDs returns row with the values of its CT_UUID columns in canonical form; row itself is left as is
+func (t *Table) canonicalUUIDs(row map[string]interface{}) (out map[string]interface{}, err error) {
+	out = row
+	copied := false
+	for name, c := range t.columns {
+		value, ok := row[name]
+		if c.columnType != CT_UUID || !ok || isNil(value) {
+			continue
+		}
+		id, err := canonicalUUID(value)
+		if err != nil {
+			return row, err
+		}
+		if id == value {
+			continue
+		}
+		if !copied {
+			out = make(map[string]interface{}, len(row))
+			for k, v := range row {
+				out[k] = v
+			}
+			copied = true
+		}
+		out[name] = id
+	}
+	return out, nil
+}
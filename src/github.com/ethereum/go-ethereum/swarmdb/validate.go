@@ -0,0 +1,120 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"encoding/json"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	wire "github.com/ethereum/go-ethereum/swarmdb/swarmdbwire"
+)
+
+// Every request parsed by parseData is validated before anything touches storage, so a malformed field is refused
+// with an INVALID_REQUEST error naming it rather than being truncated into a chunk or failing halfway through a write.
+const (
+	OWNER_NAME_LENGTH_MAX  = 64                                             // ENS names as well as hex addresses
+	COLUMN_NAME_LENGTH_MAX = 25                                             // OpenTable reads a column name from bytes [0:25] of its slot in the table descriptor
+	ROW_VALUE_SIZE_MAX     = CHUNK_END_CHUNKVAL - 40 - CHUNK_START_CHUNKVAL // storeChunkInDB keeps 40 bytes for the encryption overhead
+	BID_MAX                = 1 << 40                                        // keeps bid × replication × chunks within int64
+
+	INVALID_REQUEST = 508
+)
+
+// validName reports whether name is 1 to max letters, digits, '_', '-' or '.', not starting with '.' or '-'
+func validName(name string, max int) bool {
+	if len(name) == 0 || len(name) > max || name[0] == '.' || name[0] == '-' {
+		return false
+	}
+	for _, c := range []byte(name) {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func invalidRequest(field string, value interface{}, reason string) error {
+	return &sdbc.SWARMDBError{Message: fmt.Sprintf("[validate:validateRequest] %s [%v]: %s", field, value, reason), ErrorCode: INVALID_REQUEST, ErrorMessage: fmt.Sprintf("Invalid Request: %s %s", field, reason)}
+}
+
+// validateRequest checks the names, keys, rows and bids of d against the limits of the chunk layout
+func validateRequest(d *sdbc.RequestOption) (err error) {
+	const charset = "letters, digits, '_', '-' or '.'"
+	if len(d.Owner) > 0 && !validName(d.Owner, OWNER_NAME_LENGTH_MAX) {
+		return invalidRequest("owner", d.Owner, fmt.Sprintf("must be up to %d %s", OWNER_NAME_LENGTH_MAX, charset))
+	}
+	if len(d.Database) > 0 && !validName(d.Database, DATABASE_NAME_LENGTH_MAX) {
+		return invalidRequest("database", d.Database, fmt.Sprintf("must be up to %d %s", DATABASE_NAME_LENGTH_MAX, charset))
+	}
+	if len(d.Table) > 0 && !validName(d.Table, TABLE_NAME_LENGTH_MAX) {
+		return invalidRequest("table", d.Table, fmt.Sprintf("must be up to %d %s", TABLE_NAME_LENGTH_MAX, charset))
+	}
+	if key, ok := d.Key.(string); ok && len(key) > K_SIZE {
+		return invalidRequest("key", key, fmt.Sprintf("must be at most %d bytes", K_SIZE))
+	}
+
+	switch d.RequestType {
+	case sdbc.RT_CREATE_TABLE:
+		if len(d.Columns) > COLUMNS_PER_TABLE_MAX {
+			return invalidRequest("columns", len(d.Columns), fmt.Sprintf("must be at most %d", COLUMNS_PER_TABLE_MAX))
+		}
+		seen := make(map[string]bool)
+		for _, c := range d.Columns {
+			if !validName(c.ColumnName, COLUMN_NAME_LENGTH_MAX) {
+				return invalidRequest("column name", c.ColumnName, fmt.Sprintf("must be up to %d %s", COLUMN_NAME_LENGTH_MAX, charset))
+			}
+			if seen[c.ColumnName] {
+				return invalidRequest("column name", c.ColumnName, "is given twice")
+			}
+			seen[c.ColumnName] = true
+		}
+	case sdbc.RT_PUT:
+		for _, row := range d.Rows {
+			if len(row) > COLUMNS_PER_TABLE_MAX {
+				return invalidRequest("row", len(row), fmt.Sprintf("must have at most %d columns", COLUMNS_PER_TABLE_MAX))
+			}
+			for name := range row {
+				if !validName(name, COLUMN_NAME_LENGTH_MAX) {
+					return invalidRequest("column name", name, fmt.Sprintf("must be up to %d %s", COLUMN_NAME_LENGTH_MAX, charset))
+				}
+			}
+			value, err := json.Marshal(row)
+			if err != nil {
+				return &sdbc.SWARMDBError{Message: fmt.Sprintf("[validate:validateRequest] Marshal %s", err.Error()), ErrorCode: 435, ErrorMessage: "Invalid Row Data"}
+			}
+			if len(value) > ROW_VALUE_SIZE_MAX {
+				return invalidRequest("row", fmt.Sprintf("%d bytes", len(value)), fmt.Sprintf("must be at most %d bytes as JSON", ROW_VALUE_SIZE_MAX))
+			}
+		}
+	case wire.RT_GET_MULTI:
+		keys, _ := d.Key.([]interface{})
+		if len(keys) > MULTI_GET_KEYS_MAX {
+			return invalidRequest("key", fmt.Sprintf("%d keys", len(keys)), fmt.Sprintf("must list at most %d keys", MULTI_GET_KEYS_MAX))
+		}
+		for _, k := range keys {
+			if key, ok := k.(string); ok && len(key) > K_SIZE {
+				return invalidRequest("key", key, fmt.Sprintf("must be at most %d bytes", K_SIZE))
+			}
+		}
+	case RT_SET_BID:
+		if len(d.Rows) == 1 {
+			if bid, ok := toFloat(d.Rows[0]["bid"]); ok && (bid < 0 || bid > BID_MAX) {
+				return invalidRequest("bid", bid, fmt.Sprintf("must be between 0 and %d", int64(BID_MAX)))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,485 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"sort"
+)
+
+// Verify checks tables the way fsck checks a file system, reading only what is published under their ENS root
+// hashes: the descriptor, every node of every index, every row the primary index points to and the archived
+// versions of each row.  Problems are reported as issues rather than errors, so one damaged table does not hide
+// the state of the others.
+const (
+	ADMIN_VERIFY = "Verify" // Rows[0] {"owner", optional "database", optional "table"}; answered with a report row per table
+
+	VERIFY_ERROR   = "error"   // the table is damaged: reads may fail or miss rows
+	VERIFY_WARNING = "warning" // a secondary index entry outlived the row value it indexed, which updates leave behind
+
+	// checks an issue can come from
+	VERIFY_DESCRIPTOR   = "descriptor"   // column definitions of the descriptor
+	VERIFY_REACHABILITY = "reachability" // a chunk named by the table is missing or unreadable
+	VERIFY_INDEX        = "index"        // node types and key order of an index
+	VERIFY_PRIMARY      = "primary"      // a row against the primary index entry pointing to it
+	VERIFY_SECONDARY    = "secondary"    // secondary index entries against the rows
+	VERIFY_VERSION      = "version"      // the chain of archived versions of a row
+	VERIFY_SHARD        = "shard"        // rows of a shard against its key range
+)
+
+type VerifyIssue struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Column   string `json:"column,omitempty"`
+	Chunk    string `json:"chunk,omitempty"` // hex
+	Message  string `json:"message"`
+}
+
+type VerifyReport struct {
+	Owner    string        `json:"owner"`
+	Database string        `json:"database"`
+	Table    string        `json:"table"`
+	RootHash string        `json:"roothash"` // hex
+	Rows     int           `json:"rows"`
+	Chunks   int           `json:"chunks"`
+	Errors   int           `json:"errors"`
+	Warnings int           `json:"warnings"`
+	Issues   []VerifyIssue `json:"issues"`
+}
+
+// OK reports whether the table passed every check; warnings do not count
+func (r *VerifyReport) OK() bool {
+	return r.Errors == 0
+}
+
+func (r *VerifyReport) Row() (row sdbc.Row) {
+	out, _ := json.Marshal(r)
+	row = sdbc.NewRow()
+	json.Unmarshal(out, &row)
+	row["ok"] = r.OK()
+	return row
+}
+
+func (r *VerifyReport) issue(severity string, check string, column string, chunk []byte, format string, args ...interface{}) {
+	i := VerifyIssue{Severity: severity, Check: check, Column: column, Message: fmt.Sprintf(format, args...)}
+	if chunk != nil {
+		i.Chunk = hex.EncodeToString(chunk)
+	}
+	if severity == VERIFY_ERROR {
+		r.Errors++
+	} else {
+		r.Warnings++
+	}
+	r.Issues = append(r.Issues, i)
+}
+
+// Verify checks the tables of owner and returns a report per table: every table of every database when database
+// is empty, every table of database when tableName is empty.  Shards are checked with the table they belong to,
+// including that each holds only the keys of its range.
+func (self *SwarmDB) Verify(u *SWARMDBUser, owner string, database string, tableName string) (reports []VerifyReport, err error) {
+	var databases []string
+	if len(database) > 0 {
+		databases = []string{database}
+	} else {
+		rows, err := self.ListDatabases(u, owner)
+		if err != nil {
+			return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[verify:Verify] ListDatabases %s", err.Error()))
+		}
+		for _, row := range rows {
+			if name, ok := row["database"].(string); ok {
+				databases = append(databases, name)
+			}
+		}
+	}
+	for _, database := range databases {
+		var tables []string
+		if len(tableName) > 0 {
+			tables = []string{tableName}
+		} else {
+			rows, err := self.ListTables(u, owner, database)
+			if err != nil {
+				return nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[verify:Verify] ListTables %s", err.Error()))
+			}
+			for _, row := range rows {
+				if name, ok := row["table"].(string); ok {
+					tables = append(tables, name)
+				}
+			}
+			// tables before their shards
+			sort.Strings(tables)
+		}
+		done := make(map[string]bool)
+		for _, name := range tables {
+			if done[name] {
+				continue
+			}
+			report, splits, primaryType, err := self.verifyTable(u, owner, database, name, nil)
+			if err != nil {
+				return nil, err
+			}
+			reports = append(reports, report)
+			cmp := keyComparator(primaryType)
+			for i := 0; len(splits) > 0 && i <= len(splits); i++ {
+				var lo, hi []byte
+				if i > 0 {
+					lo = splits[i-1]
+				}
+				if i < len(splits) {
+					hi = splits[i]
+				}
+				shard := shardTableName(name, i)
+				report, _, _, err := self.verifyTable(u, owner, database, shard, &keyRange{lo: lo, hi: hi, cmp: cmp})
+				if err != nil {
+					return nil, err
+				}
+				reports = append(reports, report)
+				done[shard] = true
+			}
+		}
+	}
+	return reports, nil
+}
+
+// keyRange holds keys in [lo, hi), nil bounds being open
+type keyRange struct {
+	lo  []byte
+	hi  []byte
+	cmp Cmp
+}
+
+func (r *keyRange) contains(k []byte) bool {
+	return (r.lo == nil || r.cmp(k, r.lo) >= 0) && (r.hi == nil || r.cmp(k, r.hi) < 0)
+}
+
+// verifyColumn is a column as the descriptor defines it
+type verifyColumn struct {
+	name       string
+	primary    bool
+	columnType sdbc.ColumnType
+	indexType  sdbc.IndexType
+	roothash   []byte
+	encrypted  bool
+}
+
+// indexEntry is a key of an index and the value stored for it: the row chunk key in the primary index, the primary
+// key in a secondary index
+type indexEntry struct {
+	k []byte
+	v []byte
+}
+
+// verifier walks one table, counting each chunk once
+type verifier struct {
+	swarmdb *SwarmDB
+	u       *SWARMDBUser
+	report  *VerifyReport
+	seen    map[string]bool
+	order   []string // the columnOrder of the table, naming the cells of binary rows
+}
+
+// verifyTable checks tableName, whose primary keys must all lie in shardRange unless it is nil, and returns the
+// split keys and primary column type of its descriptor so the caller can check the shards of a sharded table.
+// err is only set when the root hash cannot be looked up; everything else is an issue of the report.
+func (self *SwarmDB) verifyTable(u *SWARMDBUser, owner string, database string, tableName string, shardRange *keyRange) (report VerifyReport, splits [][]byte, primaryType sdbc.ColumnType, err error) {
+	report = VerifyReport{Owner: owner, Database: database, Table: tableName}
+	roothash, err := self.GetRootHash(u, []byte(self.GetTableKey(owner, database, tableName)))
+	if err != nil {
+		return report, nil, primaryType, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[verify:verifyTable] GetRootHash %s", err.Error()))
+	}
+	if !valid_hashid(roothash) {
+		report.issue(VERIFY_ERROR, VERIFY_REACHABILITY, "", nil, "no root hash is published for %s", tableName)
+		return report, nil, primaryType, nil
+	}
+	report.RootHash = hex.EncodeToString(roothash)
+	v := &verifier{swarmdb: self, u: u, report: &report, seen: make(map[string]bool)}
+	descriptor, ok := v.load(roothash, "")
+	if !ok {
+		return report, nil, primaryType, nil
+	}
+	columns := v.descriptor(descriptor)
+	splits = readShardSplits(descriptor)
+
+	var primary *verifyColumn
+	var names []string
+	for i := range columns {
+		if columns[i].primary {
+			primary = &columns[i]
+		}
+		names = append(names, columns[i].name)
+	}
+	if primary == nil {
+		return report, splits, primaryType, nil
+	}
+	v.order = orderColumns(names, primary.name)
+	rows := make(map[string]sdbc.Row)
+	for _, e := range v.index(primary) {
+		row, ok := v.row(primary, e)
+		if !ok {
+			continue
+		}
+		report.Rows++
+		rows[string(padKey(e.k))] = row
+		if shardRange != nil && !shardRange.contains(padKey(e.k)) {
+			report.issue(VERIFY_ERROR, VERIFY_SHARD, primary.name, e.v, "key %s lies outside the range of the shard", printableKey(e.k))
+		}
+	}
+	for i := range columns {
+		if c := &columns[i]; !c.primary {
+			v.secondary(c, rows)
+		}
+	}
+	report.Chunks = len(v.seen)
+	log.Debug(fmt.Sprintf("[verify:verifyTable] %s: %d rows, %d chunks, %d errors, %d warnings", tableName, report.Rows, report.Chunks, report.Errors, report.Warnings), "trace", u.TraceID())
+	return report, splits, primary.columnType, nil
+}
+
+// load returns the opened chunk under key, reporting it when missing or unreadable
+func (v *verifier) load(key []byte, column string) (buf []byte, ok bool) {
+	v.seen[string(key)] = true
+	data, ok, err := v.swarmdb.dbchunkstore.RetrieveStoredChunk(key)
+	if err != nil || !ok {
+		v.report.issue(VERIFY_ERROR, VERIFY_REACHABILITY, column, key, "chunk missing from the chunk store")
+		return nil, false
+	}
+	if buf, err = v.swarmdb.dbchunkstore.openStoredChunk(v.u, data); err != nil || len(buf) < CHUNK_SIZE {
+		v.report.issue(VERIFY_ERROR, VERIFY_REACHABILITY, column, key, "chunk unreadable: %v", err)
+		return nil, false
+	}
+	return buf, true
+}
+
+// descriptor checks the column definitions of the descriptor and returns them
+func (v *verifier) descriptor(buf []byte) (columns []verifyColumn) {
+	primaries := 0
+	names := make(map[string]bool)
+	for i := 2048; i < 4000 && buf[i] != 0; i = i + 64 {
+		c := verifyColumn{name: string(bytes.Trim(buf[i:i+COLUMN_NAME_LENGTH_MAX], "\x00")), primary: buf[i+26] == 1, indexType: ByteToIndexType(buf[i+30]), encrypted: buf[i+COLUMN_ENCRYPTED_OFFSET] == 1}
+		c.roothash = make([]byte, 32)
+		copy(c.roothash, buf[i+32:i+64])
+		columnType, err := ByteToColumnType(buf[i+28])
+		if err != nil {
+			v.report.issue(VERIFY_ERROR, VERIFY_DESCRIPTOR, c.name, nil, "invalid column type %d", buf[i+28])
+		}
+		c.columnType = columnType
+		if names[c.name] {
+			v.report.issue(VERIFY_ERROR, VERIFY_DESCRIPTOR, c.name, nil, "column defined twice")
+		}
+		names[c.name] = true
+		if c.primary {
+			primaries++
+		}
+		columns = append(columns, c)
+	}
+	if primaries != 1 {
+		v.report.issue(VERIFY_ERROR, VERIFY_DESCRIPTOR, "", nil, "%d primary columns, expected 1", primaries)
+	}
+	return columns
+}
+
+// index walks the index of c and returns its entries, in key order for a B+tree
+func (v *verifier) index(c *verifyColumn) (entries []indexEntry) {
+	if !valid_hashid(c.roothash) {
+		return nil
+	}
+	switch c.indexType {
+	case sdbc.IT_BPLUSTREE:
+		var last []byte
+		v.bplus(c, c.roothash, "", nil, nil, keyComparator(c.columnType), &last, &entries)
+	case sdbc.IT_HASHTREE:
+		v.hashdb(c, c.roothash, &entries)
+	}
+	return entries
+}
+
+// bplus walks the B+tree node under key, whose keys must lie in [lo, hi) and follow last
+func (v *verifier) bplus(c *verifyColumn, key []byte, nodetype string, lo []byte, hi []byte, cmp Cmp, last *[]byte, entries *[]indexEntry) {
+	buf, ok := v.load(key, c.name)
+	if !ok {
+		return
+	}
+	actual := get_chunk_nodetype(buf)
+	if actual != "X" && actual != "D" {
+		v.report.issue(VERIFY_ERROR, VERIFY_INDEX, c.name, key, "not a B+tree node")
+		return
+	}
+	if len(nodetype) > 0 && actual != nodetype {
+		v.report.issue(VERIFY_ERROR, VERIFY_INDEX, c.name, key, "%s node where the parent expects %s nodes", actual, nodetype)
+	}
+	outside := func(k []byte) bool {
+		return (lo != nil && cmp(k, lo) < 0) || (hi != nil && cmp(k, hi) >= 0)
+	}
+	node := get_chunk_entries(buf)
+	if actual == "D" {
+		for _, e := range node {
+			k, hashid := e.k, e.v
+			if !valid_hashid(hashid) {
+				continue
+			}
+			if outside(k) {
+				v.report.issue(VERIFY_ERROR, VERIFY_INDEX, c.name, key, "key %s outside the range of its parent entry", printableKey(k))
+			}
+			if *last != nil && cmp(*last, k) >= 0 {
+				v.report.issue(VERIFY_ERROR, VERIFY_INDEX, c.name, key, "key %s out of order after %s", printableKey(k), printableKey(*last))
+			}
+			*last = k
+			*entries = append(*entries, indexEntry{k: k, v: hashid})
+		}
+		return
+	}
+
+	// an X node separates child i from child i+1 by the key of entry i; the last child has no key
+	var children [][]byte
+	var separators [][]byte
+	for _, e := range node {
+		if valid_hashid(e.v) {
+			children = append(children, e.v)
+			separators = append(separators, e.k)
+		}
+	}
+	childtype := get_chunk_childtype(buf)
+	for i, child := range children {
+		childLo, childHi := lo, hi
+		if i > 0 {
+			childLo = separators[i-1]
+		}
+		if i < len(children)-1 {
+			childHi = separators[i]
+			if outside(childHi) || (i > 0 && cmp(separators[i-1], childHi) >= 0) {
+				v.report.issue(VERIFY_ERROR, VERIFY_INDEX, c.name, key, "separator %s out of order", printableKey(childHi))
+			}
+		}
+		v.bplus(c, child, childtype, childLo, childHi, cmp, last, entries)
+	}
+}
+
+// hashdb walks the hash index node under key
+func (v *verifier) hashdb(c *verifyColumn, key []byte, entries *[]indexEntry) {
+	buf, ok := v.load(key, c.name)
+	if !ok {
+		return
+	}
+	children, values := indexNodeRefs(buf, sdbc.IT_HASHTREE)
+	for _, child := range children {
+		v.hashdb(c, child, entries)
+	}
+	if len(values) > 0 {
+		*entries = append(*entries, indexEntry{k: buf[96:128], v: values[0]})
+	}
+}
+
+// row checks the row chunk the primary index entry e points to, and the chain of its archived versions, and returns
+// the row
+func (v *verifier) row(c *verifyColumn, e indexEntry) (row sdbc.Row, ok bool) {
+	buf, ok := v.load(e.v, c.name)
+	if !ok {
+		return nil, false
+	}
+	header, err := ParseChunkHeader(buf)
+	if err != nil || string(header.NodeType) != "k" {
+		v.report.issue(VERIFY_ERROR, VERIFY_PRIMARY, c.name, e.v, "key %s points to a chunk that is not a row", printableKey(e.k))
+		return nil, false
+	}
+	// a row is stored under the hash of its owner, database, table and key, which its header repeats
+	r := v.report
+	if !bytes.Equal(header.Key, e.v) || !bytes.Equal(e.v, BuildSwarmdbPrefix([]byte(r.Owner), []byte(r.Database), []byte(r.Table), padKey(e.k))) {
+		v.report.issue(VERIFY_ERROR, VERIFY_PRIMARY, c.name, e.v, "key %s points to the row of %s/%s/%s stored under %x", printableKey(e.k), bytes.Trim(header.Database, "\x00"), bytes.Trim(header.Table, "\x00"), header.Key)
+	}
+	value, err := rowJSON(v.order, bytes.TrimRight(buf[CHUNK_START_CHUNKVAL:CHUNK_END_CHUNKVAL], "\x00"))
+	if err == nil {
+		err = json.Unmarshal(value, &row)
+	}
+	if err != nil {
+		v.report.issue(VERIFY_ERROR, VERIFY_PRIMARY, c.name, e.v, "row of key %s is not JSON or a binary row: %s", printableKey(e.k), err.Error())
+		return nil, false
+	}
+	if k, err := convertJSONValueToKey(c.columnType, row[c.name]); err != nil || !bytes.Equal(bytes.TrimRight(k, "\x00"), bytes.TrimRight(e.k, "\x00")) {
+		v.report.issue(VERIFY_ERROR, VERIFY_PRIMARY, c.name, e.v, "row of key %s holds %s %v", printableKey(e.k), c.name, row[c.name])
+	}
+
+	for version, prev := header.Version, header.PrevVersion; valid_hashid(prev) && !v.seen[string(prev)]; {
+		buf, ok := v.load(prev, c.name)
+		if !ok {
+			break
+		}
+		archived, err := ParseChunkHeader(buf)
+		if err != nil || string(archived.NodeType) != "k" {
+			v.report.issue(VERIFY_ERROR, VERIFY_VERSION, c.name, prev, "archived version of key %s is not a row", printableKey(e.k))
+			break
+		}
+		if archived.Version != version-1 {
+			v.report.issue(VERIFY_ERROR, VERIFY_VERSION, c.name, prev, "version %d of key %s follows version %d", archived.Version, printableKey(e.k), version)
+		}
+		version, prev = archived.Version, archived.PrevVersion
+	}
+	return row, true
+}
+
+// secondary checks the index of c against rows, keyed by padded primary key.  An index entry may name a row whose
+// value has since changed, or which was deleted, as neither removes the entry: that is a warning.  A row value
+// with no entry at all is an error, as lookups by that value miss the row.  Encrypted columns are not indexed.
+func (v *verifier) secondary(c *verifyColumn, rows map[string]sdbc.Row) {
+	if !valid_hashid(c.roothash) || c.encrypted || (c.indexType != sdbc.IT_BPLUSTREE && c.indexType != sdbc.IT_HASHTREE) {
+		return
+	}
+	indexed := make(map[string]bool)
+	for _, e := range v.index(c) {
+		indexed[string(padKey(bytes.TrimRight(e.k, "\x00")))] = true
+		row, ok := rows[string(padKey(e.v))]
+		if !ok {
+			v.report.issue(VERIFY_WARNING, VERIFY_SECONDARY, c.name, nil, "%s points to missing primary key %s", printableKey(e.k), printableKey(e.v))
+			continue
+		}
+		if k, err := convertJSONValueToKey(c.columnType, row[c.name]); err != nil || !bytes.Equal(bytes.TrimRight(k, "\x00"), bytes.TrimRight(e.k, "\x00")) {
+			v.report.issue(VERIFY_WARNING, VERIFY_SECONDARY, c.name, nil, "%s points to primary key %s, which holds %v", printableKey(e.k), printableKey(e.v), row[c.name])
+		}
+	}
+	for pk, row := range rows {
+		value, ok := row[c.name]
+		if !ok {
+			continue
+		}
+		if k, err := convertJSONValueToKey(c.columnType, value); err == nil && !indexed[string(padKey(bytes.TrimRight(k, "\x00")))] {
+			v.report.issue(VERIFY_ERROR, VERIFY_SECONDARY, c.name, nil, "%v of primary key %s is not indexed", value, printableKey([]byte(pk)))
+		}
+	}
+}
+
+// verify answers ADMIN_VERIFY
+func (self *SwarmDB) verify(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	if len(d.Rows) == 0 {
+		return resp, &sdbc.SWARMDBError{Message: "[verify:verify] missing owner", ErrorCode: 418, ErrorMessage: "Request Invalid: Verify requires an owner"}
+	}
+	owner, _ := d.Rows[0]["owner"].(string)
+	database, _ := d.Rows[0]["database"].(string)
+	table, _ := d.Rows[0]["table"].(string)
+	if len(owner) == 0 || (len(table) > 0 && len(database) == 0) {
+		return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[verify:verify] owner [%s] database [%s] table [%s]", owner, database, table), ErrorCode: 418, ErrorMessage: "Request Invalid: Verify requires an owner, and a database to verify a table"}
+	}
+	reports, err := self.Verify(u, owner, database, table)
+	if err != nil {
+		return resp, err
+	}
+	for i := range reports {
+		resp.Data = append(resp.Data, reports[i].Row())
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}
@@ -0,0 +1,392 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"encoding/json"
+	"fmt"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"github.com/syndtr/goleveldb/leveldb"
+	"regexp"
+)
+
+// A view is a named SELECT over a table of its database, created with RT_QUERY statements the SQL parser does not
+// know:
+//
+//	CREATE [MATERIALIZED] VIEW name AS SELECT ...
+//	REFRESH MATERIALIZED VIEW name
+//	DROP [MATERIALIZED] VIEW name
+//
+// A SELECT from a view runs the SELECT of the view and applies its own WHERE and columns to the rows it returns.  A
+// materialized view keeps those rows in a table of its name instead, whose columns are the columns selected, which
+// must include the primary key of the source table, and which is read like any other table.  REFRESH rebuilds the
+// table from the source.  With config.ChangeStream on, the table is also kept up to date from the change stream of
+// the source (see cdc.go): the changes committed since it was last refreshed are applied before every SELECT from
+// it, and by REFRESH, which then need not scan the source again.  Sharded sources keep a stream per shard and are
+// only refreshed on demand.
+//
+// Definitions are kept in the leveldb of the chunk store under VIEW_PREFIX and the table key of the view.
+const VIEW_PREFIX = "view/"
+
+// View is the definition of a view, see view.go
+type View struct {
+	Name         string `json:"name"`
+	Query        string `json:"query"` // the SELECT
+	Materialized bool   `json:"materialized,omitempty"`
+	Position     string `json:"position,omitempty"` // of the last change of the source a materialized view holds
+}
+
+var (
+	createViewStatement  = regexp.MustCompile(`(?is)^\s*create\s+(materialized\s+)?view\s+(\S+)\s+as\s+(select\s.*?)\s*;?\s*$`)
+	refreshViewStatement = regexp.MustCompile(`(?is)^\s*refresh\s+materialized\s+view\s+(\S+?)\s*;?\s*$`)
+	dropViewStatement    = regexp.MustCompile(`(?is)^\s*drop\s+(materialized\s+)?view\s+(\S+?)\s*;?\s*$`)
+)
+
+func viewKey(tableKey string) []byte {
+	return []byte(VIEW_PREFIX + tableKey)
+}
+
+// GetView returns the definition of the named view, or nil when there is none
+func (self *SwarmDB) GetView(owner string, database string, name string) (v *View, err error) {
+	data, err := self.dbchunkstore.ldb.Get(viewKey(self.GetTableKey(owner, database, name)), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:GetView] Get %s", err.Error()), ErrorCode: 462, ErrorMessage: "Unable to Read View"}
+	}
+	v = new(View)
+	if err = json.Unmarshal(data, v); err != nil {
+		return nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:GetView] Unmarshal %s", err.Error()), ErrorCode: 439, ErrorMessage: "Unable to Parse View"}
+	}
+	return v, nil
+}
+
+func (self *SwarmDB) storeView(owner string, database string, v *View) (err error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:storeView] Marshal %s", err.Error()), ErrorCode: 435, ErrorMessage: "Invalid View"}
+	}
+	if err = self.dbchunkstore.ldb.Put(viewKey(self.GetTableKey(owner, database, v.Name)), data, nil); err != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:storeView] Put %s", err.Error()), ErrorCode: 462, ErrorMessage: "Unable to Store View"}
+	}
+	return nil
+}
+
+// parseView returns the SELECT of v and the table it reads
+func (self *SwarmDB) parseView(u *SWARMDBUser, owner string, database string, v *View) (query QueryOption, src *Table, err error) {
+	if query, err = ParseQuery(v.Query); err != nil {
+		return query, nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:parseView] ParseQuery %s", err.Error()))
+	}
+	if query.Type != "Select" {
+		return query, nil, &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:parseView] %s query", query.Type), ErrorCode: 418, ErrorMessage: "Request Invalid: a view is defined by a SELECT"}
+	}
+	query.Owner, query.Database = owner, database
+	if src, err = self.GetTable(u, owner, database, query.Table); err != nil {
+		return query, nil, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:parseView] GetTable %s", err.Error()))
+	}
+	return query, src, nil
+}
+
+// CreateView defines the named view by the SELECT rawQuery; a materialized view gets its table, filled at once
+func (self *SwarmDB) CreateView(u *SWARMDBUser, owner string, database string, name string, rawQuery string, materialized bool) (err error) {
+	if err = self.checkWritable(); err != nil {
+		return err
+	}
+	if !validName(name, TABLE_NAME_LENGTH_MAX) {
+		return invalidRequest("view", name, fmt.Sprintf("must be up to %d letters, digits, '_', '-' or '.'", TABLE_NAME_LENGTH_MAX))
+	}
+	self.viewsMu.Lock()
+	defer self.viewsMu.Unlock()
+	if existing, err := self.GetView(owner, database, name); err != nil {
+		return err
+	} else if existing != nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:CreateView] view %s exists", name), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: view [%s] exists", name)}
+	}
+	if _, err := self.GetTable(u, owner, database, name); err == nil {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:CreateView] table %s exists", name), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: table [%s] exists", name)}
+	}
+	v := &View{Name: name, Query: rawQuery, Materialized: materialized}
+	query, src, err := self.parseView(u, owner, database, v)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:CreateView] parseView %s", err.Error()))
+	}
+	// the view must not show its readers what its creator may not read of the source
+	hidden, err := src.checkRead(u)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:CreateView] checkRead %s", err.Error()))
+	}
+	for _, p := range query.Where.Predicates() {
+		if hidden[p.Left] {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:CreateView] WHERE on restricted column [%s]", p.Left), ErrorCode: 490, ErrorMessage: fmt.Sprintf("Access Denied to Column [%s]", p.Left)}
+		}
+	}
+	var columns []sdbc.Column
+	primary := false
+	for _, reqCol := range query.RequestColumns {
+		c, ok := src.columns[reqCol.ColumnName]
+		if !ok {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:CreateView] Requested col [%s] does not exist in table [%s]", reqCol.ColumnName, query.Table), ErrorCode: 404, ErrorMessage: fmt.Sprintf("Column Does Not Exist in table definition: [%s]", reqCol.ColumnName)}
+		}
+		if hidden[c.columnName] {
+			return &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:CreateView] restricted column [%s]", c.columnName), ErrorCode: 490, ErrorMessage: fmt.Sprintf("Access Denied to Column [%s]", c.columnName)}
+		}
+		if c.primary > 0 {
+			primary = true
+		}
+		columns = append(columns, sdbc.Column{ColumnName: c.columnName, Primary: int(c.primary), IndexType: c.indexType, ColumnType: c.columnType})
+	}
+	if !materialized {
+		return self.storeView(owner, database, v)
+	}
+	if !primary {
+		return &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:CreateView] %s does not select %s", name, src.primaryColumnName), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: a materialized view must select the primary key [%s]", src.primaryColumnName)}
+	}
+	if _, err = self.CreateTable(u, owner, database, name, columns); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:CreateView] CreateTable %s", err.Error()))
+	}
+	if _, err = self.rebuildView(u, owner, database, v, &query, src); err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:CreateView] rebuildView %s", err.Error()))
+	}
+	return nil
+}
+
+// DropView removes the named view, and the table of a materialized one, and reports whether there was one
+func (self *SwarmDB) DropView(u *SWARMDBUser, owner string, database string, name string) (ok bool, err error) {
+	if err = self.checkWritable(); err != nil {
+		return false, err
+	}
+	self.viewsMu.Lock()
+	defer self.viewsMu.Unlock()
+	v, err := self.GetView(owner, database, name)
+	if err != nil || v == nil {
+		return false, err
+	}
+	if v.Materialized {
+		if _, err = self.DropTable(u, owner, database, name); err != nil {
+			return false, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:DropView] DropTable %s", err.Error()))
+		}
+	}
+	if err = self.dbchunkstore.ldb.Delete(viewKey(self.GetTableKey(owner, database, name)), nil); err != nil {
+		return false, &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:DropView] Delete %s", err.Error()), ErrorCode: 462, ErrorMessage: "Unable to Delete View"}
+	}
+	return true, nil
+}
+
+// RefreshView brings the table of the named materialized view up to date with its source and returns the number of
+// rows it wrote or deleted
+func (self *SwarmDB) RefreshView(u *SWARMDBUser, owner string, database string, name string) (changed int, err error) {
+	self.viewsMu.Lock()
+	defer self.viewsMu.Unlock()
+	v, err := self.GetView(owner, database, name)
+	if err != nil {
+		return 0, err
+	}
+	if v == nil || !v.Materialized {
+		return 0, &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:RefreshView] %s is not a materialized view", name), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: [%s] is not a materialized view", name)}
+	}
+	query, src, err := self.parseView(u, owner, database, v)
+	if err != nil {
+		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:RefreshView] parseView %s", err.Error()))
+	}
+	if len(v.Position) > 0 && self.changeStream != nil && !src.IsSharded() {
+		return self.applyViewChanges(u, owner, database, v, &query, src)
+	}
+	return self.rebuildView(u, owner, database, v, &query, src)
+}
+
+// catchUpView applies the changes of the source of the materialized view v it does not hold yet
+func (self *SwarmDB) catchUpView(u *SWARMDBUser, owner string, database string, v *View) (err error) {
+	if !v.Materialized || len(v.Position) == 0 || self.changeStream == nil || self.replica {
+		// replicas serve the table as the writer last refreshed it
+		return nil
+	}
+	self.viewsMu.Lock()
+	defer self.viewsMu.Unlock()
+	// reread, as another request may have applied changes since v was read
+	if v, err = self.GetView(owner, database, v.Name); err != nil || v == nil {
+		return err
+	}
+	query, src, err := self.parseView(u, owner, database, v)
+	if err != nil {
+		return sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:catchUpView] parseView %s", err.Error()))
+	}
+	if src.IsSharded() {
+		return nil
+	}
+	_, err = self.applyViewChanges(u, owner, database, v, &query, src)
+	return err
+}
+
+// rebuildView writes the rows the SELECT of v returns to its table and deletes the others
+func (self *SwarmDB) rebuildView(u *SWARMDBUser, owner string, database string, v *View, query *QueryOption, src *Table) (changed int, err error) {
+	// taken first, so the changes committed while the source is scanned are applied again rather than missed
+	position := ""
+	if self.changeStream != nil && !src.IsSharded() {
+		if position, err = src.ChangesPosition(); err != nil {
+			return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:rebuildView] ChangesPosition %s", err.Error()))
+		}
+	}
+	rows, err := self.QuerySelect(u, query)
+	if err != nil {
+		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:rebuildView] QuerySelect %s", err.Error()))
+	}
+	tbl, err := self.GetTable(u, owner, database, v.Name)
+	if err != nil {
+		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:rebuildView] GetTable %s", err.Error()))
+	}
+	old, err := self.scanTable(u, tbl, tbl.primaryColumnName, 1, 0)
+	if err != nil {
+		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:rebuildView] Scan %s", err.Error()))
+	}
+	keep := make(map[string]bool)
+	for _, row := range rows {
+		if err = tbl.Put(u, row); err != nil {
+			return changed, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:rebuildView] Put %s", err.Error()))
+		}
+		keep[fmt.Sprintf("%v", row[tbl.primaryColumnName])] = true
+		changed++
+	}
+	for _, row := range old {
+		if key := row[tbl.primaryColumnName]; !keep[fmt.Sprintf("%v", key)] {
+			if _, err = tbl.Delete(u, key); err != nil {
+				return changed, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:rebuildView] Delete %s", err.Error()))
+			}
+			changed++
+		}
+	}
+	v.Position = position
+	return changed, self.storeView(owner, database, v)
+}
+
+// applyViewChanges applies the changes of the source of v following v.Position to its table: rows the SELECT of v
+// returns after a change are written, the others deleted
+func (self *SwarmDB) applyViewChanges(u *SWARMDBUser, owner string, database string, v *View, query *QueryOption, src *Table) (changed int, err error) {
+	tbl, err := self.GetTable(u, owner, database, v.Name)
+	if err != nil {
+		return 0, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:applyViewChanges] GetTable %s", err.Error()))
+	}
+	position := v.Position
+	for {
+		changes, err := src.Changes(u, position, CHANGES_PAGE_MAX)
+		if err != nil {
+			return changed, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:applyViewChanges] Changes %s", err.Error()))
+		}
+		if len(changes) == 0 {
+			break
+		}
+		for _, c := range changes {
+			if c.After != nil {
+				matched, err := src.applyWhere([]sdbc.Row{c.After}, query.Where)
+				if err != nil {
+					return changed, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:applyViewChanges] applyWhere %s", err.Error()))
+				}
+				if len(matched) > 0 {
+					if err = tbl.Put(u, filterRowByColumns(c.After, query.RequestColumns)); err != nil {
+						return changed, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:applyViewChanges] Put %s", err.Error()))
+					}
+					changed++
+					continue
+				}
+			}
+			if c.Before != nil {
+				if _, err = tbl.Delete(u, c.Before[src.primaryColumnName]); err != nil {
+					return changed, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:applyViewChanges] Delete %s", err.Error()))
+				}
+				changed++
+			}
+		}
+		position = changes[len(changes)-1].Position
+	}
+	if position == v.Position {
+		return changed, nil
+	}
+	v.Position = position
+	return changed, self.storeView(owner, database, v)
+}
+
+// selectView answers a SELECT from the plain view v
+func (self *SwarmDB) selectView(u *SWARMDBUser, owner string, database string, v *View, query *QueryOption) (resp sdbc.SWARMDBResponse, err error) {
+	if query.Type != "Select" {
+		return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:selectView] %s on view %s", query.Type, v.Name), ErrorCode: 418, ErrorMessage: fmt.Sprintf("Request Invalid: view [%s] is read only", v.Name)}
+	}
+	viewQuery, src, err := self.parseView(u, owner, database, v)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:selectView] parseView %s", err.Error()))
+	}
+	hidden, err := src.checkRead(u)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:selectView] checkRead %s", err.Error()))
+	}
+	columns := make(map[string]bool)
+	for _, c := range viewQuery.RequestColumns {
+		columns[c.ColumnName] = true
+	}
+	for _, reqCol := range query.RequestColumns {
+		if !columns[reqCol.ColumnName] {
+			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:selectView] Requested col [%s] does not exist in view [%s]", reqCol.ColumnName, v.Name), ErrorCode: 404, ErrorMessage: fmt.Sprintf("Column Does Not Exist in table definition: [%s]", reqCol.ColumnName)}
+		}
+	}
+	for _, p := range query.Where.Predicates() {
+		if !columns[p.Left] {
+			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:selectView] Query col [%s] does not exist in view", p.Left), ErrorCode: 432, ErrorMessage: fmt.Sprintf("WHERE Clause contains invalid column [%s]", p.Left)}
+		}
+		if hidden[p.Left] {
+			return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[view:selectView] WHERE on restricted column [%s]", p.Left), ErrorCode: 490, ErrorMessage: fmt.Sprintf("Access Denied to Column [%s]", p.Left)}
+		}
+	}
+	rows, err := self.QuerySelect(u, &viewQuery)
+	if err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:selectView] QuerySelect %s", err.Error()))
+	}
+	if rows, err = src.applyWhere(rows, query.Where); err != nil {
+		return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:selectView] applyWhere %s", err.Error()))
+	}
+	for _, row := range rows {
+		if fRow := filterRowByColumns(maskRow(row, hidden), query.RequestColumns); len(fRow) > 0 {
+			resp.Data = append(resp.Data, fRow)
+		}
+	}
+	resp.AffectedRowCount = len(resp.Data)
+	return resp, nil
+}
+
+// viewStatement answers the RT_QUERY d when it is a CREATE, REFRESH or DROP VIEW statement; ok is false for others
+func (self *SwarmDB) viewStatement(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, ok bool, err error) {
+	if m := createViewStatement.FindStringSubmatch(d.RawQuery); m != nil {
+		if err = self.CreateView(u, d.Owner, d.Database, m[2], m[3], len(m[1]) > 0); err != nil {
+			return resp, true, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:viewStatement] CreateView %s", err.Error()))
+		}
+		return sdbc.SWARMDBResponse{AffectedRowCount: 1}, true, nil
+	}
+	if m := refreshViewStatement.FindStringSubmatch(d.RawQuery); m != nil {
+		changed, err := self.RefreshView(u, d.Owner, d.Database, m[1])
+		if err != nil {
+			return resp, true, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:viewStatement] RefreshView %s", err.Error()))
+		}
+		return sdbc.SWARMDBResponse{AffectedRowCount: changed}, true, nil
+	}
+	if m := dropViewStatement.FindStringSubmatch(d.RawQuery); m != nil {
+		dropped, err := self.DropView(u, d.Owner, d.Database, m[2])
+		if err != nil {
+			return resp, true, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[view:viewStatement] DropView %s", err.Error()))
+		}
+		if dropped {
+			return sdbc.SWARMDBResponse{AffectedRowCount: 1}, true, nil
+		}
+		return sdbc.SWARMDBResponse{AffectedRowCount: 0}, true, nil
+	}
+	return resp, false, nil
+}
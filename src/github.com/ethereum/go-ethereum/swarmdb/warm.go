@@ -0,0 +1,179 @@
+// Copyright (c) 2018 Wolk Inc.  All rights reserved.
+
+// The SWARMDB library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The SWARMDB library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package swarmdb
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	sdbc "github.com/ethereum/go-ethereum/swarmdb/swarmdbcommon"
+	"sync"
+)
+
+// OpenTable only reads the root node of each column index; the nodes below it are loaded from the chunk store by
+// the first lookup that passes through them (see checkload and Node.load).  After a restart every query therefore
+// starts with a cold traversal.  Warm loads the top levels of every index up front, so they are in memory before
+// the first query arrives.
+const (
+	WARM_DEPTH = 2 // levels below the root ADMIN_WARM loads when no depth is given
+
+	ADMIN_WARM = "Warm" // Owner, Database, optional Table, Rows[0] optional {"depth"}; answered with a row per table
+)
+
+// warmer is implemented by the indexes whose nodes load lazily
+type warmer interface {
+	warm(u *SWARMDBUser, depth int) (loaded int, err error)
+}
+
+// Warm loads the depth levels below the root of every column index of t, returning the number of nodes read
+func (t *Table) Warm(u *SWARMDBUser, depth int) (loaded int, err error) {
+	if t.IsSharded() {
+		var mu sync.Mutex
+		err = t.eachShard(u, func(i int, shard *Table) error {
+			n, err := shard.Warm(u, depth)
+			mu.Lock()
+			loaded += n
+			mu.Unlock()
+			return err
+		})
+		return loaded, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, name := range t.columnOrder() {
+		w, ok := t.columns[name].dbaccess.(warmer)
+		if !ok {
+			continue
+		}
+		n, err := w.warm(u, depth)
+		loaded += n
+		if err != nil {
+			return loaded, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[warm:Warm] column %s %s", name, err.Error()))
+		}
+	}
+	log.Debug(fmt.Sprintf("[warm:Warm] table [%s] depth %d loaded %d nodes", t.tableName, depth, loaded), "trace", u.TraceID())
+	return loaded, nil
+}
+
+func (t *Tree) warm(u *SWARMDBUser, depth int) (loaded int, err error) {
+	if t.r == nil {
+		return 0, nil
+	}
+	level := []interface{}{t.r}
+	for l := 0; l < depth && len(level) > 0; l++ {
+		var next []interface{}
+		for _, q := range level {
+			p, ok := q.(*x)
+			if !ok {
+				continue
+			}
+			// the child count of an X node read from a chunk is not kept, so every slot is looked at
+			for i := range p.x {
+				ch := p.x[i].ch
+				if ch == nil {
+					continue
+				}
+				if notloaded(ch) {
+					if err = checkload(u, t.swarmdb, ch); err != nil {
+						return loaded, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[warm:warm] checkload %s", err.Error()))
+					}
+					loaded++
+				}
+				next = append(next, ch)
+			}
+		}
+		level = next
+	}
+	return loaded, nil
+}
+
+func notloaded(q interface{}) bool {
+	switch z := q.(type) {
+	case *x:
+		return z.notloaded
+	case *d:
+		return z.notloaded
+	}
+	return false
+}
+
+func (self *HashDB) warm(u *SWARMDBUser, depth int) (loaded int, err error) {
+	level := []*Node{self.rootnode}
+	for l := 0; l < depth && len(level) > 0; l++ {
+		var next []*Node
+		for _, n := range level {
+			if !n.Next {
+				continue
+			}
+			for _, bin := range n.Bin {
+				if bin == nil {
+					continue
+				}
+				if !bin.Loaded {
+					if err = bin.load(u, self.swarmdb, self.columnType); err != nil {
+						return loaded, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[warm:warm] load %s", err.Error()))
+					}
+					loaded++
+				}
+				next = append(next, bin)
+			}
+		}
+		level = next
+	}
+	return loaded, nil
+}
+
+// warm answers ADMIN_WARM: the table named, or every table of the database, is opened and warmed
+func (self *SwarmDB) warm(u *SWARMDBUser, d *sdbc.RequestOption) (resp sdbc.SWARMDBResponse, err error) {
+	if len(d.Owner) == 0 || len(d.Database) == 0 {
+		return resp, &sdbc.SWARMDBError{Message: fmt.Sprintf("[warm:warm] owner [%s] database [%s]", d.Owner, d.Database), ErrorCode: 418, ErrorMessage: "Request Invalid: Warm requires an owner and a database"}
+	}
+	depth := WARM_DEPTH
+	if len(d.Rows) > 0 {
+		if f, ok := toFloat(d.Rows[0]["depth"]); ok && f >= 0 {
+			depth = int(f)
+		}
+	}
+	tables := []string{d.Table}
+	if len(d.Table) == 0 {
+		rows, err := self.ListTables(u, d.Owner, d.Database)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[warm:warm] ListTables %s", err.Error()))
+		}
+		tables = tables[:0]
+		for _, row := range rows {
+			if name, ok := row["table"].(string); ok {
+				tables = append(tables, name)
+			}
+		}
+	}
+	for _, name := range tables {
+		tbl, err := self.GetTable(u, d.Owner, d.Database, name)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[warm:warm] GetTable %s", err.Error()))
+		}
+		loaded, err := tbl.Warm(u, depth)
+		if err != nil {
+			return resp, sdbc.GenerateSWARMDBError(err, fmt.Sprintf("[warm:warm] Warm %s", err.Error()))
+		}
+		row := sdbc.NewRow()
+		row["table"] = name
+		row["depth"] = depth
+		row["nodes"] = loaded
+		resp.Data = append(resp.Data, row)
+	}
+	resp.MatchedRowCount = len(resp.Data)
+	return resp, nil
+}